@@ -0,0 +1,231 @@
+package assoc
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestLogisticTwoByTwo(t *testing.T) {
+	// A single binary covariate reproduces the odds ratio of the 2x2 table
+	// and its Woolf standard error.
+	cells := map[[2]bool]int{
+		{true, true}: 30, {true, false}: 70,
+		{false, true}: 15, {false, false}: 85,
+	}
+	var y []bool
+	var x [][]float64
+	for cell, count := range cells {
+		exposure := 0.0
+		if cell[0] {
+			exposure = 1
+		}
+		for i := 0; i < count; i++ {
+			y = append(y, cell[1])
+			x = append(x, []float64{exposure})
+		}
+	}
+
+	fit, err := Logistic(y, x, []string{"exposure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logOR := math.Log(30.0 * 85 / (70 * 15))
+	se := math.Sqrt(1.0/30 + 1.0/70 + 1.0/15 + 1.0/85)
+	if math.Abs(fit.Coef[1]-logOR) > 1e-6 || math.Abs(fit.SE[1]-se) > 1e-6 {
+		t.Errorf("Expected %f (SE %f), got %f (SE %f)", logOR, se, fit.Coef[1], fit.SE[1])
+	}
+	if intercept := math.Log(15.0 / 85); math.Abs(fit.Coef[0]-intercept) > 1e-6 {
+		t.Errorf("Expected intercept %f, got %f", intercept, fit.Coef[0])
+	}
+	if !fit.Converged || fit.N != 200 || fit.NEvents != 45 {
+		t.Errorf("Unexpected fit %+v", fit)
+	}
+}
+
+// efronLogLik is a direct transcription of the Efron partial likelihood.
+func efronLogLik(data SurvivalData, x [][]float64, beta []float64) float64 {
+	eta := func(i int) float64 {
+		out := 0.0
+		for j, v := range x[i] {
+			out += v * beta[j]
+		}
+		return out
+	}
+
+	seen := make(map[float64]bool)
+	loglik := 0.0
+	for i, t := range data.Exit {
+		if !data.Event[i] || seen[t] {
+			continue
+		}
+		seen[t] = true
+
+		riskSum, tiedSum, d := 0.0, 0.0, 0
+		for k := range data.Exit {
+			atRisk := data.Exit[k] >= t && (data.Entry == nil || data.Entry[k] < t)
+			if atRisk {
+				riskSum += math.Exp(eta(k))
+			}
+			if data.Exit[k] == t && data.Event[k] {
+				tiedSum += math.Exp(eta(k))
+				loglik += eta(k)
+				d++
+			}
+		}
+		for r := 0; r < d; r++ {
+			loglik -= math.Log(riskSum - float64(r)/float64(d)*tiedSum)
+		}
+	}
+
+	return loglik
+}
+
+func TestCoxEfron(t *testing.T) {
+	data := SurvivalData{
+		Entry: []float64{0, 0, 1, 0, 2, 0, 0, 3, 0, 0, 1, 0},
+		Exit:  []float64{5, 5, 5, 8, 8, 9, 12, 12, 12, 15, 20, 22},
+		Event: []bool{true, true, false, true, true, false, true, true, true, false, true, false},
+	}
+	x := [][]float64{
+		{1, 0.5}, {0, 1.2}, {1, -0.3}, {1, 0.8}, {0, -1.0}, {0, 0.1},
+		{1, 2.0}, {0, 0.4}, {1, -0.7}, {0, 0.9}, {0, -0.2}, {1, 0.0},
+	}
+
+	fit, err := Cox(data, x, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fit.Converged || fit.NEvents != 8 || len(fit.Schoenfeld) != 8 {
+		t.Fatalf("Unexpected fit %+v", fit)
+	}
+
+	// The estimates maximize the Efron partial likelihood, and the standard
+	// errors come from its curvature.
+	if got, expected := fit.LogLik, efronLogLik(data, x, fit.Coef); math.Abs(got-expected) > 1e-9 {
+		t.Errorf("Expected log likelihood %f, got %f", expected, got)
+	}
+	h := 1e-4
+	hessian := make([][]float64, 2)
+	for j := 0; j < 2; j++ {
+		hessian[j] = make([]float64, 2)
+		for k := 0; k < 2; k++ {
+			at := func(dj, dk float64) float64 {
+				beta := append([]float64(nil), fit.Coef...)
+				beta[j] += dj
+				beta[k] += dk
+				return efronLogLik(data, x, beta)
+			}
+			hessian[j][k] = (at(h, h) - at(h, -h) - at(-h, h) + at(-h, -h)) / (4 * h * h)
+		}
+
+		up := append([]float64(nil), fit.Coef...)
+		down := append([]float64(nil), fit.Coef...)
+		up[j] += h
+		down[j] -= h
+		if slope := (efronLogLik(data, x, up) - efronLogLik(data, x, down)) / (2 * h); math.Abs(slope) > 1e-5 {
+			t.Errorf("Coefficient %d is not at the maximum (slope %g)", j, slope)
+		}
+	}
+	det := hessian[0][0]*hessian[1][1] - hessian[0][1]*hessian[1][0]
+	expectedSE := []float64{math.Sqrt(-hessian[1][1] / det), math.Sqrt(-hessian[0][0] / det)}
+	for j := range expectedSE {
+		if math.Abs(fit.SE[j]-expectedSE[j]) > 1e-4 {
+			t.Errorf("Coefficient %d: expected SE %f, got %f", j, expectedSE[j], fit.SE[j])
+		}
+	}
+
+	// Schoenfeld residuals sum to the score, which is zero at the maximum
+	for j := 0; j < 2; j++ {
+		sum := 0.0
+		for _, r := range fit.Schoenfeld {
+			sum += r[j]
+		}
+		if math.Abs(sum) > 1e-6 {
+			t.Errorf("Schoenfeld residuals of coefficient %d sum to %g", j, sum)
+		}
+	}
+}
+
+func TestKaplanMeier(t *testing.T) {
+	km, err := KaplanMeier(SurvivalData{
+		Exit:  []float64{1, 2, 2, 3, 4, 5},
+		Event: []bool{true, true, false, true, false, true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []KMPoint{
+		{Time: 1, AtRisk: 6, Events: 1, Survival: 5.0 / 6},
+		{Time: 2, AtRisk: 5, Events: 1, Censored: 1, Survival: 5.0 / 6 * 4 / 5},
+		{Time: 3, AtRisk: 3, Events: 1, Survival: 5.0 / 6 * 4 / 5 * 2 / 3},
+		{Time: 4, AtRisk: 2, Censored: 1, Survival: 5.0 / 6 * 4 / 5 * 2 / 3},
+		{Time: 5, AtRisk: 1, Events: 1, Survival: 0},
+	}
+	if len(km) != len(expected) {
+		t.Fatalf("Expected %d points, got %d", len(expected), len(km))
+	}
+	for i, p := range km {
+		e := expected[i]
+		if p.Time != e.Time || p.AtRisk != e.AtRisk || p.Events != e.Events || p.Censored != e.Censored || math.Abs(p.Survival-e.Survival) > 1e-12 {
+			t.Errorf("Point %d: expected %+v, got %+v", i, e, p)
+		}
+	}
+
+	// Greenwood: S(3) * sqrt(1/(6*5) + 1/(5*4) + 1/(3*2))
+	if se := km[2].Survival * math.Sqrt(1.0/30+1.0/20+1.0/6); math.Abs(km[2].StdErr-se) > 1e-12 {
+		t.Errorf("Expected SE %f, got %f", se, km[2].StdErr)
+	}
+}
+
+func TestProportionalHazards(t *testing.T) {
+	simulate := func(proportional bool) *CoxFit {
+		rng := rand.New(rand.NewSource(1))
+		var data SurvivalData
+		var x [][]float64
+		for i := 0; i < 2000; i++ {
+			group := float64(i % 2)
+
+			// Exponential event times, or, for the non-proportional case,
+			// a hazard that is higher early in group 1 but higher late in
+			// group 0 (Weibull shapes 0.5 and 2).
+			var tm float64
+			switch {
+			case proportional:
+				tm = rng.ExpFloat64() / math.Exp(0.5*group)
+			case group == 1:
+				tm = math.Pow(rng.ExpFloat64(), 2)
+			default:
+				tm = math.Pow(rng.ExpFloat64(), 0.5)
+			}
+			censor := rng.Float64() * 3
+			data.Exit = append(data.Exit, math.Min(tm, censor))
+			data.Event = append(data.Event, tm <= censor)
+			x = append(x, []float64{group})
+		}
+
+		fit, err := Cox(data, x, []string{"group"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fit
+	}
+
+	ph, err := simulate(true).ProportionalHazards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ph) != 2 || ph[1].Name != "GLOBAL" || ph[0].P < 0.001 {
+		t.Errorf("Expected no evidence against proportional hazards, got %+v", ph)
+	}
+
+	nonPH, err := simulate(false).ProportionalHazards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonPH[0].P > 1e-6 || math.Abs(nonPH[0].Chisq-nonPH[1].Chisq) > 1e-9 {
+		t.Errorf("Expected strong evidence against proportional hazards, got %+v", nonPH)
+	}
+}
@@ -0,0 +1,243 @@
+package assoc
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// SurvivalData holds right-censored follow-up. If Entry is set, follow-up is
+// also left truncated: an observation is only at risk at times t with
+// Entry < t <= Exit (e.g., when age is the time scale and participants enter
+// at their age at enrollment).
+type SurvivalData struct {
+	Entry []float64
+	Exit  []float64
+	Event []bool
+}
+
+func (d SurvivalData) validate() error {
+	if len(d.Event) != len(d.Exit) || (d.Entry != nil && len(d.Entry) != len(d.Exit)) {
+		return fmt.Errorf("Entry, Exit, and Event must have the same length")
+	}
+	for i, exit := range d.Exit {
+		if math.IsNaN(exit) {
+			return fmt.Errorf("Observation %d has no exit time", i)
+		}
+		if d.Entry != nil && !(d.Entry[i] < exit) {
+			return fmt.Errorf("Observation %d exits (%v) no later than it enters (%v)", i, exit, d.Entry[i])
+		}
+	}
+
+	return nil
+}
+
+// CoxFit is a Cox proportional hazards model along with what is needed to test
+// its proportional hazards assumption.
+type CoxFit struct {
+	Fit
+
+	// The time and the Schoenfeld residuals of each event
+	EventTimes []float64
+	Schoenfeld [][]float64
+
+	data SurvivalData
+}
+
+// Cox fits a Cox proportional hazards model with Efron's approximation for
+// tied event times. x holds one row of covariates per observation.
+func Cox(data SurvivalData, x [][]float64, names []string) (*CoxFit, error) {
+	if err := data.validate(); err != nil {
+		return nil, err
+	}
+	n, p := len(data.Exit), len(names)
+	if len(x) != n {
+		return nil, fmt.Errorf("Got %d observations but %d rows of covariates", n, len(x))
+	}
+	if p == 0 {
+		return nil, fmt.Errorf("At least one covariate is required")
+	}
+
+	// Centering the covariates does not change the estimates, but keeps
+	// exp(x*beta) within range.
+	means := make([]float64, p)
+	for i, row := range x {
+		if len(row) != p {
+			return nil, fmt.Errorf("Row %d has %d covariates, expected %d", i, len(row), p)
+		}
+		for j, v := range row {
+			means[j] += v / float64(n)
+		}
+	}
+	centered := make([][]float64, n)
+	for i, row := range x {
+		centered[i] = make([]float64, p)
+		for j, v := range row {
+			centered[i][j] = v - means[j]
+		}
+	}
+
+	nEvents := 0
+	for _, event := range data.Event {
+		if event {
+			nEvents++
+		}
+	}
+	if nEvents == 0 {
+		return nil, fmt.Errorf("There are no events")
+	}
+
+	e := newEfron(data, centered)
+	fit, err := newtonRaphson(p, func(beta []float64) (float64, []float64, *mat.SymDense) {
+		return e.Eval(beta, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	fit.Names = names
+	fit.N = n
+	fit.NEvents = nEvents
+
+	out := &CoxFit{Fit: *fit, data: data}
+	e.Eval(fit.Coef, func(t float64, residual []float64) {
+		out.EventTimes = append(out.EventTimes, t)
+		out.Schoenfeld = append(out.Schoenfeld, residual)
+	})
+
+	return out, nil
+}
+
+// efron evaluates the Efron partial likelihood by sweeping backwards in time,
+// adding observations to the risk set at their exit time and removing them at
+// their entry time.
+type efron struct {
+	data    SurvivalData
+	x       [][]float64
+	byExit  []int
+	byEntry []int
+}
+
+func newEfron(data SurvivalData, x [][]float64) *efron {
+	e := &efron{data: data, x: x}
+
+	e.byExit = make([]int, len(data.Exit))
+	for i := range e.byExit {
+		e.byExit[i] = i
+	}
+	sort.SliceStable(e.byExit, func(i, j int) bool { return data.Exit[e.byExit[i]] > data.Exit[e.byExit[j]] })
+
+	if data.Entry != nil {
+		e.byEntry = append([]int(nil), e.byExit...)
+		sort.SliceStable(e.byEntry, func(i, j int) bool { return data.Entry[e.byEntry[i]] > data.Entry[e.byEntry[j]] })
+	}
+
+	return e
+}
+
+// Eval returns the log partial likelihood, its gradient, and the information
+// matrix at beta. If residual is set, it is called with the Schoenfeld
+// residual of each event.
+func (e *efron) Eval(beta []float64, residual func(t float64, r []float64)) (float64, []float64, *mat.SymDense) {
+	p := len(beta)
+	eta := func(i int) float64 {
+		out := 0.0
+		for j, v := range e.x[i] {
+			out += v * beta[j]
+		}
+		return out
+	}
+
+	loglik := 0.0
+	grad := make([]float64, p)
+	info := make([]float64, p*p)
+
+	// Sums over the risk set (s) and over the events at the current time (t)
+	s0, s1, s2 := 0.0, make([]float64, p), make([]float64, p*p)
+	t0, t1, t2 := 0.0, make([]float64, p), make([]float64, p*p)
+	accumulate := func(i int, sign float64, sum0 *float64, sum1, sum2 []float64) {
+		w := sign * math.Exp(eta(i))
+		*sum0 += w
+		for j, xj := range e.x[i] {
+			sum1[j] += w * xj
+			for k := j; k < p; k++ {
+				sum2[j*p+k] += w * xj * e.x[i][k]
+			}
+		}
+	}
+
+	a1 := make([]float64, p)
+	xbar := make([]float64, p)
+	nextEntry := 0
+	for i := 0; i < len(e.byExit); {
+		t := e.data.Exit[e.byExit[i]]
+
+		t0 = 0
+		for j := range t1 {
+			t1[j] = 0
+		}
+		for j := range t2 {
+			t2[j] = 0
+		}
+
+		var events []int
+		for ; i < len(e.byExit) && e.data.Exit[e.byExit[i]] == t; i++ {
+			obs := e.byExit[i]
+			accumulate(obs, 1, &s0, s1, s2)
+			if e.data.Event[obs] {
+				events = append(events, obs)
+				accumulate(obs, 1, &t0, t1, t2)
+			}
+		}
+
+		// Observations that enter at or after t are not at risk at t
+		for ; e.byEntry != nil && nextEntry < len(e.byEntry) && e.data.Entry[e.byEntry[nextEntry]] >= t; nextEntry++ {
+			accumulate(e.byEntry[nextEntry], -1, &s0, s1, s2)
+		}
+
+		d := len(events)
+		if d == 0 {
+			continue
+		}
+
+		for _, obs := range events {
+			loglik += eta(obs)
+			for j, v := range e.x[obs] {
+				grad[j] += v
+			}
+		}
+
+		for j := range xbar {
+			xbar[j] = 0
+		}
+		for r := 0; r < d; r++ {
+			f := float64(r) / float64(d)
+			a0 := s0 - f*t0
+			loglik -= math.Log(a0)
+			for j := 0; j < p; j++ {
+				a1[j] = s1[j] - f*t1[j]
+				grad[j] -= a1[j] / a0
+				xbar[j] += a1[j] / a0 / float64(d)
+			}
+			for j := 0; j < p; j++ {
+				for k := j; k < p; k++ {
+					a2 := s2[j*p+k] - f*t2[j*p+k]
+					info[j*p+k] += a2/a0 - a1[j]*a1[k]/(a0*a0)
+				}
+			}
+		}
+
+		if residual != nil {
+			for _, obs := range events {
+				r := make([]float64, p)
+				for j, v := range e.x[obs] {
+					r[j] = v - xbar[j]
+				}
+				residual(t, r)
+			}
+		}
+	}
+
+	return loglik, grad, mat.NewSymDense(p, info)
+}
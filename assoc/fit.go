@@ -0,0 +1,137 @@
+// Package assoc fits the models that are used to associate a score (e.g., a
+// polygenic score) with a disease: logistic regression, Cox proportional
+// hazards regression with Efron's handling of ties, the Grambsch-Therneau test
+// of proportional hazards, and Kaplan-Meier curves.
+package assoc
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+const (
+	// Models are considered converged once the relative change in the log
+	// likelihood falls below this value.
+	convergenceTolerance = 1e-9
+	maxIterations        = 30
+	maxStepHalvings      = 20
+)
+
+// Fit holds the maximum likelihood estimates of a model.
+type Fit struct {
+	Names      []string
+	Coef       []float64
+	SE         []float64
+	Covariance *mat.SymDense
+	LogLik     float64
+	Iterations int
+	Converged  bool
+
+	// Number of observations, and number of events (Cox) or cases (logistic)
+	N       int
+	NEvents int
+}
+
+// Z is the Wald statistic of the ith coefficient.
+func (f *Fit) Z(i int) float64 {
+	return f.Coef[i] / f.SE[i]
+}
+
+// P is the two-sided Wald P value of the ith coefficient.
+func (f *Fit) P(i int) float64 {
+	// Equal to 2*Phi(-|z|), without losing small P values to rounding
+	return math.Erfc(math.Abs(f.Z(i)) / math.Sqrt2)
+}
+
+// ConfInt is the Wald confidence interval of the ith coefficient at the given
+// level (e.g., 0.95).
+func (f *Fit) ConfInt(i int, level float64) (lo, hi float64) {
+	z := distuv.UnitNormal.Quantile(1 - (1-level)/2)
+	return f.Coef[i] - z*f.SE[i], f.Coef[i] + z*f.SE[i]
+}
+
+// newtonRaphson maximizes a log likelihood, halving steps that do not improve
+// it. eval returns the log likelihood, its gradient, and the information
+// matrix (the negative Hessian) at beta.
+func newtonRaphson(p int, eval func(beta []float64) (float64, []float64, *mat.SymDense)) (*Fit, error) {
+	beta := make([]float64, p)
+	loglik, grad, info := eval(beta)
+	if math.IsNaN(loglik) || math.IsInf(loglik, 0) {
+		return nil, fmt.Errorf("The log likelihood could not be evaluated at the starting values")
+	}
+
+	fit := &Fit{}
+	for fit.Iterations = 1; fit.Iterations <= maxIterations; fit.Iterations++ {
+		step, err := solve(info, grad)
+		if err != nil {
+			return nil, err
+		}
+
+		candidate := make([]float64, p)
+		var newLoglik float64
+		var newGrad []float64
+		var newInfo *mat.SymDense
+		for halving := 0; ; halving++ {
+			for i := range beta {
+				candidate[i] = beta[i] + step[i]
+			}
+			newLoglik, newGrad, newInfo = eval(candidate)
+			if !math.IsNaN(newLoglik) && newLoglik >= loglik-convergenceTolerance*math.Abs(loglik) {
+				break
+			}
+			if halving == maxStepHalvings {
+				return nil, fmt.Errorf("The log likelihood could not be improved after %d iterations", fit.Iterations)
+			}
+			for i := range step {
+				step[i] /= 2
+			}
+		}
+
+		change := math.Abs(newLoglik - loglik)
+		beta, loglik, grad, info = candidate, newLoglik, newGrad, newInfo
+		if change <= convergenceTolerance*(math.Abs(loglik)+convergenceTolerance) {
+			fit.Converged = true
+			break
+		}
+	}
+	if fit.Iterations > maxIterations {
+		fit.Iterations = maxIterations
+	}
+
+	var chol mat.Cholesky
+	if ok := chol.Factorize(info); !ok {
+		return nil, fmt.Errorf("The information matrix is singular; are the covariates collinear?")
+	}
+	covariance := mat.NewSymDense(p, nil)
+	if err := chol.InverseTo(covariance); err != nil {
+		return nil, err
+	}
+
+	fit.Coef = beta
+	fit.LogLik = loglik
+	fit.Covariance = covariance
+	fit.SE = make([]float64, p)
+	for i := range fit.SE {
+		fit.SE[i] = math.Sqrt(covariance.At(i, i))
+	}
+
+	return fit, nil
+}
+
+// solve returns the solution x of info * x = grad.
+func solve(info *mat.SymDense, grad []float64) ([]float64, error) {
+	var chol mat.Cholesky
+	if ok := chol.Factorize(info); !ok {
+		return nil, fmt.Errorf("The information matrix is singular; are the covariates collinear?")
+	}
+
+	x := mat.NewVecDense(len(grad), nil)
+	if err := chol.SolveVecTo(x, mat.NewVecDense(len(grad), append([]float64(nil), grad...))); err != nil {
+		return nil, err
+	}
+
+	return x.RawVector().Data, nil
+}
@@ -0,0 +1,75 @@
+package assoc
+
+import (
+	"math"
+	"sort"
+)
+
+// KMPoint is the Kaplan-Meier estimate just after one time at which an event
+// or censoring occurred.
+type KMPoint struct {
+	Time     float64
+	AtRisk   int
+	Events   int
+	Censored int
+	Survival float64
+
+	// Greenwood standard error of Survival
+	StdErr float64
+}
+
+// KaplanMeier estimates the survival function. Left truncation (Entry) is
+// respected when computing the number at risk.
+func KaplanMeier(data SurvivalData) ([]KMPoint, error) {
+	if err := data.validate(); err != nil {
+		return nil, err
+	}
+
+	order := make([]int, len(data.Exit))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return data.Exit[order[i]] < data.Exit[order[j]] })
+
+	var entries []float64
+	if data.Entry != nil {
+		entries = append([]float64(nil), data.Entry...)
+		sort.Float64s(entries)
+	}
+
+	var out []KMPoint
+	survival, greenwood := 1.0, 0.0
+	for i := 0; i < len(order); {
+		t := data.Exit[order[i]]
+
+		// Everyone who exits at or after t, less those who enter at or after
+		// t, is at risk at t.
+		atRisk := len(order) - i
+		if entries != nil {
+			atRisk -= len(entries) - sort.SearchFloat64s(entries, t)
+		}
+
+		point := KMPoint{Time: t, AtRisk: atRisk}
+		for ; i < len(order) && data.Exit[order[i]] == t; i++ {
+			if data.Event[order[i]] {
+				point.Events++
+			} else {
+				point.Censored++
+			}
+		}
+
+		if point.Events > 0 {
+			n, d := float64(point.AtRisk), float64(point.Events)
+			survival *= 1 - d/n
+			if n > d {
+				greenwood += d / (n * (n - d))
+			}
+		}
+		point.Survival = survival
+		point.StdErr = survival * math.Sqrt(greenwood)
+
+		out = append(out, point)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,83 @@
+package assoc
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Logistic fits a logistic regression of the binary outcome y on the
+// covariates x (one row per observation) by iteratively reweighted least
+// squares. An intercept, named "(Intercept)", is added as the first
+// coefficient.
+func Logistic(y []bool, x [][]float64, names []string) (*Fit, error) {
+	n := len(y)
+	if len(x) != n {
+		return nil, fmt.Errorf("Got %d outcomes but %d rows of covariates", n, len(x))
+	}
+	p := len(names) + 1
+
+	cases := 0
+	for i, row := range x {
+		if len(row) != p-1 {
+			return nil, fmt.Errorf("Row %d has %d covariates, expected %d", i, len(row), p-1)
+		}
+		if y[i] {
+			cases++
+		}
+	}
+	if cases == 0 || cases == n {
+		return nil, fmt.Errorf("The outcome does not vary (%d of %d are cases)", cases, n)
+	}
+
+	design := func(i, j int) float64 {
+		if j == 0 {
+			return 1
+		}
+		return x[i][j-1]
+	}
+
+	eval := func(beta []float64) (float64, []float64, *mat.SymDense) {
+		loglik := 0.0
+		grad := make([]float64, p)
+		info := make([]float64, p*p)
+		for i := 0; i < n; i++ {
+			eta := 0.0
+			for j := 0; j < p; j++ {
+				eta += design(i, j) * beta[j]
+			}
+
+			// log(1 + exp(eta)), computed without overflow
+			log1pExp := math.Log1p(math.Exp(-math.Abs(eta))) + math.Max(eta, 0)
+			mu := 1 / (1 + math.Exp(-eta))
+			residual := -mu
+			if y[i] {
+				loglik += eta
+				residual = 1 - mu
+			}
+			loglik -= log1pExp
+
+			w := mu * (1 - mu)
+			for j := 0; j < p; j++ {
+				xj := design(i, j)
+				grad[j] += xj * residual
+				for k := j; k < p; k++ {
+					info[j*p+k] += w * xj * design(i, k)
+				}
+			}
+		}
+
+		return loglik, grad, mat.NewSymDense(p, info)
+	}
+
+	fit, err := newtonRaphson(p, eval)
+	if err != nil {
+		return nil, err
+	}
+	fit.Names = append([]string{"(Intercept)"}, names...)
+	fit.N = n
+	fit.NEvents = cases
+
+	return fit, nil
+}
@@ -0,0 +1,83 @@
+package assoc
+
+import (
+	"fmt"
+	"sort"
+
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// PHTest is a test of the proportional hazards assumption of one covariate,
+// or of all covariates jointly.
+type PHTest struct {
+	Name  string
+	Chisq float64
+	DF    int
+	P     float64
+}
+
+// ProportionalHazards tests whether the scaled Schoenfeld residuals of each
+// covariate are correlated with time, transformed as 1 - KM(t) to be robust
+// to outlying event times. This is the approximation of Grambsch and Therneau
+// (1994), as in cox.zph(transform = "km") from versions of the R survival
+// package before 3.0. The last test is the global test of all covariates.
+func (c *CoxFit) ProportionalHazards() ([]PHTest, error) {
+	d := len(c.EventTimes)
+	if d < 2 {
+		return nil, fmt.Errorf("At least 2 events are needed to test proportional hazards")
+	}
+
+	km, err := KaplanMeier(c.data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The transformed time of each event, centered
+	g := make([]float64, d)
+	mean := 0.0
+	for k, t := range c.EventTimes {
+		i := sort.Search(len(km), func(i int) bool { return km[i].Time >= t })
+		g[k] = 1 - km[i].Survival
+		mean += g[k] / float64(d)
+	}
+	ss := 0.0
+	for k := range g {
+		g[k] -= mean
+		ss += g[k] * g[k]
+	}
+	if ss == 0 {
+		return nil, fmt.Errorf("All events occurred at the same time")
+	}
+
+	p := len(c.Coef)
+	u := make([]float64, p)
+	for k, residual := range c.Schoenfeld {
+		for j, v := range residual {
+			u[j] += g[k] * v
+		}
+	}
+
+	// V*u, where V is the covariance of the coefficients
+	vu := make([]float64, p)
+	for j := 0; j < p; j++ {
+		for k := 0; k < p; k++ {
+			vu[j] += c.Covariance.At(j, k) * u[k]
+		}
+	}
+
+	out := make([]PHTest, 0, p+1)
+	global := 0.0
+	for j := 0; j < p; j++ {
+		chisq := float64(d) * vu[j] * vu[j] / (c.Covariance.At(j, j) * ss)
+		out = append(out, PHTest{Name: c.Names[j], Chisq: chisq, DF: 1, P: chiSquareP(chisq, 1)})
+		global += u[j] * vu[j]
+	}
+	global *= float64(d) / ss
+	out = append(out, PHTest{Name: "GLOBAL", Chisq: global, DF: p, P: chiSquareP(global, p)})
+
+	return out, nil
+}
+
+func chiSquareP(chisq float64, df int) float64 {
+	return distuv.ChiSquared{K: float64(df)}.Survival(chisq)
+}
@@ -31,10 +31,51 @@ func (l *List) GetAdjacent(n int) []Entry {
 }
 
 func (l *List) Extrema(adjacentN, discardN int) (Result, error) {
-	out := Result{}
+	synthetic, maxOneStepShift, err := l.synthesize(adjacentN, discardN)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Note that we are sorting on the TrueMetric, not the median metric. So,
+	// while we do define a median metric, we don't record exactly where its max
+	// and min are.
+	sort.Slice(synthetic, func(i, j int) bool {
+		return synthetic[i].TrueMetric < synthetic[j].TrueMetric
+	})
+
+	max := synthetic[len(synthetic)-1]
 
+	// Scale the onestepshift value to represent a fraction of the total range
+	// starting at 0. Otherwise, people with absolutely higher values will look
+	// like they have higher onestepshifts, but from a fractional basis they
+	// might not. (e.g., 2->1 vs 5->2.5 both represent a 50% reduction, but
+	// unless scaled, the 5->2.5 will look more extreme).
+	return extremaResult(synthetic, maxOneStepShift/max.TrueMetric, adjacentN, discardN), nil
+}
+
+// SmoothedExtrema is like Extrema, but the extrema are the frames with the
+// largest and smallest median-smoothed metric, and MaxOneStepShift is the
+// absolute (unscaled) change. These are the semantics of the cardiaccycle and
+// cardiaccyclev2 commands.
+func (l *List) SmoothedExtrema(adjacentN, discardN int) (Result, error) {
+	synthetic, maxOneStepShift, err := l.synthesize(adjacentN, discardN)
+	if err != nil {
+		return Result{}, err
+	}
+
+	sort.Slice(synthetic, func(i, j int) bool {
+		return synthetic[i].Metric < synthetic[j].Metric
+	})
+
+	return extremaResult(synthetic, maxOneStepShift, adjacentN, discardN), nil
+}
+
+// synthesize computes the median-smoothed metric of each entry, along with the
+// biggest absolute change in the metric between two adjacent entries.
+func (l *List) synthesize(adjacentN, discardN int) ([]synthEntry, float64, error) {
 	synthetic := make([]synthEntry, 0, l.Len())
 
+	maxOneStepShift := 0.0
 	lastPixelArea := 0.0
 	for i := 0; i < l.Len(); i++ {
 		thisEntry := entryValue(l.Value)
@@ -42,15 +83,15 @@ func (l *List) Extrema(adjacentN, discardN int) (Result, error) {
 		adj := l.GetAdjacent(adjacentN)
 		mapped, err := discardExtremes(adj, discardN)
 		if err != nil {
-			return out, err
+			return nil, 0, err
 		}
 
 		synthEntry := synthEntry{InstanceNumber: thisEntry.InstanceNumber, Metric: median(mapped), TrueMetric: thisEntry.Metric}
 		synthetic = append(synthetic, synthEntry)
 
 		if i > 0 {
-			if x := math.Abs(thisEntry.Metric - lastPixelArea); x > out.MaxOneStepShift {
-				out.MaxOneStepShift = x
+			if x := math.Abs(thisEntry.Metric - lastPixelArea); x > maxOneStepShift {
+				maxOneStepShift = x
 			}
 		}
 		lastPixelArea = thisEntry.Metric
@@ -58,31 +99,24 @@ func (l *List) Extrema(adjacentN, discardN int) (Result, error) {
 		l.Ring = l.Next()
 	}
 
-	// Note that we are sorting on the TrueMetric, not the median metric. So,
-	// while we do define a median metric, we don't record exactly where its max
-	// and min are.
-	sort.Slice(synthetic, func(i, j int) bool {
-		return synthetic[i].TrueMetric < synthetic[j].TrueMetric
-	})
-
-	max := synthetic[len(synthetic)-1]
-	min := synthetic[0]
+	return synthetic, maxOneStepShift, nil
+}
 
-	// Scale the onestepshift value to represent a fraction of the total range
-	// starting at 0. Otherwise, people with absolutely higher values will look
-	// like they have higher onestepshifts, but from a fractional basis they
-	// might not. (e.g., 2->1 vs 5->2.5 both represent a 50% reduction, but
-	// unless scaled, the 5->2.5 will look more extreme).
-	out.MaxOneStepShift = out.MaxOneStepShift / (max.TrueMetric)
-
-	out.InstanceNumberAtMax = max.InstanceNumber
-	out.InstanceNumberAtMin = min.InstanceNumber
-	out.Max = max.TrueMetric
-	out.SmoothedMax = max.Metric
-	out.Min = min.TrueMetric
-	out.SmoothedMin = min.Metric
-	out.Discards = discardN
-	out.Window = adjacentN
-
-	return out, nil
+// extremaResult summarizes entries that have already been sorted in ascending
+// order.
+func extremaResult(sorted []synthEntry, maxOneStepShift float64, adjacentN, discardN int) Result {
+	max := sorted[len(sorted)-1]
+	min := sorted[0]
+
+	return Result{
+		MaxOneStepShift:     maxOneStepShift,
+		InstanceNumberAtMax: max.InstanceNumber,
+		InstanceNumberAtMin: min.InstanceNumber,
+		Max:                 max.TrueMetric,
+		SmoothedMax:         max.Metric,
+		Min:                 min.TrueMetric,
+		SmoothedMin:         min.Metric,
+		Discards:            discardN,
+		Window:              adjacentN,
+	}
 }
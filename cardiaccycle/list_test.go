@@ -0,0 +1,53 @@
+package cardiaccycle
+
+import (
+	"math"
+	"testing"
+)
+
+// spikeCycle has a one-frame spike to 60 at instance 3 and a broader peak up
+// to 50 at instance 8, which survives median smoothing.
+func spikeCycle() Cycle {
+	c := Cycle{Keys: []string{"spike"}}
+	for i, v := range []float64{10, 10, 60, 10, 10, 30, 40, 50, 40, 30} {
+		c.Entries = append(c.Entries, Entry{InstanceNumber: uint16(i + 1), Metric: v})
+	}
+	return c
+}
+
+func TestExtrema(t *testing.T) {
+	res, err := spikeCycle().List().Extrema(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Picked by the raw metric, with the shift scaled by the max
+	if res.InstanceNumberAtMax != 3 || res.Max != 60 {
+		t.Errorf("Max was %f at instance %d, expected the spike of 60 at instance 3", res.Max, res.InstanceNumberAtMax)
+	}
+	if math.Abs(res.MaxOneStepShift-50.0/60.0) > 1e-9 {
+		t.Errorf("MaxOneStepShift was %f, expected %f", res.MaxOneStepShift, 50.0/60.0)
+	}
+}
+
+func TestSmoothedExtrema(t *testing.T) {
+	res, err := spikeCycle().List().SmoothedExtrema(2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Picked by the smoothed metric, with the shift left unscaled. The
+	// smoothed peak is a plateau at 40 over instances 7 through 9.
+	if res.SmoothedMax != 40 || res.InstanceNumberAtMax < 7 || res.InstanceNumberAtMax > 9 {
+		t.Errorf("Smoothed max was %f at instance %d, expected 40 at instance 7, 8, or 9", res.SmoothedMax, res.InstanceNumberAtMax)
+	}
+	if res.SmoothedMin != 10 || res.Min != 10 {
+		t.Errorf("Min was %f (smoothed %f), expected 10", res.Min, res.SmoothedMin)
+	}
+	if res.MaxOneStepShift != 50 {
+		t.Errorf("MaxOneStepShift was %f, expected 50", res.MaxOneStepShift)
+	}
+	if res.Window != 2 || res.Discards != 1 {
+		t.Errorf("Window and discards were %d and %d, expected 2 and 1", res.Window, res.Discards)
+	}
+}
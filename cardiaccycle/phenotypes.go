@@ -0,0 +1,339 @@
+package cardiaccycle
+
+import (
+	"container/ring"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Cycle is one series' metric (e.g., a chamber's area or volume) over one
+// cardiac cycle.
+type Cycle struct {
+	// Keys identify the series, e.g., sample_id and series_number.
+	Keys []string
+
+	Entries []Entry
+
+	// TriggerTimes holds the trigger time (ms after the R wave) of each entry,
+	// in the same order. It is nil when trigger times are unknown, in which
+	// case rates and times cannot be computed.
+	TriggerTimes []float64
+}
+
+// Sort orders the entries (and trigger times) by instance number.
+func (c *Cycle) Sort() {
+	idx := make([]int, len(c.Entries))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return c.Entries[idx[i]].InstanceNumber < c.Entries[idx[j]].InstanceNumber })
+
+	entries := make([]Entry, len(c.Entries))
+	for i, j := range idx {
+		entries[i] = c.Entries[j]
+	}
+	c.Entries = entries
+
+	if c.TriggerTimes != nil {
+		times := make([]float64, len(c.TriggerTimes))
+		for i, j := range idx {
+			times[i] = c.TriggerTimes[j]
+		}
+		c.TriggerTimes = times
+	}
+}
+
+// List returns the entries as a ring, for use with Extrema.
+func (c Cycle) List() *List {
+	cl := &List{ring.New(len(c.Entries))}
+	for _, v := range c.Entries {
+		cl.Ring.Value = v
+		cl.Ring = cl.Next()
+	}
+
+	return cl
+}
+
+// Phenotypes are derived from the smoothed metric over one cycle. The maximum
+// is taken as end-diastole (ED) and the minimum as end-systole (ES); for the
+// atria, these are the maximum and minimum volumes, and the ejection fraction
+// is the emptying fraction. Rates are in metric units per second and times are
+// in ms. Values that cannot be computed are NaN.
+type Phenotypes struct {
+	Smoothing string
+
+	InstanceNumberAtED uint16
+	InstanceNumberAtES uint16
+	EDV                float64
+	ESV                float64
+	StrokeVolume       float64
+	EjectionFraction   float64
+
+	// CycleDuration is estimated as the last trigger time plus the average
+	// interval between frames.
+	CycleDuration float64
+	TimeToES      float64
+
+	PeakEjectionRate   float64
+	TimeToPeakEjection float64 // From ED
+	PeakFillingRate    float64
+	TimeToPeakFilling  float64 // From ES
+
+	// Diastolic filling is split at diastasis, the slowest filling between the
+	// early (E) and atrial (A) peaks. When no separate atrial peak is found
+	// (e.g., at high heart rates where the two fuse), these are NaN.
+	PeakEarlyFillingRate       float64
+	PeakAtrialFillingRate      float64
+	EARatio                    float64
+	InstanceNumberAtDiastasis  uint16
+	EarlyFillingVolume         float64
+	AtrialFillingVolume        float64
+	AtrialContributionFraction float64
+}
+
+// Phenotypes smooths the cycle and derives its functional phenotypes.
+func (c Cycle) Phenotypes(smoother Smoother) (Phenotypes, error) {
+	nan := math.NaN()
+	out := Phenotypes{
+		Smoothing:                  smoother.String(),
+		CycleDuration:              nan,
+		TimeToES:                   nan,
+		PeakEjectionRate:           nan,
+		TimeToPeakEjection:         nan,
+		PeakFillingRate:            nan,
+		TimeToPeakFilling:          nan,
+		PeakEarlyFillingRate:       nan,
+		PeakAtrialFillingRate:      nan,
+		EARatio:                    nan,
+		EarlyFillingVolume:         nan,
+		AtrialFillingVolume:        nan,
+		AtrialContributionFraction: nan,
+	}
+
+	n := len(c.Entries)
+	if n < 5 {
+		return out, fmt.Errorf("%v: need at least 5 frames to derive phenotypes, found %d", c.Keys, n)
+	}
+	if c.TriggerTimes != nil && len(c.TriggerTimes) != n {
+		return out, fmt.Errorf("%v: %d trigger times for %d frames", c.Keys, len(c.TriggerTimes), n)
+	}
+
+	values := make([]float64, n)
+	for i, v := range c.Entries {
+		values[i] = v.Metric
+	}
+
+	smoothed, err := smoother.Smooth(values)
+	if err != nil {
+		return out, err
+	}
+
+	ed, es := 0, 0
+	for i, v := range smoothed {
+		if v > smoothed[ed] {
+			ed = i
+		}
+		if v < smoothed[es] {
+			es = i
+		}
+	}
+
+	out.InstanceNumberAtED = c.Entries[ed].InstanceNumber
+	out.InstanceNumberAtES = c.Entries[es].InstanceNumber
+	out.EDV = smoothed[ed]
+	out.ESV = smoothed[es]
+	out.StrokeVolume = out.EDV - out.ESV
+	out.EjectionFraction = out.StrokeVolume / out.EDV
+
+	// Times, as frame indices if no trigger times are known, so that the
+	// phases can still be found.
+	times := c.TriggerTimes
+	haveTimes := times != nil
+	if !haveTimes {
+		times = make([]float64, n)
+		for i := range times {
+			times[i] = float64(i)
+		}
+	}
+	period := times[n-1] + (times[n-1]-times[0])/float64(n-1)
+	if period <= times[n-1] {
+		return out, fmt.Errorf("%v: trigger times do not increase with instance number", c.Keys)
+	}
+
+	// Central differences, wrapping around the cycle
+	deriv := make([]float64, n)
+	for i := range smoothed {
+		prev, next := (i+n-1)%n, (i+1)%n
+		dt := times[next] - times[prev]
+		if next < i {
+			dt += period
+		}
+		if prev > i {
+			dt += period
+		}
+		deriv[i] = (smoothed[next] - smoothed[prev]) / dt
+	}
+
+	elapsed := func(from, to int) float64 {
+		t := times[to] - times[from]
+		if t < 0 {
+			t += period
+		}
+		return t
+	}
+
+	// Systole runs from ED to ES, and diastole from ES back to ED
+	systole := cyclicRange(ed, es, n)
+	diastole := cyclicRange(es, ed, n)
+
+	per := systole[0]
+	for _, i := range systole {
+		if deriv[i] < deriv[per] {
+			per = i
+		}
+	}
+
+	pfr := diastole[0]
+	for _, i := range diastole {
+		if deriv[i] > deriv[pfr] {
+			pfr = i
+		}
+	}
+
+	if haveTimes {
+		out.CycleDuration = period
+		out.TimeToES = elapsed(ed, es)
+		out.PeakEjectionRate = -1000 * deriv[per]
+		out.TimeToPeakEjection = elapsed(ed, per)
+		out.PeakFillingRate = 1000 * deriv[pfr]
+		out.TimeToPeakFilling = elapsed(es, pfr)
+	}
+
+	// Early and atrial filling: the E peak is the fastest filling in the
+	// first half of diastole, and the A peak is the fastest filling that
+	// follows a slowdown (diastasis) after the E peak.
+	var peaks []int
+	for j := 1; j < len(diastole)-1; j++ {
+		i := diastole[j]
+		if deriv[i] > 0 && deriv[i] > deriv[diastole[j-1]] && deriv[i] >= deriv[diastole[j+1]] {
+			peaks = append(peaks, j)
+		}
+	}
+
+	half := elapsed(es, ed) / 2
+	ePeak, aPeak := -1, -1
+	for _, j := range peaks {
+		if elapsed(es, diastole[j]) <= half && (ePeak < 0 || deriv[diastole[j]] > deriv[diastole[ePeak]]) {
+			ePeak = j
+		}
+	}
+	if ePeak < 0 {
+		return out, nil
+	}
+	for _, j := range peaks {
+		if j > ePeak && elapsed(es, diastole[j]) > half && (aPeak < 0 || deriv[diastole[j]] > deriv[diastole[aPeak]]) {
+			aPeak = j
+		}
+	}
+	if aPeak < 0 {
+		return out, nil
+	}
+
+	diastasis := ePeak
+	for j := ePeak; j <= aPeak; j++ {
+		if deriv[diastole[j]] < deriv[diastole[diastasis]] {
+			diastasis = j
+		}
+	}
+
+	out.InstanceNumberAtDiastasis = c.Entries[diastole[diastasis]].InstanceNumber
+	out.EarlyFillingVolume = smoothed[diastole[diastasis]] - out.ESV
+	out.AtrialFillingVolume = out.EDV - smoothed[diastole[diastasis]]
+	out.AtrialContributionFraction = out.AtrialFillingVolume / out.StrokeVolume
+
+	if haveTimes {
+		out.PeakEarlyFillingRate = 1000 * deriv[diastole[ePeak]]
+		out.PeakAtrialFillingRate = 1000 * deriv[diastole[aPeak]]
+		out.EARatio = out.PeakEarlyFillingRate / out.PeakAtrialFillingRate
+	}
+
+	return out, nil
+}
+
+// cyclicRange lists the indices from start to end, inclusive, wrapping around
+// a cycle of length n.
+func cyclicRange(start, end, n int) []int {
+	out := []int{start}
+	for i := start; i != end; {
+		i = (i + 1) % n
+		out = append(out, i)
+	}
+
+	return out
+}
+
+// PhenotypeHeader names the columns produced by Phenotypes.Strings.
+var PhenotypeHeader = []string{
+	"smoothing",
+	"instance_number_at_ed",
+	"instance_number_at_es",
+	"edv",
+	"esv",
+	"stroke_volume",
+	"ejection_fraction",
+	"cycle_duration_ms",
+	"time_to_es_ms",
+	"peak_ejection_rate_per_s",
+	"time_to_peak_ejection_ms",
+	"peak_filling_rate_per_s",
+	"time_to_peak_filling_ms",
+	"peak_early_filling_rate_per_s",
+	"peak_atrial_filling_rate_per_s",
+	"e_a_ratio",
+	"instance_number_at_diastasis",
+	"early_filling_volume",
+	"atrial_filling_volume",
+	"atrial_contribution_fraction",
+}
+
+// Strings formats the phenotypes in the order of PhenotypeHeader, with NA for
+// values that could not be computed.
+func (p Phenotypes) Strings() []string {
+	diastasis := "NA"
+	if !math.IsNaN(p.EarlyFillingVolume) {
+		diastasis = strconv.FormatUint(uint64(p.InstanceNumberAtDiastasis), 10)
+	}
+
+	return []string{
+		p.Smoothing,
+		strconv.FormatUint(uint64(p.InstanceNumberAtED), 10),
+		strconv.FormatUint(uint64(p.InstanceNumberAtES), 10),
+		formatFloat(p.EDV),
+		formatFloat(p.ESV),
+		formatFloat(p.StrokeVolume),
+		formatFloat(p.EjectionFraction),
+		formatFloat(p.CycleDuration),
+		formatFloat(p.TimeToES),
+		formatFloat(p.PeakEjectionRate),
+		formatFloat(p.TimeToPeakEjection),
+		formatFloat(p.PeakFillingRate),
+		formatFloat(p.TimeToPeakFilling),
+		formatFloat(p.PeakEarlyFillingRate),
+		formatFloat(p.PeakAtrialFillingRate),
+		formatFloat(p.EARatio),
+		diastasis,
+		formatFloat(p.EarlyFillingVolume),
+		formatFloat(p.AtrialFillingVolume),
+		formatFloat(p.AtrialContributionFraction),
+	}
+}
+
+func formatFloat(x float64) string {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return "NA"
+	}
+
+	return strconv.FormatFloat(x, 'f', 6, 64)
+}
@@ -0,0 +1,81 @@
+package cardiaccycle
+
+import (
+	"math"
+	"testing"
+)
+
+// syntheticCycle builds a 1000ms, 50-frame cycle: ejection from 120 to 50 by
+// 360ms, early filling to 100 by 660ms, diastasis until 840ms, and atrial
+// filling back to 120.
+func syntheticCycle() Cycle {
+	c := Cycle{Keys: []string{"synthetic"}}
+
+	for i := 0; i < 50; i++ {
+		t := float64(i) * 20
+
+		var v float64
+		switch {
+		case t <= 360:
+			v = 120 - 70*(1-math.Cos(math.Pi*t/360))/2
+		case t <= 660:
+			v = 50 + 50*(1-math.Cos(math.Pi*(t-360)/300))/2
+		case t <= 840:
+			v = 100
+		default:
+			v = 100 + 20*(1-math.Cos(math.Pi*(t-840)/160))/2
+		}
+
+		c.Entries = append(c.Entries, Entry{InstanceNumber: uint16(i + 1), Metric: v})
+		c.TriggerTimes = append(c.TriggerTimes, t)
+	}
+
+	return c
+}
+
+func TestPhenotypes(t *testing.T) {
+	p, err := syntheticCycle().Phenotypes(MedianSmoother{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	within := func(name string, got, want, tolerance float64) {
+		if math.Abs(got-want) > tolerance*math.Abs(want) {
+			t.Errorf("%s: got %f, want %f", name, got, want)
+		}
+	}
+
+	within("EDV", p.EDV, 120, 1e-9)
+	within("ESV", p.ESV, 50, 1e-9)
+	within("EjectionFraction", p.EjectionFraction, 70.0/120, 1e-9)
+	within("CycleDuration", p.CycleDuration, 1000, 1e-9)
+	within("TimeToES", p.TimeToES, 360, 1e-9)
+	// Rates come from central differences over 40ms, which underestimate the
+	// true peaks slightly
+	within("PeakEjectionRate", p.PeakEjectionRate, 1000*70*math.Pi/720, 0.03)
+	within("PeakEarlyFillingRate", p.PeakEarlyFillingRate, 1000*50*math.Pi/600, 0.03)
+	within("PeakAtrialFillingRate", p.PeakAtrialFillingRate, 1000*20*math.Pi/320, 0.03)
+	within("EarlyFillingVolume", p.EarlyFillingVolume, 50, 0.01)
+	within("AtrialFillingVolume", p.AtrialFillingVolume, 20, 0.01)
+}
+
+func TestSmoothersPreserveSlowSignals(t *testing.T) {
+	values := make([]float64, 40)
+	for i := range values {
+		values[i] = 10 + 3*math.Sin(2*math.Pi*float64(i)/40)
+	}
+
+	for _, s := range []Smoother{FourierSmoother{Harmonics: 1}, SplineSmoother{Lambda: 0}} {
+		smoothed, err := s.Smooth(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range values {
+			if math.Abs(smoothed[i]-values[i]) > 1e-9 {
+				t.Errorf("%s: value %d changed from %f to %f", s, i, values[i], smoothed[i])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package cardiaccycle
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReadCycles reads a comma-delimited file with a header and, in order, nKeys
+// identifying columns, the DICOM instance_number, and a metric. If a further
+// column named trigger_time is present, it is used as the trigger times.
+// Cycles are returned sorted by their keys, with entries sorted by instance
+// number. As in a map, a later row for the same keys and instance number
+// replaces an earlier one. The name of the metric column is also returned.
+func ReadCycles(input io.Reader, nKeys int) ([]Cycle, string, error) {
+	r := csv.NewReader(input)
+	r.FieldsPerRecord = -1
+
+	instanceCol, metricCol, triggerCol := nKeys, nKeys+1, -1
+
+	var colName string
+	cycles := make(map[string]*Cycle)
+	entryIdx := make(map[string]map[uint16]int) // map[key] => map[instance_number]index into Entries
+
+	for i := 0; ; i++ {
+		line, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, colName, err
+		}
+
+		if i == 0 {
+			if len(line) < nKeys+2 {
+				return nil, colName, fmt.Errorf("Expected >= %d columns, got %d", nKeys+2, len(line))
+			}
+			colName = line[metricCol]
+			if len(line) > nKeys+2 && line[nKeys+2] == "trigger_time" {
+				triggerCol = nKeys + 2
+			}
+			continue
+		}
+
+		if len(line) < nKeys+2 || (triggerCol >= 0 && len(line) <= triggerCol) {
+			return nil, colName, fmt.Errorf("Line %d: too few columns (%d)", i+1, len(line))
+		}
+
+		inst, err := strconv.ParseUint(line[instanceCol], 10, 16)
+		if err != nil {
+			return nil, colName, err
+		}
+
+		metric, err := strconv.ParseFloat(line[metricCol], 64)
+		if err != nil {
+			return nil, colName, err
+		}
+
+		triggerTime := math.NaN()
+		if triggerCol >= 0 {
+			triggerTime, err = strconv.ParseFloat(line[triggerCol], 64)
+			if err != nil {
+				return nil, colName, fmt.Errorf("Line %d: could not parse trigger_time: %v", i+1, err)
+			}
+		}
+
+		key := strings.Join(line[:nKeys], "\t")
+		cycle, exists := cycles[key]
+		if !exists {
+			cycle = &Cycle{Keys: append([]string(nil), line[:nKeys]...)}
+			cycles[key] = cycle
+			entryIdx[key] = make(map[uint16]int)
+		}
+
+		entry := Entry{InstanceNumber: uint16(inst), Metric: metric}
+		if idx, exists := entryIdx[key][entry.InstanceNumber]; exists {
+			cycle.Entries[idx] = entry
+			if triggerCol >= 0 {
+				cycle.TriggerTimes[idx] = triggerTime
+			}
+			continue
+		}
+
+		entryIdx[key][entry.InstanceNumber] = len(cycle.Entries)
+		cycle.Entries = append(cycle.Entries, entry)
+		if triggerCol >= 0 {
+			cycle.TriggerTimes = append(cycle.TriggerTimes, triggerTime)
+		}
+	}
+
+	keys := make([]string, 0, len(cycles))
+	for key := range cycles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]Cycle, 0, len(keys))
+	for _, key := range keys {
+		cycle := cycles[key]
+		cycle.Sort()
+		out = append(out, *cycle)
+	}
+
+	return out, colName, nil
+}
+
+// ReadTriggerTimes reads the tab-delimited dicom manifest produced by
+// manifester and returns the trigger time of each instance_number, grouped by
+// the values of keyColumns joined with underscores (e.g., sample_id,
+// instance, series_number gives sampleID_instance_seriesNumber).
+func ReadTriggerTimes(input io.Reader, keyColumns []string) (map[string]map[uint16]float64, error) {
+	r := csv.NewReader(input)
+	r.Comma = '\t'
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	colIdx := make(map[string]int)
+	for i, col := range header {
+		colIdx[col] = i
+	}
+
+	var keyIdx []int
+	for _, col := range append(append([]string(nil), keyColumns...), "instance_number", "trigger_time") {
+		idx, exists := colIdx[col]
+		if !exists {
+			return nil, fmt.Errorf("Manifest has no %s column", col)
+		}
+		keyIdx = append(keyIdx, idx)
+	}
+	instanceIdx, triggerIdx := keyIdx[len(keyIdx)-2], keyIdx[len(keyIdx)-1]
+	keyIdx = keyIdx[:len(keyIdx)-2]
+
+	out := make(map[string]map[uint16]float64)
+	for line := 2; ; line++ {
+		cols, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(cols) <= instanceIdx || len(cols) <= triggerIdx {
+			return nil, fmt.Errorf("Manifest line %d: too few columns (%d)", line, len(cols))
+		}
+
+		inst, err := strconv.ParseUint(cols[instanceIdx], 10, 16)
+		if err != nil {
+			// E.g., NA
+			continue
+		}
+
+		triggerTime, err := strconv.ParseFloat(cols[triggerIdx], 64)
+		if err != nil {
+			continue
+		}
+
+		keyParts := make([]string, 0, len(keyIdx))
+		for _, idx := range keyIdx {
+			keyParts = append(keyParts, cols[idx])
+		}
+		key := strings.Join(keyParts, "_")
+
+		if out[key] == nil {
+			out[key] = make(map[uint16]float64)
+		}
+		out[key][uint16(inst)] = triggerTime
+	}
+
+	return out, nil
+}
+
+// SetTriggerTimes assigns each entry its trigger time from times, keyed by
+// instance number. It fails if any entry has no trigger time.
+func (c *Cycle) SetTriggerTimes(times map[uint16]float64) error {
+	out := make([]float64, len(c.Entries))
+	for i, v := range c.Entries {
+		t, exists := times[v.InstanceNumber]
+		if !exists || math.IsNaN(t) {
+			return fmt.Errorf("%v: no trigger time for instance number %d", c.Keys, v.InstanceNumber)
+		}
+		out[i] = t
+	}
+
+	c.TriggerTimes = out
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package cardiaccycle
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadCyclesDuplicateInstance(t *testing.T) {
+	input := strings.Join([]string{
+		"identifier,instance_number,area,trigger_time",
+		"a,2,20,40",
+		"a,1,10,0",
+		"a,2,25,45",
+		"b,1,30,0",
+	}, "\n")
+
+	cycles, colName, err := ReadCycles(strings.NewReader(input), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if colName != "area" {
+		t.Errorf("Column name was %s, expected area", colName)
+	}
+	if len(cycles) != 2 {
+		t.Fatalf("Got %d cycles, expected 2", len(cycles))
+	}
+
+	// The later row for instance 2 replaces the earlier one
+	a := cycles[0]
+	if len(a.Entries) != 2 || len(a.TriggerTimes) != 2 {
+		t.Fatalf("Cycle a had %d entries and %d trigger times, expected 2 of each", len(a.Entries), len(a.TriggerTimes))
+	}
+	if a.Entries[0].InstanceNumber != 1 || a.Entries[1].InstanceNumber != 2 {
+		t.Errorf("Cycle a had instances %d and %d, expected 1 and 2", a.Entries[0].InstanceNumber, a.Entries[1].InstanceNumber)
+	}
+	if a.Entries[1].Metric != 25 || a.TriggerTimes[1] != 45 {
+		t.Errorf("Instance 2 had metric %f at %fms, expected the last row's 25 at 45ms", a.Entries[1].Metric, a.TriggerTimes[1])
+	}
+}
@@ -1,5 +1,7 @@
 package cardiaccycle
 
+import "strconv"
+
 type Result struct {
 	Identifier          string
 	Column              string
@@ -13,3 +15,32 @@ type Result struct {
 	Window              int
 	Discards            int
 }
+
+// ResultHeader names the columns produced by Result.Strings.
+var ResultHeader = []string{
+	"max_one_step_shift",
+	"instance_number_at_min",
+	"min",
+	"smoothed_min",
+	"instance_number_at_max",
+	"max",
+	"smoothed_max",
+	"window",
+	"discards",
+}
+
+// Strings formats the extrema, without the identifier or column, in the order
+// of ResultHeader.
+func (v Result) Strings() []string {
+	return []string{
+		strconv.FormatFloat(v.MaxOneStepShift, 'f', 6, 64),
+		strconv.FormatUint(uint64(v.InstanceNumberAtMin), 10),
+		strconv.FormatFloat(v.Min, 'f', 6, 64),
+		strconv.FormatFloat(v.SmoothedMin, 'f', 6, 64),
+		strconv.FormatUint(uint64(v.InstanceNumberAtMax), 10),
+		strconv.FormatFloat(v.Max, 'f', 6, 64),
+		strconv.FormatFloat(v.SmoothedMax, 'f', 6, 64),
+		strconv.Itoa(v.Window),
+		strconv.Itoa(v.Discards),
+	}
+}
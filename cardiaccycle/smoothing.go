@@ -0,0 +1,146 @@
+package cardiaccycle
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// Smoother smooths one periodic series of metric values, ordered in time. The
+// series is treated as one full cardiac cycle, so the last value neighbors the
+// first. Values are assumed to be (roughly) evenly spaced in time.
+type Smoother interface {
+	Smooth(values []float64) ([]float64, error)
+	String() string
+}
+
+// NewSmoother returns the smoother named by method: "median" (which uses
+// window and discards), "fourier" (which uses harmonics), or "spline" (which
+// uses lambda).
+func NewSmoother(method string, window, discards, harmonics int, lambda float64) (Smoother, error) {
+	switch method {
+	case "median":
+		return MedianSmoother{Window: window, Discards: discards}, nil
+	case "fourier":
+		return FourierSmoother{Harmonics: harmonics}, nil
+	case "spline":
+		return SplineSmoother{Lambda: lambda}, nil
+	}
+
+	return nil, fmt.Errorf("Unrecognized smoothing method %q; expected median, fourier, or spline", method)
+}
+
+// MedianSmoother replaces each value with the median of the values within
+// Window steps of it, after discarding the Discards most extreme values at
+// each end. This is the smoothing used by Extrema.
+type MedianSmoother struct {
+	Window   int
+	Discards int
+}
+
+func (s MedianSmoother) Smooth(values []float64) ([]float64, error) {
+	n := len(values)
+	out := make([]float64, n)
+
+	for i := range values {
+		adj := make([]Entry, 0, 1+2*s.Window)
+		for j := -s.Window; j <= s.Window; j++ {
+			adj = append(adj, Entry{Metric: values[((i+j)%n+n)%n]})
+		}
+
+		mapped, err := discardExtremes(adj, s.Discards)
+		if err != nil {
+			return nil, err
+		}
+
+		out[i] = median(mapped)
+	}
+
+	return out, nil
+}
+
+func (s MedianSmoother) String() string {
+	return fmt.Sprintf("median(window=%d,discards=%d)", s.Window, s.Discards)
+}
+
+// FourierSmoother keeps the mean and the lowest Harmonics harmonics of the
+// series, discarding all higher frequencies.
+type FourierSmoother struct {
+	Harmonics int
+}
+
+func (s FourierSmoother) Smooth(values []float64) ([]float64, error) {
+	if s.Harmonics < 1 {
+		return nil, fmt.Errorf("Fourier smoothing needs at least 1 harmonic, got %d", s.Harmonics)
+	}
+
+	return filterPeriodic(values, func(k, n int) float64 {
+		// Frequencies above n/2 are the negative frequencies
+		if k > n/2 {
+			k = n - k
+		}
+		if k <= s.Harmonics {
+			return 1
+		}
+		return 0
+	}), nil
+}
+
+func (s FourierSmoother) String() string {
+	return fmt.Sprintf("fourier(harmonics=%d)", s.Harmonics)
+}
+
+// SplineSmoother fits a periodic cubic smoothing spline, minimizing the sum of
+// squared residuals plus Lambda times the integrated squared second
+// derivative, with time measured in steps between frames. Larger values of
+// Lambda give smoother curves; 0 interpolates.
+type SplineSmoother struct {
+	Lambda float64
+}
+
+func (s SplineSmoother) Smooth(values []float64) ([]float64, error) {
+	if s.Lambda < 0 {
+		return nil, fmt.Errorf("Spline smoothing needs a non-negative lambda, got %f", s.Lambda)
+	}
+
+	// With evenly spaced, periodic knots, the Reinsch system (I + λQR⁻¹Qᵀ)g = y
+	// is circulant, so it is solved exactly in the frequency domain. With unit
+	// spacing, QQᵀ has eigenvalues (2-2cosθ)² and R has (2+cosθ)/3.
+	return filterPeriodic(values, func(k, n int) float64 {
+		theta := 2 * math.Pi * float64(k) / float64(n)
+		q := 2 - 2*math.Cos(theta)
+		r := (2 + math.Cos(theta)) / 3
+
+		return 1 / (1 + s.Lambda*q*q/r)
+	}), nil
+}
+
+func (s SplineSmoother) String() string {
+	return fmt.Sprintf("spline(lambda=%g)", s.Lambda)
+}
+
+// filterPeriodic scales the k'th of the n discrete Fourier coefficients of
+// values by gain(k, n). The series are short, so a direct DFT suffices.
+func filterPeriodic(values []float64, gain func(k, n int) float64) []float64 {
+	n := len(values)
+
+	coefs := make([]complex128, n)
+	for k := range coefs {
+		var sum complex128
+		for t, v := range values {
+			sum += complex(v, 0) * cmplx.Exp(complex(0, -2*math.Pi*float64(k*t)/float64(n)))
+		}
+		coefs[k] = sum * complex(gain(k, n), 0)
+	}
+
+	out := make([]float64, n)
+	for t := range out {
+		var sum complex128
+		for k, c := range coefs {
+			sum += c * cmplx.Exp(complex(0, 2*math.Pi*float64(k*t)/float64(n)))
+		}
+		out[t] = real(sum) / float64(n)
+	}
+
+	return out
+}
@@ -0,0 +1,45 @@
+package cardiaccycle
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// WriteCycles writes one tab-delimited row per cycle: its keys (named by
+// keyHeader), the metric's column name, the extrema from
+// SmoothedExtrema(window, discards), and the phenotypes from the smoother.
+// Phenotypes that cannot be derived for a cycle are logged and written as NA.
+func WriteCycles(w io.Writer, keyHeader []string, colName string, cycles []Cycle, window, discards int, smoother Smoother) error {
+	header := append(append(append([]string(nil), keyHeader...), "column"), ResultHeader...)
+	header = append(header, PhenotypeHeader...)
+	if _, err := fmt.Fprintln(w, strings.Join(header, "\t")); err != nil {
+		return err
+	}
+
+	for _, cycle := range cycles {
+		res, err := cycle.List().SmoothedExtrema(window, discards)
+		if err != nil {
+			return err
+		}
+
+		phenotypeCols := make([]string, len(PhenotypeHeader))
+		if p, err := cycle.Phenotypes(smoother); err != nil {
+			log.Println(err)
+			for i := range phenotypeCols {
+				phenotypeCols[i] = "NA"
+			}
+		} else {
+			phenotypeCols = p.Strings()
+		}
+
+		row := append(append(append([]string(nil), cycle.Keys...), colName), res.Strings()...)
+		row = append(row, phenotypeCols...)
+		if _, err := fmt.Fprintln(w, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
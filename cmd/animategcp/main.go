@@ -14,6 +14,7 @@ import (
 
 	"cloud.google.com/go/storage"
 	_ "github.com/carbocation/genomisc/compileinfoprint"
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
 )
 
 const (
@@ -32,7 +33,7 @@ var client *storage.Client
 func main() {
 	defer func() { log.Println("Quitting") }()
 
-	var manifest, folder, suffix, sampleList string
+	var manifest, folder, suffix, sampleList, format string
 	var delay int
 	var grid, withTransparency bool
 	flag.StringVar(&manifest, "manifest", "", "Path to manifest file")
@@ -44,6 +45,7 @@ func main() {
 	flag.IntVar(&delay, "delay", 2, "Milliseconds between each frame of the gif.")
 	flag.BoolVar(&grid, "grid", true, "If multiple series are included, display as grid? (If false, will display sequentially)")
 	flag.BoolVar(&withTransparency, "transparency", false, "If true, the gif will reserve a color in its palette for transparency")
+	flag.StringVar(&format, "format", bulkprocess.AnimationGIF, "Animation format: 'gif' (256 colors), 'apng' (lossless animated PNG), or 'avi' (motion JPEG).")
 	flag.Parse()
 
 	if manifest == "" || folder == "" {
@@ -51,6 +53,10 @@ func main() {
 		os.Exit(1)
 	}
 
+	if _, err := bulkprocess.AnimationExtension(format); err != nil {
+		log.Fatalln(err)
+	}
+
 	folder = strings.TrimSuffix(folder, "/")
 
 	// Initialize the Google Storage client, but only if our folder indicates
@@ -64,21 +70,24 @@ func main() {
 	}
 
 	if sampleList != "" {
-		if err := runBatch(sampleList, manifest, folder, suffix, delay, grid, withTransparency); err != nil {
+		if err := runBatch(sampleList, manifest, folder, suffix, format, delay, grid, withTransparency); err != nil {
 			log.Fatalln(err)
 		}
 
 		return
 	}
 
-	if err := run(manifest, folder, suffix, delay, grid, withTransparency); err != nil {
+	if err := run(manifest, folder, suffix, format, delay, grid, withTransparency); err != nil {
 		log.Fatalln(err)
 	}
 }
 
-func runBatch(sampleList, manifest, folder, suffix string, delay int, grid bool, withTransparency bool) error {
+func runBatch(sampleList, manifest, folder, suffix, format string, delay int, grid bool, withTransparency bool) error {
 	fmt.Println("Batch mode animated Gif maker")
 
+	// The format was validated at startup.
+	extension, _ := bulkprocess.AnimationExtension(format)
+
 	man, err := parseManifest(manifest)
 	if err != nil {
 		return err
@@ -125,16 +134,16 @@ func runBatch(sampleList, manifest, folder, suffix string, delay int, grid bool,
 			pngs = append(pngs, folder+"/"+entry.dicom+suffix)
 		}
 
-		outName := key.SampleID + "_" + key.Instance + ".gif"
+		outName := key.SampleID + "_" + key.Instance + extension
 
 		errchan := make(chan error)
 
 		fmt.Printf("Fetching images for %+v %s_%s", key, key.SampleID, key.Instance)
 		go func() {
 			if grid {
-				errchan <- makeOneGrid(pngs, outName, delay, withTransparency)
+				errchan <- makeOneGrid(pngs, outName, format, delay, withTransparency)
 			} else {
-				errchan <- makeOneGif(pngs, outName, delay, withTransparency)
+				errchan <- makeOneGif(pngs, outName, format, delay, withTransparency)
 			}
 		}()
 
@@ -158,10 +167,13 @@ func runBatch(sampleList, manifest, folder, suffix string, delay int, grid bool,
 	return nil
 }
 
-func run(manifest, folder, suffix string, delay int, grid bool, withTransparency bool) error {
+func run(manifest, folder, suffix, format string, delay int, grid bool, withTransparency bool) error {
 
 	fmt.Println("Animated Gif maker")
 
+	// The format was validated at startup.
+	extension, _ := bulkprocess.AnimationExtension(format)
+
 	man, err := parseManifest(manifest)
 	if err != nil {
 		return err
@@ -221,7 +233,7 @@ func run(manifest, folder, suffix string, delay int, grid bool, withTransparency
 			pngs = append(pngs, folder+"/"+entry.dicom+suffix)
 		}
 
-		outName := key.SampleID + "_" + key.Instance + ".gif"
+		outName := key.SampleID + "_" + key.Instance + extension
 
 		errchan := make(chan error)
 
@@ -229,9 +241,9 @@ func run(manifest, folder, suffix string, delay int, grid bool, withTransparency
 		started := time.Now()
 		go func() {
 			if grid {
-				errchan <- makeOneGrid(pngs, outName, delay, withTransparency)
+				errchan <- makeOneGrid(pngs, outName, format, delay, withTransparency)
 			} else {
-				errchan <- makeOneGif(pngs, outName, delay, withTransparency)
+				errchan <- makeOneGif(pngs, outName, format, delay, withTransparency)
 			}
 		}()
 
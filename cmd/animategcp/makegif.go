@@ -2,14 +2,13 @@ package main
 
 import (
 	"image"
-	"image/gif"
 	"os"
 
 	"github.com/carbocation/genomisc/ukbb/bulkprocess"
 	"github.com/carbocation/pfx"
 )
 
-func makeOneGrid(dicomNames []string, outName string, delay int, withTransparency bool) error {
+func makeOneGrid(dicomNames []string, outName, format string, delay int, withTransparency bool) error {
 
 	// Fetch the images based on the dicom names and shove them into a map
 	sortedPngs, err := bulkprocess.FetchGIFComponents(dicomNames, client)
@@ -28,36 +27,33 @@ func makeOneGrid(dicomNames []string, outName string, delay int, withTransparenc
 		return pfx.Err(err)
 	}
 
-	// Create the GIF
-	outGif, err := bulkprocess.MakeOneGIFFromMap(newDicomNames, newImageMap, delay, withTransparency)
-	if err != nil {
-		return pfx.Err(err)
+	images := make([]image.Image, 0, len(newDicomNames))
+	for _, dicomName := range newDicomNames {
+		images = append(images, newImageMap[dicomName])
 	}
 
-	// Save file
-	f, err := os.OpenFile(outName, os.O_WRONLY|os.O_CREATE, 0600)
+	return pfx.Err(writeAnimation(images, outName, format, delay, withTransparency))
+}
+
+func makeOneGif(pngs []string, outName, format string, delay int, withTransparency bool) error {
+	sortedPngs, err := bulkprocess.FetchGIFComponents(pngs, client)
 	if err != nil {
 		return pfx.Err(err)
 	}
 
-	defer f.Close()
-
-	return pfx.Err(gif.EncodeAll(f, outGif))
+	return pfx.Err(writeAnimation(sortedPngs, outName, format, delay, withTransparency))
 }
 
-func makeOneGif(pngs []string, outName string, delay int, withTransparency bool) error {
-	outGif, err := bulkprocess.MakeOneGIFFromPaths(pngs, delay, withTransparency, client)
+func writeAnimation(images []image.Image, outName, format string, delay int, withTransparency bool) error {
+	f, err := os.Create(outName)
 	if err != nil {
-		return pfx.Err(err)
+		return err
 	}
+	defer f.Close()
 
-	// Save file
-	f, err := os.OpenFile(outName, os.O_WRONLY|os.O_CREATE, 0600)
-	if err != nil {
-		return pfx.Err(err)
+	if err := bulkprocess.EncodeAnimation(f, format, images, delay, withTransparency); err != nil {
+		return err
 	}
 
-	defer f.Close()
-
-	return pfx.Err(gif.EncodeAll(f, outGif))
+	return f.Close()
 }
@@ -39,7 +39,7 @@ var client *storage.Client
 
 func main() {
 	var includeOverlay, doNotSort, labelDicom, batch bool
-	var manifest, folder string
+	var manifest, folder, format string
 	var delay int
 	flag.StringVar(&manifest, "manifest", "", "Path to manifest file")
 	flag.StringVar(&folder, "folder", "", "Path to google storage folder that contains zip files.")
@@ -52,6 +52,7 @@ func main() {
 	flag.BoolVar(&doNotSort, "donotsort", false, "Pass this if you do not want to sort the manifest (i.e., you've already sorted it)")
 	flag.BoolVar(&labelDicom, "labeldicom", false, "Pass this if you want to print the dicom name at the top of each frame of the animated gif.")
 	flag.BoolVar(&batch, "batch", false, "Pass this if you want to run in batch mode instead of interactive mode; if so, all gifs will be created and then the program will exit.")
+	flag.StringVar(&format, "format", bulkprocess.AnimationGIF, "Animation format: 'gif' (256 colors), 'apng' (lossless animated PNG), or 'avi' (motion JPEG).")
 	flag.Parse()
 
 	if manifest == "" || folder == "" {
@@ -59,6 +60,10 @@ func main() {
 		os.Exit(1)
 	}
 
+	if _, err := bulkprocess.AnimationExtension(format); err != nil {
+		log.Fatalln(err)
+	}
+
 	folder = strings.TrimSuffix(folder, "/")
 
 	// Initialize the Google Storage client, but only if our folder indicates
@@ -74,20 +79,20 @@ func main() {
 	fmt.Println("Animated Gif maker")
 
 	if batch {
-		if err := runBatch(manifest, folder, delay, includeOverlay, doNotSort, labelDicom); err != nil {
+		if err := runBatch(manifest, folder, format, delay, includeOverlay, doNotSort, labelDicom); err != nil {
 			log.Fatalln(err)
 		}
 		return
 	}
 
-	if err := run(manifest, folder, delay, includeOverlay, doNotSort, labelDicom); err != nil {
+	if err := run(manifest, folder, format, delay, includeOverlay, doNotSort, labelDicom); err != nil {
 		log.Fatalln(err)
 	}
 
 	log.Println("Quitting")
 }
 
-func runBatch(manifest, folder string, delay int, includeOverlay, doNotSort, labelDicom bool) error {
+func runBatch(manifest, folder, format string, delay int, includeOverlay, doNotSort, labelDicom bool) error {
 	man, err := parseManifest(manifest, doNotSort)
 	if err != nil {
 		return err
@@ -102,7 +107,7 @@ func runBatch(manifest, folder string, delay int, includeOverlay, doNotSort, lab
 			continue
 		}
 
-		if err = processEntries(entries, key, folder, delay, labelDicom, includeOverlay, doNotSort, false); err != nil {
+		if err = processEntries(entries, key, folder, format, delay, labelDicom, includeOverlay, doNotSort, false); err != nil {
 			log.Println(err)
 		}
 	}
@@ -110,7 +115,7 @@ func runBatch(manifest, folder string, delay int, includeOverlay, doNotSort, lab
 	return nil
 }
 
-func run(manifest, folder string, delay int, includeOverlay, doNotSort, labelDicom bool) error {
+func run(manifest, folder, format string, delay int, includeOverlay, doNotSort, labelDicom bool) error {
 
 	man, err := parseManifest(manifest, doNotSort)
 	if err != nil {
@@ -162,7 +167,7 @@ func run(manifest, folder string, delay int, includeOverlay, doNotSort, labelDic
 			continue
 		}
 
-		if err = processEntries(entries, key, folder, delay, labelDicom, includeOverlay, doNotSort, true); err != nil {
+		if err = processEntries(entries, key, folder, format, delay, labelDicom, includeOverlay, doNotSort, true); err != nil {
 			log.Println(err)
 		}
 	}
@@ -173,7 +178,7 @@ func run(manifest, folder string, delay int, includeOverlay, doNotSort, labelDic
 // processEntries handles fetching the (possibly remote) zip file, ingesting the
 // desired images from the DICOM, computing the palatte, and emitting the .gif
 // file(s).
-func processEntries(entries []manifestEntry, key manifestKey, folder string, delay int, labelDicom, includeOverlay, doNotSort, beVerbose bool) error {
+func processEntries(entries []manifestEntry, key manifestKey, folder, format string, delay int, labelDicom, includeOverlay, doNotSort, beVerbose bool) error {
 	started := time.Now()
 	tick := time.NewTicker(1 * time.Second)
 	defer tick.Stop()
@@ -239,9 +244,11 @@ func processEntries(entries []manifestEntry, key manifestKey, folder string, del
 		}
 
 		go func(zip seriesMap, imgMap map[string]image.Image, pngs []string) {
-			outName := zip.Zip + "_" + zip.Series + ".gif"
+			// The format was validated at startup.
+			extension, _ := bulkprocess.AnimationExtension(format)
+			outName := zip.Zip + "_" + zip.Series + extension
 
-			errchan <- makeOneGifFromImageMap(pngs, imgMap, outName, delay)
+			errchan <- makeOneAnimationFromImageMap(pngs, imgMap, outName, format, delay)
 		}(zip, imgMap, pngs)
 	}
 
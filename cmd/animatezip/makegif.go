@@ -2,26 +2,27 @@ package main
 
 import (
 	"image"
-	"image/gif"
 	"os"
 
 	"github.com/carbocation/genomisc/ukbb/bulkprocess"
 )
 
-func makeOneGifFromImageMap(dicomNames []string, imgMap map[string]image.Image, outName string, delay int) error {
-
-	outGif, err := bulkprocess.MakeOneGIFFromMap(dicomNames, imgMap, delay, false)
-	if err != nil {
-		return err
+func makeOneAnimationFromImageMap(dicomNames []string, imgMap map[string]image.Image, outName, format string, delay int) error {
+	images := make([]image.Image, 0, len(dicomNames))
+	for _, dicomName := range dicomNames {
+		images = append(images, imgMap[dicomName])
 	}
 
 	// Save file
-	f, err := os.OpenFile(outName, os.O_WRONLY|os.O_CREATE, 0600)
+	f, err := os.Create(outName)
 	if err != nil {
 		return err
 	}
-
 	defer f.Close()
 
-	return gif.EncodeAll(f, outGif)
+	if err := bulkprocess.EncodeAnimation(f, format, images, delay, false); err != nil {
+		return err
+	}
+
+	return f.Close()
 }
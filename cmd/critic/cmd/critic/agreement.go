@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Agreement summarizes how often raters assign the same label to the same
+// image.
+type Agreement struct {
+	Raters []string
+	Labels []string
+
+	// Items is the number of images with at least two ratings, over which
+	// Fleiss' kappa is computed.
+	Items       int
+	FleissKappa float64
+
+	Pairs []PairAgreement
+
+	// Confusion counts, over every ordered pair of raters who rated the same
+	// image, how often rater A chose Labels[i] and rater B chose Labels[j].
+	// It is therefore symmetric.
+	Confusion [][]int
+
+	PerLabel []LabelAgreement
+}
+
+type PairAgreement struct {
+	RaterA string
+	RaterB string
+
+	// N is the number of images rated by both
+	N        int
+	Observed float64
+	Kappa    float64
+}
+
+type LabelAgreement struct {
+	Label string
+
+	// N is the number of ratings with this label on images that were rated
+	// more than once
+	N int
+
+	// SpecificAgreement is the probability that, given that one rater chose
+	// this label, another rater of the same image did too.
+	SpecificAgreement float64
+}
+
+// ComputeAgreement computes Cohen's kappa for each pair of raters, Fleiss'
+// kappa across all raters, and a per-label confusion table. ratings is keyed by
+// rater and then by image. Fleiss' kappa uses the generalization that permits
+// different numbers of raters per image. Statistics that are undefined (e.g.,
+// when only one label was ever used) are NaN.
+func ComputeAgreement(labels []Label, ratings map[string]map[int]string) Agreement {
+	out := Agreement{}
+
+	for rater := range ratings {
+		out.Raters = append(out.Raters, rater)
+	}
+	sort.Strings(out.Raters)
+
+	// Configured labels come first, in their configured order, followed by any
+	// other values that were recorded.
+	labelIndex := make(map[string]int)
+	for _, label := range labels {
+		if _, exists := labelIndex[label.Value]; !exists {
+			labelIndex[label.Value] = len(out.Labels)
+			out.Labels = append(out.Labels, label.Value)
+		}
+	}
+	var extra []string
+	for _, rater := range out.Raters {
+		for _, value := range ratings[rater] {
+			if _, exists := labelIndex[value]; !exists {
+				labelIndex[value] = -1
+				extra = append(extra, value)
+			}
+		}
+	}
+	sort.Strings(extra)
+	for _, value := range extra {
+		labelIndex[value] = len(out.Labels)
+		out.Labels = append(out.Labels, value)
+	}
+
+	nLabels := len(out.Labels)
+
+	// Pairwise Cohen's kappa
+	for a := 0; a < len(out.Raters); a++ {
+		for b := a + 1; b < len(out.Raters); b++ {
+			out.Pairs = append(out.Pairs, cohenKappa(out.Raters[a], out.Raters[b], ratings[out.Raters[a]], ratings[out.Raters[b]], labelIndex, nLabels))
+		}
+	}
+
+	// Count ratings per label for each image
+	counts := make(map[int][]int)
+	for _, rater := range out.Raters {
+		for idx, value := range ratings[rater] {
+			if counts[idx] == nil {
+				counts[idx] = make([]int, nLabels)
+			}
+			counts[idx][labelIndex[value]]++
+		}
+	}
+
+	out.Confusion = make([][]int, nLabels)
+	for i := range out.Confusion {
+		out.Confusion[i] = make([]int, nLabels)
+	}
+
+	labelTotals := make([]int, nLabels)
+	totalRatings := 0
+	sumP := 0.0
+	for _, row := range counts {
+		n := 0
+		for _, c := range row {
+			n += c
+		}
+		if n < 2 {
+			continue
+		}
+
+		out.Items++
+		totalRatings += n
+
+		agreeingPairs := 0
+		for j, c := range row {
+			labelTotals[j] += c
+			agreeingPairs += c * (c - 1)
+
+			for k, d := range row {
+				if j == k {
+					out.Confusion[j][k] += c * (c - 1)
+				} else {
+					out.Confusion[j][k] += c * d
+				}
+			}
+		}
+
+		sumP += float64(agreeingPairs) / float64(n*(n-1))
+	}
+
+	out.FleissKappa = math.NaN()
+	if out.Items > 0 {
+		pBar := sumP / float64(out.Items)
+		pE := 0.0
+		for _, total := range labelTotals {
+			p := float64(total) / float64(totalRatings)
+			pE += p * p
+		}
+		out.FleissKappa = kappa(pBar, pE)
+	}
+
+	for j, label := range out.Labels {
+		rowSum := 0
+		for _, c := range out.Confusion[j] {
+			rowSum += c
+		}
+
+		la := LabelAgreement{Label: label, N: labelTotals[j], SpecificAgreement: math.NaN()}
+		if rowSum > 0 {
+			la.SpecificAgreement = float64(out.Confusion[j][j]) / float64(rowSum)
+		}
+		out.PerLabel = append(out.PerLabel, la)
+	}
+
+	return out
+}
+
+func cohenKappa(raterA, raterB string, a, b map[int]string, labelIndex map[string]int, nLabels int) PairAgreement {
+	out := PairAgreement{RaterA: raterA, RaterB: raterB, Observed: math.NaN(), Kappa: math.NaN()}
+
+	marginA := make([]int, nLabels)
+	marginB := make([]int, nLabels)
+	agree := 0
+	for idx, va := range a {
+		vb, exists := b[idx]
+		if !exists {
+			continue
+		}
+
+		out.N++
+		marginA[labelIndex[va]]++
+		marginB[labelIndex[vb]]++
+		if va == vb {
+			agree++
+		}
+	}
+
+	if out.N == 0 {
+		return out
+	}
+
+	out.Observed = float64(agree) / float64(out.N)
+
+	pE := 0.0
+	for j := range marginA {
+		pE += float64(marginA[j]) / float64(out.N) * float64(marginB[j]) / float64(out.N)
+	}
+	out.Kappa = kappa(out.Observed, pE)
+
+	return out
+}
+
+func kappa(observed, expected float64) float64 {
+	if expected >= 1 {
+		return math.NaN()
+	}
+
+	return (observed - expected) / (1 - expected)
+}
+
+// WriteTSV writes the agreement statistics as one long table, with one
+// statistic per row.
+func (a Agreement) WriteTSV(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "statistic\trater_a\trater_b\tlabel_a\tlabel_b\tn\tvalue"); err != nil {
+		return err
+	}
+
+	row := func(statistic, raterA, raterB, labelA, labelB string, n int, value string) error {
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", statistic, raterA, raterB, labelA, labelB, n, value)
+		return err
+	}
+
+	if err := row("fleiss_kappa", "", "", "", "", a.Items, formatStatistic(a.FleissKappa)); err != nil {
+		return err
+	}
+
+	for _, pair := range a.Pairs {
+		if err := row("observed_agreement", pair.RaterA, pair.RaterB, "", "", pair.N, formatStatistic(pair.Observed)); err != nil {
+			return err
+		}
+		if err := row("cohen_kappa", pair.RaterA, pair.RaterB, "", "", pair.N, formatStatistic(pair.Kappa)); err != nil {
+			return err
+		}
+	}
+
+	for _, label := range a.PerLabel {
+		if err := row("specific_agreement", "", "", label.Label, "", label.N, formatStatistic(label.SpecificAgreement)); err != nil {
+			return err
+		}
+	}
+
+	for i, labelA := range a.Labels {
+		for j, labelB := range a.Labels {
+			if err := row("confusion", "", "", labelA, labelB, a.Confusion[i][j], ""); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func formatStatistic(x float64) string {
+	if math.IsNaN(x) {
+		return "NA"
+	}
+
+	return strconv.FormatFloat(x, 'f', 4, 64)
+}
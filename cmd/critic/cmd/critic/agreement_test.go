@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestCohenKappa(t *testing.T) {
+	// 20 yes/yes, 5 yes/no, 10 no/yes, 15 no/no gives a kappa of 0.4
+	ratings := map[string]map[int]string{"a": {}, "b": {}}
+	idx := 0
+	for _, cell := range []struct {
+		a, b string
+		n    int
+	}{{"yes", "yes", 20}, {"yes", "no", 5}, {"no", "yes", 10}, {"no", "no", 15}} {
+		for i := 0; i < cell.n; i++ {
+			ratings["a"][idx] = cell.a
+			ratings["b"][idx] = cell.b
+			idx++
+		}
+	}
+
+	agreement := ComputeAgreement([]Label{{Value: "yes"}, {Value: "no"}}, ratings)
+	if len(agreement.Pairs) != 1 {
+		t.Fatalf("Expected 1 pair, got %d", len(agreement.Pairs))
+	}
+	if pair := agreement.Pairs[0]; pair.N != 50 || math.Abs(pair.Kappa-0.4) > 1e-9 || math.Abs(pair.Observed-0.7) > 1e-9 {
+		t.Errorf("Expected N=50, observed=0.7, kappa=0.4; got %+v", pair)
+	}
+	if agreement.Confusion[0][1] != 15 || agreement.Confusion[1][0] != 15 {
+		t.Errorf("Expected 15 discordant ordered pairs each way, got %v", agreement.Confusion)
+	}
+}
+
+func TestFleissKappa(t *testing.T) {
+	// Fleiss (1971) style example with 14 raters, 10 images, and 5 categories,
+	// whose kappa is 0.210.
+	table := [][]int{
+		{0, 0, 0, 0, 14},
+		{0, 2, 6, 4, 2},
+		{0, 0, 3, 5, 6},
+		{0, 3, 9, 2, 0},
+		{2, 2, 8, 1, 1},
+		{7, 7, 0, 0, 0},
+		{3, 2, 6, 3, 0},
+		{2, 5, 3, 2, 2},
+		{6, 5, 2, 1, 0},
+		{0, 2, 2, 3, 7},
+	}
+
+	ratings := make(map[string]map[int]string)
+	for idx, row := range table {
+		rater := 0
+		for category, n := range row {
+			for i := 0; i < n; i++ {
+				name := fmt.Sprintf("rater%02d", rater)
+				if ratings[name] == nil {
+					ratings[name] = make(map[int]string)
+				}
+				ratings[name][idx] = fmt.Sprint(category)
+				rater++
+			}
+		}
+	}
+
+	agreement := ComputeAgreement(nil, ratings)
+	if agreement.Items != 10 {
+		t.Errorf("Expected 10 items, got %d", agreement.Items)
+	}
+	if math.Abs(agreement.FleissKappa-0.210) > 0.001 {
+		t.Errorf("Expected Fleiss' kappa of 0.210, got %f", agreement.FleissKappa)
+	}
+}
@@ -16,11 +16,11 @@ const (
 )
 
 type Annotation struct {
-	Dicom    string
-	SampleID string
-	Value    string
-	Date     string
-	Path     string
+	Dicom    string `db:"dicom"`
+	SampleID string `db:"sample_id"`
+	Value    string `db:"value"`
+	Date     string `db:"date"`
+	Path     string `db:"path"`
 }
 
 func OpenOrCreateAnnotationFile(annotationPath string) (map[DicomFilename]Annotation, error) {
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// An image that has been handed to a rater stays reserved for them for this
+// long. If they have not annotated it by then, it can be given to someone else.
+const assignmentLease = 30 * time.Minute
+
+// AssignmentQueue hands each image to up to K raters, in manifest order.
+// Completed assignments are the annotations themselves, so only the pending
+// reservations are tracked here, and they do not survive a restart.
+type AssignmentQueue struct {
+	K int
+
+	m        sync.Mutex
+	reserved map[int]map[string]time.Time
+}
+
+func NewAssignmentQueue(k int) *AssignmentQueue {
+	return &AssignmentQueue{K: k, reserved: make(map[int]map[string]time.Time)}
+}
+
+// Next returns the manifest index of the next image for user, and false if
+// every image has been (or is being) annotated by K raters or by this user.
+// annotated reports whether a given rater has annotated a given image.
+func (q *AssignmentQueue) Next(user string, raters []string, nEntries int, annotated func(rater string, idx int) bool) (int, bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	now := time.Now()
+
+	for idx := 0; idx < nEntries; idx++ {
+		if annotated(user, idx) {
+			continue
+		}
+
+		holders := q.reserved[idx]
+		for holder, when := range holders {
+			if now.Sub(when) > assignmentLease || annotated(holder, idx) {
+				delete(holders, holder)
+			}
+		}
+
+		// Re-serve an image that this user already holds
+		if _, exists := holders[user]; exists {
+			return idx, true
+		}
+
+		count := len(holders)
+		for _, rater := range raters {
+			if _, exists := holders[rater]; exists || rater == user {
+				continue
+			}
+			if annotated(rater, idx) {
+				count++
+			}
+		}
+
+		if count >= q.K {
+			continue
+		}
+
+		if holders == nil {
+			holders = make(map[string]time.Time)
+			q.reserved[idx] = holders
+		}
+		holders[user] = now
+
+		return idx, true
+	}
+
+	return 0, false
+}
+
+// Release drops any reservation that user holds on the image, e.g., once it
+// has been annotated.
+func (q *AssignmentQueue) Release(user string, idx int) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	delete(q.reserved[idx], user)
+	if len(q.reserved[idx]) == 0 {
+		delete(q.reserved, idx)
+	}
+}
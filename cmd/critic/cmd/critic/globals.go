@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"sort"
 	"sync"
 
 	"cloud.google.com/go/storage"
@@ -24,8 +26,22 @@ type Global struct {
 	OutputPath   string
 	Labels       []Label
 
+	// Tokens maps login tokens to usernames. UserHeader names a header in which
+	// an authenticating proxy passes the username. If either is set, each user
+	// gets their own annotation file.
+	Tokens     map[string]string
+	UserHeader string
+
+	// Raters is the number of users to whom each image is assigned by the
+	// queue. If 0, there is no queue.
+	Raters      int
+	assignments *AssignmentQueue
+
+	store AnnotationStore
+
 	m        sync.RWMutex
 	manifest *AnnotationTracker
+	users    map[string]*AnnotationTracker
 }
 
 func (g *Global) Manifest() []ManifestEntry {
@@ -39,6 +55,118 @@ func (g *Global) Manifest() []ManifestEntry {
 	return g.manifest.GetEntries()
 }
 
+func (g *Global) MultiUser() bool {
+	return len(g.Tokens) > 0 || g.UserHeader != ""
+}
+
+// Tracker returns the annotations belonging to user, loading them from (or
+// creating) the user's annotation file the first time that the user is seen.
+// Without multiple users, all annotations go into the main tracker.
+func (g *Global) Tracker(user string) (*AnnotationTracker, error) {
+	if !g.MultiUser() {
+		if g.manifest == nil {
+			return nil, fmt.Errorf("No manifest has been loaded")
+		}
+		return g.manifest, nil
+	}
+
+	if user == "" {
+		return nil, fmt.Errorf("No user was identified")
+	}
+
+	g.m.RLock()
+	tracker, exists := g.users[user]
+	g.m.RUnlock()
+	if exists {
+		return tracker, nil
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	// Another request may have loaded this user while we awaited the lock
+	if tracker, exists := g.users[user]; exists {
+		return tracker, nil
+	}
+
+	tracker, err := g.manifest.ForUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.users == nil {
+		g.users = make(map[string]*AnnotationTracker)
+	}
+	g.users[user] = tracker
+
+	return tracker, nil
+}
+
+// UserNames returns the users whose annotations have been loaded, in sorted
+// order.
+func (g *Global) UserNames() []string {
+	if !g.MultiUser() {
+		return []string{""}
+	}
+
+	g.m.RLock()
+	defer g.m.RUnlock()
+
+	users := make([]string, 0, len(g.users))
+	for user := range g.users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	return users
+}
+
+// Ratings returns every user's non-empty annotation values, keyed by user and
+// then by manifest index.
+func (g *Global) Ratings() (map[string]map[int]string, error) {
+	out := make(map[string]map[int]string)
+
+	for _, user := range g.UserNames() {
+		tracker, err := g.Tracker(user)
+		if err != nil {
+			return nil, err
+		}
+
+		out[user] = tracker.Values()
+	}
+
+	return out, nil
+}
+
+// NextAssignment returns the manifest index of the next image that the queue
+// assigns to user.
+func (g *Global) NextAssignment(user string) (int, bool, error) {
+	if g.assignments == nil {
+		return 0, false, fmt.Errorf("No assignment queue is running; set -raters")
+	}
+
+	if _, err := g.Tracker(user); err != nil {
+		return 0, false, err
+	}
+
+	raters := g.UserNames()
+	trackers := make(map[string]*AnnotationTracker, len(raters))
+	for _, rater := range raters {
+		tracker, err := g.Tracker(rater)
+		if err != nil {
+			return 0, false, err
+		}
+		trackers[rater] = tracker
+	}
+
+	idx, ok := g.assignments.Next(user, raters, len(g.Manifest()), func(rater string, idx int) bool {
+		tracker, exists := trackers[rater]
+		return exists && tracker.Annotated(idx)
+	})
+
+	return idx, ok, nil
+}
+
 type logger interface {
 	Print(v ...interface{})
 	Printf(format string, v ...interface{})
@@ -90,6 +90,7 @@ func (h *handler) Template(templateFilename string) *template.Template {
 				"add":       func(a, b int) int { return a + b },
 				"cleanDate": func(d time.Time) string { return d.Format("January 02, 2006") },
 				"year":      func(d time.Time) string { return d.Format("2006") },
+				"stat":      formatStatistic,
 				"noescape": func(s string) template.HTML {
 					return template.HTML(s)
 				},
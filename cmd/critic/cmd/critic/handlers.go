@@ -37,18 +37,90 @@ func (h *handler) ListProject(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
+	user, err := h.CurrentUser(w, r)
+	if err != nil {
+		HTTPError(h, w, r, err, http.StatusUnauthorized)
+		return
+	}
+
+	tracker, err := h.Global.Tracker(user)
+	if err != nil {
+		HTTPError(h, w, r, err)
+		return
+	}
+
 	output := struct {
 		Project  string
+		User     string
+		Queue    bool
 		Manifest []ManifestEntry
 	}{
 		h.Global.Project,
-		h.Global.Manifest(),
+		user,
+		h.Global.assignments != nil,
+		tracker.GetEntries(),
 	}
 
 	Render(h, w, r, "List Project", "listproject.html", output, nil)
 }
 
+// Next sends the user to their next image from the assignment queue.
+func (h *handler) Next(w http.ResponseWriter, r *http.Request) {
+	user, err := h.CurrentUser(w, r)
+	if err != nil {
+		HTTPError(h, w, r, err, http.StatusUnauthorized)
+		return
+	}
+
+	idx, ok, err := h.Global.NextAssignment(user)
+	if err != nil {
+		HTTPError(h, w, r, err)
+		return
+	}
+
+	if !ok {
+		output := struct {
+			Project string
+			User    string
+			Raters  int
+		}{
+			h.Global.Project,
+			user,
+			h.Global.Raters,
+		}
+
+		Render(h, w, r, "Queue Complete", "queuedone.html", output, nil)
+		return
+	}
+
+	nextURL, err := h.router.Get("critic").URL("manifest_index", strconv.Itoa(idx))
+	if err != nil {
+		HTTPError(h, w, r, err)
+		return
+	}
+
+	if overlay := r.URL.Query().Get("overlay"); overlay != "" {
+		qv := nextURL.Query()
+		qv.Add("overlay", overlay)
+		nextURL.RawQuery = qv.Encode()
+	}
+
+	http.Redirect(w, r, nextURL.String(), http.StatusSeeOther)
+}
+
 func (h *handler) CriticHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := h.CurrentUser(w, r)
+	if err != nil {
+		HTTPError(h, w, r, err, http.StatusUnauthorized)
+		return
+	}
+
+	tracker, err := h.Global.Tracker(user)
+	if err != nil {
+		HTTPError(h, w, r, err)
+		return
+	}
+
 	// Fetch the desired image from the zip file as described in the manifest
 	manifestIdx := mux.Vars(r)["manifest_index"]
 	manifestIndex, err := strconv.Atoi(manifestIdx)
@@ -57,12 +129,12 @@ func (h *handler) CriticHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if manifestIndex >= len(h.Global.Manifest()) {
+	if manifestIndex < 0 || manifestIndex >= len(tracker.GetEntries()) {
 		HTTPError(h, w, r, fmt.Errorf("Manifest_index was %d, out of range of the Manifest slice", manifestIndex))
 		return
 	}
 
-	manifestEntry := h.Global.Manifest()[manifestIndex]
+	manifestEntry := tracker.GetEntries()[manifestIndex]
 
 	showOverlay := true
 	r.ParseForm()
@@ -109,6 +181,8 @@ func (h *handler) CriticHandler(w http.ResponseWriter, r *http.Request) {
 		Height        int
 		ShowOverlay   bool
 		Labels        []Label
+		User          string
+		Queue         bool
 	}{
 		h.Global.Project,
 		manifestEntry,
@@ -119,47 +193,60 @@ func (h *handler) CriticHandler(w http.ResponseWriter, r *http.Request) {
 		10, // Previously im.Bounds().Dy(),
 		showOverlay,
 		h.Global.Labels,
+		user,
+		h.Global.assignments != nil,
 	}
 
 	Render(h, w, r, "Critic Handler", "critic.html", output, nil)
 }
 
 func (h *handler) CriticPost(w http.ResponseWriter, r *http.Request) {
+	user, err := h.CurrentUser(w, r)
+	if err != nil {
+		HTTPError(h, w, r, err, http.StatusUnauthorized)
+		return
+	}
+
+	tracker, err := h.Global.Tracker(user)
+	if err != nil {
+		HTTPError(h, w, r, err)
+		return
+	}
+
 	manifestIdx := mux.Vars(r)["manifest_index"]
 	manifestIndex, err := strconv.Atoi(manifestIdx)
 	if err != nil {
 		HTTPError(h, w, r, fmt.Errorf("No manifest_index passed"))
 		return
 	}
-	if manifestIndex >= len(h.Global.Manifest()) {
+	if manifestIndex < 0 || manifestIndex >= len(tracker.GetEntries()) {
 		HTTPError(h, w, r, fmt.Errorf("Manifest_index was %d, out of range of the Manifest slice", manifestIndex))
 		return
 	}
 
-	manifestEntry := h.Global.Manifest()[manifestIndex]
+	manifestEntry := tracker.GetEntries()[manifestIndex]
 
 	// Apply the annotation
 	r.ParseForm()
 
 	userAnno := r.PostForm.Get("value")
-	log.Println("Annotation submitted:", manifestEntry.SampleID, userAnno)
-
-	if err := h.Global.manifest.SetAnnotation(manifestIndex, userAnno); err != nil {
-		HTTPError(h, w, r, err)
-		return
-	}
+	log.Println("Annotation submitted:", user, manifestEntry.SampleID, userAnno)
 
-	// Write to disk. Can consider launching in a goroutine to reduce delay.
+	// The image path is recorded alongside the annotation
 	notedPath := h.Global.MergedRoot
 	if notedPath == "" {
 		notedPath = h.Global.RawRoot
 	}
-	if err := h.Global.manifest.WriteAnnotationsToDisk(notedPath); err != nil {
+	if err := tracker.SetAnnotation(manifestIndex, userAnno, GetIPAddress(r), notedPath); err != nil {
 		HTTPError(h, w, r, err)
 		return
 	}
 
 	nextURL, err := h.router.Get("critic").URL("manifest_index", strconv.Itoa(manifestIndex+1))
+	if h.Global.assignments != nil {
+		h.Global.assignments.Release(user, manifestIndex)
+		nextURL, err = h.router.Get("next").URL()
+	}
 	if err != nil {
 		HTTPError(h, w, r, err)
 		return
@@ -178,6 +265,113 @@ func (h *handler) CriticPost(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, nextURL.String(), http.StatusSeeOther)
 }
 
+// Undo reverts the user's most recent annotation and returns them to that
+// image.
+func (h *handler) Undo(w http.ResponseWriter, r *http.Request) {
+	user, err := h.CurrentUser(w, r)
+	if err != nil {
+		HTTPError(h, w, r, err, http.StatusUnauthorized)
+		return
+	}
+
+	tracker, err := h.Global.Tracker(user)
+	if err != nil {
+		HTTPError(h, w, r, err)
+		return
+	}
+
+	manifestIndex, ok, err := tracker.Undo(GetIPAddress(r))
+	if err != nil {
+		HTTPError(h, w, r, err)
+		return
+	}
+	if !ok {
+		HTTPError(h, w, r, fmt.Errorf("There are no annotations left to undo"), http.StatusConflict)
+		return
+	}
+	log.Println("Annotation undone:", user, manifestIndex)
+
+	nextURL, err := h.router.Get("critic").URL("manifest_index", strconv.Itoa(manifestIndex))
+	if err != nil {
+		HTTPError(h, w, r, err)
+		return
+	}
+
+	r.ParseForm()
+	if overlay := r.Form.Get("overlay"); overlay != "" {
+		qv := nextURL.Query()
+		qv.Add("overlay", overlay)
+		nextURL.RawQuery = qv.Encode()
+	}
+
+	http.Redirect(w, r, nextURL.String(), http.StatusSeeOther)
+}
+
+// Export serves either every user's current annotations or the full history
+// of annotation events as a downloadable TSV.
+func (h *handler) Export(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.CurrentUser(w, r); err != nil {
+		HTTPError(h, w, r, err, http.StatusUnauthorized)
+		return
+	}
+
+	which := mux.Vars(r)["which"]
+	export := WriteCurrentTSV
+	if which == "history" {
+		export = WriteHistoryTSV
+	}
+
+	w.Header().Set("Content-Type", "text/tab-separated-values")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tsv"`, which))
+	if err := export(w, h.Global.store); err != nil {
+		h.log.Println(err)
+	}
+}
+
+// Agreement reports inter-rater agreement across every user's annotations.
+func (h *handler) Agreement(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.CurrentUser(w, r); err != nil {
+		HTTPError(h, w, r, err, http.StatusUnauthorized)
+		return
+	}
+
+	ratings, err := h.Global.Ratings()
+	if err != nil {
+		HTTPError(h, w, r, err)
+		return
+	}
+
+	output := struct {
+		Project   string
+		Agreement Agreement
+	}{
+		h.Global.Project,
+		ComputeAgreement(h.Global.Labels, ratings),
+	}
+
+	Render(h, w, r, "Agreement", "agreement.html", output, nil)
+}
+
+// AgreementTSV serves the agreement report as a downloadable TSV.
+func (h *handler) AgreementTSV(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.CurrentUser(w, r); err != nil {
+		HTTPError(h, w, r, err, http.StatusUnauthorized)
+		return
+	}
+
+	ratings, err := h.Global.Ratings()
+	if err != nil {
+		HTTPError(h, w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/tab-separated-values")
+	w.Header().Set("Content-Disposition", `attachment; filename="agreement.tsv"`)
+	if err := ComputeAgreement(h.Global.Labels, ratings).WriteTSV(w); err != nil {
+		h.log.Println(err)
+	}
+}
+
 func (h *handler) Goroutines(w http.ResponseWriter, r *http.Request) {
 	goroutines := fmt.Sprintf("%d goroutines are currently active\n", runtime.NumGoroutine())
 
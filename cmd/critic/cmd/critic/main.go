@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
@@ -42,19 +43,31 @@ func main() {
 		//syscall.SIGINFO,
 	)
 
-	var rawRoot, mergedRoot, outputPath, labelsFile, manifestPath, nestedSuffix string
-	var port int
+	var rawRoot, mergedRoot, outputPath, labelsFile, manifestPath, nestedSuffix, usersFile, userHeader, storeKind, export string
+	var port, raters int
 	flag.StringVar(&addSuffix, "add_suffix", "", "(Optional) Suffix to add after the /merged/ filename to obtain the correct filename from the /raw/ data.")
 	flag.StringVar(&removeSuffix, "remove_suffix", "", "(Optional) Suffix to remove from the /merged/ filename to obtain the correct filename from the /raw/ data.")
 	flag.StringVar(&rawRoot, "raw", "", "(Optional) Path under which all secondary (usually raw/no-overlay) images sit. If --manifest is set, this may be a gs:// URL. Either raw or merged (or both) must be set.")
 	flag.StringVar(&manifestPath, "manifest", "", "(Optional) Path with a file whose first column is the file names of the images of interest from the --merged folder.")
 	flag.StringVar(&mergedRoot, "merged", "", "(Optional) Path under which all main images of interest sit. If --manifest is set, this may be a gs:// URL. Either raw or merged (or both) must be set.")
 	flag.StringVar(&outputPath, "output", "", "Path to a local file where all output will be written. Will be created if it does not yet exist.")
+	flag.StringVar(&storeKind, "store", "tsv", "How annotations are stored at --output: 'tsv' (a flat file of current annotations, plus every change journaled to <output>.history) or 'sqlite' (a SQLite database holding both).")
+	flag.StringVar(&export, "export", "", "(Optional) Instead of serving, write the 'current' annotations or their full 'history' from --output to stdout as TSV, and exit.")
 	flag.StringVar(&nestedSuffix, "nested-suffix", "", "If images are nested within .tar.gz files, the wrapper file is assumed to be in a manifest column named 'zip_file' and the image filename assumed to be in a column named 'dicom_file'. --nested-suffix defines how the zip_file name is modified (.zip is removed and nested-suffix is added)")
 	flag.IntVar(&port, "port", 9019, "Port for HTTP server")
 	flag.StringVar(&labelsFile, "labels", "", "(Optional) json file with labels. E.g.: {Labels: [{'name':'Label 1', 'value':'l1'}]}")
+	flag.StringVar(&usersFile, "users", "", "(Optional) Headerless tab-delimited file of login tokens (column 1) and usernames (column 2). Users log in by visiting the site with ?token=<token>. Each user's annotations are written next to --output, e.g., output.<username>.tsv, instead of to --output itself.")
+	flag.StringVar(&userHeader, "user-header", "", "(Optional) Name of an HTTP header that carries the username, as set by an authenticating proxy (e.g., X-Goog-Authenticated-User-Email). Like --users, gives each user their own annotation file.")
+	flag.IntVar(&raters, "raters", 0, "(Optional) If set, /next serves images from a queue that assigns each image to this many users.")
 	flag.Parse()
 
+	if outputPath != "" && export != "" {
+		if err := exportStore(storeKind, outputPath, export); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	// We permit passing either
 	if (mergedRoot == "" && rawRoot == "") || outputPath == "" {
 		flag.PrintDefaults()
@@ -93,7 +106,32 @@ func main() {
 		MergedRoot: mergedRoot,
 		OutputPath: outputPath,
 		Labels:     []Label{{DisplayName: "Bad Image", Value: "bad-image"}, {DisplayName: "Mistraced Segmentation", Value: "mistrace"}, {DisplayName: "Good", Value: "good"}},
+		UserHeader: userHeader,
+		Raters:     raters,
+	}
+
+	if usersFile != "" {
+		uf, err := os.Open(usersFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		global.Tokens, err = ParseUserTokenFile(uf)
+		uf.Close()
+		if err != nil {
+			log.Fatalln(usersFile, err)
+		}
+	}
+
+	if raters > 0 {
+		global.assignments = NewAssignmentQueue(raters)
+	}
+
+	global.store, err = OpenAnnotationStore(storeKind, outputPath)
+	if err != nil {
+		log.Fatalln(err)
 	}
+	defer global.store.Close()
 
 	var sortedAnnotatedManifest *AnnotationTracker
 
@@ -102,9 +140,9 @@ func main() {
 		log.Fatalln(err)
 	}
 	if nestedSuffix != "" {
-		sortedAnnotatedManifest, err = ReadNestedManifest(manReader, outputPath, nestedSuffix)
+		sortedAnnotatedManifest, err = ReadNestedManifest(manReader, global.store, nestedSuffix)
 	} else {
-		sortedAnnotatedManifest, err = CreateManifestAndOutput(mergedRoot, outputPath, manReader, nestedSuffix)
+		sortedAnnotatedManifest, err = CreateManifestAndOutput(mergedRoot, global.store, manReader, nestedSuffix)
 	}
 	if err != nil {
 		log.Fatalln(err)
@@ -112,6 +150,28 @@ func main() {
 
 	global.manifest = sortedAnnotatedManifest
 
+	if global.MultiUser() {
+		// Load everyone who has annotated before, so that the queue and the
+		// agreement report see their work from the start.
+		knownUsers, err := global.store.Users()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, user := range global.Tokens {
+			knownUsers = append(knownUsers, user)
+		}
+
+		for _, user := range knownUsers {
+			if user == "" {
+				// Annotations from before there were users
+				continue
+			}
+			if _, err := global.Tracker(user); err != nil {
+				log.Fatalln(err)
+			}
+		}
+	}
+
 	if labelsFile != "" {
 		lf, err := os.Open(labelsFile)
 		if err != nil {
@@ -187,6 +247,26 @@ Outer:
 	}
 }
 
+func exportStore(storeKind, outputPath, export string) error {
+	store, err := OpenAnnotationStore(storeKind, outputPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	switch export {
+	case "current":
+		return WriteCurrentTSV(w, store)
+	case "history":
+		return WriteHistoryTSV(w, store)
+	}
+
+	return fmt.Errorf("Unrecognized --export %q; expected current or history", export)
+}
+
 func SigStatus() {
 	global.log.Println("There are", runtime.NumGoroutine(), "goroutines running")
 }
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -18,8 +17,11 @@ import (
 type DicomFilename string
 
 type AnnotationTracker struct {
-	AnnotationPath string
-	Nested         bool
+	Nested bool
+
+	// User is the namespace whose annotations this tracker holds
+	User  string
+	store AnnotationStore
 
 	m       sync.RWMutex
 	Entries []ManifestEntry
@@ -32,19 +34,88 @@ func (m *AnnotationTracker) GetEntries() []ManifestEntry {
 	return m.Entries
 }
 
-func (m *AnnotationTracker) SetAnnotation(manifestIdx int, value string) error {
+// SetAnnotation records the new value in the store, on behalf of a client at
+// ip, and then applies it. imagePath is stored with the annotation if it does
+// not already have a path.
+func (m *AnnotationTracker) SetAnnotation(manifestIdx int, value, ip, imagePath string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if manifestIdx < 0 || len(m.Entries)-1 < manifestIdx {
+		return fmt.Errorf("manifest entry #%d was not found", manifestIdx)
+	}
+
+	chosen := m.Entries[manifestIdx]
+
+	path := chosen.Annotation.Path
+	if path == "" {
+		path = imagePath
+	}
+
+	event := AnnotationEvent{
+		User:     m.User,
+		Date:     time.Now().Format(time.RFC3339),
+		IP:       ip,
+		SampleID: chosen.SampleID,
+		Dicom:    string(annotationKey(chosen)),
+		OldValue: chosen.Annotation.Value,
+		NewValue: value,
+		Path:     path,
+	}
+
+	return m.apply(manifestIdx, event)
+}
+
+// Undo reverts the user's most recent annotation that has not yet been undone,
+// recording the reversion as a new event. It returns the manifest index of the
+// reverted entry, and false if there was nothing to undo.
+func (m *AnnotationTracker) Undo(ip string) (int, bool, error) {
 	m.m.Lock()
 	defer m.m.Unlock()
 
-	err := fmt.Errorf("manifest entry #%d was not found", manifestIdx)
+	last, ok, err := m.store.LastUndoable(m.User)
+	if err != nil || !ok {
+		return 0, false, err
+	}
 
-	if len(m.Entries)-1 < manifestIdx {
+	manifestIdx := -1
+	for i, v := range m.Entries {
+		if string(annotationKey(v)) == last.Dicom {
+			manifestIdx = i
+			break
+		}
+	}
+	if manifestIdx < 0 {
+		return 0, false, fmt.Errorf("%s was annotated but is not in the manifest", last.Dicom)
+	}
+
+	event := AnnotationEvent{
+		User:     m.User,
+		Date:     time.Now().Format(time.RFC3339),
+		IP:       ip,
+		SampleID: last.SampleID,
+		Dicom:    last.Dicom,
+		OldValue: m.Entries[manifestIdx].Annotation.Value,
+		NewValue: last.OldValue,
+		Path:     last.Path,
+		Undoes:   last.ID,
+	}
+
+	return manifestIdx, true, m.apply(manifestIdx, event)
+}
+
+// apply must be called with the lock held. The event is made durable before
+// the in-memory entry changes.
+func (m *AnnotationTracker) apply(manifestIdx int, event AnnotationEvent) error {
+	if err := m.store.Record(&event); err != nil {
 		return err
 	}
 
 	chosen := m.Entries[manifestIdx]
-	chosen.Annotation.Date = time.Now().Format(time.RFC3339)
-	chosen.Annotation.Value = value
+	chosen.Annotation.SampleID = event.SampleID
+	chosen.Annotation.Date = event.Date
+	chosen.Annotation.Value = event.NewValue
+	chosen.Annotation.Path = event.Path
 
 	// Make sure the main array gets the values
 	m.Entries[manifestIdx] = chosen
@@ -52,36 +123,64 @@ func (m *AnnotationTracker) SetAnnotation(manifestIdx int, value string) error {
 	return nil
 }
 
-func (m *AnnotationTracker) WriteAnnotationsToDisk(imagePath string) error {
-	m.m.Lock()
-	defer m.m.Unlock()
+// Values returns the non-empty annotation values, keyed by manifest index.
+func (m *AnnotationTracker) Values() map[int]string {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	out := make(map[int]string)
+	for i, v := range m.Entries {
+		if v.Annotation.Value != "" {
+			out[i] = v.Annotation.Value
+		}
+	}
+
+	return out
+}
+
+// Annotated reports whether manifest entry #manifestIdx has a value.
+func (m *AnnotationTracker) Annotated(manifestIdx int) bool {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	if manifestIdx < 0 || manifestIdx >= len(m.Entries) {
+		return false
+	}
+
+	return m.Entries[manifestIdx].Annotation.Value != ""
+}
 
-	// f, err := os.Open(m.AnnotationPath)
-	f, err := os.OpenFile(m.AnnotationPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+// ForUser creates a tracker with the same manifest entries but with the
+// annotations that belong to user.
+func (m *AnnotationTracker) ForUser(user string) (*AnnotationTracker, error) {
+	annotations, err := m.store.Annotations(user)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
 
-	fmt.Fprintf(f, "sample_id\tdicom\tvalue\tdate\tpath\n")
-	for _, v := range m.Entries {
-		if v.Annotation.Value == "" {
-			continue
-		}
+	m.m.RLock()
+	defer m.m.RUnlock()
 
-		if v.Annotation.Path == "" {
-			v.Annotation.Path = imagePath
-		}
+	entries := make([]ManifestEntry, len(m.Entries))
+	for i, v := range m.Entries {
+		anno := annotations[annotationKey(v)]
+		anno.Dicom = v.Annotation.Dicom
 
-		dicomName := v.Annotation.Dicom
-		if dicomName == "" {
-			dicomName = v.Dicom
-		}
+		v.Annotation = anno
+		entries[i] = v
+	}
 
-		fmt.Fprintf(f, "%s\t%s\t%s\t%s\t%s\n", v.SampleID, dicomName, v.Annotation.Value, v.Annotation.Date, v.Annotation.Path)
+	return &AnnotationTracker{Entries: entries, Nested: m.Nested, User: user, store: m.store}, nil
+}
+
+// annotationKey is the name under which an entry's annotation is stored.
+// Nested manifests annotate the raw dicom name rather than the merged image.
+func annotationKey(v ManifestEntry) DicomFilename {
+	if v.Annotation.Dicom != "" {
+		return DicomFilename(v.Annotation.Dicom)
 	}
 
-	return nil
+	return DicomFilename(v.Dicom)
 }
 
 type ManifestEntry struct {
@@ -113,7 +212,7 @@ func ManifestPathToBufferedReader(manifestPath string) (*bytes.Reader, int64, er
 
 // ReadNestedManifest will attempt to parse a classical manifest. This is
 // necessary when the image files are nested within a container.
-func ReadNestedManifest(manifest io.ReadSeeker, annotationPath, nestedSuffix string) (*AnnotationTracker, error) {
+func ReadNestedManifest(manifest io.ReadSeeker, store AnnotationStore, nestedSuffix string) (*AnnotationTracker, error) {
 
 	mayBeNested := true
 
@@ -121,9 +220,8 @@ func ReadNestedManifest(manifest io.ReadSeeker, annotationPath, nestedSuffix str
 	// suffix. TODO: Make configurable.
 	assumedMergedImageSuffix := ".png.overlay.png"
 
-	// Fetch any annotations that already exist, or create a file to hold them
-	// if none yet do.
-	annotations, err := OpenOrCreateAnnotationFile(annotationPath)
+	// Fetch any annotations that already exist in the default namespace.
+	annotations, err := store.Annotations("")
 	if err != nil {
 		return nil, err
 	}
@@ -209,19 +307,19 @@ func ReadNestedManifest(manifest io.ReadSeeker, annotationPath, nestedSuffix str
 	// For now, don't sort manifests - permits you to arrange them in a preprocessing step
 	// sort.Slice(output, generateManifestSorter(output))
 
-	return &AnnotationTracker{Entries: output, Nested: mayBeNested, AnnotationPath: annotationPath}, nil
+	return &AnnotationTracker{Entries: output, Nested: mayBeNested, store: store}, nil
 }
 
 // CreateManifestAndOutput lists all files in your main input directory and your
 // output directory. It checks to see if the output file has already been
 // created. If so, it reads the output and matches it with the input, returning
 // pre-populated values.
-func CreateManifestAndOutput(mergedPath, annotationPath string, manifest io.ReadSeeker, nestedSuffix string) (*AnnotationTracker, error) {
+func CreateManifestAndOutput(mergedPath string, store AnnotationStore, manifest io.ReadSeeker, nestedSuffix string) (*AnnotationTracker, error) {
 
 	// First, if we happen to be given a complete manifest, read it. If that
 	// conforms to our old manifest format, use it. Just mark it non-nested.
 	manifest.Seek(0, 0)
-	tracker, err := ReadNestedManifest(manifest, annotationPath, nestedSuffix)
+	tracker, err := ReadNestedManifest(manifest, store, nestedSuffix)
 	if err == nil {
 		tracker.Nested = false
 		return tracker, nil
@@ -232,9 +330,8 @@ func CreateManifestAndOutput(mergedPath, annotationPath string, manifest io.Read
 
 	output := make([]ManifestEntry, 0)
 
-	// Fetch any annotations that already exist, or create a file to hold them
-	// if none yet do.
-	annotations, err := OpenOrCreateAnnotationFile(annotationPath)
+	// Fetch any annotations that already exist in the default namespace.
+	annotations, err := store.Annotations("")
 	if err != nil {
 		return nil, err
 	}
@@ -284,5 +381,5 @@ func CreateManifestAndOutput(mergedPath, annotationPath string, manifest io.Read
 		}
 	}
 
-	return &AnnotationTracker{Entries: output, AnnotationPath: annotationPath}, nil
+	return &AnnotationTracker{Entries: output, store: store}, nil
 }
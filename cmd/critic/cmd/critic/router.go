@@ -21,12 +21,17 @@ func router(config *Global) (http.Handler, error) {
 	GET.HandleFunc("/{template:(?:about|privacy|TOS|DMCA)}", h.TemplateOnly)
 	GET.HandleFunc("/critic/{manifest_index}", h.CriticHandler).Name("critic")
 	GET.HandleFunc("/listproject", h.ListProject).Name("listproject")
+	GET.HandleFunc("/next", h.Next).Name("next")
+	GET.HandleFunc("/agreement", h.Agreement).Name("agreement")
+	GET.HandleFunc("/agreement.tsv", h.AgreementTSV).Name("agreementtsv")
+	GET.HandleFunc("/export/{which:(?:current|history)}.tsv", h.Export).Name("export")
 
 	//
 	// POST
 	//
 	POST.Handle("/", http.NotFoundHandler())
 	POST.HandleFunc("/critic/{manifest_index}", h.CriticPost)
+	POST.HandleFunc("/undo", h.Undo).Name("undo")
 
 	// Static assets
 	assetFilesystem, err := fs.Sub(embeddedTemplates, "templates/static")
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// AnnotationEvent records one change to one annotation.
+type AnnotationEvent struct {
+	ID       int64  `db:"id"`
+	User     string `db:"user"`
+	Date     string `db:"date"`
+	IP       string `db:"ip"`
+	SampleID string `db:"sample_id"`
+	Dicom    string `db:"dicom"`
+	OldValue string `db:"old_value"`
+	NewValue string `db:"new_value"`
+	Path     string `db:"path"`
+
+	// Undoes is the ID of the event that this event reverted, or 0.
+	Undoes int64 `db:"undoes"`
+}
+
+// AnnotationStore persists the current annotations of each user along with
+// every event that produced them. Implementations must be safe for concurrent
+// use, and Record must not return until the event is durable.
+type AnnotationStore interface {
+	// Users lists every user who has annotations.
+	Users() ([]string, error)
+
+	// Annotations returns the current, non-empty annotations of one user.
+	Annotations(user string) (map[DicomFilename]Annotation, error)
+
+	// Record saves the event, assigning its ID, and applies its NewValue.
+	Record(event *AnnotationEvent) error
+
+	// LastUndoable returns the user's most recent event that is neither an
+	// undo nor has already been undone.
+	LastUndoable(user string) (AnnotationEvent, bool, error)
+
+	// History returns every event, oldest first.
+	History() ([]AnnotationEvent, error)
+
+	Close() error
+}
+
+// OpenAnnotationStore opens (or creates) the store of the given kind at
+// outputPath.
+func OpenAnnotationStore(kind, outputPath string) (AnnotationStore, error) {
+	switch kind {
+	case "tsv":
+		return OpenTSVStore(outputPath)
+	case "sqlite":
+		return OpenSQLiteStore(outputPath)
+	}
+
+	return nil, fmt.Errorf("Unrecognized store %q; expected tsv or sqlite", kind)
+}
+
+var historyHeader = []string{"id", "user", "date", "ip", "sample_id", "dicom", "old_value", "new_value", "undoes", "path"}
+
+func (e AnnotationEvent) columns() []string {
+	undoes := ""
+	if e.Undoes != 0 {
+		undoes = strconv.FormatInt(e.Undoes, 10)
+	}
+
+	return []string{strconv.FormatInt(e.ID, 10), e.User, e.Date, e.IP, e.SampleID, e.Dicom, e.OldValue, e.NewValue, undoes, e.Path}
+}
+
+func parseHistoryColumns(cols []string) (AnnotationEvent, error) {
+	if len(cols) != len(historyHeader) {
+		return AnnotationEvent{}, fmt.Errorf("Expected %d columns, found %d", len(historyHeader), len(cols))
+	}
+
+	id, err := strconv.ParseInt(cols[0], 10, 64)
+	if err != nil {
+		return AnnotationEvent{}, err
+	}
+
+	var undoes int64
+	if cols[8] != "" {
+		if undoes, err = strconv.ParseInt(cols[8], 10, 64); err != nil {
+			return AnnotationEvent{}, err
+		}
+	}
+
+	return AnnotationEvent{
+		ID:       id,
+		User:     cols[1],
+		Date:     cols[2],
+		IP:       cols[3],
+		SampleID: cols[4],
+		Dicom:    cols[5],
+		OldValue: cols[6],
+		NewValue: cols[7],
+		Undoes:   undoes,
+		Path:     cols[9],
+	}, nil
+}
+
+// WriteCurrentTSV exports every user's current annotations.
+func WriteCurrentTSV(w io.Writer, store AnnotationStore) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = '\t'
+
+	if err := cw.Write([]string{"user", "sample_id", "dicom", "value", "date", "path"}); err != nil {
+		return err
+	}
+
+	users, err := store.Users()
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		annotations, err := store.Annotations(user)
+		if err != nil {
+			return err
+		}
+
+		for _, anno := range sortedAnnotations(annotations) {
+			if err := cw.Write([]string{user, anno.SampleID, anno.Dicom, anno.Value, anno.Date, anno.Path}); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func sortedAnnotations(annotations map[DicomFilename]Annotation) []Annotation {
+	out := make([]Annotation, 0, len(annotations))
+	for _, anno := range annotations {
+		out = append(out, anno)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Dicom < out[j].Dicom })
+
+	return out
+}
+
+// WriteHistoryTSV exports every annotation event.
+func WriteHistoryTSV(w io.Writer, store AnnotationStore) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = '\t'
+
+	if err := cw.Write(historyHeader); err != nil {
+		return err
+	}
+
+	events, err := store.History()
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if err := cw.Write(event.columns()); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeFileAtomically writes to a temporary file in the same folder, syncs it,
+// and renames it over path, so that a crash leaves either the old file or the
+// new one, but never a partial file.
+func writeFileAtomically(path string, write func(io.Writer) error) error {
+	dir := filepath.Dir(path)
+
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	// Make the rename itself durable
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
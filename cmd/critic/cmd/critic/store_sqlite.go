@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS annotation_event (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user TEXT NOT NULL,
+	date TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	sample_id TEXT NOT NULL,
+	dicom TEXT NOT NULL,
+	old_value TEXT NOT NULL,
+	new_value TEXT NOT NULL,
+	path TEXT NOT NULL,
+	undoes INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS annotation_event_user ON annotation_event (user, id);
+CREATE INDEX IF NOT EXISTS annotation_event_undoes ON annotation_event (undoes);
+
+CREATE TABLE IF NOT EXISTS annotation (
+	user TEXT NOT NULL,
+	dicom TEXT NOT NULL,
+	sample_id TEXT NOT NULL,
+	value TEXT NOT NULL,
+	date TEXT NOT NULL,
+	path TEXT NOT NULL,
+	PRIMARY KEY (user, dicom)
+);
+`
+
+// SQLiteStore keeps annotations and their history in one SQLite database.
+// Each event and the current value it produces are written in a single
+// transaction.
+type SQLiteStore struct {
+	db *sqlx.DB
+}
+
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_synchronous=FULL&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite permits one writer at a time
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Could not create the annotation tables in %s: %v", path, err)
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Users() ([]string, error) {
+	var users []string
+	err := s.db.Select(&users, `SELECT user FROM annotation UNION SELECT user FROM annotation_event ORDER BY user`)
+
+	return users, err
+}
+
+func (s *SQLiteStore) Annotations(user string) (map[DicomFilename]Annotation, error) {
+	var rows []Annotation
+	if err := s.db.Select(&rows, `SELECT dicom, sample_id, value, date, path FROM annotation WHERE user=?`, user); err != nil {
+		return nil, err
+	}
+
+	out := make(map[DicomFilename]Annotation, len(rows))
+	for _, row := range rows {
+		out[DicomFilename(row.Dicom)] = row
+	}
+
+	return out, nil
+}
+
+func (s *SQLiteStore) Record(event *AnnotationEvent) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.NamedExec(`INSERT INTO annotation_event (user, date, ip, sample_id, dicom, old_value, new_value, path, undoes)
+VALUES (:user, :date, :ip, :sample_id, :dicom, :old_value, :new_value, :path, :undoes)`, event)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if event.NewValue == "" {
+		_, err = tx.Exec(`DELETE FROM annotation WHERE user=? AND dicom=?`, event.User, event.Dicom)
+	} else {
+		_, err = tx.Exec(`INSERT INTO annotation (user, dicom, sample_id, value, date, path) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (user, dicom) DO UPDATE SET sample_id=excluded.sample_id, value=excluded.value, date=excluded.date, path=excluded.path`,
+			event.User, event.Dicom, event.SampleID, event.NewValue, event.Date, event.Path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	event.ID = id
+
+	return nil
+}
+
+func (s *SQLiteStore) LastUndoable(user string) (AnnotationEvent, bool, error) {
+	var event AnnotationEvent
+	err := s.db.Get(&event, `SELECT * FROM annotation_event e
+WHERE e.user=? AND e.undoes=0 AND NOT EXISTS (SELECT 1 FROM annotation_event u WHERE u.undoes=e.id)
+ORDER BY e.id DESC LIMIT 1`, user)
+	if err == sql.ErrNoRows {
+		return event, false, nil
+	} else if err != nil {
+		return event, false, err
+	}
+
+	return event, true, nil
+}
+
+func (s *SQLiteStore) History() ([]AnnotationEvent, error) {
+	var events []AnnotationEvent
+	err := s.db.Select(&events, `SELECT * FROM annotation_event ORDER BY id`)
+
+	return events, err
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
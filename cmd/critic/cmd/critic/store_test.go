@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testStoreKinds = []string{"tsv", "sqlite"}
+
+func testStorePath(t *testing.T, kind string) string {
+	t.Helper()
+
+	if kind == "sqlite" {
+		return filepath.Join(t.TempDir(), "annotations.sqlite")
+	}
+
+	return filepath.Join(t.TempDir(), "annotations.tsv")
+}
+
+func testEvent(user, dicom, oldValue, newValue string) *AnnotationEvent {
+	return &AnnotationEvent{
+		User:     user,
+		Date:     "2021-06-01T10:00:00Z",
+		IP:       "127.0.0.1",
+		SampleID: "1000001",
+		Dicom:    dicom,
+		OldValue: oldValue,
+		NewValue: newValue,
+		Path:     "1000001_20209_2_0.zip",
+	}
+}
+
+func recordTestEvents(t *testing.T, store AnnotationStore, events ...*AnnotationEvent) {
+	t.Helper()
+
+	for _, event := range events {
+		if err := store.Record(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testUndo mirrors AnnotationTracker.Undo: the last undoable event is reverted
+// by recording its old value.
+func testUndo(t *testing.T, store AnnotationStore, user string) (AnnotationEvent, bool) {
+	t.Helper()
+
+	last, ok, err := store.LastUndoable(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		return last, false
+	}
+
+	undo := testEvent(user, last.Dicom, last.NewValue, last.OldValue)
+	undo.Undoes = last.ID
+	recordTestEvents(t, store, undo)
+
+	return last, true
+}
+
+func annotationValues(t *testing.T, store AnnotationStore, user string) map[string]string {
+	t.Helper()
+
+	annotations, err := store.Annotations(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		out[string(k)] = v.Value
+	}
+
+	return out
+}
+
+func sameValues(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStoreUndo(t *testing.T) {
+	for _, kind := range testStoreKinds {
+		path := testStorePath(t, kind)
+		store, err := OpenAnnotationStore(kind, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		recordTestEvents(t, store,
+			testEvent("alice", "a.dcm", "", "good"),
+			testEvent("alice", "b.dcm", "", "bad"),
+			testEvent("bob", "a.dcm", "", "bad"),
+		)
+
+		// Undo reverts alice's most recent event, not bob's
+		if undone, ok := testUndo(t, store, "alice"); !ok || undone.Dicom != "b.dcm" {
+			t.Fatalf("%s: first undo reverted %+v, expected alice's b.dcm", kind, undone)
+		}
+		if got := annotationValues(t, store, "alice"); !sameValues(got, map[string]string{"a.dcm": "good"}) {
+			t.Errorf("%s: after one undo, alice has %v", kind, got)
+		}
+
+		// The undo itself cannot be undone, so the next undo reverts the event
+		// before it
+		if undone, ok := testUndo(t, store, "alice"); !ok || undone.Dicom != "a.dcm" || undone.Undoes != 0 {
+			t.Fatalf("%s: second undo reverted %+v, expected alice's a.dcm", kind, undone)
+		}
+		if got := annotationValues(t, store, "alice"); len(got) != 0 {
+			t.Errorf("%s: after two undos, alice has %v", kind, got)
+		}
+
+		if undone, ok := testUndo(t, store, "alice"); ok {
+			t.Errorf("%s: third undo reverted %+v, expected nothing left to undo", kind, undone)
+		}
+
+		if got := annotationValues(t, store, "bob"); !sameValues(got, map[string]string{"a.dcm": "bad"}) {
+			t.Errorf("%s: bob has %v after alice's undos", kind, got)
+		}
+
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Which events were undone must survive a restart
+		store, err = OpenAnnotationStore(kind, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := store.LastUndoable("alice"); err != nil || ok {
+			t.Errorf("%s: after reopening, alice still had an undoable event (err: %v)", kind, err)
+		}
+		if last, ok, err := store.LastUndoable("bob"); err != nil || !ok || last.ID != 3 {
+			t.Errorf("%s: after reopening, bob's undoable event was %+v, expected ID 3 (err: %v)", kind, last, err)
+		}
+		store.Close()
+	}
+}
+
+func TestStoreHistoryExport(t *testing.T) {
+	for _, kind := range testStoreKinds {
+		store, err := OpenAnnotationStore(kind, testStorePath(t, kind))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Values with tabs and quotes must survive the round trip
+		recordTestEvents(t, store,
+			testEvent("alice", "a.dcm", "", "good"),
+			testEvent("alice", "a.dcm", "good", "has\ttab \"quoted\""),
+			testEvent("bob", "b.dcm", "", "bad"),
+		)
+		testUndo(t, store, "bob")
+
+		history := &bytes.Buffer{}
+		if err := WriteHistoryTSV(history, store); err != nil {
+			t.Fatal(err)
+		}
+
+		cr := csv.NewReader(history)
+		cr.Comma = '\t'
+		rows, err := cr.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if len(rows) != 5 || strings.Join(rows[0], ",") != strings.Join(historyHeader, ",") {
+			t.Fatalf("%s: history export was %v", kind, rows)
+		}
+
+		for i, row := range rows[1:] {
+			event, err := parseHistoryColumns(row)
+			if err != nil {
+				t.Fatalf("%s: history row %d: %v", kind, i+1, err)
+			}
+			if event.ID != int64(i+1) {
+				t.Errorf("%s: history row %d had ID %d", kind, i+1, event.ID)
+			}
+		}
+
+		if event, _ := parseHistoryColumns(rows[2]); event.NewValue != "has\ttab \"quoted\"" || event.OldValue != "good" {
+			t.Errorf("%s: history row 2 was %+v", kind, event)
+		}
+		if event, _ := parseHistoryColumns(rows[4]); event.Undoes != 3 || event.NewValue != "" || event.User != "bob" {
+			t.Errorf("%s: history row 4 was %+v, expected bob's undo of event 3", kind, event)
+		}
+
+		current := &bytes.Buffer{}
+		if err := WriteCurrentTSV(current, store); err != nil {
+			t.Fatal(err)
+		}
+
+		cr = csv.NewReader(current)
+		cr.Comma = '\t'
+		rows, err = cr.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		if len(rows) != 2 || rows[1][0] != "alice" || rows[1][2] != "a.dcm" || rows[1][3] != "has\ttab \"quoted\"" {
+			t.Errorf("%s: current export was %v, expected only alice's a.dcm", kind, rows)
+		}
+
+		store.Close()
+	}
+}
+
+func TestTSVStoreTruncatedJournal(t *testing.T) {
+	path := testStorePath(t, "tsv")
+
+	store, err := OpenTSVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordTestEvents(t, store,
+		testEvent("", "a.dcm", "", "good"),
+		testEvent("", "b.dcm", "", "bad"),
+	)
+	store.Close()
+
+	// Simulate a kill -9 partway through appending the third event
+	journal, err := os.OpenFile(path+".history", os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := journal.WriteString("3\t\t2021-06-01T10:00:00Z\t127.0.0.1\t1000001\tc.d"); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	store, err = OpenTSVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if history, err := store.History(); err != nil || len(history) != 2 {
+		t.Fatalf("After a truncated write, history had %d events (err: %v), expected 2", len(history), err)
+	}
+	if got := annotationValues(t, store, ""); !sameValues(got, map[string]string{"a.dcm": "good", "b.dcm": "bad"}) {
+		t.Errorf("After a truncated write, annotations were %v", got)
+	}
+
+	// The next event must start on its own line rather than extending the
+	// partial one
+	next := testEvent("", "c.dcm", "", "good")
+	recordTestEvents(t, store, next)
+	if next.ID != 3 {
+		t.Errorf("Event after a truncated write was assigned ID %d, expected 3", next.ID)
+	}
+	store.Close()
+
+	store, err = OpenTSVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	history, err := store.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[2].Dicom != "c.dcm" || history[2].NewValue != "good" {
+		t.Errorf("After recovering from a truncated write, history was %+v", history)
+	}
+}
+
+func TestTSVStoreReplaysJournalOverFlatFile(t *testing.T) {
+	path := testStorePath(t, "tsv")
+
+	store, err := OpenTSVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordTestEvents(t, store,
+		testEvent("alice", "a.dcm", "", "good"),
+		testEvent("alice", "b.dcm", "", "bad"),
+		testEvent("alice", "b.dcm", "bad", ""),
+	)
+	store.Close()
+
+	// Simulate a crash after the journal was synced but before the flat file
+	// was replaced: the flat file is stale and disagrees with the journal. It
+	// also holds an annotation from before the journal existed, which must be
+	// kept.
+	stale := "sample_id\tdicom\tvalue\tdate\tpath\n" +
+		"1000001\ta.dcm\tbad\t2021-06-01T09:00:00Z\t1000001_20209_2_0.zip\n" +
+		"1000001\tb.dcm\tbad\t2021-06-01T09:00:00Z\t1000001_20209_2_0.zip\n" +
+		"1000001\tlegacy.dcm\tgood\t2020-01-01T09:00:00Z\t1000001_20209_2_0.zip\n"
+	if err := os.WriteFile(UserAnnotationPath(path, "alice"), []byte(stale), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenTSVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	expected := map[string]string{"a.dcm": "good", "legacy.dcm": "good"}
+	if got := annotationValues(t, store, "alice"); !sameValues(got, expected) {
+		t.Errorf("After replay, alice had %v, expected %v", got, expected)
+	}
+
+	// The next write brings the flat file back in line with the journal
+	recordTestEvents(t, store, testEvent("alice", "c.dcm", "", "good"))
+
+	flat, err := OpenOrCreateAnnotationFile(UserAnnotationPath(path, "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected["c.dcm"] = "good"
+	got := make(map[string]string, len(flat))
+	for k, v := range flat {
+		got[string(k)] = v.Value
+	}
+	if !sameValues(got, expected) {
+		t.Errorf("Flat file held %v after the next write, expected %v", got, expected)
+	}
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "annotations.tsv")
+
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in the way makes the final rename fail
+	blocked := filepath.Join(dir, "blocked.tsv")
+	if err := os.Mkdir(blocked, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name  string
+		Path  string
+		Write func(w io.Writer) error
+	}{
+		{"Write fails partway", path, func(w io.Writer) error {
+			if _, err := w.Write([]byte("partial")); err != nil {
+				return err
+			}
+			return fmt.Errorf("disk full")
+		}},
+		{"Rename fails", blocked, func(w io.Writer) error {
+			_, err := w.Write([]byte("new\n"))
+			return err
+		}},
+	}
+
+	for _, c := range cases {
+		if err := writeFileAtomically(c.Path, c.Write); err == nil {
+			t.Errorf("%s: expected an error", c.Name)
+		}
+
+		if contents, err := os.ReadFile(path); err != nil || string(contents) != "old\n" {
+			t.Errorf("%s: file held %q (err: %v), expected the old contents", c.Name, contents, err)
+		}
+
+		if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp*")); len(matches) > 0 {
+			t.Errorf("%s: temporary files were left behind: %v", c.Name, matches)
+		}
+	}
+
+	if err := writeFileAtomically(path, func(w io.Writer) error {
+		_, err := w.Write([]byte("new\n"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := os.ReadFile(path); err != nil || string(contents) != "new\n" {
+		t.Errorf("After a successful write, file held %q (err: %v)", contents, err)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// TSVStore keeps each user's current annotations in a flat file, like
+// annotations.tsv or annotations.<user>.tsv, and every event in an append-only
+// journal at annotations.tsv.history. The journal is synced before the flat
+// file is atomically replaced, and is replayed over the flat files when they
+// are read, so the journal wins if the two disagree after a crash.
+type TSVStore struct {
+	OutputPath  string
+	HistoryPath string
+
+	m       sync.Mutex
+	journal *os.File
+	events  []AnnotationEvent
+	undone  map[int64]struct{}
+	current map[string]map[DicomFilename]Annotation
+}
+
+func OpenTSVStore(outputPath string) (*TSVStore, error) {
+	s := &TSVStore{
+		OutputPath:  outputPath,
+		HistoryPath: outputPath + ".history",
+		undone:      make(map[int64]struct{}),
+		current:     make(map[string]map[DicomFilename]Annotation),
+	}
+
+	if err := CreateFileAndPath(s.HistoryPath); err != nil {
+		return nil, err
+	}
+
+	if err := s.readJournal(); err != nil {
+		return nil, err
+	}
+
+	journal, err := os.OpenFile(s.HistoryPath, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+
+	// A crash may have left a partial final line, which must not be joined to
+	// the next event.
+	if info, err := journal.Stat(); err != nil {
+		journal.Close()
+		return nil, err
+	} else if info.Size() == 0 {
+		if err := s.appendJournal(historyHeader); err != nil {
+			journal.Close()
+			return nil, err
+		}
+	} else if lastByte, err := readLastByte(s.HistoryPath); err != nil {
+		journal.Close()
+		return nil, err
+	} else if lastByte != '\n' {
+		if _, err := journal.Write([]byte("\n")); err != nil {
+			journal.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func readLastByte(path string) (byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	b := make([]byte, 1)
+	if _, err := f.Seek(-1, io.SeekEnd); err != nil {
+		return 0, err
+	}
+	if _, err := f.Read(b); err != nil {
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+func (s *TSVStore) readJournal() error {
+	f, err := os.Open(s.HistoryPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(bufio.NewReader(f))
+	cr.Comma = '\t'
+	cr.FieldsPerRecord = -1
+
+	for line := 1; ; line++ {
+		cols, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("Ignoring unreadable line %d of %s: %v\n", line, s.HistoryPath, err)
+			continue
+		}
+
+		if line == 1 && len(cols) > 0 && cols[0] == historyHeader[0] {
+			continue
+		}
+
+		event, err := parseHistoryColumns(cols)
+		if err != nil {
+			log.Printf("Ignoring unreadable line %d of %s: %v\n", line, s.HistoryPath, err)
+			continue
+		}
+
+		s.events = append(s.events, event)
+		if event.Undoes != 0 {
+			s.undone[event.Undoes] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+func (s *TSVStore) appendJournal(cols []string) error {
+	cw := csv.NewWriter(s.journal)
+	cw.Comma = '\t'
+	if err := cw.Write(cols); err != nil {
+		return err
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	return s.journal.Sync()
+}
+
+func (s *TSVStore) userPath(user string) string {
+	if user == "" {
+		return s.OutputPath
+	}
+
+	return UserAnnotationPath(s.OutputPath, user)
+}
+
+// load reads the user's flat file and replays the journal over it. Must be
+// called with the lock held.
+func (s *TSVStore) load(user string) (map[DicomFilename]Annotation, error) {
+	if annotations, exists := s.current[user]; exists {
+		return annotations, nil
+	}
+
+	annotations, err := OpenOrCreateAnnotationFile(s.userPath(user))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, event := range s.events {
+		if event.User == user {
+			applyEvent(annotations, event)
+		}
+	}
+
+	s.current[user] = annotations
+
+	return annotations, nil
+}
+
+func applyEvent(annotations map[DicomFilename]Annotation, event AnnotationEvent) {
+	if event.NewValue == "" {
+		delete(annotations, DicomFilename(event.Dicom))
+		return
+	}
+
+	annotations[DicomFilename(event.Dicom)] = Annotation{
+		Dicom:    event.Dicom,
+		SampleID: event.SampleID,
+		Value:    event.NewValue,
+		Date:     event.Date,
+		Path:     event.Path,
+	}
+}
+
+func (s *TSVStore) Users() ([]string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	seen := make(map[string]struct{})
+
+	users, err := FindUserAnnotationFiles(s.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		seen[user] = struct{}{}
+	}
+	for _, event := range s.events {
+		seen[event.User] = struct{}{}
+	}
+
+	if _, exists := seen[""]; !exists {
+		if annotations, err := s.load(""); err != nil {
+			return nil, err
+		} else if len(annotations) > 0 {
+			seen[""] = struct{}{}
+		}
+	}
+
+	out := make([]string, 0, len(seen))
+	for user := range seen {
+		out = append(out, user)
+	}
+	sort.Strings(out)
+
+	return out, nil
+}
+
+func (s *TSVStore) Annotations(user string) (map[DicomFilename]Annotation, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	annotations, err := s.load(user)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[DicomFilename]Annotation, len(annotations))
+	for k, v := range annotations {
+		out[k] = v
+	}
+
+	return out, nil
+}
+
+func (s *TSVStore) Record(event *AnnotationEvent) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	annotations, err := s.load(event.User)
+	if err != nil {
+		return err
+	}
+
+	event.ID = 1
+	if len(s.events) > 0 {
+		event.ID = s.events[len(s.events)-1].ID + 1
+	}
+
+	if err := s.appendJournal(event.columns()); err != nil {
+		return fmt.Errorf("Could not record annotation event: %v", err)
+	}
+
+	s.events = append(s.events, *event)
+	if event.Undoes != 0 {
+		s.undone[event.Undoes] = struct{}{}
+	}
+	applyEvent(annotations, *event)
+
+	return writeFileAtomically(s.userPath(event.User), func(w io.Writer) error {
+		if _, err := fmt.Fprintf(w, "sample_id\tdicom\tvalue\tdate\tpath\n"); err != nil {
+			return err
+		}
+
+		for _, v := range sortedAnnotations(annotations) {
+			if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", v.SampleID, v.Dicom, v.Value, v.Date, v.Path); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *TSVStore) LastUndoable(user string) (AnnotationEvent, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for i := len(s.events) - 1; i >= 0; i-- {
+		event := s.events[i]
+		if event.User != user || event.Undoes != 0 {
+			continue
+		}
+		if _, undone := s.undone[event.ID]; undone {
+			continue
+		}
+
+		return event, true, nil
+	}
+
+	return AnnotationEvent{}, false, nil
+}
+
+func (s *TSVStore) History() ([]AnnotationEvent, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	out := make([]AnnotationEvent, len(s.events))
+	copy(out, s.events)
+
+	return out, nil
+}
+
+func (s *TSVStore) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.journal.Close()
+}
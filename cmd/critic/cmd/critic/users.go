@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const tokenCookieName = "critic_token"
+
+var unsafeUsernameChars = regexp.MustCompile(`[^A-Za-z0-9@._-]+`)
+
+// ParseUserTokenFile reads a headerless, tab-delimited file whose first column
+// is a login token and whose second column is the username that it belongs to.
+func ParseUserTokenFile(input io.Reader) (map[string]string, error) {
+	cr := csv.NewReader(input)
+	cr.Comma = '\t'
+	cr.FieldsPerRecord = -1
+
+	tokens := make(map[string]string)
+	for line := 1; ; line++ {
+		cols, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(cols) < 2 || cols[0] == "" || SanitizeUsername(cols[1]) == "" {
+			return nil, fmt.Errorf("Line %d: expected a token and a username", line)
+		}
+
+		if _, exists := tokens[cols[0]]; exists {
+			return nil, fmt.Errorf("Line %d: token is used more than once", line)
+		}
+
+		tokens[cols[0]] = SanitizeUsername(cols[1])
+	}
+
+	return tokens, nil
+}
+
+// SanitizeUsername reduces a username to characters that are safe to use in a
+// filename. The sanitized name is the user's namespace.
+func SanitizeUsername(user string) string {
+	return strings.Trim(unsafeUsernameChars.ReplaceAllString(strings.TrimSpace(user), "_"), ".")
+}
+
+// UserAnnotationPath places each user's annotations next to the main output
+// file: annotations.tsv becomes annotations.<user>.tsv.
+func UserAnnotationPath(outputPath, user string) string {
+	ext := filepath.Ext(outputPath)
+
+	return strings.TrimSuffix(outputPath, ext) + "." + user + ext
+}
+
+// FindUserAnnotationFiles lists the users who already have an annotation file
+// next to the main output file.
+func FindUserAnnotationFiles(outputPath string) ([]string, error) {
+	ext := filepath.Ext(outputPath)
+	prefix := strings.TrimSuffix(outputPath, ext) + "."
+
+	matches, err := filepath.Glob(globEscape(prefix) + "*" + globEscape(ext))
+	if err != nil {
+		return nil, err
+	}
+
+	var users []string
+	for _, match := range matches {
+		user := strings.TrimSuffix(strings.TrimPrefix(match, prefix), ext)
+		if user == "" || user != SanitizeUsername(user) {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	sort.Strings(users)
+
+	return users, nil
+}
+
+func globEscape(path string) string {
+	return strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`).Replace(path)
+}
+
+// CurrentUser identifies the user making the request. When critic is not
+// running with multiple users, this is always the empty string. With -users, a
+// token is accepted as a bearer token, from a cookie, or once from the "token"
+// query parameter, after which it is stored in a cookie. With -user-header, the
+// username is taken from a header set by an authenticating proxy.
+func (h *handler) CurrentUser(w http.ResponseWriter, r *http.Request) (string, error) {
+	if !h.Global.MultiUser() {
+		return "", nil
+	}
+
+	if h.Global.UserHeader != "" {
+		if user := SanitizeUsername(r.Header.Get(h.Global.UserHeader)); user != "" {
+			return user, nil
+		}
+	}
+
+	if len(h.Global.Tokens) > 0 {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			if user, exists := h.Global.Tokens[strings.TrimPrefix(auth, "Bearer ")]; exists {
+				return user, nil
+			}
+		}
+
+		if token := r.URL.Query().Get("token"); token != "" {
+			if user, exists := h.Global.Tokens[token]; exists {
+				http.SetCookie(w, &http.Cookie{
+					Name:     tokenCookieName,
+					Value:    token,
+					Path:     "/",
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+				return user, nil
+			}
+		}
+
+		if cookie, err := r.Cookie(tokenCookieName); err == nil {
+			if user, exists := h.Global.Tokens[cookie.Value]; exists {
+				return user, nil
+			}
+		}
+
+		return "", fmt.Errorf("Please log in by visiting this site with ?token=<your token> appended to the URL")
+	}
+
+	return "", fmt.Errorf("No username was found in the %s header", h.Global.UserHeader)
+}
@@ -16,6 +16,7 @@ import (
 
 	"cloud.google.com/go/storage"
 	_ "github.com/carbocation/genomisc/compileinfoprint"
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
 )
 
 const (
@@ -34,7 +35,16 @@ var (
 	ImagePositionPatientXColumn = "image_x"
 	ImagePositionPatientYColumn = "image_y"
 	ImagePositionPatientZColumn = "image_z"
-	makeGIF                     = false
+	movieFormat                 = MovieMP4
+
+	// Settings for multi-planar reformatting. If reformatPlanes is empty, no
+	// reformatted views are made.
+	reformatPlanes     []plane
+	reformatProjection = ProjectionMaximum
+	reformatSlab       float64
+	reformatOffset     float64
+	reformatVoxel      = 2.0
+	onlyReformat       bool
 )
 
 // Safe for concurrent use by multiple goroutines so we'll make this a global
@@ -44,8 +54,8 @@ func main() {
 	// go tool pprof --http=localhost:6060 ~/go/bin/dicom-mip PPROF_OUTPUT_FILE
 	// defer profile.Start(profile.CPUProfile).Stop()
 
-	var doNotSort, batch bool
-	var manifest, folder string
+	var doNotSort, batch, makeGIF bool
+	var manifest, folder, planes string
 	flag.StringVar(&manifest, "manifest", "", "Path to manifest file")
 	flag.StringVar(&folder, "folder", "", "Path to google storage folder that contains zip files.")
 	flag.StringVar(&DicomColumnName, "dicom_column_name", "dicom_file", "Name of the column in the manifest with the dicoms.")
@@ -60,7 +70,14 @@ func main() {
 	flag.StringVar(&SeriesNumberColumName, "series_number_column_name", "series_number", "(Optional) Name of the column that indicates the series number of the images, useful for colorizing different acquisitions.")
 	flag.BoolVar(&doNotSort, "donotsort", false, "Pass this if you do not want to sort the manifest (i.e., you've already sorted it)")
 	flag.BoolVar(&batch, "batch", false, "Pass this if you want to run in batch mode.")
-	flag.BoolVar(&makeGIF, "makegif", false, "Pass this if you want to make a gif of the MIPs instead of an mp4.")
+	flag.BoolVar(&makeGIF, "makegif", false, "Pass this if you want to make a gif of the MIPs instead of an mp4. (Equivalent to -format gif.)")
+	flag.StringVar(&movieFormat, "format", MovieMP4, "Format of the videos of the MIPs: 'mp4' (requires ffmpeg), or, without external programs, 'gif' (256 colors), 'apng' (lossless animated PNG), or 'avi' (motion JPEG).")
+	flag.StringVar(&planes, "reformat", "", "(Optional) Comma-separated planes for multi-planar reformatting of the stitched, isotropically resampled volume: axial, coronal, sagittal, and/or an oblique plane given by its normal vector as x:y:z (e.g., 1:1:0).")
+	flag.StringVar(&reformatProjection, "projection", ProjectionMaximum, "For -reformat: projection through each slab. Options include 'mip' (maximum intensity), 'minip' (minimum intensity), and 'average'.")
+	flag.Float64Var(&reformatSlab, "slab", 0, "For -reformat: slab thickness in mm. 0 projects through the whole volume; a value below the voxel size gives a single interpolated slice.")
+	flag.Float64Var(&reformatOffset, "slaboffset", 0, "For -reformat: distance in mm along the plane normal from the center of the volume to the center of the slab.")
+	flag.Float64Var(&reformatVoxel, "voxel", 2.0, "For -reformat: size in mm of the isotropic voxels to which the volume is resampled.")
+	flag.BoolVar(&onlyReformat, "onlyreformat", false, "For -reformat: skip the default coronal and sagittal projections and videos.")
 	flag.Parse()
 
 	if manifest == "" || folder == "" {
@@ -68,6 +85,31 @@ func main() {
 		os.Exit(1)
 	}
 
+	if makeGIF {
+		movieFormat = bulkprocess.AnimationGIF
+	}
+	if movieFormat != MovieMP4 {
+		if _, err := bulkprocess.AnimationExtension(movieFormat); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if planes != "" {
+		for _, v := range strings.Split(planes, ",") {
+			p, err := parsePlane(v)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			reformatPlanes = append(reformatPlanes, p)
+		}
+
+		if reformatProjection != ProjectionMaximum && reformatProjection != ProjectionMinimum && reformatProjection != ProjectionAverage {
+			log.Fatalf("Unrecognized -projection %q\n", reformatProjection)
+		}
+	} else if onlyReformat {
+		log.Fatalln("-onlyreformat requires -reformat")
+	}
+
 	folder = strings.TrimSuffix(folder, "/")
 
 	// Initialize the Google Storage client, but only if our folder indicates
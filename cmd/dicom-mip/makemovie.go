@@ -2,7 +2,6 @@ package main
 
 import (
 	"image"
-	"image/gif"
 	"os"
 
 	"github.com/carbocation/genomisc/ukbb/bulkprocess"
@@ -10,21 +9,44 @@ import (
 	"github.com/unixpickle/ffmpego"
 )
 
-func makeOneGIF(sortedImages []image.Image, outName string, delay int, withTransparency bool) error {
-	outGIF, err := bulkprocess.MakeOneGIF(sortedImages, delay, withTransparency)
-	if err != nil {
-		return pfx.Err(err)
+// MovieMP4 is encoded by ffmpeg; the other movie formats are the pure-Go
+// animation formats from bulkprocess.
+const MovieMP4 = "mp4"
+
+func movieExtension() string {
+	if movieFormat == MovieMP4 {
+		return ".mp4"
+	}
+
+	// The format was validated at startup.
+	extension, _ := bulkprocess.AnimationExtension(movieFormat)
+	return extension
+}
+
+// makeOneMovie writes the images in movieFormat. MP4s play at fps frames per
+// second; the other formats wait delay hundredths of a second between frames.
+func makeOneMovie(sortedImages []image.Image, outName string, fps float64, delay int) error {
+	if movieFormat == MovieMP4 {
+		return makeOneMPEG(sortedImages, outName, fps)
 	}
 
+	return makeOneAnimation(sortedImages, outName, movieFormat, delay, false)
+}
+
+func makeOneAnimation(sortedImages []image.Image, outName, format string, delay int, withTransparency bool) error {
 	// Save file
-	f, err := os.OpenFile(outName, os.O_WRONLY|os.O_CREATE, 0600)
+	f, err := os.Create(outName)
 	if err != nil {
 		return pfx.Err(err)
 	}
 
 	defer f.Close()
 
-	return pfx.Err(gif.EncodeAll(f, outGIF))
+	if err := bulkprocess.EncodeAnimation(f, format, sortedImages, delay, withTransparency); err != nil {
+		return pfx.Err(err)
+	}
+
+	return pfx.Err(f.Close())
 }
 
 func makeOneMPEG(sortedImages []image.Image, outName string, fps float64) error {
@@ -64,6 +64,18 @@ func processEntries(entries []manifestEntry, key manifestKey, folder string, doN
 		fmt.Printf("Found %d zip files+series combinations. Summary images and movies will be created for each.\n", len(zipSeriesMap))
 	}
 
+	if len(reformatPlanes) > 0 {
+		for zip, pngData := range zipSeriesMap {
+			if err := makeReformats(zip, zipMap[zip.Zip], pngData, beVerbose); err != nil {
+				return err
+			}
+		}
+
+		if onlyReformat {
+			return nil
+		}
+	}
+
 	// For now, doing one zip at a time
 	errchan := make(chan error)
 
@@ -102,10 +114,7 @@ func processEntries(entries []manifestEntry, key manifestKey, folder string, doN
 		// First create a sagittal MIP, then use its width in pixels to
 		// determine the number of frames for the GIF of the coronal MIP:
 		go func(zip seriesMap, imgMap map[string]image.Image, pngData []manifestEntry) {
-			outName := zip.Zip + "_" + zip.Series + ".coronal.mp4"
-			if makeGIF {
-				outName = zip.Zip + "_" + zip.Series + ".coronal.gif"
-			}
+			outName := zip.Zip + "_" + zip.Series + ".coronal" + movieExtension()
 
 			_, canvasDepth, _, _, _, _ := findCanvasAndOffsets(pngData, imgMap)
 
@@ -137,21 +146,14 @@ func processEntries(entries []manifestEntry, key manifestKey, folder string, doN
 				}
 			}
 
-			if makeGIF {
-				errchan <- makeOneGIF(imgList, outName, 2, false)
-			} else {
-				errchan <- makeOneMPEG(imgList, outName, 20)
-			}
+			errchan <- makeOneMovie(imgList, outName, 20, 2)
 
 		}(zip, imgMap, pngData)
 
 		// First create a coronal MIP, then use its width in pixels to
 		// determine the number of frames for the GIF of the sagittal MIP:
 		go func(zip seriesMap, imgMap map[string]image.Image, pngData []manifestEntry) {
-			outName := zip.Zip + "_" + zip.Series + ".sagittal.mp4"
-			if makeGIF {
-				outName = zip.Zip + "_" + zip.Series + ".sagittal.gif"
-			}
+			outName := zip.Zip + "_" + zip.Series + ".sagittal" + movieExtension()
 
 			im, err := canvasMakeOneCoronalMIPFromImageMapNonsquare(pngData, imgMap, AverageIntensity, 0)
 			if err != nil {
@@ -183,11 +185,7 @@ func processEntries(entries []manifestEntry, key manifestKey, folder string, doN
 				}
 			}
 
-			if makeGIF {
-				errchan <- makeOneGIF(imgList, outName, 4, false)
-			} else {
-				errchan <- makeOneMPEG(imgList, outName, 10)
-			}
+			errchan <- makeOneMovie(imgList, outName, 10, 4)
 
 		}(zip, imgMap, pngData)
 	}
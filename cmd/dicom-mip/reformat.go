@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Projections through a slab of the volume.
+const (
+	ProjectionMaximum = "mip"
+	ProjectionMinimum = "minip"
+	ProjectionAverage = "average"
+)
+
+// volume is a block of isotropic voxels in patient coordinates (mm). Voxel
+// (i, j, k) is centered at Origin + Spacing*(i, j, k); i runs along the
+// patient X axis, j along Y, and k along Z.
+type volume struct {
+	NX, NY, NZ int
+	Spacing    float64
+	Origin     [3]float64
+	Data       []float32
+}
+
+func (v *volume) at(i, j, k int) float32 {
+	return v.Data[(k*v.NY+j)*v.NX+i]
+}
+
+// sample trilinearly interpolates the volume at a point in patient
+// coordinates. It returns false if the point lies outside of the volume.
+func (v *volume) sample(p [3]float64) (float64, bool) {
+	fx := (p[0] - v.Origin[0]) / v.Spacing
+	fy := (p[1] - v.Origin[1]) / v.Spacing
+	fz := (p[2] - v.Origin[2]) / v.Spacing
+	if fx < 0 || fy < 0 || fz < 0 || fx > float64(v.NX-1) || fy > float64(v.NY-1) || fz > float64(v.NZ-1) {
+		return 0, false
+	}
+
+	i0, j0, k0 := int(fx), int(fy), int(fz)
+	i1, j1, k1 := minInt(i0+1, v.NX-1), minInt(j0+1, v.NY-1), minInt(k0+1, v.NZ-1)
+	dx, dy, dz := fx-float64(i0), fy-float64(j0), fz-float64(k0)
+
+	c00 := float64(v.at(i0, j0, k0))*(1-dx) + float64(v.at(i1, j0, k0))*dx
+	c10 := float64(v.at(i0, j1, k0))*(1-dx) + float64(v.at(i1, j1, k0))*dx
+	c01 := float64(v.at(i0, j0, k1))*(1-dx) + float64(v.at(i1, j0, k1))*dx
+	c11 := float64(v.at(i0, j1, k1))*(1-dx) + float64(v.at(i1, j1, k1))*dx
+
+	c0 := c00*(1-dy) + c10*dy
+	c1 := c01*(1-dy) + c11*dy
+
+	return c0*(1-dz) + c1*dz, true
+}
+
+// corners are the centers of the 8 corner voxels, in patient coordinates.
+func (v *volume) corners() [][3]float64 {
+	out := make([][3]float64, 0, 8)
+	for _, i := range []int{0, v.NX - 1} {
+		for _, j := range []int{0, v.NY - 1} {
+			for _, k := range []int{0, v.NZ - 1} {
+				out = append(out, [3]float64{
+					v.Origin[0] + float64(i)*v.Spacing,
+					v.Origin[1] + float64(j)*v.Spacing,
+					v.Origin[2] + float64(k)*v.Spacing,
+				})
+			}
+		}
+	}
+
+	return out
+}
+
+// sourceSlice is one 2D image placed in patient coordinates. As elsewhere in
+// this program, images are assumed to be axial, with columns running along X
+// and rows along Y.
+type sourceSlice struct {
+	img    *image.Gray16
+	x0, y0 float64
+	dx, dy float64
+	z      float64
+}
+
+// sample bilinearly interpolates the slice at (x, y).
+func (s sourceSlice) sample(x, y float64) (float64, bool) {
+	fx := (x - s.x0) / s.dx
+	fy := (y - s.y0) / s.dy
+	b := s.img.Bounds()
+	if fx < 0 || fy < 0 || fx > float64(b.Dx()-1) || fy > float64(b.Dy()-1) {
+		return 0, false
+	}
+
+	c0, r0 := int(fx), int(fy)
+	c1, r1 := minInt(c0+1, b.Dx()-1), minInt(r0+1, b.Dy()-1)
+	wx, wy := fx-float64(c0), fy-float64(r0)
+
+	px := func(c, r int) float64 { return float64(s.img.Gray16At(b.Min.X+c, b.Min.Y+r).Y) }
+
+	top := px(c0, r0)*(1-wx) + px(c1, r0)*wx
+	bottom := px(c0, r1)*(1-wx) + px(c1, r1)*wx
+
+	return top*(1-wy) + bottom*wy, true
+}
+
+// buildIsotropicVolume stitches the images of every station (series number)
+// into one volume of cubic voxels of the given size, in mm. Each voxel is
+// interpolated bilinearly within the nearest slices above and below it, and
+// linearly between them, so the result is trilinear in the original grid.
+// Where stations overlap, the slice that is nearest in Z is used. Voxels more
+// than half a slice thickness beyond any slice are left at 0.
+func buildIsotropicVolume(entries []manifestEntry, imgMap map[string]image.Image, spacing float64) (*volume, error) {
+	if spacing <= 0 {
+		return nil, fmt.Errorf("Voxel size must be positive, got %f", spacing)
+	}
+
+	slices := make([]sourceSlice, 0, len(entries))
+	thickness := 0.0
+	xMin, yMin, zMin := math.MaxFloat64, math.MaxFloat64, math.MaxFloat64
+	xMax, yMax, zMax := -math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64
+
+	for _, entry := range entries {
+		img, ok := imgMap[entry.dicom].(*image.Gray16)
+		if !ok {
+			return nil, fmt.Errorf("Image %s was not found or is not 16-bit grayscale", entry.dicom)
+		}
+
+		s := sourceSlice{
+			img: img,
+			x0:  entry.ImagePositionPatientX,
+			y0:  entry.ImagePositionPatientY,
+			dx:  entry.PixelWidthNativeX,
+			dy:  entry.PixelWidthNativeY,
+			z:   entry.ImagePositionPatientZ,
+		}
+
+		// Without pixel dimensions, assume each pixel is 1x1x1.
+		if s.dx <= 0 {
+			s.dx = 1
+		}
+		if s.dy <= 0 {
+			s.dy = 1
+		}
+		dz := entry.PixelWidthNativeZ
+		if dz <= 0 {
+			dz = 1
+		}
+		thickness = math.Max(thickness, dz)
+
+		slices = append(slices, s)
+
+		xMin = math.Min(xMin, s.x0)
+		yMin = math.Min(yMin, s.y0)
+		zMin = math.Min(zMin, s.z)
+		xMax = math.Max(xMax, s.x0+float64(img.Bounds().Dx()-1)*s.dx)
+		yMax = math.Max(yMax, s.y0+float64(img.Bounds().Dy()-1)*s.dy)
+		zMax = math.Max(zMax, s.z)
+	}
+
+	if len(slices) == 0 {
+		return nil, fmt.Errorf("No images were provided")
+	}
+
+	sort.SliceStable(slices, func(i, j int) bool { return slices[i].z < slices[j].z })
+
+	v := &volume{
+		NX:      int(math.Floor((xMax-xMin)/spacing)) + 1,
+		NY:      int(math.Floor((yMax-yMin)/spacing)) + 1,
+		NZ:      int(math.Floor((zMax-zMin)/spacing)) + 1,
+		Spacing: spacing,
+		Origin:  [3]float64{xMin, yMin, zMin},
+	}
+	v.Data = make([]float32, v.NX*v.NY*v.NZ)
+
+	for k := 0; k < v.NZ; k++ {
+		z := zMin + float64(k)*spacing
+
+		// The nearest slices at or below, and at or above, this Z.
+		above := sort.Search(len(slices), func(i int) bool { return slices[i].z >= z })
+		below := above - 1
+		if above < len(slices) && slices[above].z == z {
+			below = above
+		}
+
+		for j := 0; j < v.NY; j++ {
+			y := yMin + float64(j)*spacing
+			for i := 0; i < v.NX; i++ {
+				x := xMin + float64(i)*spacing
+
+				lower, lowerZ, lowerOK := nearestCovering(slices, below, -1, x, y)
+				upper, upperZ, upperOK := nearestCovering(slices, above, 1, x, y)
+
+				var value float64
+				switch {
+				case lowerOK && upperOK && upperZ > lowerZ:
+					w := (z - lowerZ) / (upperZ - lowerZ)
+					value = lower*(1-w) + upper*w
+				case lowerOK && z-lowerZ <= thickness/2:
+					value = lower
+				case upperOK && upperZ-z <= thickness/2:
+					value = upper
+				default:
+					continue
+				}
+
+				v.Data[(k*v.NY+j)*v.NX+i] = float32(value)
+			}
+		}
+	}
+
+	return v, nil
+}
+
+// nearestCovering walks from slices[start] in direction dir to the first slice
+// that covers (x, y) and returns its interpolated value and Z.
+func nearestCovering(slices []sourceSlice, start, dir int, x, y float64) (float64, float64, bool) {
+	for i := start; i >= 0 && i < len(slices); i += dir {
+		if value, ok := slices[i].sample(x, y); ok {
+			return value, slices[i].z, true
+		}
+	}
+
+	return 0, 0, false
+}
+
+// plane orients a reformatted view: pixels run along U (left to right) and V
+// (top to bottom), and projections are taken along Normal. All are unit
+// vectors in patient coordinates.
+type plane struct {
+	Name   string
+	Normal [3]float64
+	U, V   [3]float64
+}
+
+// parsePlane understands axial, coronal, and sagittal, or an oblique plane
+// given by its normal as x:y:z. For coronal, sagittal, and oblique views, V
+// points toward the feet so that the head is at the top of the image.
+func parsePlane(spec string) (plane, error) {
+	spec = strings.TrimSpace(spec)
+
+	switch strings.ToLower(spec) {
+	case "axial":
+		return plane{Name: "axial", Normal: [3]float64{0, 0, 1}, U: [3]float64{1, 0, 0}, V: [3]float64{0, 1, 0}}, nil
+	case "coronal":
+		return plane{Name: "coronal", Normal: [3]float64{0, 1, 0}, U: [3]float64{1, 0, 0}, V: [3]float64{0, 0, -1}}, nil
+	case "sagittal":
+		return plane{Name: "sagittal", Normal: [3]float64{1, 0, 0}, U: [3]float64{0, 1, 0}, V: [3]float64{0, 0, -1}}, nil
+	}
+
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return plane{}, fmt.Errorf("Plane %q is not axial, coronal, sagittal, or a normal vector x:y:z", spec)
+	}
+
+	var n [3]float64
+	for i, v := range parts {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return plane{}, fmt.Errorf("Plane %q: %v", spec, err)
+		}
+		n[i] = f
+	}
+	n, ok := normalize(n)
+	if !ok {
+		return plane{}, fmt.Errorf("Plane %q has a zero normal vector", spec)
+	}
+
+	// V is the direction toward the feet, projected onto the plane. If the
+	// plane is axial, fall back to the Y axis.
+	down := [3]float64{0, 0, -1}
+	v, ok := normalize(sub(down, scale(n, dot(down, n))))
+	if !ok || math.Abs(dot(n, down)) > 0.999 {
+		y := [3]float64{0, 1, 0}
+		v, _ = normalize(sub(y, scale(n, dot(y, n))))
+	}
+	u, _ := normalize(cross(v, n))
+
+	return plane{Name: "oblique_" + strings.Join(parts, "_"), Normal: n, U: u, V: v}, nil
+}
+
+// reformat projects the volume onto a plane. The projection combines samples
+// along the normal within a slab of the given thickness (mm) centered offset mm
+// from the center of the volume along the normal; a thickness of 0 spans the
+// whole volume. A thickness smaller than the voxel size yields a single
+// interpolated slice. Output pixels are the size of a voxel.
+func reformat(v *volume, p plane, projection string, thickness, offset float64) (*image.Gray16, error) {
+	if projection != ProjectionMaximum && projection != ProjectionMinimum && projection != ProjectionAverage {
+		return nil, fmt.Errorf("Unrecognized projection %q", projection)
+	}
+
+	center := [3]float64{
+		v.Origin[0] + float64(v.NX-1)*v.Spacing/2,
+		v.Origin[1] + float64(v.NY-1)*v.Spacing/2,
+		v.Origin[2] + float64(v.NZ-1)*v.Spacing/2,
+	}
+
+	// Extent of the volume along each axis of the plane, relative to the
+	// center.
+	uMin, vMin, nMin := math.MaxFloat64, math.MaxFloat64, math.MaxFloat64
+	uMax, vMax, nMax := -math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64
+	for _, c := range v.corners() {
+		d := sub(c, center)
+		uMin, uMax = math.Min(uMin, dot(d, p.U)), math.Max(uMax, dot(d, p.U))
+		vMin, vMax = math.Min(vMin, dot(d, p.V)), math.Max(vMax, dot(d, p.V))
+		nMin, nMax = math.Min(nMin, dot(d, p.Normal)), math.Max(nMax, dot(d, p.Normal))
+	}
+
+	tFrom, tTo := nMin, nMax
+	if thickness > 0 {
+		tFrom, tTo = offset-thickness/2, offset+thickness/2
+	}
+	steps := int(math.Floor((tTo-tFrom)/v.Spacing)) + 1
+	if steps < 1 {
+		steps = 1
+	}
+	if thickness > 0 && thickness < v.Spacing {
+		tFrom, steps = offset, 1
+	}
+
+	width := int(math.Floor((uMax-uMin)/v.Spacing)) + 1
+	height := int(math.Floor((vMax-vMin)/v.Spacing)) + 1
+
+	values := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			base := add(center, add(scale(p.U, uMin+float64(x)*v.Spacing), scale(p.V, vMin+float64(y)*v.Spacing)))
+
+			n := 0
+			var acc float64
+			for s := 0; s < steps; s++ {
+				value, ok := v.sample(add(base, scale(p.Normal, tFrom+float64(s)*v.Spacing)))
+				if !ok {
+					continue
+				}
+
+				switch {
+				case n == 0:
+					acc = value
+				case projection == ProjectionMaximum:
+					acc = math.Max(acc, value)
+				case projection == ProjectionMinimum:
+					acc = math.Min(acc, value)
+				default:
+					acc += value
+				}
+				n++
+			}
+
+			if n > 0 && projection == ProjectionAverage {
+				acc /= float64(n)
+			}
+
+			values[y*width+x] = acc
+		}
+	}
+
+	out := image.NewGray16(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			out.SetGray16(x, y, color.Gray16{Y: uint16(math.Round(math.Min(values[y*width+x], math.MaxUint16)))})
+		}
+	}
+
+	return out, nil
+}
+
+func add(a, b [3]float64) [3]float64 { return [3]float64{a[0] + b[0], a[1] + b[1], a[2] + b[2]} }
+func sub(a, b [3]float64) [3]float64 { return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]} }
+func scale(a [3]float64, s float64) [3]float64 {
+	return [3]float64{a[0] * s, a[1] * s, a[2] * s}
+}
+func dot(a, b [3]float64) float64 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func normalize(a [3]float64) ([3]float64, bool) {
+	n := math.Sqrt(dot(a, a))
+	if n == 0 {
+		return a, false
+	}
+
+	return scale(a, 1/n), true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// makeReformats resamples all stations of a zip+series to isotropic voxels and
+// saves a projection onto each requested plane.
+func makeReformats(zip seriesMap, imgMap map[string]image.Image, entries []manifestEntry, beVerbose bool) error {
+	vol, err := buildIsotropicVolume(entries, imgMap, reformatVoxel)
+	if err != nil {
+		return err
+	}
+
+	if beVerbose {
+		fmt.Printf("Resampled %s_%s to %dx%dx%d voxels of %.2fmm\n", zip.Zip, zip.Series, vol.NX, vol.NY, vol.NZ, vol.Spacing)
+	}
+
+	suffix := "." + reformatProjection
+	if reformatSlab > 0 {
+		suffix += fmt.Sprintf(".slab%gmm", reformatSlab)
+		if reformatOffset != 0 {
+			suffix += fmt.Sprintf(".offset%gmm", reformatOffset)
+		}
+	}
+
+	for _, p := range reformatPlanes {
+		im, err := reformat(vol, p, reformatProjection, reformatSlab, reformatOffset)
+		if err != nil {
+			return err
+		}
+
+		if err := savePNG(rescaleMaxBright(im), zip.Zip+"_"+zip.Series+"."+p.Name+suffix+".png"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// linearStations builds two overlapping stations of 20x20 axial images with 1x1
+// mm pixels and 3 mm slices, whose intensity is a linear function of position.
+func linearStations(f func(x, y, z float64) float64) ([]manifestEntry, map[string]image.Image) {
+	var entries []manifestEntry
+	imgMap := make(map[string]image.Image)
+
+	for station, zs := range [][]float64{{0, 3, 6, 9, 12}, {10.5, 13.5, 16.5, 19.5}} {
+		for _, z := range zs {
+			name := strconv.Itoa(station) + "_" + strconv.FormatFloat(z, 'f', 1, 64)
+			img := image.NewGray16(image.Rect(0, 0, 20, 20))
+			for r := 0; r < 20; r++ {
+				for c := 0; c < 20; c++ {
+					img.SetGray16(c, r, color.Gray16{Y: uint16(f(10+float64(c), -5+float64(r), z))})
+				}
+			}
+			imgMap[name] = img
+			entries = append(entries, manifestEntry{
+				dicom:                 name,
+				ImagePositionPatientX: 10,
+				ImagePositionPatientY: -5,
+				ImagePositionPatientZ: z,
+				PixelWidthNativeX:     1,
+				PixelWidthNativeY:     1,
+				PixelWidthNativeZ:     3,
+			})
+		}
+	}
+
+	return entries, imgMap
+}
+
+func TestBuildIsotropicVolume(t *testing.T) {
+	f := func(x, y, z float64) float64 { return 100 + 10*x + 20*y + 30*z }
+	entries, imgMap := linearStations(f)
+
+	vol, err := buildIsotropicVolume(entries, imgMap, 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.NX != 13 || vol.NY != 13 || vol.NZ != 14 {
+		t.Fatalf("Expected 13x13x14 voxels, got %dx%dx%d", vol.NX, vol.NY, vol.NZ)
+	}
+
+	// Trilinear interpolation reproduces a linear function, including across
+	// the junction between stations.
+	for _, p := range [][3]float64{{10, -5, 0}, {15.2, 0.7, 4.4}, {20.1, 3.3, 11.9}, {28, 13, 19.5}} {
+		got, ok := vol.sample(p)
+		if want := f(p[0], p[1], p[2]); !ok || math.Abs(got-want) > 1e-2 {
+			t.Errorf("At %v: expected %f, got %f (%v)", p, want, got, ok)
+		}
+	}
+
+	if _, ok := vol.sample([3]float64{9, 0, 0}); ok {
+		t.Errorf("Expected a point outside of the volume to be rejected")
+	}
+}
+
+func TestReformat(t *testing.T) {
+	// Intensity rises along Y, so projections along Y differ by type.
+	vol := &volume{NX: 4, NY: 5, NZ: 3, Spacing: 1}
+	vol.Data = make([]float32, vol.NX*vol.NY*vol.NZ)
+	for k := 0; k < vol.NZ; k++ {
+		for j := 0; j < vol.NY; j++ {
+			for i := 0; i < vol.NX; i++ {
+				vol.Data[(k*vol.NY+j)*vol.NX+i] = float32(10 * (j + 1))
+			}
+		}
+	}
+
+	coronal, err := parsePlane("coronal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []struct {
+		projection string
+		slab       float64
+		want       uint16
+	}{
+		{ProjectionMaximum, 0, 50},
+		{ProjectionMinimum, 0, 10},
+		{ProjectionAverage, 0, 30},
+		{ProjectionMaximum, 2, 40},
+		{ProjectionAverage, 0.5, 30},
+	} {
+		im, err := reformat(vol, coronal, v.projection, v.slab, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if im.Bounds().Dx() != 4 || im.Bounds().Dy() != 3 {
+			t.Fatalf("Expected a 4x3 coronal image, got %v", im.Bounds())
+		}
+		if got := im.Gray16At(1, 1).Y; got != v.want {
+			t.Errorf("%s with slab %f: expected %d, got %d", v.projection, v.slab, v.want, got)
+		}
+	}
+
+	// An oblique plane tilted 45 degrees about Z has unit vectors in the
+	// plane.
+	oblique, err := parsePlane("1:1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, axis := range [][3]float64{oblique.Normal, oblique.U, oblique.V} {
+		if math.Abs(dot(axis, axis)-1) > 1e-9 {
+			t.Errorf("Expected unit vectors, got %v", axis)
+		}
+	}
+	if math.Abs(dot(oblique.U, oblique.Normal)) > 1e-9 || math.Abs(dot(oblique.V, oblique.Normal)) > 1e-9 || oblique.V[2] != -1 {
+		t.Errorf("Unexpected oblique axes %+v", oblique)
+	}
+}
@@ -1,3 +1,12 @@
 // dicom2las converts DICOM files to LAS files (LASer format), which is a
 // [format for point clouds](https://en.wikipedia.org/wiki/LAS_file_format).
+// With -format, the point cloud can instead be written as a PLY or a PCD (Point
+// Cloud Library) file.
+//
+// With -mesh, dicom2las also reads the segmentation mask of each DICOM (in the
+// format of the github.com/carbocation/genomisc/overlay package), stacks them
+// into a labeled volume, and extracts a closed triangle mesh of each label by
+// marching cubes. Meshes are in patient coordinates (mm) and are written as
+// STL (e.g., for 3D printing), OBJ, or PLY files. They can be smoothed (-smooth,
+// optionally with -taubin to avoid shrinkage) and simplified (-decimate).
 package main
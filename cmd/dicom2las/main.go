@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"cloud.google.com/go/storage"
 	_ "github.com/carbocation/genomisc/compileinfoprint"
+	"github.com/carbocation/genomisc/overlay"
 )
 
 const (
@@ -28,6 +30,21 @@ var (
 	ImagePositionPatientXColumn = "image_x"
 	ImagePositionPatientYColumn = "image_y"
 	ImagePositionPatientZColumn = "image_z"
+	ImageOrientationColumn      = "image_orientation"
+
+	// Point cloud output format; see the PointCloud constants.
+	pointCloudFormat = PointCloudLAS
+
+	// Settings for surface meshes. If meshFormat is empty, no meshes are made.
+	meshFormat       string
+	maskFolder       string
+	maskSuffix       = ".png.mask.png"
+	meshLabels       []uint32
+	meshConfig       overlay.JSONConfig
+	smoothIterations int
+	smoothLambda     = 0.5
+	smoothTaubin     bool
+	decimateFraction = 1.0
 )
 
 // Safe for concurrent use by multiple goroutines so we'll make this a global
@@ -38,7 +55,7 @@ func main() {
 	// defer profile.Start(profile.CPUProfile).Stop()
 
 	var doNotSort, batch bool
-	var manifest, folder string
+	var manifest, folder, labels, jsonConfig string
 	flag.StringVar(&manifest, "manifest", "", "Path to manifest file")
 	flag.StringVar(&folder, "folder", "", "Path to google storage folder that contains zip files.")
 	flag.StringVar(&DicomColumnName, "dicom_column_name", "dicom_file", "Name of the column in the manifest with the dicoms.")
@@ -52,6 +69,17 @@ func main() {
 	flag.StringVar(&SeriesColumnName, "series_column_name", "sample_id", "Name of the column that indicates the series of the images.")
 	flag.BoolVar(&doNotSort, "donotsort", false, "Pass this if you do not want to sort the manifest (i.e., you've already sorted it)")
 	flag.BoolVar(&batch, "batch", false, "Pass this if you want to run in batch mode.")
+	flag.StringVar(&ImageOrientationColumn, "image_orientation", "image_orientation", "(Optional) Name of the column in the manifest with the ImageOrientationPatient direction cosines (6 values separated by backslashes, commas, or spaces). If absent, images are assumed to be axial.")
+	flag.StringVar(&pointCloudFormat, "format", PointCloudLAS, "Point cloud format: 'las', 'ply', 'pcd', or 'none' (e.g., if you only want meshes).")
+	flag.StringVar(&meshFormat, "mesh", "", "(Optional) Extract a surface mesh of each label in the segmentation masks by marching cubes, in patient coordinates (mm). Options include 'stl', 'obj', and 'ply'.")
+	flag.StringVar(&maskFolder, "maskfolder", "", "(Required for -mesh) Path to the local or google storage (gs://) folder that contains the segmentation masks, in the format of the github.com/carbocation/genomisc/overlay package.")
+	flag.StringVar(&maskSuffix, "masksuffix", ".png.mask.png", "For -mesh: suffix placed after the dicom name to find its mask.")
+	flag.StringVar(&labels, "meshlabels", "", "For -mesh: comma-separated label IDs to mesh. By default, every nonzero label in the masks is meshed.")
+	flag.StringVar(&jsonConfig, "config", "", "For -mesh: (Optional) JSONConfig file from the github.com/carbocation/genomisc/overlay package, used to name the mesh files after their labels.")
+	flag.IntVar(&smoothIterations, "smooth", 0, "For -mesh: number of iterations of Laplacian smoothing. 0 disables smoothing.")
+	flag.Float64Var(&smoothLambda, "smoothlambda", 0.5, "For -mesh: fraction of the way each vertex moves toward the mean of its neighbors in each smoothing iteration.")
+	flag.BoolVar(&smoothTaubin, "taubin", false, "For -mesh: follow each smoothing step with an inflating step (Taubin smoothing), which avoids shrinking the mesh.")
+	flag.Float64Var(&decimateFraction, "decimate", 1.0, "For -mesh: fraction of triangles to keep after simplifying the mesh by quadric edge collapse. 1 disables decimation.")
 	flag.Parse()
 
 	if manifest == "" || folder == "" {
@@ -59,11 +87,51 @@ func main() {
 		os.Exit(1)
 	}
 
+	switch pointCloudFormat {
+	case PointCloudLAS, PointCloudPLY, PointCloudPCD, PointCloudNone:
+	default:
+		log.Fatalf("Unrecognized -format %q\n", pointCloudFormat)
+	}
+
+	if meshFormat != "" {
+		switch meshFormat {
+		case MeshSTL, MeshOBJ, MeshPLY:
+		default:
+			log.Fatalf("Unrecognized -mesh %q\n", meshFormat)
+		}
+
+		if maskFolder == "" {
+			log.Fatalln("-mesh requires -maskfolder")
+		}
+		maskFolder = strings.TrimSuffix(maskFolder, "/")
+
+		for _, v := range strings.Split(labels, ",") {
+			if strings.TrimSpace(v) == "" {
+				continue
+			}
+			id, err := strconv.ParseUint(strings.TrimSpace(v), 10, 32)
+			if err != nil {
+				log.Fatalf("Could not parse -meshlabels: %v\n", err)
+			}
+			meshLabels = append(meshLabels, uint32(id))
+		}
+
+		if jsonConfig != "" {
+			var err error
+			meshConfig, err = overlay.ParseJSONConfigFromPath(jsonConfig)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+	} else if pointCloudFormat == PointCloudNone {
+		log.Fatalln("-format none requires -mesh")
+	}
+
 	folder = strings.TrimSuffix(folder, "/")
 
 	// Initialize the Google Storage client, but only if our folder indicates
 	// that we are pointing to a Google Storage path.
-	if strings.HasPrefix(folder, "gs://") {
+	if strings.HasPrefix(folder, "gs://") || strings.HasPrefix(maskFolder, "gs://") {
 		var err error
 		client, err = storage.NewClient(context.Background())
 		if err != nil {
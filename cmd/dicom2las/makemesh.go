@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// makeMeshes extracts a surface mesh for each label in the masks of the
+// entries, and writes it to outPrefix.<label>.<meshFormat>.
+func makeMeshes(dicomEntries []manifestEntry, outPrefix string) error {
+	v, err := buildMaskVolume(dicomEntries, maskFolder, maskSuffix)
+	if err != nil {
+		return err
+	}
+
+	labels := meshLabels
+	if len(labels) == 0 {
+		labels = v.presentLabels()
+	}
+
+	names := make(map[uint32]string)
+	for _, label := range meshConfig.Labels.Sorted() {
+		names[uint32(label.ID)] = strings.ReplaceAll(label.Label, " ", "_")
+	}
+
+	for _, id := range labels {
+		m := marchingCubes(v, id)
+		if len(m.Triangles) == 0 {
+			log.Printf("%s: label %d is not present in the masks\n", outPrefix, id)
+			continue
+		}
+
+		if smoothIterations > 0 {
+			m.smooth(smoothIterations, smoothLambda, smoothTaubin)
+		}
+		m.decimate(decimateFraction)
+
+		name, exists := names[id]
+		if !exists {
+			name = fmt.Sprintf("label%d", id)
+		}
+
+		outName := outPrefix + "." + name + "." + meshFormat
+		if err := writeMeshFile(m, meshFormat, outName); err != nil {
+			return err
+		}
+
+		log.Printf("Wrote %d triangles enclosing %.1f mL to %s\n", len(m.Triangles), m.signedVolume()/1000, outName)
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+// Marching cubes over a binary (inside/outside) voxel grid. Rather than
+// carrying the usual 256-entry triangle table as a literal, the table is
+// derived at startup from the corner states: on every face of the cube, the
+// crossed edges are joined by segments that keep the inside corners on their
+// right when viewed from outside the cube, and the segments are chained into
+// loops that are then triangulated. On ambiguous faces (two diagonally
+// opposite inside corners), the inside corners are always kept apart. Because
+// each face is handled the same way from both of the cubes that share it, the
+// resulting surface has no cracks and is consistently oriented, with normals
+// pointing out of the labeled region.
+
+// cubeCorners are the (x, y, z) offsets of the 8 corners of a cube; corner c
+// is at (c&1, c>>1&1, c>>2&1).
+var cubeCorners [8][3]int
+
+// cubeEdges are the 12 edges of a cube, as pairs of corners. The first corner
+// is the one nearer the origin, and the edge runs along axis cubeEdgeAxis.
+var cubeEdges [12][2]int
+var cubeEdgeAxis [12]int
+
+// mcTriangles holds, for each of the 256 cube configurations, the triangles as
+// triples of edge indices.
+var mcTriangles [256][][3]int
+
+func init() {
+	for c := range cubeCorners {
+		cubeCorners[c] = [3]int{c & 1, c >> 1 & 1, c >> 2 & 1}
+	}
+
+	n := 0
+	for a := 0; a < 8; a++ {
+		for axis := 0; axis < 3; axis++ {
+			if a>>axis&1 == 0 {
+				cubeEdges[n] = [2]int{a, a | 1<<axis}
+				cubeEdgeAxis[n] = axis
+				n++
+			}
+		}
+	}
+
+	for config := range mcTriangles {
+		mcTriangles[config] = cubeTriangles(config)
+	}
+}
+
+// cubeEdgeIndex returns the edge joining corners a and b.
+func cubeEdgeIndex(a, b int) int {
+	if a > b {
+		a, b = b, a
+	}
+	for e, v := range cubeEdges {
+		if v[0] == a && v[1] == b {
+			return e
+		}
+	}
+
+	panic("corners do not share an edge")
+}
+
+func cubeEdgeMidpoint(e int) [3]float64 {
+	a, b := cubeCorners[cubeEdges[e][0]], cubeCorners[cubeEdges[e][1]]
+	return [3]float64{float64(a[0]+b[0]) / 2, float64(a[1]+b[1]) / 2, float64(a[2]+b[2]) / 2}
+}
+
+func cornerPoint(c int) [3]float64 {
+	return [3]float64{float64(cubeCorners[c][0]), float64(cubeCorners[c][1]), float64(cubeCorners[c][2])}
+}
+
+// cubeTriangles triangulates the surface within one cube, where bit c of
+// config is set if corner c is inside.
+func cubeTriangles(config int) [][3]int {
+	inside := func(c int) bool { return config>>c&1 == 1 }
+
+	next := make(map[int]int)
+
+	for axis := 0; axis < 3; axis++ {
+		u, v := (axis+1)%3, (axis+2)%3
+		for side := 0; side < 2; side++ {
+			// The corners of this face, in cyclic order.
+			var cyc [4]int
+			for t, uv := range [4][2]int{{0, 0}, {1, 0}, {1, 1}, {0, 1}} {
+				cyc[t] = side<<axis | uv[0]<<u | uv[1]<<v
+			}
+
+			var normal [3]float64
+			normal[axis] = float64(2*side - 1)
+
+			var crossed []int
+			for t := range cyc {
+				a, b := cyc[t], cyc[(t+1)%4]
+				if inside(a) != inside(b) {
+					crossed = append(crossed, cubeEdgeIndex(a, b))
+				}
+			}
+
+			var segments [][2]int
+			switch len(crossed) {
+			case 2:
+				segments = append(segments, [2]int{crossed[0], crossed[1]})
+			case 4:
+				// Cut off each inside corner separately.
+				for t, p := range cyc {
+					if inside(p) {
+						segments = append(segments, [2]int{cubeEdgeIndex(cyc[(t+3)%4], p), cubeEdgeIndex(p, cyc[(t+1)%4])})
+					}
+				}
+			}
+
+			for _, s := range segments {
+				m1, m2 := cubeEdgeMidpoint(s[0]), cubeEdgeMidpoint(s[1])
+				ref := cubeEdges[s[0]][0]
+				if !inside(ref) {
+					ref = cubeEdges[s[0]][1]
+				}
+
+				left := cross(normal, sub(m2, m1))
+				if dot(left, sub(cornerPoint(ref), m1)) > 0 {
+					s[0], s[1] = s[1], s[0]
+				}
+				next[s[0]] = s[1]
+			}
+		}
+	}
+
+	var out [][3]int
+	visited := make(map[int]bool)
+	for e := 0; e < 12; e++ {
+		if _, exists := next[e]; !exists || visited[e] {
+			continue
+		}
+
+		var loop []int
+		for cur := e; !visited[cur]; cur = next[cur] {
+			visited[cur] = true
+			loop = append(loop, cur)
+		}
+
+		for i := 1; i+1 < len(loop); i++ {
+			out = append(out, [3]int{loop[0], loop[i], loop[i+1]})
+		}
+	}
+
+	return out
+}
+
+// marchingCubes extracts the surface of the voxels of v that carry label. The
+// grid is padded with a layer of outside voxels so that the surface is closed
+// even where the label touches the edge of the volume. Vertices are placed at
+// the midpoints of crossed edges and mapped to patient coordinates.
+func marchingCubes(v *maskVolume, label uint32) *mesh {
+	m := &mesh{}
+
+	in := func(i, j, k int) bool {
+		if i < 0 || j < 0 || k < 0 || i >= v.NX || j >= v.NY || k >= v.NZ {
+			return false
+		}
+		return v.at(i, j, k) == label
+	}
+
+	// Vertices are shared between neighboring cubes, keyed by the lower
+	// corner of their edge and the edge's axis.
+	vertexIDs := make(map[int]int)
+	vertexID := func(i, j, k, axis int) int {
+		key := (((k+1)*(v.NY+2)+(j+1))*(v.NX+2)+(i+1))*3 + axis
+		if id, exists := vertexIDs[key]; exists {
+			return id
+		}
+
+		p := [3]float64{float64(i), float64(j), float64(k)}
+		p[axis] += 0.5
+
+		id := len(m.Vertices)
+		m.Vertices = append(m.Vertices, v.patientPosition(p[0], p[1], p[2]))
+		vertexIDs[key] = id
+		return id
+	}
+
+	for k := -1; k < v.NZ; k++ {
+		for j := -1; j < v.NY; j++ {
+			for i := -1; i < v.NX; i++ {
+				config := 0
+				for c, o := range cubeCorners {
+					if in(i+o[0], j+o[1], k+o[2]) {
+						config |= 1 << c
+					}
+				}
+				if config == 0 || config == 255 {
+					continue
+				}
+
+				for _, tri := range mcTriangles[config] {
+					var face [3]int
+					for t, e := range tri {
+						o := cubeCorners[cubeEdges[e][0]]
+						face[t] = vertexID(i+o[0], j+o[1], k+o[2], cubeEdgeAxis[e])
+					}
+					m.Triangles = append(m.Triangles, face)
+				}
+			}
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/carbocation/genomisc/overlay"
+)
+
+// maskVolume is a stack of segmentation masks from one series. Voxel (i, j, k)
+// is column i and row j of the mask of slice k; slices are ordered along the
+// slice normal.
+type maskVolume struct {
+	NX, NY, NZ int
+	Labels     []uint32
+
+	// Slices holds the manifest entry of each slice, and Row, Col, and Normal
+	// are the patient-space directions of increasing i, j, and k.
+	Slices []manifestEntry
+	Row    [3]float64
+	Col    [3]float64
+	Normal [3]float64
+}
+
+func (v *maskVolume) at(i, j, k int) uint32 {
+	return v.Labels[(k*v.NY+j)*v.NX+i]
+}
+
+// patientPosition maps a (possibly fractional, possibly just outside of the
+// volume) voxel index to patient coordinates in mm. Between slices, the
+// position is interpolated from the two neighboring slices' positions, so
+// uneven slice spacing is respected.
+func (v *maskVolume) patientPosition(i, j, k float64) [3]float64 {
+	var origin [3]float64
+	if v.NZ == 1 {
+		thickness := v.Slices[0].PixelWidthNativeZ
+		if thickness <= 0 {
+			thickness = 1
+		}
+		origin = add(slicePosition(v.Slices[0]), scale(v.Normal, k*thickness))
+	} else {
+		k0 := int(math.Floor(k))
+		if k0 < 0 {
+			k0 = 0
+		} else if k0 > v.NZ-2 {
+			k0 = v.NZ - 2
+		}
+
+		p0, p1 := slicePosition(v.Slices[k0]), slicePosition(v.Slices[k0+1])
+		origin = add(p0, scale(sub(p1, p0), k-float64(k0)))
+	}
+
+	ref := v.Slices[0]
+	dx, dy := ref.PixelWidthNativeX, ref.PixelWidthNativeY
+	if dx <= 0 {
+		dx = 1
+	}
+	if dy <= 0 {
+		dy = 1
+	}
+
+	return add(origin, add(scale(v.Row, i*dx), scale(v.Col, j*dy)))
+}
+
+func slicePosition(entry manifestEntry) [3]float64 {
+	return [3]float64{entry.ImagePositionPatientX, entry.ImagePositionPatientY, entry.ImagePositionPatientZ}
+}
+
+// sliceOrientation returns the row and column direction cosines of an entry,
+// from the ImageOrientationPatient column if the manifest has one (six
+// numbers, separated by backslashes, commas, or spaces). Otherwise, as
+// elsewhere in this program, the images are assumed to be axial.
+func sliceOrientation(entry manifestEntry) ([3]float64, [3]float64, error) {
+	row, col := [3]float64{1, 0, 0}, [3]float64{0, 1, 0}
+
+	value, exists := entry.Etc[ImageOrientationColumn]
+	if !exists || strings.TrimSpace(value) == "" {
+		return row, col, nil
+	}
+
+	fields := strings.FieldsFunc(value, func(r rune) bool { return r == '\\' || r == ',' || r == ' ' })
+	if len(fields) != 6 {
+		return row, col, fmt.Errorf("Expected 6 values in %s, found %d: %q", ImageOrientationColumn, len(fields), value)
+	}
+
+	var cosines [6]float64
+	for i, field := range fields {
+		var err error
+		cosines[i], err = strconv.ParseFloat(field, 64)
+		if err != nil {
+			return row, col, err
+		}
+	}
+
+	return normalize([3]float64{cosines[0], cosines[1], cosines[2]}), normalize([3]float64{cosines[3], cosines[4], cosines[5]}), nil
+}
+
+// buildMaskVolume reads the mask of each entry, named after its dicom with
+// maskSuffix appended, and stacks them into a volume. If several entries
+// share a slice position (e.g., the frames of a cine series), only the first
+// is used, so the manifest should be filtered to the frame of interest.
+func buildMaskVolume(entries []manifestEntry, maskFolder, maskSuffix string) (*maskVolume, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("No entries")
+	}
+
+	v := &maskVolume{}
+
+	var err error
+	v.Row, v.Col, err = sliceOrientation(entries[0])
+	if err != nil {
+		return nil, err
+	}
+	v.Normal = normalize(cross(v.Row, v.Col))
+
+	// Order the slices along the normal, keeping the first entry at each
+	// position.
+	sorted := append([]manifestEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return dot(slicePosition(sorted[i]), v.Normal) < dot(slicePosition(sorted[j]), v.Normal)
+	})
+
+	duplicates := 0
+	for _, entry := range sorted {
+		if n := len(v.Slices); n > 0 && math.Abs(dot(sub(slicePosition(entry), slicePosition(v.Slices[n-1])), v.Normal)) < 1e-3 {
+			duplicates++
+			continue
+		}
+		v.Slices = append(v.Slices, entry)
+	}
+	if duplicates > 0 {
+		log.Printf("%d dicoms shared a slice position with another dicom and were skipped\n", duplicates)
+	}
+
+	v.NZ = len(v.Slices)
+	for k, entry := range v.Slices {
+		mask, err := overlay.OpenImageFromLocalFileOrGoogleStorage(maskFolder+"/"+entry.dicom+maskSuffix, client)
+		if err != nil {
+			return nil, err
+		}
+
+		size := mask.Bounds().Size()
+		if k == 0 {
+			v.NX, v.NY = size.X, size.Y
+			v.Labels = make([]uint32, v.NX*v.NY*v.NZ)
+		} else if size.X != v.NX || size.Y != v.NY {
+			return nil, fmt.Errorf("Mask for %s is %v, but the first mask is %dx%d", entry.dicom, size, v.NX, v.NY)
+		}
+
+		if err := readMaskLabels(mask, v.Labels[k*v.NX*v.NY:(k+1)*v.NX*v.NY]); err != nil {
+			return nil, fmt.Errorf("%s: %v", entry.dicom, err)
+		}
+	}
+
+	return v, nil
+}
+
+func readMaskLabels(mask image.Image, out []uint32) error {
+	min := mask.Bounds().Min
+	cols := mask.Bounds().Dx()
+	for p := range out {
+		id, err := overlay.LabeledPixelToID(mask.At(min.X+p%cols, min.Y+p/cols))
+		if err != nil {
+			return err
+		}
+		out[p] = id
+	}
+
+	return nil
+}
+
+// presentLabels lists the nonzero labels found in the volume, in order.
+func (v *maskVolume) presentLabels() []uint32 {
+	seen := make(map[uint32]struct{})
+	for _, id := range v.Labels {
+		if id != 0 {
+			seen[id] = struct{}{}
+		}
+	}
+
+	out := make([]uint32, 0, len(seen))
+	for id := range seen {
+		out = append(out, id)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+
+	return out
+}
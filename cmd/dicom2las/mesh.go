@@ -0,0 +1,398 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+)
+
+// mesh is a triangle mesh in patient coordinates (mm). Triangles are wound
+// counter-clockwise when viewed from outside.
+type mesh struct {
+	Vertices  [][3]float64
+	Triangles [][3]int
+}
+
+// signedVolume is the enclosed volume, in mm^3, by the divergence theorem. It
+// is positive for a closed mesh whose normals point outward.
+func (m *mesh) signedVolume() float64 {
+	total := 0.0
+	for _, t := range m.Triangles {
+		total += dot(m.Vertices[t[0]], cross(m.Vertices[t[1]], m.Vertices[t[2]]))
+	}
+
+	return total / 6
+}
+
+// neighbors lists the vertices that share an edge with each vertex.
+func (m *mesh) neighbors() [][]int {
+	sets := make([]map[int]struct{}, len(m.Vertices))
+	for _, t := range m.Triangles {
+		for a := 0; a < 3; a++ {
+			for b := 0; b < 3; b++ {
+				if a == b {
+					continue
+				}
+				if sets[t[a]] == nil {
+					sets[t[a]] = make(map[int]struct{})
+				}
+				sets[t[a]][t[b]] = struct{}{}
+			}
+		}
+	}
+
+	out := make([][]int, len(m.Vertices))
+	for i, set := range sets {
+		for j := range set {
+			out[i] = append(out[i], j)
+		}
+	}
+
+	return out
+}
+
+// smooth applies iterations of Laplacian smoothing, moving each vertex a
+// fraction lambda of the way toward the mean of its neighbors. This removes
+// the staircase left by voxels, but also shrinks the mesh; if taubin is set,
+// each step is followed by an inflating step (Taubin's lambda|mu smoothing),
+// which largely preserves the volume.
+func (m *mesh) smooth(iterations int, lambda float64, taubin bool) {
+	neighbors := m.neighbors()
+
+	step := func(factor float64) {
+		moved := make([][3]float64, len(m.Vertices))
+		for i, p := range m.Vertices {
+			if len(neighbors[i]) == 0 {
+				moved[i] = p
+				continue
+			}
+
+			var mean [3]float64
+			for _, j := range neighbors[i] {
+				mean = add(mean, m.Vertices[j])
+			}
+			mean = scale(mean, 1/float64(len(neighbors[i])))
+
+			moved[i] = add(p, scale(sub(mean, p), factor))
+		}
+		m.Vertices = moved
+	}
+
+	// A pass band of about 0.1 is typical for Taubin smoothing:
+	// 1/lambda + 1/mu = 0.1.
+	mu := -lambda / (1 - 0.1*lambda)
+
+	for i := 0; i < iterations; i++ {
+		step(lambda)
+		if taubin {
+			step(mu)
+		}
+	}
+}
+
+// decimate simplifies the mesh by collapsing edges until at most fraction of
+// the triangles remain. Edges are collapsed in order of their quadric error
+// (Garland and Heckbert, 1997), so flat regions are simplified first and
+// sharp features are kept. Collapses that would make the surface
+// non-manifold or fold a triangle over are skipped.
+func (m *mesh) decimate(fraction float64) {
+	if fraction <= 0 || fraction >= 1 || len(m.Triangles) == 0 {
+		return
+	}
+	target := int(fraction * float64(len(m.Triangles)))
+
+	nv := len(m.Vertices)
+	quadrics := make([]quadric, nv)
+	faces := make([]map[int]struct{}, nv)
+	for i := range faces {
+		faces[i] = make(map[int]struct{})
+	}
+	removedFace := make([]bool, len(m.Triangles))
+	removedVertex := make([]bool, nv)
+	stamps := make([]int, nv)
+
+	for f, t := range m.Triangles {
+		q := planeQuadric(m.Vertices[t[0]], m.Vertices[t[1]], m.Vertices[t[2]])
+		for _, v := range t {
+			quadrics[v] = quadrics[v].add(q)
+			faces[v][f] = struct{}{}
+		}
+	}
+
+	vertexNeighbors := func(v int) map[int]struct{} {
+		out := make(map[int]struct{})
+		for f := range faces[v] {
+			for _, w := range m.Triangles[f] {
+				if w != v {
+					out[w] = struct{}{}
+				}
+			}
+		}
+		return out
+	}
+
+	h := &collapseHeap{}
+	push := func(a, b int) {
+		q := quadrics[a].add(quadrics[b])
+		pos := q.optimum(m.Vertices[a], m.Vertices[b])
+		heap.Push(h, collapse{Cost: q.eval(pos), A: a, B: b, Pos: pos, StampA: stamps[a], StampB: stamps[b]})
+	}
+
+	for v := 0; v < nv; v++ {
+		for w := range vertexNeighbors(v) {
+			if v < w {
+				push(v, w)
+			}
+		}
+	}
+
+	remaining := len(m.Triangles)
+	for remaining > target && h.Len() > 0 {
+		c := heap.Pop(h).(collapse)
+		if removedVertex[c.A] || removedVertex[c.B] || stamps[c.A] != c.StampA || stamps[c.B] != c.StampB {
+			continue
+		}
+
+		// Link condition: the only vertices adjacent to both ends of the edge
+		// must be the apexes of the two triangles that share it.
+		na, nb := vertexNeighbors(c.A), vertexNeighbors(c.B)
+		if _, exists := na[c.B]; !exists {
+			continue
+		}
+		shared := 0
+		for w := range na {
+			if _, exists := nb[w]; exists {
+				shared++
+			}
+		}
+		if shared != 2 {
+			continue
+		}
+
+		if m.collapseFlips(faces, c) {
+			continue
+		}
+
+		// Collapse B into A.
+		for f := range faces[c.B] {
+			t := &m.Triangles[f]
+			if t[0] == c.A || t[1] == c.A || t[2] == c.A {
+				removedFace[f] = true
+				remaining--
+				for _, w := range t {
+					delete(faces[w], f)
+				}
+				continue
+			}
+			for i := range t {
+				if t[i] == c.B {
+					t[i] = c.A
+				}
+			}
+			faces[c.A][f] = struct{}{}
+		}
+		faces[c.B] = nil
+		removedVertex[c.B] = true
+
+		m.Vertices[c.A] = c.Pos
+		quadrics[c.A] = quadrics[c.A].add(quadrics[c.B])
+		stamps[c.A]++
+		for w := range vertexNeighbors(c.A) {
+			stamps[w]++
+		}
+		for w := range vertexNeighbors(c.A) {
+			push(c.A, w)
+			for x := range vertexNeighbors(w) {
+				if x != c.A {
+					push(w, x)
+				}
+			}
+		}
+	}
+
+	// Compact the vertices and triangles that remain.
+	newIndex := make([]int, nv)
+	var vertices [][3]float64
+	for v := range m.Vertices {
+		if removedVertex[v] {
+			continue
+		}
+		newIndex[v] = len(vertices)
+		vertices = append(vertices, m.Vertices[v])
+	}
+
+	var triangles [][3]int
+	for f, t := range m.Triangles {
+		if removedFace[f] {
+			continue
+		}
+		triangles = append(triangles, [3]int{newIndex[t[0]], newIndex[t[1]], newIndex[t[2]]})
+	}
+
+	m.Vertices, m.Triangles = vertices, triangles
+}
+
+// collapseFlips reports whether moving the ends of the edge to the new
+// position would turn any of the surviving triangles around them over.
+func (m *mesh) collapseFlips(faces []map[int]struct{}, c collapse) bool {
+	for _, v := range []int{c.A, c.B} {
+		for f := range faces[v] {
+			t := m.Triangles[f]
+			if (t[0] == c.A || t[1] == c.A || t[2] == c.A) && (t[0] == c.B || t[1] == c.B || t[2] == c.B) {
+				// This triangle will be removed.
+				continue
+			}
+
+			var moved [3][3]float64
+			for i, w := range t {
+				moved[i] = m.Vertices[w]
+				if w == c.A || w == c.B {
+					moved[i] = c.Pos
+				}
+			}
+
+			before := cross(sub(m.Vertices[t[1]], m.Vertices[t[0]]), sub(m.Vertices[t[2]], m.Vertices[t[0]]))
+			after := cross(sub(moved[1], moved[0]), sub(moved[2], moved[0]))
+			if dot(before, after) <= 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// quadric is the symmetric 4x4 matrix of the sum of squared distances to a set
+// of planes, stored as its upper triangle.
+type quadric [10]float64
+
+func planeQuadric(p0, p1, p2 [3]float64) quadric {
+	n := cross(sub(p1, p0), sub(p2, p0))
+	length := math.Sqrt(dot(n, n))
+	if length == 0 {
+		return quadric{}
+	}
+
+	// Weight each plane by its triangle's area, so that the many small
+	// triangles of a smooth region do not outweigh a large one.
+	area := length / 2
+	n = scale(n, 1/length)
+	d := -dot(n, p0)
+	a, b, c := n[0], n[1], n[2]
+
+	return quadric{
+		a * a, a * b, a * c, a * d,
+		b * b, b * c, b * d,
+		c * c, c * d,
+		d * d,
+	}.scale(area)
+}
+
+func (q quadric) add(r quadric) quadric {
+	for i := range q {
+		q[i] += r[i]
+	}
+	return q
+}
+
+func (q quadric) scale(s float64) quadric {
+	for i := range q {
+		q[i] *= s
+	}
+	return q
+}
+
+func (q quadric) eval(p [3]float64) float64 {
+	x, y, z := p[0], p[1], p[2]
+	return q[0]*x*x + 2*q[1]*x*y + 2*q[2]*x*z + 2*q[3]*x +
+		q[4]*y*y + 2*q[5]*y*z + 2*q[6]*y +
+		q[7]*z*z + 2*q[8]*z +
+		q[9]
+}
+
+// optimum is the position that minimizes the quadric, if it is well defined,
+// and otherwise the best of the edge's ends and midpoint.
+func (q quadric) optimum(a, b [3]float64) [3]float64 {
+	m := [3][3]float64{
+		{q[0], q[1], q[2]},
+		{q[1], q[4], q[5]},
+		{q[2], q[5], q[7]},
+	}
+	rhs := [3]float64{-q[3], -q[6], -q[8]}
+
+	det := dot(m[0], cross(m[1], m[2]))
+	if math.Abs(det) > 1e-9 {
+		// Cramer's rule, using the symmetry of m.
+		p := scale([3]float64{
+			dot(rhs, cross(m[1], m[2])),
+			dot(m[0], cross(rhs, m[2])),
+			dot(m[0], cross(m[1], rhs)),
+		}, 1/det)
+
+		// Only accept it if it stays near the edge.
+		if length := math.Sqrt(dot(sub(a, b), sub(a, b))); math.Sqrt(dot(sub(p, scale(add(a, b), 0.5)), sub(p, scale(add(a, b), 0.5)))) <= length {
+			return p
+		}
+	}
+
+	best := scale(add(a, b), 0.5)
+	for _, p := range [][3]float64{a, b} {
+		if q.eval(p) < q.eval(best) {
+			best = p
+		}
+	}
+
+	return best
+}
+
+type collapse struct {
+	Cost           float64
+	A, B           int
+	Pos            [3]float64
+	StampA, StampB int
+}
+
+type collapseHeap []collapse
+
+func (h collapseHeap) Len() int            { return len(h) }
+func (h collapseHeap) Less(i, j int) bool  { return h[i].Cost < h[j].Cost }
+func (h collapseHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *collapseHeap) Push(x interface{}) { *h = append(*h, x.(collapse)) }
+func (h *collapseHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func add(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+func sub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func scale(a [3]float64, s float64) [3]float64 {
+	return [3]float64{a[0] * s, a[1] * s, a[2] * s}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func normalize(a [3]float64) [3]float64 {
+	length := math.Sqrt(dot(a, a))
+	if length == 0 {
+		return a
+	}
+
+	return scale(a, 1/length)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// sphereVolume is a 1 mm isotropic volume holding a ball of label 1.
+func sphereVolume(n int, radius float64) *maskVolume {
+	v := &maskVolume{NX: n, NY: n, NZ: n, Labels: make([]uint32, n*n*n), Row: [3]float64{1, 0, 0}, Col: [3]float64{0, 1, 0}, Normal: [3]float64{0, 0, 1}}
+	for k := 0; k < n; k++ {
+		v.Slices = append(v.Slices, manifestEntry{ImagePositionPatientZ: float64(k), PixelWidthNativeX: 1, PixelWidthNativeY: 1, PixelWidthNativeZ: 1})
+		for j := 0; j < n; j++ {
+			for i := 0; i < n; i++ {
+				c := float64(n-1) / 2
+				if (float64(i)-c)*(float64(i)-c)+(float64(j)-c)*(float64(j)-c)+(float64(k)-c)*(float64(k)-c) <= radius*radius {
+					v.Labels[(k*n+j)*n+i] = 1
+				}
+			}
+		}
+	}
+
+	return v
+}
+
+// checkClosed verifies that every edge is shared by exactly two triangles that
+// traverse it in opposite directions.
+func checkClosed(t *testing.T, m *mesh) {
+	t.Helper()
+
+	edges := make(map[[2]int]int)
+	for _, tri := range m.Triangles {
+		for i := 0; i < 3; i++ {
+			edges[[2]int{tri[i], tri[(i+1)%3]}]++
+		}
+	}
+
+	for e, count := range edges {
+		if count != 1 || edges[[2]int{e[1], e[0]}] != 1 {
+			t.Fatalf("Edge %v is used %d times, and its reverse %d times", e, count, edges[[2]int{e[1], e[0]}])
+		}
+	}
+}
+
+func TestMarchingCubesAllConfigurations(t *testing.T) {
+	// A 2x2x2 volume holds every configuration of a single cube, and padding
+	// makes each one a closed surface.
+	for config := 1; config < 256; config++ {
+		v := &maskVolume{NX: 2, NY: 2, NZ: 2, Labels: make([]uint32, 8), Row: [3]float64{1, 0, 0}, Col: [3]float64{0, 1, 0}, Normal: [3]float64{0, 0, 1}}
+		for k := 0; k < 2; k++ {
+			v.Slices = append(v.Slices, manifestEntry{ImagePositionPatientZ: float64(k), PixelWidthNativeX: 1, PixelWidthNativeY: 1})
+		}
+		for c := 0; c < 8; c++ {
+			if config>>c&1 == 1 {
+				v.Labels[c] = 1
+			}
+		}
+
+		m := marchingCubes(v, 1)
+		checkClosed(t, m)
+		if m.signedVolume() <= 0 {
+			t.Fatalf("Configuration %d: expected a positive volume, got %f", config, m.signedVolume())
+		}
+	}
+}
+
+func TestMeshSphere(t *testing.T) {
+	radius := 8.0
+	expected := 4.0 / 3.0 * math.Pi * radius * radius * radius
+
+	m := marchingCubes(sphereVolume(24, radius), 1)
+	checkClosed(t, m)
+	if got := m.signedVolume(); math.Abs(got-expected)/expected > 0.1 {
+		t.Errorf("Expected a volume near %f, got %f", expected, got)
+	}
+
+	m.smooth(10, 0.5, true)
+	checkClosed(t, m)
+	if got := m.signedVolume(); math.Abs(got-expected)/expected > 0.1 {
+		t.Errorf("Expected a volume near %f after smoothing, got %f", expected, got)
+	}
+
+	before := len(m.Triangles)
+	m.decimate(0.25)
+	checkClosed(t, m)
+	if len(m.Triangles) > before/4+2 {
+		t.Errorf("Expected at most %d triangles after decimation, got %d", before/4, len(m.Triangles))
+	}
+	if got := m.signedVolume(); math.Abs(got-expected)/expected > 0.1 {
+		t.Errorf("Expected a volume near %f after decimation, got %f", expected, got)
+	}
+
+	var buf bytes.Buffer
+	if err := writeSTL(&buf, m, "sphere"); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 84+50*len(m.Triangles) {
+		t.Errorf("Expected an STL of %d bytes, got %d", 84+50*len(m.Triangles), buf.Len())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Mesh output formats.
+const (
+	MeshSTL = "stl"
+	MeshOBJ = "obj"
+	MeshPLY = "ply"
+)
+
+func writeMeshFile(m *mesh, format, outName string) error {
+	f, err := os.Create(outName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	switch format {
+	case MeshSTL:
+		err = writeSTL(w, m, outName)
+	case MeshOBJ:
+		err = writeOBJ(w, m)
+	case MeshPLY:
+		err = writeMeshPLY(w, m)
+	default:
+		err = fmt.Errorf("Unrecognized mesh format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// writeSTL writes a binary STL file, which is what most slicers for 3D
+// printing expect.
+func writeSTL(w io.Writer, m *mesh, name string) error {
+	var header [80]byte
+	copy(header[:], "dicom2las "+name)
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(m.Triangles))); err != nil {
+		return err
+	}
+
+	// Normal, three vertices, and a 2-byte attribute count.
+	var record [50]byte
+	for _, t := range m.Triangles {
+		p0, p1, p2 := m.Vertices[t[0]], m.Vertices[t[1]], m.Vertices[t[2]]
+		values := [][3]float64{normalize(cross(sub(p1, p0), sub(p2, p0))), p0, p1, p2}
+
+		for i, v := range values {
+			for j := range v {
+				binary.LittleEndian.PutUint32(record[4*(3*i+j):], math.Float32bits(float32(v[j])))
+			}
+		}
+
+		if _, err := w.Write(record[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeOBJ(w io.Writer, m *mesh) error {
+	for _, v := range m.Vertices {
+		if _, err := fmt.Fprintf(w, "v %.4f %.4f %.4f\n", v[0], v[1], v[2]); err != nil {
+			return err
+		}
+	}
+
+	// OBJ indices are 1-based.
+	for _, t := range m.Triangles {
+		if _, err := fmt.Fprintf(w, "f %d %d %d\n", t[0]+1, t[1]+1, t[2]+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeMeshPLY writes a binary little-endian PLY file with float vertices and
+// triangular faces.
+func writeMeshPLY(w io.Writer, m *mesh) error {
+	header := fmt.Sprintf("ply\nformat binary_little_endian 1.0\ncomment dicom2las, patient coordinates in mm\nelement vertex %d\nproperty float x\nproperty float y\nproperty float z\nelement face %d\nproperty list uchar int vertex_indices\nend_header\n", len(m.Vertices), len(m.Triangles))
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+
+	var vertex [12]byte
+	for _, v := range m.Vertices {
+		for j := range v {
+			binary.LittleEndian.PutUint32(vertex[4*j:], math.Float32bits(float32(v[j])))
+		}
+		if _, err := w.Write(vertex[:]); err != nil {
+			return err
+		}
+	}
+
+	var face [13]byte
+	face[0] = 3
+	for _, t := range m.Triangles {
+		for j := range t {
+			binary.LittleEndian.PutUint32(face[1+4*j:], uint32(t[j]))
+		}
+		if _, err := w.Write(face[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+)
+
+// Point cloud output formats.
+const (
+	PointCloudLAS  = "las"
+	PointCloudPLY  = "ply"
+	PointCloudPCD  = "pcd"
+	PointCloudNone = "none"
+)
+
+// makePointCloud writes the voxels of the entries in the requested format.
+// The points are the same as those in the LAS output: offset so that the
+// minimum coordinate is at the origin, and carrying the pixel intensity.
+func makePointCloud(dicomEntries []manifestEntry, imgMap map[string]image.Image, format, outName string) error {
+	if format == PointCloudLAS {
+		return makeLAS(dicomEntries, imgMap, outName)
+	}
+
+	points, err := dicomTo3DPoints(dicomEntries, imgMap)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(outName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	switch format {
+	case PointCloudPLY:
+		err = writePointsPLY(w, points)
+	case PointCloudPCD:
+		err = writePointsPCD(w, points)
+	default:
+		err = fmt.Errorf("Unrecognized point cloud format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// writePointsPLY writes a binary little-endian PLY file. The intensity is also
+// written as a gray color, scaled to 8 bits, since most viewers only display
+// colors.
+func writePointsPLY(w io.Writer, points []Dicom3DPoint) error {
+	header := fmt.Sprintf("ply\nformat binary_little_endian 1.0\ncomment dicom2las\nelement vertex %d\nproperty float x\nproperty float y\nproperty float z\nproperty ushort intensity\nproperty uchar red\nproperty uchar green\nproperty uchar blue\nend_header\n", len(points))
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+
+	var record [17]byte
+	for _, p := range points {
+		putPoint(record[:], p)
+		gray := uint8(p.Intensity >> 8)
+		record[14], record[15], record[16] = gray, gray, gray
+
+		if _, err := w.Write(record[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writePointsPCD writes a binary Point Cloud Library (PCD v0.7) file.
+func writePointsPCD(w io.Writer, points []Dicom3DPoint) error {
+	header := fmt.Sprintf("# .PCD v0.7 - Point Cloud Data file format\nVERSION 0.7\nFIELDS x y z intensity\nSIZE 4 4 4 2\nTYPE F F F U\nCOUNT 1 1 1 1\nWIDTH %d\nHEIGHT 1\nVIEWPOINT 0 0 0 1 0 0 0\nPOINTS %d\nDATA binary\n", len(points), len(points))
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+
+	var record [14]byte
+	for _, p := range points {
+		putPoint(record[:], p)
+
+		if _, err := w.Write(record[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// putPoint encodes x, y, z as little-endian float32 followed by the
+// intensity as a little-endian uint16.
+func putPoint(b []byte, p Dicom3DPoint) {
+	binary.LittleEndian.PutUint32(b[0:], math.Float32bits(float32(p.X)))
+	binary.LittleEndian.PutUint32(b[4:], math.Float32bits(float32(p.Y)))
+	binary.LittleEndian.PutUint32(b[8:], math.Float32bits(float32(p.Z)))
+	binary.LittleEndian.PutUint16(b[12:], p.Intensity)
+}
@@ -42,6 +42,11 @@ func processEntries(entries []manifestEntry, key manifestKey, folder string, doN
 	// Fetch images from each zipfile once:
 	zipMap := make(map[string]map[string]image.Image)
 	for entry := range zipSeriesMap {
+		// Meshes are made from the masks alone.
+		if pointCloudFormat == PointCloudNone {
+			break
+		}
+
 		if _, exists := zipMap[entry.Zip]; !exists {
 			if beVerbose {
 				fmt.Printf("Fetching images for %+v:%v\n", key, entry.Zip)
@@ -67,18 +72,31 @@ func processEntries(entries []manifestEntry, key manifestKey, folder string, doN
 	for zip, pngData := range zipSeriesMap {
 		imgMap := zipMap[zip.Zip]
 
-		go func(zip seriesMap, imgMap map[string]image.Image, pngData []manifestEntry) {
-			outName := zip.Zip + "_" + zip.Series + ".las"
-			errchan <- makeLAS(pngData, imgMap, outName)
+		if pointCloudFormat != PointCloudNone {
+			go func(zip seriesMap, imgMap map[string]image.Image, pngData []manifestEntry) {
+				outName := zip.Zip + "_" + zip.Series + "." + pointCloudFormat
+				errchan <- makePointCloud(pngData, imgMap, pointCloudFormat, outName)
 
-		}(zip, imgMap, pngData)
+			}(zip, imgMap, pngData)
+		}
 
+		if meshFormat != "" {
+			go func(zip seriesMap, pngData []manifestEntry) {
+				errchan <- makeMeshes(pngData, zip.Zip+"_"+zip.Series)
+			}(zip, pngData)
+		}
 	}
 
 	completed := 0
 
 	var err error
-	imPerZip := 1
+	imPerZip := 0
+	if pointCloudFormat != PointCloudNone {
+		imPerZip++
+	}
+	if meshFormat != "" {
+		imPerZip++
+	}
 WaitLoop:
 	for {
 		select {
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+	"github.com/suyashkumar/dicom/dicomtag"
+	"github.com/suyashkumar/dicom/dicomuid"
+	"github.com/suyashkumar/dicom/element"
+)
+
+// Subject holds the per-subject replacements drawn from the lookup table.
+type Subject struct {
+	NewID string
+
+	// DateOffsetDays is added to every date when dates are being shifted.
+	DateOffsetDays int
+}
+
+// Deidentifier applies the Basic Application Level Confidentiality Profile,
+// plus any requested options, to parsed dicoms.
+type Deidentifier struct {
+	// UIDSecret keys the UID remapping. The same secret always maps a given
+	// UID to the same replacement, so UIDs remain consistent across a series
+	// (and across runs).
+	UIDSecret []byte
+
+	// ShiftDates implements the Retain Longitudinal Temporal Information with
+	// Modified Dates Option: dates are shifted by the subject's offset rather
+	// than removed, and times are retained.
+	ShiftDates bool
+
+	// RemapPatientID replaces PatientID and PatientName with the subject's new
+	// ID rather than emptying them.
+	RemapPatientID bool
+
+	KeepDescriptions bool
+	KeepOverlays     bool
+	KeepCSA          bool
+}
+
+// Apply de-identifies ds in place and returns its new SOPInstanceUID.
+func (d Deidentifier) Apply(ds *element.DataSet, subject Subject) (string, error) {
+	elems, err := d.cleanElements(ds.Elements, subject, true)
+	if err != nil {
+		return "", err
+	}
+
+	methods := []interface{}{"Basic Application Confidentiality Profile"}
+	if d.ShiftDates {
+		methods = append(methods, "Retain Longitudinal With Modified Dates Option")
+	}
+	if d.KeepDescriptions {
+		methods = append(methods, "Retain Series And Study Descriptions")
+	}
+
+	additions := []*element.Element{
+		{Tag: tagPatientIdentityRemoved, VR: "CS", Value: []interface{}{"YES"}},
+		{Tag: tagDeidentificationMethod, VR: "LO", Value: methods},
+	}
+	if d.ShiftDates {
+		additions = append(additions, &element.Element{Tag: tagLongitudinalTemporalInformationModified, VR: "CS", Value: []interface{}{"MODIFIED"}})
+	}
+
+	for _, add := range additions {
+		replaced := false
+		for i, elem := range elems {
+			if elem.Tag == add.Tag {
+				elems[i] = add
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			elems = append(elems, add)
+		}
+	}
+
+	sort.SliceStable(elems, func(i, j int) bool {
+		return elems[i].Tag.Compare(elems[j].Tag) < 0
+	})
+
+	ds.Elements = elems
+
+	sopInstanceUID, err := ds.FindElementByTag(dicomtag.SOPInstanceUID)
+	if err != nil {
+		return "", fmt.Errorf("No SOPInstanceUID found after de-identification: %v", err)
+	}
+
+	return sopInstanceUID.GetString()
+}
+
+// cleanElements applies the profile to one level of a data set, recursing into
+// sequences.
+func (d Deidentifier) cleanElements(elems []*element.Element, subject Subject, topLevel bool) ([]*element.Element, error) {
+	out := make([]*element.Element, 0, len(elems))
+
+	for _, elem := range elems {
+		if elem == nil {
+			continue
+		}
+
+		tag := elem.Tag
+
+		// File meta information
+		if tag.Group == dicomtag.MetadataGroup {
+			switch tag {
+			case dicomtag.MediaStorageSOPInstanceUID:
+				d.remapUIDs(elem)
+			case dicomtag.TransferSyntaxUID:
+				// The data set has already been inflated while parsing, and is
+				// rewritten without compression.
+				if v, err := elem.GetString(); err == nil && v == bulkprocess.TransferSyntaxDeflatedExplicitVRLittleEndian {
+					elem.Value = []interface{}{bulkprocess.TransferSyntaxExplicitVRLittleEndian}
+				}
+			}
+			out = append(out, elem)
+			continue
+		}
+
+		// Private attributes
+		if dicomtag.IsPrivate(tag.Group) {
+			if d.KeepCSA && tag.Group == 0x0029 && (tag.Element <= 0x00FF || tag == tagSiemensCSAImageHeader || tag == tagSiemensCSASeriesHeader) {
+				out = append(out, elem)
+			}
+			continue
+		}
+
+		if isCurve(tag) {
+			continue
+		}
+
+		if isOverlay(tag) {
+			// Overlay comments are always removed. Overlay planes are removed
+			// unless requested.
+			if d.KeepOverlays && tag.Element != 0x4000 {
+				out = append(out, elem)
+			}
+			continue
+		}
+
+		action, listed := basicProfile[tag]
+		if !listed && isTemporalVR(elem.VR) {
+			// A date or time that the table does not name could still date the
+			// exam, so it is removed unless the options below retain it.
+			action = actionRemove
+		}
+		if _, isDescriptor := descriptorTags[tag]; isDescriptor && d.KeepDescriptions {
+			action = actionKeep
+		}
+		if _, isPatientTemporal := patientTemporalTags[tag]; d.ShiftDates && isTemporalVR(elem.VR) && !isPatientTemporal {
+			// The option retains (and cleans, by shifting) dates and times that
+			// the Basic Profile would remove, but not those that describe the
+			// patient.
+			action = actionKeep
+		}
+
+		if topLevel && d.RemapPatientID && (tag == dicomtag.PatientID || tag == dicomtag.PatientName) {
+			elem.Value = []interface{}{subject.NewID}
+			out = append(out, elem)
+			continue
+		}
+
+		switch action {
+		case actionRemove:
+			continue
+		case actionZero:
+			elem.Value = nil
+			out = append(out, elem)
+			continue
+		case actionUID:
+			d.remapUIDs(elem)
+			out = append(out, elem)
+			continue
+		}
+
+		switch {
+		case elem.VR == "SQ":
+			for _, v := range elem.Value {
+				item, ok := v.(*element.Element)
+				if !ok {
+					return nil, fmt.Errorf("Sequence %s contains a non-item value", dicomtag.DebugString(tag))
+				}
+
+				children := make([]*element.Element, 0, len(item.Value))
+				for _, child := range item.Value {
+					if c, ok := child.(*element.Element); ok {
+						children = append(children, c)
+					}
+				}
+
+				cleaned, err := d.cleanElements(children, subject, false)
+				if err != nil {
+					return nil, err
+				}
+
+				item.Value = make([]interface{}, 0, len(cleaned))
+				for _, c := range cleaned {
+					item.Value = append(item.Value, c)
+				}
+			}
+		case elem.VR == "UI":
+			// UIDs that are not part of the DICOM standard (e.g., SOP classes
+			// and transfer syntaxes) identify instances and are remapped
+			// wherever they occur.
+			d.remapUIDs(elem)
+		case d.ShiftDates && (elem.VR == "DA" || elem.VR == "DT"):
+			if err := shiftDates(elem, subject.DateOffsetDays); err != nil {
+				return nil, err
+			}
+		}
+
+		out = append(out, elem)
+	}
+
+	return out, nil
+}
+
+func (d Deidentifier) remapUIDs(elem *element.Element) {
+	for i, v := range elem.Value {
+		uid, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		uid = strings.TrimRight(strings.TrimSpace(uid), "\x00")
+		if uid == "" {
+			continue
+		}
+
+		if _, err := dicomuid.Lookup(uid); err == nil {
+			// Standard UIDs carry no information about the subject
+			continue
+		}
+
+		elem.Value[i] = d.RemapUID(uid)
+	}
+}
+
+// RemapUID deterministically maps a UID to a new one under the 2.25 root,
+// which is reserved for UIDs derived from 128-bit integers.
+func (d Deidentifier) RemapUID(uid string) string {
+	mac := hmac.New(sha256.New, d.UIDSecret)
+	mac.Write([]byte(uid))
+	sum := mac.Sum(nil)
+
+	return "2.25." + new(big.Int).SetBytes(sum[:16]).String()
+}
+
+// PseudonymizeID deterministically maps a sample ID to a hex pseudonym, keyed
+// by the same secret as the UIDs. It is used in place of the sample ID when no
+// lookup table is given.
+func (d Deidentifier) PseudonymizeID(id string) string {
+	mac := hmac.New(sha256.New, d.UIDSecret)
+	// Domain-separate from RemapUID so that a pseudonym cannot be matched to
+	// a remapped UID.
+	mac.Write([]byte("sampleid:"))
+	mac.Write([]byte(id))
+
+	return hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+func isTemporalVR(vr string) bool {
+	return vr == "DA" || vr == "DT" || vr == "TM"
+}
+
+// shiftDates shifts each DA (YYYYMMDD) or DT (YYYYMMDDHHMMSS.FFFFFF&ZZXX)
+// value, including the ends of ranges, by offsetDays.
+func shiftDates(elem *element.Element, offsetDays int) error {
+	for i, v := range elem.Value {
+		value, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		parts := strings.Split(value, "-")
+		if elem.VR == "DT" {
+			// '-' can also introduce a UTC offset, so only DA ranges are split
+			parts = []string{value}
+		}
+
+		for j, part := range parts {
+			part = strings.TrimSpace(part)
+			if len(part) < 8 {
+				// Blank ends of ranges and partial dates (e.g., YYYY) are
+				// too coarse to shift meaningfully and are left alone.
+				continue
+			}
+
+			date, err := time.Parse("20060102", part[:8])
+			if err != nil {
+				return fmt.Errorf("Could not shift %s value %q: %v", dicomtag.DebugString(elem.Tag), value, err)
+			}
+
+			parts[j] = date.AddDate(0, 0, offsetDays).Format("20060102") + part[8:]
+		}
+
+		elem.Value[i] = strings.Join(parts, "-")
+	}
+
+	return nil
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/suyashkumar/dicom/dicomtag"
+	"github.com/suyashkumar/dicom/element"
+)
+
+const (
+	testSOPClassUID  = "1.2.840.10008.5.1.4.1.1.4" // MR Image Storage
+	testStudyUID     = "1.2.826.0.1.3680043.2.1125.1.1"
+	testFrameUID     = "1.2.826.0.1.3680043.2.1125.1.2"
+	testSOPUIDFirst  = "1.2.826.0.1.3680043.2.1125.1.3"
+	testSOPUIDSecond = "1.2.826.0.1.3680043.2.1125.1.4"
+)
+
+var (
+	tagPatientBirthDate = dicomtag.Tag{Group: 0x0010, Element: 0x0030}
+	tagPatientBirthTime = dicomtag.Tag{Group: 0x0010, Element: 0x0032}
+	tagPrivateCreator   = dicomtag.Tag{Group: 0x0029, Element: 0x0010}
+	tagSiemensOther     = dicomtag.Tag{Group: 0x0029, Element: 0x1030}
+	tagPrivateGE        = dicomtag.Tag{Group: 0x0019, Element: 0x100C}
+
+	tagInstanceCreationDate   = dicomtag.Tag{Group: 0x0008, Element: 0x0012}
+	tagDateOfLastCalibration  = dicomtag.Tag{Group: 0x0018, Element: 0x1200}
+	tagExclusionStartDateTime = dicomtag.Tag{Group: 0x0018, Element: 0x9804}
+)
+
+func testElement(tag dicomtag.Tag, vr string, values ...interface{}) *element.Element {
+	return &element.Element{Tag: tag, VR: vr, Value: values}
+}
+
+func testSequence(tag dicomtag.Tag, items ...[]*element.Element) *element.Element {
+	seq := &element.Element{Tag: tag, VR: "SQ", UndefinedLength: true}
+	for _, children := range items {
+		item := &element.Element{Tag: dicomtag.Item, UndefinedLength: true}
+		for _, c := range children {
+			item.Value = append(item.Value, c)
+		}
+		seq.Value = append(seq.Value, item)
+	}
+	return seq
+}
+
+// testDataSet builds a small MR data set with identifiers, dates, private
+// tags, and a sequence that refers to other instances.
+func testDataSet(sopInstanceUID string) *element.DataSet {
+	return &element.DataSet{Elements: []*element.Element{
+		testElement(dicomtag.MediaStorageSOPClassUID, "UI", testSOPClassUID),
+		testElement(dicomtag.MediaStorageSOPInstanceUID, "UI", sopInstanceUID),
+		testElement(dicomtag.TransferSyntaxUID, "UI", "1.2.840.10008.1.2.1"),
+		testElement(tagInstanceCreationDate, "DA", "20150601"),
+		testElement(dicomtag.SOPClassUID, "UI", testSOPClassUID),
+		testElement(dicomtag.SOPInstanceUID, "UI", sopInstanceUID),
+		testElement(dicomtag.StudyDate, "DA", "20150601"),
+		testElement(dicomtag.SeriesDate, "DA", "20150601"),
+		testElement(dicomtag.StudyTime, "TM", "101500"),
+		testElement(dicomtag.InstitutionName, "LO", "Cheadle Imaging Centre"),
+		testSequence(dicomtag.ReferencedStudySequence, []*element.Element{
+			testElement(dicomtag.ReferencedSOPClassUID, "UI", testSOPClassUID),
+			testElement(dicomtag.ReferencedSOPInstanceUID, "UI", testSOPUIDSecond),
+			testElement(dicomtag.InstitutionName, "LO", "Cheadle Imaging Centre"),
+		}),
+		testElement(dicomtag.PatientName, "PN", "Doe^Jane"),
+		testElement(dicomtag.PatientID, "LO", "1234567"),
+		testElement(tagPatientBirthDate, "DA", "19500312"),
+		testElement(tagPatientBirthTime, "TM", "0830"),
+		testElement(dicomtag.PatientSex, "CS", "F"),
+		testElement(tagDateOfLastCalibration, "DA", "20150530"),
+		testElement(tagExclusionStartDateTime, "DT", "20150601101500"),
+		testElement(tagPrivateGE, "DS", "12.5"),
+		testElement(dicomtag.StudyInstanceUID, "UI", testStudyUID),
+		testElement(dicomtag.FrameOfReferenceUID, "UI", testFrameUID),
+		testElement(tagPrivateCreator, "LO", "SIEMENS CSA HEADER"),
+		testElement(tagSiemensCSAImageHeader, "OB", []byte("SV10\x04\x03\x02\x01")),
+		testElement(tagSiemensCSASeriesHeader, "OB", []byte("SV10\x04\x03\x02\x01")),
+		testElement(tagSiemensOther, "OB", []byte("ABCD")),
+	}}
+}
+
+func findTestElement(t *testing.T, elems []*element.Element, tag dicomtag.Tag) *element.Element {
+	t.Helper()
+	for _, elem := range elems {
+		if elem.Tag == tag {
+			return elem
+		}
+	}
+	return nil
+}
+
+func testDeidentifier() Deidentifier {
+	return Deidentifier{UIDSecret: []byte("test secret")}
+}
+
+func TestApplyBasicProfile(t *testing.T) {
+	ds := testDataSet(testSOPUIDFirst)
+
+	newUID, err := testDeidentifier().Apply(ds, Subject{NewID: "pseudo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name    string
+		Tag     dicomtag.Tag
+		Present bool
+		Value   []interface{}
+	}{
+		{"Z action empties PatientName", dicomtag.PatientName, true, nil},
+		{"Z action empties PatientID", dicomtag.PatientID, true, nil},
+		{"Z action empties StudyDate", dicomtag.StudyDate, true, nil},
+		{"Z action empties StudyTime", dicomtag.StudyTime, true, nil},
+		{"Z action empties PatientBirthDate", tagPatientBirthDate, true, nil},
+		{"Z action empties PatientSex", dicomtag.PatientSex, true, nil},
+		{"X action removes SeriesDate", dicomtag.SeriesDate, false, nil},
+		{"X action removes InstitutionName", dicomtag.InstitutionName, false, nil},
+		{"X action removes PatientBirthTime", tagPatientBirthTime, false, nil},
+		{"Z action empties InstanceCreationDate", tagInstanceCreationDate, true, nil},
+		{"X action removes DateOfLastCalibration", tagDateOfLastCalibration, false, nil},
+		{"Unlisted dates and times are removed", tagExclusionStartDateTime, false, nil},
+		{"Standard SOP class UIDs are kept", dicomtag.SOPClassUID, true, []interface{}{testSOPClassUID}},
+		{"Identity is marked as removed", tagPatientIdentityRemoved, true, []interface{}{"YES"}},
+	}
+
+	for _, c := range cases {
+		elem := findTestElement(t, ds.Elements, c.Tag)
+		if (elem != nil) != c.Present {
+			t.Errorf("%s: present was %v, expected %v", c.Name, elem != nil, c.Present)
+			continue
+		}
+		if elem == nil {
+			continue
+		}
+		if len(elem.Value) != len(c.Value) {
+			t.Errorf("%s: value was %v, expected %v", c.Name, elem.Value, c.Value)
+			continue
+		}
+		for i := range c.Value {
+			if elem.Value[i] != c.Value[i] {
+				t.Errorf("%s: value was %v, expected %v", c.Name, elem.Value, c.Value)
+			}
+		}
+	}
+
+	// U action
+	for _, tag := range []dicomtag.Tag{dicomtag.SOPInstanceUID, dicomtag.MediaStorageSOPInstanceUID} {
+		elem := findTestElement(t, ds.Elements, tag)
+		if elem == nil {
+			t.Fatalf("%s was removed", dicomtag.DebugString(tag))
+		}
+		if got := elem.Value[0]; got != newUID || got == testSOPUIDFirst || !strings.HasPrefix(newUID, "2.25.") {
+			t.Errorf("%s was %v, expected the remapped UID %s", dicomtag.DebugString(tag), got, newUID)
+		}
+	}
+
+	for i := 1; i < len(ds.Elements); i++ {
+		if ds.Elements[i-1].Tag.Compare(ds.Elements[i].Tag) >= 0 {
+			t.Errorf("Elements are not in tag order at %s", dicomtag.DebugString(ds.Elements[i].Tag))
+		}
+	}
+}
+
+func TestApplyRecursesIntoSequences(t *testing.T) {
+	ds := testDataSet(testSOPUIDFirst)
+	deid := testDeidentifier()
+
+	if _, err := deid.Apply(ds, Subject{}); err != nil {
+		t.Fatal(err)
+	}
+
+	seq := findTestElement(t, ds.Elements, dicomtag.ReferencedStudySequence)
+	if seq == nil || len(seq.Value) != 1 {
+		t.Fatalf("ReferencedStudySequence was %v, expected one item", seq)
+	}
+
+	var children []*element.Element
+	for _, v := range seq.Value[0].(*element.Element).Value {
+		children = append(children, v.(*element.Element))
+	}
+
+	if findTestElement(t, children, dicomtag.InstitutionName) != nil {
+		t.Errorf("InstitutionName within a sequence was not removed")
+	}
+
+	if elem := findTestElement(t, children, dicomtag.ReferencedSOPInstanceUID); elem == nil || elem.Value[0] != deid.RemapUID(testSOPUIDSecond) {
+		t.Errorf("ReferencedSOPInstanceUID within a sequence was %v, expected %s", elem, deid.RemapUID(testSOPUIDSecond))
+	}
+
+	if elem := findTestElement(t, children, dicomtag.ReferencedSOPClassUID); elem == nil || elem.Value[0] != testSOPClassUID {
+		t.Errorf("ReferencedSOPClassUID within a sequence was %v, expected it to be kept", elem)
+	}
+}
+
+func TestApplyPrivateTags(t *testing.T) {
+	cases := []struct {
+		KeepCSA bool
+		Tag     dicomtag.Tag
+		Kept    bool
+	}{
+		{false, tagPrivateGE, false},
+		{false, tagPrivateCreator, false},
+		{false, tagSiemensCSAImageHeader, false},
+		{false, tagSiemensCSASeriesHeader, false},
+		{false, tagSiemensOther, false},
+		{true, tagPrivateGE, false},
+		{true, tagPrivateCreator, true},
+		{true, tagSiemensCSAImageHeader, true},
+		{true, tagSiemensCSASeriesHeader, true},
+		{true, tagSiemensOther, false},
+	}
+
+	for _, c := range cases {
+		ds := testDataSet(testSOPUIDFirst)
+		deid := testDeidentifier()
+		deid.KeepCSA = c.KeepCSA
+
+		if _, err := deid.Apply(ds, Subject{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if kept := findTestElement(t, ds.Elements, c.Tag) != nil; kept != c.Kept {
+			t.Errorf("KeepCSA=%v %s: kept was %v, expected %v", c.KeepCSA, dicomtag.DebugString(c.Tag), kept, c.Kept)
+		}
+	}
+}
+
+func TestApplyShiftDates(t *testing.T) {
+	ds := testDataSet(testSOPUIDFirst)
+	deid := testDeidentifier()
+	deid.ShiftDates = true
+
+	if _, err := deid.Apply(ds, Subject{DateOffsetDays: 31}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name    string
+		Tag     dicomtag.Tag
+		Present bool
+		Value   []interface{}
+	}{
+		{"StudyDate is shifted", dicomtag.StudyDate, true, []interface{}{"20150702"}},
+		{"SeriesDate is retained and shifted", dicomtag.SeriesDate, true, []interface{}{"20150702"}},
+		{"StudyTime is retained", dicomtag.StudyTime, true, []interface{}{"101500"}},
+		{"InstanceCreationDate is retained and shifted", tagInstanceCreationDate, true, []interface{}{"20150702"}},
+		{"DateOfLastCalibration is retained and shifted", tagDateOfLastCalibration, true, []interface{}{"20150630"}},
+		{"Unlisted dates and times are retained and shifted", tagExclusionStartDateTime, true, []interface{}{"20150702101500"}},
+		{"PatientBirthDate is still emptied", tagPatientBirthDate, true, nil},
+		{"PatientBirthTime is still removed", tagPatientBirthTime, false, nil},
+		{"Dates are marked as modified", tagLongitudinalTemporalInformationModified, true, []interface{}{"MODIFIED"}},
+	}
+
+	for _, c := range cases {
+		elem := findTestElement(t, ds.Elements, c.Tag)
+		if (elem != nil) != c.Present {
+			t.Errorf("%s: present was %v, expected %v", c.Name, elem != nil, c.Present)
+			continue
+		}
+		if elem == nil {
+			continue
+		}
+		if len(elem.Value) != len(c.Value) {
+			t.Errorf("%s: value was %v, expected %v", c.Name, elem.Value, c.Value)
+			continue
+		}
+		for i := range c.Value {
+			if elem.Value[i] != c.Value[i] {
+				t.Errorf("%s: value was %v, expected %v", c.Name, elem.Value, c.Value)
+			}
+		}
+	}
+}
+
+func TestShiftDates(t *testing.T) {
+	cases := []struct {
+		VR       string
+		Value    string
+		Offset   int
+		Expected string
+	}{
+		{"DA", "20150601", 10, "20150611"},
+		{"DA", "20151225", 10, "20160104"},
+		{"DA", "20160301", -1, "20160229"},
+		{"DA", "20150101-20150131", 31, "20150201-20150303"},
+		{"DA", "-20150131", 1, "-20150201"},
+		{"DA", "20150131-", 1, "20150201-"},
+		{"DA", "2015", 10, "2015"},
+		{"DT", "20150601101500", 1, "20150602101500"},
+		{"DT", "20150601101500.123456-0500", 1, "20150602101500.123456-0500"},
+		{"DT", "20151231235959+0100", 1, "20160101235959+0100"},
+	}
+
+	for _, c := range cases {
+		elem := testElement(dicomtag.Tag{Group: 0x0008, Element: 0x0020}, c.VR, c.Value)
+		if err := shiftDates(elem, c.Offset); err != nil {
+			t.Errorf("%s %q: %v", c.VR, c.Value, err)
+			continue
+		}
+		if elem.Value[0] != c.Expected {
+			t.Errorf("%s %q shifted by %d: got %q, expected %q", c.VR, c.Value, c.Offset, elem.Value[0], c.Expected)
+		}
+	}
+
+	if err := shiftDates(testElement(dicomtag.StudyDate, "DA", "2015AB01"), 1); err == nil {
+		t.Errorf("Expected an error shifting an invalid date")
+	}
+}
+
+func TestRemapUIDAcrossStudy(t *testing.T) {
+	// Two files from one study, de-identified separately (and, for the
+	// second, by a separate run using the same secret).
+	first, second := testDataSet(testSOPUIDFirst), testDataSet(testSOPUIDSecond)
+
+	firstUID, err := testDeidentifier().Apply(first, Subject{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondUID, err := testDeidentifier().Apply(second, Subject{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if firstUID == secondUID {
+		t.Errorf("Distinct SOPInstanceUIDs were remapped to the same UID %s", firstUID)
+	}
+
+	for _, tag := range []dicomtag.Tag{dicomtag.StudyInstanceUID, dicomtag.FrameOfReferenceUID} {
+		a, b := findTestElement(t, first.Elements, tag), findTestElement(t, second.Elements, tag)
+		if a == nil || b == nil {
+			t.Fatalf("%s was removed", dicomtag.DebugString(tag))
+		}
+		if a.Value[0] != b.Value[0] {
+			t.Errorf("%s was remapped inconsistently: %v and %v", dicomtag.DebugString(tag), a.Value[0], b.Value[0])
+		}
+		if a.Value[0] == testStudyUID || a.Value[0] == testFrameUID {
+			t.Errorf("%s was not remapped", dicomtag.DebugString(tag))
+		}
+	}
+
+	other := Deidentifier{UIDSecret: []byte("another secret")}
+	if other.RemapUID(testStudyUID) == testDeidentifier().RemapUID(testStudyUID) {
+		t.Errorf("Different secrets remapped %s to the same UID", testStudyUID)
+	}
+
+	// 2.25 UIDs hold a 128-bit integer and must fit within 64 characters
+	if uid := testDeidentifier().RemapUID(testStudyUID); !strings.HasPrefix(uid, "2.25.") || len(uid) > 64 {
+		t.Errorf("RemapUID produced %q, expected a 2.25 UID of at most 64 characters", uid)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/carbocation/genomisc"
+	_ "github.com/carbocation/genomisc/compileinfoprint"
+	"github.com/carbocation/pfx"
+)
+
+var (
+	BufferSize = 4096
+	STDOUT     = bufio.NewWriterSize(os.Stdout, BufferSize)
+)
+
+// De-identifies UK Biobank dicom zips according to the DICOM PS3.15 Basic
+// Application Level Confidentiality Profile, writing new zips in the same
+// layout. Emits a report of changed tags to stdout.
+func main() {
+	defer STDOUT.Flush()
+
+	var path, fileList, outDir, lookupFile, uidSecret string
+	var deid Deidentifier
+
+	flag.StringVar(&path, "path", "", "Path where the UKBB bulk .zip files are being held.")
+	flag.StringVar(&fileList, "files", "", "Optional one-column headerless list of zip files (under -path) to process. If not set, all zip files under -path are processed.")
+	flag.StringVar(&outDir, "out", "", "Folder into which the de-identified zip files will be written.")
+	flag.StringVar(&lookupFile, "lookup", "", "Optional headerless tab-delimited file mapping the original sample ID (column 1) to a new ID (column 2) and, for -shift-dates, a date offset in days (column 3). If set, zips for samples not in the file are skipped, and PatientID, PatientName, and the zip filename use the new ID. If not set, PatientID and PatientName are emptied, and the zip filename uses a hash of the original sample ID keyed by -uid-secret.")
+	flag.BoolVar(&deid.ShiftDates, "shift-dates", false, "Retain dates, shifted by each subject's offset from -lookup, rather than removing them. Times are retained. PatientBirthDate and PatientBirthTime are always emptied or removed, as in the Basic Profile.")
+	flag.StringVar(&uidSecret, "uid-secret", "", "Secret used to remap UIDs. Using the same secret maps each UID to the same new UID across runs. Also keys the zip filename pseudonyms when -lookup is not set. If not set, a random secret is used, so UIDs and pseudonyms are only consistent within this run.")
+	flag.BoolVar(&deid.KeepDescriptions, "keep-descriptions", false, "Retain StudyDescription, SeriesDescription, ProtocolName, and PerformedProcedureStepDescription, which are needed to identify UKBB series. You are responsible for ensuring that they contain no identifiers.")
+	flag.BoolVar(&deid.KeepOverlays, "keep-overlays", false, "Retain overlay planes (60xx), e.g., for segmentations. Overlay comments are always removed.")
+	flag.BoolVar(&deid.KeepCSA, "keep-csa", false, "Retain the Siemens CSA image and series headers (0029,1010) and (0029,1020), which are needed for, e.g., dicomvenc. All other private tags are always removed. The CSA series header includes the scanner protocol, which you are responsible for checking.")
+	flag.Parse()
+
+	if path == "" || outDir == "" {
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if deid.ShiftDates && lookupFile == "" {
+		log.Fatalln("-shift-dates requires -lookup with a date offset for each subject")
+	}
+
+	var subjects map[string]Subject
+	if lookupFile != "" {
+		var err error
+		subjects, err = ReadLookup(lookupFile, deid.ShiftDates)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		deid.RemapPatientID = true
+	}
+
+	if uidSecret != "" {
+		deid.UIDSecret = []byte(uidSecret)
+	} else {
+		deid.UIDSecret = make([]byte, 32)
+		if _, err := rand.Read(deid.UIDSecret); err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("No -uid-secret given. Using a random secret, so UIDs will not match those from other runs.")
+	}
+
+	if err := os.MkdirAll(genomisc.ExpandHome(outDir), 0755); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := Run(genomisc.ExpandHome(path), fileList, genomisc.ExpandHome(outDir), deid, subjects); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Run processes each zip, a few at a time, and serializes the report of
+// changed tags to STDOUT. Zips that fail are logged, and Run then returns an
+// error with their count so that the process exits non-zero. Zips skipped
+// because their sample is not in the lookup table do not count as failures.
+func Run(path, fileList, outDir string, deid Deidentifier, subjects map[string]Subject) error {
+	files, err := zipFiles(path, fileList)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(STDOUT, strings.Join([]string{
+		"zip_file",
+		"dicom_file",
+		"deid_zip_file",
+		"deid_dicom_file",
+		"tag",
+		"keyword",
+		"change",
+	}, "\t"))
+
+	concurrency := runtime.NumCPU()
+
+	report := make(chan string, concurrency)
+	doneListening := make(chan struct{})
+	go func() {
+		defer func() { doneListening <- struct{}{} }()
+		// Serialize results so you don't dump text haphazardly into os.Stdout
+		// (which is not goroutine safe).
+		for res := range report {
+			fmt.Fprintln(STDOUT, res)
+		}
+	}()
+
+	// Ensure a path separator if we are using a path
+	if path != "" && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	semaphore := make(chan struct{}, concurrency)
+	var failed, processed int64
+
+	for _, file := range files {
+
+		// Will block after `concurrency` simultaneous goroutines are running
+		semaphore <- struct{}{}
+
+		go func(file string) {
+
+			// Be sure to permit unblocking once we finish
+			defer func() { <-semaphore }()
+
+			if !strings.HasSuffix(file, ".zip") {
+				return
+			}
+
+			atomic.AddInt64(&processed, 1)
+			if err := ProcessZip(path+file, outDir, deid, subjects, report); errors.Is(err, ErrNotInLookup) {
+				log.Println(err)
+			} else if err != nil {
+				log.Println(err)
+				atomic.AddInt64(&failed, 1)
+			}
+		}(file)
+	}
+
+	// Make sure we finish all the zips before we exit
+	for i := 0; i < cap(semaphore); i++ {
+		semaphore <- struct{}{}
+	}
+
+	// Close the report channel and make sure we are done listening
+	close(report)
+	<-doneListening
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d zips failed and were not written", failed, processed)
+	}
+
+	return nil
+}
+
+// ReadLookup reads the headerless, tab-delimited lookup table of original
+// sample ID, new ID, and (if needDateOffset) the date offset in days.
+func ReadLookup(lookupFile string, needDateOffset bool) (map[string]Subject, error) {
+	f, err := os.Open(genomisc.ExpandHome(lookupFile))
+	if err != nil {
+		return nil, pfx.Err(err)
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.Comma = '\t'
+	cr.FieldsPerRecord = -1
+
+	out := make(map[string]Subject)
+	for line := 1; ; line++ {
+		cols, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, pfx.Err(err)
+		}
+
+		if len(cols) < 2 || cols[0] == "" || cols[1] == "" {
+			return nil, fmt.Errorf("%s line %d: expected an original and a new ID", lookupFile, line)
+		}
+
+		s := Subject{NewID: cols[1]}
+
+		if needDateOffset {
+			if len(cols) < 3 {
+				return nil, fmt.Errorf("%s line %d: expected a date offset in the third column", lookupFile, line)
+			}
+			s.DateOffsetDays, err = strconv.Atoi(strings.TrimSpace(cols[2]))
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: could not parse date offset: %v", lookupFile, line, err)
+			}
+		}
+
+		if _, exists := out[cols[0]]; exists {
+			return nil, fmt.Errorf("%s line %d: sample ID %s appears more than once", lookupFile, line, cols[0])
+		}
+
+		out[cols[0]] = s
+	}
+
+	return out, nil
+}
+
+func zipFiles(path, fileList string) ([]string, error) {
+	var files []string
+
+	if fileList != "" {
+		fl, err := os.Open(genomisc.ExpandHome(fileList))
+		if err != nil {
+			return nil, pfx.Err(err)
+		}
+		defer fl.Close()
+
+		cr := csv.NewReader(fl)
+		for {
+			cols, err := cr.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, pfx.Err(err)
+			}
+
+			if len(cols) < 1 || len(cols[0]) < 1 {
+				continue
+			}
+
+			files = append(files, cols[0])
+		}
+
+		return files, nil
+	}
+
+	fileInfos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, pfx.Err(err)
+	}
+
+	for _, f := range fileInfos {
+		if f.IsDir() {
+			continue
+		}
+
+		files = append(files, f.Name())
+	}
+
+	return files, nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"github.com/suyashkumar/dicom/dicomtag"
+)
+
+// profileAction is the action that the DICOM PS3.15 Basic Application Level
+// Confidentiality Profile (Table E.1-1) assigns to an attribute.
+type profileAction byte
+
+const (
+	// actionKeep retains the attribute unchanged. Attributes that are not in
+	// the table are kept, except for private attributes and those with a DA,
+	// DT, or TM value representation, which are removed.
+	actionKeep profileAction = iota
+
+	// actionRemove (X) removes the attribute.
+	actionRemove
+
+	// actionZero (Z) replaces the value with a zero-length value.
+	actionZero
+
+	// actionUID (U) replaces the UID with a consistently remapped UID.
+	actionUID
+)
+
+// basicProfile lists the attributes of Table E.1-1 along with the action of
+// the Basic Profile. Where the table permits a choice (e.g., X/Z or Z/D), the
+// choice that keeps the attribute present (Z) is made so that Type 2
+// attributes stay valid; D is always implemented as Z, and X/Z/U* sequences
+// are retained so that the UIDs within them are remapped.
+var basicProfile = map[dicomtag.Tag]profileAction{
+	{Group: 0x0008, Element: 0x0012}: actionZero,   // InstanceCreationDate
+	{Group: 0x0008, Element: 0x0013}: actionZero,   // InstanceCreationTime
+	{Group: 0x0008, Element: 0x0014}: actionUID,    // InstanceCreatorUID
+	{Group: 0x0008, Element: 0x0015}: actionRemove, // InstanceCoercionDateTime
+	{Group: 0x0008, Element: 0x0018}: actionUID,    // SOPInstanceUID
+	{Group: 0x0008, Element: 0x0020}: actionZero,   // StudyDate
+	{Group: 0x0008, Element: 0x0021}: actionRemove, // SeriesDate
+	{Group: 0x0008, Element: 0x0022}: actionZero,   // AcquisitionDate
+	{Group: 0x0008, Element: 0x0023}: actionZero,   // ContentDate
+	{Group: 0x0008, Element: 0x0024}: actionRemove, // OverlayDate
+	{Group: 0x0008, Element: 0x0025}: actionRemove, // CurveDate
+	{Group: 0x0008, Element: 0x002A}: actionRemove, // AcquisitionDateTime
+	{Group: 0x0008, Element: 0x0030}: actionZero,   // StudyTime
+	{Group: 0x0008, Element: 0x0031}: actionRemove, // SeriesTime
+	{Group: 0x0008, Element: 0x0032}: actionZero,   // AcquisitionTime
+	{Group: 0x0008, Element: 0x0033}: actionZero,   // ContentTime
+	{Group: 0x0008, Element: 0x0034}: actionRemove, // OverlayTime
+	{Group: 0x0008, Element: 0x0035}: actionRemove, // CurveTime
+	{Group: 0x0008, Element: 0x0050}: actionZero,   // AccessionNumber
+	{Group: 0x0008, Element: 0x0058}: actionUID,    // FailedSOPInstanceUIDList
+	{Group: 0x0008, Element: 0x0080}: actionRemove, // InstitutionName
+	{Group: 0x0008, Element: 0x0081}: actionRemove, // InstitutionAddress
+	{Group: 0x0008, Element: 0x0082}: actionRemove, // InstitutionCodeSequence
+	{Group: 0x0008, Element: 0x0090}: actionZero,   // ReferringPhysicianName
+	{Group: 0x0008, Element: 0x0092}: actionRemove, // ReferringPhysicianAddress
+	{Group: 0x0008, Element: 0x0094}: actionRemove, // ReferringPhysicianTelephoneNumbers
+	{Group: 0x0008, Element: 0x0096}: actionRemove, // ReferringPhysicianIdentificationSequence
+	{Group: 0x0008, Element: 0x009C}: actionRemove, // ConsultingPhysicianName
+	{Group: 0x0008, Element: 0x009D}: actionRemove, // ConsultingPhysicianIdentificationSequence
+	{Group: 0x0008, Element: 0x0201}: actionRemove, // TimezoneOffsetFromUTC
+	{Group: 0x0008, Element: 0x1010}: actionRemove, // StationName
+	{Group: 0x0008, Element: 0x1030}: actionRemove, // StudyDescription
+	{Group: 0x0008, Element: 0x103E}: actionRemove, // SeriesDescription
+	{Group: 0x0008, Element: 0x1040}: actionRemove, // InstitutionalDepartmentName
+	{Group: 0x0008, Element: 0x1048}: actionRemove, // PhysiciansOfRecord
+	{Group: 0x0008, Element: 0x1049}: actionRemove, // PhysiciansOfRecordIdentificationSequence
+	{Group: 0x0008, Element: 0x1050}: actionRemove, // PerformingPhysicianName
+	{Group: 0x0008, Element: 0x1052}: actionRemove, // PerformingPhysicianIdentificationSequence
+	{Group: 0x0008, Element: 0x1060}: actionRemove, // NameOfPhysiciansReadingStudy
+	{Group: 0x0008, Element: 0x1062}: actionRemove, // PhysiciansReadingStudyIdentificationSequence
+	{Group: 0x0008, Element: 0x1070}: actionRemove, // OperatorsName
+	{Group: 0x0008, Element: 0x1072}: actionRemove, // OperatorIdentificationSequence
+	{Group: 0x0008, Element: 0x1080}: actionRemove, // AdmittingDiagnosesDescription
+	{Group: 0x0008, Element: 0x1084}: actionRemove, // AdmittingDiagnosesCodeSequence
+	{Group: 0x0008, Element: 0x1110}: actionKeep,   // ReferencedStudySequence (UIDs within are remapped)
+	{Group: 0x0008, Element: 0x1111}: actionRemove, // ReferencedPerformedProcedureStepSequence
+	{Group: 0x0008, Element: 0x1120}: actionRemove, // ReferencedPatientSequence
+	{Group: 0x0008, Element: 0x1155}: actionUID,    // ReferencedSOPInstanceUID
+	{Group: 0x0008, Element: 0x1195}: actionUID,    // TransactionUID
+	{Group: 0x0008, Element: 0x2111}: actionRemove, // DerivationDescription
+	{Group: 0x0008, Element: 0x3010}: actionUID,    // IrradiationEventUID
+	{Group: 0x0008, Element: 0x4000}: actionRemove, // IdentifyingComments
+	{Group: 0x0010, Element: 0x0010}: actionZero,   // PatientName
+	{Group: 0x0010, Element: 0x0020}: actionZero,   // PatientID
+	{Group: 0x0010, Element: 0x0021}: actionRemove, // IssuerOfPatientID
+	{Group: 0x0010, Element: 0x0030}: actionZero,   // PatientBirthDate
+	{Group: 0x0010, Element: 0x0032}: actionRemove, // PatientBirthTime
+	{Group: 0x0010, Element: 0x0040}: actionZero,   // PatientSex
+	{Group: 0x0010, Element: 0x0050}: actionRemove, // PatientInsurancePlanCodeSequence
+	{Group: 0x0010, Element: 0x0101}: actionRemove, // PatientPrimaryLanguageCodeSequence
+	{Group: 0x0010, Element: 0x0102}: actionRemove, // PatientPrimaryLanguageModifierCodeSequence
+	{Group: 0x0010, Element: 0x1000}: actionRemove, // OtherPatientIDs
+	{Group: 0x0010, Element: 0x1001}: actionRemove, // OtherPatientNames
+	{Group: 0x0010, Element: 0x1002}: actionRemove, // OtherPatientIDsSequence
+	{Group: 0x0010, Element: 0x1005}: actionRemove, // PatientBirthName
+	{Group: 0x0010, Element: 0x1010}: actionRemove, // PatientAge
+	{Group: 0x0010, Element: 0x1020}: actionRemove, // PatientSize
+	{Group: 0x0010, Element: 0x1030}: actionRemove, // PatientWeight
+	{Group: 0x0010, Element: 0x1040}: actionRemove, // PatientAddress
+	{Group: 0x0010, Element: 0x1060}: actionRemove, // PatientMotherBirthName
+	{Group: 0x0010, Element: 0x1080}: actionRemove, // MilitaryRank
+	{Group: 0x0010, Element: 0x1081}: actionRemove, // BranchOfService
+	{Group: 0x0010, Element: 0x1090}: actionRemove, // MedicalRecordLocator
+	{Group: 0x0010, Element: 0x2000}: actionRemove, // MedicalAlerts
+	{Group: 0x0010, Element: 0x2110}: actionRemove, // Allergies
+	{Group: 0x0010, Element: 0x2150}: actionRemove, // CountryOfResidence
+	{Group: 0x0010, Element: 0x2152}: actionRemove, // RegionOfResidence
+	{Group: 0x0010, Element: 0x2154}: actionRemove, // PatientTelephoneNumbers
+	{Group: 0x0010, Element: 0x2160}: actionRemove, // EthnicGroup
+	{Group: 0x0010, Element: 0x2180}: actionRemove, // Occupation
+	{Group: 0x0010, Element: 0x21A0}: actionRemove, // SmokingStatus
+	{Group: 0x0010, Element: 0x21B0}: actionRemove, // AdditionalPatientHistory
+	{Group: 0x0010, Element: 0x21C0}: actionRemove, // PregnancyStatus
+	{Group: 0x0010, Element: 0x21D0}: actionRemove, // LastMenstrualDate
+	{Group: 0x0010, Element: 0x21F0}: actionRemove, // PatientReligiousPreference
+	{Group: 0x0010, Element: 0x2203}: actionRemove, // PatientSexNeutered
+	{Group: 0x0010, Element: 0x2297}: actionRemove, // ResponsiblePerson
+	{Group: 0x0010, Element: 0x2299}: actionRemove, // ResponsibleOrganization
+	{Group: 0x0010, Element: 0x4000}: actionRemove, // PatientComments
+	{Group: 0x0018, Element: 0x1000}: actionRemove, // DeviceSerialNumber
+	{Group: 0x0018, Element: 0x1002}: actionUID,    // DeviceUID
+	{Group: 0x0018, Element: 0x1004}: actionRemove, // PlateID
+	{Group: 0x0018, Element: 0x1005}: actionRemove, // GeneratorID
+	{Group: 0x0018, Element: 0x1007}: actionRemove, // CassetteID
+	{Group: 0x0018, Element: 0x1008}: actionRemove, // GantryID
+	{Group: 0x0018, Element: 0x1012}: actionRemove, // DateOfSecondaryCapture
+	{Group: 0x0018, Element: 0x1014}: actionRemove, // TimeOfSecondaryCapture
+	{Group: 0x0018, Element: 0x1030}: actionRemove, // ProtocolName
+	{Group: 0x0018, Element: 0x1200}: actionRemove, // DateOfLastCalibration
+	{Group: 0x0018, Element: 0x1201}: actionRemove, // TimeOfLastCalibration
+	{Group: 0x0018, Element: 0x1400}: actionRemove, // AcquisitionDeviceProcessingDescription
+	{Group: 0x0018, Element: 0x4000}: actionRemove, // AcquisitionComments
+	{Group: 0x0018, Element: 0x700A}: actionRemove, // DetectorID
+	{Group: 0x0018, Element: 0x700C}: actionRemove, // DateOfLastDetectorCalibration
+	{Group: 0x0018, Element: 0x700E}: actionRemove, // TimeOfLastDetectorCalibration
+	{Group: 0x0018, Element: 0x9074}: actionZero,   // FrameAcquisitionDateTime
+	{Group: 0x0018, Element: 0x9151}: actionZero,   // FrameReferenceDateTime
+	{Group: 0x0018, Element: 0x9424}: actionRemove, // AcquisitionProtocolDescription
+	{Group: 0x0018, Element: 0x9516}: actionRemove, // StartAcquisitionDateTime
+	{Group: 0x0018, Element: 0x9517}: actionRemove, // EndAcquisitionDateTime
+	{Group: 0x0018, Element: 0x9701}: actionRemove, // DecayCorrectionDateTime
+	{Group: 0x0018, Element: 0xA003}: actionRemove, // ContributionDescription
+	{Group: 0x0020, Element: 0x000D}: actionUID,    // StudyInstanceUID
+	{Group: 0x0020, Element: 0x000E}: actionUID,    // SeriesInstanceUID
+	{Group: 0x0020, Element: 0x0010}: actionZero,   // StudyID
+	{Group: 0x0020, Element: 0x0052}: actionUID,    // FrameOfReferenceUID
+	{Group: 0x0020, Element: 0x0200}: actionUID,    // SynchronizationFrameOfReferenceUID
+	{Group: 0x0020, Element: 0x3401}: actionRemove, // ModifyingDeviceID
+	{Group: 0x0020, Element: 0x3404}: actionRemove, // ModifyingDeviceManufacturer
+	{Group: 0x0020, Element: 0x3406}: actionRemove, // ModifiedImageDescription
+	{Group: 0x0020, Element: 0x4000}: actionRemove, // ImageComments
+	{Group: 0x0020, Element: 0x9158}: actionRemove, // FrameComments
+	{Group: 0x0020, Element: 0x9161}: actionUID,    // ConcatenationUID
+	{Group: 0x0020, Element: 0x9164}: actionUID,    // DimensionOrganizationUID
+	{Group: 0x0028, Element: 0x1199}: actionUID,    // PaletteColorLookupTableUID
+	{Group: 0x0028, Element: 0x1214}: actionUID,    // LargePaletteColorLookupTableUID
+	{Group: 0x0028, Element: 0x4000}: actionRemove, // ImagePresentationComments
+	{Group: 0x0032, Element: 0x0012}: actionRemove, // StudyIDIssuer
+	{Group: 0x0032, Element: 0x0032}: actionRemove, // StudyVerifiedDate
+	{Group: 0x0032, Element: 0x0033}: actionRemove, // StudyVerifiedTime
+	{Group: 0x0032, Element: 0x0034}: actionRemove, // StudyReadDate
+	{Group: 0x0032, Element: 0x0035}: actionRemove, // StudyReadTime
+	{Group: 0x0032, Element: 0x1000}: actionRemove, // ScheduledStudyStartDate
+	{Group: 0x0032, Element: 0x1001}: actionRemove, // ScheduledStudyStartTime
+	{Group: 0x0032, Element: 0x1010}: actionRemove, // ScheduledStudyStopDate
+	{Group: 0x0032, Element: 0x1011}: actionRemove, // ScheduledStudyStopTime
+	{Group: 0x0032, Element: 0x1020}: actionRemove, // ScheduledStudyLocation
+	{Group: 0x0032, Element: 0x1021}: actionRemove, // ScheduledStudyLocationAETitle
+	{Group: 0x0032, Element: 0x1030}: actionRemove, // ReasonForStudy
+	{Group: 0x0032, Element: 0x1032}: actionRemove, // RequestingPhysician
+	{Group: 0x0032, Element: 0x1033}: actionRemove, // RequestingService
+	{Group: 0x0032, Element: 0x1040}: actionRemove, // StudyArrivalDate
+	{Group: 0x0032, Element: 0x1041}: actionRemove, // StudyArrivalTime
+	{Group: 0x0032, Element: 0x1050}: actionRemove, // StudyCompletionDate
+	{Group: 0x0032, Element: 0x1051}: actionRemove, // StudyCompletionTime
+	{Group: 0x0032, Element: 0x1060}: actionRemove, // RequestedProcedureDescription
+	{Group: 0x0032, Element: 0x1070}: actionRemove, // RequestedContrastAgent
+	{Group: 0x0032, Element: 0x4000}: actionRemove, // StudyComments
+	{Group: 0x0038, Element: 0x0010}: actionRemove, // AdmissionID
+	{Group: 0x0038, Element: 0x001A}: actionRemove, // ScheduledAdmissionDate
+	{Group: 0x0038, Element: 0x001B}: actionRemove, // ScheduledAdmissionTime
+	{Group: 0x0038, Element: 0x001C}: actionRemove, // ScheduledDischargeDate
+	{Group: 0x0038, Element: 0x001D}: actionRemove, // ScheduledDischargeTime
+	{Group: 0x0038, Element: 0x0020}: actionRemove, // AdmittingDate
+	{Group: 0x0038, Element: 0x0021}: actionRemove, // AdmittingTime
+	{Group: 0x0038, Element: 0x0030}: actionRemove, // DischargeDate
+	{Group: 0x0038, Element: 0x0032}: actionRemove, // DischargeTime
+	{Group: 0x0038, Element: 0x0300}: actionRemove, // CurrentPatientLocation
+	{Group: 0x0038, Element: 0x0400}: actionRemove, // PatientInstitutionResidence
+	{Group: 0x0038, Element: 0x0500}: actionRemove, // PatientState
+	{Group: 0x0038, Element: 0x4000}: actionRemove, // VisitComments
+	{Group: 0x0040, Element: 0x0001}: actionRemove, // ScheduledStationAETitle
+	{Group: 0x0040, Element: 0x0002}: actionRemove, // ScheduledProcedureStepStartDate
+	{Group: 0x0040, Element: 0x0003}: actionRemove, // ScheduledProcedureStepStartTime
+	{Group: 0x0040, Element: 0x0004}: actionRemove, // ScheduledProcedureStepEndDate
+	{Group: 0x0040, Element: 0x0005}: actionRemove, // ScheduledProcedureStepEndTime
+	{Group: 0x0040, Element: 0x0006}: actionRemove, // ScheduledPerformingPhysicianName
+	{Group: 0x0040, Element: 0x0007}: actionRemove, // ScheduledProcedureStepDescription
+	{Group: 0x0040, Element: 0x000B}: actionRemove, // ScheduledPerformingPhysicianIdentificationSequence
+	{Group: 0x0040, Element: 0x0010}: actionRemove, // ScheduledStationName
+	{Group: 0x0040, Element: 0x0011}: actionRemove, // ScheduledProcedureStepLocation
+	{Group: 0x0040, Element: 0x0241}: actionRemove, // PerformedStationAETitle
+	{Group: 0x0040, Element: 0x0242}: actionRemove, // PerformedStationName
+	{Group: 0x0040, Element: 0x0243}: actionRemove, // PerformedLocation
+	{Group: 0x0040, Element: 0x0244}: actionRemove, // PerformedProcedureStepStartDate
+	{Group: 0x0040, Element: 0x0245}: actionRemove, // PerformedProcedureStepStartTime
+	{Group: 0x0040, Element: 0x0250}: actionRemove, // PerformedProcedureStepEndDate
+	{Group: 0x0040, Element: 0x0251}: actionRemove, // PerformedProcedureStepEndTime
+	{Group: 0x0040, Element: 0x0253}: actionRemove, // PerformedProcedureStepID
+	{Group: 0x0040, Element: 0x0254}: actionRemove, // PerformedProcedureStepDescription
+	{Group: 0x0040, Element: 0x0275}: actionRemove, // RequestAttributesSequence
+	{Group: 0x0040, Element: 0x0280}: actionRemove, // CommentsOnThePerformedProcedureStep
+	{Group: 0x0040, Element: 0x1001}: actionRemove, // RequestedProcedureID
+	{Group: 0x0040, Element: 0x1004}: actionRemove, // PatientTransportArrangements
+	{Group: 0x0040, Element: 0x1005}: actionRemove, // RequestedProcedureLocation
+	{Group: 0x0040, Element: 0x1010}: actionRemove, // NamesOfIntendedRecipientsOfResults
+	{Group: 0x0040, Element: 0x1011}: actionRemove, // IntendedRecipientsOfResultsIdentificationSequence
+	{Group: 0x0040, Element: 0x1102}: actionRemove, // PersonAddress
+	{Group: 0x0040, Element: 0x1103}: actionRemove, // PersonTelephoneNumbers
+	{Group: 0x0040, Element: 0x1400}: actionRemove, // RequestedProcedureComments
+	{Group: 0x0040, Element: 0x2001}: actionRemove, // ReasonForTheImagingServiceRequest
+	{Group: 0x0040, Element: 0x2004}: actionRemove, // IssueDateOfImagingServiceRequest
+	{Group: 0x0040, Element: 0x2005}: actionRemove, // IssueTimeOfImagingServiceRequest
+	{Group: 0x0040, Element: 0x2008}: actionRemove, // OrderEnteredBy
+	{Group: 0x0040, Element: 0x2009}: actionRemove, // OrderEntererLocation
+	{Group: 0x0040, Element: 0x2010}: actionRemove, // OrderCallbackPhoneNumber
+	{Group: 0x0040, Element: 0x2016}: actionZero,   // PlacerOrderNumberImagingServiceRequest
+	{Group: 0x0040, Element: 0x2017}: actionZero,   // FillerOrderNumberImagingServiceRequest
+	{Group: 0x0040, Element: 0x2400}: actionRemove, // ImagingServiceRequestComments
+	{Group: 0x0040, Element: 0x3001}: actionRemove, // ConfidentialityConstraintOnPatientDataDescription
+	{Group: 0x0040, Element: 0xA027}: actionRemove, // VerifyingOrganization
+	{Group: 0x0040, Element: 0xA030}: actionZero,   // VerificationDateTime
+	{Group: 0x0040, Element: 0xA032}: actionZero,   // ObservationDateTime
+	{Group: 0x0040, Element: 0xA073}: actionRemove, // VerifyingObserverSequence
+	{Group: 0x0040, Element: 0xA075}: actionZero,   // VerifyingObserverName
+	{Group: 0x0040, Element: 0xA088}: actionZero,   // VerifyingObserverIdentificationCodeSequence
+	{Group: 0x0040, Element: 0xA120}: actionRemove, // DateTime
+	{Group: 0x0040, Element: 0xA121}: actionRemove, // Date
+	{Group: 0x0040, Element: 0xA122}: actionRemove, // Time
+	{Group: 0x0040, Element: 0xA124}: actionUID,    // UID
+	{Group: 0x0040, Element: 0xA730}: actionRemove, // ContentSequence
+	{Group: 0x0040, Element: 0xDB0C}: actionUID,    // TemplateExtensionOrganizationUID
+	{Group: 0x0040, Element: 0xDB0D}: actionUID,    // TemplateExtensionCreatorUID
+	{Group: 0x0070, Element: 0x0082}: actionRemove, // PresentationCreationDate
+	{Group: 0x0070, Element: 0x0083}: actionRemove, // PresentationCreationTime
+	{Group: 0x0070, Element: 0x0084}: actionZero,   // ContentCreatorName
+	{Group: 0x0070, Element: 0x0086}: actionRemove, // ContentCreatorIdentificationCodeSequence
+	{Group: 0x0088, Element: 0x0140}: actionUID,    // StorageMediaFileSetUID
+	{Group: 0x0400, Element: 0x0100}: actionRemove, // DigitalSignatureUID
+	{Group: 0x0400, Element: 0x0561}: actionRemove, // OriginalAttributesSequence
+	{Group: 0x3006, Element: 0x0008}: actionRemove, // StructureSetDate
+	{Group: 0x3006, Element: 0x0009}: actionRemove, // StructureSetTime
+	{Group: 0x3006, Element: 0x0024}: actionUID,    // ReferencedFrameOfReferenceUID
+	{Group: 0x3006, Element: 0x00C2}: actionUID,    // RelatedFrameOfReferenceUID
+	{Group: 0x300A, Element: 0x0013}: actionUID,    // DoseReferenceUID
+	{Group: 0xFFFA, Element: 0xFFFA}: actionRemove, // DigitalSignaturesSequence
+	{Group: 0xFFFC, Element: 0xFFFC}: actionRemove, // DataSetTrailingPadding
+}
+
+// descriptorTags are free-text descriptions that the Basic Profile removes but
+// that are needed to interpret UK Biobank series. They are retained with
+// -keep-descriptions, and it is the user's responsibility to ensure that they
+// contain no identifying information.
+var descriptorTags = map[dicomtag.Tag]struct{}{
+	{Group: 0x0008, Element: 0x1030}: {}, // StudyDescription
+	{Group: 0x0008, Element: 0x103E}: {}, // SeriesDescription
+	{Group: 0x0018, Element: 0x1030}: {}, // ProtocolName
+	{Group: 0x0040, Element: 0x0254}: {}, // PerformedProcedureStepDescription
+}
+
+// patientTemporalTags are dates and times that identify the patient rather than
+// the imaging, so the Retain Longitudinal Temporal Information Options do not
+// apply to them and the Basic Profile's action is taken even with -shift-dates.
+var patientTemporalTags = map[dicomtag.Tag]struct{}{
+	{Group: 0x0010, Element: 0x0030}: {}, // PatientBirthDate
+	{Group: 0x0010, Element: 0x0032}: {}, // PatientBirthTime
+}
+
+var (
+	tagPatientIdentityRemoved                  = dicomtag.Tag{Group: 0x0012, Element: 0x0062}
+	tagDeidentificationMethod                  = dicomtag.Tag{Group: 0x0012, Element: 0x0063}
+	tagLongitudinalTemporalInformationModified = dicomtag.Tag{Group: 0x0028, Element: 0x0303}
+
+	// Siemens CSA headers, retained with -keep-csa
+	tagSiemensCSAImageHeader  = dicomtag.Tag{Group: 0x0029, Element: 0x1010}
+	tagSiemensCSASeriesHeader = dicomtag.Tag{Group: 0x0029, Element: 0x1020}
+)
+
+// isCurve reports whether the tag is in a repeating curve group (50xx), all of
+// which the Basic Profile removes.
+func isCurve(tag dicomtag.Tag) bool {
+	return tag.Group >= 0x5000 && tag.Group <= 0x501E && tag.Group%2 == 0
+}
+
+// isOverlay reports whether the tag is in a repeating overlay group (60xx).
+func isOverlay(tag dicomtag.Tag) bool {
+	return tag.Group >= 0x6000 && tag.Group <= 0x601E && tag.Group%2 == 0
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/suyashkumar/dicom/dicomio"
+	"github.com/suyashkumar/dicom/dicomtag"
+	"github.com/suyashkumar/dicom/element"
+	"github.com/suyashkumar/dicom/write"
+)
+
+const undefinedLength = 0xffffffff
+
+// WriteDataSet serializes ds as a DICOM file. It delegates to the dicom
+// library's writer for the file meta information and for simple values, but
+// handles sequences, items, AT values, and pixel data itself, since the
+// library's writer cannot round-trip those as they are produced by its parser
+// (e.g., it rejects AT values and multi-frame native pixel data).
+func WriteDataSet(out io.Writer, ds *element.DataSet) error {
+	e := dicomio.NewEncoder(out, nil, dicomio.UnknownVR)
+
+	var metaElems []*element.Element
+	for _, elem := range ds.Elements {
+		if elem.Tag.Group == dicomtag.MetadataGroup {
+			metaElems = append(metaElems, elem)
+		}
+	}
+
+	write.FileHeader(e, metaElems)
+	if e.Error() != nil {
+		return e.Error()
+	}
+
+	endian, implicit, err := ds.TransferSyntax()
+	if err != nil {
+		return err
+	}
+
+	e.PushTransferSyntax(endian, implicit)
+	for _, elem := range ds.Elements {
+		if elem.Tag.Group == dicomtag.MetadataGroup {
+			continue
+		}
+
+		writeElement(e, elem)
+		if e.Error() != nil {
+			return fmt.Errorf("Error writing %s: %v", dicomtag.DebugString(elem.Tag), e.Error())
+		}
+	}
+	e.PopTransferSyntax()
+
+	return e.Error()
+}
+
+func writeElement(e *dicomio.Encoder, elem *element.Element) {
+	switch {
+	case elem.Tag == dicomtag.PixelData:
+		writePixelData(e, elem)
+	case elem.VR == "SQ":
+		// Sequences and items are always written with undefined length, which
+		// avoids having to precompute the length of nested data.
+		writeElementHeader(e, elem.Tag, elem.VR, undefinedLength)
+		for _, v := range elem.Value {
+			item, ok := v.(*element.Element)
+			if !ok {
+				e.SetErrorf("Sequence %s contains a non-item value", dicomtag.DebugString(elem.Tag))
+				return
+			}
+
+			writeElementHeader(e, dicomtag.Item, "", undefinedLength)
+			for _, child := range item.Value {
+				c, ok := child.(*element.Element)
+				if !ok {
+					e.SetErrorf("Item in %s contains a non-element value", dicomtag.DebugString(elem.Tag))
+					return
+				}
+				writeElement(e, c)
+			}
+			writeElementHeader(e, dicomtag.ItemDelimitationItem, "", 0)
+		}
+		writeElementHeader(e, dicomtag.SequenceDelimitationItem, "", 0)
+	case elem.VR == "AT":
+		writeElementHeader(e, elem.Tag, elem.VR, uint32(4*len(elem.Value)))
+		for _, v := range elem.Value {
+			tag, ok := v.(dicomtag.Tag)
+			if !ok {
+				e.SetErrorf("%s: expected a tag but found %v", dicomtag.DebugString(elem.Tag), v)
+				return
+			}
+			e.WriteUInt16(tag.Group)
+			e.WriteUInt16(tag.Element)
+		}
+	case len(elem.Value) == 1 && isBytes(elem.Value[0]) && elem.VR != "OB" && elem.VR != "OW":
+		// E.g., private data with VR UN, which the library writer would
+		// expect to be a string.
+		data := elem.Value[0].([]byte)
+		if len(data)%2 == 1 {
+			data = append(data, 0)
+		}
+		writeElementHeader(e, elem.Tag, elem.VR, uint32(len(data)))
+		e.WriteBytes(data)
+	default:
+		write.Element(e, elem, write.SkipVRVerification)
+	}
+}
+
+func isBytes(v interface{}) bool {
+	_, ok := v.([]byte)
+	return ok
+}
+
+// writePixelData writes encapsulated fragments as-is. Native frames are
+// concatenated, since they are contiguous in the file.
+func writePixelData(e *dicomio.Encoder, elem *element.Element) {
+	if len(elem.Value) != 1 {
+		e.SetErrorf("PixelData must have one value")
+		return
+	}
+
+	info, ok := elem.Value[0].(element.PixelDataInfo)
+	if !ok {
+		e.SetErrorf("PixelData must be a PixelDataInfo")
+		return
+	}
+
+	vr := elem.VR
+	if vr == "" {
+		vr = "OW"
+	}
+
+	if info.IsEncapsulated {
+		writeElementHeader(e, elem.Tag, "OB", undefinedLength)
+
+		// The parser reports an empty basic offset table as a single zero
+		// offset, which is only valid for a single frame.
+		offsets := info.Offsets
+		if len(offsets) == 1 && offsets[0] == 0 {
+			offsets = nil
+		}
+
+		writeElementHeader(e, dicomtag.Item, "", uint32(4*len(offsets)))
+		for _, offset := range offsets {
+			e.WriteUInt32(offset)
+		}
+
+		for _, fr := range info.Frames {
+			data := fr.EncapsulatedData.Data
+			if len(data)%2 == 1 {
+				data = append(data, 0)
+			}
+			writeElementHeader(e, dicomtag.Item, "", uint32(len(data)))
+			e.WriteBytes(data)
+		}
+
+		writeElementHeader(e, dicomtag.SequenceDelimitationItem, "", 0)
+		return
+	}
+
+	length := 0
+	for _, fr := range info.Frames {
+		nd := fr.NativeData
+		if nd.BitsPerSample != 8 && nd.BitsPerSample != 16 {
+			e.SetErrorf("Writing native pixel data with %d bits per sample is not supported", nd.BitsPerSample)
+			return
+		}
+		for _, pixel := range nd.Data {
+			length += len(pixel) * nd.BitsPerSample / 8
+		}
+	}
+
+	padded := length
+	if padded%2 == 1 {
+		padded++
+	}
+
+	writeElementHeader(e, elem.Tag, vr, uint32(padded))
+
+	for _, fr := range info.Frames {
+		nd := fr.NativeData
+		for _, pixel := range nd.Data {
+			for _, sample := range pixel {
+				if nd.BitsPerSample == 8 {
+					e.WriteByte(byte(sample))
+					continue
+				}
+
+				e.WriteUInt16(uint16(sample))
+			}
+		}
+	}
+
+	if padded != length {
+		e.WriteByte(0)
+	}
+}
+
+// writeElementHeader mirrors the dicom library's (unexported) header encoder.
+// Item and delimitation tags are always written with implicit VR.
+func writeElementHeader(e *dicomio.Encoder, tag dicomtag.Tag, vr string, vl uint32) {
+	e.WriteUInt16(tag.Group)
+	e.WriteUInt16(tag.Element)
+
+	_, implicit := e.TransferSyntax()
+	if implicit == dicomio.ImplicitVR || tag.Group == dicomtag.GROUP_ItemSeq {
+		e.WriteUInt32(vl)
+		return
+	}
+
+	e.WriteString(vr)
+	switch vr {
+	case "OB", "OD", "OF", "OL", "OW", "SQ", "UN", "UC", "UR", "UT", "OV", "SV", "UV":
+		e.WriteZeros(2)
+		e.WriteUInt32(vl)
+	default:
+		e.WriteUInt16(uint16(vl))
+	}
+}
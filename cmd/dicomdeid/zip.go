@@ -0,0 +1,251 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+	"github.com/carbocation/pfx"
+	"github.com/suyashkumar/dicom/dicomtag"
+	"github.com/suyashkumar/dicom/element"
+)
+
+// ErrNotInLookup is returned by ProcessZip for a zip whose sample ID is not in
+// the lookup table. Such zips are skipped by design rather than failed.
+var ErrNotInLookup = errors.New("sample ID is not in the lookup table; skipping")
+
+// ProcessZip de-identifies every dicom within one UK Biobank zip and writes a
+// new zip, named sampleID_fieldID_instance_index.zip like the original (with
+// the remapped sample ID, or else a pseudonym for it), into outDir. The zip's
+// manifest is not copied, since it lists identifiers. Each dicom is renamed
+// after its new SOPInstanceUID. If any dicom cannot be de-identified, the
+// whole zip fails and no output is written. Changed tags are sent to report
+// only once the zip is complete.
+func ProcessZip(zipPath, outDir string, deid Deidentifier, subjects map[string]Subject, report chan<- string) error {
+	zipName := filepath.Base(zipPath)
+
+	parts := strings.Split(strings.TrimSuffix(zipName, ".zip"), "_")
+	if len(parts) != 4 {
+		return fmt.Errorf("Expected filename to be of format sampleID_fieldID_instance_index.zip, but found %s", zipName)
+	}
+
+	// Without a lookup table, the sample ID is still an identifier, so the
+	// output zip is named after a keyed hash of it instead.
+	subject := Subject{NewID: deid.PseudonymizeID(parts[0])}
+	if subjects != nil {
+		s, exists := subjects[parts[0]]
+		if !exists {
+			return fmt.Errorf("%s: %w", zipName, ErrNotInLookup)
+		}
+		subject = s
+	}
+
+	outName := strings.Join(append([]string{subject.NewID}, parts[1:]...), "_") + ".zip"
+
+	rc, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return pfx.Err(err)
+	}
+	defer rc.Close()
+
+	// Write to a temporary file first so that an interrupted run never leaves
+	// a partial zip that looks complete.
+	outPath := filepath.Join(outDir, outName)
+	f, err := os.Create(outPath + ".tmp")
+	if err != nil {
+		return pfx.Err(err)
+	}
+	defer os.Remove(outPath + ".tmp")
+
+	zw := zip.NewWriter(f)
+
+	var reportLines []string
+	for _, v := range rc.File {
+		// Looking only at the dicoms
+		if strings.HasPrefix(v.Name, "manifest") {
+			continue
+		}
+
+		original, err := readZipEntry(v)
+		if err != nil {
+			f.Close()
+			return pfx.Err(err)
+		}
+
+		deidentified, newName, err := deidentifyDicom(original, deid, subject)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s %s: %v", zipName, v.Name, err)
+		}
+
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     newName,
+			Method:   zip.Deflate,
+			Modified: v.Modified,
+		})
+		if err != nil {
+			f.Close()
+			return pfx.Err(err)
+		}
+		if _, err := w.Write(deidentified); err != nil {
+			f.Close()
+			return pfx.Err(err)
+		}
+
+		changes, err := changedTags(original, deidentified)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("%s %s: de-identified output could not be re-read: %v", zipName, v.Name, err)
+		}
+
+		for _, change := range changes {
+			reportLines = append(reportLines, strings.Join([]string{zipName, v.Name, outName, newName, change}, "\t"))
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return pfx.Err(err)
+	}
+	if err := f.Close(); err != nil {
+		return pfx.Err(err)
+	}
+
+	if err := os.Rename(outPath+".tmp", outPath); err != nil {
+		return pfx.Err(err)
+	}
+
+	for _, line := range reportLines {
+		report <- line
+	}
+
+	return nil
+}
+
+func readZipEntry(v *zip.File) ([]byte, error) {
+	r, err := v.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func deidentifyDicom(original []byte, deid Deidentifier, subject Subject) ([]byte, string, error) {
+	ds, err := bulkprocess.DicomToDataSet(bytes.NewReader(original))
+	if err != nil {
+		return nil, "", err
+	}
+
+	newUID, err := deid.Apply(ds, subject)
+	if err != nil {
+		return nil, "", err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteDataSet(buf, ds); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), newUID + ".dcm", nil
+}
+
+// changedTags compares the top-level tags of the original and de-identified
+// dicoms and describes each difference as tag, keyword, and one of "removed",
+// "modified", or "added". Changes within sequences are reported against the
+// sequence. Values are never reported, since they may identify the subject.
+func changedTags(original, deidentified []byte) ([]string, error) {
+	before, err := bulkprocess.DicomToTagMap(bytes.NewReader(original))
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := bulkprocess.DicomToTagMap(bytes.NewReader(deidentified))
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]dicomtag.Tag, 0, len(before)+len(after))
+	for tag := range before {
+		tags = append(tags, tag)
+	}
+	for tag := range after {
+		if _, exists := before[tag]; !exists {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Compare(tags[j]) < 0 })
+
+	var out []string
+	for _, tag := range tags {
+		// The group length is recomputed on every write
+		if tag == dicomtag.FileMetaInformationGroupLength || tag == dicomtag.PixelData {
+			continue
+		}
+
+		b, inBefore := before[tag]
+		a, inAfter := after[tag]
+
+		change := ""
+		switch {
+		case !inAfter:
+			change = "removed"
+		case !inBefore:
+			change = "added"
+		case !dicomValuesEqual(b, a):
+			change = "modified"
+		default:
+			continue
+		}
+
+		keyword := ""
+		if info, err := dicomtag.Find(tag); err == nil {
+			keyword = info.Name
+		} else if dicomtag.IsPrivate(tag.Group) {
+			keyword = "private"
+		}
+
+		out = append(out, strings.Join([]string{tag.String(), keyword, change}, "\t"))
+	}
+
+	return out, nil
+}
+
+// dicomValuesEqual compares parsed values, descending into sequences. The
+// encoded length of sequences and items is ignored, since it is always
+// undefined after rewriting.
+func dicomValuesEqual(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		ae, aIsElem := a[i].(*element.Element)
+		be, bIsElem := b[i].(*element.Element)
+		if aIsElem != bIsElem {
+			return false
+		}
+
+		if aIsElem {
+			if ae.Tag != be.Tag || !dicomValuesEqual(ae.Value, be.Value) {
+				return false
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testDicomBytes(t *testing.T, sopInstanceUID string) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	if err := WriteDataSet(buf, testDataSet(sopInstanceUID)); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func writeTestZip(t *testing.T, zipPath string, contents map[string][]byte) {
+	t.Helper()
+
+	f, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, data := range contents {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChangedTags(t *testing.T) {
+	original := testDicomBytes(t, testSOPUIDFirst)
+
+	deidentified, newName, err := deidentifyDicom(original, testDeidentifier(), Subject{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newName != testDeidentifier().RemapUID(testSOPUIDFirst)+".dcm" {
+		t.Errorf("De-identified dicom was named %s, expected its new SOPInstanceUID", newName)
+	}
+
+	changes, err := changedTags(original, deidentified)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"(0002,0003)\tMediaStorageSOPInstanceUID\tmodified",
+		"(0008,0012)\tInstanceCreationDate\tmodified",
+		"(0008,0018)\tSOPInstanceUID\tmodified",
+		"(0008,0020)\tStudyDate\tmodified",
+		"(0008,0021)\tSeriesDate\tremoved",
+		"(0008,0030)\tStudyTime\tmodified",
+		"(0008,0080)\tInstitutionName\tremoved",
+		"(0008,1110)\tReferencedStudySequence\tmodified",
+		"(0010,0010)\tPatientName\tmodified",
+		"(0010,0020)\tPatientID\tmodified",
+		"(0010,0030)\tPatientBirthDate\tmodified",
+		"(0010,0032)\tPatientBirthTime\tremoved",
+		"(0010,0040)\tPatientSex\tmodified",
+		"(0012,0062)\tPatientIdentityRemoved\tadded",
+		"(0012,0063)\tDeidentificationMethod\tadded",
+		"(0018,1200)\tDateOfLastCalibration\tremoved",
+		"(0018,9804)\tExclusionStartDatetime\tremoved",
+		"(0019,100c)\tprivate\tremoved",
+		"(0020,000d)\tStudyInstanceUID\tmodified",
+		"(0020,0052)\tFrameOfReferenceUID\tmodified",
+		"(0029,0010)\tprivate\tremoved",
+		"(0029,1010)\tprivate\tremoved",
+		"(0029,1020)\tprivate\tremoved",
+		"(0029,1030)\tprivate\tremoved",
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("Got %d changes, expected %d:\n%s", len(changes), len(expected), strings.Join(changes, "\n"))
+	}
+
+	for i := range expected {
+		if !strings.EqualFold(changes[i], expected[i]) {
+			t.Errorf("Change %d was %q, expected %q", i, changes[i], expected[i])
+		}
+	}
+
+	// The report must never carry identifying values
+	for _, change := range changes {
+		for _, phi := range []string{"Doe", "1234567", "19500312", "Cheadle", testSOPUIDFirst} {
+			if strings.Contains(change, phi) {
+				t.Errorf("Change %q includes the original value %q", change, phi)
+			}
+		}
+	}
+}
+
+func TestProcessZipNaming(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "1234567_20209_2_0.zip")
+
+	writeTestZip(t, zipPath, map[string][]byte{
+		"manifest.csv":           []byte("1234567,Doe^Jane\n"),
+		testSOPUIDFirst + ".dcm": testDicomBytes(t, testSOPUIDFirst),
+	})
+
+	deid := testDeidentifier()
+
+	cases := []struct {
+		Name     string
+		Subjects map[string]Subject
+		Expected string
+	}{
+		{"Lookup", map[string]Subject{"1234567": {NewID: "9000001"}}, "9000001_20209_2_0.zip"},
+		{"No lookup", nil, deid.PseudonymizeID("1234567") + "_20209_2_0.zip"},
+	}
+
+	for _, c := range cases {
+		outDir := filepath.Join(dir, strings.ReplaceAll(c.Name, " ", "_"))
+		if err := os.Mkdir(outDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		report := make(chan string, 1000)
+		if err := ProcessZip(zipPath, outDir, deid, c.Subjects, report); err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+			continue
+		}
+		close(report)
+
+		entries, err := os.ReadDir(outDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != c.Expected {
+			t.Errorf("%s: wrote %v, expected only %s", c.Name, entries, c.Expected)
+			continue
+		}
+		if strings.Contains(entries[0].Name(), "1234567") {
+			t.Errorf("%s: output zip %s retains the original sample ID", c.Name, entries[0].Name())
+		}
+
+		for line := range report {
+			if strings.Contains(strings.Join(strings.Split(line, "\t")[2:], "\t"), "1234567") {
+				t.Errorf("%s: de-identified columns of report line %q retain the original sample ID", c.Name, line)
+			}
+		}
+
+		rc, err := zip.OpenReader(filepath.Join(outDir, entries[0].Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rc.File) != 1 || rc.File[0].Name != deid.RemapUID(testSOPUIDFirst)+".dcm" {
+			t.Errorf("%s: zip held %d files, expected only the renamed dicom and no manifest", c.Name, len(rc.File))
+		}
+		rc.Close()
+	}
+
+	if err := ProcessZip(zipPath, dir, deid, map[string]Subject{"7654321": {NewID: "9000002"}}, make(chan string, 1000)); !errors.Is(err, ErrNotInLookup) {
+		t.Errorf("Expected ErrNotInLookup for a sample ID that is not in the lookup table, got %v", err)
+	}
+}
+
+func TestProcessZipFailsOnBadDicom(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "1234567_20209_2_0.zip")
+	writeTestZip(t, zipPath, map[string][]byte{
+		testSOPUIDFirst + ".dcm":  testDicomBytes(t, testSOPUIDFirst),
+		testSOPUIDSecond + ".dcm": []byte("not a dicom"),
+	})
+
+	outDir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	report := make(chan string, 1000)
+	if err := ProcessZip(zipPath, outDir, testDeidentifier(), nil, report); err == nil {
+		t.Errorf("Expected an error for a zip with a dicom that cannot be parsed")
+	}
+	close(report)
+
+	if entries, err := os.ReadDir(outDir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 0 {
+		t.Errorf("Failed zip left %v in the output folder, expected nothing", entries)
+	}
+
+	if n := len(report); n != 0 {
+		t.Errorf("Failed zip sent %d report lines, expected none", n)
+	}
+}
+
+func TestRunReportsFailures(t *testing.T) {
+	dir := t.TempDir()
+	writeTestZip(t, filepath.Join(dir, "1234567_20209_2_0.zip"), map[string][]byte{
+		testSOPUIDFirst + ".dcm": testDicomBytes(t, testSOPUIDFirst),
+	})
+	writeTestZip(t, filepath.Join(dir, "7654321_20209_2_0.zip"), map[string][]byte{
+		testSOPUIDSecond + ".dcm": []byte("not a dicom"),
+	})
+
+	outDir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Run(dir, "", outDir, testDeidentifier(), nil)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 zips failed") {
+		t.Errorf("Run returned %v, expected it to report 1 of 2 zips failed", err)
+	}
+
+	// A sample that is absent from the lookup table is skipped, not failed
+	subjects := map[string]Subject{"1234567": {NewID: "9000001"}}
+	if err := os.Remove(filepath.Join(dir, "7654321_20209_2_0.zip")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestZip(t, filepath.Join(dir, "7654321_20209_2_0.zip"), map[string][]byte{
+		testSOPUIDSecond + ".dcm": testDicomBytes(t, testSOPUIDSecond),
+	})
+	if err := Run(dir, "", outDir, testDeidentifier(), subjects); err != nil {
+		t.Errorf("Run returned %v for a sample that is not in the lookup table, expected it to be skipped", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// staticTissue marks the unlabeled (label 0) pixels whose velocity varies the
+// least over the cardiac cycle: the lowest fraction of them by temporal
+// standard deviation. Moving blood and air (whose phase is noise) vary
+// strongly, so what remains is stationary tissue whose true velocity is zero.
+func staticTissue(frames [][]float64, labels [][]uint, fraction float64) []bool {
+	if len(frames) == 0 {
+		return nil
+	}
+	nPixels := len(frames[0])
+
+	type pixelSD struct {
+		Pixel int
+		SD    float64
+	}
+	candidates := make([]pixelSD, 0, nPixels)
+
+PixelLoop:
+	for p := 0; p < nPixels; p++ {
+		var sum, sumSq float64
+		for t, frame := range frames {
+			if labels[t][p] != 0 {
+				continue PixelLoop
+			}
+			sum += frame[p]
+			sumSq += frame[p] * frame[p]
+		}
+		n := float64(len(frames))
+		variance := sumSq/n - (sum/n)*(sum/n)
+		candidates = append(candidates, pixelSD{Pixel: p, SD: math.Sqrt(math.Max(variance, 0))})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].SD < candidates[j].SD })
+
+	out := make([]bool, nPixels)
+	for _, v := range candidates[:int(fraction*float64(len(candidates)))] {
+		out[v.Pixel] = true
+	}
+
+	return out
+}
+
+// backgroundTerms returns the polynomial terms x^i*y^j, i+j <= order, at pixel
+// p, with x and y scaled to [-1, 1] for numerical stability.
+func backgroundTerms(p, rows, cols, order int) []float64 {
+	x := 2*float64(p%cols)/math.Max(float64(cols-1), 1) - 1
+	y := 2*float64(p/cols)/math.Max(float64(rows-1), 1) - 1
+
+	var out []float64
+	for total := 0; total <= order; total++ {
+		for i := total; i >= 0; i-- {
+			out = append(out, math.Pow(x, float64(i))*math.Pow(y, float64(total-i)))
+		}
+	}
+
+	return out
+}
+
+// fitBackground fits a polynomial surface of the given order (1 is a plane, 2
+// is quadratic) to the time-averaged velocity of the static pixels, by least
+// squares. Eddy currents produce a phase offset that is constant over the
+// cycle and varies smoothly in space, so the surface estimates the offset at
+// every pixel, including within vessels.
+func fitBackground(frames [][]float64, static []bool, rows, cols, order int) ([]float64, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("No frames from which to fit the background")
+	}
+
+	nTerms := (order + 1) * (order + 2) / 2
+
+	var pixels []int
+	for p, isStatic := range static {
+		if isStatic {
+			pixels = append(pixels, p)
+		}
+	}
+	if len(pixels) < 10*nTerms {
+		return nil, fmt.Errorf("Only %d static tissue pixels, too few to fit a background of order %d", len(pixels), order)
+	}
+
+	X := mat.NewDense(len(pixels), nTerms, nil)
+	y := mat.NewVecDense(len(pixels), nil)
+	for i, p := range pixels {
+		X.SetRow(i, backgroundTerms(p, rows, cols, order))
+
+		var mean float64
+		for _, frame := range frames {
+			mean += frame[p]
+		}
+		y.SetVec(i, mean/float64(len(frames)))
+	}
+
+	var beta mat.VecDense
+	if err := beta.SolveVec(X, y); err != nil {
+		return nil, fmt.Errorf("Could not fit the background: %v", err)
+	}
+
+	out := make([]float64, len(static))
+	for p := range out {
+		for k, term := range backgroundTerms(p, rows, cols, order) {
+			out[p] += beta.AtVec(k) * term
+		}
+	}
+
+	return out, nil
+}
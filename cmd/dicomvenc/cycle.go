@@ -0,0 +1,733 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carbocation/genomisc/overlay"
+)
+
+// Settings for the per-cycle mode
+var (
+	backgroundOrder int
+	staticFraction  float64
+)
+
+// vencSeries is every phase image of one series (i.e., one cardiac cycle at
+// one plane), in temporal order, along with the segmentation of each.
+type vencSeries struct {
+	ZipFile      string
+	SeriesNumber string
+	Images       []*vencImage
+	Labels       [][]uint
+}
+
+// labelCycle is the flow through one segmented vessel over one cardiac cycle.
+type labelCycle struct {
+	SeriesNumber string
+	Label        overlay.Label
+
+	// Times are the start of each frame, in seconds after the R wave.
+	Times []float64
+
+	// Flow is the net flow through the vessel in each frame, in cm^3/sec,
+	// positive in the direction of the venc encoding.
+	Flow []float64
+
+	// Direction is +1 if the dominant (systolic) flow is positive, else -1.
+	Direction float64
+
+	Summary cycleSummary
+}
+
+type cycleSummary struct {
+	Frames                 int
+	PixelsMean             float64
+	AreaMeanCM2            float64
+	DurationSec            float64
+	ForwardVolumeCM3       float64
+	BackwardVolumeCM3      float64
+	NetVolumeCM3           float64
+	RegurgitantFraction    float64
+	PeakFlowCM3PerSec      float64
+	TimeToPeakFlowSec      float64
+	PeakVelocityCMPerSec   float64
+	PeakVelocity99CMPerSec float64
+	MeanVelocityCMPerSec   float64
+	UpstrokeTimeSec        float64
+	BackgroundCMPerSec     float64
+	AliasingRiskFrames     int
+	UnwrappedPixels        int
+}
+
+var cycleHeader = []string{
+	"zip_file",
+	"series_number",
+	"label_id",
+	"label_name",
+	"frames",
+	"unwrap_method",
+	"background_order",
+	"static_pixels",
+	"pixels_mean",
+	"area_mean_cm2",
+	"duration_sec",
+	"forward_volume_cm3",
+	"backward_volume_cm3",
+	"net_volume_cm3",
+	"regurgitant_fraction",
+	"peak_flow_cm3_sec",
+	"time_to_peak_flow_sec",
+	"peak_velocity_cm_sec",
+	"peak_velocity_99pct_cm_sec",
+	"mean_velocity_cm_sec",
+	"upstroke_time_sec",
+	"background_offset_cm_sec",
+	"venc_limit",
+	"aliasing_risk_frames",
+	"unwrapped_pixels",
+}
+
+var pwvHeader = []string{
+	"zip_file",
+	"from_series_number",
+	"from_label_name",
+	"to_series_number",
+	"to_label_name",
+	"from_upstroke_time_sec",
+	"to_upstroke_time_sec",
+	"transit_time_sec",
+	"distance_cm",
+	"pwv_m_sec",
+}
+
+// pwvSettings names the two vessels (which may be at the same plane, such as
+// the ascending and descending aorta, or in different series) between which
+// pulse wave velocity is measured, and the path length between them.
+type pwvSettings struct {
+	From, To  string
+	Distances map[string]float64 // zip_file => cm
+	Out       *csv.Writer
+
+	file *os.File
+}
+
+// readPWVDistances reads a tab-delimited file with zip_file and distance_cm
+// columns, giving the centerline distance between the two planes.
+func readPWVDistances(path string) (map[string]float64, error) {
+	out := make(map[string]float64)
+	if path == "" {
+		return out, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = '\t'
+	entries, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	zipCol, distCol := -1, -1
+	for i, row := range entries {
+		if i == 0 {
+			for j, col := range row {
+				if col == "zip_file" {
+					zipCol = j
+				} else if col == "distance_cm" {
+					distCol = j
+				}
+			}
+			if zipCol < 0 || distCol < 0 {
+				return nil, fmt.Errorf("Did not identify zip_file or distance_cm in the header line of %s", path)
+			}
+			continue
+		}
+
+		dist, err := strconv.ParseFloat(row[distCol], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d of %s: %v", i+1, path, err)
+		}
+		out[row[zipCol]] = dist
+	}
+
+	return out, nil
+}
+
+func runCyclesFromManifest(manifest, zipPath, maskFolder, maskSuffix string, config overlay.JSONConfig, pwv *pwvSettings) error {
+	zipMap, err := getZipMap(manifest)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(STDOUT, strings.Join(cycleHeader, "\t"))
+	if pwv != nil {
+		if err := pwv.Out.Write(pwvHeader); err != nil {
+			return err
+		}
+	}
+
+	zipFiles := make([]string, 0, len(zipMap))
+	for zipFile := range zipMap {
+		zipFiles = append(zipFiles, zipFile)
+	}
+	sort.Strings(zipFiles)
+
+	for _, zipFile := range zipFiles {
+
+		// As in runFromManifest, retry on filesystem errors, and only print
+		// once the whole zip has succeeded.
+		for loadAttempts, maxLoadAttempts := 1, 10; loadAttempts <= maxLoadAttempts; loadAttempts++ {
+
+			out, pwvRows, err := processOneZipCycles(zipPath, zipFile, zipMap[zipFile], maskFolder, maskSuffix, config, pwv)
+
+			if err != nil && loadAttempts == maxLoadAttempts {
+				// We've exhausted our retries. Fail hard.
+				log.Fatalln(err)
+			} else if err != nil {
+				log.Println("Sleeping 5s to recover from", err.Error(), ". Attempt #", loadAttempts)
+				time.Sleep(5 * time.Second)
+				continue
+			}
+
+			fmt.Fprint(STDOUT, out)
+			if pwv != nil {
+				if err := pwv.Out.WriteAll(pwvRows); err != nil {
+					return err
+				}
+			}
+
+			// If no error, we don't need to retry
+			break
+		}
+	}
+
+	return nil
+}
+
+func processOneZipCycles(zipPath, zipFile string, dicoms []maskMap, maskFolder, maskSuffix string, config overlay.JSONConfig, pwv *pwvSettings) (string, [][]string, error) {
+	f, err := os.Open(filepath.Join(zipPath, zipFile))
+	if err != nil {
+		return "", nil, fmt.Errorf("processOneZipCycles fatal error (terminating on zip %s): %v", zipFile, err)
+	}
+	defer f.Close()
+
+	// the zip reader wants to know the # of bytes in advance
+	nBytes, err := f.Stat()
+	if err != nil {
+		return "", nil, fmt.Errorf("processOneZipCycles fatal error (terminating on zip %s): %v", zipFile, err)
+	}
+
+	seriesMap := make(map[string]*vencSeries)
+	for _, dicomPair := range dicoms {
+		img, labels, err := func(dicomPair maskMap) (*vencImage, []uint, error) {
+			dcmReadSeeker, err := extractDicomReaderFromZip(f, nBytes.Size(), dicomPair.VencDicom)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			// The mask comes from the cine dicom while we will apply it to the
+			// phase (VENC) dicom.
+			rawOverlayImg, err := overlay.OpenImageFromLocalFile(filepath.Join(maskFolder, dicomPair.CineDicom+maskSuffix))
+			if err != nil {
+				return nil, nil, err
+			}
+
+			labels, err := maskLabels(rawOverlayImg)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			img, err := readVencImage(dcmReadSeeker, dicomPair.VencDicom, rawOverlayImg.Bounds().Size().Y, rawOverlayImg.Bounds().Size().X)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(img.Frames) != 1 {
+				return nil, nil, fmt.Errorf("Expected 1 frame per DICOM, found %d", len(img.Frames))
+			}
+
+			return img, labels, nil
+		}(dicomPair)
+
+		if err != nil && strings.Contains(err.Error(), "input/output error") {
+			return "", nil, fmt.Errorf("processOneZipCycles fatal error (terminating on dicom %s in zip %s): %v", dicomPair.VencDicom, zipFile, err)
+		} else if err != nil {
+			log.Printf("processOneZipCycles error (skipping dicom %s in zip %s): %v\n", dicomPair.VencDicom, zipFile, err)
+			continue
+		}
+
+		series, exists := seriesMap[img.SeriesNumber]
+		if !exists {
+			series = &vencSeries{ZipFile: zipFile, SeriesNumber: img.SeriesNumber}
+			seriesMap[img.SeriesNumber] = series
+		}
+		series.Images = append(series.Images, img)
+		series.Labels = append(series.Labels, labels)
+	}
+
+	seriesNumbers := make([]string, 0, len(seriesMap))
+	for seriesNumber := range seriesMap {
+		seriesNumbers = append(seriesNumbers, seriesNumber)
+	}
+	sort.Strings(seriesNumbers)
+
+	sb := strings.Builder{}
+	var cycles []labelCycle
+	for _, seriesNumber := range seriesNumbers {
+		series := seriesMap[seriesNumber]
+		out, seriesCycles, err := series.analyze(config)
+		if err != nil {
+			log.Printf("processOneZipCycles error (skipping series %s in zip %s): %v\n", seriesNumber, zipFile, err)
+			continue
+		}
+		sb.WriteString(out)
+		cycles = append(cycles, seriesCycles...)
+	}
+
+	var pwvRows [][]string
+	if pwv != nil {
+		row, err := pulseWaveVelocity(zipFile, cycles, pwv)
+		if err != nil {
+			log.Printf("processOneZipCycles error (no PWV for zip %s): %v\n", zipFile, err)
+		} else {
+			pwvRows = append(pwvRows, row)
+		}
+	}
+
+	return sb.String(), pwvRows, nil
+}
+
+// sort orders the images by trigger time if every image has one, and
+// otherwise by instance number.
+func (s *vencSeries) sort() {
+	haveTimes := true
+	for _, img := range s.Images {
+		if math.IsNaN(img.TriggerTime) {
+			haveTimes = false
+		}
+	}
+
+	idx := make([]int, len(s.Images))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		a, b := s.Images[idx[i]], s.Images[idx[j]]
+		if haveTimes {
+			return a.TriggerTime < b.TriggerTime
+		}
+		ai, _ := strconv.Atoi(a.InstanceNumber)
+		bi, _ := strconv.Atoi(b.InstanceNumber)
+		return ai < bi
+	})
+
+	images := make([]*vencImage, len(idx))
+	labels := make([][]uint, len(idx))
+	for i, j := range idx {
+		images[i] = s.Images[j]
+		labels[i] = s.Labels[j]
+	}
+	s.Images, s.Labels = images, labels
+}
+
+// analyze unwraps and background-corrects the series, then summarizes the flow
+// through each labeled vessel over the cycle.
+func (s *vencSeries) analyze(config overlay.JSONConfig) (string, []labelCycle, error) {
+	if len(s.Images) < 3 {
+		return "", nil, fmt.Errorf("Only %d frames in the series", len(s.Images))
+	}
+	s.sort()
+
+	first := s.Images[0]
+	rows, cols := first.Rows, first.Cols
+	venc := first.FlowVenc.FlowVenc
+	for _, img := range s.Images {
+		if img.Rows != rows || img.Cols != cols || img.FlowVenc.FlowVenc != venc {
+			return "", nil, fmt.Errorf("Frames differ in size or VENC")
+		}
+	}
+
+	raw := make([][]float64, len(s.Images))
+	for t, img := range s.Images {
+		raw[t] = img.Frames[0]
+	}
+
+	static := staticTissue(raw, s.Labels, staticFraction)
+	nStatic := 0
+	for _, isStatic := range static {
+		if isStatic {
+			nStatic++
+		}
+	}
+
+	frames := raw
+	if attemptPhaseUnwrapping {
+		switch unwrapMethod {
+		case unwrapSpatial:
+			frames = make([][]float64, len(raw))
+			for t := range raw {
+				frames[t] = unwrapSpatialFrame(raw[t], rows, cols, venc, static)
+			}
+		case unwrapTemporal:
+			frames = unwrapTemporalFrames(raw, rows, cols, venc, static)
+		case unwrapHistogram:
+			frames = s.deAliasFrames(raw, config)
+		}
+	}
+
+	background := make([]float64, rows*cols)
+	if backgroundOrder > 0 {
+		var err error
+		background, err = fitBackground(frames, static, rows, cols, backgroundOrder)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	sb := strings.Builder{}
+	var out []labelCycle
+	for _, label := range config.Labels.Sorted() {
+		lc := labelCycle{SeriesNumber: s.SeriesNumber, Label: label}
+		summary := &lc.Summary
+		summary.Frames = len(frames)
+
+		var totalPixels int
+		var meanVelocitySum float64
+		peakVelocities := make([]float64, len(frames))
+		peak99Velocities := make([]float64, len(frames))
+		meanVelocities := make([]float64, len(frames))
+		var backgroundSum float64
+
+		for t, frame := range frames {
+			img := s.Images[t]
+
+			lc.Times = append(lc.Times, float64(t)*img.DT)
+			if !math.IsNaN(img.TriggerTime) {
+				lc.Times[t] = img.TriggerTime / 1000
+			}
+
+			v := make([]vencPixel, 0)
+			rawMin, rawMax := math.Inf(1), math.Inf(-1)
+			for j, id := range s.Labels[t] {
+				if id != label.ID {
+					continue
+				}
+				corrected := frame[j] - background[j]
+				v = append(v, vencPixel{PixelNumber: j, FlowVenc: corrected})
+				backgroundSum += background[j]
+				rawMin, rawMax = math.Min(rawMin, raw[t][j]), math.Max(rawMax, raw[t][j])
+				if frame[j] != raw[t][j] {
+					summary.UnwrappedPixels++
+				}
+			}
+
+			if len(v) == 0 {
+				lc.Flow = append(lc.Flow, 0)
+				peakVelocities[t], peak99Velocities[t], meanVelocities[t] = math.NaN(), math.NaN(), math.NaN()
+				continue
+			}
+
+			if math.Abs(rawMin) > 0.99*venc || math.Abs(rawMax) > 0.99*venc {
+				summary.AliasingRiskFrames++
+			}
+
+			pixdat := describeSegmentationPixels(v, img.DT, img.PxHeightCM, img.PxWidthCM)
+			lc.Flow = append(lc.Flow, pixdat.FlowCM3PerSec)
+			totalPixels += len(v)
+			summary.AreaMeanCM2 += float64(len(v)) * img.PxHeightCM * img.PxWidthCM
+
+			peakVelocities[t] = pixdat.PixelVelocityMaxCMPerSec
+			peak99Velocities[t] = pixdat.PixelVelocity99PctCMPerSec
+			meanVelocities[t] = pixdat.PixelVelocitySumCMPerSec / float64(len(v))
+			if pixdat.FlowCM3PerSec < 0 {
+				// The extremum in the direction of this frame's bulk flow
+				peakVelocities[t] = pixdat.PixelVelocityMinCMPerSec
+				peak99Velocities[t] = pixdat.PixelVelocity01PctCMPerSec
+			}
+			meanVelocitySum += meanVelocities[t]
+		}
+
+		if totalPixels == 0 {
+			continue
+		}
+
+		summary.PixelsMean = float64(totalPixels) / float64(len(frames))
+		summary.AreaMeanCM2 /= float64(len(frames))
+		summary.BackgroundCMPerSec = backgroundSum / float64(totalPixels)
+
+		lc.summarize(s.Images, peakVelocities, peak99Velocities, meanVelocities)
+
+		sb.WriteString(strings.Join([]string{
+			s.ZipFile,
+			s.SeriesNumber,
+			strconv.FormatUint(uint64(label.ID), 10),
+			strings.ReplaceAll(label.Label, " ", "_"),
+			strconv.Itoa(summary.Frames),
+			unwrapDescription(),
+			strconv.Itoa(backgroundOrder),
+			strconv.Itoa(nStatic),
+			formatCycleFloat(summary.PixelsMean),
+			formatCycleFloat(summary.AreaMeanCM2),
+			formatCycleFloat(summary.DurationSec),
+			formatCycleFloat(summary.ForwardVolumeCM3),
+			formatCycleFloat(summary.BackwardVolumeCM3),
+			formatCycleFloat(summary.NetVolumeCM3),
+			formatCycleFloat(summary.RegurgitantFraction),
+			formatCycleFloat(summary.PeakFlowCM3PerSec),
+			formatCycleFloat(summary.TimeToPeakFlowSec),
+			formatCycleFloat(summary.PeakVelocityCMPerSec),
+			formatCycleFloat(summary.PeakVelocity99CMPerSec),
+			formatCycleFloat(summary.MeanVelocityCMPerSec),
+			formatCycleFloat(summary.UpstrokeTimeSec),
+			formatCycleFloat(summary.BackgroundCMPerSec),
+			formatCycleFloat(venc),
+			strconv.Itoa(summary.AliasingRiskFrames),
+			strconv.Itoa(summary.UnwrappedPixels),
+		}, "\t"))
+		sb.WriteString("\n")
+
+		out = append(out, lc)
+	}
+
+	return sb.String(), out, nil
+}
+
+// deAliasFrames applies the histogram heuristic to each vessel in each frame
+// that is at risk of aliasing.
+func (s *vencSeries) deAliasFrames(raw [][]float64, config overlay.JSONConfig) [][]float64 {
+	out := make([][]float64, len(raw))
+	for t, frame := range raw {
+		img := s.Images[t]
+		out[t] = make([]float64, len(frame))
+		copy(out[t], frame)
+
+		for _, label := range config.Labels.Sorted() {
+			var v []vencPixel
+			for j, id := range s.Labels[t] {
+				if id == label.ID {
+					v = append(v, vencPixel{PixelNumber: j, FlowVenc: frame[j]})
+				}
+			}
+			if len(v) == 0 {
+				continue
+			}
+
+			pixdat := describeSegmentationPixels(v, img.DT, img.PxHeightCM, img.PxWidthCM)
+			if math.Abs(pixdat.PixelVelocityMaxCMPerSec) <= 0.99*img.FlowVenc.FlowVenc &&
+				math.Abs(pixdat.PixelVelocityMinCMPerSec) <= 0.99*img.FlowVenc.FlowVenc {
+				continue
+			}
+
+			_, unwrappedV, unwrapped := deAlias(pixdat, img.FlowVenc, img.DT, img.PxHeightCM, img.PxWidthCM, v)
+			if !unwrapped {
+				continue
+			}
+			for _, px := range unwrappedV {
+				out[t][px.PixelNumber] = px.FlowVenc
+			}
+		}
+	}
+
+	return out
+}
+
+// summarize derives the per-cycle flow phenotypes. Forward flow is in the
+// direction of the largest-magnitude flow, which for arteries is systolic
+// ejection; backward flow in the opposite direction (e.g., aortic
+// regurgitation in diastole) is reported separately, and the regurgitant
+// fraction is backward volume divided by forward volume.
+func (lc *labelCycle) summarize(images []*vencImage, peakVelocities, peak99Velocities, meanVelocities []float64) {
+	summary := &lc.Summary
+
+	peak := 0
+	for t, q := range lc.Flow {
+		if math.Abs(q) > math.Abs(lc.Flow[peak]) {
+			peak = t
+		}
+	}
+	lc.Direction = 1
+	if lc.Flow[peak] < 0 {
+		lc.Direction = -1
+	}
+
+	for t, q := range lc.Flow {
+		dt := images[t].DT
+		summary.DurationSec += dt
+		if forward := lc.Direction * q; forward > 0 {
+			summary.ForwardVolumeCM3 += forward * dt
+		} else {
+			summary.BackwardVolumeCM3 -= forward * dt
+		}
+	}
+	summary.NetVolumeCM3 = summary.ForwardVolumeCM3 - summary.BackwardVolumeCM3
+	summary.RegurgitantFraction = summary.BackwardVolumeCM3 / summary.ForwardVolumeCM3
+
+	summary.PeakFlowCM3PerSec = lc.Direction * lc.Flow[peak]
+	summary.TimeToPeakFlowSec = lc.Times[peak] - lc.Times[0]
+
+	// Peak velocities are taken in the forward direction, from frames whose
+	// bulk flow is also forward.
+	summary.PeakVelocityCMPerSec, summary.PeakVelocity99CMPerSec = math.NaN(), math.NaN()
+	var meanSum float64
+	var meanN int
+	for t := range lc.Flow {
+		if !math.IsNaN(meanVelocities[t]) {
+			meanSum += lc.Direction * meanVelocities[t]
+			meanN++
+		}
+		if lc.Direction*lc.Flow[t] <= 0 || math.IsNaN(peakVelocities[t]) {
+			continue
+		}
+		if v := lc.Direction * peakVelocities[t]; math.IsNaN(summary.PeakVelocityCMPerSec) || v > summary.PeakVelocityCMPerSec {
+			summary.PeakVelocityCMPerSec = v
+		}
+		if v := lc.Direction * peak99Velocities[t]; math.IsNaN(summary.PeakVelocity99CMPerSec) || v > summary.PeakVelocity99CMPerSec {
+			summary.PeakVelocity99CMPerSec = v
+		}
+	}
+	summary.MeanVelocityCMPerSec = meanSum / float64(meanN)
+
+	summary.UpstrokeTimeSec = upstrokeTime(lc.Times, lc.Flow, lc.Direction, peak)
+}
+
+// upstrokeTime finds the foot of the systolic flow wave by the intersecting
+// tangent method: the time at which the tangent through the steepest point of
+// the upstroke crosses the minimum flow that precedes it. This gives
+// sub-frame resolution, which matters since frames are ~20-30 ms apart.
+func upstrokeTime(times, flow []float64, direction float64, peak int) float64 {
+	if peak < 1 {
+		return math.NaN()
+	}
+
+	steepest, slope := -1, 0.0
+	for t := 0; t < peak; t++ {
+		s := direction * (flow[t+1] - flow[t]) / (times[t+1] - times[t])
+		if s > slope {
+			steepest, slope = t, s
+		}
+	}
+	if steepest < 0 {
+		return math.NaN()
+	}
+
+	baseline := direction * flow[0]
+	for t := 0; t <= steepest; t++ {
+		baseline = math.Min(baseline, direction*flow[t])
+	}
+
+	midTime := (times[steepest] + times[steepest+1]) / 2
+	midFlow := direction * (flow[steepest] + flow[steepest+1]) / 2
+
+	return midTime - (midFlow-baseline)/slope
+}
+
+// pulseWaveVelocity is the distance between the two vessels divided by the
+// delay between the feet of their flow waves.
+func pulseWaveVelocity(zipFile string, cycles []labelCycle, pwv *pwvSettings) ([]string, error) {
+	find := func(name string) (*labelCycle, error) {
+		var found *labelCycle
+		for i, lc := range cycles {
+			if lc.Label.Label != name {
+				continue
+			}
+			if found != nil {
+				return nil, fmt.Errorf("Label %s was found in more than one series", name)
+			}
+			found = &cycles[i]
+		}
+		if found == nil {
+			return nil, fmt.Errorf("Label %s was not found", name)
+		}
+		return found, nil
+	}
+
+	from, err := find(pwv.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := find(pwv.To)
+	if err != nil {
+		return nil, err
+	}
+
+	transit := to.Summary.UpstrokeTimeSec - from.Summary.UpstrokeTimeSec
+	distance, exists := pwv.Distances[zipFile]
+	if !exists {
+		distance = math.NaN()
+	}
+
+	// cm/sec => m/sec
+	velocity := math.NaN()
+	if transit > 0 {
+		velocity = distance / transit / 100
+	}
+
+	return []string{
+		zipFile,
+		from.SeriesNumber,
+		strings.ReplaceAll(from.Label.Label, " ", "_"),
+		to.SeriesNumber,
+		strings.ReplaceAll(to.Label.Label, " ", "_"),
+		formatCycleFloat(from.Summary.UpstrokeTimeSec),
+		formatCycleFloat(to.Summary.UpstrokeTimeSec),
+		formatCycleFloat(transit),
+		formatCycleFloat(distance),
+		formatCycleFloat(velocity),
+	}, nil
+}
+
+func unwrapDescription() string {
+	if !attemptPhaseUnwrapping {
+		return "none"
+	}
+
+	return unwrapMethod
+}
+
+func formatCycleFloat(x float64) string {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return "NA"
+	}
+
+	return strconv.FormatFloat(x, 'g', 5, 64)
+}
+
+func openPWV(from, to, distancesPath, outPath string) (*pwvSettings, error) {
+	distances, err := readPWVDistances(distancesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		return nil, err
+	}
+
+	out := csv.NewWriter(f)
+	out.Comma = '\t'
+
+	return &pwvSettings{From: from, To: to, Distances: distances, Out: out, file: f}, nil
+}
+
+func (p *pwvSettings) Close() error {
+	p.Out.Flush()
+	if err := p.Out.Error(); err != nil {
+		p.file.Close()
+		return err
+	}
+
+	return p.file.Close()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/carbocation/genomisc/overlay"
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+	"github.com/carbocation/pfx"
+	"github.com/suyashkumar/dicom/dicomtag"
+	"github.com/suyashkumar/dicom/element"
+)
+
+// vencImage holds the velocities from one phase-contrast DICOM, along with the
+// acquisition details needed to turn them into flow.
+type vencImage struct {
+	Dicom          string
+	InstanceNumber string
+	SeriesNumber   string
+
+	// TriggerTime is in ms after the R wave; NaN if absent.
+	TriggerTime float64
+
+	Rows, Cols int
+
+	// Frames holds, for each frame, the velocity (cm/sec) of each pixel in
+	// row-major order, with the venc direction sign already applied. UK
+	// Biobank phase DICOMs have one frame each.
+	Frames [][]float64
+
+	FlowVenc   *bulkprocess.VENC
+	DT         float64
+	PxHeightCM float64
+	PxWidthCM  float64
+
+	PhaseContrastN4             string
+	VelocityEncodingDirectionN4 float64
+	Sign                        int
+}
+
+// readVencImage parses a phase-contrast DICOM whose pixels are expected to
+// number rows*cols.
+func readVencImage(f io.ReadSeeker, dicomName string, rows, cols int) (*vencImage, error) {
+	out := &vencImage{
+		Dicom:           dicomName,
+		Rows:            rows,
+		Cols:            cols,
+		TriggerTime:     math.NaN(),
+		PhaseContrastN4: "NO",
+		Sign:            1,
+	}
+
+	meta, err := bulkprocess.DicomToMetadata(f)
+	if err != nil {
+		return nil, err
+	}
+	out.InstanceNumber = meta.InstanceNumber
+	out.SeriesNumber = meta.SeriesNumber
+	if tt, err := strconv.ParseFloat(meta.TriggerTime, 64); err == nil {
+		out.TriggerTime = tt
+	}
+
+	// Reset the DICOM reader
+	f.Seek(0, 0)
+
+	// Make the DICOM fields addressable as a map
+	tagMap, err := bulkprocess.DicomToTagMap(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load VENC data
+	out.FlowVenc, err = fetchFlowVenc(tagMap)
+	if err != nil {
+		return nil, pfx.Err(err)
+	}
+
+	// Load the DICOM pixel data
+	pixelElem, exists := tagMap[dicomtag.PixelData]
+	if !exists {
+		return nil, fmt.Errorf("PixelData not found")
+	}
+
+	out.PxHeightCM, out.PxWidthCM, err = pixelHeightWidthCM(tagMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the duration of time for this frame. Needed to infer VTI.
+	out.DT, err = deltaT(tagMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// Need Siemens header data, if it exists
+	if elem, exists := tagMap[dicomtag.Tag{Group: 0x0029, Element: 0x1010}]; exists {
+		for _, headerRow := range elem {
+			sc, err := bulkprocess.ParseSiemensHeader(headerRow)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range sc.Slice() {
+				if v.Name == "PhaseContrastN4" {
+					for _, subE := range v.SubElementData {
+						out.PhaseContrastN4 = subE
+					}
+				}
+				if v.Name == "VelocityEncodingDirectionN4" && out.PhaseContrastN4 == "YES" {
+					for axis, value := range v.SubElementData {
+						if axis != 2 {
+							continue
+						}
+						out.VelocityEncodingDirectionN4, err = strconv.ParseFloat(value, 64)
+						if err != nil {
+							return nil, err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Not 100% clear to me how to interpret the N4 fields, but they aren't
+	// always there, and they clearly define a +/- directional orientation. When
+	// the Z value (1-based 3rd value) from VelocityEncodingDirectionN4 is
+	// positive, it seems to indicate that the normal Z direction is reversed.
+	// In the normal orientation, positive X is toward the participant's left,
+	// positive Y is toward the participant's posterior, and positive Z is
+	// toward the participant's head. When VelocityEncodingDirectionN4 is
+	// positive, then a positive venc appears to be toward the feet rather than
+	// toward the head.
+	if out.PhaseContrastN4 == "YES" && out.VelocityEncodingDirectionN4 > 0 {
+		out.Sign = -1
+	}
+
+	data := pixelElem[0].(element.PixelDataInfo)
+	for _, frame := range data.Frames {
+		if frame.IsEncapsulated() {
+			return nil, fmt.Errorf("Frame is encapsulated, which we did not expect")
+		}
+
+		// Ensure that the pixels from the DICOM are in agreement with the
+		// pixels from the mask.
+		if x, y := len(frame.NativeData.Data), rows*cols; x != y {
+			return nil, fmt.Errorf("DICOM data has %d pixels but mask data has %d pixels (%d rows and %d cols)", x, y, rows, cols)
+		}
+
+		velocities := make([]float64, len(frame.NativeData.Data))
+		for j := range frame.NativeData.Data {
+			// Apply the "sign" immediately at the pixel level
+			velocities[j] = float64(out.Sign) * out.FlowVenc.PixelIntensityToVelocity(float64(frame.NativeData.Data[j][0]))
+		}
+		out.Frames = append(out.Frames, velocities)
+	}
+
+	return out, nil
+}
+
+// maskLabels returns the segmentation class of each pixel of the mask, in
+// row-major order.
+func maskLabels(rawOverlayImg image.Image) ([]uint, error) {
+	cols := rawOverlayImg.Bounds().Size().X
+	rows := rawOverlayImg.Bounds().Size().Y
+
+	out := make([]uint, rows*cols)
+	for j := range out {
+		idAtPixel, err := overlay.LabeledPixelToID(rawOverlayImg.At(j%cols, j/cols))
+		if err != nil {
+			return nil, err
+		}
+		out[j] = uint(idAtPixel)
+	}
+
+	return out, nil
+}
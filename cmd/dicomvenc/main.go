@@ -10,15 +10,10 @@ import (
 	"math"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	_ "github.com/carbocation/genomisc/compileinfoprint"
 	"github.com/carbocation/genomisc/overlay"
-	"github.com/carbocation/genomisc/ukbb/bulkprocess"
-	"github.com/carbocation/pfx"
-	"github.com/suyashkumar/dicom/dicomtag"
-	"github.com/suyashkumar/dicom/element"
 )
 
 type vencPixel struct {
@@ -36,12 +31,17 @@ var (
 // Consider aliasing in .profile: alias gobuild='go build -ldflags "-X main.builddate=`date -u +%Y-%m-%d:%H:%M:%S%Z`"'
 var builddate string
 
-var attemptPhaseUnwrapping bool
+var (
+	attemptPhaseUnwrapping bool
+	unwrapMethod           string
+)
 
 func main() {
 	defer STDOUT.Flush()
 
 	var inputPath, maskPath, configPath, manifest, zipPath, maskFolder, maskSuffix, plot string
+	var pwvFrom, pwvTo, pwvDistances, pwvOut string
+	var cycle bool
 
 	flag.StringVar(&manifest, "vencmanifest", "", "(Optional) VENC-style mapped manifest file containing Zip names and Dicom names. If provided, --zips and --out are required and --file and --mask will be ignored.")
 	flag.StringVar(&zipPath, "zips", "", "(Required if --manifest is set) Path to the local folder containing the raw UK Biobank zip files")
@@ -52,6 +52,14 @@ func main() {
 	flag.StringVar(&configPath, "config", "", "Path to the config.json file, to interpret the pixel mask meaning.")
 	flag.StringVar(&plot, "plot", "", "(Optional) Produce a plot? Accepts 'vti' or ''.")
 	flag.BoolVar(&attemptPhaseUnwrapping, "unwrap", false, "(Optional) Attempt phase unwrapping if at risk for aliasing?")
+	flag.StringVar(&unwrapMethod, "unwrapmethod", unwrapHistogram, "(Optional) Phase unwrapping method, if --unwrap is set. 'histogram' unwraps each vessel from gaps in its velocity histogram, 'spatial' does quality-guided 2D unwrapping of each image, and 'temporal' (requires --cycle) unwraps each pixel along the cardiac cycle with a spatial consistency check.")
+	flag.BoolVar(&cycle, "cycle", false, "(Optional) Instead of one line per DICOM, emit one line per vessel per series, summarizing flow over the cardiac cycle (forward and backward volume, regurgitant fraction, peak and mean velocity). Requires --vencmanifest.")
+	flag.IntVar(&backgroundOrder, "background", 0, "(Optional) If --cycle is set, order of the polynomial surface (1 = plane, 2 = quadratic) fitted to static tissue to correct background phase offsets. 0 disables correction.")
+	flag.Float64Var(&staticFraction, "staticfraction", 0.25, "(Optional) If --cycle is set, fraction of unlabeled pixels, those with the least variation over the cycle, that are treated as static tissue.")
+	flag.StringVar(&pwvFrom, "pwvfrom", "", "(Optional) If --cycle is set, name of the proximal label (e.g., ascending aorta) for pulse wave velocity.")
+	flag.StringVar(&pwvTo, "pwvto", "", "(Optional) If --cycle is set, name of the distal label (e.g., descending aorta) for pulse wave velocity.")
+	flag.StringVar(&pwvDistances, "pwvdistances", "", "(Optional) Tab-delimited file with zip_file and distance_cm columns, giving the path length between the --pwvfrom and --pwvto vessels. Without it, only the transit time is reported.")
+	flag.StringVar(&pwvOut, "pwvout", "", "(Required if --pwvfrom and --pwvto are set) Path to which the pulse wave velocity table will be written.")
 
 	flag.Parse()
 
@@ -76,6 +84,24 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := validUnwrapMethod(unwrapMethod); err != nil {
+		log.Fatalln(err)
+	}
+
+	// The per-cycle analyses need every frame of the series at once
+	if !cycle && (unwrapMethod == unwrapTemporal || backgroundOrder > 0 || pwvFrom != "" || pwvTo != "") {
+		log.Fatalln("--unwrapmethod temporal, --background, --pwvfrom, and --pwvto require --cycle")
+	}
+	if cycle && (manifest == "" || plot != "") {
+		log.Fatalln("--cycle requires --vencmanifest and cannot be combined with --plot")
+	}
+	if (pwvFrom == "") != (pwvTo == "") || (pwvFrom != "" && pwvOut == "") {
+		log.Fatalln("--pwvfrom, --pwvto, and --pwvout must be set together")
+	}
+	if backgroundOrder < 0 || staticFraction <= 0 || staticFraction > 1 {
+		log.Fatalln("--background must be non-negative and --staticfraction must be in (0, 1]")
+	}
+
 	config, err := overlay.ParseJSONConfigFromPath(configPath)
 	if err != nil {
 		flag.Usage()
@@ -83,7 +109,7 @@ func main() {
 	}
 
 	// Print the header
-	if plot == "" {
+	if plot == "" && !cycle {
 		fmt.Println(strings.Join([]string{
 			"dicom",
 			"label_id",
@@ -117,7 +143,19 @@ func main() {
 		log.Fatalln(err)
 	}
 
-	if plot != "" {
+	if cycle {
+		var pwv *pwvSettings
+		if pwvFrom != "" {
+			pwv, err = openPWV(pwvFrom, pwvTo, pwvDistances, pwvOut)
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+		err = runCyclesFromManifest(manifest, zipPath, maskFolder, maskSuffix, config, pwv)
+		if err == nil && pwv != nil {
+			err = pwv.Close()
+		}
+	} else if plot != "" {
 		err = plotFromManifest(manifest, zipPath, maskFolder, maskSuffix, config)
 	} else if manifest != "" {
 		// Parse from zip files
@@ -160,122 +198,42 @@ func run(f io.ReadSeeker, rawOverlayImg image.Image, dicomName string, config ov
 	cols := rawOverlayImg.Bounds().Size().X
 	rows := rawOverlayImg.Bounds().Size().Y
 
-	// Will store pixels linked with each segmentation class
-	segmentPixels := make(map[uint][]vencPixel)
-
-	meta, err := bulkprocess.DicomToMetadata(f)
-	if err != nil {
-		return "", err
-	}
-
-	// Reset the DICOM reader
-	f.Seek(0, 0)
-
-	// Make the DICOM fields addressable as a map
-	tagMap, err := bulkprocess.DicomToTagMap(f)
-	if err != nil {
-		return "", err
-	}
-
-	// Load VENC data
-	flowVenc, err := fetchFlowVenc(tagMap)
-	if err != nil {
-		return "", pfx.Err(err)
-	}
-
-	// Load the DICOM pixel data
-	pixelElem, exists := tagMap[dicomtag.PixelData]
-	if !exists {
-		return "", fmt.Errorf("PixelData not found")
-	}
-
-	pxHeightCM, pxWidthCM, err := pixelHeightWidthCM(tagMap)
+	img, err := readVencImage(f, dicomName, rows, cols)
 	if err != nil {
 		return "", err
 	}
+	flowVenc := img.FlowVenc
 
-	// Get the duration of time for this frame. Needed to infer VTI.
-	dt, err := deltaT(tagMap)
+	labels, err := maskLabels(rawOverlayImg)
 	if err != nil {
 		return "", err
 	}
 
-	// Need Siemens header data, if it exists
-	phaseContrastN4 := "NO"
-	velocityEncodingDirectionN4 := 0.0
-	if elem, exists := tagMap[dicomtag.Tag{Group: 0x0029, Element: 0x1010}]; exists {
-		for _, headerRow := range elem {
-			sc, err := bulkprocess.ParseSiemensHeader(headerRow)
-			if err != nil {
-				return "", err
-			}
-			for _, v := range sc.Slice() {
-				if v.Name == "PhaseContrastN4" {
-					for _, subE := range v.SubElementData {
-						phaseContrastN4 = subE
-					}
-				}
-				if v.Name == "VelocityEncodingDirectionN4" && phaseContrastN4 == "YES" {
-					for axis, value := range v.SubElementData {
-						if axis != 2 {
-							continue
-						}
-						velocityEncodingDirectionN4, err = strconv.ParseFloat(value, 64)
-						if err != nil {
-							return "", err
-						}
-					}
-				}
-			}
-		}
-	}
+	// Will store pixels linked with each segmentation class
+	segmentPixels := make(map[uint][]vencPixel)
 
-	// Not 100% clear to me how to interpret the N4 fields, but they aren't
-	// always there, and they clearly define a +/- directional orientation. When
-	// the Z value (1-based 3rd value) from VelocityEncodingDirectionN4 is
-	// positive, it seems to indicate that the normal Z direction is reversed.
-	// In the normal orientation, positive X is toward the participant's left,
-	// positive Y is toward the participant's posterior, and positive Z is
-	// toward the participant's head. When VelocityEncodingDirectionN4 is
-	// positive, then a positive venc appears to be toward the feet rather than
-	// toward the head.
-	sign := 1
-	if phaseContrastN4 == "YES" && velocityEncodingDirectionN4 > 0 {
-		sign = -1
-	}
+	// Track whether spatial unwrapping changed any of the class's pixels
+	spatiallyUnwrapped := make(map[uint]bool)
 
 	// Iterate over the DICOM and find all pixels for each class and their VENC
 	// values
-	data := pixelElem[0].(element.PixelDataInfo)
-	for _, frame := range data.Frames {
-		if frame.IsEncapsulated() {
-			return "", fmt.Errorf("Frame is encapsulated, which we did not expect")
-		}
-
-		// Ensure that the pixels from the DICOM are in agreement with the
-		// pixels from the mask.
-		if x, y := len(frame.NativeData.Data), rows*cols; x != y {
-			return "", fmt.Errorf("DICOM data has %d pixels but mask data has %d pixels (%d rows and %d cols)", x, y, rows, cols)
+	for _, frame := range img.Frames {
+		velocities := frame
+		if attemptPhaseUnwrapping && unwrapMethod == unwrapSpatial {
+			reference := make([]bool, len(labels))
+			for j, id := range labels {
+				reference[j] = id == 0
+			}
+			velocities = unwrapSpatialFrame(frame, rows, cols, flowVenc.FlowVenc, reference)
 		}
 
-		// Iterate over the DICOM pixels
-		for j := 0; j < len(frame.NativeData.Data); j++ {
-
-			// Identify which segmentation class this pixel belongs to
-			idAtPixel, err := overlay.LabeledPixelToID(rawOverlayImg.At(j%cols, j/cols))
-			if err != nil {
-				return "", err
+		for j, v := range velocities {
+			if v != frame[j] {
+				spatiallyUnwrapped[labels[j]] = true
 			}
 
-			// Extract the pixel's VENC
-			seg := vencPixel{}
-			seg.PixelNumber = j
-
-			// Apply the "sign" immediately at the pixel level
-			seg.FlowVenc = float64(sign) * flowVenc.PixelIntensityToVelocity(float64(frame.NativeData.Data[j][0]))
-
 			// Save the pixel to the class's pixel map
-			segmentPixels[uint(idAtPixel)] = append(segmentPixels[uint(idAtPixel)], seg)
+			segmentPixels[labels[j]] = append(segmentPixels[labels[j]], vencPixel{PixelNumber: j, FlowVenc: v})
 		}
 	}
 
@@ -287,16 +245,16 @@ func run(f io.ReadSeeker, rawOverlayImg image.Image, dicomName string, config ov
 			continue
 		}
 
-		pixdat := describeSegmentationPixels(v, dt, pxHeightCM, pxWidthCM)
+		pixdat := describeSegmentationPixels(v, img.DT, img.PxHeightCM, img.PxWidthCM)
 
 		// Are we potentially aliasing, based on how close we are getting to an
 		// extremum of +/- FlowVenc?
 		aliasRisk := math.Abs(pixdat.PixelVelocityMaxCMPerSec) > 0.99*flowVenc.FlowVenc ||
 			math.Abs(pixdat.PixelVelocityMinCMPerSec) > 0.99*flowVenc.FlowVenc
 
-		unwrapped := false
-		if aliasRisk && attemptPhaseUnwrapping {
-			pixdat, _, unwrapped = deAlias(pixdat, flowVenc, dt, pxHeightCM, pxWidthCM, v)
+		unwrapped := spatiallyUnwrapped[label.ID]
+		if aliasRisk && attemptPhaseUnwrapping && unwrapMethod == unwrapHistogram {
+			pixdat, _, unwrapped = deAlias(pixdat, flowVenc, img.DT, img.PxHeightCM, img.PxWidthCM, v)
 		}
 
 		sb.WriteString(fmt.Sprintf("%s\t%d\t%s\t%d\t%.5g\t%.5g\t%.5g\t%.5g\t%.5g\t%.5g\t%.5g\t%.5g\t%.5g\t%.5g\t%.5g\t%.5g\t%.5g\t%.5g\t%.5g\t%s\t%s\t%.5g\t%t\t%t\t%t\n",
@@ -304,9 +262,9 @@ func run(f io.ReadSeeker, rawOverlayImg image.Image, dicomName string, config ov
 			label.ID,
 			strings.ReplaceAll(label.Label, " ", "_"),
 			len(v),
-			float64(len(v))*pxHeightCM*pxWidthCM,
+			float64(len(v))*img.PxHeightCM*img.PxWidthCM,
 			pixdat.FlowCM3PerSec,
-			pixdat.FlowCM3PerSec*dt,
+			pixdat.FlowCM3PerSec*img.DT,
 			pixdat.VTIMeanCM,
 			pixdat.VTI99pctCM,
 			pixdat.VTIMaxCM,
@@ -318,11 +276,11 @@ func run(f io.ReadSeeker, rawOverlayImg image.Image, dicomName string, config ov
 			pixdat.PixelVelocity99PctCMPerSec,
 			pixdat.PixelVelocityMaxCMPerSec,
 			flowVenc.FlowVenc,
-			dt,
-			meta.InstanceNumber,
-			phaseContrastN4,
-			velocityEncodingDirectionN4,
-			sign < 0,
+			img.DT,
+			img.InstanceNumber,
+			img.PhaseContrastN4,
+			img.VelocityEncodingDirectionN4,
+			img.Sign < 0,
 			aliasRisk,
 			unwrapped,
 		))
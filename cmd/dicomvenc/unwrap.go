@@ -0,0 +1,237 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Phase unwrapping. Velocities are encoded as phase, so any velocity beyond
+// +/- VENC wraps around to the other end of the range. Each method below
+// returns velocities that may exceed VENC, restoring the multiple of 2*VENC
+// that was lost.
+const (
+	unwrapHistogram = "histogram"
+	unwrapSpatial   = "spatial"
+	unwrapTemporal  = "temporal"
+)
+
+func validUnwrapMethod(method string) error {
+	switch method {
+	case unwrapHistogram, unwrapSpatial, unwrapTemporal:
+		return nil
+	}
+
+	return fmt.Errorf("Unrecognized unwrapping method %q; expected %s, %s, or %s", method, unwrapHistogram, unwrapSpatial, unwrapTemporal)
+}
+
+// wrapVelocity maps v into [-venc, venc).
+func wrapVelocity(v, venc float64) float64 {
+	period := 2 * venc
+	return v - period*math.Floor((v+venc)/period)
+}
+
+// unwrapQuality scores each pixel by how smoothly its velocity agrees with its
+// 4-connected neighbors: the negated mean squared wrapped difference. Pixels
+// inside vessels and static tissue score well; noise (e.g., air) scores
+// poorly, so it is unwrapped last and cannot corrupt its neighbors.
+func unwrapQuality(velocities []float64, rows, cols int, venc float64) []float64 {
+	quality := make([]float64, len(velocities))
+	for p := range velocities {
+		var sum float64
+		var n int
+		for _, q := range neighbors4(p, rows, cols) {
+			d := wrapVelocity(velocities[q]-velocities[p], venc)
+			sum += d * d
+			n++
+		}
+		if n > 0 {
+			quality[p] = -sum / float64(n)
+		}
+	}
+
+	return quality
+}
+
+func neighbors4(p, rows, cols int) []int {
+	out := make([]int, 0, 4)
+	r, c := p/cols, p%cols
+	if r > 0 {
+		out = append(out, p-cols)
+	}
+	if r < rows-1 {
+		out = append(out, p+cols)
+	}
+	if c > 0 {
+		out = append(out, p-1)
+	}
+	if c < cols-1 {
+		out = append(out, p+1)
+	}
+
+	return out
+}
+
+func neighbors8(p, rows, cols int) []int {
+	out := make([]int, 0, 8)
+	r, c := p/cols, p%cols
+	for dr := -1; dr <= 1; dr++ {
+		for dc := -1; dc <= 1; dc++ {
+			if (dr == 0 && dc == 0) || r+dr < 0 || r+dr >= rows || c+dc < 0 || c+dc >= cols {
+				continue
+			}
+			out = append(out, p+dr*cols+dc)
+		}
+	}
+
+	return out
+}
+
+type unwrapCandidate struct {
+	Pixel     int
+	Reference int
+	Quality   float64
+}
+
+type unwrapQueue []unwrapCandidate
+
+func (q unwrapQueue) Len() int            { return len(q) }
+func (q unwrapQueue) Less(i, j int) bool  { return q[i].Quality > q[j].Quality }
+func (q unwrapQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *unwrapQueue) Push(x interface{}) { *q = append(*q, x.(unwrapCandidate)) }
+func (q *unwrapQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// unwrapSpatialFrame performs quality-guided 2D phase unwrapping of one frame.
+// Starting from the highest-quality pixel, it repeatedly unwraps the
+// highest-quality pixel that borders the unwrapped region, relative to that
+// neighbor. Since the result is only determined up to a multiple of 2*VENC,
+// it is then shifted so that the median of the reference pixels (static
+// tissue, or every pixel if reference is nil) is as close to 0 as possible.
+func unwrapSpatialFrame(velocities []float64, rows, cols int, venc float64, reference []bool) []float64 {
+	out := make([]float64, len(velocities))
+	if len(velocities) == 0 {
+		return out
+	}
+
+	quality := unwrapQuality(velocities, rows, cols, venc)
+
+	seed := 0
+	for p, q := range quality {
+		if q > quality[seed] {
+			seed = p
+		}
+	}
+
+	done := make([]bool, len(velocities))
+	out[seed] = velocities[seed]
+	done[seed] = true
+
+	queue := &unwrapQueue{}
+	push := func(p int) {
+		for _, q := range neighbors4(p, rows, cols) {
+			if !done[q] {
+				heap.Push(queue, unwrapCandidate{Pixel: q, Reference: p, Quality: quality[q]})
+			}
+		}
+	}
+	push(seed)
+
+	for queue.Len() > 0 {
+		c := heap.Pop(queue).(unwrapCandidate)
+		if done[c.Pixel] {
+			continue
+		}
+
+		out[c.Pixel] = out[c.Reference] + wrapVelocity(velocities[c.Pixel]-velocities[c.Reference], venc)
+		done[c.Pixel] = true
+		push(c.Pixel)
+	}
+
+	removeWrapOffset(out, venc, reference)
+
+	return out
+}
+
+// removeWrapOffset shifts the velocities by the multiple of 2*VENC that brings
+// the median of the reference pixels closest to 0.
+func removeWrapOffset(velocities []float64, venc float64, reference []bool) {
+	ref := make([]float64, 0, len(velocities))
+	for p, v := range velocities {
+		if reference == nil || reference[p] {
+			ref = append(ref, v)
+		}
+	}
+	if len(ref) == 0 {
+		return
+	}
+
+	sort.Float64s(ref)
+	shift := 2 * venc * math.Round(ref[len(ref)/2]/(2*venc))
+	if shift == 0 {
+		return
+	}
+
+	for p := range velocities {
+		velocities[p] -= shift
+	}
+}
+
+// unwrapTemporalFrames applies Itoh's method along the time axis: each pixel
+// is unwrapped relative to its own value in the preceding frame. The first
+// frame, acquired at the R wave when flow is slowest, is unwrapped spatially to
+// serve as the starting point. Because a single noisy frame can derail a
+// pixel's temporal path, each frame is then checked for spatial consistency:
+// a pixel that differs from the median of its 8 neighbors by more than VENC is
+// moved by the multiple of 2*VENC that brings it closest to that median.
+func unwrapTemporalFrames(frames [][]float64, rows, cols int, venc float64, reference []bool) [][]float64 {
+	out := make([][]float64, len(frames))
+	if len(frames) == 0 {
+		return out
+	}
+
+	out[0] = unwrapSpatialFrame(frames[0], rows, cols, venc, reference)
+
+	for t := 1; t < len(frames); t++ {
+		prev, cur := out[t-1], frames[t]
+		unwrapped := make([]float64, len(cur))
+		for p := range cur {
+			unwrapped[p] = prev[p] + wrapVelocity(cur[p]-prev[p], venc)
+		}
+
+		enforceSpatialConsistency(unwrapped, rows, cols, venc)
+
+		out[t] = unwrapped
+	}
+
+	return out
+}
+
+func enforceSpatialConsistency(velocities []float64, rows, cols int, venc float64) {
+	corrected := make([]float64, len(velocities))
+	copy(corrected, velocities)
+
+	adj := make([]float64, 0, 8)
+	for p, v := range velocities {
+		adj = adj[:0]
+		for _, q := range neighbors8(p, rows, cols) {
+			adj = append(adj, velocities[q])
+		}
+		if len(adj) == 0 {
+			continue
+		}
+		sort.Float64s(adj)
+		med := adj[len(adj)/2]
+
+		if math.Abs(v-med) > venc {
+			corrected[p] = v + 2*venc*math.Round((med-v)/(2*venc))
+		}
+	}
+
+	copy(velocities, corrected)
+}
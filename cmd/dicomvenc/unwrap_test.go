@@ -0,0 +1,114 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+const testVenc = 150.0
+
+// syntheticFrame is a 40x40 image with a plane-shaped background offset and a
+// round vessel whose velocity peaks at peak cm/sec.
+func syntheticFrame(peak float64) (truth []float64, labels []uint) {
+	rows, cols := 40, 40
+	truth = make([]float64, rows*cols)
+	labels = make([]uint, rows*cols)
+	for p := range truth {
+		r, c := float64(p/cols), float64(p%cols)
+		truth[p] = 0.1*r - 0.05*c
+
+		d2 := (r-20)*(r-20) + (c-20)*(c-20)
+		if d2 <= 64 {
+			labels[p] = 1
+			truth[p] += peak * (1 - d2/64)
+		}
+	}
+
+	return truth, labels
+}
+
+func wrapAll(truth []float64) []float64 {
+	out := make([]float64, len(truth))
+	for p, v := range truth {
+		out[p] = wrapVelocity(v, testVenc)
+	}
+	return out
+}
+
+func TestUnwrapSpatialFrame(t *testing.T) {
+	truth, labels := syntheticFrame(1.8 * testVenc)
+
+	reference := make([]bool, len(labels))
+	for p, id := range labels {
+		reference[p] = id == 0
+	}
+
+	unwrapped := unwrapSpatialFrame(wrapAll(truth), 40, 40, testVenc, reference)
+	for p := range truth {
+		if math.Abs(unwrapped[p]-truth[p]) > 1e-6 {
+			t.Fatalf("Pixel %d: expected %f, got %f", p, truth[p], unwrapped[p])
+		}
+	}
+}
+
+func TestUnwrapTemporalFrames(t *testing.T) {
+	var truths, frames [][]float64
+	var labels [][]uint
+	for _, peak := range []float64{0, 0.9, 1.5, 1.9, 1.2, 0.4} {
+		truth, label := syntheticFrame(peak * testVenc)
+		truths = append(truths, truth)
+		frames = append(frames, wrapAll(truth))
+		labels = append(labels, label)
+	}
+
+	static := staticTissue(frames, labels, 0.5)
+	unwrapped := unwrapTemporalFrames(frames, 40, 40, testVenc, static)
+	for i := range truths {
+		for p := range truths[i] {
+			if math.Abs(unwrapped[i][p]-truths[i][p]) > 1e-6 {
+				t.Fatalf("Frame %d pixel %d: expected %f, got %f", i, p, truths[i][p], unwrapped[i][p])
+			}
+		}
+	}
+}
+
+func TestFitBackground(t *testing.T) {
+	truth, labels := syntheticFrame(100)
+	frames := [][]float64{truth, truth}
+
+	static := make([]bool, len(labels))
+	for p, id := range labels {
+		static[p] = id == 0
+	}
+
+	background, err := fitBackground(frames, static, 40, 40, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p := range background {
+		r, c := float64(p/40), float64(p%40)
+		if expected := 0.1*r - 0.05*c; math.Abs(background[p]-expected) > 1e-6 {
+			t.Fatalf("Pixel %d: expected background %f, got %f", p, expected, background[p])
+		}
+	}
+}
+
+func TestUpstrokeTime(t *testing.T) {
+	// Flow rises linearly from 10 at 0.1 sec to 110 at 0.2 sec; extended, the
+	// upstroke crosses the baseline of 10 at 0.1 sec.
+	times := []float64{0, 0.05, 0.1, 0.15, 0.2, 0.25, 0.3}
+	flow := []float64{10, 10, 10, 60, 110, 80, 30}
+
+	if got := upstrokeTime(times, flow, 1, 4); math.Abs(got-0.1) > 1e-9 {
+		t.Errorf("Expected upstroke at 0.1 sec, got %f", got)
+	}
+
+	negated := make([]float64, len(flow))
+	for i, v := range flow {
+		negated[i] = -v
+	}
+	if got := upstrokeTime(times, negated, -1, 4); math.Abs(got-0.1) > 1e-9 {
+		t.Errorf("Expected upstroke at 0.1 sec for reversed flow, got %f", got)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// dixonSlice is one axial slice for which both the fat and the water image
+// are available.
+type dixonSlice struct {
+	Fat   manifestEntry
+	Water manifestEntry
+}
+
+// Z is the slice position along the long axis of the body.
+func (d dixonSlice) Z() float64 {
+	return d.Water.ImagePositionPatientZ
+}
+
+// VoxelML is the volume of one voxel of this slice, in mL. Missing pixel
+// spacing is treated as 1 mm, as in dicom-mip.
+func (d dixonSlice) VoxelML() float64 {
+	size := 1.0
+	for _, v := range []float64{d.Water.PixelWidthNativeX, d.Water.PixelWidthNativeY, d.Water.PixelWidthNativeZ} {
+		if v > 0 {
+			size *= v
+		}
+	}
+
+	return size / 1000
+}
+
+// station is the set of slices acquired together, in one breath hold. The
+// neck-to-knee protocol acquires several stations whose ends overlap.
+type station struct {
+	Key    string
+	Slices []dixonSlice
+}
+
+func (s station) minZ() float64 {
+	return s.Slices[0].Z()
+}
+
+func (s station) maxZ() float64 {
+	return s.Slices[len(s.Slices)-1].Z()
+}
+
+// positionKey identifies images of the same slice in the same zip. The fat
+// and water images from a station share their series name, apart from the
+// suffix, and their position.
+type positionKey struct {
+	Zip    string
+	Series string
+	X      float64
+	Y      float64
+	Z      float64
+}
+
+func newPositionKey(entry manifestEntry, series string) positionKey {
+	round := func(v float64) float64 { return math.Round(v*100) / 100 }
+
+	return positionKey{
+		Zip:    entry.zip,
+		Series: series,
+		X:      round(entry.ImagePositionPatientX),
+		Y:      round(entry.ImagePositionPatientY),
+		Z:      round(entry.ImagePositionPatientZ),
+	}
+}
+
+// pairDixonStations finds, for each water image, the fat image at the same
+// position, and groups the pairs into stations. The series are recognized by
+// their suffixes (e.g., Dixon_BH_17s_F and Dixon_BH_17s_W); in-phase and
+// opposed-phase series are ignored. Stations are distinguished by zip, series
+// and series number, and are returned in order of position with each
+// station's slices sorted by position.
+func pairDixonStations(entries []manifestEntry, fatSuffix, waterSuffix string) ([]station, error) {
+	if fatSuffix == "" || waterSuffix == "" || fatSuffix == waterSuffix {
+		return nil, fmt.Errorf("The fat (%q) and water (%q) series suffixes must be distinct and non-empty", fatSuffix, waterSuffix)
+	}
+
+	fats := make(map[positionKey]manifestEntry)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.series, fatSuffix) {
+			continue
+		}
+		fats[newPositionKey(entry, strings.TrimSuffix(entry.series, fatSuffix))] = entry
+	}
+
+	stationMap := make(map[string]*station)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.series, waterSuffix) {
+			continue
+		}
+
+		fat, exists := fats[newPositionKey(entry, strings.TrimSuffix(entry.series, waterSuffix))]
+		if !exists {
+			continue
+		}
+
+		key := strings.Join([]string{entry.zip, entry.series, entry.Etc[SeriesNumberColumName]}, "\t")
+		if _, exists := stationMap[key]; !exists {
+			stationMap[key] = &station{Key: key}
+		}
+		stationMap[key].Slices = append(stationMap[key].Slices, dixonSlice{Fat: fat, Water: entry})
+	}
+
+	out := make([]station, 0, len(stationMap))
+	for _, s := range stationMap {
+		sort.Slice(s.Slices, func(i, j int) bool { return s.Slices[i].Z() < s.Slices[j].Z() })
+		out = append(out, *s)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].minZ() != out[j].minZ() {
+			return out[i].minZ() < out[j].minZ()
+		}
+		return out[i].Key < out[j].Key
+	})
+
+	return out, nil
+}
+
+// trimStationOverlap removes slices that are covered by more than one station,
+// so that no tissue is counted twice. Where two adjacent stations overlap,
+// each keeps its slices on its own side of the middle of the overlap.
+func trimStationOverlap(stations []station) []dixonSlice {
+	var out []dixonSlice
+
+	for i, s := range stations {
+		lower, upper := math.Inf(-1), math.Inf(1)
+		if i > 0 && stations[i-1].maxZ() >= s.minZ() {
+			lower = (stations[i-1].maxZ() + s.minZ()) / 2
+		}
+		if i < len(stations)-1 && s.maxZ() >= stations[i+1].minZ() {
+			upper = (s.maxZ() + stations[i+1].minZ()) / 2
+		}
+
+		for _, slice := range s.Slices {
+			if slice.Z() > lower && slice.Z() <= upper {
+				out = append(out, slice)
+			}
+		}
+	}
+
+	return out
+}
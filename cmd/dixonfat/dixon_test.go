@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestPairDixonStations(t *testing.T) {
+	var entries []manifestEntry
+	add := func(series, number string, z float64) {
+		entries = append(entries, manifestEntry{
+			zip:                   "1_20201_2_0.zip",
+			series:                series,
+			dicom:                 series + number + "_" + string(rune('a'+len(entries))),
+			ImagePositionPatientZ: z,
+			Etc:                   map[string]string{SeriesNumberColumName: number},
+		})
+	}
+
+	// Two stations, sharing a series name, that overlap between z=40 and
+	// z=50. The in-phase images and the unpaired water image are ignored.
+	for _, z := range []float64{0, 10, 20, 30, 40, 50} {
+		add("Dixon_BH_W", "1", z)
+		add("Dixon_BH_F", "2", z)
+		add("Dixon_BH_in", "3", z)
+	}
+	for _, z := range []float64{40, 50, 60, 70} {
+		add("Dixon_BH_W", "5", z)
+		add("Dixon_BH_F", "6", z)
+	}
+	add("Dixon_BH_W", "5", 80)
+
+	stations, err := pairDixonStations(entries, "_F", "_W")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 2 || len(stations[0].Slices) != 6 || len(stations[1].Slices) != 4 {
+		t.Fatalf("Expected stations of 6 and 4 slices, got %+v", stations)
+	}
+
+	var zs []float64
+	for _, v := range trimStationOverlap(stations) {
+		zs = append(zs, v.Z())
+		if v.Fat.ImagePositionPatientZ != v.Water.ImagePositionPatientZ {
+			t.Errorf("Fat image at %f paired with water image at %f", v.Fat.ImagePositionPatientZ, v.Water.ImagePositionPatientZ)
+		}
+	}
+
+	expected := []float64{0, 10, 20, 30, 40, 50, 60, 70}
+	if len(zs) != len(expected) {
+		t.Fatalf("Expected slices at %v, got %v", expected, zs)
+	}
+	for i := range zs {
+		if zs[i] != expected[i] {
+			t.Fatalf("Expected slices at %v, got %v", expected, zs)
+		}
+	}
+}
+
+func TestAccumulateSlice(t *testing.T) {
+	fat := image.NewGray16(image.Rect(0, 0, 4, 1))
+	water := image.NewGray16(image.Rect(0, 0, 4, 1))
+	mask := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+
+	// Label 1 has a 10% and a 30% fat voxel; label 2 has one voxel with no
+	// signal and one that is all fat.
+	for x, v := range []struct {
+		fat, water uint16
+		label      uint8
+	}{{100, 900, 1}, {300, 700, 1}, {0, 0, 2}, {500, 0, 2}} {
+		fat.SetGray16(x, 0, color.Gray16{Y: v.fat})
+		water.SetGray16(x, 0, color.Gray16{Y: v.water})
+		mask.SetNRGBA(x, 0, color.NRGBA{v.label, v.label, v.label, 255})
+	}
+
+	// 2 x 2 x 5 mm voxels are 0.02 mL.
+	s := dixonSlice{Water: manifestEntry{PixelWidthNativeX: 2, PixelWidthNativeY: 2, PixelWidthNativeZ: 5}}
+
+	stats := make(map[uint]*labelStats)
+	if err := accumulateSlice(stats, fat, water, mask, s.VoxelML(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	l := stats[1]
+	if l.Voxels != 2 || !near(l.VolumeML, 0.04) || !near(l.FatVolumeML, 0.008) || !near(l.WaterVolumeML, 0.032) {
+		t.Errorf("Unexpected volumes for label 1: %+v", l)
+	}
+	if !near(l.MeanPDFF(), 20) || !near(l.MedianPDFF(), 10) {
+		t.Errorf("Expected mean PDFF 20%% and median 10%%, got %f and %f", l.MeanPDFF(), l.MedianPDFF())
+	}
+
+	l = stats[2]
+	if l.Voxels != 2 || l.SignalVoxels != 1 || !near(l.VolumeML, 0.04) || !near(l.MeanPDFF(), 100) {
+		t.Errorf("Unexpected statistics for label 2: %+v", l)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/carbocation/genomisc/overlay"
+)
+
+// pdffBins is the number of histogram bins, each 0.1% wide, used to find the
+// median fat fraction without keeping every voxel in memory.
+const pdffBins = 1001
+
+// labelStats accumulates the voxels of one segmentation label.
+type labelStats struct {
+	Slices   int
+	Voxels   int
+	VolumeML float64
+
+	// Voxels with enough signal to estimate the fat fraction.
+	SignalVoxels  int
+	FatVolumeML   float64
+	WaterVolumeML float64
+	SumPDFF       float64
+	histogram     [pdffBins]int
+}
+
+// MeanPDFF is the mean proton density fat fraction, in percent.
+func (l *labelStats) MeanPDFF() float64 {
+	if l.SignalVoxels == 0 {
+		return math.NaN()
+	}
+
+	return 100 * l.SumPDFF / float64(l.SignalVoxels)
+}
+
+// MedianPDFF is the median proton density fat fraction, in percent, to the
+// nearest 0.1%.
+func (l *labelStats) MedianPDFF() float64 {
+	if l.SignalVoxels == 0 {
+		return math.NaN()
+	}
+
+	seen := 0
+	for bin, count := range l.histogram {
+		seen += count
+		if 2*seen >= l.SignalVoxels {
+			return 100 * float64(bin) / float64(pdffBins-1)
+		}
+	}
+
+	return 100
+}
+
+// fatFraction is F/(F+W) for the magnitude fat and water signals. ok is false
+// if the combined signal does not exceed minSignal, in which case the voxel is
+// background or noise and the fraction is meaningless.
+func fatFraction(fat, water, minSignal float64) (ff float64, ok bool) {
+	total := fat + water
+	if total <= 0 || total <= minSignal {
+		return 0, false
+	}
+
+	return math.Min(1, math.Max(0, fat/total)), true
+}
+
+// accumulateSlice adds the voxels of one slice to the per-label statistics.
+// The fat, water and mask images must all have the same dimensions. The fat
+// and water images must hold the stored (unwindowed) pixel values, since the
+// fat fraction is only meaningful on the original signal scale.
+func accumulateSlice(stats map[uint]*labelStats, fat, water, mask image.Image, voxelML, minSignal float64) error {
+	size := water.Bounds().Size()
+	if fat.Bounds().Size() != size {
+		return fmt.Errorf("Fat image is %v but water image is %v", fat.Bounds().Size(), size)
+	}
+	if mask.Bounds().Size() != size {
+		return fmt.Errorf("Mask is %v but water image is %v", mask.Bounds().Size(), size)
+	}
+
+	fatMin, waterMin, maskMin := fat.Bounds().Min, water.Bounds().Min, mask.Bounds().Min
+
+	seen := make(map[uint]struct{})
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			id32, err := overlay.LabeledPixelToID(mask.At(maskMin.X+x, maskMin.Y+y))
+			if err != nil {
+				return err
+			}
+			id := uint(id32)
+
+			l, exists := stats[id]
+			if !exists {
+				l = &labelStats{}
+				stats[id] = l
+			}
+			if _, exists := seen[id]; !exists {
+				seen[id] = struct{}{}
+				l.Slices++
+			}
+
+			l.Voxels++
+			l.VolumeML += voxelML
+
+			ff, ok := fatFraction(intensity(fat, fatMin.X+x, fatMin.Y+y), intensity(water, waterMin.X+x, waterMin.Y+y), minSignal)
+			if !ok {
+				continue
+			}
+
+			l.SignalVoxels++
+			l.SumPDFF += ff
+			l.FatVolumeML += ff * voxelML
+			l.WaterVolumeML += (1 - ff) * voxelML
+			l.histogram[int(math.Round(ff*float64(pdffBins-1)))]++
+		}
+	}
+
+	return nil
+}
+
+func intensity(img image.Image, x, y int) float64 {
+	if g, ok := img.(*image.Gray16); ok {
+		return float64(g.Gray16At(x, y).Y)
+	}
+
+	return float64(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y)
+}
@@ -0,0 +1,289 @@
+// dixonfat quantifies body composition from the UK Biobank neck-to-knee Dixon
+// MRI. For each sample, it pairs the fat and water images of every station,
+// computes the fat fraction F/(F+W) at each voxel, and, using a segmentation
+// mask in the format of the github.com/carbocation/genomisc/overlay package
+// (e.g., with liver, visceral and subcutaneous adipose tissue, and muscle
+// labels), reports the volume, fat volume, water volume, and mean and median
+// proton density fat fraction (PDFF) of each label. Voxel sizes come from the
+// pixel spacing and slice thickness columns of the manifest, and slices where
+// adjacent stations overlap are only counted once.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	_ "github.com/carbocation/genomisc/compileinfoprint"
+	"github.com/carbocation/genomisc/overlay"
+)
+
+const (
+	SampleIDColumnName = "sample_id"
+	InstanceColumnName = "instance"
+)
+
+const (
+	BufferSize = 4096
+)
+
+var (
+	SeriesColumnName            = "series"
+	SeriesNumberColumName       = "series_number"
+	ZipColumnName               = "zip_file"
+	DicomColumnName             = "dicom_file"
+	PixelWidthNativeXColumn     = "px_width_mm"
+	PixelWidthNativeYColumn     = "px_height_mm"
+	PixelWidthNativeZColumn     = "slice_thickness_mm"
+	ImagePositionPatientXColumn = "image_x"
+	ImagePositionPatientYColumn = "image_y"
+	ImagePositionPatientZColumn = "image_z"
+)
+
+var STDOUT = bufio.NewWriterSize(os.Stdout, BufferSize)
+
+// Safe for concurrent use by multiple goroutines so we'll make this a global
+var client *storage.Client
+
+func init() {
+	flag.Usage = func() {
+		flag.PrintDefaults()
+
+		log.Println("Example JSONConfig file layout:")
+		bts, err := json.MarshalIndent(overlay.JSONConfig{Labels: overlay.LabelMap{"Background": overlay.Label{Color: "", ID: 0}}}, "", "  ")
+		if err == nil {
+			log.Println(string(bts))
+		}
+	}
+}
+
+// options holds the settings that apply to every sample.
+type options struct {
+	Folder      string
+	MaskFolder  string
+	MaskSuffix  string
+	MaskOnFat   bool
+	FatSuffix   string
+	WaterSuffix string
+	MinSignal   float64
+	Config      overlay.JSONConfig
+}
+
+func main() {
+	defer STDOUT.Flush()
+
+	var manifest, jsonConfig, maskOn string
+	var concurrency int
+	opts := options{}
+
+	flag.StringVar(&manifest, "manifest", "", "Path to manifest file")
+	flag.StringVar(&opts.Folder, "folder", "", "Path to the local or google storage (gs://) folder that contains zip files.")
+	flag.StringVar(&opts.MaskFolder, "maskfolder", "", "Path to the local or google storage (gs://) folder that contains the segmentation masks.")
+	flag.StringVar(&opts.MaskSuffix, "masksuffix", ".png.mask.png", "(Optional) Suffix placed after the dicom name to find its mask.")
+	flag.StringVar(&maskOn, "maskon", "water", "(Optional) Which series' dicom names the masks are named after: 'water' or 'fat'.")
+	flag.StringVar(&jsonConfig, "config", "", "JSONConfig file from the github.com/carbocation/genomisc/overlay package, to interpret the mask labels.")
+	flag.StringVar(&opts.FatSuffix, "fatseries", "_F", "(Optional) Suffix of the series name that identifies the fat images.")
+	flag.StringVar(&opts.WaterSuffix, "waterseries", "_W", "(Optional) Suffix of the series name that identifies the water images.")
+	flag.Float64Var(&opts.MinSignal, "minsignal", 0, "(Optional) Voxels whose fat plus water signal does not exceed this value count toward a label's volume but not toward its fat fraction.")
+	flag.IntVar(&concurrency, "concurrency", runtime.NumCPU(), "(Optional) Number of samples to process at once.")
+	flag.StringVar(&DicomColumnName, "dicom_column_name", "dicom_file", "Name of the column in the manifest with the dicoms.")
+	flag.StringVar(&ZipColumnName, "zip_column_name", "zip_file", "Name of the column in the manifest with the zip file.")
+	flag.StringVar(&PixelWidthNativeXColumn, "pixel_width_x", "px_width_mm", "Name of the column that indicates the width of the pixels in the original images.")
+	flag.StringVar(&PixelWidthNativeYColumn, "pixel_width_y", "px_height_mm", "Name of the column that indicates the height of the pixels in the original images.")
+	flag.StringVar(&PixelWidthNativeZColumn, "pixel_width_z", "slice_thickness_mm", "Name of the column that indicates the depth/thickness of the pixels in the original images.")
+	flag.StringVar(&ImagePositionPatientXColumn, "image_x", "image_x", "Name of the column in the manifest with the X position of the top left pixel of the images.")
+	flag.StringVar(&ImagePositionPatientYColumn, "image_y", "image_y", "Name of the column in the manifest with the Y position of the top left pixel of the images.")
+	flag.StringVar(&ImagePositionPatientZColumn, "image_z", "image_z", "Name of the column in the manifest with the Z position of the top left pixel of the images.")
+	flag.StringVar(&SeriesColumnName, "series_column_name", "series", "Name of the column that indicates the series of the images. Must end in the -fatseries and -waterseries suffixes.")
+	flag.StringVar(&SeriesNumberColumName, "series_number_column_name", "series_number", "(Optional) Name of the column that indicates the series number of the images, which distinguishes stations that share a series name.")
+	flag.Parse()
+
+	if manifest == "" || opts.Folder == "" || opts.MaskFolder == "" || jsonConfig == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	switch maskOn {
+	case "water":
+	case "fat":
+		opts.MaskOnFat = true
+	default:
+		log.Fatalf("Unrecognized -maskon %q\n", maskOn)
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var err error
+	opts.Config, err = overlay.ParseJSONConfigFromPath(jsonConfig)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	opts.Folder = strings.TrimSuffix(opts.Folder, "/")
+	opts.MaskFolder = strings.TrimSuffix(opts.MaskFolder, "/")
+
+	// Initialize the Google Storage client, but only if our folders indicate
+	// that we are pointing to a Google Storage path.
+	if strings.HasPrefix(opts.Folder, "gs://") || strings.HasPrefix(opts.MaskFolder, "gs://") {
+		client, err = storage.NewClient(context.Background())
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if err := run(manifest, opts, concurrency); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func run(manifest string, opts options, concurrency int) error {
+	man, err := parseManifest(manifest)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]manifestKey, 0, len(man))
+	for key := range man {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].SampleID != keys[j].SampleID {
+			return keys[i].SampleID < keys[j].SampleID
+		}
+		return keys[i].Instance < keys[j].Instance
+	})
+
+	log.Println("Processing", len(keys), "samples from the manifest")
+
+	fmt.Fprintln(STDOUT, strings.Join([]string{"sample_id", "instance", "label_id", "label", "slices", "voxels", "volume_ml", "fat_volume_ml", "water_volume_ml", "mean_pdff", "median_pdff"}, "\t"))
+
+	var mu sync.Mutex
+	sem := make(chan bool, concurrency)
+
+	for i, key := range keys {
+		sem <- true
+		go func(key manifestKey) {
+			defer func() { <-sem }()
+
+			out, err := processSample(key, man[key], opts)
+			if err != nil {
+				log.Printf("%s_%s: %v\n", key.SampleID, key.Instance, err)
+				return
+			}
+
+			// Rows for a sample are printed together, once it is complete.
+			mu.Lock()
+			fmt.Fprint(STDOUT, out)
+			mu.Unlock()
+		}(key)
+
+		if (i+1)%100 == 0 {
+			log.Printf("Processed %d samples\n", i+1)
+		}
+	}
+
+	for i := 0; i < cap(sem); i++ {
+		sem <- true
+	}
+
+	return nil
+}
+
+func processSample(key manifestKey, entries []manifestEntry, opts options) (string, error) {
+	stations, err := pairDixonStations(entries, opts.FatSuffix, opts.WaterSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	slices := trimStationOverlap(stations)
+	if len(slices) == 0 {
+		return "", fmt.Errorf("No slices had both fat (%s) and water (%s) images", opts.FatSuffix, opts.WaterSuffix)
+	}
+
+	// Only extract the images that are needed, once per zip.
+	zipDicoms := make(map[string]map[string]struct{})
+	for _, v := range slices {
+		if _, exists := zipDicoms[v.Water.zip]; !exists {
+			zipDicoms[v.Water.zip] = make(map[string]struct{})
+		}
+		zipDicoms[v.Water.zip][v.Water.dicom] = struct{}{}
+		zipDicoms[v.Fat.zip][v.Fat.dicom] = struct{}{}
+	}
+
+	stats := make(map[uint]*labelStats)
+	for zip, dicoms := range zipDicoms {
+		imgMap, err := fetchRawImagesFromZIP(opts.Folder+"/"+zip, dicoms)
+		if err != nil {
+			return "", err
+		}
+
+		for _, v := range slices {
+			if v.Water.zip != zip {
+				continue
+			}
+
+			fat, water := imgMap[v.Fat.dicom], imgMap[v.Water.dicom]
+			if fat == nil || water == nil {
+				return "", fmt.Errorf("%s: did not find %s and %s", zip, v.Fat.dicom, v.Water.dicom)
+			}
+
+			maskDicom := v.Water.dicom
+			if opts.MaskOnFat {
+				maskDicom = v.Fat.dicom
+			}
+			mask, err := overlay.OpenImageFromLocalFileOrGoogleStorage(opts.MaskFolder+"/"+maskDicom+opts.MaskSuffix, client)
+			if err != nil {
+				return "", err
+			}
+
+			if err := accumulateSlice(stats, fat, water, mask, v.VoxelML(), opts.MinSignal); err != nil {
+				return "", fmt.Errorf("%s:%s: %v", zip, maskDicom, err)
+			}
+		}
+	}
+
+	var out strings.Builder
+	for _, label := range opts.Config.Labels.Sorted() {
+		l, exists := stats[label.ID]
+		if !exists {
+			l = &labelStats{}
+		}
+
+		out.WriteString(strings.Join([]string{
+			key.SampleID,
+			key.Instance,
+			strconv.FormatUint(uint64(label.ID), 10),
+			label.Label,
+			strconv.Itoa(l.Slices),
+			strconv.Itoa(l.Voxels),
+			formatNA(l.VolumeML),
+			formatNA(l.FatVolumeML),
+			formatNA(l.WaterVolumeML),
+			formatNA(l.MeanPDFF()),
+			formatNA(l.MedianPDFF()),
+		}, "\t"))
+		out.WriteString("\n")
+	}
+
+	return out.String(), nil
+}
+
+func formatNA(v float64) string {
+	if v != v {
+		return "NA"
+	}
+
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
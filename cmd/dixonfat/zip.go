@@ -0,0 +1,55 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"io/ioutil"
+
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+)
+
+// fetchRawImagesFromZIP is like bulkprocess.FetchNamedImagesFromZIP, but keeps
+// the stored pixel values rather than applying the DICOM window. Windowing
+// clips and rescales each series independently, which would distort the ratio
+// of the fat and water signals.
+func fetchRawImagesFromZIP(zipPath string, acceptedFiles map[string]struct{}) (map[string]image.Image, error) {
+	readerAt, zipNBytes, err := bulkprocess.MaybeOpenFromGoogleStorage(zipPath, client)
+	if err != nil {
+		return nil, err
+	}
+	defer readerAt.Close()
+
+	allBytes, err := ioutil.ReadAll(readerAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := zip.NewReader(bytes.NewReader(allBytes), zipNBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]image.Image)
+	for _, v := range rc.File {
+		if _, allowed := acceptedFiles[v.Name]; !allowed {
+			continue
+		}
+
+		dicomReader, err := v.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		img, err := bulkprocess.ExtractDicomFromReaderFuncOp(dicomReader, int64(v.UncompressedSize64), bulkprocess.OptWindowScalingRaw())
+		dicomReader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s: %v", zipPath, v.Name, err)
+		}
+
+		out[v.Name] = img
+	}
+
+	return out, nil
+}
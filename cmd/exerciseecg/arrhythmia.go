@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/carbocation/genomisc/ecg"
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+)
+
+const (
+	// A beat is premature if its RR interval is this much shorter than the
+	// median of the prematureWindow intervals on either side.
+	prematureTolerance = 0.2
+	prematureWindow    = 5
+)
+
+// arrhythmiaBurden detects every beat in the full disclosure recording and
+// counts those that came early relative to the local heart rate.
+func arrhythmiaBurden(doc *bulkprocess.EKGExercise, leadName string) (beats, premature int, err error) {
+	rec, err := ecg.FromEKGExercise(doc)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	lead, ok := rec.Lead(leadName)
+	if !ok {
+		return 0, 0, fmt.Errorf("Lead %s was not found in the full disclosure data", leadName)
+	}
+
+	opts := ecg.DefaultOptions()
+	samples, err := ecg.BandPass(lead.Samples, rec.SampleRate, opts.HighPassHz, opts.LowPassHz)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	peaks, err := ecg.DetectRPeaks(samples, rec.SampleRate)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, v := range ecg.PrematureBeats(ecg.RRIntervals(peaks, rec.SampleRate), prematureWindow, prematureTolerance) {
+		if v {
+			premature++
+		}
+	}
+
+	return len(peaks), premature, nil
+}
@@ -0,0 +1,195 @@
+// exerciseecg derives phenotypes from the UK Biobank cycle ergometry ECG
+// (field 6025): heart rate at each protocol phase, heart rate recovery,
+// chronotropic index, ST-segment trends by lead, and the burden of premature
+// beats.
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/carbocation/genomisc"
+	_ "github.com/carbocation/genomisc/compileinfoprint"
+	"github.com/carbocation/genomisc/ecg"
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+	"golang.org/x/net/html/charset"
+)
+
+const ExerciseEKGFieldIDChunk = "_6025_"
+
+var (
+	BufferSize = 4096
+	STDOUT     = bufio.NewWriterSize(os.Stdout, BufferSize)
+)
+
+type config struct {
+	RestPhase      string
+	ExercisePhase  string
+	RecoveryPhase  string
+	FullDisclosure bool
+	Lead           string
+	Export         []string
+
+	Phases *bufio.Writer
+	ST     *bufio.Writer
+}
+
+func main() {
+	defer STDOUT.Flush()
+
+	var file, path, phaseOut, stOut, export string
+	cfg := config{}
+
+	flag.StringVar(&file, "file", "", "Exercise ECG XML file. Either this or -path is required.")
+	flag.StringVar(&path, "path", "", "Folder containing exercise ECG XML files, of which those for field 6025 will be processed.")
+	flag.StringVar(&phaseOut, "phaseout", "", "(Optional) File into which heart rate and workload for each protocol phase will be written.")
+	flag.StringVar(&stOut, "stout", "", "(Optional) File into which the ST-segment trend of each lead will be written.")
+	flag.StringVar(&cfg.RestPhase, "restphase", "Pretest", "Name of the protocol phase at rest.")
+	flag.StringVar(&cfg.ExercisePhase, "exercisephase", "Exercise", "Name of the protocol phase with exercise.")
+	flag.StringVar(&cfg.RecoveryPhase, "recoveryphase", "Recovery", "Name of the protocol phase with recovery.")
+	flag.BoolVar(&cfg.FullDisclosure, "fulldisclosure", false, "Detect every beat in the full disclosure recording to count premature beats? (Slow.)")
+	flag.StringVar(&cfg.Lead, "lead", "II", "With -fulldisclosure, the lead in which to detect beats.")
+	flag.StringVar(&export, "export", "", "(Optional) Comma-separated formats into which the full disclosure recording will be exported, next to each XML file: wfdb (.hea/.dat), aecg (HL7 aECG _aecg.xml), and/or edf (EDF+ .edf).")
+	flag.Parse()
+
+	if export != "" {
+		cfg.Export = strings.Split(export, ",")
+	}
+
+	if (file == "") == (path == "") {
+		flag.PrintDefaults()
+		log.Fatalln("Please provide either -file or -path")
+	}
+
+	if phaseOut != "" {
+		f, err := os.Create(genomisc.ExpandHome(phaseOut))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		cfg.Phases = bufio.NewWriterSize(f, BufferSize)
+		defer cfg.Phases.Flush()
+		fmt.Fprintln(cfg.Phases, strings.Join([]string{"sample_id", "FieldID", "instance", "phase", "start_s", "duration_s", "entries", "hr_start", "hr_end", "hr_mean", "hr_max", "max_load_w", "machine_ve_count"}, "\t"))
+	}
+
+	if stOut != "" {
+		f, err := os.Create(genomisc.ExpandHome(stOut))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		cfg.ST = bufio.NewWriterSize(f, BufferSize)
+		defer cfg.ST.Flush()
+		fmt.Fprintln(cfg.ST, strings.Join([]string{"sample_id", "FieldID", "instance", "lead", "entries", "st_rest_uv", "st_peak_uv", "st_min_uv", "st_min_time_s", "st_max_uv", "st_recovery_1min_uv", "st_load_slope_uv_per_w", "st_hr_slope_uv_per_bpm"}, "\t"))
+	}
+
+	var files []string
+	if file != "" {
+		files = append(files, genomisc.ExpandHome(file))
+	} else {
+		entries, err := ioutil.ReadDir(genomisc.ExpandHome(path))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		for _, v := range entries {
+			if strings.HasSuffix(v.Name(), ".xml") && strings.Contains(v.Name(), ExerciseEKGFieldIDChunk) {
+				files = append(files, filepath.Join(genomisc.ExpandHome(path), v.Name()))
+			}
+		}
+		sort.Strings(files)
+	}
+
+	header := []string{"sample_id", "FieldID", "instance", "xml_file", "rest_hr", "peak_hr", "max_predicted_hr", "hr_recovery_1min", "chronotropic_index", "max_load_w", "max_mets", "exercise_duration_s", "machine_ve_count"}
+	if cfg.FullDisclosure {
+		header = append(header, "beats", "premature_beats", "premature_burden")
+	}
+	fmt.Fprintln(STDOUT, strings.Join(header, "\t"))
+
+	for _, v := range files {
+		if err := processFile(v, cfg); err != nil {
+			log.Println(v, err)
+		}
+	}
+}
+
+func processFile(path string, cfg config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	doc := bulkprocess.EKGExercise{}
+	decoder := xml.NewDecoder(f)
+	decoder.CharsetReader = charset.NewReaderLabel
+	if err := decoder.Decode(&doc); err != nil {
+		return err
+	}
+
+	// Files are named sample_field_instance_arrayidx.xml
+	name := filepath.Base(path)
+	parts := strings.Split(name, "_")
+	if len(parts) < 3 {
+		return fmt.Errorf("Expected at least two '_' characters in the filename, but found %d", len(parts)-1)
+	}
+	ids := parts[:3]
+
+	points := trendPoints(&doc)
+	phases := summarizePhases(points)
+	summary := summarizeExercise(&doc, points, phases, cfg.RestPhase, cfg.ExercisePhase, cfg.RecoveryPhase)
+
+	row := append(append([]string{}, ids...), name)
+	row = append(row, formatNA(summary.RestHR), formatNA(summary.PeakHR), formatNA(summary.PredictedMaxHR), formatNA(summary.HRR1), formatNA(summary.ChronotropicIndex), formatNA(summary.MaxLoad), formatNA(summary.MaxMets), formatNA(summary.ExerciseDuration), formatNA(summary.VECount))
+
+	if cfg.FullDisclosure {
+		beats, premature, err := arrhythmiaBurden(&doc, cfg.Lead)
+		if err != nil {
+			log.Println(name, err)
+			row = append(row, "NA", "NA", "NA")
+		} else {
+			row = append(row, strconv.Itoa(beats), strconv.Itoa(premature), formatNA(float64(premature)/float64(beats)))
+		}
+	}
+	fmt.Fprintln(STDOUT, strings.Join(row, "\t"))
+
+	if len(cfg.Export) > 0 {
+		rec, err := ecg.FromEKGExercise(&doc)
+		if err != nil {
+			log.Println(name, err)
+		} else if err := ecg.WriteFiles(strings.TrimSuffix(path, ".xml")+"_full", cfg.Export, ids[0], rec); err != nil {
+			log.Println(name, err)
+		}
+	}
+
+	if cfg.Phases != nil {
+		for _, p := range phases {
+			fmt.Fprintln(cfg.Phases, strings.Join(append(append([]string{}, ids...), p.Name, formatNA(p.Start), formatNA(p.Duration), strconv.Itoa(p.Entries), formatNA(p.HRStart), formatNA(p.HREnd), formatNA(p.HRMean), formatNA(p.HRMax), formatNA(p.MaxLoad), formatNA(p.VECount)), "\t"))
+		}
+	}
+
+	if cfg.ST != nil {
+		for _, t := range stTrends(points, cfg.RestPhase, cfg.ExercisePhase, cfg.RecoveryPhase) {
+			fmt.Fprintln(cfg.ST, strings.Join(append(append([]string{}, ids...), t.Lead, strconv.Itoa(t.Entries), formatNA(t.Rest), formatNA(t.Peak), formatNA(t.Min), formatNA(t.MinTime), formatNA(t.Max), formatNA(t.Recovery1), formatNA(t.LoadSlope), formatNA(t.HRSlope)), "\t"))
+		}
+	}
+
+	return nil
+}
+
+func formatNA(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "NA"
+	}
+
+	return strconv.FormatFloat(math.Round(v*1e4)/1e4, 'f', -1, 64)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// stTrend summarizes how the ST amplitude of one lead changes with exercise.
+// Amplitudes are in uV; negative values are ST depression.
+type stTrend struct {
+	Lead    string
+	Entries int
+
+	Rest      float64
+	Peak      float64
+	Min       float64
+	MinTime   float64
+	Max       float64
+	Recovery1 float64
+
+	// LoadSlope (uV/W) and HRSlope (uV/bpm) are least-squares slopes of the ST
+	// amplitude against workload and heart rate during exercise.
+	LoadSlope float64
+	HRSlope   float64
+}
+
+// stTrends summarizes the ST amplitude of each lead: the mean at rest, the
+// value at the last exercise entry, the most depressed and most elevated
+// values during exercise and recovery, the value 1 minute into recovery, and
+// its slope against workload and heart rate during exercise.
+func stTrends(points []trendPoint, restPhase, exercisePhase, recoveryPhase string) []stTrend {
+	leadSet := make(map[string]struct{})
+	for _, p := range points {
+		for lead := range p.ST {
+			leadSet[lead] = struct{}{}
+		}
+	}
+	leads := make([]string, 0, len(leadSet))
+	for lead := range leadSet {
+		leads = append(leads, lead)
+	}
+	sort.Strings(leads)
+
+	out := make([]stTrend, 0, len(leads))
+	for _, lead := range leads {
+		st := func(p trendPoint) float64 {
+			v, exists := p.ST[lead]
+			if !exists {
+				return math.NaN()
+			}
+			return v
+		}
+
+		t := stTrend{
+			Lead:    lead,
+			Rest:    math.NaN(),
+			Peak:    math.NaN(),
+			Min:     math.NaN(),
+			MinTime: math.NaN(),
+			Max:     math.NaN(),
+		}
+
+		restSum, restN := 0.0, 0
+		var load, hr, loadST, hrST []float64
+		for _, p := range points {
+			v := st(p)
+			if math.IsNaN(v) {
+				continue
+			}
+			t.Entries++
+
+			switch {
+			case strings.EqualFold(p.Phase, restPhase):
+				restSum += v
+				restN++
+				continue
+			case strings.EqualFold(p.Phase, exercisePhase):
+				t.Peak = v
+				if !math.IsNaN(p.Load) {
+					load = append(load, p.Load)
+					loadST = append(loadST, v)
+				}
+				if !math.IsNaN(p.HR) {
+					hr = append(hr, p.HR)
+					hrST = append(hrST, v)
+				}
+			case !strings.EqualFold(p.Phase, recoveryPhase):
+				continue
+			}
+
+			if math.IsNaN(t.Min) || v < t.Min {
+				t.Min = v
+				t.MinTime = p.Time
+			}
+			t.Max = nanMax(t.Max, v)
+		}
+
+		if restN > 0 {
+			t.Rest = restSum / float64(restN)
+		}
+		t.Recovery1 = valueAt(points, recoveryPhase, 60, st)
+		t.LoadSlope = olsSlope(load, loadST)
+		t.HRSlope = olsSlope(hr, hrST)
+
+		out = append(out, t)
+	}
+
+	return out
+}
+
+// olsSlope is the least-squares slope of y on x, or NaN if x does not vary.
+func olsSlope(x, y []float64) float64 {
+	if len(x) < 2 {
+		return math.NaN()
+	}
+
+	var mx, my float64
+	for i := range x {
+		mx += x[i]
+		my += y[i]
+	}
+	mx /= float64(len(x))
+	my /= float64(len(x))
+
+	var sxy, sxx float64
+	for i := range x {
+		sxy += (x[i] - mx) * (y[i] - my)
+		sxx += (x[i] - mx) * (x[i] - mx)
+	}
+	if sxx == 0 {
+		return math.NaN()
+	}
+
+	return sxy / sxx
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+)
+
+// trendPoint is one entry of the machine's trend data, which is emitted every
+// few seconds over the course of the test.
+type trendPoint struct {
+	// Time is seconds since the start of the test, and PhaseTime is seconds
+	// since the start of the current phase.
+	Time      float64
+	Phase     string
+	PhaseTime float64
+	Stage     string
+
+	HR      float64
+	Mets    float64
+	Load    float64
+	VECount float64
+
+	// ST is the ST amplitude by lead, in uV.
+	ST map[string]float64
+}
+
+func parseNA(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return math.NaN()
+	}
+
+	return v
+}
+
+func minuteSecond(minute, second string) float64 {
+	return 60*parseNA(minute) + parseNA(second)
+}
+
+// toMicrovolts scales an amplitude to uV based on its units attribute.
+// Amplitudes without units are assumed to be in uV already.
+func toMicrovolts(value, units string) float64 {
+	v := parseNA(value)
+
+	switch strings.ToLower(strings.TrimSpace(units)) {
+	case "mv":
+		return 1000 * v
+	case "v":
+		return 1e6 * v
+	}
+
+	return v
+}
+
+func trendPoints(doc *bulkprocess.EKGExercise) []trendPoint {
+	out := make([]trendPoint, 0, len(doc.TrendData.TrendEntry))
+	for _, v := range doc.TrendData.TrendEntry {
+		p := trendPoint{
+			Time:      minuteSecond(v.EntryTime.Minute, v.EntryTime.Second),
+			Phase:     strings.TrimSpace(v.PhaseName),
+			PhaseTime: minuteSecond(v.PhaseTime.Minute, v.PhaseTime.Second),
+			Stage:     strings.TrimSpace(v.StageNumber),
+			HR:        parseNA(v.HeartRate),
+			Mets:      parseNA(v.Mets),
+			Load:      parseNA(v.Load.Text),
+			VECount:   parseNA(v.VECount),
+			ST:        make(map[string]float64),
+		}
+
+		// A heart rate of 0 is emitted when the machine could not measure one.
+		if p.HR <= 0 {
+			p.HR = math.NaN()
+		}
+
+		for _, lead := range v.LeadMeasurements {
+			p.ST[strings.TrimSpace(lead.Lead)] = toMicrovolts(lead.STAmplitude.Text, lead.STAmplitude.Units)
+		}
+
+		out = append(out, p)
+	}
+
+	return out
+}
+
+// phaseSummary describes the heart rate and workload over one protocol phase.
+type phaseSummary struct {
+	Name     string
+	Start    float64
+	Duration float64
+	Entries  int
+
+	HRStart float64
+	HREnd   float64
+	HRMean  float64
+	HRMax   float64
+	MaxLoad float64
+	VECount float64
+}
+
+// summarizePhases groups the trend entries by phase, in the order in which the
+// phases occurred.
+func summarizePhases(points []trendPoint) []phaseSummary {
+	var out []phaseSummary
+	var hrSum []float64
+	var hrN []int
+	index := make(map[string]int)
+
+	for _, p := range points {
+		i, exists := index[p.Phase]
+		if !exists {
+			i = len(out)
+			index[p.Phase] = i
+			out = append(out, phaseSummary{
+				Name:    p.Phase,
+				Start:   p.Time - p.PhaseTime,
+				HRStart: math.NaN(),
+				HREnd:   math.NaN(),
+				HRMean:  math.NaN(),
+				HRMax:   math.NaN(),
+				MaxLoad: math.NaN(),
+			})
+			hrSum = append(hrSum, 0)
+			hrN = append(hrN, 0)
+		}
+
+		s := &out[i]
+		s.Entries++
+		s.Duration = math.Max(s.Duration, p.PhaseTime)
+		s.MaxLoad = nanMax(s.MaxLoad, p.Load)
+		if !math.IsNaN(p.VECount) {
+			s.VECount += p.VECount
+		}
+
+		if math.IsNaN(p.HR) {
+			continue
+		}
+		if math.IsNaN(s.HRStart) {
+			s.HRStart = p.HR
+		}
+		s.HREnd = p.HR
+		s.HRMax = nanMax(s.HRMax, p.HR)
+		hrSum[i] += p.HR
+		hrN[i]++
+	}
+
+	for i := range out {
+		if hrN[i] > 0 {
+			out[i].HRMean = hrSum[i] / float64(hrN[i])
+		}
+	}
+
+	return out
+}
+
+// nanMax is the larger of a and b, ignoring either if it is NaN.
+func nanMax(a, b float64) float64 {
+	if math.IsNaN(a) || b > a {
+		return b
+	}
+
+	return a
+}
+
+func findPhase(phases []phaseSummary, name string) (phaseSummary, bool) {
+	for _, v := range phases {
+		if strings.EqualFold(v.Name, name) {
+			return v, true
+		}
+	}
+
+	return phaseSummary{}, false
+}
+
+// valueAt linearly interpolates value(p) at phaseTime seconds into a phase. It
+// is NaN if phaseTime lies outside of the entries of the phase.
+func valueAt(points []trendPoint, phase string, phaseTime float64, value func(trendPoint) float64) float64 {
+	var before, after *trendPoint
+	for i := range points {
+		p := &points[i]
+		if !strings.EqualFold(p.Phase, phase) || math.IsNaN(value(*p)) {
+			continue
+		}
+		if p.PhaseTime <= phaseTime && (before == nil || p.PhaseTime > before.PhaseTime) {
+			before = p
+		}
+		if p.PhaseTime >= phaseTime && (after == nil || p.PhaseTime < after.PhaseTime) {
+			after = p
+		}
+	}
+
+	if before == nil || after == nil {
+		return math.NaN()
+	}
+	if after.PhaseTime == before.PhaseTime {
+		return value(*before)
+	}
+
+	frac := (phaseTime - before.PhaseTime) / (after.PhaseTime - before.PhaseTime)
+	return value(*before) + frac*(value(*after)-value(*before))
+}
+
+func heartRate(p trendPoint) float64 { return p.HR }
+
+// exerciseSummary holds the per-test heart rate phenotypes.
+type exerciseSummary struct {
+	RestHR            float64
+	PeakHR            float64
+	PredictedMaxHR    float64
+	HRR1              float64
+	ChronotropicIndex float64
+	MaxLoad           float64
+	MaxMets           float64
+	ExerciseDuration  float64
+	VECount           float64
+}
+
+// summarizeExercise derives heart rate phenotypes. Resting heart rate is the
+// machine's, or else the mean over the rest phase. Peak heart rate is the
+// highest during exercise. Heart rate recovery is the drop from peak to 1
+// minute into recovery. The chronotropic index is the fraction of the heart
+// rate reserve that was used: (peak - rest) / (predicted max - rest), where the
+// predicted max is the machine's, or else 220 - age.
+func summarizeExercise(doc *bulkprocess.EKGExercise, points []trendPoint, phases []phaseSummary, restPhase, exercisePhase, recoveryPhase string) exerciseSummary {
+	out := exerciseSummary{
+		RestHR:         parseNA(doc.ExerciseMeasurements.RestingStats.RestHR),
+		PeakHR:         math.NaN(),
+		PredictedMaxHR: parseNA(doc.ExerciseMeasurements.MaxPredictedHR),
+		MaxLoad:        math.NaN(),
+		MaxMets:        math.NaN(),
+	}
+
+	if !(out.RestHR > 0) {
+		out.RestHR = math.NaN()
+		if rest, ok := findPhase(phases, restPhase); ok {
+			out.RestHR = rest.HRMean
+		}
+	}
+
+	if !(out.PredictedMaxHR > 0) {
+		out.PredictedMaxHR = math.NaN()
+		if age := parseNA(doc.PatientInfo.Age.Text); age > 0 {
+			out.PredictedMaxHR = 220 - age
+		}
+	}
+
+	if exercise, ok := findPhase(phases, exercisePhase); ok {
+		out.PeakHR = exercise.HRMax
+		out.MaxLoad = exercise.MaxLoad
+		out.ExerciseDuration = exercise.Duration
+	} else {
+		out.ExerciseDuration = math.NaN()
+	}
+
+	for _, p := range points {
+		if !math.IsNaN(p.VECount) {
+			out.VECount += p.VECount
+		}
+		if strings.EqualFold(p.Phase, exercisePhase) {
+			out.MaxMets = nanMax(out.MaxMets, p.Mets)
+		}
+	}
+
+	out.HRR1 = out.PeakHR - valueAt(points, recoveryPhase, 60, heartRate)
+	out.ChronotropicIndex = (out.PeakHR - out.RestHR) / (out.PredictedMaxHR - out.RestHR)
+
+	return out
+}
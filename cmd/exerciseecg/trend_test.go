@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+)
+
+func TestSummarizeExercise(t *testing.T) {
+	// 60 s of rest at 70 bpm; 6 min of exercise ramping from 80 to 150 bpm and
+	// 0 to 120 W, with ST in V5 falling by 1 uV per W; 1 min of recovery in
+	// which heart rate falls to 120 bpm.
+	var points []trendPoint
+	for s := 0.0; s <= 60; s += 10 {
+		points = append(points, trendPoint{Time: s, Phase: "Pretest", PhaseTime: s, HR: 70, Load: 0, ST: map[string]float64{"V5": 10}})
+	}
+	for s := 0.0; s <= 360; s += 10 {
+		load := 120 * s / 360
+		points = append(points, trendPoint{Time: 60 + s, Phase: "Exercise", PhaseTime: s, HR: 80 + 70*s/360, Load: load, ST: map[string]float64{"V5": 10 - load}})
+	}
+	for s := 0.0; s <= 60; s += 15 {
+		points = append(points, trendPoint{Time: 420 + s, Phase: "Recovery", PhaseTime: s, HR: 150 - 30*s/60, Load: 0, ST: map[string]float64{"V5": -110 + s}})
+	}
+
+	doc := &bulkprocess.EKGExercise{}
+	doc.PatientInfo.Age.Text = "60"
+
+	phases := summarizePhases(points)
+	if len(phases) != 3 {
+		t.Fatalf("Expected 3 phases, got %d", len(phases))
+	}
+
+	s := summarizeExercise(doc, points, phases, "Pretest", "Exercise", "Recovery")
+	for _, v := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"rest HR", s.RestHR, 70},
+		{"peak HR", s.PeakHR, 150},
+		{"predicted max HR", s.PredictedMaxHR, 160},
+		{"HRR at 1 minute", s.HRR1, 30},
+		{"chronotropic index", s.ChronotropicIndex, 80.0 / 90.0},
+		{"max load", s.MaxLoad, 120},
+		{"exercise duration", s.ExerciseDuration, 360},
+	} {
+		if math.Abs(v.got-v.want) > 1e-9 {
+			t.Errorf("%s: expected %f, got %f", v.name, v.want, v.got)
+		}
+	}
+
+	trends := stTrends(points, "Pretest", "Exercise", "Recovery")
+	if len(trends) != 1 {
+		t.Fatalf("Expected 1 lead, got %d", len(trends))
+	}
+	st := trends[0]
+	for _, v := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"ST at rest", st.Rest, 10},
+		{"ST at peak", st.Peak, -110},
+		{"ST nadir", st.Min, -110},
+		{"ST at 1 minute of recovery", st.Recovery1, -50},
+		{"ST/load slope", st.LoadSlope, -1},
+	} {
+		if math.Abs(v.got-v.want) > 1e-9 {
+			t.Errorf("%s: expected %f, got %f", v.name, v.want, v.got)
+		}
+	}
+}
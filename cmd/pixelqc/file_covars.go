@@ -10,7 +10,7 @@ import (
 	"strings"
 )
 
-func parseCovarFile(out map[string]File, covarFile, sampleID, imageID, timeID, pxHeight, pxWidth string) error {
+func parseCovarFile(out map[string]File, covarFile, sampleID, imageID, timeID, pxHeight, pxWidth string, strata []string) error {
 
 	f, err := os.Open(covarFile)
 	if err != nil {
@@ -31,6 +31,7 @@ func parseCovarFile(out map[string]File, covarFile, sampleID, imageID, timeID, p
 	r.Comma = ','
 
 	var colsSampleID, colImageID, colTimeID, colPxHeight, colPxWidth int
+	var colStrata []int
 	for i := 0; ; i++ {
 		cols, err := r.Read()
 		if err == io.EOF {
@@ -44,6 +45,10 @@ func parseCovarFile(out map[string]File, covarFile, sampleID, imageID, timeID, p
 			if err != nil {
 				return err
 			}
+			colStrata, err = findColumns(cols, strata)
+			if err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -87,6 +92,12 @@ func parseCovarFile(out map[string]File, covarFile, sampleID, imageID, timeID, p
 		entry.PxHeight = pxHeightF
 		entry.PxWidth = pxWidthF
 
+		stratum := make([]string, 0, len(colStrata))
+		for _, col := range colStrata {
+			stratum = append(stratum, cols[col])
+		}
+		entry.Stratum = strings.Join(stratum, "|")
+
 		out[cols[colImageID]] = entry
 
 	}
@@ -5,12 +5,13 @@ import (
 	"encoding/csv"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"strconv"
 	"strings"
 )
 
-func parsePixelcountFile(pixelcountFile, imageID, pixels, connectedComponents string) (map[string]File, error) {
+func parsePixelcountFile(pixelcountFile, imageID, pixels, connectedComponents string, moments []string) (map[string]File, error) {
 
 	out := make(map[string]File)
 
@@ -33,6 +34,7 @@ func parsePixelcountFile(pixelcountFile, imageID, pixels, connectedComponents st
 	r.Comma = '\t'
 
 	var colImageID, colPixels, colConnectedComponents int
+	var colMoments []int
 	for i := 0; ; i++ {
 		cols, err := r.Read()
 		if err == io.EOF {
@@ -46,6 +48,10 @@ func parsePixelcountFile(pixelcountFile, imageID, pixels, connectedComponents st
 			if err != nil {
 				return nil, err
 			}
+			colMoments, err = findColumns(cols, moments)
+			if err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -59,10 +65,20 @@ func parsePixelcountFile(pixelcountFile, imageID, pixels, connectedComponents st
 			return nil, err
 		}
 
+		// Moments are absent (e.g., NA) when the label is not in the image
+		momentValues := make([]float64, len(colMoments))
+		for k, col := range colMoments {
+			momentValues[k], err = strconv.ParseFloat(cols[col], 64)
+			if err != nil {
+				momentValues[k] = math.NaN()
+			}
+		}
+
 		out[cols[colImageID]] = File{
 			BadWhy:              []string{"No_covariates"},
 			Pixels:              pixelCount,
 			ConnectedComponents: connectedComponentCount,
+			Moments:             momentValues,
 		}
 	}
 
@@ -92,3 +108,21 @@ func readPixelHeader(cols []string, imageID, pixels, connectedComponents string)
 
 	return colImageID, colPixels, colConnectedComponents, nil
 }
+
+func findColumns(cols []string, names []string) ([]int, error) {
+	out := make([]int, 0, len(names))
+
+NameLoop:
+	for _, name := range names {
+		for col, v := range cols {
+			if v == name {
+				out = append(out, col)
+				continue NameLoop
+			}
+		}
+
+		return nil, fmt.Errorf("Did not find column %s in the header", name)
+	}
+
+	return out, nil
+}
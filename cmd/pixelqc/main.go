@@ -21,6 +21,8 @@ type File struct {
 	TimeID              float64
 	PxHeight            float64
 	PxWidth             float64
+	Moments             []float64
+	Stratum             string
 }
 
 func (f File) CM2() float64 {
@@ -40,6 +42,9 @@ func main() {
 	var timeID string
 	var pxHeight, pxWidth string
 	var nStandardDeviations float64
+	var robust bool
+	var moments, strata string
+	var minStratum int
 
 	flag.StringVar(&pixelcountFile, "pixelcountfile", "", "Path to file with pixelcount output (tab-delimited; containing imageid, value, connectedComponents)")
 	flag.StringVar(&covarFile, "covarfile", "", "Path to file with covariates output (comma delimited; containing sampleid, imageid, timeid, pxheight, pxwidth)")
@@ -51,6 +56,10 @@ func main() {
 	flag.StringVar(&pxHeight, "pxheight", "", "Column name that identifies the column with data converting pixel height to mm. (Optional.)")
 	flag.StringVar(&pxWidth, "pxwidth", "", "Column name that identifies the column with data converting pixel width to mm. (Optional.)")
 	flag.Float64Var(&nStandardDeviations, "sd", 5.0, "Number of standard deviations beyond which to consider our metrics to have failed QC.")
+	flag.BoolVar(&robust, "robust", false, "Use robust multivariate QC: flag samples by median/MAD robust z-scores and by robust Mahalanobis distance across all metrics jointly, and print a per-sample flag table instead of the default output.")
+	flag.StringVar(&moments, "moments", "", "(Optional, with -robust) Comma-delimited pixelcount column names of image moments (e.g., from pixelcounter -moment-labels) to include in the robust QC.")
+	flag.StringVar(&strata, "strata", "", "(Optional, with -robust) Comma-delimited covariate column names (e.g., station_name,software_versions,series_description) whose combined values define strata that are assessed separately.")
+	flag.IntVar(&minStratum, "minstratum", 100, "(With -robust) Samples in strata with fewer than this many samples are compared against all samples.")
 	flag.Float64Var(&divisor, "divisor", 100.0, "Divide output by this value (e.g., divide by 100.0 if input was mm^2 and goal output is cm^2, or 1.0 if input was cm and no adjustment is desired).")
 
 	flag.Parse()
@@ -93,12 +102,24 @@ func main() {
 
 	log.Println("Launched pixelqc for", pixels)
 
-	if err := runAll(pixelcountFile, covarFile, pixels, connectedComponents, sampleID, imageID, timeID, pxHeight, pxWidth, nStandardDeviations); err != nil {
+	if !robust && (moments != "" || strata != "") {
+		log.Fatalln("-moments and -strata require -robust")
+	}
+
+	if err := runAll(pixelcountFile, covarFile, pixels, connectedComponents, sampleID, imageID, timeID, pxHeight, pxWidth, nStandardDeviations, robust, splitList(moments), splitList(strata), minStratum); err != nil {
 		log.Fatalln(err)
 	}
 }
 
-func runAll(pixelcountFile, covarFile, pixels, connectedComponents, sampleID, imageID, timeID, pxHeight, pxWidth string, nStandardDeviations float64) error {
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+
+	return strings.Split(list, ",")
+}
+
+func runAll(pixelcountFile, covarFile, pixels, connectedComponents, sampleID, imageID, timeID, pxHeight, pxWidth string, nStandardDeviations float64, robust bool, moments, strata []string, minStratum int) error {
 
 	// The primary output is one row per sample, with the greatest and smallest
 	// pixel value encountered for that sample during the series, with
@@ -107,7 +128,7 @@ func runAll(pixelcountFile, covarFile, pixels, connectedComponents, sampleID, im
 
 	// Start by populating the entries from the pixelcount file; i.e., the file
 	// that actually has computed pixel values
-	entries, err := parsePixelcountFile(pixelcountFile, imageID, pixels, connectedComponents)
+	entries, err := parsePixelcountFile(pixelcountFile, imageID, pixels, connectedComponents, moments)
 	if err != nil {
 		return err
 	}
@@ -115,7 +136,7 @@ func runAll(pixelcountFile, covarFile, pixels, connectedComponents, sampleID, im
 
 	// Next, add in covariate metadata.
 	if covarFile != "" {
-		err = parseCovarFile(entries, covarFile, sampleID, imageID, timeID, pxHeight, pxWidth)
+		err = parseCovarFile(entries, covarFile, sampleID, imageID, timeID, pxHeight, pxWidth, strata)
 		if err != nil {
 			return err
 		}
@@ -130,6 +151,10 @@ func runAll(pixelcountFile, covarFile, pixels, connectedComponents, sampleID, im
 	}
 	log.Println("Processed cyclic data across", len(cycle), "samples")
 
+	if robust {
+		return runRobust(samplesWithFlags, entries, cycle, moments, nStandardDeviations, minStratum)
+	}
+
 	// Run all QC
 
 	log.Println("Will run QC iteratively until no additional samples are flagged in an iteration.")
@@ -198,3 +223,34 @@ func cardiacCycle(entries map[string]File, adjacentN, discardN int) ([]cardiaccy
 
 	return cardiaccycle.RunFromSlices(sampleIDs, instances, metrics, adjacentN, discardN)
 }
+
+// runRobust applies the rule-based flags (zero pixels, abnormal image counts,
+// missing covariates), which do not depend on the distribution of samples, and
+// then the robust QC. Since robust estimates are not swayed by outliers, there
+// is no need to iterate.
+func runRobust(samplesWithFlags SampleFlags, entries map[string]File, cycle []cardiaccycle.Result, moments []string, nStandardDeviations float64, minStratum int) error {
+	flagZeroes(entries)
+	flagAbnormalImageCounts(samplesWithFlags, entries)
+	for _, v := range entries {
+		for _, bad := range v.BadWhy {
+			samplesWithFlags.AddFlag(v.SampleID, bad)
+		}
+	}
+
+	names := featureNames(moments)
+	samples := buildSampleFeatures(entries, cycle, len(moments))
+	robustQC(samples, names, nStandardDeviations, minStratum)
+
+	flagCounts := make(map[string]int)
+	for _, s := range samples {
+		for _, v := range s.RobustFlags {
+			flagCounts[v]++
+		}
+		for v := range samplesWithFlags[s.SampleID] {
+			flagCounts[v]++
+		}
+	}
+	log.Printf("Number of samples with each flag: %+v\n", flagCounts)
+
+	return writeFlagTable(os.Stdout, samples, names, samplesWithFlags)
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/carbocation/genomisc/cardiaccycle"
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// madScale makes the median absolute deviation a consistent estimator of the
+// standard deviation for normally distributed data.
+const madScale = 1.4826
+
+// meanADScale does the same for the mean absolute deviation, which is used
+// when more than half of the values are identical (e.g., connected component
+// counts, which are usually exactly 1) and the MAD is therefore 0.
+const meanADScale = 1.2533
+
+// sampleFeatures are the per-sample summaries that are jointly assessed by the
+// robust QC.
+type sampleFeatures struct {
+	SampleID string
+	Stratum  string
+	NImages  int
+	Values   []float64
+
+	// Filled in by robustQC
+	Reference   string
+	NReference  int
+	Z           []float64
+	D2          float64
+	DF          int
+	PValue      float64
+	RobustFlags []string
+}
+
+func featureNames(moments []string) []string {
+	out := []string{"area_min", "area_max", "onestep_shift", "components_median", "components_max"}
+	for _, moment := range moments {
+		out = append(out, moment+"_median")
+	}
+
+	return out
+}
+
+// buildSampleFeatures summarizes each sample's images. A sample's stratum is
+// taken from its first image.
+func buildSampleFeatures(entries map[string]File, cycle []cardiaccycle.Result, nMoments int) []*sampleFeatures {
+	bySample := make(map[string][]File)
+	for _, v := range entries {
+		bySample[v.SampleID] = append(bySample[v.SampleID], v)
+	}
+
+	cycleBySample := make(map[string]cardiaccycle.Result)
+	for _, v := range cycle {
+		cycleBySample[v.Identifier] = v
+	}
+
+	out := make([]*sampleFeatures, 0, len(bySample))
+	for sampleID, files := range bySample {
+		sort.Slice(files, func(i, j int) bool { return files[i].TimeID < files[j].TimeID })
+
+		sf := &sampleFeatures{
+			SampleID: sampleID,
+			Stratum:  files[0].Stratum,
+			NImages:  len(files),
+		}
+
+		nan := math.NaN()
+		c, exists := cycleBySample[sampleID]
+		if exists {
+			sf.Values = append(sf.Values, c.Min, c.Max, c.MaxOneStepShift)
+		} else {
+			sf.Values = append(sf.Values, nan, nan, nan)
+		}
+
+		components := make([]float64, 0, len(files))
+		for _, f := range files {
+			components = append(components, f.ConnectedComponents)
+		}
+		sort.Float64s(components)
+		sf.Values = append(sf.Values, medianSorted(components), components[len(components)-1])
+
+		for m := 0; m < nMoments; m++ {
+			values := make([]float64, 0, len(files))
+			for _, f := range files {
+				if !math.IsNaN(f.Moments[m]) {
+					values = append(values, f.Moments[m])
+				}
+			}
+			sort.Float64s(values)
+			sf.Values = append(sf.Values, medianSorted(values))
+		}
+
+		out = append(out, sf)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].SampleID < out[j].SampleID })
+
+	return out
+}
+
+// robustQC flags samples whose features are outliers relative to the other
+// samples in their stratum (or to all samples, if the stratum has fewer than
+// minStratum samples). Each feature is scored with a robust z-score, (x -
+// median) / (1.4826 * MAD), and flagged beyond nSD. The features are also
+// assessed jointly by their robust Mahalanobis distance, with a location and
+// covariance estimated from the most central 75% of samples (the minimum
+// covariance determinant, found by concentration steps), and flagged when the
+// chi-square p-value is below the two-sided normal tail probability of nSD.
+// Unlike the mean and SD, none of these estimates can be dragged toward a
+// handful of extreme samples, so one failure cannot mask another.
+func robustQC(samples []*sampleFeatures, names []string, nSD float64, minStratum int) {
+	strata := make(map[string][]*sampleFeatures)
+	for _, s := range samples {
+		strata[s.Stratum] = append(strata[s.Stratum], s)
+	}
+
+	// Samples in small strata are compared with everyone
+	references := make(map[string][]*sampleFeatures)
+	for stratum, members := range strata {
+		reference := stratum
+		if len(members) < minStratum {
+			reference = "ALL"
+		}
+		references[reference] = append(references[reference], members...)
+	}
+	if _, exists := references["ALL"]; exists {
+		references["ALL"] = samples
+	}
+
+	alpha := 2 * distuv.UnitNormal.Survival(nSD)
+
+	referenceNames := make([]string, 0, len(references))
+	for reference := range references {
+		referenceNames = append(referenceNames, reference)
+	}
+	sort.Strings(referenceNames)
+
+	for _, reference := range referenceNames {
+		members := references[reference]
+
+		// The ALL reference also contains samples from large strata, which
+		// are only used to estimate the distribution.
+		assess := members
+		if reference == "ALL" {
+			assess = nil
+			for _, s := range members {
+				if len(strata[s.Stratum]) < minStratum {
+					assess = append(assess, s)
+				}
+			}
+		}
+
+		centers, scales := robustLocationScale(members, len(names))
+		z := make(map[*sampleFeatures][]float64, len(members))
+		for _, s := range members {
+			z[s] = make([]float64, len(names))
+			for k, x := range s.Values {
+				z[s][k] = math.NaN()
+				if scales[k] > 0 {
+					z[s][k] = (x - centers[k]) / scales[k]
+				}
+			}
+		}
+
+		d2, df, err := robustMahalanobis(members, z, scales)
+		if err != nil {
+			log.Printf("Reference %s: no multivariate QC: %v\n", reference, err)
+		}
+		chi := distuv.ChiSquared{K: float64(df)}
+
+		for _, s := range assess {
+			s.Reference = reference
+			s.NReference = len(members)
+			s.Z = z[s]
+			for k, zk := range s.Z {
+				if math.Abs(zk) > nSD {
+					s.RobustFlags = append(s.RobustFlags, "Robust_"+names[k])
+				}
+			}
+
+			s.D2, s.DF, s.PValue = d2[s], df, math.NaN()
+			if !math.IsNaN(s.D2) {
+				s.PValue = chi.Survival(s.D2)
+			}
+			if s.PValue < alpha {
+				s.RobustFlags = append(s.RobustFlags, "Mahalanobis")
+			}
+		}
+
+		log.Printf("Reference %s: %d samples, %d assessed\n", reference, len(members), len(assess))
+	}
+}
+
+// robustLocationScale returns the median and the scaled MAD of each feature,
+// ignoring missing values.
+func robustLocationScale(samples []*sampleFeatures, nFeatures int) (centers, scales []float64) {
+	centers, scales = make([]float64, nFeatures), make([]float64, nFeatures)
+	for k := 0; k < nFeatures; k++ {
+		values := make([]float64, 0, len(samples))
+		for _, s := range samples {
+			if !math.IsNaN(s.Values[k]) {
+				values = append(values, s.Values[k])
+			}
+		}
+		if len(values) == 0 {
+			centers[k], scales[k] = math.NaN(), 0
+			continue
+		}
+
+		sort.Float64s(values)
+		centers[k] = medianSorted(values)
+
+		deviations := make([]float64, len(values))
+		var sumDeviations float64
+		for i, v := range values {
+			deviations[i] = math.Abs(v - centers[k])
+			sumDeviations += deviations[i]
+		}
+		sort.Float64s(deviations)
+
+		scales[k] = madScale * medianSorted(deviations)
+		if scales[k] == 0 {
+			scales[k] = meanADScale * sumDeviations / float64(len(deviations))
+		}
+	}
+
+	return centers, scales
+}
+
+// robustMahalanobis returns each sample's squared robust Mahalanobis
+// distance, computed from the robust z-scores of the features with nonzero
+// scale, and the number of such features. Samples with a missing feature are
+// given NaN.
+func robustMahalanobis(samples []*sampleFeatures, z map[*sampleFeatures][]float64, scales []float64) (map[*sampleFeatures]float64, int, error) {
+	var features []int
+	for k, scale := range scales {
+		if scale > 0 {
+			features = append(features, k)
+		}
+	}
+	p := len(features)
+
+	out := make(map[*sampleFeatures]float64, len(samples))
+	for _, s := range samples {
+		out[s] = math.NaN()
+	}
+
+	var complete []*sampleFeatures
+	var rows [][]float64
+SampleLoop:
+	for _, s := range samples {
+		row := make([]float64, p)
+		for i, k := range features {
+			if math.IsNaN(z[s][k]) {
+				continue SampleLoop
+			}
+			row[i] = z[s][k]
+		}
+		complete = append(complete, s)
+		rows = append(rows, row)
+	}
+
+	n := len(rows)
+	if p == 0 {
+		return out, p, fmt.Errorf("No features vary")
+	}
+	if n < 5*p {
+		return out, p, fmt.Errorf("Only %d complete samples for %d features", n, p)
+	}
+
+	// Start from the h samples closest to the coordinatewise median, which is
+	// 0 after robust standardization.
+	h := (3*n + 3) / 4
+	d2 := make([]float64, n)
+	for i, row := range rows {
+		for _, v := range row {
+			d2[i] += v * v
+		}
+	}
+
+	subset := closest(d2, h)
+	for step := 0; step < 100; step++ {
+		center, precision, err := meanAndPrecision(rows, subset)
+		if err != nil {
+			return out, p, err
+		}
+		for i, row := range rows {
+			d2[i] = mahalanobis2(row, center, precision)
+		}
+
+		next := closest(d2, h)
+		if sameSubset(subset, next) {
+			break
+		}
+		subset = next
+	}
+
+	// Rescale so that the median distance matches the chi-square median,
+	// which makes the subset covariance consistent for the full distribution.
+	sorted := append([]float64(nil), d2...)
+	sort.Float64s(sorted)
+	correction := distuv.ChiSquared{K: float64(p)}.Quantile(0.5) / medianSorted(sorted)
+
+	for i, s := range complete {
+		out[s] = d2[i] * correction
+	}
+
+	return out, p, nil
+}
+
+// closest returns the indices of the h smallest values, sorted.
+func closest(values []float64, h int) []int {
+	idx := make([]int, len(values))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return values[idx[i]] < values[idx[j]] })
+
+	out := append([]int(nil), idx[:h]...)
+	sort.Ints(out)
+
+	return out
+}
+
+func sameSubset(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// meanAndPrecision returns the mean and inverse covariance of the subset of
+// rows. A nearly singular covariance is regularized with a small ridge.
+func meanAndPrecision(rows [][]float64, subset []int) ([]float64, *mat.SymDense, error) {
+	p := len(rows[0])
+
+	center := make([]float64, p)
+	for _, i := range subset {
+		for k, v := range rows[i] {
+			center[k] += v
+		}
+	}
+	for k := range center {
+		center[k] /= float64(len(subset))
+	}
+
+	cov := mat.NewSymDense(p, nil)
+	for _, i := range subset {
+		for a := 0; a < p; a++ {
+			for b := a; b < p; b++ {
+				cov.SetSym(a, b, cov.At(a, b)+(rows[i][a]-center[a])*(rows[i][b]-center[b]))
+			}
+		}
+	}
+	var trace float64
+	for a := 0; a < p; a++ {
+		for b := a; b < p; b++ {
+			cov.SetSym(a, b, cov.At(a, b)/float64(len(subset)-1))
+		}
+		trace += cov.At(a, a)
+	}
+
+	var chol mat.Cholesky
+	for ridge := 0.0; ; {
+		if ok := chol.Factorize(cov); ok {
+			break
+		}
+		if ridge > trace {
+			return nil, nil, fmt.Errorf("Covariance is singular")
+		}
+
+		step := 1e-6 * trace / float64(p)
+		if ridge > 0 {
+			step = ridge
+		}
+		for a := 0; a < p; a++ {
+			cov.SetSym(a, a, cov.At(a, a)+step)
+		}
+		ridge += step
+	}
+
+	precision := mat.NewSymDense(p, nil)
+	if err := chol.InverseTo(precision); err != nil {
+		return nil, nil, err
+	}
+
+	return center, precision, nil
+}
+
+func mahalanobis2(row, center []float64, precision *mat.SymDense) float64 {
+	p := len(row)
+	diff := make([]float64, p)
+	for k := range row {
+		diff[k] = row[k] - center[k]
+	}
+
+	var out float64
+	for a := 0; a < p; a++ {
+		for b := 0; b < p; b++ {
+			out += diff[a] * precision.At(a, b) * diff[b]
+		}
+	}
+
+	return out
+}
+
+func medianSorted(values []float64) float64 {
+	n := len(values)
+	if n == 0 {
+		return math.NaN()
+	}
+	if n%2 == 1 {
+		return values[n/2]
+	}
+
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// writeFlagTable writes one row per sample with each feature, its robust
+// z-score, the Mahalanobis distance, and all flags (robust and rule-based).
+func writeFlagTable(w io.Writer, samples []*sampleFeatures, names []string, samplesWithFlags SampleFlags) error {
+	header := []string{"sampleid", "stratum", "reference", "n_reference", "n_images"}
+	for _, name := range names {
+		header = append(header, name, name+"_robust_z")
+	}
+	header = append(header, "mahalanobis_d2", "mahalanobis_df", "mahalanobis_p", "flagged", "flags")
+
+	if _, err := fmt.Fprintln(w, strings.Join(header, "\t")); err != nil {
+		return err
+	}
+
+	for _, s := range samples {
+		flags := samplesWithFlags[s.SampleID]
+		if flags == nil {
+			flags = make(flagSet)
+		}
+		for _, flag := range s.RobustFlags {
+			flags[flag] = struct{}{}
+		}
+
+		stratum := s.Stratum
+		if stratum == "" {
+			stratum = "NA"
+		}
+
+		row := []string{s.SampleID, stratum, s.Reference, strconv.Itoa(s.NReference), strconv.Itoa(s.NImages)}
+		for k := range names {
+			row = append(row, formatNA(s.Values[k]), formatNA(s.Z[k]))
+		}
+		row = append(row,
+			formatNA(s.D2),
+			strconv.Itoa(s.DF),
+			formatNA(s.PValue),
+			strconv.FormatBool(len(flags) > 0),
+			flags.String(),
+		)
+
+		if _, err := fmt.Fprintln(w, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatNA(x float64) string {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return "NA"
+	}
+
+	return strconv.FormatFloat(x, 'g', 5, 64)
+}
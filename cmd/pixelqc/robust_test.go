@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestRobustQC(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// Two strongly correlated features. Sample 0 is extreme on the first;
+	// sample 1 is unremarkable on each feature alone but breaks the
+	// correlation, so only the Mahalanobis distance can find it.
+	var samples []*sampleFeatures
+	for i := 0; i < 500; i++ {
+		x := rng.NormFloat64()
+		y := x + 0.1*rng.NormFloat64()
+		switch i {
+		case 0:
+			x, y = 50, 50
+		case 1:
+			x, y = 1.5, -1.5
+		}
+		samples = append(samples, &sampleFeatures{SampleID: strconv.Itoa(i), Values: []float64{x, y}})
+	}
+
+	robustQC(samples, []string{"x", "y"}, 5, 100)
+
+	hasFlag := func(s *sampleFeatures, flag string) bool {
+		for _, v := range s.RobustFlags {
+			if v == flag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !hasFlag(samples[0], "Robust_x") || !hasFlag(samples[0], "Mahalanobis") {
+		t.Errorf("Expected the extreme sample to be flagged, got %v", samples[0].RobustFlags)
+	}
+
+	if hasFlag(samples[1], "Robust_x") || hasFlag(samples[1], "Robust_y") || !hasFlag(samples[1], "Mahalanobis") {
+		t.Errorf("Expected only a Mahalanobis flag for the discordant sample, got %v", samples[1].RobustFlags)
+	}
+
+	flagged := 0
+	for _, s := range samples[2:] {
+		if len(s.RobustFlags) > 0 {
+			flagged++
+		}
+	}
+	if flagged > 0 {
+		t.Errorf("Expected no other samples to be flagged, found %d", flagged)
+	}
+}
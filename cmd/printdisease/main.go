@@ -14,6 +14,7 @@ import (
 
 	"cloud.google.com/go/bigquery"
 	_ "github.com/carbocation/genomisc/compileinfoprint"
+	"github.com/carbocation/genomisc/ukbb"
 )
 
 type WrappedBigQuery struct {
@@ -38,7 +39,7 @@ var materializedDB string
 func init() {
 	flag.Usage = func() {
 		flag.PrintDefaults()
-		describeDateFields(false)
+		ukbb.DescribeDateFields(false)
 	}
 }
 
@@ -108,6 +109,15 @@ func handleOneTabfile(tabfile, diseaseName string, dict map[int]UKBField, coding
 		log.Fatalln(err)
 	}
 
+	// Show exactly which codes any wildcards, ranges, or blocks match
+	codingTrees := make(map[string]*ukbb.CodingTree)
+	for _, c := range []string{ukbb.CodingICD10, ukbb.CodingICD9, ukbb.CodingOPCS4} {
+		codingTrees[c] = ukbb.NewCodingTree(c, coding[c])
+	}
+	if err := tabs.ExpandCodes(codingTrees); err != nil {
+		log.Fatalln(err)
+	}
+
 	if diseaseName == "" {
 		diseaseName = path.Base(tabfile)
 		if parts := strings.Split(diseaseName, "."); len(parts) > 1 {
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/carbocation/genomisc/assoc"
+)
+
+const (
+	TimescaleFollowup = "followup"
+	TimescaleAge      = "age"
+)
+
+// Participant joins one outcome row to the score and the covariates of the
+// participant.
+type Participant struct {
+	Outcome
+	Score      float64
+	Covariates []float64
+}
+
+// Join keeps the participants who have a score and, if covariates are given,
+// complete covariates.
+func Join(outcomes []Outcome, scores map[string]float64, covariates *Covariates) []Participant {
+	out := make([]Participant, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		score, exists := scores[outcome.SampleID]
+		if !exists {
+			continue
+		}
+
+		var covars []float64
+		if covariates != nil {
+			if covars, exists = covariates.Values[outcome.SampleID]; !exists {
+				continue
+			}
+		}
+
+		out = append(out, Participant{Outcome: outcome, Score: score, Covariates: covars})
+	}
+
+	return out
+}
+
+// Design builds the covariate matrix with the score, standardized within the
+// analysis set, in the first column. Covariates that do not vary within the
+// analysis set are dropped.
+func Design(participants []Participant, covariateNames []string) ([][]float64, []string, error) {
+	n := float64(len(participants))
+	if n < 2 {
+		return nil, nil, fmt.Errorf("Only %d participants", len(participants))
+	}
+
+	mean, sd := 0.0, 0.0
+	for _, p := range participants {
+		mean += p.Score / n
+	}
+	for _, p := range participants {
+		sd += (p.Score - mean) * (p.Score - mean) / (n - 1)
+	}
+	sd = math.Sqrt(sd)
+	if sd == 0 {
+		return nil, nil, fmt.Errorf("The score does not vary")
+	}
+
+	var keep []int
+	names := []string{"score"}
+	for j, name := range covariateNames {
+		for _, p := range participants[1:] {
+			if p.Covariates[j] != participants[0].Covariates[j] {
+				keep = append(keep, j)
+				names = append(names, name)
+				break
+			}
+		}
+		if len(keep) == 0 || keep[len(keep)-1] != j {
+			log.Printf("Dropping covariate %s, which does not vary\n", name)
+		}
+	}
+
+	x := make([][]float64, len(participants))
+	for i, p := range participants {
+		x[i] = make([]float64, 0, len(names))
+		x[i] = append(x[i], (p.Score-mean)/sd)
+		for _, j := range keep {
+			x[i] = append(x[i], p.Covariates[j])
+		}
+	}
+
+	return x, names, nil
+}
+
+// PrevalentSet is the set of participants whose prevalent disease status is
+// known.
+func PrevalentSet(participants []Participant) []Participant {
+	var out []Participant
+	for _, p := range participants {
+		if !math.IsNaN(p.Prevalent) {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
+
+// IncidentSet is the set of participants free of disease at enrollment whose
+// incident disease status and follow-up are known, along with their follow-up
+// on the chosen time scale.
+func IncidentSet(participants []Participant, timescale string) ([]Participant, assoc.SurvivalData) {
+	var out []Participant
+	var data assoc.SurvivalData
+	for _, p := range participants {
+		if math.IsNaN(p.Incident) || p.Prevalent == 1 || math.IsNaN(p.CensorAgeDays) || math.IsNaN(p.EnrollAgeDays) {
+			continue
+		}
+		if p.CensorAgeDays <= p.EnrollAgeDays {
+			continue
+		}
+
+		out = append(out, p)
+		data.Event = append(data.Event, p.Incident == 1)
+		if timescale == TimescaleAge {
+			data.Entry = append(data.Entry, p.EnrollAgeDays)
+			data.Exit = append(data.Exit, p.CensorAgeDays)
+		} else {
+			data.Exit = append(data.Exit, p.CensorAgeDays-p.EnrollAgeDays)
+		}
+	}
+
+	return out, data
+}
+
+// Quantiles assigns each participant to a quantile of the score, with 0 being
+// the lowest.
+func Quantiles(participants []Participant, n int) []int {
+	order := make([]int, len(participants))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return participants[order[i]].Score < participants[order[j]].Score })
+
+	out := make([]int, len(participants))
+	for rank, i := range order {
+		out[i] = rank * n / len(participants)
+	}
+
+	return out
+}
+
+// QuantileData returns the follow-up of the participants in the given
+// quantile.
+func QuantileData(data assoc.SurvivalData, quantiles []int, quantile int) assoc.SurvivalData {
+	var out assoc.SurvivalData
+	for i, group := range quantiles {
+		if group != quantile {
+			continue
+		}
+		if data.Entry != nil {
+			out.Entry = append(out.Entry, data.Entry[i])
+		}
+		out.Exit = append(out.Exit, data.Exit[i])
+		out.Event = append(out.Event, data.Event[i])
+	}
+
+	return out
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadCovariates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "covar.tsv")
+	contents := "sample_id\tage\tsex\tsite\n" +
+		"1\t50\tF\tb\n" +
+		"2\t60\tM\ta\n" +
+		"3\tNA\tM\tc\n" +
+		"4\t55.5\tF\tc\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	covariates, err := ReadCovariates(path, "sample_id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sample 3 is missing age and so is left out, and sex and site are
+	// indicator coded against their first levels.
+	if expected := []string{"age", "sex=M", "site=b", "site=c"}; !reflect.DeepEqual(covariates.Names, expected) {
+		t.Errorf("Expected %v, got %v", expected, covariates.Names)
+	}
+	expected := map[string][]float64{
+		"1": {50, 0, 1, 0},
+		"2": {60, 1, 0, 0},
+		"4": {55.5, 0, 0, 1},
+	}
+	if !reflect.DeepEqual(covariates.Values, expected) {
+		t.Errorf("Expected %v, got %v", expected, covariates.Values)
+	}
+}
+
+func TestAnalysisSets(t *testing.T) {
+	participants := []Participant{
+		{Outcome: Outcome{SampleID: "1", Incident: 0, Prevalent: 1, CensorAgeDays: 200, EnrollAgeDays: 100}, Score: 1},
+		{Outcome: Outcome{SampleID: "2", Incident: 1, Prevalent: 0, CensorAgeDays: 150, EnrollAgeDays: 100}, Score: 2},
+		{Outcome: Outcome{SampleID: "3", Incident: 0, Prevalent: 0, CensorAgeDays: 300, EnrollAgeDays: 120}, Score: 3},
+		{Outcome: Outcome{SampleID: "4", Incident: nan, Prevalent: nan, CensorAgeDays: 300, EnrollAgeDays: 120}, Score: 4},
+	}
+
+	if prevalent := PrevalentSet(participants); len(prevalent) != 3 {
+		t.Errorf("Expected 3 participants with known prevalent disease, got %d", len(prevalent))
+	}
+
+	incident, data := IncidentSet(participants, TimescaleFollowup)
+	if len(incident) != 2 || !reflect.DeepEqual(data.Exit, []float64{50, 180}) || data.Entry != nil || !reflect.DeepEqual(data.Event, []bool{true, false}) {
+		t.Errorf("Unexpected follow-up %+v", data)
+	}
+
+	_, data = IncidentSet(participants, TimescaleAge)
+	if !reflect.DeepEqual(data.Entry, []float64{100, 120}) || !reflect.DeepEqual(data.Exit, []float64{150, 300}) {
+		t.Errorf("Unexpected follow-up %+v", data)
+	}
+
+	x, names, err := Design(incident, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"score"}) || math.Abs(x[0][0]+math.Sqrt(0.5)) > 1e-12 || math.Abs(x[1][0]-math.Sqrt(0.5)) > 1e-12 {
+		t.Errorf("Expected a standardized score, got %v %v", names, x)
+	}
+
+	if quantiles := Quantiles(participants, 2); !reflect.DeepEqual(quantiles, []int{0, 0, 1, 1}) {
+		t.Errorf("Unexpected quantiles %v", quantiles)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/carbocation/genomisc"
+)
+
+// readTable reads a tab-delimited file (optionally compressed) with a header,
+// calling fn with each row. The row is reused between calls.
+func readTable(path string, fn func(header []string, row []string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rc, err := genomisc.MaybeDecompressReadCloserFromFile(f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	reader := csv.NewReader(rc)
+	reader.Comma = '\t'
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("Reading the header of %s: %v", path, err)
+	}
+	header = append([]string(nil), header...)
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Reading %s: %v", path, err)
+		}
+
+		if err := fn(header, row); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+func columnIndex(header []string, name string) int {
+	for i, v := range header {
+		if v == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func isMissing(value string) bool {
+	switch strings.TrimSpace(value) {
+	case "", "NA", "NaN", "NULL", "nan":
+		return true
+	}
+
+	return false
+}
+
+// ReadScores reads one score per sample.
+func ReadScores(path, sampleColumn, scoreColumn string) (map[string]float64, error) {
+	out := make(map[string]float64)
+	sampleCol, scoreCol := -1, -1
+	err := readTable(path, func(header, row []string) error {
+		if sampleCol < 0 {
+			sampleCol, scoreCol = columnIndex(header, sampleColumn), columnIndex(header, scoreColumn)
+			if sampleCol < 0 || scoreCol < 0 {
+				return fmt.Errorf("Expected %s and %s columns", sampleColumn, scoreColumn)
+			}
+		}
+
+		if isMissing(row[scoreCol]) {
+			return nil
+		}
+		score, err := strconv.ParseFloat(row[scoreCol], 64)
+		if err != nil {
+			return err
+		}
+		if _, exists := out[row[sampleCol]]; exists {
+			return fmt.Errorf("Sample %s has more than one score", row[sampleCol])
+		}
+		out[row[sampleCol]] = score
+
+		return nil
+	})
+
+	return out, err
+}
+
+// Outcome is one participant's row of ukbb2disease output. Missing values are
+// NaN.
+type Outcome struct {
+	SampleID      string
+	Incident      float64
+	Prevalent     float64
+	CensorAgeDays float64
+	EnrollAgeDays float64
+}
+
+// ReadOutcomes reads the output of ukbb2disease, which may hold more than one
+// disease. Diseases are returned in the order in which they first appear.
+func ReadOutcomes(path string) ([]string, map[string][]Outcome, error) {
+	var diseases []string
+	out := make(map[string][]Outcome)
+
+	required := []string{"disease", "sample_id", "incident_disease", "prevalent_disease", "censor_age_days", "enroll_age_days"}
+	var cols []int
+	err := readTable(path, func(header, row []string) error {
+		if cols == nil {
+			for _, name := range required {
+				col := columnIndex(header, name)
+				if col < 0 {
+					return fmt.Errorf("Expected a %s column", name)
+				}
+				cols = append(cols, col)
+			}
+		}
+
+		values := make([]float64, 4)
+		for i, col := range cols[2:] {
+			values[i] = parseOrNaN(row[col])
+		}
+
+		disease := row[cols[0]]
+		if _, exists := out[disease]; !exists {
+			diseases = append(diseases, disease)
+		}
+		out[disease] = append(out[disease], Outcome{
+			SampleID:      row[cols[1]],
+			Incident:      values[0],
+			Prevalent:     values[1],
+			CensorAgeDays: values[2],
+			EnrollAgeDays: values[3],
+		})
+
+		return nil
+	})
+
+	return diseases, out, err
+}
+
+func parseOrNaN(value string) float64 {
+	if isMissing(value) {
+		return nan
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nan
+	}
+
+	return v
+}
+
+// Covariates holds the design columns derived from a covariate file. Numeric
+// columns are used as-is. Other columns are treated as categorical and coded
+// as indicators for each level but the first (in sorted order), named
+// column=level.
+type Covariates struct {
+	Names  []string
+	Values map[string][]float64
+}
+
+// ReadCovariates reads the named columns (or, if names is empty, all columns
+// but the sample ID) of a covariate file. Samples with a missing value in any
+// of the columns are left out.
+func ReadCovariates(path, sampleColumn string, names []string) (*Covariates, error) {
+	raw := make(map[string][]string)
+	var cols []int
+	err := readTable(path, func(header, row []string) error {
+		if cols == nil {
+			sampleCol := columnIndex(header, sampleColumn)
+			if sampleCol < 0 {
+				return fmt.Errorf("Expected a %s column", sampleColumn)
+			}
+			if len(names) == 0 {
+				for _, name := range header {
+					if name != sampleColumn {
+						names = append(names, name)
+					}
+				}
+			}
+			cols = []int{sampleCol}
+			for _, name := range names {
+				col := columnIndex(header, name)
+				if col < 0 {
+					return fmt.Errorf("Expected a %s column", name)
+				}
+				cols = append(cols, col)
+			}
+		}
+
+		values := make([]string, 0, len(names))
+		for _, col := range cols[1:] {
+			if isMissing(row[col]) {
+				return nil
+			}
+			values = append(values, strings.TrimSpace(row[col]))
+		}
+		raw[row[cols[0]]] = values
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Decide which columns are numeric, and find the levels of the others
+	numeric := make([]bool, len(names))
+	levels := make([][]string, len(names))
+	for j := range names {
+		numeric[j] = true
+		seen := make(map[string]struct{})
+		for _, values := range raw {
+			if _, err := strconv.ParseFloat(values[j], 64); err != nil {
+				numeric[j] = false
+			}
+			seen[values[j]] = struct{}{}
+		}
+		if !numeric[j] {
+			for level := range seen {
+				levels[j] = append(levels[j], level)
+			}
+			sort.Strings(levels[j])
+		}
+	}
+
+	out := &Covariates{Values: make(map[string][]float64, len(raw))}
+	for j, name := range names {
+		if numeric[j] {
+			out.Names = append(out.Names, name)
+			continue
+		}
+		for _, level := range levels[j][1:] {
+			out.Names = append(out.Names, name+"="+level)
+		}
+	}
+
+	for sampleID, values := range raw {
+		design := make([]float64, 0, len(out.Names))
+		for j, value := range values {
+			if numeric[j] {
+				v, _ := strconv.ParseFloat(value, 64)
+				design = append(design, v)
+				continue
+			}
+			for _, level := range levels[j][1:] {
+				indicator := 0.0
+				if value == level {
+					indicator = 1
+				}
+				design = append(design, indicator)
+			}
+		}
+		out.Values[sampleID] = design
+	}
+
+	return out, nil
+}
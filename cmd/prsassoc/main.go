@@ -0,0 +1,215 @@
+// prsassoc associates a score (e.g., the output of applyprsbasic) with disease
+// outcomes (the output of ukbb2disease), adjusting for a table of covariates.
+// Prevalent disease is modeled with logistic regression and incident disease
+// with Cox proportional hazards regression. Effects are reported per standard
+// deviation of the score.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/carbocation/genomisc/assoc"
+	_ "github.com/carbocation/genomisc/compileinfoprint"
+	"github.com/carbocation/pfx"
+)
+
+var (
+	BufferSize = 4096 * 8
+	STDOUT     = bufio.NewWriterSize(os.Stdout, BufferSize)
+
+	nan = math.NaN()
+)
+
+const confidenceLevel = 0.95
+
+func main() {
+	fmt.Fprintf(os.Stderr, "%q\n", os.Args)
+
+	defer STDOUT.Flush()
+
+	var (
+		scorePath     string
+		scoreColumn   string
+		outcomePath   string
+		disease       string
+		covarPath     string
+		covariateList string
+		sampleColumn  string
+		timescale     string
+		nQuantiles    int
+		kmDir         string
+	)
+	flag.StringVar(&scorePath, "score", "", "Path to a tab-delimited file with one score per sample, such as the output of applyprsbasic.")
+	flag.StringVar(&scoreColumn, "score-column", "score", "Column of the -score file that holds the score.")
+	flag.StringVar(&outcomePath, "outcome", "", "Path to the output of ukbb2disease. May contain more than one disease.")
+	flag.StringVar(&disease, "disease", "", "Optional: only analyze this disease from the -outcome file.")
+	flag.StringVar(&covarPath, "covar", "", "Optional: path to a tab-delimited file of covariates with one sample per row.")
+	flag.StringVar(&covariateList, "covariates", "", "Optional: comma-separated columns of the -covar file to adjust for. If empty, all columns but the sample ID are used. Non-numeric columns are treated as categorical.")
+	flag.StringVar(&sampleColumn, "sample-column", "sample_id", "Column that holds the sample ID in the -score and -covar files.")
+	flag.StringVar(&timescale, "timescale", TimescaleFollowup, fmt.Sprintf("Time scale of the Cox models: %s (time since enrollment) or %s (age, with entry at enrollment).", TimescaleFollowup, TimescaleAge))
+	flag.IntVar(&nQuantiles, "quantiles", 4, "Number of quantiles of the score to draw Kaplan-Meier curves for.")
+	flag.StringVar(&kmDir, "km-dir", "", "Optional: directory into which a Kaplan-Meier plot of each disease, by quantile of the score, is written as a PNG.")
+	flag.Parse()
+
+	if scorePath == "" || outcomePath == "" {
+		flag.PrintDefaults()
+		log.Fatalln("Please provide -score and -outcome")
+	}
+	if timescale != TimescaleFollowup && timescale != TimescaleAge {
+		flag.PrintDefaults()
+		log.Fatalf("-timescale must be %s or %s\n", TimescaleFollowup, TimescaleAge)
+	}
+	if nQuantiles < 1 {
+		log.Fatalln("-quantiles must be at least 1")
+	}
+
+	scores, err := ReadScores(scorePath, sampleColumn, scoreColumn)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Read scores for %d samples\n", len(scores))
+
+	var covariates *Covariates
+	var covariateNames []string
+	if covarPath != "" {
+		var names []string
+		if covariateList != "" {
+			names = strings.Split(covariateList, ",")
+		}
+		covariates, err = ReadCovariates(covarPath, sampleColumn, names)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		covariateNames = covariates.Names
+		log.Printf("Read complete covariates (%s) for %d samples\n", strings.Join(covariateNames, ", "), len(covariates.Values))
+	}
+
+	diseases, outcomes, err := ReadOutcomes(outcomePath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if disease != "" {
+		if _, exists := outcomes[disease]; !exists {
+			log.Fatalf("Disease %s is not in %s\n", disease, outcomePath)
+		}
+		diseases = []string{disease}
+	}
+
+	fmt.Fprintln(STDOUT, strings.Join([]string{"disease", "model", "outcome", "n", "n_events", "term", "beta", "se", "ratio", "ratio_lci", "ratio_uci", "p", "ph_chisq", "ph_p"}, "\t"))
+
+	for _, name := range diseases {
+		participants := Join(outcomes[name], scores, covariates)
+		log.Printf("%s: %d participants with a score and complete covariates\n", name, len(participants))
+
+		if err := runLogistic(name, participants, covariateNames); err != nil {
+			log.Println(pfx.Err(fmt.Errorf("%s: prevalent disease: %v", name, err)))
+		}
+
+		if err := runCox(name, participants, covariateNames, timescale, nQuantiles, kmDir); err != nil {
+			log.Println(pfx.Err(fmt.Errorf("%s: incident disease: %v", name, err)))
+		}
+
+		STDOUT.Flush()
+	}
+}
+
+func runLogistic(disease string, participants []Participant, covariateNames []string) error {
+	participants = PrevalentSet(participants)
+	x, names, err := Design(participants, covariateNames)
+	if err != nil {
+		return err
+	}
+
+	y := make([]bool, len(participants))
+	for i, p := range participants {
+		y[i] = p.Prevalent == 1
+	}
+
+	fit, err := assoc.Logistic(y, x, names)
+	if err != nil {
+		return err
+	}
+	if !fit.Converged {
+		log.Printf("%s: the logistic model did not converge\n", disease)
+	}
+
+	// The intercept is not reported
+	for i := 1; i < len(fit.Coef); i++ {
+		printTerm(disease, "logistic", "prevalent", fit, i, nil)
+	}
+
+	return nil
+}
+
+func runCox(disease string, participants []Participant, covariateNames []string, timescale string, nQuantiles int, kmDir string) error {
+	participants, data := IncidentSet(participants, timescale)
+	x, names, err := Design(participants, covariateNames)
+	if err != nil {
+		return err
+	}
+
+	fit, err := assoc.Cox(data, x, names)
+	if err != nil {
+		return err
+	}
+	if !fit.Converged {
+		log.Printf("%s: the Cox model did not converge\n", disease)
+	}
+
+	ph, err := fit.ProportionalHazards()
+	if err != nil {
+		log.Println(pfx.Err(fmt.Errorf("%s: could not test proportional hazards: %v", disease, err)))
+	}
+
+	for i := range fit.Coef {
+		var test *assoc.PHTest
+		if ph != nil {
+			test = &ph[i]
+		}
+		printTerm(disease, "cox", "incident", &fit.Fit, i, test)
+	}
+	if ph != nil {
+		global := ph[len(ph)-1]
+		fmt.Fprintf(STDOUT, "%s\tcox\tincident\t%d\t%d\t%s\tNA\tNA\tNA\tNA\tNA\tNA\t%g\t%g\n", disease, fit.N, fit.NEvents, global.Name, global.Chisq, global.P)
+	}
+
+	if kmDir != "" {
+		path := filepath.Join(kmDir, safeFilename(disease)+".km.png")
+		if err := PlotKaplanMeier(path, disease, timescale, data, Quantiles(participants, nQuantiles), nQuantiles); err != nil {
+			return err
+		}
+		log.Printf("%s: wrote %s\n", disease, path)
+	}
+
+	return nil
+}
+
+// printTerm prints one coefficient. The ratio is the odds ratio (logistic) or
+// the hazard ratio (Cox).
+func printTerm(disease, model, outcome string, fit *assoc.Fit, i int, ph *assoc.PHTest) {
+	lo, hi := fit.ConfInt(i, confidenceLevel)
+
+	phChisq, phP := "NA", "NA"
+	if ph != nil {
+		phChisq, phP = fmt.Sprintf("%g", ph.Chisq), fmt.Sprintf("%g", ph.P)
+	}
+
+	fmt.Fprintf(STDOUT, "%s\t%s\t%s\t%d\t%d\t%s\t%g\t%g\t%g\t%g\t%g\t%g\t%s\t%s\n",
+		disease, model, outcome, fit.N, fit.NEvents, fit.Names[i],
+		fit.Coef[i], fit.SE[i], math.Exp(fit.Coef[i]), math.Exp(lo), math.Exp(hi), fit.P(i),
+		phChisq, phP)
+}
+
+var unsafeFilenameCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func safeFilename(name string) string {
+	return unsafeFilenameCharacters.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/carbocation/genomisc/assoc"
+	"github.com/wcharczuk/go-chart/v2"
+	"github.com/wcharczuk/go-chart/v2/drawing"
+)
+
+var quantileColors = []drawing.Color{
+	chart.ColorBlue,
+	chart.ColorCyan,
+	chart.ColorGreen,
+	chart.ColorOrange,
+	chart.ColorRed,
+	chart.ColorBlack,
+}
+
+// PlotKaplanMeier writes the cumulative incidence (1 - the Kaplan-Meier
+// estimate of survival) of each quantile of the score to a PNG.
+func PlotKaplanMeier(path, disease, timescale string, data assoc.SurvivalData, quantiles []int, nQuantiles int) error {
+	var series []chart.Series
+	for q := 0; q < nQuantiles; q++ {
+		km, err := assoc.KaplanMeier(QuantileData(data, quantiles, q))
+		if err != nil {
+			return err
+		}
+
+		// A step function: each point is drawn at the start of the interval
+		// over which it holds.
+		xs, ys := []float64{0}, []float64{0}
+		if timescale == TimescaleAge && len(km) > 0 {
+			xs[0] = km[0].Time / 365.25
+		}
+		for _, point := range km {
+			xs = append(xs, point.Time/365.25, point.Time/365.25)
+			ys = append(ys, ys[len(ys)-1], 100*(1-point.Survival))
+		}
+
+		color := quantileColors[q%len(quantileColors)]
+		series = append(series, chart.ContinuousSeries{
+			Name:    fmt.Sprintf("Q%d", q+1),
+			XValues: xs,
+			YValues: ys,
+			Style: chart.Style{
+				StrokeColor: color,
+				StrokeWidth: 2,
+			},
+		})
+	}
+
+	xName := "Years since enrollment"
+	if timescale == TimescaleAge {
+		xName = "Age (years)"
+	}
+
+	graph := chart.Chart{
+		Title:  disease,
+		Width:  1024,
+		Height: 768,
+		Background: chart.Style{
+			Padding: chart.Box{Top: 50, Left: 20, Right: 20, Bottom: 20},
+		},
+		XAxis: chart.XAxis{
+			Name: xName,
+		},
+		YAxis: chart.YAxis{
+			Name: "Cumulative incidence (%)",
+		},
+		Series: series,
+	}
+	graph.Elements = []chart.Renderable{chart.Legend(&graph)}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := graph.Render(chart.PNG, f); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
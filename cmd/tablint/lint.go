@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/carbocation/genomisc/ukbb"
+)
+
+// Severities of a Diagnostic. Errors are mistakes that change which
+// participants a tabfile matches; warnings may be intentional.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Checks that produce a Diagnostic.
+const (
+	CheckParse            = "parse"
+	CheckExcludeFlag      = "exclude-flag"
+	CheckEmptyValue       = "empty-value"
+	CheckUnknownField     = "unknown-field"
+	CheckUnknownCoding    = "unknown-coding"
+	CheckUnknownCode      = "unknown-code"
+	CheckBadPattern       = "bad-pattern"
+	CheckDottedCode       = "dotted-code"
+	CheckDuplicate        = "duplicate"
+	CheckUnobservedField  = "unobserved-field"
+	CheckUnobservedCode   = "unobserved-code"
+	CheckUndatedField     = "undated-field"
+	CheckOverlap          = "overlap"
+	CheckMissingCompanion = "missing-companion"
+)
+
+// Diagnostic is one problem found in a tabfile. FieldID and Value are empty
+// when the problem is not specific to them.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Severity string
+	Check    string
+	FieldID  string
+	Value    string
+	Message  string
+}
+
+// tabLine is one criterion of a tabfile along with its line number.
+type tabLine struct {
+	Line    int
+	FieldID int
+	Values  []string
+	Exclude bool
+}
+
+// Linter checks tabfiles against the data dictionary, the codings, and
+// (optionally) the values that occur in the dataset.
+type Linter struct {
+	Dict    map[int]ukbb.DictionaryField
+	Codings map[string]map[string]string
+
+	// FieldID => value => present. If nil, values are not checked against the
+	// dataset.
+	Observed map[int]map[string]struct{}
+
+	trees map[string]*ukbb.CodingTree
+}
+
+func NewLinter(dict map[int]ukbb.DictionaryField, codings map[string]map[string]string) *Linter {
+	trees := make(map[string]*ukbb.CodingTree)
+	for _, c := range []string{ukbb.CodingICD10, ukbb.CodingICD9, ukbb.CodingOPCS4} {
+		trees[c] = ukbb.NewCodingTree(c, codings[c])
+	}
+
+	return &Linter{Dict: dict, Codings: codings, trees: trees}
+}
+
+// readTabLines reads the criteria of a tabfile. Problems with its structure
+// are returned as diagnostics, and the affected lines are skipped.
+func readTabLines(path string) ([]tabLine, []Diagnostic, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	fileCSV := csv.NewReader(f)
+	fileCSV.Comma = '\t'
+	fileCSV.Comment = '#'
+	fileCSV.FieldsPerRecord = -1
+
+	var out []tabLine
+	var diags []Diagnostic
+	for i := 0; ; i++ {
+		row, err := fileCSV.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			diags = append(diags, Diagnostic{File: path, Line: parseErr.Line, Severity: SeverityError, Check: CheckParse, Message: parseErr.Err.Error()})
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		line, _ := fileCSV.FieldPos(0)
+
+		if l := len(row); l != 3 {
+			diags = append(diags, Diagnostic{File: path, Line: line, Severity: SeverityError, Check: CheckParse, Message: fmt.Sprintf("Row had %d columns, expected 3", l)})
+			continue
+		}
+
+		if i == 0 {
+			// header
+			continue
+		}
+
+		entry := tabLine{
+			Line:    line,
+			Values:  strings.Split(row[1], ","),
+			Exclude: row[2] == "1",
+		}
+		entry.FieldID, err = strconv.Atoi(strings.TrimSpace(row[0]))
+		if err != nil {
+			diags = append(diags, Diagnostic{File: path, Line: line, Severity: SeverityError, Check: CheckParse, FieldID: row[0], Message: "FieldID is not an integer"})
+			continue
+		}
+
+		if row[2] != "0" && row[2] != "1" {
+			diags = append(diags, Diagnostic{File: path, Line: line, Severity: SeverityWarning, Check: CheckExcludeFlag, FieldID: row[0], Value: row[2], Message: "The exclude column is neither 0 nor 1, so this line is treated as an inclusion"})
+		}
+
+		out = append(out, entry)
+	}
+
+	return out, diags, nil
+}
+
+// formatValue mirrors TabEntry.FormattedValues: values are trimmed, and dots
+// are removed from the values of HESIN fields.
+func formatValue(fieldID int, value string) string {
+	if ukbb.IsHesin(fieldID) {
+		value = strings.Replace(value, ".", "", -1)
+	}
+
+	return strings.TrimSpace(value)
+}
+
+type fieldValue struct {
+	FieldID int
+	Value   string
+}
+
+// Lint returns every diagnostic for the tabfile, ordered by line.
+func (l *Linter) Lint(path string) ([]Diagnostic, error) {
+	lines, diags, err := readTabLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	diag := func(line tabLine, severity, check, value, message string) {
+		diags = append(diags, Diagnostic{File: path, Line: line.Line, Severity: severity, Check: check, FieldID: strconv.Itoa(line.FieldID), Value: value, Message: message})
+	}
+
+	// The codes that each line matches, after expanding patterns, and the
+	// first line to have written each value
+	matched := make([]map[string]struct{}, len(lines))
+	written := make(map[bool]map[fieldValue]int)
+	written[false] = make(map[fieldValue]int)
+	written[true] = make(map[fieldValue]int)
+
+	for i, line := range lines {
+		matched[i] = make(map[string]struct{})
+
+		field, known := l.Dict[line.FieldID]
+		if !known {
+			diag(line, SeverityError, CheckUnknownField, "", "FieldID is not in the data dictionary")
+		}
+
+		if !ukbb.IsHesin(line.FieldID) && !ukbb.IsSpecial(line.FieldID) {
+			diag(line, SeverityWarning, CheckUndatedField, "", "FieldID has no known date, so participants who match it will have prevalent disease only")
+		}
+
+		var coding map[string]string
+		if known && field.Coding.Valid {
+			if coding = l.Codings[field.Coding.String]; coding == nil {
+				diag(line, SeverityWarning, CheckUnknownCoding, "", fmt.Sprintf("Coding %s of the FieldID is not in the codings file, so its values were not checked", field.Coding.String))
+			}
+		}
+
+		observed, fieldObserved := l.Observed[line.FieldID]
+		if l.Observed != nil && !fieldObserved {
+			diag(line, SeverityWarning, CheckUnobservedField, "", "FieldID has no values in the dataset")
+		}
+
+		for _, raw := range line.Values {
+			value := formatValue(line.FieldID, raw)
+			if value == "" {
+				diag(line, SeverityWarning, CheckEmptyValue, raw, "Empty value (e.g., from a stray comma)")
+				continue
+			}
+
+			key := fieldValue{line.FieldID, value}
+			if prior, exists := written[line.Exclude][key]; exists {
+				diag(line, SeverityWarning, CheckDuplicate, raw, fmt.Sprintf("Value was already listed on line %d", prior))
+			} else {
+				written[line.Exclude][key] = line.Line
+			}
+
+			codes, err := l.expand(line.FieldID, value)
+			if err != nil {
+				diag(line, SeverityError, CheckBadPattern, raw, err.Error())
+				continue
+			}
+
+			if strings.Contains(value, ".") && !ukbb.IsHesin(line.FieldID) && !ukbb.IsCodePattern(value) && coding != nil {
+				stripped := strings.Replace(value, ".", "", -1)
+				if _, dotted := coding[value]; !dotted {
+					if _, exists := coding[stripped]; exists || ukbb.HierarchicalCodingByFieldID[line.FieldID] != "" {
+						diag(line, SeverityError, CheckDottedCode, raw, fmt.Sprintf("Dots are only removed from the codes of HESIN fields, so this will never match; write %s", stripped))
+						continue
+					}
+				}
+			}
+
+			if coding != nil && !ukbb.IsCodePattern(value) {
+				if _, exists := coding[value]; !exists {
+					diag(line, SeverityError, CheckUnknownCode, raw, fmt.Sprintf("Value is not in coding %s", field.Coding.String))
+					continue
+				}
+			}
+
+			anyObserved := false
+			for _, code := range codes {
+				matched[i][code] = struct{}{}
+				if _, exists := observed[code]; exists {
+					anyObserved = true
+				}
+			}
+			if fieldObserved && !anyObserved {
+				diag(line, SeverityWarning, CheckUnobservedCode, raw, "Value never occurs in the dataset")
+			}
+		}
+	}
+
+	diags = append(diags, l.overlaps(path, lines, matched)...)
+	diags = append(diags, missingCompanions(path, lines)...)
+
+	sort.SliceStable(diags, func(i, j int) bool { return diags[i].Line < diags[j].Line })
+
+	return diags, nil
+}
+
+// expand returns the codes that a value matches, expanding the wildcards,
+// ranges, and blocks of ICD-10, ICD-9, and OPCS-4 fields like
+// ukbb.ExpandCodes.
+func (l *Linter) expand(fieldID int, value string) ([]string, error) {
+	if !ukbb.IsCodePattern(value) {
+		return []string{value}, nil
+	}
+
+	coding, hierarchical := ukbb.HierarchicalCodingByFieldID[fieldID]
+	if !hierarchical {
+		if strings.HasSuffix(value, "*") {
+			return nil, fmt.Errorf("Wildcards are only supported for ICD-10, ICD-9, and OPCS-4 fields")
+		}
+		return []string{value}, nil
+	}
+
+	return l.trees[coding].Expand(value)
+}
+
+// overlaps finds codes that are both included and excluded, reporting each
+// pair of lines once at the exclusion.
+func (l *Linter) overlaps(path string, lines []tabLine, matched []map[string]struct{}) []Diagnostic {
+	var out []Diagnostic
+	for i, exclude := range lines {
+		if !exclude.Exclude {
+			continue
+		}
+		for j, include := range lines {
+			if include.Exclude || include.FieldID != exclude.FieldID {
+				continue
+			}
+
+			var codes []string
+			for code := range matched[i] {
+				if _, exists := matched[j][code]; exists {
+					codes = append(codes, code)
+				}
+			}
+			if len(codes) == 0 {
+				continue
+			}
+			sort.Strings(codes)
+
+			shown := codes
+			if len(shown) > 5 {
+				shown = append(shown[:5:5], "...")
+			}
+			out = append(out, Diagnostic{File: path, Line: exclude.Line, Severity: SeverityError, Check: CheckOverlap, FieldID: strconv.Itoa(exclude.FieldID), Value: strings.Join(shown, ","),
+				Message: fmt.Sprintf("%d code(s) are excluded here but included on line %d", len(codes), include.Line)})
+		}
+	}
+
+	return out
+}
+
+// missingCompanions mirrors TabFile.CheckSensibility: a tabfile that uses some
+// of the FieldIDs for a family of codes (e.g., primary and secondary ICD-10
+// diagnoses) should usually use all of them, separately for inclusion and
+// exclusion. The diagnostic is placed on the family's first line.
+func missingCompanions(path string, lines []tabLine) []Diagnostic {
+	families := []struct {
+		Name   string
+		Fields map[int]struct{}
+	}{
+		{"ICD9", ukbb.ICD9},
+		{"ICD10", ukbb.ICD10},
+		{"OPCS", ukbb.OPCS},
+	}
+
+	var out []Diagnostic
+	for _, exclude := range []bool{false, true} {
+		for _, family := range families {
+			present := make(map[int]struct{})
+			first := 0
+			for _, line := range lines {
+				if _, exists := family.Fields[line.FieldID]; !exists || line.Exclude != exclude {
+					continue
+				}
+				if first == 0 {
+					first = line.Line
+				}
+				present[line.FieldID] = struct{}{}
+			}
+			if len(present) == 0 || len(present) == len(family.Fields) {
+				continue
+			}
+
+			var missing []string
+			for fieldID := range family.Fields {
+				if _, exists := present[fieldID]; !exists {
+					missing = append(missing, strconv.Itoa(fieldID))
+				}
+			}
+			sort.Strings(missing)
+
+			subset := "inclusion"
+			if exclude {
+				subset = "exclusion"
+			}
+			out = append(out, Diagnostic{File: path, Line: first, Severity: SeverityWarning, Check: CheckMissingCompanion,
+				Message: fmt.Sprintf("The %s criteria use some %s fields; consider also using %s", subset, family.Name, strings.Join(missing, ","))})
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/carbocation/genomisc/ukbb"
+)
+
+const testDictionary = `Path,Category,FieldID,Field,Participants,Items,Stability,ValueType,Units,ItemType,Strata,Sexed,Instances,Array,Coding,Notes,Link
+HES,2002,41202,Diagnoses - main ICD10,440000,1000000,Accruing,Categorical multiple,,Data,Primary,Unisex,1,80,19,,http://
+HES,2002,41204,Diagnoses - secondary ICD10,400000,3000000,Accruing,Categorical multiple,,Data,Primary,Unisex,1,200,19,,http://
+Death,100093,40001,Underlying (primary) cause of death: ICD10,40000,40000,Accruing,Categorical single,,Data,Primary,Unisex,2,1,19,,http://
+Death,100093,40002,Contributory (secondary) causes of death: ICD10,35000,90000,Accruing,Categorical multiple,,Data,Primary,Unisex,2,15,19,,http://
+HES,2002,41270,Diagnoses - ICD10,440000,4000000,Accruing,Categorical multiple,,Data,Primary,Unisex,1,250,19,,http://
+Verbal interview,100074,20002,Non-cancer illness code,,,Complete,Categorical multiple,,Data,Primary,Unisex,4,34,6,,http://
+Verbal interview,100038,6150,"Vascular/heart problems diagnosed by doctor",500000,600000,Complete,Categorical multiple,,Data,Primary,Unisex,4,4,100605,,http://
+Blood biochemistry,17518,30780,LDL direct,470000,500000,Complete,Continuous,mmol/L,Data,Primary,Unisex,2,1,,,http://
+`
+
+const testCodings = `Coding,Value,Meaning
+19,Block I20-I25,I20-I25 Ischaemic heart diseases
+19,I21,I21 Acute myocardial infarction
+19,I210,I21.0 Acute transmural myocardial infarction of anterior wall
+19,I214,I21.4 Acute subendocardial myocardial infarction
+19,I25,I25 Chronic ischaemic heart disease
+6,1065,hypertension
+6,1066,heart/cardiac problem
+`
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Data_Dictionary_Showcase.csv": testDictionary,
+		"Codings.csv":                  testCodings,
+		"phenotype.tsv": "sample_id\tFieldID\tinstance\tarray_idx\tvalue\n" +
+			"1\t20002\t0\t0\t1065\n" +
+			"1\t41270\t0\t0\tI210\n",
+		"materialized_hesin_dates.tsv": "sample_id\tFieldID\tvalue\tfirst_date\n" +
+			"1\t41202\tI210\t2010-01-01\n" +
+			"2\t41202\tI214\t2011-01-01\n" +
+			"2\t41204\tI21\t2011-01-01\n",
+		"mi.tab": "Field\tCoding\texclude\n" +
+			"41202\tI21.0,I21*\t0\n" + // 2: fine, but not all ICD10 fields
+			"41204\tI21,I99\t0\n" + // 3: unknown code
+			"41270\tI21.0\t0\n" + // 4: dots are kept in non-HESIN fields
+			"# A comment\n" +
+			"20002\t1065,,1065\t0\n" + // 6: empty and duplicate values
+			"99999\t1\t0\n" + // 7: unknown field
+			"41202\tI214\t1\n" + // 8: overlaps line 2's I21*
+			"41202\tI30-I31\t1\n" + // 9: matches no codes
+			"6150\t1\t0\n" + // 10: coding is not in Codings.csv
+			"20002\t1066\t0\n" + // 11: never occurs
+			"30780\t1\tx\n" + // 12: exclude flag
+			"41202\tI21\t0\textra\n", // 13: too many columns
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dict, err := ukbb.ReadDictionary(filepath.Join(dir, "Data_Dictionary_Showcase.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := dict[30780]; f.ValueType != "Continuous" || f.Coding.Valid || !dict[6150].Coding.Valid {
+		t.Fatalf("Unexpected dictionary entries %+v and %+v", f, dict[6150])
+	}
+	codings, err := ukbb.ReadCodings(filepath.Join(dir, "Codings.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tabPath := filepath.Join(dir, "mi.tab")
+	linter := NewLinter(dict, codings)
+	fieldIDs, err := fieldIDsOf([]string{tabPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linter.Observed, err = (&LocalFiles{Dir: dir}).ObservedValues(fieldIDs); err != nil {
+		t.Fatal(err)
+	}
+
+	diags, err := linter.Lint(tabPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range diags {
+		got = append(got, fmt.Sprintf("%d %s %s %s", d.Line, d.Severity, d.Check, d.Value))
+	}
+	sort.Strings(got)
+
+	expected := []string{
+		"10 warning unknown-coding ",
+		"10 warning unobserved-field ",
+		"11 warning unobserved-code 1066",
+		"12 warning exclude-flag x",
+		"12 warning undated-field ",
+		"12 warning unobserved-field ",
+		"13 error parse ",
+		"2 warning missing-companion ",
+		"3 error unknown-code I99",
+		"4 error dotted-code I21.0",
+		"4 warning undated-field ",
+		"6 warning duplicate 1065",
+		"6 warning empty-value ",
+		"7 error unknown-field ",
+		"7 warning undated-field ",
+		"7 warning unobserved-field ",
+		"8 error overlap I214",
+		"8 warning missing-companion ",
+		"9 error bad-pattern I30-I31",
+	}
+	sort.Strings(expected)
+
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d diagnostics, got %d:\n%v", len(expected), len(got), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Diagnostic %d: expected %q, got %q", i, expected[i], got[i])
+		}
+	}
+}
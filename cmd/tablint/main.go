@@ -0,0 +1,175 @@
+// tablint checks tabfiles against the UK Biobank data dictionary and codings,
+// and optionally against the values that occur in the dataset, and prints
+// tab-delimited diagnostics with line numbers. It exits with status 1 if any
+// diagnostic is at least as severe as -fail-on, so that it can gate disease
+// definitions in code review.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	_ "github.com/carbocation/genomisc/compileinfoprint"
+	"github.com/carbocation/genomisc/ukbb"
+)
+
+var (
+	BufferSize = 4096 * 8
+	STDOUT     = bufio.NewWriterSize(os.Stdout, BufferSize)
+)
+
+func main() {
+	var BQ = &WrappedBigQuery{
+		Context: context.Background(),
+	}
+	var tabfile string
+	var dictPath string
+	var codingPath string
+	var localDir string
+	var failOn string
+
+	flag.StringVar(&tabfile, "tabfile", "", "Tabfile-formatted phenotype definition, or a directory whose .tab files will all be checked")
+	flag.StringVar(&codingPath, "coding", "https://biobank.ctsu.ox.ac.uk/~bbdatan/Codings.csv", "URL or path to comma-delimited file with the UKBB data encodings, specified at http://biobank.ctsu.ox.ac.uk/crystal/exinfo.cgi?src=AccessingData")
+	flag.StringVar(&dictPath, "dict", "https://biobank.ndph.ox.ac.uk/~bbdatan/Data_Dictionary_Showcase.csv", "URL or path to comma-delimited file with the UKBB data dictionary, specified at http://biobank.ctsu.ox.ac.uk/crystal/exinfo.cgi?src=AccessingData. Paths ending in .tsv or .txt (e.g., the output of convertdict) are tab-delimited.")
+	flag.StringVar(&BQ.Project, "project", "", "(Optional) Google Cloud project you want to use for billing purposes only. With -database, values are also checked against those that occur in the dataset.")
+	flag.StringVar(&BQ.Database, "database", "", "(Optional) BigQuery source database name (note: must be formatted as project.database, e.g., ukbb-analyses.ukbb7089_201904)")
+	flag.StringVar(&localDir, "local", "", "(Optional) Directory holding local copies of the phenotype, materialized_hesin_dates, and materialized_special_dates tables, as .parquet, .tsv, or .csv files named after the table. If set, values are also checked against those that occur in these tables.")
+	flag.StringVar(&failOn, "fail-on", SeverityError, fmt.Sprintf("Exit with status 1 if there are diagnostics of this severity or worse. Options include: %s, %s, none", SeverityError, SeverityWarning))
+	flag.Parse()
+
+	if tabfile == "" {
+		fmt.Fprintln(os.Stderr, "Please provide --tabfile")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if failOn != SeverityError && failOn != SeverityWarning && failOn != "none" {
+		fmt.Fprintf(os.Stderr, "Unknown --fail-on %q\n", failOn)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if (BQ.Project == "") != (BQ.Database == "") {
+		fmt.Fprintln(os.Stderr, "--project and --database must be given together")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if localDir != "" && BQ.Database != "" {
+		fmt.Fprintln(os.Stderr, "Please provide only one of --local and --database")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	files, err := tabfiles(tabfile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	dict, err := ukbb.ReadDictionary(dictPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	codings, err := ukbb.ReadCodings(codingPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	linter := NewLinter(dict, codings)
+
+	var src ValueSource
+	if localDir != "" {
+		src = &LocalFiles{Dir: localDir}
+	} else if BQ.Database != "" {
+		BQ.Client, err = bigquery.NewClient(BQ.Context, BQ.Project)
+		if err != nil {
+			log.Fatalf("Connecting to BigQuery: %v", err)
+		}
+		defer BQ.Client.Close()
+		src = BQ
+	}
+	if src != nil {
+		fieldIDs, err := fieldIDsOf(files)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if linter.Observed, err = src.ObservedValues(fieldIDs); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var diags []Diagnostic
+	for _, file := range files {
+		fileDiags, err := linter.Lint(file)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		diags = append(diags, fileDiags...)
+	}
+
+	if err := PrintDiagnostics(diags); err != nil {
+		log.Fatalln(err)
+	}
+
+	errs, warnings := 0, 0
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+	log.Printf("Checked %d tabfile(s): %d errors, %d warnings\n", len(files), errs, warnings)
+
+	if (failOn == SeverityError && errs > 0) || (failOn == SeverityWarning && errs+warnings > 0) {
+		os.Exit(1)
+	}
+}
+
+// tabfiles returns the tabfile itself, or every .tab file within a directory.
+func tabfiles(tabfile string) ([]string, error) {
+	info, err := os.Stat(tabfile)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{tabfile}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(tabfile, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".tab") {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// PrintDiagnostics writes the diagnostics as a tab-delimited table.
+func PrintDiagnostics(diags []Diagnostic) error {
+	fmt.Fprintln(STDOUT, strings.Join([]string{"file", "line", "severity", "check", "field_id", "value", "message"}, "\t"))
+	for _, d := range diags {
+		fmt.Fprintf(STDOUT, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", d.File, d.Line, d.Severity, d.Check, d.FieldID, sanitize(d.Value), sanitize(d.Message))
+	}
+
+	return STDOUT.Flush()
+}
+
+// sanitize keeps a value on one line and in one column.
+func sanitize(value string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(value)
+}
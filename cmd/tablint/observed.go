@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/carbocation/genomisc/ukbb/localtable"
+	"github.com/carbocation/pfx"
+	"google.golang.org/api/iterator"
+)
+
+type WrappedBigQuery struct {
+	Context  context.Context
+	Client   *bigquery.Client
+	Project  string
+	Database string
+}
+
+// ValueSource reports which values of each FieldID occur in the dataset.
+type ValueSource interface {
+	ObservedValues(fieldIDs []int) (map[int]map[string]struct{}, error)
+}
+
+// observedTables hold the values that ukbb2disease matches against. Values in
+// the materialized tables are formatted like TabEntry.FormattedValues.
+var observedTables = []string{"phenotype", "materialized_hesin_dates", "materialized_special_dates"}
+
+// ObservedValues fetches the distinct values of the FieldIDs from BigQuery.
+func (BQ *WrappedBigQuery) ObservedValues(fieldIDs []int) (map[int]map[string]struct{}, error) {
+	query := ""
+	for i, table := range observedTables {
+		if i > 0 {
+			query += "\nUNION DISTINCT\n"
+		}
+		query += fmt.Sprintf("SELECT DISTINCT t.FieldID, t.value FROM `%s.%s` t WHERE t.FieldID IN UNNEST(@FieldIDs)", BQ.Database, table)
+	}
+
+	bqQuery := BQ.Client.Query(query)
+	bqQuery.QueryConfig.Parameters = append(bqQuery.QueryConfig.Parameters, bigquery.QueryParameter{Name: "FieldIDs", Value: fieldIDs})
+
+	itr, err := bqQuery.Read(BQ.Context)
+	if err != nil {
+		return nil, pfx.Err(err)
+	}
+
+	out := make(map[int]map[string]struct{})
+	for {
+		var r struct {
+			FieldID int64               `bigquery:"FieldID"`
+			Value   bigquery.NullString `bigquery:"value"`
+		}
+		err := itr.Next(&r)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, pfx.Err(err)
+		}
+
+		addObserved(out, int(r.FieldID), r.Value.StringVal)
+	}
+
+	return out, nil
+}
+
+// LocalFiles reads the values from local copies of the tables, as .parquet,
+// .tsv, or .csv files named after the table. Tables that are not present are
+// skipped.
+type LocalFiles struct {
+	Dir string
+}
+
+func (l *LocalFiles) ObservedValues(fieldIDs []int) (map[int]map[string]struct{}, error) {
+	wanted := make(map[int64]struct{})
+	for _, v := range fieldIDs {
+		wanted[int64(v)] = struct{}{}
+	}
+	keep := func(fieldID int64) bool {
+		_, exists := wanted[fieldID]
+		return exists
+	}
+
+	out := make(map[int]map[string]struct{})
+	found := 0
+	for _, table := range observedTables {
+		path, err := localtable.Find(l.Dir, table)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		found++
+
+		if table == "phenotype" {
+			err = localtable.ReadPhenotype(path, keep, func(p localtable.Phenotype) error {
+				addObserved(out, int(p.FieldID), p.Value)
+				return nil
+			})
+		} else {
+			err = localtable.ReadDatedFields(path, func(fieldID int64, value string) bool {
+				return keep(fieldID)
+			}, func(d localtable.DatedField) error {
+				addObserved(out, int(d.FieldID), d.Value)
+				return nil
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if found == 0 {
+		return nil, fmt.Errorf("None of the tables %v were found in %s", observedTables, l.Dir)
+	}
+
+	return out, nil
+}
+
+func addObserved(out map[int]map[string]struct{}, fieldID int, value string) {
+	if value == "" {
+		return
+	}
+	if out[fieldID] == nil {
+		out[fieldID] = make(map[string]struct{})
+	}
+	out[fieldID][value] = struct{}{}
+}
+
+// fieldIDsOf lists the distinct FieldIDs of the tabfiles.
+func fieldIDsOf(paths []string) ([]int, error) {
+	seen := make(map[int]struct{})
+	var out []int
+	for _, path := range paths {
+		lines, _, err := readTabLines(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			if _, exists := seen[line.FieldID]; !exists {
+				seen[line.FieldID] = struct{}{}
+				out = append(out, line.FieldID)
+			}
+		}
+	}
+
+	return out, nil
+}
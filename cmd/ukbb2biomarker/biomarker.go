@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+)
+
+// Output modes.
+const (
+	OutputSummary = "summary"
+	OutputLong    = "long"
+)
+
+// SourceGP is the source of measurements from primary care. Measurements from
+// the assessment visits are sourced by their instance.
+const SourceGP = "gp"
+
+// Biomarker is a quantitative FieldID (e.g., 30780 for LDL cholesterol) along
+// with the primary care codes that record the same quantity, and the codes of
+// FieldID 20003 for medications that alter it.
+type Biomarker struct {
+	Name        string
+	FieldID     int64
+	Read2       []string
+	CTV3        []string
+	AdjustCodes []string
+}
+
+// Harmonization controls how raw values become analysis-ready values.
+type Harmonization struct {
+	// Multipliers that convert primary care values recorded in each unit (see
+	// normalizeUnit) into the units of the FieldID. If nil, primary care
+	// values are used as recorded.
+	Units map[string]float64
+
+	// Values outside of [Min, Max], after conversion, are dropped as
+	// implausible.
+	Min, Max float64
+
+	// Values measured while on an adjusting medication are divided by
+	// AdjustDivisor (e.g., 0.7 for LDL cholesterol on statins).
+	AdjustDivisor float64
+}
+
+// ReadUnits parses a tab-delimited file with a header and the columns unit and
+// multiplier.
+func ReadUnits(path string) (map[string]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fileCSV := csv.NewReader(f)
+	fileCSV.Comma = '\t'
+	fileCSV.Comment = '#'
+
+	recs, err := fileCSV.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]float64)
+	for i, row := range recs {
+		if l := len(row); l != 2 {
+			return nil, fmt.Errorf("Units file %s row %d had %d columns, expected 2", path, i, l)
+		}
+
+		if i == 0 {
+			if row[0] != "unit" || row[1] != "multiplier" {
+				return nil, fmt.Errorf("Units file %s must have the header unit, multiplier", path)
+			}
+			continue
+		}
+
+		multiplier, err := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Units file %s row %d: %v", path, i, err)
+		}
+		out[normalizeUnit(row[0])] = multiplier
+	}
+
+	return out, nil
+}
+
+// normalizeUnit makes units case and whitespace insensitive.
+func normalizeUnit(unit string) string {
+	return strings.Join(strings.Fields(strings.ToLower(unit)), "")
+}
+
+// Measurement is one dated value of a biomarker. Repeated values at one visit
+// (e.g., array indices) or on one day in primary care are averaged.
+type Measurement struct {
+	Source     string
+	Date       civil.Date
+	Unadjusted float64
+	Value      float64
+
+	// NULL if unknown, or if no adjustment was requested
+	OnMedication bigquery.NullInt64
+}
+
+// participant gathers the rows of one participant.
+type participant struct {
+	Row         biomarkerRow
+	VisitDates  map[int64]bigquery.NullDate
+	Medicated   map[int64]bool
+	VisitValues map[int64][]float64
+	GPValues    map[civil.Date][]float64
+}
+
+// dropCounts tallies the values that were not used, by reason.
+type dropCounts struct {
+	Unparseable, Unit, Range, Undated int
+}
+
+// ExecuteBiomarker assembles the measurements of every participant in the
+// censor table and prints either their summary or each measurement.
+func ExecuteBiomarker(src DataSource, b Biomarker, h Harmonization, output string) error {
+	participants := make(map[int64]*participant)
+	get := func(sampleID int64) *participant {
+		p := participants[sampleID]
+		if p == nil {
+			p = &participant{
+				Row:         biomarkerRow{SampleID: sampleID},
+				VisitDates:  make(map[int64]bigquery.NullDate),
+				Medicated:   make(map[int64]bool),
+				VisitValues: make(map[int64][]float64),
+				GPValues:    make(map[civil.Date][]float64),
+			}
+			participants[sampleID] = p
+		}
+		return p
+	}
+
+	var inCensor []int64
+	var dropped dropCounts
+	err := src.BiomarkerRows(b, func(r biomarkerRow) error {
+		switch r.Kind {
+		case rowParticipant:
+			get(r.SampleID).Row = r
+			inCensor = append(inCensor, r.SampleID)
+		case rowVisit:
+			get(r.SampleID).VisitDates[r.Instance.Int64] = r.Date
+		case rowMedication:
+			get(r.SampleID).Medicated[r.Instance.Int64] = true
+		case rowMeasurement:
+			value, err := strconv.ParseFloat(strings.TrimSpace(r.Value1.StringVal), 64)
+			if err != nil {
+				dropped.Unparseable++
+				return nil
+			}
+			if value < h.Min || value > h.Max {
+				dropped.Range++
+				return nil
+			}
+			p := get(r.SampleID)
+			p.VisitValues[r.Instance.Int64] = append(p.VisitValues[r.Instance.Int64], value)
+		case rowGP:
+			if !validEventDate(r.Date) {
+				dropped.Undated++
+				return nil
+			}
+			value, ok := h.gpValue(r, &dropped)
+			if !ok {
+				return nil
+			}
+			p := get(r.SampleID)
+			p.GPValues[r.Date.Date] = append(p.GPValues[r.Date.Date], value)
+		default:
+			return fmt.Errorf("Unexpected row kind %q", r.Kind)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(inCensor, func(i, j int) bool { return inCensor[i] < inCensor[j] })
+	log.Printf("Assembling %s for %d participants\n", b.Name, len(inCensor))
+
+	todayDate := time.Now().Format("2006-01-02")
+	if output == OutputLong {
+		fmt.Fprintln(STDOUT, strings.Join([]string{"trait", "sample_id", "source", "date", "age_days", "unadjusted_value", "value", "on_medication"}, "\t"))
+	} else {
+		fmt.Fprintln(STDOUT, strings.Join([]string{"trait", "sample_id", "n_measurements", "n_visit_measurements", "n_gp_measurements", "baseline", "baseline_unadjusted", "baseline_on_medication", "baseline_date", "baseline_age_days", "mean", "latest", "latest_date", "latest_age_days", "latest_source", "first_date", "slope_per_year", "span_years", "birthdate", "enroll_date", "computed_date"}, "\t"))
+	}
+
+	for _, sampleID := range inCensor {
+		p := participants[sampleID]
+		measurements := p.Measurements(h, &dropped)
+
+		if output == OutputLong {
+			for _, m := range measurements {
+				date := bigquery.NullDate{Date: m.Date, Valid: true}
+				fmt.Fprintf(STDOUT, "%s\t%d\t%s\t%s\t%s\t%v\t%v\t%s\n",
+					b.Name, sampleID, m.Source, date, NA(dateDiff(date, p.Row.BirthDate)), m.Unadjusted, m.Value, NA(m.OnMedication))
+			}
+			continue
+		}
+
+		s := summarize(measurements)
+		fmt.Fprintf(STDOUT, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			b.Name, sampleID, s.N, s.NVisit, s.NGP, NA(s.Baseline), NA(s.BaselineUnadjusted), NA(s.BaselineOnMedication), NA(s.BaselineDate), NA(dateDiff(s.BaselineDate, p.Row.BirthDate)),
+			NA(s.Mean), NA(s.Latest), NA(s.LatestDate), NA(dateDiff(s.LatestDate, p.Row.BirthDate)), s.LatestSource, NA(s.FirstDate), NA(s.SlopePerYear), NA(s.SpanYears),
+			NA(p.Row.BirthDate), NA(p.Row.EnrollDate), todayDate)
+	}
+
+	log.Printf("Dropped %d unparseable values, %d primary care values with unknown units, %d values outside of the plausible range, and %d undated values\n", dropped.Unparseable, dropped.Unit, dropped.Range, dropped.Undated)
+
+	return nil
+}
+
+// gpValue takes the first numeric value of a primary care record, and reads
+// its unit from value3, where the UK Biobank usually records it.
+func (h Harmonization) gpValue(r biomarkerRow, dropped *dropCounts) (float64, bool) {
+	var value float64
+	found := false
+	for _, v := range []bigquery.NullString{r.Value1, r.Value2, r.Value3} {
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v.StringVal), 64)
+		if v.Valid && err == nil {
+			value, found = parsed, true
+			break
+		}
+	}
+	if !found {
+		dropped.Unparseable++
+		return 0, false
+	}
+
+	unit := ""
+	if _, err := strconv.ParseFloat(strings.TrimSpace(r.Value3.StringVal), 64); r.Value3.Valid && err != nil {
+		unit = normalizeUnit(r.Value3.StringVal)
+	}
+	if h.Units != nil && unit != "" {
+		multiplier, known := h.Units[unit]
+		if !known {
+			dropped.Unit++
+			return 0, false
+		}
+		value *= multiplier
+	}
+
+	if value < h.Min || value > h.Max {
+		dropped.Range++
+		return 0, false
+	}
+
+	return value, true
+}
+
+// validEventDate rejects the placeholder dates that the UK Biobank uses in
+// the primary care data (1901-01-01, 1902-02-02, and 1903-03-03 for dates
+// before, on, and after birth, and 2037-07-07 for dates in the future).
+func validEventDate(date bigquery.NullDate) bool {
+	return date.Valid && date.Date.Year > 1903 && date.Date.Year < 2037
+}
+
+// Measurements returns the participant's measurements in date order. Values
+// from an assessment visit are adjusted if an adjusting medication was
+// reported at that visit; primary care values are adjusted according to the
+// report at the most recent visit on or before their date, and are left
+// unadjusted (with an unknown medication status) if there is none.
+func (p *participant) Measurements(h Harmonization, dropped *dropCounts) []Measurement {
+	adjusting := h.AdjustDivisor > 0
+	adjust := func(m *Measurement, medicated bigquery.NullInt64) {
+		m.Value = m.Unadjusted
+		if !adjusting {
+			return
+		}
+		m.OnMedication = medicated
+		if medicated.Valid && medicated.Int64 == 1 {
+			m.Value = m.Unadjusted / h.AdjustDivisor
+		}
+	}
+
+	var out []Measurement
+	for instance, values := range p.VisitValues {
+		date := p.VisitDates[instance]
+		if !date.Valid {
+			dropped.Undated += len(values)
+			continue
+		}
+
+		m := Measurement{Source: strconv.FormatInt(instance, 10), Date: date.Date, Unadjusted: mean(values)}
+		adjust(&m, nullInt(boolInt(p.Medicated[instance])))
+		out = append(out, m)
+	}
+
+	for date, values := range p.GPValues {
+		m := Measurement{Source: SourceGP, Date: date, Unadjusted: mean(values)}
+
+		var medicated bigquery.NullInt64
+		var visitDate civil.Date
+		for instance, d := range p.VisitDates {
+			if d.Valid && !d.Date.After(date) && (!medicated.Valid || d.Date.After(visitDate)) {
+				visitDate = d.Date
+				medicated = nullInt(boolInt(p.Medicated[instance]))
+			}
+		}
+		adjust(&m, medicated)
+		out = append(out, m)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Date != out[j].Date {
+			return out[i].Date.Before(out[j].Date)
+		}
+		return out[i].Source < out[j].Source
+	})
+
+	return out
+}
+
+// biomarkerSummary is one participant's summary of a biomarker.
+type biomarkerSummary struct {
+	N, NVisit, NGP       int
+	Baseline             bigquery.NullFloat64
+	BaselineUnadjusted   bigquery.NullFloat64
+	BaselineOnMedication bigquery.NullInt64
+	BaselineDate         bigquery.NullDate
+	Mean                 bigquery.NullFloat64
+	Latest               bigquery.NullFloat64
+	LatestDate           bigquery.NullDate
+	LatestSource         string
+	FirstDate            bigquery.NullDate
+	SlopePerYear         bigquery.NullFloat64
+	SpanYears            bigquery.NullFloat64
+}
+
+// summarize computes the baseline (the value at the enrollment visit, instance
+// 0), the mean of all measurements, the latest measurement, and the ordinary
+// least squares slope of the measurements over time, in units per year. The
+// slope needs measurements on at least two dates. Measurements must be in date
+// order.
+func summarize(measurements []Measurement) biomarkerSummary {
+	var out biomarkerSummary
+	out.N = len(measurements)
+	if out.N == 0 {
+		return out
+	}
+
+	var sum float64
+	for _, m := range measurements {
+		sum += m.Value
+		if m.Source == SourceGP {
+			out.NGP++
+			continue
+		}
+		out.NVisit++
+		if m.Source == "0" {
+			out.Baseline = nullFloat(m.Value)
+			out.BaselineUnadjusted = nullFloat(m.Unadjusted)
+			out.BaselineOnMedication = m.OnMedication
+			out.BaselineDate = bigquery.NullDate{Date: m.Date, Valid: true}
+		}
+	}
+	out.Mean = nullFloat(sum / float64(out.N))
+
+	first, latest := measurements[0], measurements[out.N-1]
+	out.FirstDate = bigquery.NullDate{Date: first.Date, Valid: true}
+	out.Latest = nullFloat(latest.Value)
+	out.LatestDate = bigquery.NullDate{Date: latest.Date, Valid: true}
+	out.LatestSource = latest.Source
+	out.SpanYears = nullFloat(years(latest.Date, first.Date))
+
+	// Time is in years since the first measurement
+	var meanX float64
+	for _, m := range measurements {
+		meanX += years(m.Date, first.Date)
+	}
+	meanX /= float64(out.N)
+	meanY := out.Mean.Float64
+
+	var sxx, sxy float64
+	for _, m := range measurements {
+		dx := years(m.Date, first.Date) - meanX
+		sxx += dx * dx
+		sxy += dx * (m.Value - meanY)
+	}
+	if sxx > 0 {
+		out.SlopePerYear = nullFloat(sxy / sxx)
+	}
+
+	return out
+}
+
+func years(a, b civil.Date) float64 {
+	return float64(a.DaysSince(b)) / 365.25
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	return sum / float64(len(values))
+}
+
+func dateDiff(a, b bigquery.NullDate) bigquery.NullInt64 {
+	if !a.Valid || !b.Valid {
+		return bigquery.NullInt64{}
+	}
+
+	return nullInt(int64(a.Date.DaysSince(b.Date)))
+}
+
+func nullFloat(v float64) bigquery.NullFloat64 {
+	return bigquery.NullFloat64{Float64: v, Valid: true}
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// NA emits an empty string instead of "NULL" since this plays better with
+// BigQuery
+func NA(input interface{}) interface{} {
+	invalid := ""
+
+	switch v := input.(type) {
+	case bigquery.NullInt64:
+		if !v.Valid {
+			return invalid
+		}
+	case bigquery.NullFloat64:
+		if !v.Valid {
+			return invalid
+		}
+	case bigquery.NullString:
+		if !v.Valid {
+			return invalid
+		}
+	case bigquery.NullDate:
+		if !v.Valid {
+			return invalid
+		}
+	}
+
+	return input
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writeLocalTables(t *testing.T, tables map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, contents := range tables {
+		if err := os.WriteFile(filepath.Join(dir, name+".tsv"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// runLocal executes the biomarker against local tables and returns each output
+// row as a map from column name to value.
+func runLocal(t *testing.T, dir string, b Biomarker, h Harmonization, output string) []map[string]string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	defer func(original *bufio.Writer) { STDOUT = original }(STDOUT)
+	STDOUT = bufio.NewWriter(&buf)
+
+	if err := ExecuteBiomarker(&LocalFiles{Dir: dir}, b, h, output); err != nil {
+		t.Fatal(err)
+	}
+	STDOUT.Flush()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	header := strings.Split(lines[0], "\t")
+	var out []map[string]string
+	for _, line := range lines[1:] {
+		row := make(map[string]string)
+		for i, v := range strings.Split(line, "\t") {
+			row[header[i]] = v
+		}
+		out = append(out, row)
+	}
+
+	return out
+}
+
+func TestLocalBiomarker(t *testing.T) {
+	dir := writeLocalTables(t, map[string]string{
+		"censor": "sample_id\tbirthdate\tenroll_date\n" +
+			"1\t1950-01-01\t2008-01-01\n" +
+			"2\t1950-01-01\t2008-01-01\n" +
+			"3\t1950-01-01\t2008-01-01\n",
+		"phenotype": "sample_id\tFieldID\tinstance\tarray_idx\tvalue\n" +
+			// Participant 1 started a statin between the visits
+			"1\t53\t0\t0\t2008-01-01\n" +
+			"1\t53\t2\t0\t2014-01-01\n" +
+			"1\t30780\t0\t0\t4\n" +
+			"1\t30780\t2\t0\t2.1\n" +
+			"1\t20003\t2\t0\t1140861958\n" +
+			"1\t20003\t2\t1\t1140884600\n" +
+			// Participant 2's only visit value is implausible
+			"2\t53\t0\t0\t2008-01-01\n" +
+			"2\t30780\t0\t0\t50\n",
+		"gp_clinical": "eid\tdata_provider\tevent_dt\tread_2\tread_3\tvalue1\tvalue2\tvalue3\n" +
+			"1\t1\t01/01/2010\t44P6.\t\t3.5\t\tMMOL/L\n" +
+			"1\t3\t01/01/2016\t\tXaEVs\t2.8\t\tmmol/L\n" +
+			"1\t1\t07/07/2037\t44P6.\t\t9\t\t\n" +
+			// Participant 2 had two values on one day, in different units
+			"2\t1\t01/06/2009\t44P6.\t\t150\t\tmg/dL\n" +
+			"2\t1\t01/06/2009\t44P6.\t\t3.9\t\t\n" +
+			"2\t1\t01/06/2010\t44P6.\t\t1\t\tfurlongs\n" +
+			"2\t1\t01/06/2011\t44P5.\t\t1\t\t\n",
+	})
+
+	b := Biomarker{
+		Name:        "ldl",
+		FieldID:     30780,
+		Read2:       []string{"44P6."},
+		CTV3:        []string{"XaEVs"},
+		AdjustCodes: []string{"1140861958"},
+	}
+	h := Harmonization{
+		Units:         map[string]float64{"mmol/l": 1, "mg/dl": 0.02586},
+		Min:           0,
+		Max:           20,
+		AdjustDivisor: 0.7,
+	}
+
+	long := runLocal(t, dir, b, h, OutputLong)
+	expectedLong := []struct {
+		sampleID, source, date, onMedication string
+		value                                float64
+	}{
+		{"1", "0", "2008-01-01", "0", 4},
+		{"1", "gp", "2010-01-01", "0", 3.5},
+		{"1", "2", "2014-01-01", "1", 3},
+		{"1", "gp", "2016-01-01", "1", 4},
+		{"2", "gp", "2009-06-01", "0", 3.8895},
+	}
+	if len(long) != len(expectedLong) {
+		t.Fatalf("Expected %d measurements, got %d: %v", len(expectedLong), len(long), long)
+	}
+	for i, e := range expectedLong {
+		r := long[i]
+		if r["sample_id"] != e.sampleID || r["source"] != e.source || r["date"] != e.date || r["on_medication"] != e.onMedication {
+			t.Errorf("Measurement %d: expected %+v, got %v", i, e, r)
+		}
+		assertFloat(t, r, "value", e.value)
+	}
+
+	summary := runLocal(t, dir, b, h, OutputSummary)
+	if len(summary) != 3 {
+		t.Fatalf("Expected 3 participants, got %d", len(summary))
+	}
+
+	p1 := summary[0]
+	if p1["n_measurements"] != "4" || p1["n_visit_measurements"] != "2" || p1["n_gp_measurements"] != "2" {
+		t.Errorf("Participant 1: unexpected counts %v", p1)
+	}
+	if p1["baseline_date"] != "2008-01-01" || p1["baseline_age_days"] != "21184" || p1["baseline_on_medication"] != "0" {
+		t.Errorf("Participant 1: unexpected baseline %v", p1)
+	}
+	if p1["latest_date"] != "2016-01-01" || p1["latest_source"] != "gp" || p1["first_date"] != "2008-01-01" {
+		t.Errorf("Participant 1: unexpected latest %v", p1)
+	}
+	assertFloat(t, p1, "baseline", 4)
+	assertFloat(t, p1, "mean", 3.625)
+	assertFloat(t, p1, "latest", 4)
+	assertFloat(t, p1, "slope_per_year", -0.02502566617870397)
+	assertFloat(t, p1, "span_years", 8)
+
+	p2 := summary[1]
+	if p2["n_measurements"] != "1" || p2["baseline"] != "" || p2["slope_per_year"] != "" {
+		t.Errorf("Participant 2: unexpected summary %v", p2)
+	}
+	assertFloat(t, p2, "mean", 3.8895)
+
+	p3 := summary[2]
+	if p3["n_measurements"] != "0" || p3["mean"] != "" || p3["latest_source"] != "" || p3["enroll_date"] != "2008-01-01" {
+		t.Errorf("Participant 3: unexpected summary %v", p3)
+	}
+}
+
+func assertFloat(t *testing.T, row map[string]string, column string, expected float64) {
+	t.Helper()
+
+	got, err := strconv.ParseFloat(row[column], 64)
+	if err != nil || math.Abs(got-expected) > 1e-9 {
+		t.Errorf("Sample %s: expected %s of %v, got %q", row["sample_id"], column, expected, row[column])
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/carbocation/pfx"
+	"google.golang.org/api/iterator"
+)
+
+type WrappedBigQuery struct {
+	Context  context.Context
+	Client   *bigquery.Client
+	Project  string
+	Database string
+}
+
+// Kinds of biomarkerRow.
+const (
+	rowParticipant = "participant"
+	rowVisit       = "visit"
+	rowMeasurement = "measurement"
+	rowMedication  = "medication"
+	rowGP          = "gp"
+)
+
+// biomarkerRow is one row of query_template_ukbb.sql. Participant rows come
+// from the censor table; visit rows date each assessment instance (FieldID
+// 53); measurement rows hold one value of the biomarker's FieldID, in Value1;
+// medication rows mark instances at which a medication that triggers the
+// adjustment was reported; and gp rows are dated primary care records with
+// one of the biomarker's Read v2 or CTV3 codes, along with their three values.
+type biomarkerRow struct {
+	Kind       string              `bigquery:"kind"`
+	SampleID   int64               `bigquery:"sample_id"`
+	Instance   bigquery.NullInt64  `bigquery:"instance"`
+	Date       bigquery.NullDate   `bigquery:"date"`
+	Value1     bigquery.NullString `bigquery:"value1"`
+	Value2     bigquery.NullString `bigquery:"value2"`
+	Value3     bigquery.NullString `bigquery:"value3"`
+	BirthDate  bigquery.NullDate   `bigquery:"birthdate"`
+	EnrollDate bigquery.NullDate   `bigquery:"enroll_date"`
+}
+
+// DataSource produces the rows from which a biomarker's measurements are
+// assembled, in any order.
+type DataSource interface {
+	BiomarkerRows(b Biomarker, fn func(biomarkerRow) error) error
+}
+
+// BuildQuery assembles the query that fetches the biomarker.
+func BuildQuery(BQ *WrappedBigQuery, b Biomarker, displayQuery bool) (*bigquery.Query, error) {
+	queryParts := map[string]interface{}{
+		"database": BQ.Database,
+		"adjust":   len(b.AdjustCodes) > 0,
+		"gp":       len(b.Read2)+len(b.CTV3) > 0,
+	}
+
+	params := []bigquery.QueryParameter{
+		{Name: "FieldID", Value: b.FieldID},
+	}
+	if len(b.AdjustCodes) > 0 {
+		params = append(params, bigquery.QueryParameter{Name: "AdjustCodes", Value: b.AdjustCodes})
+	}
+	if len(b.Read2)+len(b.CTV3) > 0 {
+		params = append(params,
+			bigquery.QueryParameter{Name: "Read2", Value: append([]string{}, b.Read2...)},
+			bigquery.QueryParameter{Name: "CTV3", Value: append([]string{}, b.CTV3...)},
+		)
+	}
+
+	populatedQuery, err := executeQueryTemplate("query_template_ukbb.sql", queryParts)
+	if err != nil {
+		return nil, err
+	}
+
+	if displayQuery {
+		fmt.Println(populatedQuery)
+		fmt.Println("Query parameters:")
+		for _, v := range params {
+			fmt.Printf("%s: %v\n", v.Name, v.Value)
+		}
+		return nil, nil
+	}
+
+	bqQuery := BQ.Client.Query(populatedQuery)
+	bqQuery.QueryConfig.Parameters = append(bqQuery.QueryConfig.Parameters, params...)
+
+	return bqQuery, nil
+}
+
+// BiomarkerRows runs the biomarker query on BigQuery.
+func (BQ *WrappedBigQuery) BiomarkerRows(b Biomarker, fn func(biomarkerRow) error) error {
+	query, err := BuildQuery(BQ, b, false)
+	if err != nil {
+		return err
+	}
+
+	itr, err := query.Read(BQ.Context)
+	if err != nil {
+		return pfx.Err(err)
+	}
+
+	for {
+		var r biomarkerRow
+		err := itr.Next(&r)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return pfx.Err(err)
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/carbocation/genomisc/ukbb/localtable"
+)
+
+// LocalFiles fetches biomarkers from local copies of the tables that
+// query_template_ukbb.sql reads from BigQuery. Dir must hold censor, phenotype
+// (the output of convertpheno), and, if the biomarker has Read v2 or CTV3
+// codes, gp_clinical, each as a .parquet, .tsv, or .csv file (optionally
+// compressed) named after its table.
+type LocalFiles struct {
+	Dir string
+}
+
+// FieldIDVisitDate is the date of attending each assessment visit.
+const FieldIDVisitDate = 53
+
+// FieldIDSelfReportedMedication is the UK Biobank's "Treatment/medication
+// code", collected at the verbal interview of each assessment visit.
+const FieldIDSelfReportedMedication = 20003
+
+func (l *LocalFiles) BiomarkerRows(b Biomarker, fn func(biomarkerRow) error) error {
+	path, err := localtable.Find(l.Dir, "censor")
+	if err != nil {
+		return err
+	}
+	censor, err := localtable.ReadCensor(path)
+	if err != nil {
+		return err
+	}
+	for _, c := range censor {
+		if err := fn(biomarkerRow{Kind: rowParticipant, SampleID: c.SampleID, BirthDate: c.BirthDate, EnrollDate: c.EnrollDate}); err != nil {
+			return err
+		}
+	}
+
+	if err := l.readPhenotype(b, fn); err != nil {
+		return err
+	}
+
+	if len(b.Read2)+len(b.CTV3) == 0 {
+		return nil
+	}
+
+	path, err = localtable.Find(l.Dir, "gp_clinical")
+	if err != nil {
+		return err
+	}
+
+	return readGPClinical(path, b, fn)
+}
+
+// readPhenotype mirrors the visit, measurement, and medication rows of the
+// query.
+func (l *LocalFiles) readPhenotype(b Biomarker, fn func(biomarkerRow) error) error {
+	path, err := localtable.Find(l.Dir, "phenotype")
+	if err != nil {
+		return err
+	}
+
+	adjustCodes := make(map[string]struct{})
+	for _, v := range b.AdjustCodes {
+		adjustCodes[v] = struct{}{}
+	}
+
+	type visit struct {
+		SampleID int64
+		Instance int64
+	}
+	visitDates := make(map[visit]bigquery.NullDate)
+	var visits []visit
+	medications := make(map[visit]struct{})
+
+	err = localtable.ReadPhenotype(path, func(fieldID int64) bool {
+		return fieldID == FieldIDVisitDate || fieldID == b.FieldID || (len(adjustCodes) > 0 && fieldID == FieldIDSelfReportedMedication)
+	}, func(p localtable.Phenotype) error {
+		v := visit{p.SampleID, p.Instance}
+
+		if p.FieldID == b.FieldID {
+			if err := fn(biomarkerRow{Kind: rowMeasurement, SampleID: p.SampleID, Instance: nullInt(p.Instance), Value1: nullString(p.Value)}); err != nil {
+				return err
+			}
+		}
+
+		switch p.FieldID {
+		case FieldIDVisitDate:
+			if p.ArrayIdx != 0 {
+				return nil
+			}
+			date := localtable.SafeParseDate(p.Value)
+			prior, exists := visitDates[v]
+			if !exists {
+				visits = append(visits, v)
+				visitDates[v] = date
+			} else if date.Valid && (!prior.Valid || date.Date.Before(prior.Date)) {
+				visitDates[v] = date
+			}
+		case FieldIDSelfReportedMedication:
+			if _, adjust := adjustCodes[p.Value]; !adjust {
+				return nil
+			}
+			if _, exists := medications[v]; !exists {
+				medications[v] = struct{}{}
+				if err := fn(biomarkerRow{Kind: rowMedication, SampleID: p.SampleID, Instance: nullInt(p.Instance)}); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, v := range visits {
+		if err := fn(biomarkerRow{Kind: rowVisit, SampleID: v.SampleID, Instance: nullInt(v.Instance), Date: visitDates[v]}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readGPClinical reads a local copy of gp_clinical, whose participant column
+// may be named eid (as distributed) or sample_id.
+func readGPClinical(path string, b Biomarker, fn func(biomarkerRow) error) error {
+	t, err := localtable.Open(path)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	sampleCol := localtable.Column(t, "eid")
+	if sampleCol < 0 {
+		sampleCol = localtable.Column(t, "sample_id")
+	}
+	cols, err := localtable.Columns(t, "event_dt", "read_2", "read_3", "value1", "value2", "value3")
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if sampleCol < 0 {
+		return fmt.Errorf("%s: Column eid (or sample_id) not found", path)
+	}
+
+	codes := map[string]map[string]struct{}{
+		"read_2": make(map[string]struct{}),
+		"read_3": make(map[string]struct{}),
+	}
+	for _, v := range b.Read2 {
+		codes["read_2"][v] = struct{}{}
+	}
+	for _, v := range b.CTV3 {
+		codes["read_3"][v] = struct{}{}
+	}
+
+	for line := 2; ; line++ {
+		row, err := t.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		_, isRead2 := codes["read_2"][row[cols[1]]]
+		_, isCTV3 := codes["read_3"][row[cols[2]]]
+		if !isRead2 && !isCTV3 {
+			continue
+		}
+
+		r := biomarkerRow{
+			Kind:   rowGP,
+			Date:   parseEventDate(row[cols[0]]),
+			Value1: nullString(row[cols[3]]),
+			Value2: nullString(row[cols[4]]),
+			Value3: nullString(row[cols[5]]),
+		}
+		if r.SampleID, err = strconv.ParseInt(row[sampleCol], 10, 64); err != nil {
+			return fmt.Errorf("%s line %d: sample_id: %v", path, line, err)
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseEventDate accepts the dd/mm/yyyy dates of the distributed table as well
+// as ISO dates. Unparseable dates are NULL.
+func parseEventDate(value string) bigquery.NullDate {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2/1/2006", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return bigquery.NullDate{Date: civil.DateOf(t), Valid: true}
+		}
+	}
+
+	return bigquery.NullDate{}
+}
+
+func nullInt(v int64) bigquery.NullInt64 {
+	return bigquery.NullInt64{Int64: v, Valid: true}
+}
+
+// nullString treats empty strings as NULL, as localtable does.
+func nullString(v string) bigquery.NullString {
+	return bigquery.NullString{StringVal: v, Valid: v != ""}
+}
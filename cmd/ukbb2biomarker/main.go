@@ -0,0 +1,145 @@
+// ukbb2biomarker turns a quantitative UK Biobank field (e.g., LDL cholesterol,
+// FieldID 30780) into an analysis-ready trait, combining the measurements from
+// every assessment visit with (optionally) those from primary care.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	_ "github.com/carbocation/genomisc/compileinfoprint"
+)
+
+var (
+	BufferSize = 4096 * 8
+	STDOUT     = bufio.NewWriterSize(os.Stdout, BufferSize)
+)
+
+func main() {
+	defer STDOUT.Flush()
+
+	var BQ = &WrappedBigQuery{
+		Context: context.Background(),
+	}
+	var b Biomarker
+	var h Harmonization
+	var displayQuery bool
+	var localDir string
+	var read2, ctv3, adjustCodes string
+	var unitsPath string
+	var output string
+
+	flag.StringVar(&BQ.Project, "project", "", "Google Cloud project you want to use for billing purposes only")
+	flag.StringVar(&BQ.Database, "database", "", "BigQuery source database name (note: must be formatted as project.database, e.g., ukbb-analyses.ukbb7089_201904)")
+	flag.BoolVar(&displayQuery, "display-query", false, "Display the constructed query and exit?")
+	flag.StringVar(&localDir, "local", "", "(Optional) Directory holding local copies of the censor, phenotype, and (with -read2 or -ctv3) gp_clinical tables, as .parquet, .tsv, or .csv files named after the table. If set, -project and -database are not needed.")
+	flag.Int64Var(&b.FieldID, "field", 0, "FieldID of the quantitative trait, e.g., 30780 for LDL cholesterol")
+	flag.StringVar(&b.Name, "name", "", "(Optional) Name of the trait in the output. Defaults to the FieldID.")
+	flag.StringVar(&read2, "read2", "", "(Optional) Comma-separated Read v2 codes (e.g., 44P6.) of gp_clinical records that measure the same quantity. Codes match exactly, including their trailing dots.")
+	flag.StringVar(&ctv3, "ctv3", "", "(Optional) Comma-separated CTV3 (Read v3) codes of gp_clinical records that measure the same quantity.")
+	flag.StringVar(&unitsPath, "units", "", "(Optional) Tab-delimited file with the header unit, multiplier. Each primary care value recorded in a listed unit is multiplied into the units of the FieldID. If set, primary care values with an unlisted unit are dropped; values without a unit are assumed to be in the units of the FieldID already.")
+	flag.Float64Var(&h.Min, "min", math.Inf(-1), "Values below min (after unit conversion, before medication adjustment) are dropped as implausible")
+	flag.Float64Var(&h.Max, "max", math.Inf(1), "Values above max (after unit conversion, before medication adjustment) are dropped as implausible")
+	flag.StringVar(&adjustCodes, "adjust-codes", "", "(Optional) Comma-separated FieldID 20003 codes of medications that alter the trait, e.g., statins for LDL cholesterol. Requires -adjust-divisor.")
+	flag.Float64Var(&h.AdjustDivisor, "adjust-divisor", 0, "Values measured while on a medication listed in -adjust-codes are divided by this, e.g., 0.7 for LDL cholesterol on statins.")
+	flag.StringVar(&output, "output", OutputSummary, "Output mode. 'summary': one row per participant with the baseline, mean, latest, and slope. 'long': one row per measurement.")
+	flag.Parse()
+
+	if b.FieldID == 0 {
+		fmt.Fprintln(os.Stderr, "Please provide --field")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if b.Name == "" {
+		b.Name = strconv.FormatInt(b.FieldID, 10)
+	}
+
+	if output != OutputSummary && output != OutputLong {
+		fmt.Fprintf(os.Stderr, "Unknown --output %q\n", output)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if BQ.Project == "" && localDir == "" {
+		fmt.Fprintln(os.Stderr, "Please provide --project")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if BQ.Database == "" && localDir == "" {
+		fmt.Fprintln(os.Stderr, "Please provide --database")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if localDir != "" && displayQuery {
+		fmt.Fprintln(os.Stderr, "--display-query is not available with --local")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	b.Read2 = splitList(read2)
+	b.CTV3 = splitList(ctv3)
+	b.AdjustCodes = splitList(adjustCodes)
+
+	if (len(b.AdjustCodes) > 0) != (h.AdjustDivisor > 0) {
+		fmt.Fprintln(os.Stderr, "--adjust-codes and a positive --adjust-divisor must be given together")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if unitsPath != "" {
+		var err error
+		if h.Units, err = ReadUnits(unitsPath); err != nil {
+			log.Fatalln(err)
+		}
+	} else if len(b.Read2)+len(b.CTV3) > 0 {
+		log.Println("No --units were given, so primary care values will be used as recorded")
+	}
+
+	var src DataSource = BQ
+	if localDir != "" {
+		src = &LocalFiles{Dir: localDir}
+	} else {
+		var err error
+		BQ.Client, err = bigquery.NewClient(BQ.Context, BQ.Project)
+		if err != nil {
+			log.Fatalf("Connecting to BigQuery: %v", err)
+		}
+		defer BQ.Client.Close()
+
+		if displayQuery {
+			if _, err := BuildQuery(BQ, b, displayQuery); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+	}
+
+	if err := ExecuteBiomarker(src, b, h, output); err != nil {
+		log.Fatalln(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Finished producing output for %s\n", b.Name)
+}
+
+// splitList splits a comma-separated flag, dropping empty entries.
+func splitList(value string) []string {
+	var out []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
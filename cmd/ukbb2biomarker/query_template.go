@@ -0,0 +1,30 @@
+package main
+
+import (
+	"embed"
+	"strings"
+	"text/template"
+)
+
+//go:embed *.sql
+var embeddedQueryTemplates embed.FS
+
+// executeQueryTemplate fills in the named embedded query template.
+func executeQueryTemplate(templateName string, queryParts map[string]interface{}) (string, error) {
+	templateBytes, err := embeddedQueryTemplates.ReadFile(templateName)
+	if err != nil {
+		return "", err
+	}
+
+	queryTemplate, err := template.New("").Parse(string(templateBytes))
+	if err != nil {
+		return "", err
+	}
+
+	populatedQuery := &strings.Builder{}
+	if err := queryTemplate.Execute(populatedQuery, queryParts); err != nil {
+		return "", err
+	}
+
+	return populatedQuery.String(), nil
+}
@@ -0,0 +1,101 @@
+{{/* Produces the rows from which ExecuteBiomarker computes each participant's
+	measurements. LocalFiles.BiomarkerRows mirrors this query. */}}
+
+WITH visit_dates AS (
+	SELECT
+		p.sample_id,
+		p.instance,
+		MIN(SAFE.PARSE_DATE('%E4Y-%m-%d', p.value)) visit_date,
+	FROM `{{.database}}.phenotype` p
+	WHERE TRUE
+		AND p.FieldID = 53
+		AND p.array_idx = 0
+	GROUP BY
+		p.sample_id,
+		p.instance
+)
+
+SELECT
+	'participant' kind,
+	c.sample_id,
+	CAST(NULL AS INT64) instance,
+	CAST(NULL AS DATE) date,
+	CAST(NULL AS STRING) value1,
+	CAST(NULL AS STRING) value2,
+	CAST(NULL AS STRING) value3,
+	c.birthdate,
+	c.enroll_date,
+FROM `{{.database}}.censor` c
+
+UNION ALL
+
+SELECT
+	'visit' kind,
+	vd.sample_id,
+	vd.instance,
+	vd.visit_date date,
+	CAST(NULL AS STRING) value1,
+	CAST(NULL AS STRING) value2,
+	CAST(NULL AS STRING) value3,
+	CAST(NULL AS DATE) birthdate,
+	CAST(NULL AS DATE) enroll_date,
+FROM visit_dates vd
+
+UNION ALL
+
+SELECT
+	'measurement' kind,
+	p.sample_id,
+	p.instance,
+	CAST(NULL AS DATE) date,
+	p.value value1,
+	CAST(NULL AS STRING) value2,
+	CAST(NULL AS STRING) value3,
+	CAST(NULL AS DATE) birthdate,
+	CAST(NULL AS DATE) enroll_date,
+FROM `{{.database}}.phenotype` p
+WHERE p.FieldID = @FieldID
+
+{{- if .adjust}}
+
+UNION ALL
+
+SELECT DISTINCT
+	'medication' kind,
+	p.sample_id,
+	p.instance,
+	CAST(NULL AS DATE) date,
+	CAST(NULL AS STRING) value1,
+	CAST(NULL AS STRING) value2,
+	CAST(NULL AS STRING) value3,
+	CAST(NULL AS DATE) birthdate,
+	CAST(NULL AS DATE) enroll_date,
+FROM `{{.database}}.phenotype` p
+WHERE TRUE
+	AND p.FieldID = 20003
+	AND p.value IN UNNEST(@AdjustCodes)
+{{- end}}
+
+{{- if .gp}}
+
+UNION ALL
+
+SELECT
+	'gp' kind,
+	gp.eid sample_id,
+	CAST(NULL AS INT64) instance,
+	-- Event dates are distributed as dd/mm/yyyy
+	COALESCE(
+		SAFE.PARSE_DATE('%d/%m/%Y', CAST(gp.event_dt AS STRING)),
+		SAFE.PARSE_DATE('%F', CAST(gp.event_dt AS STRING))
+	) date,
+	CAST(gp.value1 AS STRING) value1,
+	CAST(gp.value2 AS STRING) value2,
+	CAST(gp.value3 AS STRING) value3,
+	CAST(NULL AS DATE) birthdate,
+	CAST(NULL AS DATE) enroll_date,
+FROM `{{.database}}.gp_clinical` gp
+WHERE FALSE
+	OR gp.read_2 IN UNNEST(@Read2)
+	OR gp.read_3 IN UNNEST(@CTV3)
+{{- end}}
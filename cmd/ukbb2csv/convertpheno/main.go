@@ -9,6 +9,7 @@ import (
 
 	_ "github.com/carbocation/genomisc/compileinfoprint"
 	"github.com/carbocation/genomisc/ukbb2csv/convertpheno"
+	"github.com/carbocation/genomisc/ukbb2csv/sqlstore"
 )
 
 func main() {
@@ -18,11 +19,15 @@ func main() {
 		dictionaryFile string
 		withdrawalFile string
 		acknowledge    bool
+		sqlDriver      string
+		sqlDSN         string
 		BQ             = &convertpheno.WrappedBigQuery{}
 	)
-	flag.StringVar(&checkMethod, "method", "bigquery", "Method of checking for pre-existing fields. Options: bigquery, none")
+	flag.StringVar(&checkMethod, "method", "bigquery", "Method of checking for pre-existing fields. Options: bigquery, none, sql. With sql, the data are loaded directly into the phenotype table of --dsn rather than printed.")
 	flag.StringVar(&BQ.Project, "project", "", "Name of the Google Cloud project that hosts your BigQuery dataset (only if method == 'bigquery')")
 	flag.StringVar(&BQ.Database, "bigquery", "", "BigQuery phenotype dataset that will/does contain the 'phenotype' table (only if method == 'bigquery')")
+	flag.StringVar(&sqlDriver, "driver", sqlstore.DriverSQLite, fmt.Sprintf("SQL database driver (only if method == 'sql'). Options: %s, %s", sqlstore.DriverSQLite, sqlstore.DriverPostgres))
+	flag.StringVar(&sqlDSN, "dsn", "", "SQL database to load into (only if method == 'sql'): a path to the SQLite file, or a Postgres connection string. Its dictionary table should already be loaded with loadsql.")
 	flag.StringVar(&dictionaryFile, "dictionary", "", "File containing the parsed output from `convertdict`. Required if method == 'none'")
 	flag.StringVar(&phenoPathList, "pheno", "", "File containing the paths of each phenotype file for the UKBB that you want to process in this run. Each file should be on its own line. The files with the newest data should be listed first: every FieldID that is seen in an earlier file will be ignored in later files.")
 	flag.StringVar(&withdrawalFile, "withdrawal", "", "(Optional.) File containing the most recently available participant withdrawal list for your application.")
@@ -35,8 +40,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	if checkMethod != "bigquery" && checkMethod != "none" {
-		log.Println("Valid --method options include bigquery and none")
+	if checkMethod != "bigquery" && checkMethod != "none" && checkMethod != "sql" {
+		log.Println("Valid --method options include bigquery, none, and sql")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if checkMethod == "sql" && sqlDSN == "" {
+		log.Println("Please pass --dsn the SQL database to load into.")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -91,5 +102,16 @@ Please re-run this tool with the --ack flag to demonstrate that you understand t
 		if err := convertpheno.RunAllBackendCSV(phenoPaths, dictionaryFile); err != nil {
 			log.Fatalln(err)
 		}
+
+	case "sql":
+		db, err := sqlstore.Open(sqlDriver, sqlDSN)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer db.Close()
+
+		if err := convertpheno.RunAllBackendSQL(phenoPaths, db); err != nil {
+			log.Fatalln(err)
+		}
 	}
 }
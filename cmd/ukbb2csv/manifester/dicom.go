@@ -15,53 +15,9 @@ import (
 )
 
 func ManifestForDicom(path, fileList string) error {
-
-	var files []string
-
-	if fileList != "" {
-		// File list - process just the requested files. Will prefix with path +
-		// "/" if path is not the empty string ("").
-
-		fl, err := os.Open(fileList)
-		if err != nil {
-			return pfx.Err(err)
-		}
-
-		cr := csv.NewReader(fl)
-		for {
-			cols, err := cr.Read()
-			if err == io.EOF {
-				break
-			} else if err != nil {
-				return pfx.Err(err)
-			}
-
-			if len(cols) < 1 {
-				continue
-			}
-
-			if len(cols[0]) < 1 {
-				continue
-			}
-
-			files = append(files, cols[0])
-		}
-
-	} else {
-		// No file list - process all of the items within the folder
-
-		fileInfos, err := ioutil.ReadDir(path)
-		if err != nil {
-			return pfx.Err(err)
-		}
-
-		for _, f := range fileInfos {
-			if f.IsDir() {
-				continue
-			}
-
-			files = append(files, f.Name())
-		}
+	files, err := dicomZipFiles(path, fileList)
+	if err != nil {
+		return err
 	}
 
 	fmt.Fprintf(STDOUT, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
@@ -168,3 +124,58 @@ func ManifestForDicom(path, fileList string) error {
 
 	return nil
 }
+
+// dicomZipFiles lists the files named in fileList, or, if fileList is blank,
+// all of the files directly under path.
+func dicomZipFiles(path, fileList string) ([]string, error) {
+	var files []string
+
+	if fileList != "" {
+		// File list - process just the requested files. Will prefix with path +
+		// "/" if path is not the empty string ("").
+
+		fl, err := os.Open(fileList)
+		if err != nil {
+			return nil, pfx.Err(err)
+		}
+		defer fl.Close()
+
+		cr := csv.NewReader(fl)
+		for {
+			cols, err := cr.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, pfx.Err(err)
+			}
+
+			if len(cols) < 1 {
+				continue
+			}
+
+			if len(cols[0]) < 1 {
+				continue
+			}
+
+			files = append(files, cols[0])
+		}
+
+	} else {
+		// No file list - process all of the items within the folder
+
+		fileInfos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, pfx.Err(err)
+		}
+
+		for _, f := range fileInfos {
+			if f.IsDir() {
+				continue
+			}
+
+			files = append(files, f.Name())
+		}
+	}
+
+	return files, nil
+}
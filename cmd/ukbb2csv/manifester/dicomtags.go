@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/carbocation/genomisc"
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+	"github.com/carbocation/pfx"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// These columns describe the origin of each DICOM and precede the requested
+// tags in every row.
+var dicomTagFixedColumns = []string{
+	"sample_id",
+	"field_id",
+	"instance",
+	"index",
+	"zip_file",
+	"dicom_file",
+}
+
+// dicomTagRowWriter serializes one row per DICOM. Rows are keyed by column name.
+type dicomTagRowWriter interface {
+	Write(row map[string]interface{}) error
+	Close() error
+}
+
+// ManifestForDicomTags extracts every tag listed in tagFile from every DICOM in
+// the zips, emitting either Parquet or newline-delimited JSON. If schemaFile is
+// set, the BigQuery schema for the output is written there.
+func ManifestForDicomTags(path, fileList, tagFile, format, schemaFile string) error {
+	specs, err := readDicomTagSpecs(tagFile)
+	if err != nil {
+		return err
+	}
+
+	selectors, err := bulkprocess.ParseDicomTagSelectors(specs)
+	if err != nil {
+		return pfx.Err(err)
+	}
+
+	if schemaFile != "" {
+		if err := writeBigQuerySchema(schemaFile, selectors); err != nil {
+			return err
+		}
+	}
+
+	var rowWriter dicomTagRowWriter
+	switch format {
+	case "ndjson":
+		rowWriter = &ndjsonDicomTagWriter{enc: json.NewEncoder(STDOUT)}
+	case "parquet":
+		pw, err := writer.NewJSONWriterFromWriter(parquetSchema(selectors), STDOUT, int64(runtime.NumCPU()))
+		if err != nil {
+			return pfx.Err(err)
+		}
+		pw.CompressionType = parquet.CompressionCodec_SNAPPY
+		rowWriter = &parquetDicomTagWriter{pw: pw}
+	default:
+		return fmt.Errorf("Output format %q not recognized; options are 'parquet' and 'ndjson'", format)
+	}
+
+	files, err := dicomZipFiles(path, fileList)
+	if err != nil {
+		return err
+	}
+
+	concurrency := 4 * runtime.NumCPU()
+
+	results := make(chan bulkprocess.DicomTagOutput, concurrency)
+	doneListening := make(chan struct{})
+	var writeErr error
+	go func() {
+		defer func() { doneListening <- struct{}{} }()
+		// Serialize results so that rows are not interleaved (the writers are
+		// not goroutine safe).
+		for res := range results {
+			if writeErr != nil {
+				continue
+			}
+			writeErr = rowWriter.Write(dicomTagRow(res, selectors))
+		}
+	}()
+
+	// Ensure a path separator if we are using a path
+	if path != "" && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	semaphore := make(chan struct{}, concurrency)
+
+	for _, file := range files {
+
+		// Will block after `concurrency` simultaneous goroutines are running
+		semaphore <- struct{}{}
+
+		go func(file string) {
+
+			// Be sure to permit unblocking once we finish
+			defer func() { <-semaphore }()
+
+			if !strings.HasSuffix(file, ".zip") {
+				return
+			}
+
+			err := bulkprocess.DicomTagZipIterator(path+file, selectors, func(dcm bulkprocess.DicomTagOutput) error {
+				results <- dcm
+				return nil
+			})
+			if err != nil {
+				log.Println("Error parsing", path+file)
+				log.Println(err)
+			}
+		}(file)
+	}
+
+	// Make sure we finish all the reads before we exit, otherwise we'll lose
+	// the last `concurrency` rows.
+	for i := 0; i < cap(semaphore); i++ {
+		semaphore <- struct{}{}
+	}
+
+	// Close the results channel and make sure we are done listening
+	close(results)
+	<-doneListening
+
+	if writeErr != nil {
+		return pfx.Err(writeErr)
+	}
+
+	return pfx.Err(rowWriter.Close())
+}
+
+// readDicomTagSpecs reads one tag specification per line. Blank lines and
+// lines starting with '#' are ignored.
+func readDicomTagSpecs(tagFile string) ([]string, error) {
+	f, err := os.Open(genomisc.ExpandHome(tagFile))
+	if err != nil {
+		return nil, pfx.Err(err)
+	}
+	defer f.Close()
+
+	var specs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		specs = append(specs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, pfx.Err(err)
+	}
+
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("No tags were listed in %s", tagFile)
+	}
+
+	return specs, nil
+}
+
+// dicomTagRow flattens one DICOM's values into a row. Missing values are
+// omitted, which both BigQuery and Parquet treat as NULL (or as an empty list,
+// for repeated columns). Dates remain time.Time; the writers encode them.
+func dicomTagRow(dcm bulkprocess.DicomTagOutput, selectors []bulkprocess.DicomTagSelector) map[string]interface{} {
+	row := map[string]interface{}{
+		"sample_id":  dcm.SampleID,
+		"field_id":   dcm.FieldID,
+		"instance":   dcm.Instance,
+		"index":      dcm.Index,
+		"zip_file":   dcm.ZipFile,
+		"dicom_file": dcm.Filename,
+	}
+
+	for i, sel := range selectors {
+		vals := dcm.Values[i]
+		if len(vals) == 0 {
+			continue
+		}
+
+		if sel.Repeated {
+			row[sel.Name] = vals
+		} else {
+			row[sel.Name] = vals[0]
+		}
+	}
+
+	return row
+}
+
+type ndjsonDicomTagWriter struct {
+	enc *json.Encoder
+}
+
+func (w *ndjsonDicomTagWriter) Write(row map[string]interface{}) error {
+	return w.enc.Encode(encodeDicomTagDates(row, func(t time.Time) interface{} {
+		return t.Format("2006-01-02")
+	}))
+}
+
+func (w *ndjsonDicomTagWriter) Close() error {
+	return nil
+}
+
+type parquetDicomTagWriter struct {
+	pw *writer.JSONWriter
+}
+
+func (w *parquetDicomTagWriter) Write(row map[string]interface{}) error {
+	// Parquet's DATE logical type counts days since the Unix epoch
+	line, err := json.Marshal(encodeDicomTagDates(row, func(t time.Time) interface{} {
+		return int32(t.Unix() / 86400)
+	}))
+	if err != nil {
+		return err
+	}
+
+	return w.pw.Write(string(line))
+}
+
+func (w *parquetDicomTagWriter) Close() error {
+	return w.pw.WriteStop()
+}
+
+func encodeDicomTagDates(row map[string]interface{}, encode func(time.Time) interface{}) map[string]interface{} {
+	for k, v := range row {
+		switch x := v.(type) {
+		case time.Time:
+			row[k] = encode(x)
+		case []interface{}:
+			for i, item := range x {
+				if t, ok := item.(time.Time); ok {
+					x[i] = encode(t)
+				}
+			}
+		}
+	}
+
+	return row
+}
+
+type bigQueryField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+}
+
+func writeBigQuerySchema(schemaFile string, selectors []bulkprocess.DicomTagSelector) error {
+	fields := make([]bigQueryField, 0, len(dicomTagFixedColumns)+len(selectors))
+	for _, col := range dicomTagFixedColumns {
+		fields = append(fields, bigQueryField{Name: col, Type: "STRING", Mode: "REQUIRED"})
+	}
+
+	for _, sel := range selectors {
+		mode := "NULLABLE"
+		if sel.Repeated {
+			mode = "REPEATED"
+		}
+		fields = append(fields, bigQueryField{Name: sel.Name, Type: string(sel.Type), Mode: mode})
+	}
+
+	out, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return pfx.Err(err)
+	}
+
+	return pfx.Err(os.WriteFile(genomisc.ExpandHome(schemaFile), append(out, '\n'), 0644))
+}
+
+// parquetSchema produces the JSON schema definition used by parquet-go's JSON
+// writer.
+func parquetSchema(selectors []bulkprocess.DicomTagSelector) string {
+	type parquetField struct {
+		Tag string
+	}
+
+	fields := make([]parquetField, 0, len(dicomTagFixedColumns)+len(selectors))
+	for _, col := range dicomTagFixedColumns {
+		fields = append(fields, parquetField{Tag: fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED", col)})
+	}
+
+	for _, sel := range selectors {
+		var physical string
+		switch sel.Type {
+		case bulkprocess.DicomColumnInteger:
+			physical = "type=INT64"
+		case bulkprocess.DicomColumnFloat:
+			physical = "type=DOUBLE"
+		case bulkprocess.DicomColumnDate:
+			physical = "type=INT32, convertedtype=DATE"
+		default:
+			physical = "type=BYTE_ARRAY, convertedtype=UTF8"
+		}
+
+		repetition := "OPTIONAL"
+		if sel.Repeated {
+			repetition = "REPEATED"
+		}
+
+		fields = append(fields, parquetField{Tag: fmt.Sprintf("name=%s, %s, repetitiontype=%s", sel.Name, physical, repetition)})
+	}
+
+	out, _ := json.Marshal(struct {
+		Tag    string
+		Fields []parquetField
+	}{
+		Tag:    "name=parquet_go_root, repetitiontype=REQUIRED",
+		Fields: fields,
+	})
+
+	return string(out)
+}
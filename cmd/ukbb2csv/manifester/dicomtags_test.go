@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+var testDicomTagSpecs = []string{
+	"SeriesDescription",
+	"Rows",
+	"PixelSpacing",
+	"StudyDate",
+	"SharedFunctionalGroupsSequence>MRTimingAndRelatedParametersSequence>RepetitionTime",
+	"venc=CSAImage.FlowVenc:FLOAT",
+}
+
+var testStudyDate = time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// testDicomTagOutputs returns one fully populated DICOM and one with every
+// selected tag missing.
+func testDicomTagOutputs() []bulkprocess.DicomTagOutput {
+	return []bulkprocess.DicomTagOutput{
+		{
+			SampleID: "1000001",
+			ZipFile:  "1000001_20208_2_0.zip",
+			FieldID:  "20208",
+			Instance: "2",
+			Index:    "0",
+			Filename: "1.3.12.2.1107.5.2.18.41754.dcm",
+			Values: [][]interface{}{
+				{"CINE_segmented_LAX_4Ch"},
+				{int64(208)},
+				{1.5, 1.875},
+				{testStudyDate},
+				{30.5, 31.0},
+				{150.0},
+			},
+		},
+		{
+			SampleID: "1000002",
+			ZipFile:  "1000002_20208_2_0.zip",
+			FieldID:  "20208",
+			Instance: "2",
+			Index:    "0",
+			Filename: "1.3.12.2.1107.5.2.18.41755.dcm",
+			Values:   make([][]interface{}, len(testDicomTagSpecs)),
+		},
+	}
+}
+
+func TestDicomTagNDJSONMatchesSchema(t *testing.T) {
+	selectors, err := bulkprocess.ParseDicomTagSelectors(testDicomTagSpecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schemaFile := filepath.Join(t.TempDir(), "schema.json")
+	if err := writeBigQuerySchema(schemaFile, selectors); err != nil {
+		t.Fatal(err)
+	}
+
+	schemaJSON, err := os.ReadFile(schemaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema []bigQueryField
+	if err := json.Unmarshal(schemaJSON, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedSchema := []bigQueryField{
+		{"sample_id", "STRING", "REQUIRED"},
+		{"field_id", "STRING", "REQUIRED"},
+		{"instance", "STRING", "REQUIRED"},
+		{"index", "STRING", "REQUIRED"},
+		{"zip_file", "STRING", "REQUIRED"},
+		{"dicom_file", "STRING", "REQUIRED"},
+		{"SeriesDescription", "STRING", "NULLABLE"},
+		{"Rows", "INTEGER", "NULLABLE"},
+		{"PixelSpacing", "FLOAT", "REPEATED"},
+		{"StudyDate", "DATE", "NULLABLE"},
+		{"SharedFunctionalGroupsSequence_MRTimingAndRelatedParametersSequence_RepetitionTime", "FLOAT", "REPEATED"},
+		{"venc", "FLOAT", "REPEATED"},
+	}
+	if len(schema) != len(expectedSchema) {
+		t.Fatalf("Schema had %d fields, expected %d: %+v", len(schema), len(expectedSchema), schema)
+	}
+	for i := range expectedSchema {
+		if schema[i] != expectedSchema[i] {
+			t.Errorf("Schema field %d was %+v, expected %+v", i, schema[i], expectedSchema[i])
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	w := &ndjsonDicomTagWriter{enc: json.NewEncoder(buf)}
+	for _, dcm := range testDicomTagOutputs() {
+		if err := w.Write(dicomTagRow(dcm, selectors)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []map[string]interface{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		row := make(map[string]interface{})
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("Line %d is not JSON: %v", len(rows)+1, err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Got %d NDJSON rows, expected 2", len(rows))
+	}
+
+	// Every value must be loadable under the schema: required columns are
+	// present, repeated columns are arrays, and others are scalars of the
+	// declared type.
+	fields := make(map[string]bigQueryField)
+	for _, field := range schema {
+		fields[field.Name] = field
+	}
+	for i, row := range rows {
+		for name := range row {
+			if _, exists := fields[name]; !exists {
+				t.Errorf("Row %d: column %s is not in the schema", i, name)
+			}
+		}
+
+		for _, field := range schema {
+			v, present := row[field.Name]
+			if !present {
+				if field.Mode == "REQUIRED" {
+					t.Errorf("Row %d: required column %s is missing", i, field.Name)
+				}
+				continue
+			}
+
+			values := []interface{}{v}
+			if field.Mode == "REPEATED" {
+				list, isList := v.([]interface{})
+				if !isList {
+					t.Errorf("Row %d: repeated column %s is %#v, not an array", i, field.Name, v)
+					continue
+				}
+				values = list
+			}
+
+			for _, value := range values {
+				switch field.Type {
+				case "STRING":
+					_, ok := value.(string)
+					if !ok {
+						t.Errorf("Row %d: %s value %#v is not a string", i, field.Name, value)
+					}
+				case "INTEGER", "FLOAT":
+					_, ok := value.(float64)
+					if !ok {
+						t.Errorf("Row %d: %s value %#v is not a number", i, field.Name, value)
+					}
+				case "DATE":
+					s, ok := value.(string)
+					if _, err := time.Parse("2006-01-02", s); !ok || err != nil {
+						t.Errorf("Row %d: %s value %#v is not a YYYY-MM-DD date", i, field.Name, value)
+					}
+				}
+			}
+		}
+	}
+
+	first := rows[0]
+	if first["sample_id"] != "1000001" || first["dicom_file"] != "1.3.12.2.1107.5.2.18.41754.dcm" {
+		t.Errorf("First row had origin %v / %v", first["sample_id"], first["dicom_file"])
+	}
+	if first["SeriesDescription"] != "CINE_segmented_LAX_4Ch" || first["Rows"] != 208.0 || first["StudyDate"] != "2015-06-01" {
+		t.Errorf("First row had scalar values %v, %v, %v", first["SeriesDescription"], first["Rows"], first["StudyDate"])
+	}
+	if tr, _ := first["SharedFunctionalGroupsSequence_MRTimingAndRelatedParametersSequence_RepetitionTime"].([]interface{}); len(tr) != 2 || tr[0] != 30.5 || tr[1] != 31.0 {
+		t.Errorf("First row had RepetitionTime %v, expected [30.5 31]", tr)
+	}
+	if venc, _ := first["venc"].([]interface{}); len(venc) != 1 || venc[0] != 150.0 {
+		t.Errorf("First row had venc %v, expected [150]", venc)
+	}
+
+	// Missing values are omitted rather than written as empty values
+	if len(rows[1]) != len(dicomTagFixedColumns) {
+		t.Errorf("Second row had %d columns, expected only the %d fixed columns: %v", len(rows[1]), len(dicomTagFixedColumns), rows[1])
+	}
+}
+
+func TestDicomTagParquet(t *testing.T) {
+	selectors, err := bulkprocess.ParseDicomTagSelectors(testDicomTagSpecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tags.parquet")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pw, err := writer.NewJSONWriterFromWriter(parquetSchema(selectors), f, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &parquetDicomTagWriter{pw: pw}
+	for _, dcm := range testDicomTagOutputs() {
+		if err := w.Write(dicomTagRow(dcm, selectors)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pf, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+
+	pr, err := reader.NewParquetColumnReader(pf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := pr.GetNumRows(); n != 2 {
+		t.Fatalf("Parquet file had %d rows, expected 2", n)
+	}
+
+	sh := pr.SchemaHandler
+	columns := make(map[string][]interface{})
+	for _, inPath := range sh.ValueColumns {
+		values, _, _, err := pr.ReadColumnByPath(inPath, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns[sh.Infos[sh.MapIndex[inPath]].ExName] = values
+	}
+
+	if len(columns) != len(dicomTagFixedColumns)+len(selectors) {
+		t.Errorf("Parquet file had %d columns, expected %d", len(columns), len(dicomTagFixedColumns)+len(selectors))
+	}
+
+	expected := []struct {
+		Column string
+		Values []interface{}
+	}{
+		{"sample_id", []interface{}{"1000001", "1000002"}},
+		{"SeriesDescription", []interface{}{"CINE_segmented_LAX_4Ch", nil}},
+		{"Rows", []interface{}{int64(208), nil}},
+		{"StudyDate", []interface{}{int32(testStudyDate.Unix() / 86400), nil}},
+
+		// Repeated columns hold every value of the first row, then a NULL
+		// placeholder for the empty list of the second
+		{"PixelSpacing", []interface{}{1.5, 1.875, nil}},
+		{"venc", []interface{}{150.0, nil}},
+	}
+
+	for _, e := range expected {
+		got := columns[e.Column]
+		if len(got) != len(e.Values) {
+			t.Errorf("Column %s was %v, expected %v", e.Column, got, e.Values)
+			continue
+		}
+		for i := range e.Values {
+			if got[i] != e.Values[i] {
+				t.Errorf("Column %s value %d was %#v, expected %#v", e.Column, i, got[i], e.Values[i])
+			}
+		}
+	}
+}
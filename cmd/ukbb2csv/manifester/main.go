@@ -25,11 +25,15 @@ func main() {
 
 	var path, output, fileList string
 	var filetypes string
+	var tagFile, format, schemaFile string
 
 	flag.StringVar(&path, "path", "", "Path where the UKBB bulk .zip files are being held.")
-	flag.StringVar(&filetypes, "type", "dicomzip", "File type. Options include 'dicomzip' (default), '12leadekg', and 'exerciseekg' (for EKG data).")
+	flag.StringVar(&filetypes, "type", "dicomzip", "File type. Options include 'dicomzip' (default), 'dicomtags' (dicomzip, with the tags listed in -tags), '12leadekg', and 'exerciseekg' (for EKG data).")
 	flag.StringVar(&output, "output", "", "Output file. If blank, output will go to STDOUT.")
 	flag.StringVar(&fileList, "files", "", "Optional one-column headerless list of files to process. If set, will only process these fils rather than all available files under the path.")
+	flag.StringVar(&tagFile, "tags", "", "For -type dicomtags: file with one tag per line, as a keyword or (gggg,eeee). Nested sequences are separated by '>', e.g., SharedFunctionalGroupsSequence>PixelMeasuresSequence>PixelSpacing. Siemens CSA header elements are given as CSAImage.Name or CSASeries.Name. Optionally prefix with column= or suffix with :STRING, :INTEGER, :FLOAT, or :DATE.")
+	flag.StringVar(&format, "format", "parquet", "For -type dicomtags: output format. Options include 'parquet' (default) and 'ndjson' (newline-delimited JSON).")
+	flag.StringVar(&schemaFile, "schema", "", "For -type dicomtags: optional file into which the BigQuery JSON schema for the output will be written.")
 	flag.Parse()
 
 	if path == "" && fileList == "" {
@@ -72,6 +76,18 @@ func main() {
 		return
 	}
 
+	if filetypes == "dicomtags" {
+		if tagFile == "" {
+			log.Fatalln("-tags is required for the dicomtags file type")
+		}
+
+		if err := ManifestForDicomTags(path, fileList, tagFile, format, schemaFile); err != nil {
+			log.Fatalln(err)
+		}
+
+		return
+	}
+
 	if filetypes != "dicomzip" {
 		log.Printf("Requested filetype '%s' not recognized\n", filetypes)
 		flag.PrintDefaults()
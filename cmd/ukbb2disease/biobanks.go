@@ -1,17 +1,253 @@
 package main
 
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/carbocation/genomisc/ukbb"
+)
+
 const (
 	BiobankSourceUKBiobank = "ukbb"
 	BiobankSourceAllOfUs   = "aou"
+	BiobankSourceOMOP      = "omop"
+)
+
+// Biobanks holds the adapters that can be chosen with -biobank_source.
+var Biobanks = map[string]Biobank{
+	BiobankSourceUKBiobank: UKBiobank{},
+	BiobankSourceAllOfUs:   AllOfUs{},
+	BiobankSourceOMOP:      OMOP{},
+}
+
+// FieldClass says where the dates of a field's values are found. It
+// determines which part of the TabFile an entry is added to.
+type FieldClass int
+
+const (
+	// FieldClassHesin fields are found in a table of dated events (for the UK
+	// Biobank, the materialized HESIN-like table).
+	FieldClassHesin FieldClass = iota
+
+	// FieldClassSpecial fields have a specially-known date.
+	FieldClassSpecial
+
+	// FieldClassStandard fields are undated and are assigned the enrollment
+	// date.
+	FieldClassStandard
 )
 
-func DoesBiobankUseNumericFieldID(biobank string) bool {
-	switch biobank {
-	case BiobankSourceUKBiobank:
-		return true
-	case BiobankSourceAllOfUs:
-		return false
+// A Biobank adapts the tabfile format and the query to one biobank's data.
+type Biobank interface {
+	// Name is the value of -biobank_source that selects the biobank.
+	Name() string
+
+	// ParseField interprets the first column of a tabfile row, setting
+	// either the FieldID or the FieldName of the entry.
+	ParseField(field string, entry *TabEntry) error
+
+	// Classify says where the dates of the entry's values are found.
+	Classify(entry TabEntry) FieldClass
+
+	// NormalizeCode rewrites one of the entry's values to match the way that
+	// the biobank stores it.
+	NormalizeCode(entry TabEntry, value string) string
+
+	// FieldCondition returns the SQL condition on the event table (aliased
+	// hd) that selects the entry's field, with the value for the named query
+	// parameter.
+	FieldCondition(entry TabEntry, param string) (string, interface{})
+
+	// QueryTemplate names the embedded SQL template for a single disease or,
+	// if batch is set, for many diseases at once.
+	QueryTemplate(batch bool) (string, error)
+}
+
+// LookupBiobank returns the adapter for the named biobank.
+func LookupBiobank(name string) (Biobank, error) {
+	biobank, exists := Biobanks[name]
+	if !exists {
+		return nil, fmt.Errorf("Unknown biobank source %q. Options include: %s", name, strings.Join(BiobankNames(), ", "))
+	}
+
+	return biobank, nil
+}
+
+// BiobankNames lists the valid values of -biobank_source.
+func BiobankNames() []string {
+	out := make([]string, 0, len(Biobanks))
+	for name := range Biobanks {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+// UKBiobank uses numeric FieldIDs. ICD and OPCS codes (the HESIN fields) are
+// stored without their dots.
+type UKBiobank struct{}
+
+func (UKBiobank) Name() string { return BiobankSourceUKBiobank }
+
+func (UKBiobank) ParseField(field string, entry *TabEntry) error {
+	fieldID, err := strconv.Atoi(field)
+	if err != nil {
+		return err
+	}
+	entry.FieldID = fieldID
+
+	return nil
+}
+
+func (UKBiobank) Classify(entry TabEntry) FieldClass {
+	if ukbb.IsHesin(entry.FieldID) {
+		return FieldClassHesin
+	} else if ukbb.IsSpecial(entry.FieldID) {
+		return FieldClassSpecial
+	}
+
+	return FieldClassStandard
+}
+
+func (UKBiobank) NormalizeCode(entry TabEntry, value string) string {
+	// In the UK Biobank HESIN data, fields are special-cased to exclude "." (So
+	// K41.2 becomes K412)
+	if ukbb.IsHesin(entry.FieldID) {
+		return strings.Replace(value, ".", "", -1)
+	}
+
+	return value
+}
+
+func (UKBiobank) FieldCondition(entry TabEntry, param string) (string, interface{}) {
+	return fmt.Sprintf("hd.FieldID = @%s", param), entry.FieldID
+}
+
+func (UKBiobank) QueryTemplate(batch bool) (string, error) {
+	if batch {
+		return "query_template_ukbb_batch.sql", nil
+	}
+
+	return "query_template_ukbb.sql", nil
+}
+
+// AllOfUs names fields by their OMOP vocabulary (e.g., 'ICD10CM'), and codes
+// must be written as they are in the vocabulary (i.e., with their dots). Its
+// query relies on the All of Us cb_search_person table.
+type AllOfUs struct{}
+
+func (AllOfUs) Name() string { return BiobankSourceAllOfUs }
+
+func (AllOfUs) ParseField(field string, entry *TabEntry) error {
+	entry.FieldName = field
+
+	return nil
+}
+
+func (AllOfUs) Classify(entry TabEntry) FieldClass {
+	// For now, treat all non-numeric field types (e.g., 'ICD10CM') as if they
+	// have an associated date.
+	return FieldClassHesin
+}
+
+func (AllOfUs) NormalizeCode(entry TabEntry, value string) string { return value }
+
+func (AllOfUs) FieldCondition(entry TabEntry, param string) (string, interface{}) {
+	return fmt.Sprintf("hd.FieldName = @%s", param), entry.FieldName
+}
+
+func (AllOfUs) QueryTemplate(batch bool) (string, error) {
+	if batch {
+		return "", fmt.Errorf("Batched queries are not available for biobank source %s", BiobankSourceAllOfUs)
+	}
+
+	return "query_template_aou.sql", nil
+}
+
+// OMOPVocabularies maps the UK Biobank FieldIDs of coded diagnoses and
+// procedures to the OMOP vocabularies that hold the same kind of code, so that
+// a tabfile written for the UK Biobank can be run against an OMOP CDM. The
+// Finnish vocabularies are used by FinnGen's OMOP release.
+var OMOPVocabularies = map[int][]string{
+	// ICD-10
+	41270: {"ICD10", "ICD10CM", "ICD10fi"},
+	41202: {"ICD10", "ICD10CM", "ICD10fi"},
+	41204: {"ICD10", "ICD10CM", "ICD10fi"},
+	40001: {"ICD10", "ICD10CM", "ICD10fi"},
+	40002: {"ICD10", "ICD10CM", "ICD10fi"},
+	40006: {"ICD10", "ICD10CM", "ICD10fi"},
+
+	// ICD-9
+	41271: {"ICD9CM", "ICD9fi"},
+	41203: {"ICD9CM", "ICD9fi"},
+	41205: {"ICD9CM", "ICD9fi"},
+	40013: {"ICD9CM", "ICD9fi"},
+
+	// OPCS-4
+	41272: {"OPCS4"},
+	41200: {"OPCS4"},
+	41210: {"OPCS4"},
+}
+
+// OMOP reads a generic OMOP CDM (condition_occurrence, procedure_occurrence,
+// observation_period, and death). Fields are either OMOP vocabularies (e.g.,
+// 'ICD10CM' or 'SNOMED') or the UK Biobank FieldIDs in OMOPVocabularies. Dots
+// are ignored when comparing codes, so UK Biobank-style codes (I210) match
+// their OMOP equivalents (I21.0).
+type OMOP struct{}
+
+func (OMOP) Name() string { return BiobankSourceOMOP }
+
+func (OMOP) ParseField(field string, entry *TabEntry) error {
+	fieldID, err := strconv.Atoi(field)
+	if err != nil {
+		entry.FieldName = field
+		return nil
+	}
+
+	if _, exists := OMOPVocabularies[fieldID]; !exists {
+		return fmt.Errorf("FieldID %d has no equivalent in the OMOP CDM. Use a FieldID of a coded diagnosis or procedure (%v), or the name of an OMOP vocabulary", fieldID, sortedOMOPFieldIDs())
+	}
+	entry.FieldID = fieldID
+
+	return nil
+}
+
+func (OMOP) Classify(entry TabEntry) FieldClass {
+	// Every field is looked up in the dated events of the CDM
+	return FieldClassHesin
+}
+
+func (OMOP) NormalizeCode(entry TabEntry, value string) string {
+	return strings.Replace(value, ".", "", -1)
+}
+
+func (OMOP) FieldCondition(entry TabEntry, param string) (string, interface{}) {
+	vocabularies := OMOPVocabularies[entry.FieldID]
+	if entry.FieldName != "" {
+		vocabularies = []string{entry.FieldName}
+	}
+
+	return fmt.Sprintf("hd.FieldName IN UNNEST(@%s)", param), vocabularies
+}
+
+func (OMOP) QueryTemplate(batch bool) (string, error) {
+	if batch {
+		return "", fmt.Errorf("Batched queries are not available for biobank source %s", BiobankSourceOMOP)
+	}
+
+	return "query_template_omop.sql", nil
+}
+
+func sortedOMOPFieldIDs() []int {
+	out := make([]int, 0, len(OMOPVocabularies))
+	for fieldID := range OMOPVocabularies {
+		out = append(out, fieldID)
 	}
+	sort.Ints(out)
 
-	return false
+	return out
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOMOPTabFile(t *testing.T) {
+	// A tabfile written for the UK Biobank, and one that names OMOP
+	// vocabularies, are both accepted.
+	tabPath := filepath.Join(t.TempDir(), "mi.tab")
+	tab := "FieldID\tvalues\texclude\n" +
+		"41202\tI21,I21.1\t0\n" +
+		"SNOMED\t22298006\t0\n" +
+		"41203\t410\t1\n"
+	if err := os.WriteFile(tabPath, []byte(tab), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	biobank := OMOP{}
+	tabs, err := ParseTabFile(tabPath, biobank)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tabs.Include.Hesin) != 2 || len(tabs.Exclude.Hesin) != 1 || len(tabs.Include.Standard)+len(tabs.Include.Special) != 0 {
+		t.Fatalf("Expected every OMOP field to be dated, got %+v", tabs)
+	}
+
+	expected := map[string]struct {
+		condition string
+		value     interface{}
+		values    []string
+	}{
+		"41202":  {"hd.FieldName IN UNNEST(@p)", []string{"ICD10", "ICD10CM", "ICD10fi"}, []string{"I21", "I211"}},
+		"SNOMED": {"hd.FieldName IN UNNEST(@p)", []string{"SNOMED"}, []string{"22298006"}},
+		"41203":  {"hd.FieldName IN UNNEST(@p)", []string{"ICD9CM", "ICD9fi"}, []string{"410"}},
+	}
+	for _, entry := range append(tabs.AllIncluded(), tabs.AllExcluded()...) {
+		key := entry.FieldName
+		if key == "" {
+			key = strconv.Itoa(entry.FieldID)
+		}
+		e := expected[key]
+		condition, value := biobank.FieldCondition(entry, "p")
+		if condition != e.condition || !reflect.DeepEqual(value, e.value) || !reflect.DeepEqual(entry.FormattedValues(biobank), e.values) {
+			t.Errorf("%s: expected %s %v %v, got %s %v %v", key, e.condition, e.value, e.values, condition, value, entry.FormattedValues(biobank))
+		}
+	}
+
+	// Fields that have no equivalent in the CDM are rejected
+	if err := biobank.ParseField("20002", &TabEntry{}); err == nil {
+		t.Errorf("Expected an error for a self-reported field")
+	}
+}
+
+func TestQueryTemplates(t *testing.T) {
+	queryParts := map[string]interface{}{
+		"database":             "project.dataset",
+		"materializedDatabase": "project.dataset",
+		"use_gp":               false,
+		"standardPart":         "AND FALSE",
+		"includePart":          "\nOR (hd.FieldName IN UNNEST(@IncludeParts0) AND hd.value IN UNNEST(@IncludeParts1) )",
+		"excludePart":          "",
+	}
+
+	for _, name := range BiobankNames() {
+		biobank, err := LookupBiobank(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		query, err := renderQuery(biobank, false, queryParts)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !strings.Contains(query, "project.dataset") || !strings.Contains(query, "@IncludeParts1") {
+			t.Errorf("%s: the query was not populated", name)
+		}
+
+		_, err = renderQuery(biobank, true, queryParts)
+		if batched := name == BiobankSourceUKBiobank; batched != (err == nil) {
+			t.Errorf("%s: unexpected result for a batched query: %v", name, err)
+		}
+	}
+
+	if _, err := LookupBiobank("finngen"); err == nil {
+		t.Errorf("Expected an error for an unknown biobank")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/carbocation/pfx"
+	"google.golang.org/api/iterator"
+)
+
+// DataSource produces one Result per participant in the censor table for the
+// disease defined by a tabfile. Results must be ordered by has_disease DESC,
+// incident_disease DESC, age_censor ASC.
+type DataSource interface {
+	Results(tabs *TabFile, biobank Biobank) (ResultIterator, error)
+}
+
+// ResultIterator yields Results. Next populates dst, which must be a *Result,
+// and returns iterator.Done when there are no more Results. It is satisfied by
+// *bigquery.RowIterator.
+type ResultIterator interface {
+	Next(dst interface{}) error
+}
+
+// Results builds the query for the tabfile and runs it on BigQuery.
+func (BQ *WrappedBigQuery) Results(tabs *TabFile, biobank Biobank) (ResultIterator, error) {
+	query, err := BuildQuery(BQ, tabs, false, biobank)
+	if err != nil {
+		return nil, err
+	}
+
+	itr, err := query.Read(BQ.Context)
+	if err != nil {
+		return nil, pfx.Err(fmt.Sprint(err.Error(), query.Parameters))
+	}
+
+	return itr, nil
+}
+
+// Disease is a named disease definition. A batch of diseases is evaluated
+// together and emitted as one long table.
+type Disease struct {
+	Name string
+	Tabs *TabFile
+}
+
+// BatchDataSource produces one Result per participant in the censor table for
+// each of many diseases, with Result.Disease set. Results must be grouped by
+// disease, in the order in which the diseases were given, and ordered within
+// each disease like those of a DataSource.
+type BatchDataSource interface {
+	BatchResults(diseases []Disease, biobank Biobank) (ResultIterator, error)
+}
+
+// BatchResults runs one query per BQ.BatchSize diseases (or a single query, if
+// BatchSize is not positive), keeping each query's parameters within
+// BigQuery's request size limits.
+func (BQ *WrappedBigQuery) BatchResults(diseases []Disease, biobank Biobank) (ResultIterator, error) {
+	size := BQ.BatchSize
+	if size <= 0 {
+		size = len(diseases)
+	}
+
+	var batches [][]Disease
+	for start := 0; start < len(diseases); start += size {
+		end := start + size
+		if end > len(diseases) {
+			end = len(diseases)
+		}
+		batches = append(batches, diseases[start:end])
+	}
+
+	return &chainedResults{next: func() (ResultIterator, error) {
+		if len(batches) == 0 {
+			return nil, nil
+		}
+		batch := batches[0]
+		batches = batches[1:]
+
+		query, err := BuildBatchQuery(BQ, batch, false, biobank)
+		if err != nil {
+			return nil, err
+		}
+
+		itr, err := query.Read(BQ.Context)
+		if err != nil {
+			return nil, pfx.Err(err)
+		}
+
+		return itr, nil
+	}}, nil
+}
+
+// chainedResults yields the Results of one iterator after another, opening
+// each only once the prior one is exhausted. next returns a nil iterator when
+// there are no more.
+type chainedResults struct {
+	next    func() (ResultIterator, error)
+	current ResultIterator
+}
+
+func (c *chainedResults) Next(dst interface{}) error {
+	for {
+		if c.current == nil {
+			itr, err := c.next()
+			if err != nil {
+				return err
+			}
+			if itr == nil {
+				return iterator.Done
+			}
+			c.current = itr
+		}
+
+		err := c.current.Next(dst)
+		if err == iterator.Done {
+			c.current = nil
+			continue
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/carbocation/genomisc/ukbb/localtable"
+	"google.golang.org/api/iterator"
+)
+
+// LocalFiles evaluates tabfiles in Go against local copies of the tables that
+// query_template_ukbb.sql reads from BigQuery. Dir must hold censor, phenotype
+// (the output of convertpheno), materialized_hesin_dates,
+// materialized_special_dates, and, if UseGP is set, materialized_gp_dates, each
+// as a .parquet, .tsv, or .csv file (optionally compressed) named after its
+// table.
+type LocalFiles struct {
+	Dir   string
+	UseGP bool
+}
+
+// criterion is one disease's use of a FieldID's value, as an inclusion or an
+// exclusion criterion.
+type criterion struct {
+	Disease int
+	Exclude bool
+}
+
+// valueMatcher mirrors the `hd.FieldID = X AND hd.value IN (...)` clauses that
+// BuildQuery assembles, for any number of diseases at once.
+type valueMatcher map[int64]map[string][]criterion
+
+func newValueMatcher(diseases []Disease, biobank Biobank) valueMatcher {
+	out := make(valueMatcher)
+	for i, disease := range diseases {
+		for _, entries := range [][]TabEntry{disease.Tabs.AllIncluded(), disease.Tabs.AllExcluded()} {
+			for _, entry := range entries {
+				fieldID := int64(entry.FieldID)
+				if out[fieldID] == nil {
+					out[fieldID] = make(map[string][]criterion)
+				}
+				for _, value := range entry.FormattedValues(biobank) {
+					out[fieldID][value] = append(out[fieldID][value], criterion{Disease: i, Exclude: entry.Exclude})
+				}
+			}
+		}
+	}
+
+	return out
+}
+
+func (m valueMatcher) Matches(fieldID int64, value string) []criterion {
+	return m[fieldID][value]
+}
+
+// earliestDates tracks, per participant, the earliest non-NULL date on which a
+// matching value was seen, like MIN(hd.first_date).
+type earliestDates map[int64]bigquery.NullDate
+
+func (e earliestDates) Add(sampleID int64, date bigquery.NullDate) {
+	if !date.Valid {
+		return
+	}
+
+	if prior := e[sampleID]; !prior.Valid || date.Date.Before(prior.Date) {
+		e[sampleID] = date
+	}
+}
+
+func (l *LocalFiles) Results(tabs *TabFile, biobank Biobank) (ResultIterator, error) {
+	diseases := []Disease{{Tabs: tabs}}
+	included, excluded, err := l.firstDates(diseases, biobank)
+	if err != nil {
+		return nil, err
+	}
+
+	censor, err := l.readCensor()
+	if err != nil {
+		return nil, err
+	}
+
+	return &resultSlice{results: evaluateDisease(censor, "", included[0], excluded[0])}, nil
+}
+
+// BatchResults evaluates every disease in a single pass over the tables. The
+// Results of each disease are only assembled once those of the prior disease
+// have been consumed.
+func (l *LocalFiles) BatchResults(diseases []Disease, biobank Biobank) (ResultIterator, error) {
+	included, excluded, err := l.firstDates(diseases, biobank)
+	if err != nil {
+		return nil, err
+	}
+
+	censor, err := l.readCensor()
+	if err != nil {
+		return nil, err
+	}
+
+	i := 0
+	return &chainedResults{next: func() (ResultIterator, error) {
+		if i >= len(diseases) {
+			return nil, nil
+		}
+		results := evaluateDisease(censor, diseases[i].Name, included[i], excluded[i])
+
+		// Free the dates of diseases that have been emitted
+		included[i], excluded[i] = nil, nil
+		i++
+
+		return &resultSlice{results: results}, nil
+	}}, nil
+}
+
+// firstDates reads the tables once and finds, for each disease, the earliest
+// date on which each participant met an inclusion and an exclusion criterion.
+func (l *LocalFiles) firstDates(diseases []Disease, biobank Biobank) (included, excluded []earliestDates, err error) {
+	if biobank.Name() != BiobankSourceUKBiobank {
+		return nil, nil, fmt.Errorf("The local backend only supports the %s biobank source", BiobankSourceUKBiobank)
+	}
+
+	matcher := newValueMatcher(diseases, biobank)
+	included = make([]earliestDates, len(diseases))
+	excluded = make([]earliestDates, len(diseases))
+	for i := range diseases {
+		included[i] = make(earliestDates)
+		excluded[i] = make(earliestDates)
+	}
+
+	record := func(sampleID, fieldID int64, value string, date bigquery.NullDate) {
+		for _, c := range matcher.Matches(fieldID, value) {
+			if c.Exclude {
+				excluded[c.Disease].Add(sampleID, date)
+			} else {
+				included[c.Disease].Add(sampleID, date)
+			}
+		}
+	}
+
+	tables := []string{"materialized_hesin_dates", "materialized_special_dates"}
+	if l.UseGP {
+		tables = append([]string{"materialized_gp_dates"}, tables...)
+	}
+	for _, table := range tables {
+		path, err := localtable.Find(l.Dir, table)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = localtable.ReadDatedFields(path, func(fieldID int64, value string) bool {
+			return len(matcher.Matches(fieldID, value)) > 0
+		}, func(d localtable.DatedField) error {
+			record(d.SampleID, d.FieldID, d.Value, d.FirstDate)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	standardFields := make(map[int64]struct{})
+	for _, disease := range diseases {
+		for _, fieldID := range disease.Tabs.AllStandardFields() {
+			standardFields[int64(fieldID)] = struct{}{}
+		}
+	}
+	if err := l.readUndatedFields(standardFields, record); err != nil {
+		return nil, nil, err
+	}
+
+	return included, excluded, nil
+}
+
+func (l *LocalFiles) readCensor() ([]localtable.Censor, error) {
+	censorPath, err := localtable.Find(l.Dir, "censor")
+	if err != nil {
+		return nil, err
+	}
+
+	return localtable.ReadCensor(censorPath)
+}
+
+// evaluateDisease produces one sorted Result per censored participant.
+func evaluateDisease(censor []localtable.Censor, diseaseName string, included, excluded earliestDates) []Result {
+	results := make([]Result, 0, len(censor))
+	for _, c := range censor {
+		r := evaluateParticipant(c, firstDateStatus(c, included[c.SampleID]), firstDateStatus(c, excluded[c.SampleID]))
+		r.Disease = diseaseName
+		results = append(results, r)
+	}
+
+	sortResults(results)
+
+	return results
+}
+
+// readUndatedFields mimics the undated_fields CTE: values of the diseases'
+// standard (non-dated) fields from the phenotype table are assigned the
+// participant's enrollment date (FieldID 53, instance 0, array_idx 0).
+func (l *LocalFiles) readUndatedFields(standardFields map[int64]struct{}, record func(sampleID, fieldID int64, value string, date bigquery.NullDate)) error {
+	if len(standardFields) == 0 {
+		return nil
+	}
+
+	path, err := localtable.Find(l.Dir, "phenotype")
+	if err != nil {
+		return err
+	}
+
+	enrollDates := make(map[int64]bigquery.NullDate)
+	var candidates []localtable.Phenotype
+	err = localtable.ReadPhenotype(path, func(fieldID int64) bool {
+		_, isStandard := standardFields[fieldID]
+		return isStandard || fieldID == 53
+	}, func(p localtable.Phenotype) error {
+		if p.FieldID == 53 && p.Instance == 0 && p.ArrayIdx == 0 {
+			// The join keeps a participant with an unparseable enrollment
+			// date; only the date itself becomes NULL.
+			date := localtable.SafeParseDate(p.Value)
+			if prior, exists := enrollDates[p.SampleID]; !exists || (date.Valid && (!prior.Valid || date.Date.Before(prior.Date))) {
+				enrollDates[p.SampleID] = date
+			}
+		}
+
+		if _, isStandard := standardFields[p.FieldID]; isStandard {
+			candidates = append(candidates, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range candidates {
+		if date, exists := enrollDates[p.SampleID]; exists {
+			record(p.SampleID, p.FieldID, p.Value, date)
+		}
+	}
+
+	return nil
+}
+
+// diseaseStatus corresponds to one row of the included_only or excluded_only
+// CTEs.
+type diseaseStatus struct {
+	HasDisease       bigquery.NullInt64
+	IncidentDisease  bigquery.NullInt64
+	PrevalentDisease bigquery.NullInt64
+	DateCensor       bigquery.NullDate
+}
+
+func firstDateStatus(c localtable.Censor, first bigquery.NullDate) diseaseStatus {
+	if !first.Valid {
+		return diseaseStatus{
+			HasDisease:       nullInt(0),
+			IncidentDisease:  nullInt(0),
+			PrevalentDisease: nullInt(0),
+			DateCensor:       c.PhenotypeCensorDate,
+		}
+	}
+
+	out := diseaseStatus{
+		HasDisease:       nullInt(1),
+		PrevalentDisease: nullInt(1),
+		DateCensor:       first,
+	}
+	if c.EnrollDate.Valid && first.Date.After(c.EnrollDate.Date) {
+		out.IncidentDisease = nullInt(1)
+		out.PrevalentDisease = nullInt(0)
+	}
+
+	return out
+}
+
+// evaluateParticipant applies the exclusion rules of the final SELECT in
+// query_template_ukbb.sql.
+func evaluateParticipant(c localtable.Censor, io, eo diseaseStatus) Result {
+	r := Result{
+		SampleID:      c.SampleID,
+		BirthDate:     c.BirthDate,
+		EnrollDate:    c.EnrollDate,
+		EnrollAge:     c.EnrollAge,
+		EnrollAgeDays: c.EnrollAgeDays,
+		HasDied:       nullInt(0),
+		DeathDate:     c.DeathCensorDate,
+		DeathAge:      c.DeathCensorAge,
+		DeathAgeDays:  c.DeathCensorAgeDays,
+		ComputedDate:  c.ComputedDate,
+		MissingFields: c.MissingFields,
+	}
+
+	if c.DeathDate.Valid {
+		r.HasDied = nullInt(1)
+		r.DeathDate = c.DeathDate
+		r.DeathAge = c.DeathAge
+		r.DeathAgeDays = c.DeathAgeDays
+	}
+
+	excludedFirst := isOne(eo.HasDisease)
+	includedFirst := isOne(io.HasDisease)
+
+	censorAt := func(date bigquery.NullDate) {
+		r.PhenotypeDateCensor = date
+		days := dateDiff(date, c.BirthDate)
+		if days.Valid {
+			r.RoughPhenotypeAgeCensor = bigquery.NullFloat64{Float64: float64(days.Int64) / 365.25, Valid: true}
+			r.PhenotypeAgeCensorDays = bigquery.NullFloat64{Float64: float64(days.Int64), Valid: true}
+		}
+	}
+
+	switch {
+	case excludedFirst && isPositive(dateDiff(c.EnrollDate, eo.DateCensor)):
+		// Enrollment occurred after exclusion
+		r.MetExclusion = nullInt(1)
+		censorAt(eo.DateCensor)
+	case excludedFirst && includedFirst && isPositive(dateDiff(io.DateCensor, eo.DateCensor)):
+		// Exclusion occurred after enrollment and prior to disease onset
+		r.HasDisease, r.IncidentDisease, r.PrevalentDisease = nullInt(0), nullInt(0), nullInt(0)
+		r.MetExclusion = nullInt(1)
+		censorAt(eo.DateCensor)
+	case excludedFirst && includedFirst && isPositive(dateDiff(eo.DateCensor, io.DateCensor)):
+		// Exclusion occurred after disease onset
+		r.HasDisease, r.IncidentDisease, r.PrevalentDisease = io.HasDisease, io.IncidentDisease, io.PrevalentDisease
+		r.MetExclusion = nullInt(0)
+		censorAt(io.DateCensor)
+	case excludedFirst && !includedFirst:
+		// Met exclusion but no inclusion
+		r.HasDisease, r.IncidentDisease, r.PrevalentDisease = nullInt(0), nullInt(0), nullInt(0)
+		r.MetExclusion = nullInt(1)
+		censorAt(eo.DateCensor)
+	default:
+		// Every censored participant has an included_only row, so the SQL's
+		// `io.has_disease IS NULL` branch cannot be reached here.
+		r.HasDisease, r.IncidentDisease, r.PrevalentDisease = io.HasDisease, io.IncidentDisease, io.PrevalentDisease
+		r.MetExclusion = nullInt(0)
+		censorAt(io.DateCensor)
+	}
+
+	return r
+}
+
+// sortResults orders results as BigQuery does for `ORDER BY has_disease DESC,
+// incident_disease DESC, age_censor ASC` (NULLs last when descending, first
+// when ascending). Ties, which BigQuery leaves in arbitrary order, are broken
+// by sample_id.
+func sortResults(results []Result) {
+	descending := func(a, b bigquery.NullInt64) int {
+		switch {
+		case a.Valid && b.Valid && a.Int64 != b.Int64:
+			if a.Int64 > b.Int64 {
+				return -1
+			}
+			return 1
+		case a.Valid != b.Valid:
+			if a.Valid {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if cmp := descending(a.HasDisease, b.HasDisease); cmp != 0 {
+			return cmp < 0
+		}
+		if cmp := descending(a.IncidentDisease, b.IncidentDisease); cmp != 0 {
+			return cmp < 0
+		}
+		x, y := a.RoughPhenotypeAgeCensor, b.RoughPhenotypeAgeCensor
+		if x.Valid != y.Valid {
+			return !x.Valid
+		}
+		if x.Valid && x.Float64 != y.Float64 {
+			return x.Float64 < y.Float64
+		}
+		return a.SampleID < b.SampleID
+	})
+}
+
+type resultSlice struct {
+	results []Result
+	next    int
+}
+
+func (s *resultSlice) Next(dst interface{}) error {
+	if s.next >= len(s.results) {
+		return iterator.Done
+	}
+
+	r, ok := dst.(*Result)
+	if !ok {
+		return fmt.Errorf("Expected a *Result, got %T", dst)
+	}
+	*r = s.results[s.next]
+	s.next++
+
+	return nil
+}
+
+// dateDiff is DATE_DIFF(a, b, DAY), which is NULL if either date is NULL.
+func dateDiff(a, b bigquery.NullDate) bigquery.NullInt64 {
+	if !a.Valid || !b.Valid {
+		return bigquery.NullInt64{}
+	}
+
+	return nullInt(int64(a.Date.DaysSince(b.Date)))
+}
+
+func nullInt(v int64) bigquery.NullInt64 {
+	return bigquery.NullInt64{Int64: v, Valid: true}
+}
+
+func isOne(v bigquery.NullInt64) bool {
+	return v.Valid && v.Int64 == 1
+}
+
+func isPositive(v bigquery.NullInt64) bool {
+	return v.Valid && v.Int64 > 0
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/iterator"
+)
+
+func writeLocalTables(t *testing.T, tables map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, contents := range tables {
+		if err := os.WriteFile(filepath.Join(dir, name+".tsv"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestLocalFilesResults(t *testing.T) {
+	dir := writeLocalTables(t, map[string]string{
+		"censor": "sample_id\tbirthdate\tenroll_date\tenroll_age\tenroll_age_days\tdeath_date\tdeath_censor_date\tphenotype_censor_date\tphenotype_censor_age\tphenotype_censor_age_days\n" +
+			"1\t1950-01-01\t2008-01-01\t58\t21184\t\t2020-01-01\t2020-01-01\t70\t25567\n" +
+			"2\t1950-01-01\t2008-01-01\t58\t21184\t\t2020-01-01\t2020-01-01\t70\t25567\n" +
+			"3\t1950-01-01\t2008-01-01\t58\t21184\t\t2020-01-01\t2020-01-01\t70\t25567\n" +
+			"4\t1950-01-01\t2008-01-01\t58\t21184\t2016-05-05\t2020-01-01\t2020-01-01\t70\t25567\n" +
+			"5\t1950-01-01\t2008-01-01\t58\t21184\t\t2020-01-01\t2020-01-01\t70\t25567\n" +
+			"6\t1950-01-01\t2008-01-01\t58\t21184\t\t2020-01-01\t2020-01-01\t70\t25567\n" +
+			"7\t1940-01-01\t2009-01-01\t69\t25203\t\t2020-01-01\t2020-01-01\t80\t29220\n" +
+			"8\t1950-01-01\t2008-01-01\t58\t21184\t\t2020-01-01\t2020-01-01\t70\t25567\n",
+		"phenotype": "sample_id\tFieldID\tinstance\tarray_idx\tvalue\tcoding_file_id\n" +
+			"7\t53\t0\t0\t2009-01-01\t\n" +
+			"7\t2453\t0\t0\t1\t\n" +
+			"8\t2453\t0\t0\t0\t\n",
+		"materialized_hesin_dates": "sample_id\tFieldID\tvalue\tfirst_date\n" +
+			"2\t41202\tI21\t2012-03-03\n" +
+			"2\t41202\tI21\t2013-03-03\n" +
+			"3\t41202\tI21\t2001-01-01\n" +
+			"4\t41202\tI25\t2005-01-01\n" +
+			"4\t41202\tI21\t2010-01-01\n" +
+			"5\t41202\tI21\t2012-01-01\n" +
+			"5\t41202\tI25\t2014-01-01\n" +
+			"6\t41202\tI25\t2011-01-01\n" +
+			"6\t41202\tI21\t2013-01-01\n",
+		"materialized_special_dates": "sample_id\tFieldID\tvalue\tfirst_date\n" +
+			"1\t20002\t1065\t2015-01-01\n",
+	})
+
+	tabPath := filepath.Join(dir, "mi.tab")
+	tab := "FieldID\tvalues\texclude\n" +
+		"41202\tI21\t0\n" +
+		"2453\t1\t0\n" +
+		"41202\tI25\t1\n"
+	if err := os.WriteFile(tabPath, []byte(tab), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tabs, err := ParseTabFile(tabPath, UKBiobank{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	itr, err := (&LocalFiles{Dir: dir}).Results(tabs, UKBiobank{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sample_id has_disease incident_disease prevalent_disease met_exclusion date_censor age_censor_days
+	expected := []string{
+		"5 1 1 0 0 2012-01-01 22645",
+		"2 1 1 0 0 2012-03-03 22707",
+		"3 1 NULL 1 0 2001-01-01 18628",
+		"7 1 NULL 1 0 2009-01-01 25203",
+		"6 0 0 0 1 2011-01-01 22280",
+		"1 0 0 0 0 2020-01-01 25567",
+		"8 0 0 0 0 2020-01-01 25567",
+		"4 NULL NULL NULL 1 2005-01-01 20089",
+	}
+
+	for i := 0; ; i++ {
+		var r Result
+		err := itr.Next(&r)
+		if err == iterator.Done {
+			if i != len(expected) {
+				t.Fatalf("Expected %d rows, got %d", len(expected), i)
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		got := strings.Join([]string{
+			strconv.FormatInt(r.SampleID, 10), r.HasDisease.String(), r.IncidentDisease.String(), r.PrevalentDisease.String(), r.MetExclusion.String(), r.PhenotypeDateCensor.String(), r.PhenotypeAgeCensorDays.String(),
+		}, " ")
+		if i >= len(expected) {
+			t.Fatalf("Unexpected row %d: %q", i, got)
+		}
+		if got != expected[i] {
+			t.Errorf("Row %d: expected %q, got %q", i, expected[i], got)
+		}
+
+		if r.SampleID == 4 && (r.HasDied.Int64 != 1 || r.DeathDate.String() != "2016-05-05") {
+			t.Errorf("Expected sample 4 to have died on 2016-05-05, got %v %v", r.HasDied, r.DeathDate)
+		}
+	}
+}
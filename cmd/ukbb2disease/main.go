@@ -12,6 +12,7 @@ import (
 
 	"cloud.google.com/go/bigquery"
 	_ "github.com/carbocation/genomisc/compileinfoprint"
+	"github.com/carbocation/genomisc/ukbb"
 )
 
 type WrappedBigQuery struct {
@@ -21,6 +22,9 @@ type WrappedBigQuery struct {
 	Database       string
 	MaterializedDB string
 	UseGP          bool
+
+	// Number of diseases per query when evaluating many diseases at once
+	BatchSize int
 }
 
 // Special value that is to be set using ldflags
@@ -36,7 +40,7 @@ var (
 func init() {
 	flag.Usage = func() {
 		flag.PrintDefaults()
-		describeDateFields(false)
+		ukbb.DescribeDateFields(false)
 	}
 }
 
@@ -53,6 +57,12 @@ func main() {
 	var diseaseName string
 	var verbose bool
 	var biobankSource string
+	var localDir string
+	var codingPath string
+	var phecodeICD10, phecodeICD9 string
+	var phecodeRollUp bool
+	var medicationPath, atcMapPath string
+	var exposure ExposureSettings
 
 	flag.StringVar(&BQ.Project, "project", "", "Google Cloud project you want to use for billing purposes only")
 	flag.StringVar(&BQ.Database, "database", "", "BigQuery source database name (note: must be formatted as project.database, e.g., ukbb-analyses.ukbb7089_201904)")
@@ -63,48 +73,97 @@ func main() {
 	flag.BoolVar(&allowUndated, "allow-undated", false, "Force run, even if your tabfile has fields whose date is unknown (which will cause matching participants to be set to prevalent)?")
 	flag.BoolVar(&verbose, "verbose", false, "Print all ~ 2,000 fields whose dates are known?")
 	flag.StringVar(&diseaseName, "disease", "", "If not specified, the tabfile will be parsed and become the disease name.")
-	flag.StringVar(&biobankSource, "biobank_source", BiobankSourceUKBiobank, "")
+	flag.StringVar(&biobankSource, "biobank_source", BiobankSourceUKBiobank, fmt.Sprintf("Layout of the source data. Options include: %s. With %s, tabfiles may name OMOP vocabularies (e.g., ICD10CM) or use the UK Biobank FieldIDs of ICD and OPCS codes.", strings.Join(BiobankNames(), ", "), BiobankSourceOMOP))
 	flag.BoolVar(&BQ.UseGP, "usegp", false, "")
+	flag.StringVar(&localDir, "local", "", "(Optional) Directory holding local copies of the censor, phenotype, materialized_hesin_dates, materialized_special_dates, (with -usegp) materialized_gp_dates, and (with -medications) gp_scripts tables, as .parquet, .tsv, or .csv files named after the table. If set, the tabfile is evaluated locally instead of with BigQuery, and -project and -database are not needed.")
+	flag.StringVar(&codingPath, "coding", "", "(Optional) URL or path to comma-delimited file with the UKBB data encodings (Codings.csv, e.g., https://biobank.ctsu.ox.ac.uk/~bbdatan/Codings.csv). Required if the tabfile uses wildcards (I21*), ranges (I20-I25), or blocks (Block I20-I25) of ICD-10, ICD-9, or OPCS-4 codes, which are expanded with the coding trees.")
+	flag.StringVar(&phecodeICD10, "phecode-icd10", "", "(Optional) Path to the Phecode ICD-10 map (e.g., phecode_icd10.csv, with ICD10, PheCode, and Excl. Phecodes columns). If this or -phecode-icd9 is set, one disease per phecode is produced instead of processing a tabfile.")
+	flag.StringVar(&phecodeICD9, "phecode-icd9", "", "(Optional) Path to the Phecode ICD-9 map (e.g., phecode_icd9_rolled.csv, with ICD9, PheCode, and Excl. Phecodes columns).")
+	flag.BoolVar(&phecodeRollUp, "phecode-rollup", true, "Count codes mapped to a phecode (e.g., 250.21) toward its parent phecodes (250.2 and 250)?")
+	flag.StringVar(&medicationPath, "medications", "", "(Optional) Path to a tab-delimited medication definition file with the columns medication, source, and values, where source is name (drug name), dmd, bnf, read2, atc, or 20003 (self-reported medication). If set, exposure to each medication is produced from gp_scripts and FieldID 20003 instead of processing a tabfile.")
+	flag.StringVar(&atcMapPath, "atc-map", "", "(Optional) Path to a map from codes to ATC codes, with the columns source (dmd, bnf, read2, or 20003), code, and atc. Required if -medications uses ATC classes.")
+	flag.IntVar(&exposure.ScriptDays, "script-days", 28, "Days of exposure assumed for each prescription when computing cumulative exposure.")
+	flag.IntVar(&exposure.CurrentWindowDays, "current-window-days", 90, "A prescription issued within this many days before enrollment makes a participant a current user at enrollment.")
+	flag.IntVar(&BQ.BatchSize, "batch-size", 100, "Number of phecodes per BigQuery query. (With -local, all phecodes are evaluated in one pass.)")
 	flag.Parse()
 
 	flag.Usage = func() {
 		flag.PrintDefaults()
-		describeDateFields(verbose)
+		ukbb.DescribeDateFields(verbose)
 	}
 
 	// Deprecating BQ.MaterializedDB - adds unnecessary complexity; reasonable
 	// to assume that all data is in the same database
 	BQ.MaterializedDB = BQ.Database
 
-	if BQ.Project == "" {
+	if BQ.Project == "" && localDir == "" {
 		fmt.Fprintln(os.Stderr, "Please provide --project")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if BQ.Database == "" {
+	if BQ.Database == "" && localDir == "" {
 		fmt.Fprintln(os.Stderr, "Please provide --database")
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	if localDir != "" && displayQuery {
+		fmt.Fprintln(os.Stderr, "--display-query is not available with --local")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	biobank, err := LookupBiobank(biobankSource)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if medicationPath != "" {
+		if err := runMedications(BQ, localDir, medicationPath, atcMapPath, exposure, displayQuery, biobank); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if phecodeICD10 != "" || phecodeICD9 != "" {
+		if err := runPhecodes(BQ, localDir, phecodeICD10, phecodeICD9, phecodeRollUp, displayQuery, biobank); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	if tabfile == "" {
 		fmt.Fprintln(os.Stderr, "Please provide --tabfile")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if BQ.MaterializedDB == "" {
+	if BQ.MaterializedDB == "" && localDir == "" {
 		fmt.Fprintln(os.Stderr, "Please provide --materialized")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	tabs, err := ParseTabFile(tabfile, biobankSource)
+	tabs, err := ParseTabFile(tabfile, biobank)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
+	var codingTrees map[string]*ukbb.CodingTree
+	if codingPath != "" {
+		codingTrees, err = ukbb.ReadCodingTrees(codingPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if err := tabs.ExpandCodes(codingTrees); err != nil {
+		log.Fatalln(err)
+	}
+
 	if diseaseName == "" {
 		diseaseName = path.Base(tabfile)
 		if parts := strings.Split(diseaseName, "."); len(parts) > 1 {
@@ -136,7 +195,7 @@ func main() {
 
 	fmt.Fprintln(os.Stderr, "Including:")
 	for _, v := range tabs.AllIncluded() {
-		if DoesBiobankUseNumericFieldID(biobankSource) {
+		if v.FieldName == "" {
 			fmt.Fprintf(os.Stderr, "\tFieldID %v values:\n", v.FieldID)
 		} else {
 			fmt.Fprintf(os.Stderr, "\tFieldName %v values:\n", v.FieldName)
@@ -149,7 +208,7 @@ func main() {
 
 	fmt.Fprintln(os.Stderr, "Excluding:")
 	for _, v := range tabs.AllExcluded() {
-		if DoesBiobankUseNumericFieldID(biobankSource) {
+		if v.FieldName == "" {
 			fmt.Fprintf(os.Stderr, "\tFieldID %v values:\n", v.FieldID)
 		} else {
 			fmt.Fprintf(os.Stderr, "\tFieldName %v values:\n", v.FieldName)
@@ -162,24 +221,112 @@ func main() {
 		fmt.Fprintf(os.Stderr, "\t(No exclusion criteria)\n")
 	}
 
-	BQ.Client, err = bigquery.NewClient(BQ.Context, BQ.Project)
+	var src DataSource = BQ
+	if localDir != "" {
+		src = &LocalFiles{Dir: localDir, UseGP: BQ.UseGP}
+	} else {
+		BQ.Client, err = bigquery.NewClient(BQ.Context, BQ.Project)
+		if err != nil {
+			log.Fatalln("Connecting to BigQuery:", err)
+		}
+		defer BQ.Client.Close()
+
+		if displayQuery {
+			if _, err := BuildQuery(BQ, tabs, displayQuery, biobank); err != nil {
+				log.Fatalln(diseaseName, err)
+			}
+			return
+		}
+	}
+
+	if err := ExecuteQuery(src, tabs, biobank, diseaseName, missingFields); err != nil {
+		log.Fatalln(diseaseName, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Finished producing output for %s\n", diseaseName)
+}
+
+// runPhecodes produces one long table with a disease per phecode.
+func runPhecodes(BQ *WrappedBigQuery, localDir, icd10Path, icd9Path string, rollUp, displayQuery bool, biobank Biobank) error {
+	diseases, err := ReadPhecodeDiseases(icd10Path, icd9Path, rollUp, biobank)
 	if err != nil {
-		log.Fatalln("Connecting to BigQuery:", err)
+		return err
 	}
-	defer BQ.Client.Close()
 
-	query, err := BuildQuery(BQ, tabs, displayQuery, biobankSource)
+	log.Printf("Processing %d phecodes\n", len(diseases))
+
+	var src BatchDataSource = BQ
+	if localDir != "" {
+		src = &LocalFiles{Dir: localDir, UseGP: BQ.UseGP}
+	} else {
+		BQ.Client, err = bigquery.NewClient(BQ.Context, BQ.Project)
+		if err != nil {
+			return fmt.Errorf("Connecting to BigQuery: %v", err)
+		}
+		defer BQ.Client.Close()
+
+		if displayQuery {
+			// Show the query for the first batch only; the others differ only
+			// in their parameters.
+			batch := diseases
+			if BQ.BatchSize > 0 && BQ.BatchSize < len(batch) {
+				batch = batch[:BQ.BatchSize]
+			}
+			_, err := BuildBatchQuery(BQ, batch, displayQuery, biobank)
+			return err
+		}
+	}
+
+	if err := ExecuteBatch(src, diseases, biobank); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Finished producing output for %d phecodes\n", len(diseases))
+
+	return nil
+}
+
+// runMedications produces one long table with each participant's exposure to
+// each medication.
+func runMedications(BQ *WrappedBigQuery, localDir, medicationPath, atcMapPath string, settings ExposureSettings, displayQuery bool, biobank Biobank) error {
+	if biobank.Name() != BiobankSourceUKBiobank {
+		return fmt.Errorf("Medications are only available for biobank source %s", BiobankSourceUKBiobank)
+	}
+	if settings.ScriptDays < 1 || settings.CurrentWindowDays < 1 {
+		return fmt.Errorf("-script-days and -current-window-days must be positive")
+	}
+
+	defs, err := ReadMedicationDefinitions(medicationPath)
 	if err != nil {
-		log.Fatalln(diseaseName, err)
+		return err
+	}
+	if err := ExpandATC(defs, atcMapPath); err != nil {
+		return err
 	}
 
-	if displayQuery {
-		return
+	log.Printf("Processing %d medications\n", len(defs))
+
+	var src MedicationSource = BQ
+	if localDir != "" {
+		src = &LocalFiles{Dir: localDir}
+	} else {
+		BQ.Client, err = bigquery.NewClient(BQ.Context, BQ.Project)
+		if err != nil {
+			return fmt.Errorf("Connecting to BigQuery: %v", err)
+		}
+		defer BQ.Client.Close()
+
+		if displayQuery {
+			_, err := BuildMedicationQuery(BQ, defs, displayQuery)
+			return err
+		}
 	}
 
-	if err := ExecuteQuery(BQ, query, diseaseName, missingFields); err != nil {
-		log.Fatalln(diseaseName, err)
+	if err := ExecuteMedications(src, defs, settings); err != nil {
+		return err
 	}
 
-	fmt.Fprintf(os.Stderr, "Finished producing output for %s\n", diseaseName)
+	fmt.Fprintf(os.Stderr, "Finished producing output for %d medications\n", len(defs))
+
+	return nil
 }
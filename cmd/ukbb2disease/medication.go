@@ -0,0 +1,526 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/carbocation/genomisc/ukbb/localtable"
+)
+
+// The ways in which a medication can be defined. Drug names match
+// case-insensitive substrings of the prescribed drug's name. BNF and Read v2
+// codes match as prefixes, so that a BNF section (e.g., 0212 for
+// lipid-regulating drugs) or a Read v2 drug class (e.g., bx for statins)
+// matches every drug within it. dm+d codes and the coding of FieldID 20003
+// match exactly. ATC classes are expanded into the other codes with an ATC
+// map.
+const (
+	MedicationSourceName       = "name"
+	MedicationSourceDMD        = "dmd"
+	MedicationSourceBNF        = "bnf"
+	MedicationSourceRead2      = "read2"
+	MedicationSourceSelfReport = "20003"
+	MedicationSourceATC        = "atc"
+)
+
+var medicationSources = []string{
+	MedicationSourceName,
+	MedicationSourceDMD,
+	MedicationSourceBNF,
+	MedicationSourceRead2,
+	MedicationSourceSelfReport,
+	MedicationSourceATC,
+}
+
+// FieldIDSelfReportedMedication is the UK Biobank's "Treatment/medication
+// code", collected at the verbal interview of each assessment visit.
+const FieldIDSelfReportedMedication = 20003
+
+// MedicationDefinition is a named exposure (e.g., statin) along with the
+// values of each source that identify it.
+type MedicationDefinition struct {
+	Name   string
+	Values map[string][]string
+}
+
+// ReadMedicationDefinitions parses a tab-delimited file with a header and the
+// columns medication, source, and values (comma-separated). A medication may
+// span many rows.
+func ReadMedicationDefinitions(path string) ([]*MedicationDefinition, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fileCSV := csv.NewReader(f)
+	fileCSV.Comma = '\t'
+	fileCSV.Comment = '#'
+
+	recs, err := fileCSV.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*MedicationDefinition
+	byName := make(map[string]*MedicationDefinition)
+	for i, row := range recs {
+		if l := len(row); l != 3 {
+			return nil, fmt.Errorf("Medication file %s row %d had %d columns, expected 3", path, i, l)
+		}
+
+		if i == 0 {
+			// header
+			continue
+		}
+
+		name, source := strings.TrimSpace(row[0]), strings.ToLower(strings.TrimSpace(row[1]))
+		if !isMedicationSource(source) {
+			return nil, fmt.Errorf("Medication file %s row %d: source %q is not one of %s", path, i, row[1], strings.Join(medicationSources, ", "))
+		}
+
+		def, exists := byName[name]
+		if !exists {
+			def = &MedicationDefinition{Name: name, Values: make(map[string][]string)}
+			byName[name] = def
+			out = append(out, def)
+		}
+
+		for _, value := range strings.Split(row[2], ",") {
+			if value = normalizeMedicationValue(source, value); value != "" {
+				def.Values[source] = append(def.Values[source], value)
+			}
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("Medication file %s defines no medications", path)
+	}
+
+	return out, nil
+}
+
+func isMedicationSource(source string) bool {
+	for _, known := range medicationSources {
+		if source == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeMedicationValue puts a value in the form in which it is compared:
+// names are lowercased, and spaces and dots are removed from codes (BNF codes,
+// e.g., are written both as 02.12.00.00 and as 0212000).
+func normalizeMedicationValue(source, value string) string {
+	value = strings.TrimSpace(value)
+	if source == MedicationSourceName {
+		return strings.ToLower(value)
+	}
+
+	return strings.NewReplacer(".", "", " ", "").Replace(value)
+}
+
+// ExpandATC replaces the ATC classes of each medication with the codes that
+// the ATC map assigns to them. The map has the columns source (one of the
+// sources other than atc), code, and atc; a code belongs to every class that
+// is a prefix of its ATC code.
+func ExpandATC(defs []*MedicationDefinition, atcMapPath string) error {
+	needed := false
+	for _, def := range defs {
+		needed = needed || len(def.Values[MedicationSourceATC]) > 0
+	}
+	if !needed {
+		return nil
+	}
+	if atcMapPath == "" {
+		return fmt.Errorf("Medications defined by ATC class require -atc-map")
+	}
+
+	t, err := localtable.Open(atcMapPath)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	cols, err := localtable.Columns(t, "source", "code", "atc")
+	if err != nil {
+		return fmt.Errorf("%s: %v", atcMapPath, err)
+	}
+
+	for line := 2; ; line++ {
+		row, err := t.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", atcMapPath, err)
+		}
+
+		source := strings.ToLower(strings.TrimSpace(row[cols[0]]))
+		if !isMedicationSource(source) || source == MedicationSourceATC {
+			return fmt.Errorf("%s line %d: source %q cannot be mapped to ATC", atcMapPath, line, row[cols[0]])
+		}
+		atc := strings.ToUpper(strings.TrimSpace(row[cols[2]]))
+
+		for _, def := range defs {
+			for _, class := range def.Values[MedicationSourceATC] {
+				if strings.HasPrefix(atc, strings.ToUpper(class)) {
+					def.Values[source] = append(def.Values[source], normalizeMedicationValue(source, row[cols[1]]))
+					break
+				}
+			}
+		}
+	}
+
+	for _, def := range defs {
+		if classes := def.Values[MedicationSourceATC]; len(classes) > 0 {
+			delete(def.Values, MedicationSourceATC)
+			if len(def.Values) == 0 {
+				return fmt.Errorf("No codes in %s belong to the ATC classes %v of %s", atcMapPath, classes, def.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Script is one row of the gp_scripts table.
+type Script struct {
+	SampleID  int64
+	IssueDate bigquery.NullDate
+	DrugName  string
+	Read2     string
+	BNF       string
+	DMD       string
+}
+
+// medicationMatcher mirrors the matching of the definitions CTE in
+// query_template_ukbb_medication.sql.
+type medicationMatcher struct {
+	prefixes   map[string][]medicationValue
+	names      []medicationValue
+	dmd        map[string][]int
+	selfReport map[string][]int
+}
+
+type medicationValue struct {
+	Value      string
+	Medication int
+}
+
+func newMedicationMatcher(defs []*MedicationDefinition) *medicationMatcher {
+	m := &medicationMatcher{
+		prefixes:   make(map[string][]medicationValue),
+		dmd:        make(map[string][]int),
+		selfReport: make(map[string][]int),
+	}
+
+	for i, def := range defs {
+		for source, values := range def.Values {
+			for _, value := range values {
+				switch source {
+				case MedicationSourceName:
+					m.names = append(m.names, medicationValue{value, i})
+				case MedicationSourceBNF, MedicationSourceRead2:
+					m.prefixes[source] = append(m.prefixes[source], medicationValue{value, i})
+				case MedicationSourceDMD:
+					m.dmd[value] = append(m.dmd[value], i)
+				case MedicationSourceSelfReport:
+					m.selfReport[value] = append(m.selfReport[value], i)
+				}
+			}
+		}
+	}
+
+	return m
+}
+
+// Script returns the medications that a prescription matches, each once.
+func (m *medicationMatcher) Script(s Script) []int {
+	seen := make(map[int]struct{})
+	var out []int
+	add := func(i int) {
+		if _, exists := seen[i]; !exists {
+			seen[i] = struct{}{}
+			out = append(out, i)
+		}
+	}
+
+	name := strings.ToLower(s.DrugName)
+	for _, v := range m.names {
+		if strings.Contains(name, v.Value) {
+			add(v.Medication)
+		}
+	}
+
+	codes := map[string]string{
+		MedicationSourceBNF:   normalizeMedicationValue(MedicationSourceBNF, s.BNF),
+		MedicationSourceRead2: normalizeMedicationValue(MedicationSourceRead2, s.Read2),
+	}
+	for source, code := range codes {
+		for _, v := range m.prefixes[source] {
+			if code != "" && strings.HasPrefix(code, v.Value) {
+				add(v.Medication)
+			}
+		}
+	}
+
+	for _, i := range m.dmd[normalizeMedicationValue(MedicationSourceDMD, s.DMD)] {
+		add(i)
+	}
+
+	sort.Ints(out)
+
+	return out
+}
+
+// SelfReport returns the medications that a value of FieldID 20003 matches.
+func (m *medicationMatcher) SelfReport(value string) []int {
+	return m.selfReport[normalizeMedicationValue(MedicationSourceSelfReport, value)]
+}
+
+// Kinds of medicationRow.
+const (
+	medicationRowParticipant = "participant"
+	medicationRowCoverage    = "coverage"
+	medicationRowScript      = "script"
+	medicationRowSelfReport  = "self_report"
+)
+
+// medicationRow is one row of query_template_ukbb_medication.sql. Participant
+// rows come from the censor table; coverage rows mark participants with any
+// GP prescription; script and self_report rows are uses of a medication, dated
+// by the issue date or by the date of the assessment visit.
+type medicationRow struct {
+	Kind       string             `bigquery:"kind"`
+	Medication bigquery.NullInt64 `bigquery:"medication_order"`
+	SampleID   int64              `bigquery:"sample_id"`
+	Date       bigquery.NullDate  `bigquery:"date"`
+	Instance   bigquery.NullInt64 `bigquery:"instance"`
+	BirthDate  bigquery.NullDate  `bigquery:"birthdate"`
+	EnrollDate bigquery.NullDate  `bigquery:"enroll_date"`
+}
+
+// MedicationSource produces the rows from which medication exposures are
+// computed.
+type MedicationSource interface {
+	MedicationRows(defs []*MedicationDefinition, fn func(medicationRow) error) error
+}
+
+// ExposureSettings control how prescriptions are turned into exposure.
+type ExposureSettings struct {
+	// Number of days of exposure assumed for each prescription, since
+	// gp_scripts does not record a duration
+	ScriptDays int
+
+	// A participant is a current user at enrollment if a prescription was
+	// issued within this many days before (or on) the enrollment date
+	CurrentWindowDays int
+}
+
+type selfReport struct {
+	Instance int64
+	Date     bigquery.NullDate
+}
+
+type exposure struct {
+	Scripts     []civil.Date
+	SelfReports []selfReport
+}
+
+// validScriptDate rejects the placeholder dates that the UK Biobank uses in
+// the primary care data (1901-01-01, 1902-02-02, and 1903-03-03 for dates
+// before, on, and after birth, and 2037-07-07 for dates in the future).
+func validScriptDate(date bigquery.NullDate) bool {
+	return date.Valid && date.Date.Year > 1903 && date.Date.Year < 2037
+}
+
+// ExecuteMedications computes and prints the exposure of every participant in
+// the censor table to each medication.
+func ExecuteMedications(src MedicationSource, defs []*MedicationDefinition, settings ExposureSettings) error {
+	var participants []medicationRow
+	covered := make(map[int64]struct{})
+	exposures := make([]map[int64]*exposure, len(defs))
+	for i := range exposures {
+		exposures[i] = make(map[int64]*exposure)
+	}
+
+	get := func(r medicationRow) (*exposure, error) {
+		if !r.Medication.Valid || r.Medication.Int64 < 0 || int(r.Medication.Int64) >= len(defs) {
+			return nil, fmt.Errorf("Unexpected medication %v for sample %d", r.Medication, r.SampleID)
+		}
+		e := exposures[r.Medication.Int64][r.SampleID]
+		if e == nil {
+			e = &exposure{}
+			exposures[r.Medication.Int64][r.SampleID] = e
+		}
+		return e, nil
+	}
+
+	err := src.MedicationRows(defs, func(r medicationRow) error {
+		switch r.Kind {
+		case medicationRowParticipant:
+			participants = append(participants, r)
+		case medicationRowCoverage:
+			covered[r.SampleID] = struct{}{}
+		case medicationRowScript:
+			if !validScriptDate(r.Date) {
+				return nil
+			}
+			e, err := get(r)
+			if err != nil {
+				return err
+			}
+			e.Scripts = append(e.Scripts, r.Date.Date)
+		case medicationRowSelfReport:
+			e, err := get(r)
+			if err != nil {
+				return err
+			}
+			e.SelfReports = append(e.SelfReports, selfReport{Instance: r.Instance.Int64, Date: r.Date})
+		default:
+			return fmt.Errorf("Unexpected row kind %q", r.Kind)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(participants, func(i, j int) bool { return participants[i].SampleID < participants[j].SampleID })
+	log.Printf("Computing exposure to %d medications for %d participants, %d of whom have GP prescriptions\n", len(defs), len(participants), len(covered))
+
+	todayDate := time.Now().Format("2006-01-02")
+	fmt.Fprintln(STDOUT, strings.Join([]string{"medication", "sample_id", "ever_use", "ever_use_at_enrollment", "current_use_at_enrollment", "self_reported_at_enrollment", "incident_use", "first_use_date", "first_use_age_days", "first_prescription_date", "last_prescription_date", "n_prescriptions", "cumulative_exposure_days", "has_gp_scripts", "birthdate", "enroll_date", "enroll_age_days", "computed_date"}, "\t"))
+	for i, def := range defs {
+		for _, p := range participants {
+			_, hasGP := covered[p.SampleID]
+			s := summarizeExposure(exposures[i][p.SampleID], p, settings)
+
+			fmt.Fprintf(STDOUT, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n",
+				def.Name, p.SampleID, NA(s.EverUse), NA(s.EverUseAtEnrollment), NA(s.CurrentUseAtEnrollment), NA(s.SelfReportedAtEnrollment), NA(s.IncidentUse),
+				NA(s.FirstUseDate), NA(dateDiff(s.FirstUseDate, p.BirthDate)), NA(s.FirstPrescriptionDate), NA(s.LastPrescriptionDate), s.NPrescriptions, s.CumulativeExposureDays,
+				boolInt(hasGP), NA(p.BirthDate), NA(p.EnrollDate), NA(dateDiff(p.EnrollDate, p.BirthDate)), todayDate)
+		}
+	}
+
+	return nil
+}
+
+// exposureSummary is one participant's exposure to one medication.
+type exposureSummary struct {
+	EverUse                  bigquery.NullInt64
+	EverUseAtEnrollment      bigquery.NullInt64
+	CurrentUseAtEnrollment   bigquery.NullInt64
+	SelfReportedAtEnrollment bigquery.NullInt64
+	IncidentUse              bigquery.NullInt64
+	FirstUseDate             bigquery.NullDate
+	FirstPrescriptionDate    bigquery.NullDate
+	LastPrescriptionDate     bigquery.NullDate
+	NPrescriptions           int
+	CumulativeExposureDays   int
+}
+
+func summarizeExposure(e *exposure, p medicationRow, settings ExposureSettings) exposureSummary {
+	out := exposureSummary{
+		EverUse:                  nullInt(0),
+		EverUseAtEnrollment:      nullInt(0),
+		CurrentUseAtEnrollment:   nullInt(0),
+		SelfReportedAtEnrollment: nullInt(0),
+		IncidentUse:              nullInt(0),
+	}
+	if e == nil {
+		return out
+	}
+	out.EverUse = nullInt(1)
+
+	scripts := append([]civil.Date(nil), e.Scripts...)
+	sort.Slice(scripts, func(i, j int) bool { return scripts[i].Before(scripts[j]) })
+	out.NPrescriptions = len(scripts)
+
+	// Each prescription covers ScriptDays days from its issue date, and
+	// overlapping prescriptions are merged.
+	var coveredUntil civil.Date
+	for i, d := range scripts {
+		end := d.AddDays(settings.ScriptDays)
+		switch {
+		case i == 0 || !d.Before(coveredUntil):
+			out.CumulativeExposureDays += settings.ScriptDays
+		case end.After(coveredUntil):
+			out.CumulativeExposureDays += end.DaysSince(coveredUntil)
+		}
+		if i == 0 || end.After(coveredUntil) {
+			coveredUntil = end
+		}
+	}
+
+	firstUse := func(d bigquery.NullDate) {
+		if d.Valid && (!out.FirstUseDate.Valid || d.Date.Before(out.FirstUseDate.Date)) {
+			out.FirstUseDate = d
+		}
+	}
+	if len(scripts) > 0 {
+		out.FirstPrescriptionDate = bigquery.NullDate{Date: scripts[0], Valid: true}
+		out.LastPrescriptionDate = bigquery.NullDate{Date: scripts[len(scripts)-1], Valid: true}
+		firstUse(out.FirstPrescriptionDate)
+	}
+	for _, r := range e.SelfReports {
+		firstUse(r.Date)
+		if r.Instance == 0 {
+			// Medications reported at the baseline interview are taken
+			// regularly at enrollment.
+			out.SelfReportedAtEnrollment = nullInt(1)
+			out.EverUseAtEnrollment = nullInt(1)
+			out.CurrentUseAtEnrollment = nullInt(1)
+		}
+	}
+
+	if !p.EnrollDate.Valid {
+		if !isOne(out.SelfReportedAtEnrollment) {
+			out.EverUseAtEnrollment, out.CurrentUseAtEnrollment = bigquery.NullInt64{}, bigquery.NullInt64{}
+		}
+		out.IncidentUse = bigquery.NullInt64{}
+		return out
+	}
+
+	enroll := p.EnrollDate.Date
+	for _, d := range scripts {
+		if d.After(enroll) {
+			break
+		}
+		out.EverUseAtEnrollment = nullInt(1)
+		if enroll.DaysSince(d) < settings.CurrentWindowDays {
+			out.CurrentUseAtEnrollment = nullInt(1)
+		}
+	}
+
+	// Like incident_disease, incident use is NULL for those already exposed
+	// at enrollment (and for uses whose date is unknown).
+	switch {
+	case isOne(out.EverUseAtEnrollment):
+		out.IncidentUse = bigquery.NullInt64{}
+	case out.FirstUseDate.Valid && out.FirstUseDate.Date.After(enroll):
+		out.IncidentUse = nullInt(1)
+	default:
+		out.IncidentUse = bigquery.NullInt64{}
+	}
+
+	return out
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/carbocation/genomisc/ukbb/localtable"
+	"github.com/carbocation/pfx"
+	"google.golang.org/api/iterator"
+)
+
+// medicationDefinition is one row of the @Definitions parameter of
+// query_template_ukbb_medication.sql.
+type medicationDefinition struct {
+	MedicationOrder int64  `bigquery:"medication_order"`
+	Source          string `bigquery:"source"`
+	Value           string `bigquery:"value"`
+}
+
+// BuildMedicationQuery assembles the query that finds every use of the
+// medications.
+func BuildMedicationQuery(BQ *WrappedBigQuery, defs []*MedicationDefinition, displayQuery bool) (*bigquery.Query, error) {
+	definitions := make([]medicationDefinition, 0)
+	for i, def := range defs {
+		for _, source := range medicationSources {
+			for _, value := range def.Values[source] {
+				definitions = append(definitions, medicationDefinition{MedicationOrder: int64(i), Source: source, Value: value})
+			}
+		}
+	}
+
+	queryParts := map[string]interface{}{
+		"database": BQ.Database,
+	}
+
+	populatedQuery, err := executeQueryTemplate("query_template_ukbb_medication.sql", queryParts)
+	if err != nil {
+		return nil, err
+	}
+
+	if displayQuery {
+		fmt.Println(populatedQuery)
+		fmt.Println("Query parameters:")
+		for _, d := range definitions {
+			fmt.Printf("Definitions: %s %s %q\n", defs[d.MedicationOrder].Name, d.Source, d.Value)
+		}
+		return nil, nil
+	}
+
+	bqQuery := BQ.Client.Query(populatedQuery)
+	bqQuery.QueryConfig.Parameters = append(bqQuery.QueryConfig.Parameters, bigquery.QueryParameter{Name: "Definitions", Value: definitions})
+
+	return bqQuery, nil
+}
+
+// MedicationRows runs the medication query on BigQuery.
+func (BQ *WrappedBigQuery) MedicationRows(defs []*MedicationDefinition, fn func(medicationRow) error) error {
+	query, err := BuildMedicationQuery(BQ, defs, false)
+	if err != nil {
+		return err
+	}
+
+	itr, err := query.Read(BQ.Context)
+	if err != nil {
+		return pfx.Err(err)
+	}
+
+	for {
+		var r medicationRow
+		err := itr.Next(&r)
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return pfx.Err(err)
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MedicationRows reads censor, gp_scripts, and phenotype from the local
+// directory.
+func (l *LocalFiles) MedicationRows(defs []*MedicationDefinition, fn func(medicationRow) error) error {
+	censor, err := l.readCensor()
+	if err != nil {
+		return err
+	}
+	for _, c := range censor {
+		if err := fn(medicationRow{Kind: medicationRowParticipant, SampleID: c.SampleID, BirthDate: c.BirthDate, EnrollDate: c.EnrollDate}); err != nil {
+			return err
+		}
+	}
+
+	matcher := newMedicationMatcher(defs)
+
+	path, err := localtable.Find(l.Dir, "gp_scripts")
+	if err != nil {
+		return err
+	}
+	covered := make(map[int64]struct{})
+	err = readScripts(path, func(s Script) error {
+		if _, exists := covered[s.SampleID]; !exists {
+			covered[s.SampleID] = struct{}{}
+			if err := fn(medicationRow{Kind: medicationRowCoverage, SampleID: s.SampleID}); err != nil {
+				return err
+			}
+		}
+
+		for _, i := range matcher.Script(s) {
+			if err := fn(medicationRow{Kind: medicationRowScript, Medication: nullInt(int64(i)), SampleID: s.SampleID, Date: s.IssueDate}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return l.readSelfReports(matcher, fn)
+}
+
+// readSelfReports mirrors the self_reports and visit_dates CTEs.
+func (l *LocalFiles) readSelfReports(matcher *medicationMatcher, fn func(medicationRow) error) error {
+	if len(matcher.selfReport) == 0 {
+		return nil
+	}
+
+	path, err := localtable.Find(l.Dir, "phenotype")
+	if err != nil {
+		return err
+	}
+
+	type visit struct {
+		SampleID int64
+		Instance int64
+	}
+	visitDates := make(map[visit]bigquery.NullDate)
+	reports := make(map[visit]map[int]struct{})
+	var order []visit
+	err = localtable.ReadPhenotype(path, func(fieldID int64) bool {
+		return fieldID == 53 || fieldID == FieldIDSelfReportedMedication
+	}, func(p localtable.Phenotype) error {
+		v := visit{p.SampleID, p.Instance}
+		if p.FieldID == 53 {
+			if p.ArrayIdx != 0 {
+				return nil
+			}
+			date := localtable.SafeParseDate(p.Value)
+			if prior := visitDates[v]; date.Valid && (!prior.Valid || date.Date.Before(prior.Date)) {
+				visitDates[v] = date
+			}
+			return nil
+		}
+
+		for _, i := range matcher.SelfReport(p.Value) {
+			if reports[v] == nil {
+				reports[v] = make(map[int]struct{})
+				order = append(order, v)
+			}
+			reports[v][i] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, v := range order {
+		for i := range reports[v] {
+			if err := fn(medicationRow{Kind: medicationRowSelfReport, Medication: nullInt(int64(i)), SampleID: v.SampleID, Date: visitDates[v], Instance: nullInt(v.Instance)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readScripts reads a local copy of gp_scripts, whose participant column may
+// be named eid (as distributed) or sample_id.
+func readScripts(path string, fn func(Script) error) error {
+	t, err := localtable.Open(path)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	sampleCol := localtable.Column(t, "eid")
+	if sampleCol < 0 {
+		sampleCol = localtable.Column(t, "sample_id")
+	}
+	cols, err := localtable.Columns(t, "issue_date", "drug_name", "read_2", "bnf_code", "dmd_code")
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if sampleCol < 0 {
+		return fmt.Errorf("%s: Column eid (or sample_id) not found", path)
+	}
+
+	for line := 2; ; line++ {
+		row, err := t.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		s := Script{
+			IssueDate: parseIssueDate(row[cols[0]]),
+			DrugName:  row[cols[1]],
+			Read2:     row[cols[2]],
+			BNF:       row[cols[3]],
+			DMD:       row[cols[4]],
+		}
+		if s.SampleID, err = strconv.ParseInt(row[sampleCol], 10, 64); err != nil {
+			return fmt.Errorf("%s line %d: sample_id: %v", path, line, err)
+		}
+
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseIssueDate accepts the dd/mm/yyyy dates of the distributed table as well
+// as ISO dates. Unparseable dates are NULL.
+func parseIssueDate(value string) bigquery.NullDate {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{"2/1/2006", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return bigquery.NullDate{Date: civil.DateOf(t), Valid: true}
+		}
+	}
+
+	return bigquery.NullDate{}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalMedications(t *testing.T) {
+	dir := writeLocalTables(t, map[string]string{
+		"censor": "sample_id\tbirthdate\tenroll_date\n" +
+			"1\t1950-01-01\t2008-01-01\n" +
+			"2\t1950-01-01\t2008-01-01\n" +
+			"3\t1950-01-01\t2008-01-01\n" +
+			"4\t1950-01-01\t2008-01-01\n",
+		"gp_scripts": "eid\tdata_provider\tissue_date\tread_2\tbnf_code\tdmd_code\tdrug_name\tquantity\n" +
+			// Participant 1 is a current statin user at enrollment, with two
+			// overlapping prescriptions (by name and by BNF code)
+			"1\t1\t01/12/2007\t\t02.12.00.00\t\tSimvastatin 40mg tablets\t28\n" +
+			"1\t1\t15/12/2007\t\t0212000Y0\t\tSimvastatin 40mg tablets\t28\n" +
+			// Participant 2 first used a statin long before enrollment, and
+			// an anticoagulant (by ATC) after
+			"2\t1\t01/01/2000\tbxd1.\t\t\tAtorvastatin 20mg tablets\t28\n" +
+			"2\t1\t01/03/2010\t\t\t123\tWarfarin 1mg tablets\t28\n" +
+			"2\t1\t07/07/2037\t\t\t123\tWarfarin 1mg tablets\t28\n" +
+			// Participant 3 has GP data but no matching prescriptions
+			"3\t1\t01/01/2009\t\t\t\tParacetamol 500mg tablets\t32\n",
+		"phenotype": "sample_id\tFieldID\tinstance\tarray_idx\tvalue\n" +
+			"4\t53\t0\t0\t2008-01-01\n" +
+			"4\t53\t2\t0\t2015-06-01\n" +
+			"4\t20003\t2\t0\t1140861958\n",
+	})
+
+	medicationPath := filepath.Join(dir, "medications.tsv")
+	medications := "medication\tsource\tvalues\n" +
+		"statin\tname\tSimvastatin,atorvastatin\n" +
+		"statin\tbnf\t0212\n" +
+		"statin\t20003\t1140861958\n" +
+		"anticoagulant\tatc\tB01AA\n"
+	if err := os.WriteFile(medicationPath, []byte(medications), 0644); err != nil {
+		t.Fatal(err)
+	}
+	atcPath := filepath.Join(dir, "atc.tsv")
+	if err := os.WriteFile(atcPath, []byte("source\tcode\tatc\ndmd\t123\tB01AA03\n20003\t1140861958\tC10AA01\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defs, err := ReadMedicationDefinitions(medicationPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ExpandATC(defs, atcPath); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	defer func(original *bufio.Writer) { STDOUT = original }(STDOUT)
+	STDOUT = bufio.NewWriter(&buf)
+	if err := ExecuteMedications(&LocalFiles{Dir: dir}, defs, ExposureSettings{ScriptDays: 28, CurrentWindowDays: 90}); err != nil {
+		t.Fatal(err)
+	}
+	STDOUT.Flush()
+
+	// medication, sample_id, ever_use, ever_use_at_enrollment,
+	// current_use_at_enrollment, self_reported_at_enrollment, incident_use,
+	// first_use_date, first_use_age_days, first_prescription_date,
+	// last_prescription_date, n_prescriptions, cumulative_exposure_days,
+	// has_gp_scripts
+	expected := []string{
+		"statin\t1\t1\t1\t1\t0\t\t2007-12-01\t21153\t2007-12-01\t2007-12-15\t2\t42\t1",
+		"statin\t2\t1\t1\t0\t0\t\t2000-01-01\t18262\t2000-01-01\t2000-01-01\t1\t28\t1",
+		"statin\t3\t0\t0\t0\t0\t0\t\t\t\t\t0\t0\t1",
+		"statin\t4\t1\t0\t0\t0\t1\t2015-06-01\t23892\t\t\t0\t0\t0",
+		"anticoagulant\t1\t0\t0\t0\t0\t0\t\t\t\t\t0\t0\t1",
+		"anticoagulant\t2\t1\t0\t0\t0\t1\t2010-03-01\t21974\t2010-03-01\t2010-03-01\t1\t28\t1",
+		"anticoagulant\t3\t0\t0\t0\t0\t0\t\t\t\t\t0\t0\t1",
+		"anticoagulant\t4\t0\t0\t0\t0\t0\t\t\t\t\t0\t0\t0",
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(expected)+1 {
+		t.Fatalf("Expected %d lines, got %d:\n%s", len(expected)+1, len(lines), buf.String())
+	}
+	for i, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if got := strings.Join(fields[:14], "\t"); got != expected[i] {
+			t.Errorf("Row %d:\nexpected %q\ngot      %q", i, expected[i], got)
+		}
+	}
+}
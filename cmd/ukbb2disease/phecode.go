@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/carbocation/genomisc"
+	"github.com/carbocation/genomisc/ukbb"
+)
+
+// phecodeRange is one entry of a phecode's exclusion ranges, e.g., 250-250.99.
+type phecodeRange struct {
+	Lo, Hi float64
+}
+
+func (r phecodeRange) Contains(phecode float64) bool {
+	return phecode >= r.Lo && phecode <= r.Hi
+}
+
+// phecodeMap accumulates the rows of the Phecode ICD-10 and ICD-9 maps.
+type phecodeMap struct {
+	// phecode => code family ("icd10" or "icd9") => codes
+	codes map[string]map[string]map[string]struct{}
+
+	// phecode => exclusion ranges
+	exclusions map[string][]phecodeRange
+}
+
+func newPhecodeMap() *phecodeMap {
+	return &phecodeMap{
+		codes:      make(map[string]map[string]map[string]struct{}),
+		exclusions: make(map[string][]phecodeRange),
+	}
+}
+
+// normalizePhecode formats a phecode with at least 3 digits before the decimal,
+// so that 8, 008, and 008.0 are all 008, and 250.20 is 250.2.
+func normalizePhecode(value string) (string, float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || v < 0 {
+		return "", 0, fmt.Errorf("%q is not a phecode", value)
+	}
+
+	formatted := strconv.FormatFloat(v, 'f', -1, 64)
+	integer := formatted
+	if dot := strings.Index(formatted, "."); dot >= 0 {
+		integer = formatted[:dot]
+	}
+	if pad := 3 - len(integer); pad > 0 {
+		formatted = strings.Repeat("0", pad) + formatted
+	}
+
+	return formatted, v, nil
+}
+
+// parsePhecodeRanges parses exclusion ranges such as "250-250.99", which may
+// be separated by commas and may include single phecodes.
+func parsePhecodeRanges(value string) ([]phecodeRange, error) {
+	var out []phecodeRange
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return nil, fmt.Errorf("Exclusion range %q must have the form FIRST-LAST", part)
+		}
+		_, lo, err := normalizePhecode(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			if _, hi, err = normalizePhecode(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		if lo > hi {
+			return nil, fmt.Errorf("Exclusion range %q ends before it begins", part)
+		}
+
+		out = append(out, phecodeRange{Lo: lo, Hi: hi})
+	}
+
+	return out, nil
+}
+
+// ReadMap reads a Phecode map (e.g., phecode_icd10.csv or
+// phecode_icd9_rolled.csv from phewascatalog.org). The map must have a column
+// named ICD10 or ICD9 (whichever is given by family), a PheCode column, and
+// may have an exclusion range column (Excl. Phecodes, or Exl. Phecodes as it
+// is spelled in some releases). Column names are not case sensitive. Rows
+// without a phecode are skipped.
+func (m *phecodeMap) ReadMap(path, family string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rc, err := genomisc.MaybeDecompressReadCloserFromFile(f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	reader := csv.NewReader(rc)
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	if ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(path, ".gz"))); ext == ".tsv" || ext == ".txt" || ext == ".tab" {
+		reader.Comma = '\t'
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("Reading the header of %s: %v", path, err)
+	}
+	codeCol, phecodeCol, exclusionCol := -1, -1, -1
+	for i, v := range header {
+		name := strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(v, ".", " ")), ""))
+		switch {
+		case name == family:
+			codeCol = i
+		case name == "phecode":
+			phecodeCol = i
+		case name == "exclphecodes" || name == "exlphecodes":
+			exclusionCol = i
+		}
+	}
+	if codeCol < 0 || phecodeCol < 0 {
+		return fmt.Errorf("%s must have %s and PheCode columns", path, strings.ToUpper(family))
+	}
+
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Reading %s: %v", path, err)
+		}
+
+		if len(row) <= codeCol || len(row) <= phecodeCol || strings.TrimSpace(row[phecodeCol]) == "" || strings.TrimSpace(row[codeCol]) == "" {
+			continue
+		}
+
+		phecode, _, err := normalizePhecode(row[phecodeCol])
+		if err != nil {
+			return fmt.Errorf("%s line %d: %v", path, line, err)
+		}
+
+		if m.codes[phecode] == nil {
+			m.codes[phecode] = make(map[string]map[string]struct{})
+		}
+		if m.codes[phecode][family] == nil {
+			m.codes[phecode][family] = make(map[string]struct{})
+		}
+		m.codes[phecode][family][strings.TrimSpace(row[codeCol])] = struct{}{}
+
+		if exclusionCol >= 0 && len(row) > exclusionCol {
+			ranges, err := parsePhecodeRanges(row[exclusionCol])
+			if err != nil {
+				return fmt.Errorf("%s line %d: %v", path, line, err)
+			}
+			m.exclusions[phecode] = append(m.exclusions[phecode], ranges...)
+		}
+	}
+
+	return nil
+}
+
+// RollUp also assigns each code to the ancestors of its phecode, so that a
+// code mapped to 250.21 also counts toward 250.2 and 250. Only ancestors that
+// are themselves in the map are used.
+func (m *phecodeMap) RollUp() {
+	for phecode, families := range m.codes {
+		for parent := phecodeParent(phecode); parent != ""; parent = phecodeParent(parent) {
+			if _, exists := m.codes[parent]; !exists {
+				continue
+			}
+			for family, codes := range families {
+				if m.codes[parent][family] == nil {
+					m.codes[parent][family] = make(map[string]struct{})
+				}
+				for code := range codes {
+					m.codes[parent][family][code] = struct{}{}
+				}
+			}
+		}
+	}
+}
+
+// phecodeParent drops the last decimal digit of a phecode: 250.21 => 250.2 =>
+// 250 => "".
+func phecodeParent(phecode string) string {
+	dot := strings.Index(phecode, ".")
+	if dot < 0 {
+		return ""
+	}
+
+	parent := phecode[:len(phecode)-1]
+	return strings.TrimSuffix(parent, ".")
+}
+
+// Diseases builds one tabfile per phecode. Cases are participants with any
+// code mapped to the phecode; the codes mapped to any other phecode within the
+// phecode's exclusion ranges are exclusion criteria.
+func (m *phecodeMap) Diseases(biobank Biobank) ([]Disease, error) {
+	type numbered struct {
+		phecode string
+		value   float64
+	}
+	phecodes := make([]numbered, 0, len(m.codes))
+	for phecode := range m.codes {
+		_, value, err := normalizePhecode(phecode)
+		if err != nil {
+			return nil, err
+		}
+		phecodes = append(phecodes, numbered{phecode, value})
+	}
+	sort.Slice(phecodes, func(i, j int) bool { return phecodes[i].value < phecodes[j].value })
+
+	fields := map[string][]int{
+		"icd10": sortedFieldIDs(ukbb.ICD10),
+		"icd9":  sortedFieldIDs(ukbb.ICD9),
+	}
+
+	out := make([]Disease, 0, len(phecodes))
+	for _, p := range phecodes {
+		excluded := make(map[string]map[string]struct{})
+		for _, q := range phecodes {
+			if !inPhecodeRanges(q.value, m.exclusions[p.phecode]) {
+				continue
+			}
+			for family, codes := range m.codes[q.phecode] {
+				if excluded[family] == nil {
+					excluded[family] = make(map[string]struct{})
+				}
+				for code := range codes {
+					if _, isCase := m.codes[p.phecode][family][code]; !isCase {
+						excluded[family][code] = struct{}{}
+					}
+				}
+			}
+		}
+
+		tabs := NewTabFile()
+		for _, family := range []string{"icd10", "icd9"} {
+			included := sortedCodes(m.codes[p.phecode][family])
+			excluded := sortedCodes(excluded[family])
+			for _, fieldID := range fields[family] {
+				if len(included) > 0 {
+					tabs.add(TabEntry{FieldID: fieldID, Values: included}, biobank)
+				}
+				if len(excluded) > 0 {
+					tabs.add(TabEntry{FieldID: fieldID, Values: excluded, Exclude: true}, biobank)
+				}
+			}
+		}
+
+		out = append(out, Disease{Name: "phecode_" + p.phecode, Tabs: tabs})
+	}
+
+	return out, nil
+}
+
+func inPhecodeRanges(phecode float64, ranges []phecodeRange) bool {
+	for _, r := range ranges {
+		if r.Contains(phecode) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortedCodes(codes map[string]struct{}) []string {
+	out := make([]string, 0, len(codes))
+	for code := range codes {
+		out = append(out, code)
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+func sortedFieldIDs(fields map[int]struct{}) []int {
+	out := make([]int, 0, len(fields))
+	for fieldID := range fields {
+		out = append(out, fieldID)
+	}
+	sort.Ints(out)
+
+	return out
+}
+
+// ReadPhecodeDiseases reads the Phecode ICD-10 and ICD-9 maps (either may be
+// empty) and builds one disease definition per phecode.
+func ReadPhecodeDiseases(icd10Path, icd9Path string, rollUp bool, biobank Biobank) ([]Disease, error) {
+	m := newPhecodeMap()
+	if icd10Path != "" {
+		if err := m.ReadMap(icd10Path, "icd10"); err != nil {
+			return nil, err
+		}
+	}
+	if icd9Path != "" {
+		if err := m.ReadMap(icd9Path, "icd9"); err != nil {
+			return nil, err
+		}
+	}
+
+	if rollUp {
+		m.RollUp()
+	}
+
+	return m.Diseases(biobank)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/iterator"
+)
+
+const (
+	testPhecodeICD10 = `ICD10,PheCode,Exl. Phecodes,Excl. Phenotypes
+I21,411.2,411-414.99,ischemic heart disease
+I21.0,411.2,411-414.99,ischemic heart disease
+I25,411.4,411-414.99,ischemic heart disease
+I48,427.21,427-427.99,cardiac dysrhythmias
+E11,250.2,249-250.99,diabetes mellitus
+R69,,,
+`
+	testPhecodeICD9 = `ICD9,ICD9 String,PheCode,Phenotype,Excl. Phecodes,Excl. Phenotypes,Rollup,Leaf,Ignore Bool
+410.0,Acute MI,411.2,Myocardial infarction,411-414.99,ischemic heart disease,1,1,FALSE
+`
+)
+
+func TestReadPhecodeDiseases(t *testing.T) {
+	dir := t.TempDir()
+	icd10Path := filepath.Join(dir, "phecode_icd10.csv")
+	icd9Path := filepath.Join(dir, "phecode_icd9_rolled.csv")
+	if err := os.WriteFile(icd10Path, []byte(testPhecodeICD10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(icd9Path, []byte(testPhecodeICD9), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	diseases, err := ReadPhecodeDiseases(icd10Path, icd9Path, true, UKBiobank{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(diseases))
+	byName := make(map[string]*TabFile)
+	for _, disease := range diseases {
+		names = append(names, disease.Name)
+		byName[disease.Name] = disease.Tabs
+	}
+	if got := strings.Join(names, " "); got != "phecode_250.2 phecode_411.2 phecode_411.4 phecode_427.21" {
+		t.Fatalf("Unexpected phecodes %s", got)
+	}
+
+	mi := byName["phecode_411.2"]
+	if missing, err := mi.CheckSensibility(); err != nil {
+		t.Errorf("Expected every ICD field to be used, but %v are missing", missing)
+	}
+
+	// FieldID => values
+	got := make(map[int]string)
+	for _, entry := range mi.AllIncluded() {
+		got[entry.FieldID] = strings.Join(entry.FormattedValues(UKBiobank{}), ",")
+	}
+	for _, entry := range mi.AllExcluded() {
+		got[-entry.FieldID] = strings.Join(entry.FormattedValues(UKBiobank{}), ",")
+	}
+	expected := map[int]string{
+		41202: "I21,I210", 41204: "I21,I210", 40001: "I21,I210", 40002: "I21,I210",
+		41203: "4100", 41205: "4100",
+		-41202: "I25", -41204: "I25", -40001: "I25", -40002: "I25",
+	}
+	if len(got) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	for fieldID, values := range expected {
+		if got[fieldID] != values {
+			t.Errorf("FieldID %d: expected %s, got %s", fieldID, values, got[fieldID])
+		}
+	}
+
+	// The single-code exclusion range 427-427.99 contains no other phecode
+	if len(byName["phecode_427.21"].AllExcluded()) != 0 {
+		t.Errorf("Expected no exclusions for 427.21")
+	}
+}
+
+func TestLocalFilesBatchResults(t *testing.T) {
+	dir := writeLocalTables(t, map[string]string{
+		"censor": "sample_id\tbirthdate\tenroll_date\tenroll_age\tenroll_age_days\tdeath_date\tdeath_censor_date\tphenotype_censor_date\tphenotype_censor_age\tphenotype_censor_age_days\n" +
+			"1\t1950-01-01\t2008-01-01\t58\t21184\t\t2020-01-01\t2020-01-01\t70\t25567\n" +
+			"2\t1950-01-01\t2008-01-01\t58\t21184\t\t2020-01-01\t2020-01-01\t70\t25567\n",
+		"materialized_hesin_dates": "sample_id\tFieldID\tvalue\tfirst_date\n" +
+			"1\t41202\tI25\t2010-01-01\n" +
+			"1\t41204\tI210\t2012-01-01\n" +
+			"2\t41203\t4100\t2001-01-01\n",
+		"materialized_special_dates": "sample_id\tFieldID\tvalue\tfirst_date\n",
+	})
+
+	icd10Path := filepath.Join(dir, "phecode_icd10.csv")
+	icd9Path := filepath.Join(dir, "phecode_icd9_rolled.csv")
+	if err := os.WriteFile(icd10Path, []byte(testPhecodeICD10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(icd9Path, []byte(testPhecodeICD9), 0644); err != nil {
+		t.Fatal(err)
+	}
+	diseases, err := ReadPhecodeDiseases(icd10Path, icd9Path, true, UKBiobank{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	itr, err := (&LocalFiles{Dir: dir}).BatchResults(diseases, UKBiobank{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// disease sample_id has_disease incident_disease prevalent_disease met_exclusion date_censor
+	expected := []string{
+		"phecode_250.2 1 0 0 0 0 2020-01-01",
+		"phecode_250.2 2 0 0 0 0 2020-01-01",
+		"phecode_411.2 2 1 NULL 1 0 2001-01-01",
+		"phecode_411.2 1 0 0 0 1 2010-01-01",
+		"phecode_411.4 1 1 1 0 0 2010-01-01",
+		"phecode_411.4 2 NULL NULL NULL 1 2001-01-01",
+		"phecode_427.21 1 0 0 0 0 2020-01-01",
+		"phecode_427.21 2 0 0 0 0 2020-01-01",
+	}
+
+	for i := 0; ; i++ {
+		var r Result
+		err := itr.Next(&r)
+		if err == iterator.Done {
+			if i != len(expected) {
+				t.Fatalf("Expected %d rows, got %d", len(expected), i)
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		got := strings.Join([]string{
+			r.Disease, strconv.FormatInt(r.SampleID, 10), r.HasDisease.String(), r.IncidentDisease.String(), r.PrevalentDisease.String(), r.MetExclusion.String(), r.PhenotypeDateCensor.String(),
+		}, " ")
+		if i >= len(expected) {
+			t.Fatalf("Unexpected row %d: %q", i, got)
+		}
+		if got != expected[i] {
+			t.Errorf("Row %d: expected %q, got %q", i, expected[i], got)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"embed"
+	"strings"
+	"text/template"
 
 	_ "embed"
 )
@@ -29,3 +31,38 @@ func mkMap(args ...interface{}) map[interface{}]interface{} {
 //
 // TODO: Resolve age_censor vs enroll_age. Choose one or the other (likely the
 // latter, so you end up with enroll_age, censor_age, death_censor_age).
+
+// renderQuery fills in the biobank's query template.
+func renderQuery(biobank Biobank, batch bool, queryParts map[string]interface{}) (string, error) {
+	templateName, err := biobank.QueryTemplate(batch)
+	if err != nil {
+		return "", err
+	}
+
+	return executeQueryTemplate(templateName, queryParts)
+}
+
+// executeQueryTemplate fills in the named embedded query template.
+func executeQueryTemplate(templateName string, queryParts map[string]interface{}) (string, error) {
+	// Fetch desired template from embedded resources
+	templateBytes, err := embeddedQueryTemplates.ReadFile(templateName)
+	if err != nil {
+		return "", err
+	}
+
+	// Parse the selected template
+	queryTemplate, err := template.New("").
+		Funcs(template.FuncMap(map[string]interface{}{"mkMap": mkMap})).
+		Parse(string(templateBytes))
+	if err != nil {
+		return "", err
+	}
+
+	// (execute the template)
+	populatedQuery := &strings.Builder{}
+	if err := queryTemplate.Execute(populatedQuery, queryParts); err != nil {
+		return "", err
+	}
+
+	return populatedQuery.String(), nil
+}
@@ -0,0 +1,310 @@
+{{define "include_exclude"}}
+SELECT 
+	sample_id, 
+	has_disease, 
+	incident_disease, 
+	prevalent_disease, 
+	date_censor, 
+	DATE_DIFF(date_censor,birthdate, DAY)/365.25 age_censor, 
+	DATE_DIFF(date_censor,birthdate, DAY) age_censor_days,
+	birthdate, 
+	enroll_date, 
+	enroll_age, 
+	enroll_age_days,
+	death_date, 
+	death_age, 
+	death_age_days,
+	computed_date, 
+	missing_fields
+FROM (
+	SELECT 
+		c.sample_id, 
+		CASE 
+			WHEN MIN(hd.first_date) IS NOT NULL THEN 1
+			ELSE 0
+		END has_disease,
+		CASE 
+			WHEN MIN(hd.first_date) > MIN(c.enroll_date) THEN 1
+			WHEN MIN(hd.first_date) IS NOT NULL THEN NULL
+			ELSE 0
+		END incident_disease,
+		CASE 
+			WHEN MIN(hd.first_date) > MIN(c.enroll_date) THEN 0
+			WHEN MIN(hd.first_date) IS NOT NULL THEN 1
+			ELSE 0
+		END prevalent_disease,
+		CASE 
+			WHEN MIN(hd.first_date) IS NOT NULL THEN MIN(hd.first_date)
+			ELSE MIN(c.phenotype_censor_date)
+		END date_censor,
+		MIN(c.birthdate) birthdate,
+		MIN(c.enroll_date) enroll_date,
+		MIN(c.enroll_age) enroll_age,
+		MIN(c.enroll_age_days) enroll_age_days,
+		MIN(c.death_date) death_date,
+		MIN(c.death_age) death_age,
+		MIN(c.death_age_days) death_age_days,
+		MIN(c.computed_date) computed_date,
+		MIN(c.missing_fields) missing_fields
+	FROM censor c
+	LEFT OUTER JOIN dated_events hd ON c.sample_id=hd.sample_id
+	AND (
+		FALSE
+		{{/* The .includePart or .excludePart is passed here */}}
+		{{.whichPart}}
+	)
+	GROUP BY 
+		sample_id
+)
+{{end}}
+
+WITH 
+--------------------------------------------------------------------------------
+-- CTEs to reconstruct the censor table from the OMOP CDM. Follow-up begins with
+-- the first observation period and ends with the last one (or death).
+--------------------------------------------------------------------------------
+death_dates AS (
+	-- People can have multiple entries in the death table
+	SELECT
+		d.person_id,
+		MAX(d.death_date) death_date,
+	FROM `{{.database}}.death` d
+	GROUP BY person_id
+)
+, observation AS (
+	SELECT
+		op.person_id,
+		MIN(op.observation_period_start_date) enrolled,
+		MAX(op.observation_period_end_date) observed_until,
+	FROM `{{.database}}.observation_period` op
+	GROUP BY person_id
+)
+, censor_query AS (
+	SELECT 
+		p.person_id sample_id,
+		o.enrolled,
+		o.observed_until,
+		d.death_date died,
+		-- Sites that only share the year (or month) of birth are assigned the
+		-- middle of the year (or the first of the month).
+		COALESCE(
+			CAST(p.birth_datetime AS DATE),
+			SAFE.DATE(p.year_of_birth, COALESCE(p.month_of_birth, 7), COALESCE(p.day_of_birth, 1))
+		) birthdate,
+	FROM `{{.database}}.person` p
+	JOIN observation o USING(person_id)
+	LEFT JOIN death_dates d USING(person_id)
+)
+, censor_dates AS (
+	SELECT
+		*,
+		CASE 
+			WHEN died IS NOT NULL AND died < observed_until THEN died
+			ELSE observed_until
+		END phenotype_censor_date,
+	FROM censor_query
+)
+-- Mimicking the precomputed censor table
+, censor AS (
+	SELECT 
+		sample_id,
+		CAST(NULL AS STRING) missing_fields,
+		CURRENT_DATE() computed_date,
+		birthdate,
+		enrolled enroll_date,
+		SAFE.DATE_DIFF(enrolled, birthdate, DAY)/1.0 enroll_age_days,
+		SAFE.DATE_DIFF(enrolled, birthdate, DAY)/365.25 enroll_age,
+		died death_date,
+		SAFE.DATE_DIFF(died, birthdate, DAY)/1.0 death_age_days,
+		SAFE.DATE_DIFF(died, birthdate, DAY)/365.25 death_age,
+		phenotype_censor_date,
+		SAFE.DATE_DIFF(phenotype_censor_date, birthdate, DAY)/1.0 phenotype_censor_age_days,
+		SAFE.DATE_DIFF(phenotype_censor_date, birthdate, DAY)/365.25 phenotype_censor_age,
+		-- Deaths are only ascertained while participants are observed
+		observed_until death_censor_date,
+		SAFE.DATE_DIFF(observed_until, birthdate, DAY)/1.0 death_censor_age_days,
+		SAFE.DATE_DIFF(observed_until, birthdate, DAY)/365.25 death_censor_age,
+	FROM censor_dates
+)
+--------------------------------------------------------------------------------
+-- Dated codes, in the structure that the usual ukbb2disease CTEs expect. The
+-- vocabulary takes the place of the FieldID, and dots are removed from the codes
+-- so that UK Biobank-style codes (I210) match OMOP codes (I21.0).
+--------------------------------------------------------------------------------
+, condition_events AS (
+	SELECT 
+		co.person_id, 
+		c.vocabulary_id,
+		c.concept_code,
+		co.condition_start_date event_date,
+	FROM `{{.database}}.condition_occurrence` co
+	JOIN `{{.database}}.concept` c ON (c.concept_id = co.condition_concept_id OR c.concept_id = co.condition_source_concept_id)
+)
+, procedure_events AS (
+	SELECT 
+		po.person_id, 
+		c.vocabulary_id,
+		c.concept_code,
+		po.procedure_date event_date,
+	FROM `{{.database}}.procedure_occurrence` po
+	JOIN `{{.database}}.concept` c ON (c.concept_id = po.procedure_concept_id OR c.concept_id = po.procedure_source_concept_id)
+)
+, death_events AS (
+	-- Causes of death
+	SELECT 
+		d.person_id, 
+		c.vocabulary_id,
+		c.concept_code,
+		d.death_date event_date,
+	FROM `{{.database}}.death` d
+	JOIN `{{.database}}.concept` c ON (c.concept_id = d.cause_concept_id OR c.concept_id = d.cause_source_concept_id)
+)
+, dated_events AS (
+	SELECT
+		person_id sample_id,
+		vocabulary_id FieldName,
+		REPLACE(concept_code, '.', '') value,
+		MIN(event_date) first_date,
+	FROM (
+		SELECT * FROM condition_events
+		UNION ALL
+		SELECT * FROM procedure_events
+		UNION ALL
+		SELECT * FROM death_events
+	)
+	GROUP BY 
+		sample_id, 
+		FieldName,
+		value
+)
+, included_only AS (
+	{{template "include_exclude" (mkMap "g" . "whichPart" .includePart)}}
+)
+, excluded_only AS (
+	{{template "include_exclude" (mkMap "g" . "whichPart" .excludePart)}}
+)
+
+SELECT 
+	c.sample_id, 
+	CASE 
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN NULL
+		-- Exclusion occurred after enrollment and prior to disease onset; we will censor:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN 0
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN io.has_disease 
+		-- Met exclusion but no inclusion; occurred after enrollment (due to above rule); censor:
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN 0
+		-- Didn't meet exclusion or inclusion means we censor at the end of observation:
+		WHEN io.has_disease IS NULL THEN 0
+		ELSE io.has_disease
+	END has_disease, 
+
+	CASE 
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN NULL
+		-- Exclusion occurred after enrollment and prior to disease onset; we will censor:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN 0
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN io.incident_disease
+		-- Met exclusion but no inclusion; occurred after enrollment (due to above rule); censor:
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN 0
+		-- Didn't meet exclusion or inclusion means we censor at the end of observation:
+		WHEN io.has_disease IS NULL THEN 0
+		ELSE io.incident_disease
+	END incident_disease, 
+
+	CASE 
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN NULL
+		-- Exclusion occurred after enrollment and prior to disease onset; we will exclude:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN 0
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN io.prevalent_disease
+		-- Met exclusion but no inclusion; occurred after enrollment (due to above rule); censor:
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN 0
+		-- Didn't meet exclusion or inclusion means we censor at the end of observation:
+		WHEN io.has_disease IS NULL THEN 0
+		ELSE io.prevalent_disease
+	END prevalent_disease, 
+
+	CASE 
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN 1
+		-- Exclusion occurred after enrollment and prior to disease onset; we will exclude:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN 1
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN 0
+		-- Met exclusion but no inclusion
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN 1
+		-- Didn't get excluded:
+		ELSE 0
+	END met_exclusion, 
+
+	CASE 
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN eo.date_censor
+		-- Exclusion occurred after enrollment and prior to disease onset; we will exclude:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN eo.date_censor
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN io.date_censor
+		-- Met exclusion but no inclusion
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN eo.date_censor
+		-- Didn't meet exclusion or inclusion means we censor at the end of observation:
+		WHEN io.has_disease IS NULL THEN c.phenotype_censor_date
+		ELSE io.date_censor
+	END date_censor, 
+
+	-- If you modify age_censor, don't forget to modify age_censor_days equivalently
+	CASE
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)/365.25
+		-- Exclusion occurred after enrollment and prior to disease onset; we will exclude:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)/365.25
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN DATE_DIFF(io.date_censor,c.birthdate, DAY)/365.25 
+		-- Met exclusion but no inclusion
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)/365.25
+		-- Didn't meet exclusion or inclusion means we censor at the end of observation:
+		WHEN io.has_disease IS NULL THEN c.phenotype_censor_age
+		ELSE DATE_DIFF(io.date_censor,c.birthdate, DAY)/365.25 
+	END age_censor, 
+
+	-- Designed to be a duplicate of age_censor but with days instead of years
+	CASE
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN DATE_DIFF(io.date_censor,c.birthdate, DAY)
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)
+		WHEN io.has_disease IS NULL THEN c.phenotype_censor_age_days
+		ELSE DATE_DIFF(io.date_censor,c.birthdate, DAY)
+	END age_censor_days, 
+
+	c.birthdate, 
+	c.enroll_date, 
+	c.enroll_age, 
+	c.enroll_age_days,
+	CASE 
+		WHEN c.death_date IS NULL THEN 0 
+		ELSE 1 
+	END has_died,
+	CASE 
+		WHEN c.death_date IS NULL THEN c.death_censor_date 
+		ELSE c.death_date 
+	END death_date, 
+	CASE WHEN c.death_date IS NULL THEN c.death_censor_age 
+		ELSE c.death_age 
+	END death_age, 
+	CASE WHEN c.death_date IS NULL THEN c.death_censor_age_days 
+		ELSE c.death_age_days 
+	END death_age_days, 
+	c.computed_date, 
+	c.missing_fields
+FROM censor c
+LEFT JOIN included_only io ON io.sample_id=c.sample_id
+LEFT JOIN excluded_only eo ON eo.sample_id=c.sample_id
+ORDER BY 
+	has_disease DESC, 
+	incident_disease DESC, 
+	age_censor ASC
+
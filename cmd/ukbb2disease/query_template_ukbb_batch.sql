@@ -0,0 +1,209 @@
+{{/* The batched counterpart of query_template_ukbb.sql. Instead of one
+	tabfile, the diseases are defined by the @Definitions table parameter, with
+	one row per disease, inclusion or exclusion, FieldID, and value. Every
+	participant in the censor table gets one row per disease. */}}
+
+{{define "include_exclude"}}
+SELECT 
+	c.sample_id, 
+	dz.disease, 
+	CASE 
+		WHEN fd.first_date IS NOT NULL THEN 1
+		ELSE 0
+	END has_disease,
+	CASE 
+		WHEN fd.first_date > c.enroll_date THEN 1
+		WHEN fd.first_date IS NOT NULL THEN NULL
+		ELSE 0
+	END incident_disease,
+	CASE 
+		WHEN fd.first_date > c.enroll_date THEN 0
+		WHEN fd.first_date IS NOT NULL THEN 1
+		ELSE 0
+	END prevalent_disease,
+	CASE 
+		WHEN fd.first_date IS NOT NULL THEN fd.first_date
+		ELSE c.phenotype_censor_date
+	END date_censor
+FROM {{.g.database}}.censor c
+CROSS JOIN diseases dz
+LEFT OUTER JOIN first_dates fd ON fd.sample_id=c.sample_id 
+	AND fd.disease=dz.disease 
+	AND fd.exclude={{.exclude}}
+{{end}}
+
+WITH definitions AS (
+	SELECT * FROM UNNEST(@Definitions)
+), diseases AS (
+	SELECT DISTINCT 
+		disease, 
+		disease_order 
+	FROM definitions
+), undated_fields AS (
+	SELECT 
+		p.sample_id, 
+		p.FieldID, 
+		p.value, 
+		MIN(SAFE.PARSE_DATE("%E4Y-%m-%d", denroll.value)) first_date
+	FROM {{.database}}.phenotype p
+	JOIN {{.database}}.phenotype denroll ON denroll.FieldID=53 AND denroll.sample_id=p.sample_id AND denroll.instance = 0 AND denroll.array_idx = 0
+	WHERE TRUE
+		{{.standardPart}}
+	GROUP BY 
+		p.FieldID, 
+		p.sample_id, 
+		p.value
+), first_dates AS (
+	SELECT 
+		d.disease, 
+		d.exclude, 
+		hd.sample_id, 
+		MIN(hd.first_date) first_date
+	FROM (
+		{{if .use_gp}}
+		SELECT * FROM {{.materializedDatabase}}.materialized_gp_dates
+		UNION DISTINCT
+		{{end}}
+		SELECT * FROM {{.materializedDatabase}}.materialized_hesin_dates
+		UNION DISTINCT
+		SELECT * FROM {{.materializedDatabase}}.materialized_special_dates
+		UNION DISTINCT
+		SELECT * FROM undated_fields
+	) hd
+	JOIN definitions d ON d.FieldID=hd.FieldID AND d.value=hd.value
+	GROUP BY 
+		d.disease, 
+		d.exclude, 
+		hd.sample_id
+), included_only AS (
+	{{template "include_exclude" (mkMap "g" . "exclude" "FALSE")}}
+), excluded_only AS (
+	{{template "include_exclude" (mkMap "g" . "exclude" "TRUE")}}
+)
+
+SELECT 
+	dz.disease, 
+	c.sample_id, 
+	CASE 
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN NULL
+		-- Exclusion occurred after enrollment and prior to disease onset; we will censor:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN 0
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN io.has_disease 
+		-- Met exclusion but no inclusion; occurred after enrollment (due to above rule); censor:
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN 0
+		-- Didn't meet exclusion or inclusion means we censor at the date given by UKBB:
+		WHEN io.has_disease IS NULL THEN 0
+		ELSE io.has_disease
+	END has_disease, 
+
+	CASE 
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN NULL
+		-- Exclusion occurred after enrollment and prior to disease onset; we will censor:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN 0
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN io.incident_disease
+		-- Met exclusion but no inclusion; occurred after enrollment (due to above rule); censor:
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN 0
+		-- Didn't meet exclusion or inclusion means we censor at the date given by UKBB:
+		WHEN io.has_disease IS NULL THEN 0
+		ELSE io.incident_disease
+	END incident_disease, 
+
+	CASE 
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN NULL
+		-- Exclusion occurred after enrollment and prior to disease onset; we will exclude:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN 0
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN io.prevalent_disease
+		-- Met exclusion but no inclusion; occurred after enrollment (due to above rule); censor:
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN 0
+		-- Didn't meet exclusion or inclusion means we censor at the date given by UKBB:
+		WHEN io.has_disease IS NULL THEN 0
+		ELSE io.prevalent_disease
+	END prevalent_disease, 
+
+	CASE 
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN 1
+		-- Exclusion occurred after enrollment and prior to disease onset; we will exclude:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN 1
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN 0
+		-- Met exclusion but no inclusion
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN 1
+		-- Didn't get excluded:
+		ELSE 0
+	END met_exclusion, 
+
+	CASE 
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN eo.date_censor
+		-- Exclusion occurred after enrollment and prior to disease onset; we will exclude:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN eo.date_censor
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN io.date_censor
+		-- Met exclusion but no inclusion
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN eo.date_censor
+		-- Didn't meet exclusion or inclusion means we censor at the date given by UKBB:
+		WHEN io.has_disease IS NULL THEN c.phenotype_censor_date
+		ELSE io.date_censor
+	END date_censor, 
+
+	-- If you modify age_censor, don't forget to modify age_censor_days equivalently
+	CASE
+		-- Enrollment occurred after exclusion:
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)/365.25
+		-- Exclusion occurred after enrollment and prior to disease onset; we will exclude:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)/365.25
+		-- Exclusion occurred after disease onset; we'll allow it:
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN DATE_DIFF(io.date_censor,c.birthdate, DAY)/365.25 
+		-- Met exclusion but no inclusion
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)/365.25
+		-- Didn't meet exclusion or inclusion means we censor at the date given by UKBB:
+		WHEN io.has_disease IS NULL THEN c.phenotype_censor_age
+		ELSE DATE_DIFF(io.date_censor,c.birthdate, DAY)/365.25 
+	END age_censor, 
+
+	-- Designed to be a duplicate of age_censor but with days instead of years
+	CASE
+		WHEN eo.has_disease = 1 AND SAFE.DATE_DIFF(c.enroll_date, eo.date_censor, DAY) > 0 THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(io.date_censor,eo.date_censor, DAY) > 0 THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)
+		WHEN eo.has_disease = 1 AND io.has_disease = 1 AND SAFE.DATE_DIFF(eo.date_censor,io.date_censor, DAY) > 0 THEN DATE_DIFF(io.date_censor,c.birthdate, DAY)
+		WHEN eo.has_disease = 1 AND (io.has_disease = 0 OR io.has_disease IS NULL) THEN DATE_DIFF(eo.date_censor,c.birthdate, DAY)
+		WHEN io.has_disease IS NULL THEN c.phenotype_censor_age_days
+		ELSE DATE_DIFF(io.date_censor,c.birthdate, DAY)
+	END age_censor_days, 
+
+	c.birthdate, 
+	c.enroll_date, 
+	c.enroll_age, 
+	c.enroll_age_days,
+	CASE 
+		WHEN c.death_date IS NULL THEN 0 
+		ELSE 1 
+	END has_died,
+	CASE 
+		WHEN c.death_date IS NULL THEN c.death_censor_date 
+		ELSE c.death_date 
+	END death_date, 
+	CASE WHEN c.death_date IS NULL THEN c.death_censor_age 
+		ELSE c.death_age 
+	END death_age, 
+	CASE WHEN c.death_date IS NULL THEN c.death_censor_age_days 
+		ELSE c.death_age_days 
+	END death_age_days, 
+	c.computed_date, 
+	c.missing_fields
+FROM {{.database}}.censor c
+CROSS JOIN diseases dz
+LEFT JOIN included_only io ON io.sample_id=c.sample_id AND io.disease=dz.disease
+LEFT JOIN excluded_only eo ON eo.sample_id=c.sample_id AND eo.disease=dz.disease
+ORDER BY 
+	dz.disease_order, 
+	has_disease DESC, 
+	incident_disease DESC, 
+	age_censor ASC
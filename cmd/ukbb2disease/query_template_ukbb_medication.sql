@@ -0,0 +1,106 @@
+{{/* Produces the rows from which ExecuteMedications computes exposures. The
+	matching of @Definitions mirrors medicationMatcher. */}}
+
+WITH definitions AS (
+	SELECT * FROM UNNEST(@Definitions)
+)
+, scripts AS (
+	SELECT
+		ROW_NUMBER() OVER () script_id,
+		gp.eid sample_id,
+		-- Issue dates are distributed as dd/mm/yyyy
+		COALESCE(
+			SAFE.PARSE_DATE('%d/%m/%Y', CAST(gp.issue_date AS STRING)),
+			SAFE.PARSE_DATE('%F', CAST(gp.issue_date AS STRING))
+		) issue_date,
+		LOWER(COALESCE(gp.drug_name, '')) drug_name,
+		REGEXP_REPLACE(COALESCE(CAST(gp.read_2 AS STRING), ''), r'[. ]', '') read_2,
+		REGEXP_REPLACE(COALESCE(CAST(gp.bnf_code AS STRING), ''), r'[. ]', '') bnf_code,
+		REGEXP_REPLACE(COALESCE(CAST(gp.dmd_code AS STRING), ''), r'[. ]', '') dmd_code,
+	FROM `{{.database}}.gp_scripts` gp
+)
+, matched_scripts AS (
+	-- A prescription that matches a medication in more than one way is only
+	-- counted once
+	SELECT DISTINCT
+		d.medication_order,
+		s.script_id,
+		s.sample_id,
+		s.issue_date,
+	FROM scripts s
+	JOIN definitions d ON (
+		(d.source = 'name' AND STRPOS(s.drug_name, d.value) > 0)
+		OR (d.source = 'bnf' AND s.bnf_code != '' AND STARTS_WITH(s.bnf_code, d.value))
+		OR (d.source = 'read2' AND s.read_2 != '' AND STARTS_WITH(s.read_2, d.value))
+		OR (d.source = 'dmd' AND s.dmd_code = d.value)
+	)
+)
+, visit_dates AS (
+	SELECT
+		p.sample_id,
+		p.instance,
+		MIN(SAFE.PARSE_DATE('%E4Y-%m-%d', p.value)) visit_date,
+	FROM `{{.database}}.phenotype` p
+	WHERE TRUE
+		AND p.FieldID = 53
+		AND p.array_idx = 0
+	GROUP BY
+		p.sample_id,
+		p.instance
+)
+, self_reports AS (
+	SELECT DISTINCT
+		d.medication_order,
+		p.sample_id,
+		p.instance,
+	FROM `{{.database}}.phenotype` p
+	JOIN definitions d ON d.source = '20003' AND REGEXP_REPLACE(p.value, r'[. ]', '') = d.value
+	WHERE p.FieldID = 20003
+)
+
+SELECT
+	'participant' kind,
+	CAST(NULL AS INT64) medication_order,
+	c.sample_id,
+	CAST(NULL AS DATE) date,
+	CAST(NULL AS INT64) instance,
+	c.birthdate,
+	c.enroll_date,
+FROM `{{.database}}.censor` c
+
+UNION ALL
+
+SELECT DISTINCT
+	'coverage' kind,
+	CAST(NULL AS INT64) medication_order,
+	s.sample_id,
+	CAST(NULL AS DATE) date,
+	CAST(NULL AS INT64) instance,
+	CAST(NULL AS DATE) birthdate,
+	CAST(NULL AS DATE) enroll_date,
+FROM scripts s
+
+UNION ALL
+
+SELECT
+	'script' kind,
+	ms.medication_order,
+	ms.sample_id,
+	ms.issue_date date,
+	CAST(NULL AS INT64) instance,
+	CAST(NULL AS DATE) birthdate,
+	CAST(NULL AS DATE) enroll_date,
+FROM matched_scripts ms
+
+UNION ALL
+
+SELECT
+	'self_report' kind,
+	sr.medication_order,
+	sr.sample_id,
+	vd.visit_date date,
+	sr.instance,
+	CAST(NULL AS DATE) birthdate,
+	CAST(NULL AS DATE) enroll_date,
+FROM self_reports sr
+LEFT JOIN visit_dates vd ON vd.sample_id = sr.sample_id AND vd.instance = sr.instance
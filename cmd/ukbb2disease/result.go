@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/carbocation/pfx"
@@ -14,6 +13,7 @@ import (
 )
 
 type Result struct {
+	Disease                 string               `bigquery:"disease"` // Only set when evaluating a batch of diseases
 	SampleID                int64                `bigquery:"sample_id"`
 	HasDisease              bigquery.NullInt64   `bigquery:"has_disease"`
 	IncidentDisease         bigquery.NullInt64   `bigquery:"incident_disease"`
@@ -69,14 +69,14 @@ func (r Result) DeathAgeCensor() (bigquery.NullFloat64, error) {
 	return res, nil
 }
 
-func ExecuteQuery(BQ *WrappedBigQuery, query *bigquery.Query, diseaseName string, missingFields []string) error {
-	itr, err := query.Read(BQ.Context)
+func ExecuteQuery(src DataSource, tabs *TabFile, biobank Biobank, diseaseName string, missingFields []string) error {
+	itr, err := src.Results(tabs, biobank)
 	if err != nil {
-		return pfx.Err(fmt.Sprint(err.Error(), query.Parameters))
+		return err
 	}
 	todayDate := time.Now().Format("2006-01-02")
 	missing := strings.Join(missingFields, ",")
-	fmt.Fprintf(STDOUT, "disease\tsample_id\thas_disease\tincident_disease\tprevalent_disease\tmet_exclusion\tcensor_date\tcensor_age\tcensor_age_days\tbirthdate\tenroll_date\tenroll_age\tenroll_age_days\thas_died\tdeath_censor_date\tdeath_censor_age\tdeath_censor_age_days\tcensor_computed_date\tcensor_missing_fields\tcomputed_date\tmissing_fields\n")
+	printHeader()
 	for {
 		var r Result
 		err := itr.Next(&r)
@@ -87,41 +87,80 @@ func ExecuteQuery(BQ *WrappedBigQuery, query *bigquery.Query, diseaseName string
 			return pfx.Err(err)
 		}
 
-		censoredPhenoAge, err := r.PhenotypeAgeCensor()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: setting censor_age to enroll_age for %d (birthdate %s phenotype date %s) because %s\n", diseaseName, r.SampleID, r.BirthDate, r.PhenotypeDateCensor, err.Error())
-
-			// UK Biobank uses impossible values (e.g., 1900-01-01) to indicate that
-			// the date is not known. See, e.g., FieldID 42000. This does not mean
-			// that the value is illegal, so it shouldn't be null. Instead, it should
-			// be some legal value. Here, we set the age of incidence to be 0 years,
-			// and we set the date of incidence to be the birthdate.
-			censoredPhenoAge = r.EnrollAge
-			r.PhenotypeAgeCensorDays = r.EnrollAgeDays
-			r.PhenotypeDateCensor = r.EnrollDate
-		}
+		printResult(r, diseaseName, todayDate, missing)
+	}
+
+	return nil
+}
 
-		censoredDeathAge, err := r.DeathAgeCensor()
+// ExecuteBatch evaluates many diseases at once and prints them as one long
+// table, in the same layout as ExecuteQuery.
+func ExecuteBatch(src BatchDataSource, diseases []Disease, biobank Biobank) error {
+	missing := make(map[string]string, len(diseases))
+	for _, disease := range diseases {
+		missingFields, _ := disease.Tabs.CheckSensibility()
+		missing[disease.Name] = strings.Join(missingFields, ",")
+	}
+
+	itr, err := src.BatchResults(diseases, biobank)
+	if err != nil {
+		return err
+	}
+	todayDate := time.Now().Format("2006-01-02")
+	printHeader()
+	for {
+		var r Result
+		err := itr.Next(&r)
+		if err == iterator.Done {
+			break
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s: setting death_censor_age to enroll_age for %d (birthdate %s death date %s) because %s\n", diseaseName, r.SampleID, r.BirthDate, r.DeathDate, err.Error())
-
-			// UK Biobank uses impossible values (e.g., 1900-01-01) to indicate that
-			// the date is not known. See, e.g., FieldID 42000. This does not mean
-			// that the value is illegal, so it shouldn't be null. Instead, it should
-			// be some legal value. Here, we set the age of incidence to be 0 years,
-			// and we set the date of incidence to be the birthdate.
-			censoredDeathAge = r.EnrollAge
-			r.DeathAgeDays = r.EnrollAgeDays
-			r.DeathDate = r.EnrollDate
+			return pfx.Err(err)
 		}
 
-		fmt.Fprintf(STDOUT, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-			diseaseName, r.SampleID, NA(r.HasDisease), NA(r.IncidentDisease), NA(r.PrevalentDisease), NA(r.MetExclusion), NA(r.PhenotypeDateCensor), NA(censoredPhenoAge), NA(r.PhenotypeAgeCensorDays), NA(r.BirthDate), NA(r.EnrollDate), NA(r.EnrollAge), NA(r.EnrollAgeDays), NA(r.HasDied), NA(r.DeathDate), NA(censoredDeathAge), NA(r.DeathAgeDays), NA(r.ComputedDate), NA(r.MissingFields), todayDate, missing)
+		printResult(r, r.Disease, todayDate, missing[r.Disease])
 	}
 
 	return nil
 }
 
+func printHeader() {
+	fmt.Fprintf(STDOUT, "disease\tsample_id\thas_disease\tincident_disease\tprevalent_disease\tmet_exclusion\tcensor_date\tcensor_age\tcensor_age_days\tbirthdate\tenroll_date\tenroll_age\tenroll_age_days\thas_died\tdeath_censor_date\tdeath_censor_age\tdeath_censor_age_days\tcensor_computed_date\tcensor_missing_fields\tcomputed_date\tmissing_fields\n")
+}
+
+func printResult(r Result, diseaseName, todayDate, missing string) {
+	censoredPhenoAge, err := r.PhenotypeAgeCensor()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: setting censor_age to enroll_age for %d (birthdate %s phenotype date %s) because %s\n", diseaseName, r.SampleID, r.BirthDate, r.PhenotypeDateCensor, err.Error())
+
+		// UK Biobank uses impossible values (e.g., 1900-01-01) to indicate that
+		// the date is not known. See, e.g., FieldID 42000. This does not mean
+		// that the value is illegal, so it shouldn't be null. Instead, it should
+		// be some legal value. Here, we set the age of incidence to be 0 years,
+		// and we set the date of incidence to be the birthdate.
+		censoredPhenoAge = r.EnrollAge
+		r.PhenotypeAgeCensorDays = r.EnrollAgeDays
+		r.PhenotypeDateCensor = r.EnrollDate
+	}
+
+	censoredDeathAge, err := r.DeathAgeCensor()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: setting death_censor_age to enroll_age for %d (birthdate %s death date %s) because %s\n", diseaseName, r.SampleID, r.BirthDate, r.DeathDate, err.Error())
+
+		// UK Biobank uses impossible values (e.g., 1900-01-01) to indicate that
+		// the date is not known. See, e.g., FieldID 42000. This does not mean
+		// that the value is illegal, so it shouldn't be null. Instead, it should
+		// be some legal value. Here, we set the age of incidence to be 0 years,
+		// and we set the date of incidence to be the birthdate.
+		censoredDeathAge = r.EnrollAge
+		r.DeathAgeDays = r.EnrollAgeDays
+		r.DeathDate = r.EnrollDate
+	}
+
+	fmt.Fprintf(STDOUT, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		diseaseName, r.SampleID, NA(r.HasDisease), NA(r.IncidentDisease), NA(r.PrevalentDisease), NA(r.MetExclusion), NA(r.PhenotypeDateCensor), NA(censoredPhenoAge), NA(r.PhenotypeAgeCensorDays), NA(r.BirthDate), NA(r.EnrollDate), NA(r.EnrollAge), NA(r.EnrollAgeDays), NA(r.HasDied), NA(r.DeathDate), NA(censoredDeathAge), NA(r.DeathAgeDays), NA(r.ComputedDate), NA(r.MissingFields), todayDate, missing)
+}
+
 // NA emits an empty string instead of "NULL" since this plays better with
 // BigQuery
 func NA(input interface{}) interface{} {
@@ -149,7 +188,7 @@ func NA(input interface{}) interface{} {
 	return input
 }
 
-func BuildQuery(BQ *WrappedBigQuery, tabs *TabFile, displayQuery bool, biobankSource string) (*bigquery.Query, error) {
+func BuildQuery(BQ *WrappedBigQuery, tabs *TabFile, displayQuery bool, biobank Biobank) (*bigquery.Query, error) {
 	params := []bigquery.QueryParameter{}
 
 	// By default, if there is no undated query, pull no data (the query will be
@@ -167,17 +206,13 @@ func BuildQuery(BQ *WrappedBigQuery, tabs *TabFile, displayQuery bool, biobankSo
 		i := 0
 		for _, v := range includedValues {
 			// The field key specificier (e.g., "ICD" or 42000) depends on
-			// whether the biobank uses numeric field IDs or not. However, the
-			// paired field value parameter is always a string. The same
-			// explanation is true for the exclusion portion below.
-			if DoesBiobankUseNumericFieldID(biobankSource) {
-				includePart = includePart + fmt.Sprintf("\nOR (hd.FieldID = @IncludeParts%d AND hd.value IN UNNEST(@IncludeParts%d) )", i, i+1)
-				params = append(params, bigquery.QueryParameter{Name: fmt.Sprintf("IncludeParts%d", i), Value: v.FieldID})
-			} else {
-				includePart = includePart + fmt.Sprintf("\nOR (hd.FieldName = @IncludeParts%d AND hd.value IN UNNEST(@IncludeParts%d) )", i, i+1)
-				params = append(params, bigquery.QueryParameter{Name: fmt.Sprintf("IncludeParts%d", i), Value: v.FieldName})
-			}
-			params = append(params, bigquery.QueryParameter{Name: fmt.Sprintf("IncludeParts%d", i+1), Value: v.FormattedValues(biobankSource)})
+			// the biobank. However, the paired field value parameter is
+			// always a string. The same explanation is true for the
+			// exclusion portion below.
+			fieldCondition, fieldValue := biobank.FieldCondition(v, fmt.Sprintf("IncludeParts%d", i))
+			includePart = includePart + fmt.Sprintf("\nOR (%s AND hd.value IN UNNEST(@IncludeParts%d) )", fieldCondition, i+1)
+			params = append(params, bigquery.QueryParameter{Name: fmt.Sprintf("IncludeParts%d", i), Value: fieldValue})
+			params = append(params, bigquery.QueryParameter{Name: fmt.Sprintf("IncludeParts%d", i+1), Value: v.FormattedValues(biobank)})
 			i += 2
 		}
 	}
@@ -187,14 +222,10 @@ func BuildQuery(BQ *WrappedBigQuery, tabs *TabFile, displayQuery bool, biobankSo
 	if len(exludedValues) > 0 {
 		i := 0
 		for _, v := range exludedValues {
-			if DoesBiobankUseNumericFieldID(biobankSource) {
-				excludePart = excludePart + fmt.Sprintf("\nOR (hd.FieldID = @ExcludeParts%d AND hd.value IN UNNEST(@ExcludeParts%d) )", i, i+1)
-				params = append(params, bigquery.QueryParameter{Name: fmt.Sprintf("ExcludeParts%d", i), Value: v.FieldID})
-			} else {
-				excludePart = excludePart + fmt.Sprintf("\nOR (hd.FieldName = @ExcludeParts%d AND hd.value IN UNNEST(@ExcludeParts%d) )", i, i+1)
-				params = append(params, bigquery.QueryParameter{Name: fmt.Sprintf("ExcludeParts%d", i), Value: v.FieldName})
-			}
-			params = append(params, bigquery.QueryParameter{Name: fmt.Sprintf("ExcludeParts%d", i+1), Value: v.FormattedValues(biobankSource)})
+			fieldCondition, fieldValue := biobank.FieldCondition(v, fmt.Sprintf("ExcludeParts%d", i))
+			excludePart = excludePart + fmt.Sprintf("\nOR (%s AND hd.value IN UNNEST(@ExcludeParts%d) )", fieldCondition, i+1)
+			params = append(params, bigquery.QueryParameter{Name: fmt.Sprintf("ExcludeParts%d", i), Value: fieldValue})
+			params = append(params, bigquery.QueryParameter{Name: fmt.Sprintf("ExcludeParts%d", i+1), Value: v.FormattedValues(biobank)})
 			i += 2
 		}
 	}
@@ -213,30 +244,15 @@ func BuildQuery(BQ *WrappedBigQuery, tabs *TabFile, displayQuery bool, biobankSo
 		"excludePart":  excludePart,
 	}
 
-	// Assemble all the parts
-	// Fetch desired template from embedded resources
-	templateBytes, err := embeddedQueryTemplates.ReadFile(fmt.Sprintf("query_template_%s.sql", biobankSource))
+	// Assemble all the parts from the biobank's template
+	populatedQuery, err := renderQuery(biobank, false, queryParts)
 	if err != nil {
 		return nil, err
 	}
 
-	// Parse the selected template
-	queryTemplate, err := template.New("").
-		Funcs(template.FuncMap(map[string]interface{}{"mkMap": mkMap})).
-		Parse(string(templateBytes))
-	if err != nil {
-		return nil, err
-	}
-
-	// (execute the template)
-	populatedQuery := &strings.Builder{}
-	if err := queryTemplate.Execute(populatedQuery, queryParts); err != nil {
-		return nil, err
-	}
-
 	if displayQuery {
 
-		fmt.Println(populatedQuery.String())
+		fmt.Println(populatedQuery)
 		fmt.Println("Query parameters:")
 
 		for _, v := range params {
@@ -250,7 +266,88 @@ func BuildQuery(BQ *WrappedBigQuery, tabs *TabFile, displayQuery bool, biobankSo
 	}
 
 	// Generate the bigquery query object, but don't call it
-	bqQuery := BQ.Client.Query(populatedQuery.String())
+	bqQuery := BQ.Client.Query(populatedQuery)
+	bqQuery.QueryConfig.Parameters = append(bqQuery.QueryConfig.Parameters, params...)
+
+	return bqQuery, nil
+}
+
+// batchDefinition is one row of the @Definitions parameter of the batched
+// query: a value of a FieldID that includes (or excludes) a participant for a
+// disease.
+type batchDefinition struct {
+	Disease      string `bigquery:"disease"`
+	DiseaseOrder int64  `bigquery:"disease_order"`
+	Exclude      bool   `bigquery:"exclude"`
+	FieldID      int64  `bigquery:"FieldID"`
+	Value        string `bigquery:"value"`
+}
+
+// BuildBatchQuery assembles a single query that evaluates every one of the
+// diseases. Rather than composing one clause per tabfile entry, as BuildQuery
+// does, the definitions are passed as a table-valued parameter.
+func BuildBatchQuery(BQ *WrappedBigQuery, diseases []Disease, displayQuery bool, biobank Biobank) (*bigquery.Query, error) {
+	// Fail before assembling the definitions if the biobank has no batched
+	// query
+	if _, err := biobank.QueryTemplate(true); err != nil {
+		return nil, err
+	}
+
+	definitions := make([]batchDefinition, 0)
+	standardFields := make([]int, 0)
+	seenStandard := make(map[int]struct{})
+	for i, disease := range diseases {
+		for _, entries := range [][]TabEntry{disease.Tabs.AllIncluded(), disease.Tabs.AllExcluded()} {
+			for _, entry := range entries {
+				for _, value := range entry.FormattedValues(biobank) {
+					definitions = append(definitions, batchDefinition{
+						Disease:      disease.Name,
+						DiseaseOrder: int64(i),
+						Exclude:      entry.Exclude,
+						FieldID:      int64(entry.FieldID),
+						Value:        value,
+					})
+				}
+			}
+		}
+
+		for _, fieldID := range disease.Tabs.AllStandardFields() {
+			if _, exists := seenStandard[fieldID]; !exists {
+				seenStandard[fieldID] = struct{}{}
+				standardFields = append(standardFields, fieldID)
+			}
+		}
+	}
+
+	params := []bigquery.QueryParameter{{Name: "Definitions", Value: definitions}}
+
+	standardPart := "AND FALSE"
+	if len(standardFields) > 0 {
+		standardPart = "AND p.FieldID IN UNNEST(@StandardFieldIDs)"
+		params = append(params, bigquery.QueryParameter{Name: "StandardFieldIDs", Value: standardFields})
+	}
+
+	queryParts := map[string]interface{}{
+		"database":             BQ.Database,
+		"materializedDatabase": BQ.MaterializedDB,
+		"use_gp":               BQ.UseGP,
+		"standardPart":         standardPart,
+	}
+
+	populatedQuery, err := renderQuery(biobank, true, queryParts)
+	if err != nil {
+		return nil, err
+	}
+
+	if displayQuery {
+		fmt.Println(populatedQuery)
+		fmt.Println("Query parameters:")
+		fmt.Printf("Definitions: %d rows for %d diseases\n", len(definitions), len(diseases))
+		fmt.Printf("StandardFieldIDs: %v\n", standardFields)
+		return nil, nil
+	}
+
+	bqQuery := BQ.Client.Query(populatedQuery)
 	bqQuery.QueryConfig.Parameters = append(bqQuery.QueryConfig.Parameters, params...)
 
 	return bqQuery, nil
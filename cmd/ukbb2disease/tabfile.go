@@ -9,6 +9,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/carbocation/genomisc/ukbb"
 )
 
 type TabEntry struct {
@@ -18,25 +20,16 @@ type TabEntry struct {
 	Exclude   bool
 }
 
-// The UK Biobank keys up the ICD and OPCS codes without any decimals, so e.g.,
-// K41.2 becomes K412. You can look up the true value using the coding table,
-// but for simplicity we just strip the dots.
-func (t TabEntry) FormattedValues(biobankSource string) []string {
+// FormattedValues returns the values as the biobank stores them. E.g., the UK
+// Biobank keys up the ICD and OPCS codes without any decimals, so K41.2 becomes
+// K412. You can look up the true value using the coding table, but for
+// simplicity we just strip the dots.
+func (t TabEntry) FormattedValues(biobank Biobank) []string {
 	out := make([]string, 0, len(t.Values))
 
-	// In the UK Biobank HESIN data, fields are special-cased to exclude "." (So
-	// K41.2 becomes K412)
-	if biobankSource == BiobankSourceUKBiobank && IsHesin(t.FieldID) {
-		for _, v := range t.Values {
-			out = append(out, strings.Replace(v, ".", "", -1))
-		}
-	} else {
-		out = append(out, t.Values...)
-	}
-
 	// Every field will get leading and trailing spaces trimmed
-	for i, v := range out {
-		out[i] = strings.TrimSpace(v)
+	for _, v := range t.Values {
+		out = append(out, strings.TrimSpace(biobank.NormalizeCode(t, v)))
 	}
 
 	return out
@@ -132,50 +125,50 @@ func (t *TabFile) CheckSensibility() ([]string, error) {
 		opcs := make(map[int]struct{})
 
 		for _, v := range batch {
-			// Capitalized ICD9 (etc) here refer to the manual global maps that
-			// we create in special_fields.go which list all possible FieldIDs
+			// ukbb.ICD9 (etc) here refer to the manual global maps that
+			// we create in ukbb/specialfields.go which list all possible FieldIDs
 			// for ICD9 codes. Lower case icd9 represents the local map that
 			// will track which of those FieldIDs actually appeared in the
 			// tabfile. Basically, this is a lookup to see whether the field we
 			// are currently looking at is an ICD9 field, etc.
-			if _, exists := ICD9[v.FieldID]; exists {
+			if _, exists := ukbb.ICD9[v.FieldID]; exists {
 				icd9[v.FieldID] = struct{}{}
 			}
-			if _, exists := ICD10[v.FieldID]; exists {
+			if _, exists := ukbb.ICD10[v.FieldID]; exists {
 				icd10[v.FieldID] = struct{}{}
 			}
-			if _, exists := OPCS[v.FieldID]; exists {
+			if _, exists := ukbb.OPCS[v.FieldID]; exists {
 				opcs[v.FieldID] = struct{}{}
 			}
 		}
 
 		// So, you included at least one ICD9 field, but you failed to include
 		// all of them. Probably an error.
-		if len(icd9) > 0 && len(icd9) < len(ICD9) {
-			for known := range ICD9 {
+		if len(icd9) > 0 && len(icd9) < len(ukbb.ICD9) {
+			for known := range ukbb.ICD9 {
 				if _, exists := icd9[known]; !exists {
 					missingFields[known] = struct{}{}
 				}
 			}
-			errs = append(errs, fmt.Sprintf("In the %s subset, you included ICD9 fields %v. Consider including all IC9 fields: %v", batchnames[batchID], icd9, ICD9))
+			errs = append(errs, fmt.Sprintf("In the %s subset, you included ICD9 fields %v. Consider including all IC9 fields: %v", batchnames[batchID], icd9, ukbb.ICD9))
 		}
 
-		if len(icd10) > 0 && len(icd10) < len(ICD10) {
-			for known := range ICD10 {
+		if len(icd10) > 0 && len(icd10) < len(ukbb.ICD10) {
+			for known := range ukbb.ICD10 {
 				if _, exists := icd10[known]; !exists {
 					missingFields[known] = struct{}{}
 				}
 			}
-			errs = append(errs, fmt.Sprintf("In the %s subset, you included ICD10 fields %v. Consider including all IC10 fields: %v", batchnames[batchID], icd10, ICD10))
+			errs = append(errs, fmt.Sprintf("In the %s subset, you included ICD10 fields %v. Consider including all IC10 fields: %v", batchnames[batchID], icd10, ukbb.ICD10))
 		}
 
-		if len(opcs) > 0 && len(opcs) < len(OPCS) {
-			for known := range OPCS {
+		if len(opcs) > 0 && len(opcs) < len(ukbb.OPCS) {
+			for known := range ukbb.OPCS {
 				if _, exists := opcs[known]; !exists {
 					missingFields[known] = struct{}{}
 				}
 			}
-			errs = append(errs, fmt.Sprintf("In the %s subset, you included OPCS fields %v. Consider including all OPCS fields: %v", batchnames[batchID], opcs, OPCS))
+			errs = append(errs, fmt.Sprintf("In the %s subset, you included OPCS fields %v. Consider including all OPCS fields: %v", batchnames[batchID], opcs, ukbb.OPCS))
 		}
 	}
 
@@ -303,9 +296,27 @@ func (t *TabFile) consolidateDuplicates() error {
 	return nil
 }
 
+// add assigns an entry to the inclusion or exclusion list of the right field
+// type.
+func (t *TabFile) add(entry TabEntry, biobank Biobank) {
+	include, exclude := &t.Include.Standard, &t.Exclude.Standard
+	switch biobank.Classify(entry) {
+	case FieldClassHesin:
+		include, exclude = &t.Include.Hesin, &t.Exclude.Hesin
+	case FieldClassSpecial:
+		include, exclude = &t.Include.Special, &t.Exclude.Special
+	}
+
+	if entry.Exclude {
+		*exclude = append(*exclude, entry)
+	} else {
+		*include = append(*include, entry)
+	}
+}
+
 // ParseTabFile consumes a tabfile and returns our machine representation of
 // that file
-func ParseTabFile(tabPath, biobankSource string) (*TabFile, error) {
+func ParseTabFile(tabPath string, biobank Biobank) (*TabFile, error) {
 	f, err := os.Open(tabPath)
 	if err != nil {
 		return nil, err
@@ -337,38 +348,11 @@ func ParseTabFile(tabPath, biobankSource string) (*TabFile, error) {
 			Exclude: row[2] == "1",
 		}
 
-		if DoesBiobankUseNumericFieldID(biobankSource) {
-			entry.FieldID, err = strconv.Atoi(row[0])
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			entry.FieldName = row[0]
+		if err := biobank.ParseField(row[0], &entry); err != nil {
+			return nil, fmt.Errorf("Tabfile %s row %d: %v", tabPath, i, err)
 		}
 
-		// Assign to the right field type
-		switch entry.Exclude {
-		case true:
-			// For now, treat all non-numeric field types (e.g., 'ICD10CM') as
-			// if they have an associated date.
-			if IsHesin(entry.FieldID) || !DoesBiobankUseNumericFieldID(biobankSource) {
-				output.Exclude.Hesin = append(output.Exclude.Hesin, entry)
-			} else if IsSpecial(entry.FieldID) {
-				output.Exclude.Special = append(output.Exclude.Special, entry)
-			} else {
-				output.Exclude.Standard = append(output.Exclude.Standard, entry)
-			}
-		default:
-			// For now, treat all non-numeric field types (e.g., 'ICD10CM') as
-			// if they have an associated date.
-			if IsHesin(entry.FieldID) || !DoesBiobankUseNumericFieldID(biobankSource) {
-				output.Include.Hesin = append(output.Include.Hesin, entry)
-			} else if IsSpecial(entry.FieldID) {
-				output.Include.Special = append(output.Include.Special, entry)
-			} else {
-				output.Include.Standard = append(output.Include.Standard, entry)
-			}
-		}
+		output.add(entry, biobank)
 	}
 
 	if err := output.consolidateDuplicates(); err != nil {
@@ -377,3 +361,27 @@ func ParseTabFile(tabPath, biobankSource string) (*TabFile, error) {
 
 	return output, nil
 }
+
+// ExpandCodes replaces the wildcards (I21*), ranges (I20-I25), and blocks
+// (Block I20-I25) in the values of ICD-10, ICD-9, and OPCS-4 fields with every
+// code that they match in the UK Biobank coding trees, as in ukbb.ExpandCodes.
+// Entries without such patterns are left as-is. The trees are only needed if
+// the tabfile uses such patterns.
+func (t *TabFile) ExpandCodes(trees map[string]*ukbb.CodingTree) error {
+	lists := []*[]TabEntry{
+		&t.Include.Hesin, &t.Include.Special, &t.Include.Standard,
+		&t.Exclude.Hesin, &t.Exclude.Special, &t.Exclude.Standard,
+	}
+
+	for _, list := range lists {
+		for i, entry := range *list {
+			values, err := ukbb.ExpandCodes(entry.FieldID, entry.Values, trees)
+			if err != nil {
+				return err
+			}
+			(*list)[i].Values = values
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/carbocation/pfx"
+	"google.golang.org/api/iterator"
+)
+
+// Output modes
+const (
+	OutputIntervals           = "intervals"
+	OutputFineGray            = "finegray"
+	OutputMultiState          = "msstate"
+	OutputCumulativeIncidence = "cif"
+)
+
+// Event codes of the competing-risk outputs
+const (
+	EventCensored = 0
+	EventDisease  = 1
+	EventDeath    = 2
+)
+
+// forEachParticipant groups the Results, which are ordered by sample_id, into
+// the follow-up intervals of each participant.
+func forEachParticipant(itr ResultIterator, fn func(intervals []Result) error) error {
+	var intervals []Result
+	for {
+		var r Result
+		err := itr.Next(&r)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return pfx.Err(err)
+		}
+
+		if len(intervals) > 0 && intervals[0].SampleID != r.SampleID {
+			if err := fn(intervals); err != nil {
+				return err
+			}
+			intervals = nil
+		}
+		intervals = append(intervals, r)
+	}
+
+	if len(intervals) > 0 {
+		return fn(intervals)
+	}
+
+	return nil
+}
+
+// daysSinceEnroll is the follow-up time of a date. It is computed from the
+// dates, rather than from days_since_start_date, since the latter counts the
+// first incident interval of prevalent cases from their prevalent event.
+func daysSinceEnroll(r Result, date bigquery.NullDate) int {
+	return date.Date.DaysSince(r.EnrollDate.Date)
+}
+
+// ageDays is the age on a date, in days.
+func ageDays(r Result, date bigquery.NullDate) bigquery.NullInt64 {
+	if !r.BirthDate.Valid || !date.Valid {
+		return bigquery.NullInt64{}
+	}
+
+	return bigquery.NullInt64{Int64: int64(date.Date.DaysSince(r.BirthDate.Date)), Valid: true}
+}
+
+// competingEvent is the first incident event after enrollment, with death as a
+// competing event. Participants whose follow-up begins with disease are
+// prevalent.
+type competingEvent struct {
+	SampleID     int64
+	Prevalent    bool
+	Time         int
+	Event        int
+	Status       StatusEnum
+	StartDate    bigquery.NullDate
+	EndDate      bigquery.NullDate
+	StartAgeDays bigquery.NullInt64
+	EndAgeDays   bigquery.NullInt64
+}
+
+func firstCompetingEvent(intervals []Result) competingEvent {
+	first := intervals[0]
+
+	// The first interval that ends with disease or death ends the follow-up;
+	// otherwise, the final interval ends with censoring.
+	last := intervals[len(intervals)-1]
+	for _, r := range intervals {
+		if r.StatusEnd == Disease || r.StatusEnd == Died {
+			last = r
+			break
+		}
+	}
+
+	out := competingEvent{
+		SampleID:     first.SampleID,
+		Prevalent:    first.StatusStart == Disease,
+		Time:         daysSinceEnroll(first, last.EndDate),
+		Event:        EventCensored,
+		Status:       last.StatusEnd,
+		StartDate:    first.StartDate,
+		EndDate:      last.EndDate,
+		StartAgeDays: ageDays(first, first.StartDate),
+		EndAgeDays:   last.EndAgeDays,
+	}
+	switch last.StatusEnd {
+	case Disease:
+		out.Event = EventDisease
+	case Died:
+		out.Event = EventDeath
+	}
+
+	return out
+}
+
+// ExecuteFineGray prints one row per participant with the time from enrollment
+// to the first incident disease event, with death as a competing event, as
+// expected by, e.g., cmprsk::crr or survival::finegray.
+func ExecuteFineGray(src DataSource, tabs *TabFile, diseaseName string) error {
+	defer STDOUT.Flush()
+
+	itr, err := src.Results(tabs)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(STDOUT, "disease\tsample_id\tprevalent\ttime_days\tevent\tevent_name\tstart_date\tend_date\tstart_age_days\tend_age_days\n")
+	return forEachParticipant(itr, func(intervals []Result) error {
+		e := firstCompetingEvent(intervals)
+		_, err := fmt.Fprintf(STDOUT, "%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			diseaseName, e.SampleID, boolToInt(e.Prevalent), e.Time, e.Event, e.Status.Simplify().String(),
+			NA(e.StartDate), NA(e.EndDate), NA(e.StartAgeDays), NA(e.EndAgeDays))
+		return err
+	})
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// multiState numbers the states of a multi-state model in which participants
+// start event-free (state 1), move through up to MaxEvents disease states
+// (Disease1 is state 2, and so on), and can die from any of them. Disease
+// events beyond MaxEvents do not change the state.
+type multiState struct {
+	MaxEvents int
+}
+
+func (m multiState) Died() int {
+	return m.MaxEvents + 2
+}
+
+func (m multiState) Name(state int) string {
+	switch {
+	case state == 1:
+		return NoDisease.String()
+	case state == m.Died():
+		return Died.String()
+	default:
+		return fmt.Sprintf("%s%d", Disease, state-1)
+	}
+}
+
+// Transitions lists the allowed transitions out of a state. They are numbered
+// in the order of mstate::transMat: by the state of origin, then by the
+// destination.
+func (m multiState) Transitions(from int) (to []int, trans []int) {
+	number := 1
+	for state := 1; state < m.Died(); state++ {
+		destinations := []int{m.Died()}
+		if state <= m.MaxEvents {
+			destinations = []int{state + 1, m.Died()}
+		}
+
+		if state == from {
+			for i := range destinations {
+				trans = append(trans, number+i)
+			}
+			return destinations, trans
+		}
+		number += len(destinations)
+	}
+
+	return nil, nil
+}
+
+// multiStateRow is one row of the long-format transition table: a participant
+// at risk of the transition from => to between Tstart and Tstop.
+type multiStateRow struct {
+	SampleID      int64
+	From, To      int
+	Trans         int
+	Tstart        int
+	Tstop         int
+	Status        int
+	TstartAgeDays int64
+	TstopAgeDays  int64
+}
+
+// Rows converts the follow-up intervals of one participant into the
+// long-format transition table of mstate::msprep, with times in days since
+// enrollment.
+func (m multiState) Rows(intervals []Result) []multiStateRow {
+	first := intervals[0]
+	state := 1
+	if first.StatusStart == Disease {
+		state = 2
+	}
+
+	// The participant stays in state from sojournStart until a transition or
+	// until follow-up ends.
+	sojournStart := 0
+	sojournStartAge := ageDays(first, first.StartDate).Int64
+	var out []multiStateRow
+	emit := func(stop int, stopAge int64, to int) {
+		destinations, trans := m.Transitions(state)
+		for i, destination := range destinations {
+			out = append(out, multiStateRow{
+				SampleID:      first.SampleID,
+				From:          state,
+				To:            destination,
+				Trans:         trans[i],
+				Tstart:        sojournStart,
+				Tstop:         stop,
+				Status:        boolToInt(destination == to),
+				TstartAgeDays: sojournStartAge,
+				TstopAgeDays:  stopAge,
+			})
+		}
+	}
+
+	for _, r := range intervals {
+		stop := daysSinceEnroll(r, r.EndDate)
+		switch {
+		case r.StatusEnd == Died:
+			emit(stop, r.EndAgeDays.Int64, m.Died())
+			return out
+		case r.StatusEnd == Disease && state <= m.MaxEvents:
+			emit(stop, r.EndAgeDays.Int64, state+1)
+			state++
+			sojournStart, sojournStartAge = stop, r.EndAgeDays.Int64
+		case r.StatusEnd != Disease:
+			// Censored, lost to follow-up, or excluded
+			emit(stop, r.EndAgeDays.Int64, 0)
+			return out
+		}
+	}
+
+	return out
+}
+
+// ExecuteMultiState prints the long-format transition table (from, to, trans,
+// Tstart, Tstop, time, status) for a multi-state model with up to maxEvents
+// disease states and death. The transitions are described on stderr.
+func ExecuteMultiState(src DataSource, tabs *TabFile, diseaseName string, maxEvents int) error {
+	defer STDOUT.Flush()
+
+	if maxEvents < 1 {
+		return fmt.Errorf("The multi-state model needs at least 1 disease state, not %d", maxEvents)
+	}
+	m := multiState{MaxEvents: maxEvents}
+
+	fmt.Fprintln(os.Stderr, "Transitions:")
+	for from := 1; from < m.Died(); from++ {
+		destinations, trans := m.Transitions(from)
+		for i, to := range destinations {
+			fmt.Fprintf(os.Stderr, "\t%d: %d (%s) => %d (%s)\n", trans[i], from, m.Name(from), to, m.Name(to))
+		}
+	}
+
+	itr, err := src.Results(tabs)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(STDOUT, "disease\tsample_id\tfrom\tto\ttrans\tTstart\tTstop\ttime\tstatus\tfrom_state\tto_state\tTstart_age_days\tTstop_age_days\n")
+	return forEachParticipant(itr, func(intervals []Result) error {
+		for _, row := range m.Rows(intervals) {
+			if _, err := fmt.Fprintf(STDOUT, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\t%d\t%d\n",
+				diseaseName, row.SampleID, row.From, row.To, row.Trans, row.Tstart, row.Tstop, row.Tstop-row.Tstart, row.Status, m.Name(row.From), m.Name(row.To), row.TstartAgeDays, row.TstopAgeDays); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// cifPoint is the Aalen-Johansen estimate at one time at which an event or
+// censoring occurred.
+type cifPoint struct {
+	Time       int
+	AtRisk     int
+	Diseased   int
+	Died       int
+	Censored   int
+	EventFree  float64
+	CIFDisease float64
+	CIFDeath   float64
+}
+
+// aalenJohansen estimates the cumulative incidence of disease and of death
+// (without disease) in the presence of each other. At tied times, events are
+// counted before censoring.
+func aalenJohansen(events []competingEvent) []cifPoint {
+	sorted := append([]competingEvent(nil), events...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time < sorted[j].Time })
+
+	var out []cifPoint
+	atRisk := len(sorted)
+	eventFree, cifDisease, cifDeath := 1.0, 0.0, 0.0
+	for i := 0; i < len(sorted); {
+		p := cifPoint{Time: sorted[i].Time, AtRisk: atRisk}
+		for ; i < len(sorted) && sorted[i].Time == p.Time; i++ {
+			switch sorted[i].Event {
+			case EventDisease:
+				p.Diseased++
+			case EventDeath:
+				p.Died++
+			default:
+				p.Censored++
+			}
+		}
+
+		n := float64(p.AtRisk)
+		cifDisease += eventFree * float64(p.Diseased) / n
+		cifDeath += eventFree * float64(p.Died) / n
+		eventFree *= 1 - float64(p.Diseased+p.Died)/n
+		atRisk -= p.Diseased + p.Died + p.Censored
+
+		p.EventFree, p.CIFDisease, p.CIFDeath = eventFree, cifDisease, cifDeath
+		out = append(out, p)
+	}
+
+	return out
+}
+
+// ReadGroups reads a tab-delimited file with a header that has a sample_id
+// column and a column named groupColumn.
+func ReadGroups(path, groupColumn string) (map[int64]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.Comma = '\t'
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Reading the header of %s: %v", path, err)
+	}
+	sampleCol, groupCol := -1, -1
+	for i, v := range header {
+		switch v {
+		case "sample_id":
+			sampleCol = i
+		case groupColumn:
+			groupCol = i
+		}
+	}
+	if sampleCol < 0 || groupCol < 0 {
+		return nil, fmt.Errorf("%s must have sample_id and %s columns", path, groupColumn)
+	}
+
+	out := make(map[int64]string)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Reading %s: %v", path, err)
+		}
+
+		sampleID, err := strconv.ParseInt(row[sampleCol], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		out[sampleID] = row[groupCol]
+	}
+
+	return out, nil
+}
+
+// ExecuteCumulativeIncidence prints the Aalen-Johansen cumulative incidence of
+// the first incident disease event, with death as a competing event, for each
+// group. Prevalent cases are left out. If groups is nil, all participants form
+// one group named "all"; otherwise, participants without a group are left out.
+func ExecuteCumulativeIncidence(src DataSource, tabs *TabFile, diseaseName string, groups map[int64]string) error {
+	defer STDOUT.Flush()
+
+	itr, err := src.Results(tabs)
+	if err != nil {
+		return err
+	}
+
+	byGroup := make(map[string][]competingEvent)
+	err = forEachParticipant(itr, func(intervals []Result) error {
+		e := firstCompetingEvent(intervals)
+		if e.Prevalent {
+			return nil
+		}
+
+		group := "all"
+		if groups != nil {
+			var exists bool
+			if group, exists = groups[e.SampleID]; !exists {
+				return nil
+			}
+		}
+		byGroup[group] = append(byGroup[group], e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(byGroup))
+	for group := range byGroup {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+
+	todayDate := time.Now().Format("2006-01-02")
+	fmt.Fprintf(STDOUT, "disease\tgroup\ttime_days\tn_risk\tn_disease\tn_died\tn_censored\tevent_free\tcif_disease\tcif_died\tcomputed_date\n")
+	for _, group := range names {
+		for _, p := range aalenJohansen(byGroup[group]) {
+			fmt.Fprintf(STDOUT, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%.6f\t%.6f\t%.6f\t%s\n",
+				diseaseName, group, p.Time, p.AtRisk, p.Diseased, p.Died, p.Censored, p.EventFree, p.CIFDisease, p.CIFDeath, todayDate)
+		}
+	}
+
+	return nil
+}
+
+// ValidOutput reports whether output is one of the output modes.
+func ValidOutput(output string) bool {
+	for _, v := range []string{OutputIntervals, OutputFineGray, OutputMultiState, OutputCumulativeIncidence} {
+		if v == output {
+			return true
+		}
+	}
+
+	return false
+}
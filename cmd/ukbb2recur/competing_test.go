@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompetingRiskOutputs(t *testing.T) {
+	dir := t.TempDir()
+	tables := map[string]string{
+		"censor": "sample_id\tbirthdate\tenroll_date\tdeath_date\tlost_to_followup_date\tphenotype_censor_date\n" +
+			"1\t1950-01-01\t2008-01-01\t2018-01-01\t\t2020-01-01\n" +
+			"2\t1950-01-01\t2008-01-01\t\t\t2020-01-01\n" +
+			"3\t1950-01-01\t2008-01-01\t\t\t2020-01-01\n" +
+			"4\t1950-01-01\t2008-01-01\t2016-01-01\t\t2020-01-01\n",
+		"materialized_hesin_dates_all": "sample_id\tFieldID\tvalue\tdsource\tsource\tfirst_date\n" +
+			"1\t41202\tI21\tHESIN\tprimary\t2005-01-01\n" +
+			"1\t41202\tI21\tHESIN\tprimary\t2010-01-01\n" +
+			"1\t41204\tI21\tHESIN\tsecondary\t2012-01-01\n" +
+			"2\t41202\tI25\tHESIN\tprimary\t2011-01-01\n" +
+			"4\t41202\tI21\tHESIN\tprimary\t2009-01-01\n" +
+			"4\t41202\tI21\tHESIN\tprimary\t2012-01-01\n",
+		"materialized_special_dates": "sample_id\tFieldID\tvalue\tfirst_date\n",
+	}
+	for name, contents := range tables {
+		if err := os.WriteFile(filepath.Join(dir, name+".tsv"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tabPath := filepath.Join(dir, "mi.tab")
+	tab := "FieldID\tvalues\texclude\n" +
+		"41202\tI21\t0\n" +
+		"41204\tI21\t0\n" +
+		"41202\tI25\t1\n"
+	if err := os.WriteFile(tabPath, []byte(tab), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tabs, err := ParseTabFile(tabPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	itr, err := (&LocalFiles{Dir: dir}).Results(tabs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fineGray, msstate []string
+	m := multiState{MaxEvents: 2}
+	err = forEachParticipant(itr, func(intervals []Result) error {
+		e := firstCompetingEvent(intervals)
+		fineGray = append(fineGray, fmt.Sprintf("%d %t %d %d %s %d", e.SampleID, e.Prevalent, e.Time, e.Event, e.Status, e.StartAgeDays.Int64))
+
+		for _, row := range m.Rows(intervals) {
+			msstate = append(msstate, fmt.Sprintf("%d %d>%d #%d %d-%d %d", row.SampleID, row.From, row.To, row.Trans, row.Tstart, row.Tstop, row.Status))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sample_id prevalent time event status start_age_days
+	expectedFineGray := []string{
+		"1 true 731 1 Disease 21184",
+		"2 false 1096 0 Excluded 21184",
+		"3 false 4383 0 NoDisease 21184",
+		"4 false 366 1 Disease 21184",
+	}
+	if got := strings.Join(fineGray, "\n"); got != strings.Join(expectedFineGray, "\n") {
+		t.Errorf("Expected\n%s\ngot\n%s", strings.Join(expectedFineGray, "\n"), got)
+	}
+
+	// States: 1 NoDisease, 2 Disease1, 3 Disease2, 4 Died. Transitions: 1
+	// (1>2), 2 (1>4), 3 (2>3), 4 (2>4), 5 (3>4).
+	expectedMultiState := []string{
+		// Prevalent: starts in Disease1, then has a recurrence and dies
+		"1 2>3 #3 0-731 1",
+		"1 2>4 #4 0-731 0",
+		"1 3>4 #5 731-3653 1",
+		"2 1>2 #1 0-1096 0",
+		"2 1>4 #2 0-1096 0",
+		"3 1>2 #1 0-4383 0",
+		"3 1>4 #2 0-4383 0",
+		"4 1>2 #1 0-366 1",
+		"4 1>4 #2 0-366 0",
+		"4 2>3 #3 366-1461 1",
+		"4 2>4 #4 366-1461 0",
+		"4 3>4 #5 1461-2922 1",
+	}
+	if got := strings.Join(msstate, "\n"); got != strings.Join(expectedMultiState, "\n") {
+		t.Errorf("Expected\n%s\ngot\n%s", strings.Join(expectedMultiState, "\n"), got)
+	}
+}
+
+func TestAalenJohansen(t *testing.T) {
+	events := []competingEvent{
+		{Time: 30, Event: EventDisease},
+		{Time: 10, Event: EventDisease},
+		{Time: 40, Event: EventCensored},
+		{Time: 10, Event: EventCensored},
+		{Time: 20, Event: EventDeath},
+	}
+
+	// time at_risk event_free cif_disease cif_death
+	expected := [][5]float64{
+		{10, 5, 0.8, 0.2, 0},
+		{20, 3, 0.8 * 2 / 3, 0.2, 0.8 / 3},
+		{30, 2, 0.8 / 3, 0.2 + 0.8/3, 0.8 / 3},
+		{40, 1, 0.8 / 3, 0.2 + 0.8/3, 0.8 / 3},
+	}
+
+	got := aalenJohansen(events)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d times, got %d", len(expected), len(got))
+	}
+	for i, p := range got {
+		observed := [5]float64{float64(p.Time), float64(p.AtRisk), p.EventFree, p.CIFDisease, p.CIFDeath}
+		for j := range observed {
+			if math.Abs(observed[j]-expected[i][j]) > 1e-9 {
+				t.Errorf("Time %d: expected %v, got %v", p.Time, expected[i], observed)
+				break
+			}
+		}
+		if sum := p.EventFree + p.CIFDisease + p.CIFDeath; math.Abs(sum-1) > 1e-9 {
+			t.Errorf("Time %d: probabilities sum to %f", p.Time, sum)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/carbocation/pfx"
+)
+
+// DataSource produces the follow-up intervals of every participant in the
+// censor table for the disease defined by a tabfile. Results must be ordered
+// by sample_id, then incident_number.
+type DataSource interface {
+	Results(tabs *TabFile) (ResultIterator, error)
+}
+
+// ResultIterator yields Results. Next populates dst, which must be a *Result,
+// and returns iterator.Done when there are no more Results. It is satisfied by
+// *bigquery.RowIterator.
+type ResultIterator interface {
+	Next(dst interface{}) error
+}
+
+// Results builds the query for the tabfile and runs it on BigQuery.
+func (BQ *WrappedBigQuery) Results(tabs *TabFile) (ResultIterator, error) {
+	query, err := BuildQuery(BQ, tabs, false)
+	if err != nil {
+		return nil, err
+	}
+
+	itr, err := query.Read(BQ.Context)
+	if err != nil {
+		return nil, pfx.Err(fmt.Sprint(err.Error(), query.Parameters))
+	}
+
+	return itr, nil
+}
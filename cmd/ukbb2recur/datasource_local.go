@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/carbocation/genomisc/ukbb/localtable"
+	"google.golang.org/api/iterator"
+)
+
+// LocalFiles evaluates tabfiles in Go against local copies of the tables that
+// queryTemplate reads from BigQuery. Dir must hold censor,
+// materialized_hesin_dates_all, and materialized_special_dates, each as a
+// .parquet, .tsv, or .csv file (optionally compressed) named after its table.
+type LocalFiles struct {
+	Dir string
+}
+
+// valueMatcher mirrors the `hd.FieldID = X AND hd.value IN (...)` clauses that
+// BuildQuery assembles.
+type valueMatcher map[int64]map[string]struct{}
+
+func newValueMatcher(entries []TabEntry) valueMatcher {
+	out := make(valueMatcher)
+	for _, entry := range entries {
+		fieldID := int64(entry.FieldID)
+		if out[fieldID] == nil {
+			out[fieldID] = make(map[string]struct{})
+		}
+		for _, value := range entry.FormattedValues() {
+			out[fieldID][value] = struct{}{}
+		}
+	}
+
+	return out
+}
+
+func (m valueMatcher) Matches(fieldID int64, value string) bool {
+	_, exists := m[fieldID][value]
+	return exists
+}
+
+// event is one row of the grouped_dated_fields_with_censoring CTE.
+type event struct {
+	Date    bigquery.NullDate
+	Status  StatusEnum
+	DSource string
+}
+
+// minString keeps the lesser of two strings, like MIN(dsource).
+func minString(prior string, seen bool, candidate string) string {
+	if !seen || candidate < prior {
+		return candidate
+	}
+	return prior
+}
+
+func (l *LocalFiles) Results(tabs *TabFile) (ResultIterator, error) {
+	include := newValueMatcher(tabs.AllIncluded())
+	exclude := newValueMatcher(tabs.AllExcluded())
+
+	// grouped_dated_fields_included_only: one event per participant per
+	// distinct first_date (including NULL).
+	included := make(map[int64]map[bigquery.NullDate]string)
+
+	// grouped_dated_fields_excluded_only: one event per participant at the
+	// earliest first_date.
+	excluded := make(map[int64]event)
+
+	record := func(d localtable.DatedField) error {
+		if include.Matches(d.FieldID, d.Value) {
+			if included[d.SampleID] == nil {
+				included[d.SampleID] = make(map[bigquery.NullDate]string)
+			}
+			prior, seen := included[d.SampleID][d.FirstDate]
+			included[d.SampleID][d.FirstDate] = minString(prior, seen, d.DSource)
+		}
+
+		if exclude.Matches(d.FieldID, d.Value) {
+			prior, seen := excluded[d.SampleID]
+			if !seen {
+				prior = event{Status: Excluded, DSource: d.DSource}
+			}
+			prior.DSource = minString(prior.DSource, seen, d.DSource)
+			if d.FirstDate.Valid && (!prior.Date.Valid || d.FirstDate.Date.Before(prior.Date.Date)) {
+				prior.Date = d.FirstDate
+			}
+			excluded[d.SampleID] = prior
+		}
+
+		return nil
+	}
+
+	keep := func(fieldID int64, value string) bool {
+		return include.Matches(fieldID, value) || exclude.Matches(fieldID, value)
+	}
+
+	for _, table := range []string{"materialized_hesin_dates_all", "materialized_special_dates"} {
+		path, err := localtable.Find(l.Dir, table)
+		if err != nil {
+			return nil, err
+		}
+
+		fn := record
+		if table == "materialized_special_dates" {
+			fn = func(d localtable.DatedField) error {
+				d.DSource = "Z_SPECIAL"
+				return record(d)
+			}
+		}
+
+		if err := localtable.ReadDatedFields(path, keep, fn); err != nil {
+			return nil, err
+		}
+	}
+
+	censorPath, err := localtable.Find(l.Dir, "censor")
+	if err != nil {
+		return nil, err
+	}
+	censor, err := localtable.ReadCensor(censorPath)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(censor, func(i, j int) bool { return censor[i].SampleID < censor[j].SampleID })
+
+	var results []Result
+	for _, c := range censor {
+		var excl *event
+		if e, exists := excluded[c.SampleID]; exists {
+			excl = &e
+		}
+		results = append(results, participantIntervals(c, included[c.SampleID], excl)...)
+	}
+
+	return &resultSlice{results: results}, nil
+}
+
+// participantIntervals produces the rows of the final SELECT for one
+// participant.
+func participantIntervals(c localtable.Censor, included map[bigquery.NullDate]string, excl *event) []Result {
+	// censoring_terminations
+	censoring := event{Date: c.PhenotypeCensorDate, Status: NoDisease, DSource: "censor"}
+	if c.DeathDate.Valid {
+		censoring.Date, censoring.Status = c.DeathDate, Died
+	} else if c.LostToFollowupDate.Valid {
+		censoring.Date, censoring.Status = c.LostToFollowupDate, LostToFollowUp
+	}
+
+	// censoring_excluding_terminations
+	if excl != nil && isBefore(excl.Date, censoring.Date) {
+		censoring.Date, censoring.Status = excl.Date, excl.Status
+	}
+
+	// included_prior_to_censoring_or_exclusions
+	events := []event{censoring}
+	for date, dsource := range included {
+		if isBefore(date, censoring.Date) {
+			events = append(events, event{Date: date, Status: Disease, DSource: dsource})
+		}
+	}
+
+	// full_res: ORDER BY event_date ASC, status_end ASC (NULLs first)
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i].Date, events[j].Date
+		if a.Valid != b.Valid {
+			return !a.Valid
+		}
+		if a.Valid && a.Date != b.Date {
+			return a.Date.Before(b.Date)
+		}
+		return events[i].Status < events[j].Status
+	})
+
+	firstEventDate := events[0].Date
+
+	var out []Result
+	for i, q := range events {
+		// Only keep dates that are incident from the perspective of enrollment
+		if !isBefore(c.EnrollDate, q.Date) {
+			continue
+		}
+
+		r := Result{
+			SampleID:                c.SampleID,
+			IncidentNumber:          nullInt(int64(i)),
+			StatusStart:             NoDisease,
+			StatusEnd:               q.Status,
+			EndDate:                 q.Date,
+			BirthDate:               c.BirthDate,
+			EndAgeDays:              dateDiff(q.Date, c.BirthDate),
+			EnrollDate:              c.EnrollDate,
+			DaysSinceEnrollDate:     dateDiff(q.Date, c.EnrollDate),
+			IsFinalRecord:           bigquery.NullBool{Bool: i == len(events)-1, Valid: true},
+			FirstEventDate:          firstEventDate,
+			FirstEventAgeDays:       dateDiff(firstEventDate, c.BirthDate),
+			DaysSinceFirstEventDate: dateDiff(q.Date, firstEventDate),
+		}
+
+		var startDate bigquery.NullDate
+		if i > 0 {
+			comparator := events[i-1]
+			startDate = comparator.Date
+			r.StatusStart = comparator.Status
+			r.StartDataSource = bigquery.NullString{StringVal: comparator.DSource, Valid: true}
+		}
+
+		// When (i) a prevalent event occurs, then (ii) the person enrolls,
+		// then (iii) an incident event occurs, the time for the incident
+		// event is counted from enrollment rather than from the prevalent
+		// event.
+		switch {
+		case !startDate.Valid:
+			r.StartDate = c.EnrollDate
+			r.SurvivalDays = r.DaysSinceEnrollDate
+		case isBefore(startDate, c.EnrollDate) && isBefore(c.EnrollDate, q.Date):
+			r.StartDate = c.EnrollDate
+			r.SurvivalDays = dateDiff(c.EnrollDate, startDate)
+		default:
+			r.StartDate = startDate
+			r.SurvivalDays = dateDiff(q.Date, startDate)
+		}
+
+		if startDate.Valid {
+			r.StartAgeDays = dateDiff(startDate, c.BirthDate)
+		} else {
+			r.StartAgeDays = dateDiff(c.EnrollDate, c.BirthDate)
+		}
+
+		out = append(out, r)
+	}
+
+	return out
+}
+
+type resultSlice struct {
+	results []Result
+	next    int
+}
+
+func (s *resultSlice) Next(dst interface{}) error {
+	if s.next >= len(s.results) {
+		return iterator.Done
+	}
+
+	r, ok := dst.(*Result)
+	if !ok {
+		return fmt.Errorf("Expected a *Result, got %T", dst)
+	}
+	*r = s.results[s.next]
+	s.next++
+
+	return nil
+}
+
+// isBefore is `a < b`, which is false if either date is NULL.
+func isBefore(a, b bigquery.NullDate) bool {
+	return a.Valid && b.Valid && a.Date.Before(b.Date)
+}
+
+// dateDiff is DATE_DIFF(a, b, DAY), which is NULL if either date is NULL.
+func dateDiff(a, b bigquery.NullDate) bigquery.NullInt64 {
+	if !a.Valid || !b.Valid {
+		return bigquery.NullInt64{}
+	}
+
+	return nullInt(int64(a.Date.DaysSince(b.Date)))
+}
+
+func nullInt(v int64) bigquery.NullInt64 {
+	return bigquery.NullInt64{Int64: v, Valid: true}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/iterator"
+)
+
+func TestLocalFilesResults(t *testing.T) {
+	dir := t.TempDir()
+	tables := map[string]string{
+		"censor": "sample_id\tbirthdate\tenroll_date\tdeath_date\tlost_to_followup_date\tphenotype_censor_date\n" +
+			"3\t1950-01-01\t2008-01-01\t\t\t2020-01-01\n" +
+			"1\t1950-01-01\t2008-01-01\t2018-01-01\t\t2020-01-01\n" +
+			"2\t1950-01-01\t2008-01-01\t\t\t2020-01-01\n",
+		"materialized_hesin_dates_all": "sample_id\tFieldID\tvalue\tdsource\tsource\tfirst_date\n" +
+			"1\t41202\tI21\tHESIN\tprimary\t2005-01-01\n" +
+			"1\t41202\tI21\tHESIN\tprimary\t2010-01-01\n" +
+			"1\t41204\tI21\tHESIN\tsecondary\t2012-01-01\n" +
+			"1\t41202\tI21\tHESIN\tprimary\t2019-01-01\n" +
+			"2\t41202\tI25\tHESIN\tprimary\t2011-01-01\n" +
+			"2\t41202\tI21\tHESIN\tprimary\t2013-01-01\n",
+		"materialized_special_dates": "sample_id\tFieldID\tvalue\tfirst_date\n" +
+			"1\t42001\t1\t2012-01-01\n",
+	}
+	for name, contents := range tables {
+		if err := os.WriteFile(filepath.Join(dir, name+".tsv"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tabPath := filepath.Join(dir, "mi.tab")
+	tab := "FieldID\tvalues\texclude\n" +
+		"41202\tI21\t0\n" +
+		"41204\tI21\t0\n" +
+		"42001\t1\t0\n" +
+		"41202\tI25\t1\n"
+	if err := os.WriteFile(tabPath, []byte(tab), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tabs, err := ParseTabFile(tabPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	itr, err := (&LocalFiles{Dir: dir}).Results(tabs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sample_id incident_number status_start status_end start_date end_date days_since_start_date is_final_record
+	expected := []string{
+		// The prevalent 2005 event is not emitted. As in the BigQuery
+		// query, the first incident interval starts at enrollment, but its
+		// days_since_start_date is counted from 2005 to enrollment.
+		"1 1 Disease Disease 2008-01-01 2010-01-01 1095 false",
+		"1 2 Disease Disease 2010-01-01 2012-01-01 730 false",
+		"1 3 Disease Died 2012-01-01 2018-01-01 2192 true",
+		"2 0 NoDisease Excluded 2008-01-01 2011-01-01 1096 true",
+		"3 0 NoDisease NoDisease 2008-01-01 2020-01-01 4383 true",
+	}
+
+	for i := 0; ; i++ {
+		var r Result
+		err := itr.Next(&r)
+		if err == iterator.Done {
+			if i != len(expected) {
+				t.Fatalf("Expected %d rows, got %d", len(expected), i)
+			}
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		got := fmt.Sprintf("%d %s %s %s %s %s %s %s", r.SampleID, r.IncidentNumber, r.StatusStart, r.StatusEnd, r.StartDate, r.EndDate, r.SurvivalDays, r.IsFinalRecord)
+		if i >= len(expected) {
+			t.Fatalf("Unexpected row %d: %q", i, got)
+		}
+		if got != expected[i] {
+			t.Errorf("Row %d: expected %q, got %q", i, expected[i], got)
+		}
+	}
+}
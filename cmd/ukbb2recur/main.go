@@ -12,6 +12,7 @@ import (
 
 	"cloud.google.com/go/bigquery"
 	_ "github.com/carbocation/genomisc/compileinfoprint"
+	"github.com/carbocation/genomisc/ukbb"
 )
 
 type WrappedBigQuery struct {
@@ -35,7 +36,7 @@ var (
 func init() {
 	flag.Usage = func() {
 		flag.PrintDefaults()
-		describeDateFields(false)
+		ukbb.DescribeDateFields(false)
 	}
 }
 
@@ -54,6 +55,11 @@ func main() {
 	var timeVaryingDays int
 	var timeVaryingDaysAfterEvent int
 	var timeVaryingDaysAfterEventDecayMultiplier float64
+	var localDir string
+	var codingPath string
+	var output string
+	var maxEvents int
+	var groupsPath, groupColumn string
 
 	flag.StringVar(&BQ.Project, "project", "", "Google Cloud project you want to use for billing purposes only")
 	flag.StringVar(&BQ.Database, "database", "", "BigQuery source database name (note: must be formatted as project.database, e.g., ukbb-analyses.ukbb7089_201904)")
@@ -67,11 +73,29 @@ func main() {
 	flag.Float64Var(&timeVaryingDaysAfterEventDecayMultiplier, "time-varying-days-after-event-multiplier", 2.0, "If >= 1.0, after each time-varying-days-after-event days, the time-varying-days-after-event value will be multiplied by time-varying-days-after-event-multiplier. Allows less frequent checks over time after an event.")
 	flag.StringVar(&diseaseName, "disease", "", "If not specified, the tabfile will be parsed and become the disease name.")
 	flag.BoolVar(&BQ.UseGP, "usegp", false, "")
+	flag.StringVar(&localDir, "local", "", "(Optional) Directory holding local copies of the censor, materialized_hesin_dates_all, and materialized_special_dates tables, as .parquet, .tsv, or .csv files named after the table. If set, the tabfile is evaluated locally instead of with BigQuery, and -project and -database are not needed.")
+	flag.StringVar(&codingPath, "coding", "", "(Optional) URL or path to comma-delimited file with the UKBB data encodings (Codings.csv, e.g., https://biobank.ctsu.ox.ac.uk/~bbdatan/Codings.csv). Required if the tabfile uses wildcards (I21*), ranges (I20-I25), or blocks (Block I20-I25) of ICD-10, ICD-9, or OPCS-4 codes, which are expanded with the coding trees.")
+	flag.StringVar(&output, "output", OutputIntervals, "Output mode. 'intervals': one row per follow-up interval. 'finegray': one row per participant with the time to the first incident event and an event code (0 censored, 1 disease, 2 death as a competing event). 'msstate': long-format transition table (from, to, trans, Tstart, Tstop, time, status) for a multi-state model. 'cif': Aalen-Johansen cumulative incidence of disease and of death, by group.")
+	flag.IntVar(&maxEvents, "msstate-max-events", 1, "With -output msstate, the number of disease states (Disease1, Disease2, ...). The default of 1 is the illness-death model; later disease events do not change the state.")
+	flag.StringVar(&groupsPath, "groups", "", "(Optional) With -output cif, a tab-delimited file with a header, a sample_id column, and a column of groups within which to estimate the cumulative incidence. Participants without a group are left out.")
+	flag.StringVar(&groupColumn, "group-column", "group", "With -groups, the name of the column that holds the groups.")
 	flag.Parse()
 
 	flag.Usage = func() {
 		flag.PrintDefaults()
-		describeDateFields(verbose)
+		ukbb.DescribeDateFields(verbose)
+	}
+
+	if !ValidOutput(output) {
+		fmt.Fprintf(os.Stderr, "Unknown --output %q\n", output)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if output != OutputIntervals && timeVaryingDays > 0 {
+		fmt.Fprintln(os.Stderr, "--time-varying-days is only available with --output intervals")
+		flag.Usage()
+		os.Exit(1)
 	}
 
 	if timeVaryingDaysAfterEvent <= 0 {
@@ -82,18 +106,24 @@ func main() {
 		timeVaryingDaysAfterEventDecayMultiplier = 1.0
 	}
 
-	if BQ.Project == "" {
+	if BQ.Project == "" && localDir == "" {
 		fmt.Fprintln(os.Stderr, "Please provide --project")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if BQ.Database == "" {
+	if BQ.Database == "" && localDir == "" {
 		fmt.Fprintln(os.Stderr, "Please provide --database")
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	if localDir != "" && displayQuery {
+		fmt.Fprintln(os.Stderr, "--display-query is not available with --local")
+		flag.Usage()
+		os.Exit(1)
+	}
+
 	if tabfile == "" {
 		fmt.Fprintln(os.Stderr, "Please provide --tabfile")
 		flag.Usage()
@@ -105,6 +135,18 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	var codingTrees map[string]*ukbb.CodingTree
+	if codingPath != "" {
+		codingTrees, err = ukbb.ReadCodingTrees(codingPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if err := tabs.ExpandCodes(codingTrees); err != nil {
+		log.Fatalln(err)
+	}
+
 	if diseaseName == "" {
 		diseaseName = path.Base(tabfile)
 		if parts := strings.Split(diseaseName, "."); len(parts) > 1 {
@@ -154,22 +196,41 @@ func main() {
 		fmt.Fprintf(os.Stderr, "\t(No exclusion criteria)\n")
 	}
 
-	BQ.Client, err = bigquery.NewClient(BQ.Context, BQ.Project)
-	if err != nil {
-		log.Fatalln("Connecting to BigQuery:", err)
-	}
-	defer BQ.Client.Close()
+	var src DataSource = BQ
+	if localDir != "" {
+		src = &LocalFiles{Dir: localDir}
+	} else {
+		BQ.Client, err = bigquery.NewClient(BQ.Context, BQ.Project)
+		if err != nil {
+			log.Fatalln("Connecting to BigQuery:", err)
+		}
+		defer BQ.Client.Close()
 
-	query, err := BuildQuery(BQ, tabs, displayQuery)
-	if err != nil {
-		log.Fatalln(diseaseName, err)
+		if displayQuery {
+			if _, err := BuildQuery(BQ, tabs, displayQuery); err != nil {
+				log.Fatalln(diseaseName, err)
+			}
+			return
+		}
 	}
 
-	if displayQuery {
-		return
+	switch output {
+	case OutputFineGray:
+		err = ExecuteFineGray(src, tabs, diseaseName)
+	case OutputMultiState:
+		err = ExecuteMultiState(src, tabs, diseaseName, maxEvents)
+	case OutputCumulativeIncidence:
+		var groups map[int64]string
+		if groupsPath != "" {
+			if groups, err = ReadGroups(groupsPath, groupColumn); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		err = ExecuteCumulativeIncidence(src, tabs, diseaseName, groups)
+	default:
+		err = ExecuteQuery(src, tabs, diseaseName, missingFields, timeVaryingDays, timeVaryingDaysAfterEvent, timeVaryingDaysAfterEventDecayMultiplier)
 	}
-
-	if err := ExecuteQuery(BQ, query, diseaseName, missingFields, timeVaryingDays, timeVaryingDaysAfterEvent, timeVaryingDaysAfterEventDecayMultiplier); err != nil {
+	if err != nil {
 		log.Fatalln(diseaseName, err)
 	}
 
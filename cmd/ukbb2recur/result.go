@@ -32,12 +32,12 @@ type Result struct {
 	StartDataSource             bigquery.NullString `bigquery:"start_dsource"` // Currently not used - problematic because missing before diagnoses are issued
 }
 
-func ExecuteQuery(BQ *WrappedBigQuery, query *bigquery.Query, diseaseName string, missingFields []string, timeVaryingDays, timeVaryingDaysAfterEvent int, timeVaryingDaysAfterEventDecayMultiplier float64) error {
+func ExecuteQuery(src DataSource, tabs *TabFile, diseaseName string, missingFields []string, timeVaryingDays, timeVaryingDaysAfterEvent int, timeVaryingDaysAfterEventDecayMultiplier float64) error {
 	defer STDOUT.Flush()
 
-	itr, err := query.Read(BQ.Context)
+	itr, err := src.Results(tabs)
 	if err != nil {
-		return pfx.Err(fmt.Sprint(err.Error(), query.Parameters))
+		return err
 	}
 	todayDate := time.Now().Format("2006-01-02")
 	missing := strings.Join(missingFields, ",")
@@ -9,6 +9,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/carbocation/genomisc/ukbb"
 )
 
 type TabEntry struct {
@@ -24,7 +26,7 @@ func (t TabEntry) FormattedValues() []string {
 	out := make([]string, 0, len(t.Values))
 
 	// HESIN is special-cased to exclude "." (So K41.2 becomes K412)
-	if IsHesin(t.FieldID) {
+	if ukbb.IsHesin(t.FieldID) {
 		for _, v := range t.Values {
 			out = append(out, strings.Replace(v, ".", "", -1))
 		}
@@ -130,50 +132,50 @@ func (t *TabFile) CheckSensibility() ([]string, error) {
 		opcs := make(map[int]struct{})
 
 		for _, v := range batch {
-			// Capitalized ICD9 (etc) here refer to the manual global maps that
-			// we create in special_fields.go which list all possible FieldIDs
+			// ukbb.ICD9 (etc) here refer to the manual global maps that
+			// we create in ukbb/specialfields.go which list all possible FieldIDs
 			// for ICD9 codes. Lower case icd9 represents the local map that
 			// will track which of those FieldIDs actually appeared in the
 			// tabfile. Basically, this is a lookup to see whether the field we
 			// are currently looking at is an ICD9 field, etc.
-			if _, exists := ICD9[v.FieldID]; exists {
+			if _, exists := ukbb.ICD9[v.FieldID]; exists {
 				icd9[v.FieldID] = struct{}{}
 			}
-			if _, exists := ICD10[v.FieldID]; exists {
+			if _, exists := ukbb.ICD10[v.FieldID]; exists {
 				icd10[v.FieldID] = struct{}{}
 			}
-			if _, exists := OPCS[v.FieldID]; exists {
+			if _, exists := ukbb.OPCS[v.FieldID]; exists {
 				opcs[v.FieldID] = struct{}{}
 			}
 		}
 
 		// So, you included at least one ICD9 field, but you failed to include
 		// all of them. Probably an error.
-		if len(icd9) > 0 && len(icd9) < len(ICD9) {
-			for known := range ICD9 {
+		if len(icd9) > 0 && len(icd9) < len(ukbb.ICD9) {
+			for known := range ukbb.ICD9 {
 				if _, exists := icd9[known]; !exists {
 					missingFields[known] = struct{}{}
 				}
 			}
-			errs = append(errs, fmt.Sprintf("In the %s subset, you included ICD9 fields %v. Consider including all IC9 fields: %v", batchnames[batchID], icd9, ICD9))
+			errs = append(errs, fmt.Sprintf("In the %s subset, you included ICD9 fields %v. Consider including all IC9 fields: %v", batchnames[batchID], icd9, ukbb.ICD9))
 		}
 
-		if len(icd10) > 0 && len(icd10) < len(ICD10) {
-			for known := range ICD10 {
+		if len(icd10) > 0 && len(icd10) < len(ukbb.ICD10) {
+			for known := range ukbb.ICD10 {
 				if _, exists := icd10[known]; !exists {
 					missingFields[known] = struct{}{}
 				}
 			}
-			errs = append(errs, fmt.Sprintf("In the %s subset, you included ICD10 fields %v. Consider including all IC10 fields: %v", batchnames[batchID], icd10, ICD10))
+			errs = append(errs, fmt.Sprintf("In the %s subset, you included ICD10 fields %v. Consider including all IC10 fields: %v", batchnames[batchID], icd10, ukbb.ICD10))
 		}
 
-		if len(opcs) > 0 && len(opcs) < len(OPCS) {
-			for known := range OPCS {
+		if len(opcs) > 0 && len(opcs) < len(ukbb.OPCS) {
+			for known := range ukbb.OPCS {
 				if _, exists := opcs[known]; !exists {
 					missingFields[known] = struct{}{}
 				}
 			}
-			errs = append(errs, fmt.Sprintf("In the %s subset, you included OPCS fields %v. Consider including all OPCS fields: %v", batchnames[batchID], opcs, OPCS))
+			errs = append(errs, fmt.Sprintf("In the %s subset, you included OPCS fields %v. Consider including all OPCS fields: %v", batchnames[batchID], opcs, ukbb.OPCS))
 		}
 	}
 
@@ -337,17 +339,17 @@ func ParseTabFile(tabPath string) (*TabFile, error) {
 		// Assign to the right field type
 		switch entry.Exclude {
 		case true:
-			if IsHesin(entry.FieldID) {
+			if ukbb.IsHesin(entry.FieldID) {
 				output.Exclude.Hesin = append(output.Exclude.Hesin, entry)
-			} else if IsSpecial(entry.FieldID) {
+			} else if ukbb.IsSpecial(entry.FieldID) {
 				output.Exclude.Special = append(output.Exclude.Special, entry)
 			} else {
 				output.Exclude.Standard = append(output.Exclude.Standard, entry)
 			}
 		default:
-			if IsHesin(entry.FieldID) {
+			if ukbb.IsHesin(entry.FieldID) {
 				output.Include.Hesin = append(output.Include.Hesin, entry)
-			} else if IsSpecial(entry.FieldID) {
+			} else if ukbb.IsSpecial(entry.FieldID) {
 				output.Include.Special = append(output.Include.Special, entry)
 			} else {
 				output.Include.Standard = append(output.Include.Standard, entry)
@@ -361,3 +363,27 @@ func ParseTabFile(tabPath string) (*TabFile, error) {
 
 	return output, nil
 }
+
+// ExpandCodes replaces the wildcards (I21*), ranges (I20-I25), and blocks
+// (Block I20-I25) in the values of ICD-10, ICD-9, and OPCS-4 fields with every
+// code that they match in the UK Biobank coding trees, as in ukbb.ExpandCodes.
+// Entries without such patterns are left as-is. The trees are only needed if
+// the tabfile uses such patterns.
+func (t *TabFile) ExpandCodes(trees map[string]*ukbb.CodingTree) error {
+	lists := []*[]TabEntry{
+		&t.Include.Hesin, &t.Include.Special, &t.Include.Standard,
+		&t.Exclude.Hesin, &t.Exclude.Special, &t.Exclude.Standard,
+	}
+
+	for _, list := range lists {
+		for i, entry := range *list {
+			values, err := ukbb.ExpandCodes(entry.FieldID, entry.Values, trees)
+			if err != nil {
+				return err
+			}
+			(*list)[i].Values = values
+		}
+	}
+
+	return nil
+}
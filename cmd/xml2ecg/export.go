@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/carbocation/genomisc/ecg"
+)
+
+// processExport writes the rhythm strip and the median beats in standard ECG
+// formats, to files prefixed with _strip and _median.
+func processExport(filename, path string, formats []string) error {
+	doc, err := readEKG12Lead(path)
+	if err != nil {
+		return err
+	}
+
+	strip, medians, err := ecg.FromEKG12Lead(doc)
+	if err != nil {
+		return err
+	}
+
+	sampleID, _, err := fileNameToSampleInstance(filename)
+	if err != nil {
+		return err
+	}
+
+	prefix := strings.TrimSuffix(filename, ".xml")
+
+	if err := ecg.WriteFiles(prefix+"_strip", formats, sampleID, strip); err != nil {
+		return err
+	}
+
+	return ecg.WriteFiles(prefix+"_median", formats, sampleID, medians)
+}
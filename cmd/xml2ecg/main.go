@@ -44,12 +44,16 @@ func main() {
 	var filename string
 	var createPNG bool
 	var printDiagnoses bool
+	var measure bool
+	var export string
 	var debug bool
 	var widthPx, heightPx int
 
 	flag.StringVar(&filename, "file", "", "XML file (CardioSoft 6.73 output)")
 	flag.BoolVar(&createPNG, "createpng", false, "Create PNG representations of the EKG strips?")
 	flag.BoolVar(&printDiagnoses, "diagnoses", false, "Emit the automated diagnoses to a _diagnoses.csv file?")
+	flag.BoolVar(&measure, "measure", false, "Measure heart rate, HRV, intervals, and axes from the waveforms and emit them, with the machine's values, to a _measurements.tsv file?")
+	flag.StringVar(&export, "export", "", "(Optional) Comma-separated formats into which the rhythm strip and median beats will be exported: wfdb (.hea/.dat), aecg (HL7 aECG _aecg.xml), and/or edf (EDF+ .edf).")
 	flag.IntVar(&widthPx, "width", 256, "(Optional) If creating PNGs, what pixel width?")
 	flag.IntVar(&heightPx, "height", 256, "(Optional) If creating PNGs, what pixel height?")
 	flag.BoolVar(&debug, "debug", false, "Print extra metadata during processing?")
@@ -62,12 +66,12 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := run(filename, createPNG, printDiagnoses, widthPx, heightPx, debug); err != nil {
+	if err := run(filename, createPNG, printDiagnoses, measure, export, widthPx, heightPx, debug); err != nil {
 		log.Fatalln(err)
 	}
 }
 
-func run(filename string, createPNG, printDiagnoses bool, widthPx, heightPx int, debug bool) error {
+func run(filename string, createPNG, printDiagnoses, measure bool, export string, widthPx, heightPx int, debug bool) error {
 	f, err := os.Open(filename)
 	if err != nil {
 		return err
@@ -89,6 +93,18 @@ func run(filename string, createPNG, printDiagnoses bool, widthPx, heightPx int,
 		}
 	}
 
+	if measure {
+		if err := processMeasurements(filepath.Base(filename), filename); err != nil {
+			return err
+		}
+	}
+
+	if export != "" {
+		if err := processExport(filepath.Base(filename), filename, strings.Split(export, ",")); err != nil {
+			return err
+		}
+	}
+
 	if err := processFullDisclosureStrip(filepath.Base(filename), doc, createPNG, widthPx, heightPx, debug); err != nil {
 		return err
 	}
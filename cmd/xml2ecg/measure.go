@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/carbocation/genomisc/ecg"
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+	"golang.org/x/net/html/charset"
+)
+
+// processMeasurements measures the rhythm strip and the machine's median beats
+// with the ecg package and writes them, alongside the machine's own
+// measurements, to a _measurements.tsv file in long format.
+func processMeasurements(filename, path string) error {
+	doc, err := readEKG12Lead(path)
+	if err != nil {
+		return err
+	}
+
+	sampleID, instance, err := fileNameToSampleInstance(filename)
+	if err != nil {
+		return err
+	}
+
+	strip, medians, err := ecg.FromEKG12Lead(doc)
+	if err != nil {
+		return err
+	}
+
+	machine := ecg.MachineMeasurements(doc)
+
+	stripMeasurements, err := ecg.Analyze(strip, ecg.DefaultOptions())
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	// The machine's median beats carry no rhythm information, so QTc uses the
+	// RR interval from the strip.
+	medianMeasurements := ecg.Measurements{
+		HRV:       ecg.HRV{HeartRate: math.NaN(), MeanRR: math.NaN(), SDNN: math.NaN(), RMSSD: math.NaN()},
+		Intervals: ecg.AnalyzeMedian(medians, stripMeasurements.MeanRR),
+	}
+
+	outFile, err := os.Create(strings.TrimSuffix(filename, ".xml") + "_measurements.tsv")
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	var OUTFILE = bufio.NewWriter(outFile)
+	defer OUTFILE.Flush()
+
+	fmt.Fprintln(OUTFILE, strings.Join([]string{"sample_id", "instance", "source", "measure", "value", "machine", "difference"}, "\t"))
+
+	for _, source := range []struct {
+		name         string
+		measurements ecg.Measurements
+		skip         map[string]bool
+	}{
+		{"strip", stripMeasurements, nil},
+		{"median", medianMeasurements, map[string]bool{
+			"heart_rate": true, "rr_interval": true, "sdnn": true, "rmssd": true,
+			"beats": true, "ectopic_beats": true, "median_beats": true,
+		}},
+	} {
+		for i, value := range source.measurements.Values() {
+			name := ecg.MeasurementNames[i]
+			if source.skip[name] {
+				continue
+			}
+
+			reference, exists := machine[name]
+			if !exists {
+				reference = math.NaN()
+			}
+
+			fmt.Fprintln(OUTFILE, strings.Join([]string{
+				sampleID,
+				instance,
+				source.name,
+				name,
+				ecg.FormatValue(value),
+				ecg.FormatValue(reference),
+				ecg.FormatValue(value - reference),
+			}, "\t"))
+		}
+	}
+
+	return nil
+}
+
+// readEKG12Lead decodes the file with the shared bulkprocess types, which the
+// ecg package understands.
+func readEKG12Lead(path string) (*bulkprocess.EKG12Lead, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	doc := &bulkprocess.EKG12Lead{}
+	decoder := xml.NewDecoder(f)
+	decoder.CharsetReader = charset.NewReaderLabel
+	if err := decoder.Decode(doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,166 @@
+package ecg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HL7 aECG (annotated ECG) elements. Only the parts needed to carry the
+// waveforms are represented.
+type aecgDocument struct {
+	XMLName       xml.Name      `xml:"urn:hl7-org:v3 AnnotatedECG"`
+	XMLNSXSI      string        `xml:"xmlns:xsi,attr"`
+	ID            aecgID        `xml:"id"`
+	Code          aecgCode      `xml:"code"`
+	EffectiveTime aecgInterval  `xml:"effectiveTime"`
+	Component     aecgComponent `xml:"component"`
+}
+
+type aecgID struct {
+	Root string `xml:"root,attr"`
+}
+
+type aecgCode struct {
+	Code           string `xml:"code,attr"`
+	CodeSystem     string `xml:"codeSystem,attr,omitempty"`
+	CodeSystemName string `xml:"codeSystemName,attr,omitempty"`
+}
+
+type aecgTime struct {
+	Value string `xml:"value,attr"`
+}
+
+type aecgInterval struct {
+	Low  aecgTime `xml:"low"`
+	High aecgTime `xml:"high"`
+}
+
+type aecgComponent struct {
+	Series aecgSeries `xml:"series"`
+}
+
+type aecgSeries struct {
+	Code          aecgCode            `xml:"code"`
+	EffectiveTime aecgInterval        `xml:"effectiveTime"`
+	Component     aecgSequenceSetComp `xml:"component"`
+}
+
+type aecgSequenceSetComp struct {
+	SequenceSet aecgSequenceSet `xml:"sequenceSet"`
+}
+
+type aecgSequenceSet struct {
+	Component []aecgSequenceComp `xml:"component"`
+}
+
+type aecgSequenceComp struct {
+	Sequence aecgSequence `xml:"sequence"`
+}
+
+type aecgSequence struct {
+	Code  aecgCode  `xml:"code"`
+	Value aecgValue `xml:"value"`
+}
+
+type aecgQuantity struct {
+	Value string `xml:"value,attr"`
+	Unit  string `xml:"unit,attr"`
+}
+
+// aecgValue is either a GLIST_TS (the time axis) or an SLIST_PQ (a lead).
+type aecgValue struct {
+	Type      string        `xml:"xsi:type,attr"`
+	Head      *aecgTime     `xml:"head,omitempty"`
+	Increment *aecgQuantity `xml:"increment,omitempty"`
+	Origin    *aecgQuantity `xml:"origin,omitempty"`
+	Scale     *aecgQuantity `xml:"scale,omitempty"`
+	Digits    string        `xml:"digits,omitempty"`
+}
+
+const (
+	aecgTimeFormat = "20060102150405.000"
+	hl7CodeSystem  = "2.16.840.1.113883.5.4"
+	mdcCodeSystem  = "2.16.840.1.113883.6.24"
+)
+
+// aecgLeadCode maps a lead name to its MDC code, e.g., aVR to MDC_ECG_LEAD_AVR.
+func aecgLeadCode(name string) string {
+	return "MDC_ECG_LEAD_" + strings.ToUpper(strings.ReplaceAll(name, " ", "_"))
+}
+
+// WriteAECG writes a recording as an HL7 aECG XML document with a single
+// rhythm series. The samples of each lead are stored as integers, with the
+// recording's resolution as the scale, in uV.
+func WriteAECG(w io.Writer, documentID string, rec Recording) error {
+	if rec.SampleRate <= 0 {
+		return fmt.Errorf("Sample rate must be positive, got %f", rec.SampleRate)
+	}
+
+	resolution, leads := digitize(rec)
+
+	start := rec.Start
+	if start.IsZero() {
+		start = time.Date(1985, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	end := start.Add(time.Duration(float64(rec.Len()) / rec.SampleRate * float64(time.Second)))
+	interval := aecgInterval{
+		Low:  aecgTime{Value: start.Format(aecgTimeFormat)},
+		High: aecgTime{Value: end.Format(aecgTimeFormat)},
+	}
+
+	set := aecgSequenceSet{}
+	set.Component = append(set.Component, aecgSequenceComp{Sequence: aecgSequence{
+		Code: aecgCode{Code: "TIME_ABSOLUTE", CodeSystem: hl7CodeSystem},
+		Value: aecgValue{
+			Type:      "GLIST_TS",
+			Head:      &aecgTime{Value: start.Format(aecgTimeFormat)},
+			Increment: &aecgQuantity{Value: strconv.FormatFloat(1/rec.SampleRate, 'g', -1, 64), Unit: "s"},
+		},
+	}})
+
+	for i, lead := range rec.Leads {
+		digits := make([]string, len(leads[i]))
+		for j, v := range leads[i] {
+			digits[j] = strconv.Itoa(int(v))
+		}
+
+		set.Component = append(set.Component, aecgSequenceComp{Sequence: aecgSequence{
+			Code: aecgCode{Code: aecgLeadCode(lead.Name), CodeSystem: mdcCodeSystem, CodeSystemName: "MDC"},
+			Value: aecgValue{
+				Type:   "SLIST_PQ",
+				Origin: &aecgQuantity{Value: "0", Unit: "uV"},
+				Scale:  &aecgQuantity{Value: strconv.FormatFloat(1000*resolution, 'g', -1, 64), Unit: "uV"},
+				Digits: strings.Join(digits, " "),
+			},
+		}})
+	}
+
+	doc := aecgDocument{
+		XMLNSXSI:      "http://www.w3.org/2001/XMLSchema-instance",
+		ID:            aecgID{Root: documentID},
+		Code:          aecgCode{Code: "93000", CodeSystem: "2.16.840.1.113883.6.12", CodeSystemName: "CPT-4"},
+		EffectiveTime: interval,
+		Component: aecgComponent{Series: aecgSeries{
+			Code:          aecgCode{Code: "RHYTHM", CodeSystem: hl7CodeSystem},
+			EffectiveTime: interval,
+			Component:     aecgSequenceSetComp{SequenceSet: set},
+		}},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
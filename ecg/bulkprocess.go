@@ -0,0 +1,172 @@
+package ecg
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carbocation/genomisc/ukbb/bulkprocess"
+)
+
+// FromEKG12Lead extracts the 10-second rhythm strip and the machine's median
+// beats from a resting 12-lead ECG.
+func FromEKG12Lead(doc *bulkprocess.EKG12Lead) (strip, medians Recording, err error) {
+	strip, err = waveformRecording(doc.StripData.SampleRate.Text, doc.StripData.Resolution.Text, doc.StripData.Resolution.Units, len(doc.StripData.WaveformData), func(i int) (string, string) {
+		return doc.StripData.WaveformData[i].Lead, doc.StripData.WaveformData[i].Text
+	})
+	if err != nil {
+		return strip, medians, fmt.Errorf("StripData: %v", err)
+	}
+
+	ms := doc.RestingECGMeasurements.MedianSamples
+	medians, err = waveformRecording(ms.SampleRate.Text, ms.Resolution.Text, ms.Resolution.Units, len(ms.WaveformData), func(i int) (string, string) {
+		return ms.WaveformData[i].Lead, ms.WaveformData[i].Text
+	})
+	if err != nil {
+		return strip, medians, fmt.Errorf("MedianSamples: %v", err)
+	}
+
+	start := observationTime(doc.ObservationDateTime.Year, doc.ObservationDateTime.Month, doc.ObservationDateTime.Day, doc.ObservationDateTime.Hour, doc.ObservationDateTime.Minute, doc.ObservationDateTime.Second)
+	strip.Start, medians.Start = start, start
+
+	return strip, medians, nil
+}
+
+// MachineMeasurements returns the measurements that the ECG machine made on a
+// resting 12-lead ECG, keyed as in MeasurementNames. QTc is reported by the
+// machine with Bazett's formula. Measurements that are absent or not numeric
+// are NaN.
+func MachineMeasurements(doc *bulkprocess.EKG12Lead) map[string]float64 {
+	m := doc.RestingECGMeasurements
+
+	parse := func(text string) float64 {
+		v, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return v
+	}
+
+	return map[string]float64{
+		"heart_rate":   parse(m.VentricularRate.Text),
+		"rr_interval":  parse(m.RRInterval.Text),
+		"p_duration":   parse(m.PDuration.Text),
+		"pr_interval":  parse(m.PQInterval.Text),
+		"qrs_duration": parse(m.QRSDuration.Text),
+		"qt_interval":  parse(m.QTInterval.Text),
+		"qtc_bazett":   parse(m.QTCInterval.Text),
+		"p_axis":       parse(m.PAxis.Text),
+		"qrs_axis":     parse(m.RAxis.Text),
+		"t_axis":       parse(m.TAxis.Text),
+	}
+}
+
+// FromEKGExercise extracts the continuous recording from an exercise ECG. The
+// full disclosure data are comma-delimited and arranged in blocks of one
+// second: SampleRate samples of the first channel in LeadOrder, then
+// SampleRate samples of the next channel, and so on, before moving to the next
+// second.
+func FromEKGExercise(doc *bulkprocess.EKGExercise) (Recording, error) {
+	fd := doc.FullDisclosure
+
+	hz, err := strconv.ParseFloat(strings.TrimSpace(fd.SampleRate.Text), 64)
+	if err != nil || hz <= 0 {
+		return Recording{}, fmt.Errorf("Could not parse the full disclosure sample rate %q", fd.SampleRate.Text)
+	}
+	block := int(hz)
+
+	mvPerLSB, err := MVPerLSB(fd.Resolution.Text, fd.Resolution.Units)
+	if err != nil {
+		return Recording{}, err
+	}
+
+	var names []string
+	for _, v := range strings.Split(fd.LeadOrder, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			names = append(names, normalizeLeadName(v))
+		}
+	}
+	if len(names) == 0 {
+		return Recording{}, fmt.Errorf("No leads were listed in the full disclosure LeadOrder")
+	}
+
+	samples, err := ParseWaveform(fd.FullDisclosureData, mvPerLSB)
+	if err != nil {
+		return Recording{}, err
+	}
+
+	out := Recording{
+		SampleRate: hz,
+		Resolution: mvPerLSB,
+		Start:      observationTime(doc.ObservationDateTime.Year, doc.ObservationDateTime.Month, doc.ObservationDateTime.Day, doc.ObservationDateTime.Hour, doc.ObservationDateTime.Minute, doc.ObservationDateTime.Second),
+		Leads:      make([]Lead, len(names)),
+	}
+	seconds := len(samples) / (block * len(names))
+	for i, name := range names {
+		out.Leads[i] = Lead{Name: name, Samples: make([]float64, 0, seconds*block)}
+	}
+	for s := 0; s < seconds; s++ {
+		for i := range names {
+			start := (s*len(names) + i) * block
+			out.Leads[i].Samples = append(out.Leads[i].Samples, samples[start:start+block]...)
+		}
+	}
+
+	return out, nil
+}
+
+// normalizeLeadName converts numeric limb lead names (e.g., "2") to roman
+// numerals.
+func normalizeLeadName(name string) string {
+	switch name {
+	case "1":
+		return "I"
+	case "2":
+		return "II"
+	case "3":
+		return "III"
+	}
+
+	return name
+}
+
+func waveformRecording(sampleRate, resolution, units string, n int, lead func(i int) (name, text string)) (Recording, error) {
+	hz, err := strconv.ParseFloat(strings.TrimSpace(sampleRate), 64)
+	if err != nil || hz <= 0 {
+		return Recording{}, fmt.Errorf("Could not parse the sample rate %q", sampleRate)
+	}
+
+	mvPerLSB, err := MVPerLSB(resolution, units)
+	if err != nil {
+		return Recording{}, err
+	}
+
+	out := Recording{SampleRate: hz, Resolution: mvPerLSB}
+	for i := 0; i < n; i++ {
+		name, text := lead(i)
+		samples, err := ParseWaveform(text, mvPerLSB)
+		if err != nil {
+			return Recording{}, fmt.Errorf("Lead %s: %v", name, err)
+		}
+		out.Leads = append(out.Leads, Lead{Name: normalizeLeadName(name), Samples: samples})
+	}
+
+	return out, nil
+}
+
+// observationTime parses the ObservationDateTime of a CardioSoft XML file,
+// returning the zero time if it is incomplete.
+func observationTime(year, month, day, hour, minute, second string) time.Time {
+	parts := make([]int, 0, 6)
+	for _, v := range []string{year, month, day, hour, minute, second} {
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return time.Time{}
+		}
+		parts = append(parts, i)
+	}
+
+	return time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, time.UTC)
+}
@@ -0,0 +1,338 @@
+package ecg
+
+import (
+	"math"
+	"strings"
+)
+
+// Intervals are the wave boundaries and derived intervals of a median beat.
+// Boundaries are in ms from the start of the beat; durations are in ms and
+// axes in degrees. Anything that could not be found is NaN.
+type Intervals struct {
+	POnset    float64
+	POffset   float64
+	QRSOnset  float64
+	QRSOffset float64
+	TEnd      float64
+
+	PDuration     float64
+	PRInterval    float64
+	QRSDuration   float64
+	QTInterval    float64
+	QTcBazett     float64
+	QTcFridericia float64
+
+	PAxis   float64
+	QRSAxis float64
+	TAxis   float64
+}
+
+const (
+	// The QRS boundaries are where the combined slope of all leads stays
+	// below this fraction of its peak for qrsQuietMS.
+	qrsSlopeFraction = 0.08
+	qrsQuietMS       = 10
+
+	// Leads whose T wave is smaller than this (mV) are too flat for the
+	// tangent method.
+	minTAmplitude = 0.1
+
+	// The P wave is not called if its combined amplitude is below this (mV).
+	minPAmplitude = 0.03
+)
+
+// Delineate finds the P, QRS, and T waves of a median beat and derives the
+// intervals and axes. rrMS, the RR interval in ms, is used to bound the search
+// for the end of the T wave and to correct the QT interval.
+//
+// The QRS complex is located from the combined slope of all leads, whose peak
+// near the fiducial point anchors the search for its onset and offset. Each
+// lead's baseline is taken from the PR segment. The end of the T wave is where
+// the tangent at the steepest point of its descending limb crosses the
+// baseline, with the median taken across leads whose T wave is large enough.
+// The P wave boundaries are found the same way, from the combined amplitude of
+// all leads before the QRS.
+func Delineate(beat MedianBeat, rrMS float64) Intervals {
+	out := Intervals{}
+	for _, v := range []*float64{&out.POnset, &out.POffset, &out.QRSOnset, &out.QRSOffset, &out.TEnd,
+		&out.PDuration, &out.PRInterval, &out.QRSDuration, &out.QTInterval, &out.QTcBazett, &out.QTcFridericia,
+		&out.PAxis, &out.QRSAxis, &out.TAxis} {
+		*v = math.NaN()
+	}
+
+	n := beat.Len()
+	if n == 0 || len(beat.Leads) == 0 {
+		return out
+	}
+
+	slope := combinedSlope(beat.Recording)
+
+	// Anchor on the steepest point of the QRS near the fiducial point.
+	anchor := argMax(slope, beat.Fiducial-beat.samples(60), beat.Fiducial+beat.samples(60))
+	if anchor < 0 {
+		return out
+	}
+	threshold := qrsSlopeFraction * slope[anchor]
+	quiet := beat.samples(qrsQuietMS)
+	if quiet < 1 {
+		quiet = 1
+	}
+
+	onset := quietEdge(slope, anchor, -1, beat.samples(150), threshold, quiet)
+	offset := quietEdge(slope, anchor, 1, beat.samples(200), threshold, quiet)
+	if onset < 0 || offset < 0 {
+		return out
+	}
+	out.QRSOnset = beat.ms(float64(onset))
+	out.QRSOffset = beat.ms(float64(offset))
+	out.QRSDuration = out.QRSOffset - out.QRSOnset
+
+	// Baseline of each lead, from the PR segment.
+	baselines := make([]float64, len(beat.Leads))
+	for i, lead := range beat.Leads {
+		lo, hi := clampRange(onset-beat.samples(20), onset-beat.samples(5)+1, len(lead.Samples))
+		if lo >= hi {
+			lo, hi = clampRange(onset-1, onset+1, len(lead.Samples))
+		}
+		baselines[i] = median(lead.Samples[lo:hi])
+	}
+
+	// T wave end.
+	tSearchEnd := n - 1
+	if !math.IsNaN(rrMS) && rrMS > 0 {
+		if limit := onset + beat.samples(0.7*rrMS); limit < tSearchEnd {
+			tSearchEnd = limit
+		}
+	}
+	var tEnds []float64
+	for i, lead := range beat.Leads {
+		if t, ok := tangentTEnd(lead.Samples, baselines[i], offset+beat.samples(60), tSearchEnd, beat.samples(150)); ok {
+			tEnds = append(tEnds, t)
+		}
+	}
+	tEnd := -1.0
+	if len(tEnds) > 0 {
+		tEnd = median(tEnds)
+		out.TEnd = beat.ms(tEnd)
+		out.QTInterval = out.TEnd - out.QRSOnset
+		if !math.IsNaN(rrMS) && rrMS > 0 {
+			out.QTcBazett = out.QTInterval / math.Sqrt(rrMS/1000)
+			out.QTcFridericia = out.QTInterval / math.Cbrt(rrMS/1000)
+		}
+	}
+
+	// P wave, from the combined amplitude before the QRS.
+	pStart, pEnd := clampRange(onset-beat.samples(300), onset-beat.samples(20)+1, n)
+	pOnset, pOffset, pOK := pWaveBoundaries(beat.Recording, baselines, pStart, pEnd)
+	if pOK {
+		out.POnset = beat.ms(pOnset)
+		out.POffset = beat.ms(pOffset)
+		out.PDuration = out.POffset - out.POnset
+		out.PRInterval = out.QRSOnset - out.POnset
+	}
+
+	// Axes, from the net area in leads I and aVF.
+	out.QRSAxis = frontalAxis(beat.Recording, baselines, float64(onset), float64(offset))
+	if tEnd >= 0 {
+		out.TAxis = frontalAxis(beat.Recording, baselines, float64(offset), tEnd)
+	}
+	if pOK {
+		out.PAxis = frontalAxis(beat.Recording, baselines, pOnset, pOffset)
+	}
+
+	return out
+}
+
+// combinedSlope is the root sum of squares across leads of the central
+// difference at each sample.
+func combinedSlope(rec Recording) []float64 {
+	n := rec.Len()
+	out := make([]float64, n)
+	for _, lead := range rec.Leads {
+		x := lead.Samples
+		for i := 1; i < len(x)-1; i++ {
+			d := (x[i+1] - x[i-1]) / 2
+			out[i] += d * d
+		}
+	}
+	for i, v := range out {
+		out[i] = math.Sqrt(v)
+	}
+
+	return out
+}
+
+// quietEdge walks from start in direction dir (-1 or 1) for up to limit
+// samples and returns the first sample of the first run of quiet samples whose
+// value stays below threshold, or -1 if there is none.
+func quietEdge(x []float64, start, dir, limit int, threshold float64, quiet int) int {
+	run := 0
+	for k := 1; k <= limit; k++ {
+		i := start + dir*k
+		if i < 0 || i >= len(x) {
+			return -1
+		}
+		if x[i] < threshold {
+			run++
+			if run >= quiet {
+				return i - dir*(quiet-1)
+			}
+		} else {
+			run = 0
+		}
+	}
+
+	return -1
+}
+
+// tangentTEnd finds the end of the T wave in one lead, searching for its peak
+// in [start, end]. The result is a fractional sample index.
+func tangentTEnd(x []float64, baseline float64, start, end, descent int) (float64, bool) {
+	start, end = clampRange(start, end+1, len(x))
+	if end-start < 3 {
+		return 0, false
+	}
+
+	peak := start
+	for i := start; i < end; i++ {
+		if math.Abs(x[i]-baseline) > math.Abs(x[peak]-baseline) {
+			peak = i
+		}
+	}
+	amplitude := x[peak] - baseline
+	if math.Abs(amplitude) < minTAmplitude {
+		return 0, false
+	}
+
+	// The steepest point of the descending limb, back toward the baseline.
+	sign := math.Copysign(1, amplitude)
+	steepest, steepestSlope := -1, 0.0
+	for i := peak + 1; i < peak+descent && i < len(x)-1; i++ {
+		d := -sign * (x[i+1] - x[i-1]) / 2
+		if d > steepestSlope {
+			steepest, steepestSlope = i, d
+		}
+	}
+	if steepest < 0 {
+		return 0, false
+	}
+
+	return float64(steepest) + sign*(x[steepest]-baseline)/steepestSlope, true
+}
+
+// pWaveBoundaries applies the tangent method to both limbs of the combined,
+// baseline-corrected amplitude in [start, end).
+func pWaveBoundaries(rec Recording, baselines []float64, start, end int) (float64, float64, bool) {
+	if end-start < 5 {
+		return 0, 0, false
+	}
+
+	amplitude := make([]float64, end-start)
+	for k := range amplitude {
+		ss := 0.0
+		for i, lead := range rec.Leads {
+			if start+k < len(lead.Samples) {
+				d := lead.Samples[start+k] - baselines[i]
+				ss += d * d
+			}
+		}
+		amplitude[k] = math.Sqrt(ss)
+	}
+
+	peak := argMax(amplitude, 0, len(amplitude)-1)
+	if peak < 0 || amplitude[peak] < minPAmplitude {
+		return 0, 0, false
+	}
+
+	up, upSlope := -1, 0.0
+	for k := 1; k < peak; k++ {
+		if d := (amplitude[k+1] - amplitude[k-1]) / 2; d > upSlope {
+			up, upSlope = k, d
+		}
+	}
+	down, downSlope := -1, 0.0
+	for k := peak + 1; k < len(amplitude)-1; k++ {
+		if d := (amplitude[k-1] - amplitude[k+1]) / 2; d > downSlope {
+			down, downSlope = k, d
+		}
+	}
+	if up < 0 || down < 0 {
+		return 0, 0, false
+	}
+
+	pOnset := float64(start+up) - amplitude[up]/upSlope
+	pOffset := float64(start+down) + amplitude[down]/downSlope
+	if pOnset < float64(start) || pOffset <= pOnset {
+		return 0, 0, false
+	}
+
+	return pOnset, pOffset, true
+}
+
+// frontalAxis is the direction, in degrees, of the net area of leads I and aVF
+// over [from, to]. If either is missing, it is derived from leads II and III.
+func frontalAxis(rec Recording, baselines []float64, from, to float64) float64 {
+	area := func(name string) (float64, bool) {
+		for i, lead := range rec.Leads {
+			if !strings.EqualFold(lead.Name, name) {
+				continue
+			}
+			lo, hi := clampRange(int(math.Ceil(from)), int(math.Floor(to))+1, len(lead.Samples))
+			sum := 0.0
+			for k := lo; k < hi; k++ {
+				sum += lead.Samples[k] - baselines[i]
+			}
+			return sum, true
+		}
+		return 0, false
+	}
+
+	leadI, okI := area("I")
+	aVF, okF := area("aVF")
+	if !okI || !okF {
+		ii, okII := area("II")
+		iii, okIII := area("III")
+		if !okII || !okIII {
+			return math.NaN()
+		}
+		if !okI {
+			leadI = ii - iii
+		}
+		if !okF {
+			aVF = (ii + iii) / 2
+		}
+	}
+
+	if leadI == 0 && aVF == 0 {
+		return math.NaN()
+	}
+
+	return math.Atan2(aVF, leadI) * 180 / math.Pi
+}
+
+func argMax(x []float64, from, to int) int {
+	from, to = clampRange(from, to+1, len(x))
+	best := -1
+	for i := from; i < to; i++ {
+		if best < 0 || x[i] > x[best] {
+			best = i
+		}
+	}
+
+	return best
+}
+
+// clampRange restricts [lo, hi) to [0, n).
+func clampRange(lo, hi, n int) (int, int) {
+	if lo < 0 {
+		lo = 0
+	}
+	if hi > n {
+		hi = n
+	}
+	if hi < lo {
+		hi = lo
+	}
+
+	return lo, hi
+}
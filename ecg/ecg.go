@@ -0,0 +1,148 @@
+// Package ecg derives measurements from electrocardiograms: R-peak detection
+// (Pan-Tompkins), heart rate and heart rate variability, median beats, and the
+// PR, QRS, and QT intervals and frontal plane axes. Resting and exercise ECGs
+// from the UK Biobank (see bulkprocess.EKG12Lead and bulkprocess.EKGExercise)
+// are converted to a Recording, so both go through the same pipeline.
+package ecg
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lead is one channel of an ECG, in mV.
+type Lead struct {
+	Name    string
+	Samples []float64
+}
+
+// Recording is a multi-lead ECG, with all leads sampled simultaneously at
+// SampleRate Hz.
+type Recording struct {
+	SampleRate float64
+	Leads      []Lead
+
+	// Resolution is the size, in mV, of one unit of the analog-to-digital
+	// converter that recorded the signal. It is used when writing the
+	// recording to digital formats, and is 0 if unknown.
+	Resolution float64
+
+	// Start is when the recording began, if known.
+	Start time.Time
+}
+
+// Lead returns the lead with the given name (e.g., "II" or "V5"). The augmented
+// limb leads are matched case-insensitively, since vendors differ on "aVF" vs
+// "AVF".
+func (r Recording) Lead(name string) (Lead, bool) {
+	for _, v := range r.Leads {
+		if strings.EqualFold(v.Name, name) {
+			return v, true
+		}
+	}
+
+	return Lead{}, false
+}
+
+// Len is the number of samples in the longest lead.
+func (r Recording) Len() int {
+	n := 0
+	for _, v := range r.Leads {
+		if len(v.Samples) > n {
+			n = len(v.Samples)
+		}
+	}
+
+	return n
+}
+
+// Slice returns the samples in [start, end) of every lead, sharing storage
+// with r.
+func (r Recording) Slice(start, end int) Recording {
+	out := Recording{SampleRate: r.SampleRate, Resolution: r.Resolution, Start: r.Start, Leads: make([]Lead, 0, len(r.Leads))}
+	for _, v := range r.Leads {
+		s, e := start, end
+		if s > len(v.Samples) {
+			s = len(v.Samples)
+		}
+		if e > len(v.Samples) {
+			e = len(v.Samples)
+		}
+		out.Leads = append(out.Leads, Lead{Name: v.Name, Samples: v.Samples[s:e]})
+	}
+
+	return out
+}
+
+// ms converts a number of samples to milliseconds.
+func (r Recording) ms(samples float64) float64 {
+	return 1000 * samples / r.SampleRate
+}
+
+// samples converts milliseconds to a (rounded) number of samples.
+func (r Recording) samples(ms float64) int {
+	return int(math.Round(ms * r.SampleRate / 1000))
+}
+
+// ParseWaveform parses comma-delimited integer samples, as in CardioSoft XML,
+// ignoring whitespace, and scales them by mvPerLSB.
+func ParseWaveform(text string, mvPerLSB float64) ([]float64, error) {
+	text = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\n', '\r', '\t':
+			return -1
+		}
+		return r
+	}, text)
+	if text == "" {
+		return nil, nil
+	}
+
+	fields := strings.Split(text, ",")
+	out := make([]float64, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Sample %d is not numeric and is instead [%s]", i, field)
+		}
+		out[i] = v * mvPerLSB
+	}
+
+	return out, nil
+}
+
+// MVPerLSB converts a CardioSoft Resolution (e.g., 5 uVperLsb) to mV per
+// least significant bit. Unrecognized units are assumed to be uV.
+func MVPerLSB(value, units string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse resolution %q: %v", value, err)
+	}
+
+	switch strings.ToLower(units) {
+	case "mvperlsb":
+		return v, nil
+	}
+
+	return 0.001 * v, nil
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,171 @@
+package ecg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// syntheticECG builds a 10-second ECG from Hann-shaped P, QRS, and T waves,
+// each projected onto the frontal plane leads from a fixed axis. Within every
+// 1-second beat, the P wave spans 100-200 ms, the QRS 260-360 ms, and the T
+// wave 460-660 ms: PR 160 ms, QRS 100 ms, QT 400 ms.
+func syntheticECG(rng *rand.Rand) Recording {
+	const hz = 500.0
+	const seconds = 10
+
+	type wave struct {
+		startMS, durationMS, mV, axis float64
+	}
+	waves := []wave{
+		{100, 100, 0.15, 60},
+		{260, 100, 1.5, 45},
+		{460, 200, 0.4, 40},
+	}
+	leadAngles := []struct {
+		name  string
+		angle float64
+	}{{"I", 0}, {"II", 60}, {"III", 120}, {"aVR", -150}, {"aVL", -30}, {"aVF", 90}}
+
+	rec := Recording{SampleRate: hz}
+	for _, lead := range leadAngles {
+		samples := make([]float64, seconds*int(hz))
+		for i := range samples {
+			ms := math.Mod(1000*float64(i)/hz, 1000)
+			for _, w := range waves {
+				if ms < w.startMS || ms > w.startMS+w.durationMS {
+					continue
+				}
+				shape := 0.5 * (1 - math.Cos(2*math.Pi*(ms-w.startMS)/w.durationMS))
+				samples[i] += w.mV * math.Cos((w.axis-lead.angle)*math.Pi/180) * shape
+			}
+			samples[i] += 0.1*math.Sin(2*math.Pi*0.2*float64(i)/hz) + 0.005*rng.NormFloat64()
+		}
+		rec.Leads = append(rec.Leads, Lead{Name: lead.name, Samples: samples})
+	}
+
+	return rec
+}
+
+func TestAnalyze(t *testing.T) {
+	rec := syntheticECG(rand.New(rand.NewSource(1)))
+
+	m, err := Analyze(rec, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Beats != 10 {
+		t.Errorf("Expected 10 R peaks, got %d (%v)", m.Beats, m.RPeaks)
+	}
+
+	for _, v := range []struct {
+		name                 string
+		got, want, tolerance float64
+	}{
+		{"heart rate", m.HeartRate, 60, 0.5},
+		{"SDNN", m.SDNN, 0, 5},
+		{"QRS duration", m.QRSDuration, 100, 15},
+		{"PR interval", m.PRInterval, 160, 20},
+		{"P duration", m.PDuration, 100, 25},
+		{"QT interval", m.QTInterval, 400, 25},
+		{"QTc (Bazett)", m.QTcBazett, 400, 25},
+		{"P axis", m.PAxis, 60, 10},
+		{"QRS axis", m.QRSAxis, 45, 10},
+		{"T axis", m.TAxis, 40, 10},
+	} {
+		if math.IsNaN(v.got) || math.Abs(v.got-v.want) > v.tolerance {
+			t.Errorf("%s: expected %f +/- %f, got %f", v.name, v.want, v.tolerance, v.got)
+		}
+	}
+}
+
+func TestDetectRPeaksIrregular(t *testing.T) {
+	const hz = 500.0
+
+	// Narrow spikes at irregular intervals, with a tall T wave after each.
+	rrMS := []float64{800, 1100, 600, 900, 1300, 700, 1000, 850, 950}
+	samples := make([]float64, int(hz*10))
+	var want []int
+	at := 300.0
+	for _, rr := range append([]float64{0}, rrMS...) {
+		at += rr
+		peak := int(at * hz / 1000)
+		want = append(want, peak)
+		for i := -15; i <= 15; i++ {
+			samples[peak+i] += 1.2 * math.Exp(-float64(i*i)/20)
+		}
+		for i := 0; i < 100; i++ {
+			samples[peak+100+i] += 0.5 * math.Sin(math.Pi*float64(i)/100)
+		}
+	}
+
+	got, err := DetectRPeaks(samples, hz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d peaks, got %d (%v)", len(want), len(got), got)
+	}
+	for i := range want {
+		if d := got[i] - want[i]; d < -2 || d > 2 {
+			t.Errorf("Peak %d: expected sample %d, got %d", i, want[i], got[i])
+		}
+	}
+}
+
+func TestWriters(t *testing.T) {
+	rec := Recording{
+		SampleRate: 4,
+		Resolution: 0.005,
+		Leads: []Lead{
+			{Name: "I", Samples: []float64{0, 0.005, -0.01, 1, 0.5}},
+			{Name: "aVR", Samples: []float64{0.1, 0.2, 0.3, 0.4, 0.5}},
+		},
+	}
+
+	var hea, dat bytes.Buffer
+	if err := WriteWFDB(&hea, &dat, "rec", "rec.dat", rec); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(hea.String()), "\n")
+	if len(lines) != 3 || lines[0] != "rec 2 4 5" || !strings.HasPrefix(lines[2], "rec.dat 16 200(0)/mV 16 0 20 ") || !strings.HasSuffix(lines[2], " aVR") {
+		t.Errorf("Unexpected WFDB header:\n%s", hea.String())
+	}
+
+	// Samples are interleaved by lead.
+	samples := make([]int16, dat.Len()/2)
+	if err := binary.Read(&dat, binary.LittleEndian, samples); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int16{0, 20, 1, 40, -2, 60, 200, 80, 100, 100}; !reflect.DeepEqual(samples, want) {
+		t.Errorf("Expected WFDB samples %v, got %v", want, samples)
+	}
+
+	// EDF+: a 256-byte header per signal, plus the annotation signal, and two
+	// one-second data records of 4 samples per lead plus the annotations.
+	var edf bytes.Buffer
+	if err := WriteEDF(&edf, "1000", rec); err != nil {
+		t.Fatal(err)
+	}
+	if want := 256*4 + 2*2*(2*4+edfAnnotationSamples); edf.Len() != want {
+		t.Errorf("Expected %d EDF bytes, got %d", want, edf.Len())
+	}
+	if header := edf.String(); header[236:244] != "2       " || header[252:256] != "3   " {
+		t.Errorf("Unexpected EDF record or signal count: %q, %q", header[236:244], header[252:256])
+	}
+
+	var aecg bytes.Buffer
+	if err := WriteAECG(&aecg, "1.2.3", rec); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(aecg.String(), "<digits>0 1 -2 200 100</digits>") || !strings.Contains(aecg.String(), `code="MDC_ECG_LEAD_AVR"`) {
+		t.Errorf("Unexpected aECG:\n%s", aecg.String())
+	}
+}
@@ -0,0 +1,164 @@
+package ecg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// edfAnnotationSamples is the number of 2-byte samples that the EDF
+// Annotations signal holds in each data record. It only needs to fit the
+// timekeeping annotation.
+const edfAnnotationSamples = 32
+
+// WriteEDF writes a recording as a continuous EDF+ file (EDF+C), with
+// one-second data records and the timekeeping annotation signal that EDF+
+// requires. The sampling rate must be a whole number of Hz. Leads are stored in
+// mV; the final data record is padded with zeros.
+func WriteEDF(w io.Writer, patientID string, rec Recording) error {
+	perRecord := int(math.Round(rec.SampleRate))
+	if perRecord <= 0 || math.Abs(float64(perRecord)-rec.SampleRate) > 1e-9 {
+		return fmt.Errorf("EDF export requires a whole number of samples per second, but the sample rate is %f", rec.SampleRate)
+	}
+
+	resolution, leads := digitize(rec)
+	n := rec.Len()
+	records := (n + perRecord - 1) / perRecord
+
+	start := rec.Start
+	if start.IsZero() {
+		start = time.Date(1985, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	if patientID == "" {
+		patientID = "X"
+	}
+
+	ns := len(leads) + 1
+
+	var header strings.Builder
+	field := func(value string, width int) {
+		if len(value) > width {
+			value = value[:width]
+		}
+		header.WriteString(value)
+		header.WriteString(strings.Repeat(" ", width-len(value)))
+	}
+
+	field("0", 8)
+	// EDF+ patient identification: code, sex, birthdate, and name.
+	field(strings.ReplaceAll(patientID, " ", "_")+" X X X", 80)
+	// EDF+ recording identification: start date, admin code, technician, and
+	// equipment.
+	field("Startdate "+strings.ToUpper(start.Format("02-Jan-2006"))+" X X X", 80)
+	field(start.Format("02.01.06"), 8)
+	field(start.Format("15.04.05"), 8)
+	field(strconv.Itoa(256*(ns+1)), 8)
+	field("EDF+C", 44)
+	field(strconv.Itoa(records), 8)
+	field("1", 8)
+	field(strconv.Itoa(ns), 4)
+
+	labels := make([]string, 0, ns)
+	for _, lead := range rec.Leads {
+		labels = append(labels, "ECG "+lead.Name)
+	}
+	labels = append(labels, "EDF Annotations")
+
+	physMax := resolution * math.MaxInt16
+	physMin := resolution * math.MinInt16
+
+	for _, v := range labels {
+		field(v, 16)
+	}
+	for range labels {
+		field("", 80) // Transducer
+	}
+	for i := range labels {
+		if i < len(leads) {
+			field("mV", 8)
+		} else {
+			field("", 8)
+		}
+	}
+	for i := range labels {
+		if i < len(leads) {
+			field(formatEDFNumber(physMin), 8)
+		} else {
+			field("-1", 8)
+		}
+	}
+	for i := range labels {
+		if i < len(leads) {
+			field(formatEDFNumber(physMax), 8)
+		} else {
+			field("1", 8)
+		}
+	}
+	for range labels {
+		field(strconv.Itoa(math.MinInt16), 8)
+	}
+	for range labels {
+		field(strconv.Itoa(math.MaxInt16), 8)
+	}
+	for range labels {
+		field("", 80) // Prefiltering
+	}
+	for i := range labels {
+		if i < len(leads) {
+			field(strconv.Itoa(perRecord), 8)
+		} else {
+			field(strconv.Itoa(edfAnnotationSamples), 8)
+		}
+	}
+	for range labels {
+		field("", 32)
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(header.String()); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2)
+	for r := 0; r < records; r++ {
+		for i := range leads {
+			for j := r * perRecord; j < (r+1)*perRecord; j++ {
+				var v int16
+				if j < n {
+					v = leads[i][j]
+				}
+				binary.LittleEndian.PutUint16(buf, uint16(v))
+				if _, err := bw.Write(buf); err != nil {
+					return err
+				}
+			}
+		}
+
+		// The timekeeping annotation gives the onset of each data record.
+		tal := make([]byte, 2*edfAnnotationSamples)
+		copy(tal, fmt.Sprintf("+%d\x14\x14\x00", r))
+		if _, err := bw.Write(tal); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// formatEDFNumber formats a number in at most 8 characters.
+func formatEDFNumber(v float64) string {
+	for precision := 6; precision > 0; precision-- {
+		s := strings.TrimRight(strings.TrimRight(strconv.FormatFloat(v, 'f', precision, 64), "0"), ".")
+		if len(s) <= 8 {
+			return s
+		}
+	}
+
+	return strconv.FormatFloat(v, 'f', 0, 64)
+}
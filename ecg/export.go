@@ -0,0 +1,69 @@
+package ecg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExportFormats are the formats understood by WriteFiles.
+var ExportFormats = []string{"wfdb", "aecg", "edf"}
+
+// WriteFiles writes a recording in each of the given formats, to files named
+// after prefix: prefix.hea and prefix.dat for WFDB, prefix_aecg.xml for HL7
+// aECG, and prefix.edf for EDF+. id identifies the recording (e.g., a sample
+// ID) within formats that carry one.
+func WriteFiles(prefix string, formats []string, id string, rec Recording) error {
+	for _, format := range formats {
+		var err error
+
+		switch strings.ToLower(strings.TrimSpace(format)) {
+		case "wfdb":
+			err = writeWFDBFiles(prefix, rec)
+		case "aecg":
+			err = writeFile(prefix+"_aecg.xml", func(f *os.File) error { return WriteAECG(f, id, rec) })
+		case "edf":
+			err = writeFile(prefix+".edf", func(f *os.File) error { return WriteEDF(f, id, rec) })
+		default:
+			err = fmt.Errorf("Unrecognized export format %q. Options include %s", format, strings.Join(ExportFormats, ", "))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeWFDBFiles(prefix string, rec Recording) error {
+	name := filepath.Base(prefix)
+
+	dat, err := os.Create(prefix + ".dat")
+	if err != nil {
+		return err
+	}
+	defer dat.Close()
+
+	return writeFile(prefix+".hea", func(hea *os.File) error {
+		if err := WriteWFDB(hea, dat, name, name+".dat", rec); err != nil {
+			return err
+		}
+		return dat.Close()
+	})
+}
+
+func writeFile(name string, write func(*os.File) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := write(f); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,63 @@
+package ecg
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/jfcg/butter"
+)
+
+// BandPass applies second-order Butterworth high-pass (highPassHz) and
+// low-pass (lowPassHz) filters forward and then backward, so that the result
+// has no phase delay: R peaks and wave boundaries stay where they were. A
+// cutoff of 0 skips that filter.
+func BandPass(samples []float64, sampleRate, highPassHz, lowPassHz float64) ([]float64, error) {
+	out := append([]float64(nil), samples...)
+	if len(out) == 0 {
+		return out, nil
+	}
+
+	wcBase := 2.0 * math.Pi / sampleRate
+
+	if highPassHz > 0 {
+		newFilter := func() butter.Filter { return butter.NewHighPass2(highPassHz * wcBase) }
+		if newFilter() == nil {
+			return nil, fmt.Errorf("Invalid high-pass filter (attempted wc=%f for highPassHz=%f, but expect .0001 < wc && wc < 3.1415)", wcBase*highPassHz, highPassHz)
+		}
+
+		// A high-pass filter in steady state outputs 0 for a constant input.
+		filtfilt(out, newFilter, func(u float64) float64 { return 0 })
+	}
+
+	if lowPassHz > 0 {
+		newFilter := func() butter.Filter { return butter.NewLowPass2(lowPassHz * wcBase) }
+		if newFilter() == nil {
+			return nil, fmt.Errorf("Invalid low-pass filter (attempted wc=%f for lowPassHz=%f, but expect .0001 < wc && wc < 3.1415)", wcBase*lowPassHz, lowPassHz)
+		}
+
+		// A low-pass filter in steady state passes a constant input through.
+		filtfilt(out, newFilter, func(u float64) float64 { return u })
+	}
+
+	return out, nil
+}
+
+// filtfilt runs a fresh filter over x forward and then backward, in place. Each
+// pass starts from the steady state for the first sample, which avoids a
+// startup transient at the edges.
+func filtfilt(x []float64, newFilter func() butter.Filter, steady func(u float64) float64) {
+	for pass := 0; pass < 2; pass++ {
+		f := newFilter()
+		f.Reset(x[0], steady(x[0]))
+		for i := range x {
+			x[i] = f.Next(x[i])
+		}
+		reverse(x)
+	}
+}
+
+func reverse(x []float64) {
+	for i, j := 0, len(x)-1; i < j; i, j = i+1, j-1 {
+		x[i], x[j] = x[j], x[i]
+	}
+}
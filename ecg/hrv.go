@@ -0,0 +1,136 @@
+package ecg
+
+import (
+	"math"
+)
+
+// HRV summarizes heart rate and its variability over a series of R peaks.
+type HRV struct {
+	Beats int
+
+	// HeartRate is in beats per minute, from the median RR interval.
+	HeartRate float64
+
+	// MeanRR, SDNN, and RMSSD are in ms and are computed from normal-to-normal
+	// intervals only.
+	MeanRR float64
+	SDNN   float64
+	RMSSD  float64
+
+	// Ectopic is the number of RR intervals that differ from the median RR
+	// interval by more than 20%, and which are excluded from MeanRR, SDNN, and
+	// RMSSD.
+	Ectopic int
+}
+
+// RRIntervals converts R-peak sample indices to RR intervals in ms.
+func RRIntervals(peaks []int, sampleRate float64) []float64 {
+	if len(peaks) < 2 {
+		return nil
+	}
+
+	out := make([]float64, 0, len(peaks)-1)
+	for i := 1; i < len(peaks); i++ {
+		out = append(out, 1000*float64(peaks[i]-peaks[i-1])/sampleRate)
+	}
+
+	return out
+}
+
+// normalBeats flags RR intervals within 20% of the median.
+func normalBeats(rr []float64) []bool {
+	med := median(rr)
+
+	out := make([]bool, len(rr))
+	for i, v := range rr {
+		out[i] = math.Abs(v-med) <= 0.2*med
+	}
+
+	return out
+}
+
+// ComputeHRV derives heart rate and time-domain heart rate variability from R
+// peaks. Fields that cannot be computed are NaN.
+func ComputeHRV(peaks []int, sampleRate float64) HRV {
+	out := HRV{
+		Beats:     len(peaks),
+		HeartRate: math.NaN(),
+		MeanRR:    math.NaN(),
+		SDNN:      math.NaN(),
+		RMSSD:     math.NaN(),
+	}
+
+	rr := RRIntervals(peaks, sampleRate)
+	if len(rr) == 0 {
+		return out
+	}
+
+	out.HeartRate = 60000 / median(rr)
+
+	normal := normalBeats(rr)
+	var nn []float64
+	var successive []float64
+	for i, v := range rr {
+		if !normal[i] {
+			out.Ectopic++
+			continue
+		}
+		nn = append(nn, v)
+		if i > 0 && normal[i-1] {
+			successive = append(successive, v-rr[i-1])
+		}
+	}
+
+	if len(nn) > 0 {
+		sum := 0.0
+		for _, v := range nn {
+			sum += v
+		}
+		out.MeanRR = sum / float64(len(nn))
+	}
+
+	if len(nn) > 1 {
+		ss := 0.0
+		for _, v := range nn {
+			ss += (v - out.MeanRR) * (v - out.MeanRR)
+		}
+		out.SDNN = math.Sqrt(ss / float64(len(nn)-1))
+	}
+
+	if len(successive) > 0 {
+		ss := 0.0
+		for _, v := range successive {
+			ss += v * v
+		}
+		out.RMSSD = math.Sqrt(ss / float64(len(successive)))
+	}
+
+	return out
+}
+
+// PrematureBeats flags RR intervals that are shorter, by more than tolerance
+// (a fraction, e.g., 0.2), than the median of the window intervals on either
+// side: the beat that ends such an interval came early. Unlike the global
+// median used by ComputeHRV, the local median follows the heart rate as it
+// changes, as during exercise. The compensatory pause after a premature beat is
+// not flagged.
+func PrematureBeats(rr []float64, window int, tolerance float64) []bool {
+	out := make([]bool, len(rr))
+	neighbors := make([]float64, 0, 2*window)
+	for i, v := range rr {
+		neighbors = neighbors[:0]
+		for j := i - window; j <= i+window; j++ {
+			if j < 0 || j >= len(rr) || j == i {
+				continue
+			}
+			neighbors = append(neighbors, rr[j])
+		}
+		if len(neighbors) == 0 {
+			continue
+		}
+
+		out[i] = v < (1-tolerance)*median(neighbors)
+	}
+
+	return out
+}
@@ -0,0 +1,138 @@
+package ecg
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Options control how a Recording is analyzed.
+type Options struct {
+	// DetectionLead is the lead in which R peaks are detected.
+	DetectionLead string
+
+	// HighPassHz and LowPassHz bound the zero-phase band-pass filter that is
+	// applied to every lead before median beats are formed. 0 disables either.
+	HighPassHz float64
+	LowPassHz  float64
+}
+
+// DefaultOptions detect R peaks in lead II and remove baseline wander and
+// high-frequency noise with a 0.5-40 Hz band-pass.
+func DefaultOptions() Options {
+	return Options{
+		DetectionLead: "II",
+		HighPassHz:    0.5,
+		LowPassHz:     40,
+	}
+}
+
+// Measurements are the results of analyzing a Recording.
+type Measurements struct {
+	HRV
+	Intervals
+
+	// RPeaks are the sample indices of the detected R peaks.
+	RPeaks []int
+
+	// MedianBeats is the number of beats that went into the median beat.
+	MedianBeats int
+}
+
+// Analyze filters a recording, detects R peaks, forms median beats, and
+// delineates them.
+func Analyze(rec Recording, opts Options) (Measurements, error) {
+	out := Measurements{}
+
+	filtered := Recording{SampleRate: rec.SampleRate, Resolution: rec.Resolution, Start: rec.Start, Leads: make([]Lead, 0, len(rec.Leads))}
+	for _, lead := range rec.Leads {
+		samples, err := BandPass(lead.Samples, rec.SampleRate, opts.HighPassHz, opts.LowPassHz)
+		if err != nil {
+			return out, err
+		}
+		filtered.Leads = append(filtered.Leads, Lead{Name: lead.Name, Samples: samples})
+	}
+
+	detection, ok := filtered.Lead(opts.DetectionLead)
+	if !ok {
+		return out, fmt.Errorf("Lead %s was not found", opts.DetectionLead)
+	}
+
+	peaks, err := DetectRPeaks(detection.Samples, rec.SampleRate)
+	if err != nil {
+		return out, err
+	}
+	out.RPeaks = peaks
+	out.HRV = ComputeHRV(peaks, rec.SampleRate)
+
+	beat, err := MedianBeats(filtered, peaks)
+	if err != nil {
+		return out, err
+	}
+	out.MedianBeats = beat.Beats
+	out.Intervals = Delineate(beat, median(RRIntervals(peaks, rec.SampleRate)))
+
+	return out, nil
+}
+
+// AnalyzeMedian delineates a median beat computed elsewhere (e.g., by the ECG
+// machine). Its fiducial point is taken to be the steepest point of the beat.
+func AnalyzeMedian(rec Recording, rrMS float64) Intervals {
+	slope := combinedSlope(rec)
+	beat := MedianBeat{Recording: rec, Fiducial: argMax(slope, 0, len(slope)-1), Beats: 1}
+
+	return Delineate(beat, rrMS)
+}
+
+// MeasurementNames are the names of the values returned by
+// Measurements.Values, in order.
+var MeasurementNames = []string{
+	"heart_rate",
+	"rr_interval",
+	"sdnn",
+	"rmssd",
+	"beats",
+	"ectopic_beats",
+	"median_beats",
+	"p_duration",
+	"pr_interval",
+	"qrs_duration",
+	"qt_interval",
+	"qtc_bazett",
+	"qtc_fridericia",
+	"p_axis",
+	"qrs_axis",
+	"t_axis",
+}
+
+// Values returns the measurements in the order of MeasurementNames.
+func (m Measurements) Values() []float64 {
+	return []float64{
+		m.HeartRate,
+		m.MeanRR,
+		m.SDNN,
+		m.RMSSD,
+		float64(m.Beats),
+		float64(m.Ectopic),
+		float64(m.MedianBeats),
+		m.PDuration,
+		m.PRInterval,
+		m.QRSDuration,
+		m.QTInterval,
+		m.QTcBazett,
+		m.QTcFridericia,
+		m.PAxis,
+		m.QRSAxis,
+		m.TAxis,
+	}
+}
+
+// FormatValue formats a measurement for tab-delimited output, with NA for
+// missing values. Values are rounded to 4 decimal places.
+func FormatValue(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "NA"
+	}
+
+	return strconv.FormatFloat(math.Round(v*1e4)/1e4, 'f', -1, 64)
+}
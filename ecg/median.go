@@ -0,0 +1,76 @@
+package ecg
+
+import (
+	"fmt"
+)
+
+// Window of each beat around its R peak used to build median beats.
+const (
+	medianBeforeMS = 350
+	medianAfterMS  = 650
+)
+
+// MedianBeat is the sample-by-sample median of the aligned beats of a
+// recording. The R peak of every beat is at sample Fiducial.
+type MedianBeat struct {
+	Recording
+	Fiducial int
+
+	// Beats is the number of beats that went into the median.
+	Beats int
+}
+
+// MedianBeats aligns beats on their R peaks and takes the median of each lead
+// at every sample. Beats are skipped if they are too close to either end of
+// the recording or if either adjacent RR interval is ectopic (more than 20%
+// from the median RR interval), since premature beats have a different
+// morphology.
+func MedianBeats(rec Recording, peaks []int) (MedianBeat, error) {
+	before := rec.samples(medianBeforeMS)
+	after := rec.samples(medianAfterMS)
+
+	rr := RRIntervals(peaks, rec.SampleRate)
+	normal := normalBeats(rr)
+
+	var beats []int
+	for i, at := range peaks {
+		if at-before < 0 || at+after >= rec.Len() {
+			continue
+		}
+		if i > 0 && !normal[i-1] {
+			continue
+		}
+		if i < len(rr) && !normal[i] {
+			continue
+		}
+		beats = append(beats, at)
+	}
+
+	if len(beats) == 0 {
+		return MedianBeat{}, fmt.Errorf("None of the %d beats could be used for a median beat", len(peaks))
+	}
+
+	out := MedianBeat{
+		Recording: Recording{SampleRate: rec.SampleRate},
+		Fiducial:  before,
+		Beats:     len(beats),
+	}
+
+	values := make([]float64, len(beats))
+	for _, lead := range rec.Leads {
+		if len(lead.Samples) < rec.Len() {
+			continue
+		}
+
+		med := make([]float64, before+after+1)
+		for offset := range med {
+			for k, at := range beats {
+				values[k] = lead.Samples[at-before+offset]
+			}
+			med[offset] = median(values)
+		}
+		out.Leads = append(out.Leads, Lead{Name: lead.Name, Samples: med})
+	}
+
+	return out, nil
+}
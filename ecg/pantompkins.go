@@ -0,0 +1,231 @@
+package ecg
+
+import (
+	"fmt"
+	"math"
+)
+
+// DetectRPeaks finds the R peaks in one lead with the Pan-Tompkins algorithm
+// (Pan & Tompkins, IEEE Trans Biomed Eng 1985): the signal is band-passed at
+// 5-15 Hz, differentiated, squared, and integrated over a 150 ms window, and
+// peaks of the integrated signal are classified as QRS complexes or noise
+// against adaptive thresholds. Missed beats are recovered by searching back
+// with a lower threshold when no beat has been seen for 166% of the recent RR
+// interval. Returned indices are the sample of the largest absolute deflection
+// of the band-passed signal near each detection.
+func DetectRPeaks(samples []float64, sampleRate float64) ([]int, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("Sample rate must be positive, got %f", sampleRate)
+	}
+
+	if len(samples) < int(sampleRate) {
+		return nil, fmt.Errorf("At least 1 second of signal is needed to detect R peaks, got %d samples at %f Hz", len(samples), sampleRate)
+	}
+
+	bandpassed, err := BandPass(samples, sampleRate, 5, 15)
+	if err != nil {
+		return nil, err
+	}
+
+	integrated := integrate(square(derivative(bandpassed, sampleRate)), int(math.Round(0.150*sampleRate)))
+
+	msToSamples := func(ms float64) int { return int(math.Round(ms * sampleRate / 1000)) }
+	refractory := msToSamples(200)
+	tWaveWindow := msToSamples(360)
+	slopeWindow := msToSamples(75)
+
+	peaks := localMaxima(integrated, refractory)
+	if len(peaks) == 0 {
+		return nil, nil
+	}
+
+	// Learning phase: initialize the signal and noise levels from the first 2
+	// seconds.
+	learn := msToSamples(2000)
+	if learn > len(integrated) {
+		learn = len(integrated)
+	}
+	spki, npki := 0.0, 0.0
+	for _, v := range integrated[:learn] {
+		spki = math.Max(spki, v)
+		npki += v
+	}
+	spki *= 0.25
+	npki /= 2 * float64(learn)
+
+	threshold := func() float64 { return npki + 0.25*(spki-npki) }
+
+	maxSlope := func(at int) float64 {
+		out := 0.0
+		for i := at - slopeWindow; i <= at; i++ {
+			if i < 1 || i >= len(bandpassed) {
+				continue
+			}
+			out = math.Max(out, math.Abs(bandpassed[i]-bandpassed[i-1]))
+		}
+		return out
+	}
+
+	var detected []int
+	var rrs []int
+	lastSlope := 0.0
+
+	accept := func(at int, searchback bool) {
+		if searchback {
+			spki = 0.25*integrated[at] + 0.75*spki
+		} else {
+			spki = 0.125*integrated[at] + 0.875*spki
+		}
+		if n := len(detected); n > 0 {
+			rrs = append(rrs, at-detected[n-1])
+			if len(rrs) > 8 {
+				rrs = rrs[1:]
+			}
+		}
+		detected = append(detected, at)
+		lastSlope = maxSlope(at)
+	}
+
+	for k, at := range peaks {
+		if at < refractory/2 {
+			continue
+		}
+
+		// Searchback: if too long has passed since the last beat, take the
+		// largest skipped peak that clears half the threshold.
+		if n := len(detected); n > 0 && len(rrs) > 0 {
+			meanRR := 0
+			for _, v := range rrs {
+				meanRR += v
+			}
+			meanRR /= len(rrs)
+
+			if at-detected[n-1] > int(1.66*float64(meanRR)) {
+				best := -1
+				for j := k - 1; j >= 0 && peaks[j] > detected[n-1]; j-- {
+					if peaks[j]-detected[n-1] < refractory {
+						continue
+					}
+					if integrated[peaks[j]] > 0.5*threshold() && (best < 0 || integrated[peaks[j]] > integrated[best]) {
+						best = peaks[j]
+					}
+				}
+				if best >= 0 {
+					accept(best, true)
+				}
+			}
+		}
+
+		if integrated[at] <= threshold() {
+			npki = 0.125*integrated[at] + 0.875*npki
+			continue
+		}
+
+		if n := len(detected); n > 0 {
+			if at-detected[n-1] < refractory {
+				continue
+			}
+
+			// A candidate soon after a beat whose slope is less than half of
+			// the beat's is likely a T wave.
+			if at-detected[n-1] < tWaveWindow && maxSlope(at) < 0.5*lastSlope {
+				npki = 0.125*integrated[at] + 0.875*npki
+				continue
+			}
+		}
+
+		accept(at, false)
+	}
+
+	// The integration window delays the peak of the integrated signal relative
+	// to the QRS; look back for the actual R peak.
+	search := msToSamples(150)
+	out := make([]int, 0, len(detected))
+	for _, at := range detected {
+		best := at
+		for i := at - search; i <= at+search/2; i++ {
+			if i < 0 || i >= len(bandpassed) {
+				continue
+			}
+			if math.Abs(bandpassed[i]) > math.Abs(bandpassed[best]) {
+				best = i
+			}
+		}
+		if n := len(out); n > 0 && best-out[n-1] < refractory {
+			continue
+		}
+		out = append(out, best)
+	}
+
+	return out, nil
+}
+
+// derivative is the five-point derivative, scaled to units per second.
+func derivative(x []float64, sampleRate float64) []float64 {
+	out := make([]float64, len(x))
+	for i := 2; i < len(x)-2; i++ {
+		out[i] = sampleRate * (-x[i-2] - 2*x[i-1] + 2*x[i+1] + x[i+2]) / 8
+	}
+
+	return out
+}
+
+func square(x []float64) []float64 {
+	for i, v := range x {
+		x[i] = v * v
+	}
+
+	return x
+}
+
+// integrate is a centered moving average over width samples.
+func integrate(x []float64, width int) []float64 {
+	if width < 1 {
+		width = 1
+	}
+
+	cumulative := make([]float64, len(x)+1)
+	for i, v := range x {
+		cumulative[i+1] = cumulative[i] + v
+	}
+
+	out := make([]float64, len(x))
+	for i := range x {
+		lo, hi := i-width/2, i+width-width/2
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(x) {
+			hi = len(x)
+		}
+		out[i] = (cumulative[hi] - cumulative[lo]) / float64(width)
+	}
+
+	return out
+}
+
+// localMaxima returns the indices of peaks of x that are the largest value
+// within minDistance samples on either side.
+func localMaxima(x []float64, minDistance int) []int {
+	var out []int
+	for i := 1; i < len(x)-1; i++ {
+		if x[i] <= 0 || x[i] < x[i-1] || x[i] <= x[i+1] {
+			continue
+		}
+
+		isMax := true
+		for j := i - minDistance; j <= i+minDistance && isMax; j++ {
+			if j < 0 || j >= len(x) || j == i {
+				continue
+			}
+			if x[j] > x[i] || (x[j] == x[i] && j < i) {
+				isMax = false
+			}
+		}
+		if isMax {
+			out = append(out, i)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,102 @@
+package ecg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// defaultResolution, in mV, is used to digitize recordings whose resolution is
+// unknown.
+const defaultResolution = 0.001
+
+// digitize converts each lead to 16-bit integers in units of the recording's
+// resolution, clipping values that do not fit. Leads shorter than the longest
+// lead are padded with zeros.
+func digitize(rec Recording) (resolution float64, leads [][]int16) {
+	resolution = rec.Resolution
+	if resolution <= 0 {
+		resolution = defaultResolution
+	}
+
+	n := rec.Len()
+	leads = make([][]int16, len(rec.Leads))
+	for i, lead := range rec.Leads {
+		leads[i] = make([]int16, n)
+		for j, v := range lead.Samples {
+			d := math.Round(v / resolution)
+			if math.IsNaN(d) {
+				d = 0
+			}
+			leads[i][j] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, d)))
+		}
+	}
+
+	return resolution, leads
+}
+
+// WriteWFDB writes a recording in the PhysioNet WFDB format: a header (.hea)
+// and a signal file (.dat) in format 16, which holds the samples of all leads
+// interleaved as 16-bit little-endian integers. datName is the name of the
+// signal file as it will be referenced from the header, e.g.,
+// recordName+".dat".
+func WriteWFDB(hea, dat io.Writer, recordName, datName string, rec Recording) error {
+	if strings.ContainsAny(recordName, " \t\n") || strings.ContainsAny(datName, " \t\n") {
+		return fmt.Errorf("WFDB record and file names may not contain whitespace")
+	}
+
+	resolution, leads := digitize(rec)
+	n := rec.Len()
+
+	bw := bufio.NewWriter(dat)
+	buf := make([]byte, 2)
+	for j := 0; j < n; j++ {
+		for i := range leads {
+			binary.LittleEndian.PutUint16(buf, uint16(leads[i][j]))
+			if _, err := bw.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	// Record line: name, number of signals, sampling frequency, samples per
+	// signal, and (optionally) the base time and date.
+	record := fmt.Sprintf("%s %d %s %d", recordName, len(leads), formatHeaderFloat(rec.SampleRate), n)
+	if !rec.Start.IsZero() {
+		record += " " + rec.Start.Format("15:04:05 02/01/2006")
+	}
+	if _, err := fmt.Fprintln(hea, record); err != nil {
+		return err
+	}
+
+	// Signal lines: file, format, gain (ADC units per mV) with baseline, ADC
+	// resolution (bits), ADC zero, initial value, checksum, block size, and
+	// description.
+	gain := 1 / resolution
+	for i, lead := range rec.Leads {
+		var checksum int16
+		initial := int16(0)
+		for j, v := range leads[i] {
+			if j == 0 {
+				initial = v
+			}
+			checksum += v
+		}
+
+		if _, err := fmt.Fprintf(hea, "%s 16 %s(0)/mV 16 0 %d %d 0 %s\n", datName, formatHeaderFloat(gain), initial, checksum, lead.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func formatHeaderFloat(v float64) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.6f", v), "0"), ".")
+}
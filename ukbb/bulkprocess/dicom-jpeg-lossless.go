@@ -0,0 +1,430 @@
+package bulkprocess
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// JPEG markers used by the lossless decoders.
+const (
+	jpegMarkerSOF3  = 0xC3 // Lossless, Huffman coded
+	jpegMarkerDHT   = 0xC4
+	jpegMarkerRST0  = 0xD0
+	jpegMarkerRST7  = 0xD7
+	jpegMarkerSOI   = 0xD8
+	jpegMarkerEOI   = 0xD9
+	jpegMarkerSOS   = 0xDA
+	jpegMarkerDRI   = 0xDD
+	jpegMarkerSOF55 = 0xF7 // JPEG-LS
+	jpegMarkerLSE   = 0xF8 // JPEG-LS preset parameters
+)
+
+// jpegSegment is one marker segment of a JPEG stream. For SOS, Entropy holds
+// the entropy-coded data that follows the header (up to the next marker that
+// is not a restart marker).
+type jpegSegment struct {
+	Marker  byte
+	Payload []byte
+	Entropy []byte
+}
+
+// splitJPEGSegments walks the marker segments of a JPEG (or JPEG-LS) stream.
+// stuffedByte reports whether the byte following 0xFF within entropy-coded data
+// is data rather than the start of a marker; this is the only place where
+// classic JPEG and JPEG-LS framing differ.
+func splitJPEGSegments(data []byte, stuffedByte func(next byte) bool) ([]jpegSegment, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != jpegMarkerSOI {
+		return nil, fmt.Errorf("JPEG stream does not start with an SOI marker")
+	}
+
+	var out []jpegSegment
+	for pos := 2; pos < len(data); {
+		// Skip any fill bytes between segments
+		if data[pos] != 0xFF {
+			return nil, fmt.Errorf("Expected a JPEG marker at byte %d", pos)
+		}
+		for pos < len(data) && data[pos] == 0xFF {
+			pos++
+		}
+		if pos >= len(data) {
+			break
+		}
+
+		marker := data[pos]
+		pos++
+
+		if marker == jpegMarkerEOI {
+			break
+		}
+		if marker >= jpegMarkerRST0 && marker <= jpegMarkerRST7 {
+			return nil, fmt.Errorf("Unexpected restart marker outside of entropy-coded data")
+		}
+
+		if pos+2 > len(data) {
+			return nil, fmt.Errorf("Truncated JPEG segment 0x%X", marker)
+		}
+		length := int(binary.BigEndian.Uint16(data[pos:]))
+		if length < 2 || pos+length > len(data) {
+			return nil, fmt.Errorf("Invalid length %d for JPEG segment 0x%X", length, marker)
+		}
+
+		seg := jpegSegment{Marker: marker, Payload: data[pos+2 : pos+length]}
+		pos += length
+
+		if marker == jpegMarkerSOS {
+			// Entropy-coded data runs until a marker other than a restart
+			// marker.
+			start := pos
+			for pos < len(data) {
+				if data[pos] != 0xFF || pos+1 >= len(data) {
+					pos++
+					continue
+				}
+				next := data[pos+1]
+				if stuffedByte(next) || (next >= jpegMarkerRST0 && next <= jpegMarkerRST7) {
+					pos += 2
+					continue
+				}
+				break
+			}
+			seg.Entropy = data[start:pos]
+		}
+
+		out = append(out, seg)
+	}
+
+	return out, nil
+}
+
+// jpegHuffmanTable is a canonical Huffman decoding table as described in
+// ITU-T T.81 Annex F.2.2.3.
+type jpegHuffmanTable struct {
+	maxCode [17]int32
+	valPtr  [17]int32
+	minCode [17]int32
+	values  []byte
+}
+
+func newJPEGHuffmanTable(counts []byte, values []byte) *jpegHuffmanTable {
+	h := &jpegHuffmanTable{values: values}
+	code := int32(0)
+	k := int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		if n == 0 {
+			h.maxCode[l] = -1
+		} else {
+			h.valPtr[l] = k
+			h.minCode[l] = code
+			code += n
+			k += n
+			h.maxCode[l] = code - 1
+		}
+		code <<= 1
+	}
+
+	return h
+}
+
+// jpegBitReader reads bits from classic (T.81) entropy-coded data, removing
+// stuffed zero bytes and stopping at markers.
+type jpegBitReader struct {
+	data   []byte
+	pos    int
+	bits   uint32
+	nBits  uint
+	marker bool
+}
+
+func (r *jpegBitReader) fill() {
+	for r.nBits <= 24 {
+		b := byte(0)
+		if !r.marker && r.pos < len(r.data) {
+			b = r.data[r.pos]
+			if b == 0xFF {
+				if r.pos+1 < len(r.data) && r.data[r.pos+1] == 0x00 {
+					r.pos += 2
+				} else {
+					// A marker: stop consuming input and pad with zeroes.
+					r.marker = true
+					b = 0
+				}
+			} else {
+				r.pos++
+			}
+		}
+		r.bits |= uint32(b) << (24 - r.nBits)
+		r.nBits += 8
+	}
+}
+
+func (r *jpegBitReader) readBit() int32 {
+	if r.nBits == 0 {
+		r.fill()
+	}
+	bit := int32(r.bits >> 31)
+	r.bits <<= 1
+	r.nBits--
+	return bit
+}
+
+func (r *jpegBitReader) readBits(n uint) int32 {
+	if n == 0 {
+		return 0
+	}
+	if r.nBits < n {
+		r.fill()
+	}
+	v := int32(r.bits >> (32 - n))
+	r.bits <<= n
+	r.nBits -= n
+	return v
+}
+
+// restart discards any buffered bits, along with the padding that precedes the
+// restart marker, and skips the marker itself.
+func (r *jpegBitReader) restart() error {
+	r.bits, r.nBits, r.marker = 0, 0, false
+	for ; r.pos+1 < len(r.data); r.pos++ {
+		if r.data[r.pos] == 0xFF && r.data[r.pos+1] >= jpegMarkerRST0 && r.data[r.pos+1] <= jpegMarkerRST7 {
+			r.pos += 2
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Expected a restart marker but reached the end of the scan")
+}
+
+func (r *jpegBitReader) decodeHuffman(h *jpegHuffmanTable) (int32, error) {
+	code := r.readBit()
+	for l := 1; l <= 16; l++ {
+		if code <= h.maxCode[l] {
+			return int32(h.values[h.valPtr[l]+code-h.minCode[l]]), nil
+		}
+		code = (code << 1) | r.readBit()
+	}
+
+	return 0, fmt.Errorf("Invalid Huffman code")
+}
+
+// decodeJPEGLossless decodes a lossless JPEG (ITU-T T.81 process 14, which
+// includes DICOM's default "selection value 1" syntax) into interleaved
+// samples.
+func decodeJPEGLossless(data []byte) (*decodedFrame, error) {
+	segments, err := splitJPEGSegments(data, func(next byte) bool { return next == 0x00 })
+	if err != nil {
+		return nil, err
+	}
+
+	var precision, rows, cols int
+	var componentIDs []byte
+	var restartInterval int
+	tables := make(map[byte]*jpegHuffmanTable)
+	var planes [][]int
+	out := &decodedFrame{}
+
+	for _, seg := range segments {
+		p := seg.Payload
+
+		switch {
+		case seg.Marker == jpegMarkerSOF3:
+			if len(p) < 6 {
+				return nil, fmt.Errorf("Truncated SOF3 segment")
+			}
+			precision = int(p[0])
+			rows = int(binary.BigEndian.Uint16(p[1:]))
+			cols = int(binary.BigEndian.Uint16(p[3:]))
+			nComponents := int(p[5])
+			if len(p) < 6+3*nComponents {
+				return nil, fmt.Errorf("Truncated SOF3 segment")
+			}
+			for i := 0; i < nComponents; i++ {
+				if p[6+3*i+1] != 0x11 {
+					return nil, fmt.Errorf("Subsampled lossless JPEG components are not supported")
+				}
+				componentIDs = append(componentIDs, p[6+3*i])
+			}
+			planes = make([][]int, nComponents)
+			for i := range planes {
+				planes[i] = make([]int, rows*cols)
+			}
+
+		case seg.Marker >= 0xC0 && seg.Marker <= 0xCF && seg.Marker != jpegMarkerDHT && seg.Marker != 0xC8 && seg.Marker != 0xCC:
+			return nil, fmt.Errorf("JPEG frame type 0x%X is not lossless (process 14)", seg.Marker)
+
+		case seg.Marker == jpegMarkerDHT:
+			for len(p) > 0 {
+				if len(p) < 17 {
+					return nil, fmt.Errorf("Truncated DHT segment")
+				}
+				id := p[0]
+				total := 0
+				for _, c := range p[1:17] {
+					total += int(c)
+				}
+				if len(p) < 17+total {
+					return nil, fmt.Errorf("Truncated DHT segment")
+				}
+				tables[id] = newJPEGHuffmanTable(p[1:17], p[17:17+total])
+				p = p[17+total:]
+			}
+
+		case seg.Marker == jpegMarkerDRI:
+			if len(p) < 2 {
+				return nil, fmt.Errorf("Truncated DRI segment")
+			}
+			restartInterval = int(binary.BigEndian.Uint16(p))
+
+		case seg.Marker == jpegMarkerSOS:
+			if planes == nil {
+				return nil, fmt.Errorf("SOS found before SOF3")
+			}
+			if err := decodeJPEGLosslessScan(seg, precision, rows, cols, componentIDs, tables, restartInterval, planes); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if planes == nil {
+		return nil, fmt.Errorf("No SOF3 (lossless) frame header found")
+	}
+
+	out.Rows, out.Cols, out.SamplesPerPixel = rows, cols, len(planes)
+	out.Samples = interleavePlanes(planes)
+
+	return out, nil
+}
+
+// decodeJPEGLosslessScan decodes one scan, which may contain one or more
+// (non-subsampled) components, into the corresponding planes.
+func decodeJPEGLosslessScan(seg jpegSegment, precision, rows, cols int, componentIDs []byte, tables map[byte]*jpegHuffmanTable, restartInterval int, planes [][]int) error {
+	p := seg.Payload
+	if len(p) < 1 || len(p) < 1+2*int(p[0])+3 {
+		return fmt.Errorf("Truncated SOS segment")
+	}
+	nScan := int(p[0])
+	scanPlanes := make([][]int, nScan)
+	scanTables := make([]*jpegHuffmanTable, nScan)
+	for i := 0; i < nScan; i++ {
+		id := p[1+2*i]
+		found := false
+		for j, cid := range componentIDs {
+			if cid == id {
+				scanPlanes[i] = planes[j]
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("SOS references unknown component %d", id)
+		}
+
+		// DC table class is 0, so the table ID is the high nibble.
+		scanTables[i] = tables[p[2+2*i]>>4]
+		if scanTables[i] == nil {
+			return fmt.Errorf("SOS references undefined Huffman table %d", p[2+2*i]>>4)
+		}
+	}
+	predictor := int(p[1+2*nScan])
+	pointTransform := uint(p[3+2*nScan] & 0x0F)
+
+	if predictor < 1 || predictor > 7 {
+		return fmt.Errorf("Invalid lossless JPEG predictor %d", predictor)
+	}
+
+	r := &jpegBitReader{data: seg.Entropy}
+	initialPrediction := 1 << uint(precision-int(pointTransform)-1)
+	mask := (1 << uint(precision)) - 1
+
+	// The prediction is reset at the start of the scan and after each restart
+	// marker: the first sample uses the initial prediction and the remainder
+	// of that row uses the sample to the left.
+	resetX, resetY := 0, 0
+	mcu := 0
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			if restartInterval > 0 && mcu > 0 && mcu%restartInterval == 0 {
+				if err := r.restart(); err != nil {
+					return err
+				}
+				resetX, resetY = x, y
+			}
+			mcu++
+
+			for c, plane := range scanPlanes {
+				ssss, err := r.decodeHuffman(scanTables[c])
+				if err != nil {
+					return err
+				}
+				diff := int32(0)
+				switch {
+				case ssss == 16:
+					diff = 32768
+				case ssss > 0:
+					diff = r.readBits(uint(ssss))
+					if diff < 1<<uint(ssss-1) {
+						diff += (-1 << uint(ssss)) + 1
+					}
+				}
+
+				idx := y*cols + x
+				var pred int
+				switch {
+				case y == resetY && x == resetX:
+					pred = initialPrediction
+				case y == resetY:
+					pred = plane[idx-1]
+				case x == 0:
+					pred = plane[idx-cols]
+				default:
+					ra, rb, rc := plane[idx-1], plane[idx-cols], plane[idx-cols-1]
+					switch predictor {
+					case 1:
+						pred = ra
+					case 2:
+						pred = rb
+					case 3:
+						pred = rc
+					case 4:
+						pred = ra + rb - rc
+					case 5:
+						pred = ra + ((rb - rc) >> 1)
+					case 6:
+						pred = rb + ((ra - rc) >> 1)
+					case 7:
+						pred = (ra + rb) >> 1
+					}
+				}
+
+				plane[idx] = (pred + int(diff)) & mask
+			}
+		}
+	}
+
+	if pointTransform > 0 {
+		for _, plane := range scanPlanes {
+			for i := range plane {
+				plane[i] <<= pointTransform
+			}
+		}
+	}
+
+	return nil
+}
+
+// interleavePlanes converts planar component data into pixel-interleaved
+// samples.
+func interleavePlanes(planes [][]int) []int {
+	if len(planes) == 1 {
+		return planes[0]
+	}
+
+	n := len(planes[0])
+	out := make([]int, n*len(planes))
+	for c, plane := range planes {
+		for i, v := range plane {
+			out[i*len(planes)+c] = v
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,709 @@
+package bulkprocess
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// JPEG-LS decoding, per ITU-T T.87. Supports lossless and near-lossless
+// coding, 2-16 bit samples, and all three interleave modes. Mapping tables and
+// subsampled components are not supported, since they are not used by DICOM
+// encoders in practice.
+
+// jpeglsJ is the run length order table (T.87 A.7.1.1)
+var jpeglsJ = [32]int{0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// jpeglsPresets holds the coding parameters, which may be given in an LSE
+// segment or otherwise take their default values.
+type jpeglsPresets struct {
+	MaxVal, T1, T2, T3, Reset int
+}
+
+type jpeglsContext struct {
+	A, B, C, N int
+}
+
+type jpeglsRunContext struct {
+	A, N, Nn, RIType int
+}
+
+// jpeglsBitReader reads JPEG-LS entropy-coded data. Unlike classic JPEG, a
+// 0xFF byte is followed by a byte whose most significant bit is a stuffed zero.
+type jpeglsBitReader struct {
+	data   []byte
+	pos    int
+	cur    byte
+	nBits  uint
+	prevFF bool
+}
+
+func (r *jpeglsBitReader) readBit() int {
+	if r.nBits == 0 {
+		r.cur, r.nBits = 0, 8
+		if r.pos < len(r.data) {
+			b := r.data[r.pos]
+			if r.prevFF {
+				if b&0x80 != 0 {
+					// We've run into a marker: pad with zeroes
+					return 0
+				}
+				r.nBits = 7
+			}
+			r.cur = b
+			r.prevFF = b == 0xFF
+			r.pos++
+		}
+	}
+
+	r.nBits--
+	return int(r.cur>>r.nBits) & 1
+}
+
+func (r *jpeglsBitReader) readValue(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		v = (v << 1) | r.readBit()
+	}
+	return v
+}
+
+// restart skips ahead past the next restart marker.
+func (r *jpeglsBitReader) restart() error {
+	r.cur, r.nBits, r.prevFF = 0, 0, false
+	for ; r.pos+1 < len(r.data); r.pos++ {
+		if r.data[r.pos] == 0xFF && r.data[r.pos+1] >= jpegMarkerRST0 && r.data[r.pos+1] <= jpegMarkerRST7 {
+			r.pos += 2
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Expected a restart marker but reached the end of the scan")
+}
+
+// jpeglsScan holds the state needed to decode one scan.
+type jpeglsScan struct {
+	r *jpeglsBitReader
+
+	maxVal, near, rangeVal, qbpp, limit int
+	t1, t2, t3, reset                   int
+
+	contexts    [365]jpeglsContext
+	runContexts [2]jpeglsRunContext
+}
+
+func newJPEGLSScan(data []byte, presets jpeglsPresets, near int) *jpeglsScan {
+	s := &jpeglsScan{
+		r:      &jpeglsBitReader{data: data},
+		maxVal: presets.MaxVal,
+		near:   near,
+		t1:     presets.T1,
+		t2:     presets.T2,
+		t3:     presets.T3,
+		reset:  presets.Reset,
+	}
+
+	s.rangeVal = (s.maxVal+2*near)/(2*near+1) + 1
+	s.qbpp = ceilLog2(s.rangeVal)
+	bpp := ceilLog2(s.maxVal + 1)
+	if bpp < 2 {
+		bpp = 2
+	}
+	if bpp > 8 {
+		s.limit = 2 * (bpp + bpp)
+	} else {
+		s.limit = 2 * (bpp + 8)
+	}
+
+	s.resetContexts()
+
+	return s
+}
+
+func (s *jpeglsScan) resetContexts() {
+	a := (s.rangeVal + 32) / 64
+	if a < 2 {
+		a = 2
+	}
+	for i := range s.contexts {
+		s.contexts[i] = jpeglsContext{A: a, N: 1}
+	}
+	s.runContexts[0] = jpeglsRunContext{A: a, N: 1, RIType: 0}
+	s.runContexts[1] = jpeglsRunContext{A: a, N: 1, RIType: 1}
+}
+
+func (s *jpeglsScan) quantize(d int) int {
+	switch {
+	case d <= -s.t3:
+		return -4
+	case d <= -s.t2:
+		return -3
+	case d <= -s.t1:
+		return -2
+	case d < -s.near:
+		return -1
+	case d <= s.near:
+		return 0
+	case d < s.t1:
+		return 1
+	case d < s.t2:
+		return 2
+	case d < s.t3:
+		return 3
+	}
+	return 4
+}
+
+func (s *jpeglsScan) clamp(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > s.maxVal {
+		return s.maxVal
+	}
+	return v
+}
+
+// reconstruct applies a decoded (sign-corrected) error to a prediction,
+// undoing the modular reduction of the error (T.87 A.4.5 and A.5.3).
+func (s *jpeglsScan) reconstruct(px, errVal int) int {
+	v := px + errVal*(2*s.near+1)
+	if v < -s.near {
+		v += s.rangeVal * (2*s.near + 1)
+	} else if v > s.maxVal+s.near {
+		v -= s.rangeVal * (2*s.near + 1)
+	}
+	return s.clamp(v)
+}
+
+// decodeValue reads a limited-length Golomb code (T.87 A.5.3).
+func (s *jpeglsScan) decodeValue(k, limit int) (int, error) {
+	high := 0
+	for s.r.readBit() == 0 {
+		high++
+		if high > limit {
+			return 0, fmt.Errorf("JPEG-LS Golomb code exceeds its length limit")
+		}
+	}
+
+	if high >= limit-(s.qbpp+1) {
+		return s.r.readValue(s.qbpp) + 1, nil
+	}
+	if k == 0 {
+		return high, nil
+	}
+	return (high << uint(k)) + s.r.readValue(k), nil
+}
+
+// decodeRegular decodes one sample in regular mode, given its quantized
+// gradients and neighbours.
+func (s *jpeglsScan) decodeRegular(q1, q2, q3, ra, rb, rc int) (int, error) {
+	sign := 1
+	if q1 < 0 || (q1 == 0 && q2 < 0) || (q1 == 0 && q2 == 0 && q3 < 0) {
+		q1, q2, q3 = -q1, -q2, -q3
+		sign = -1
+	}
+	ctx := &s.contexts[81*q1+9*q2+q3]
+
+	// Median edge detector
+	var px int
+	switch {
+	case rc >= maxInt(ra, rb):
+		px = minInt(ra, rb)
+	case rc <= minInt(ra, rb):
+		px = maxInt(ra, rb)
+	default:
+		px = ra + rb - rc
+	}
+	px = s.clamp(px + sign*ctx.C)
+
+	k := 0
+	for ctx.N<<uint(k) < ctx.A {
+		k++
+	}
+
+	mErrVal, err := s.decodeValue(k, s.limit)
+	if err != nil {
+		return 0, err
+	}
+
+	errVal := mErrVal >> 1
+	if mErrVal&1 == 1 {
+		errVal = -errVal - 1
+	}
+	if k == 0 && s.near == 0 && 2*ctx.B+ctx.N-1 < 0 {
+		errVal = ^errVal
+	}
+
+	// Context update (T.87 A.6)
+	ctx.B += errVal * (2*s.near + 1)
+	ctx.A += absInt(errVal)
+	if ctx.N == s.reset {
+		ctx.A >>= 1
+		ctx.B >>= 1
+		ctx.N >>= 1
+	}
+	ctx.N++
+	if ctx.B <= -ctx.N {
+		ctx.B += ctx.N
+		if ctx.B <= -ctx.N {
+			ctx.B = -ctx.N + 1
+		}
+		if ctx.C > -128 {
+			ctx.C--
+		}
+	} else if ctx.B > 0 {
+		ctx.B -= ctx.N
+		if ctx.B > 0 {
+			ctx.B = 0
+		}
+		if ctx.C < 127 {
+			ctx.C++
+		}
+	}
+
+	return s.reconstruct(px, sign*errVal), nil
+}
+
+// decodeRunInterruptionError decodes the error of the sample that ends a run
+// (T.87 A.7.2).
+func (s *jpeglsScan) decodeRunInterruptionError(ctx *jpeglsRunContext, runIndex int) (int, error) {
+	temp := ctx.A + (ctx.N>>1)*ctx.RIType
+	k := 0
+	for ctx.N<<uint(k) < temp {
+		k++
+	}
+
+	emErrVal, err := s.decodeValue(k, s.limit-jpeglsJ[runIndex]-1)
+	if err != nil {
+		return 0, err
+	}
+
+	t := emErrVal + ctx.RIType
+	mapped := t & 1
+	errVal := (t + mapped) / 2
+	if (k != 0 || 2*ctx.Nn >= ctx.N) == (mapped == 1) {
+		errVal = -errVal
+	}
+
+	if errVal < 0 {
+		ctx.Nn++
+	}
+	ctx.A += (emErrVal + 1 - ctx.RIType) >> 1
+	if ctx.N == s.reset {
+		ctx.A >>= 1
+		ctx.N >>= 1
+		ctx.Nn >>= 1
+	}
+	ctx.N++
+
+	return errVal, nil
+}
+
+// decodeRunLength reads the length of a run of samples equal to their left
+// neighbour, of at most remaining samples (T.87 A.7.1).
+func (s *jpeglsScan) decodeRunLength(runIndex *int, remaining int) (int, error) {
+	index := 0
+	for s.r.readBit() == 1 {
+		count := minInt(1<<uint(jpeglsJ[*runIndex]), remaining-index)
+		index += count
+		if count == 1<<uint(jpeglsJ[*runIndex]) && *runIndex < 31 {
+			*runIndex++
+		}
+		if index == remaining {
+			break
+		}
+	}
+
+	if index != remaining && jpeglsJ[*runIndex] > 0 {
+		index += s.r.readValue(jpeglsJ[*runIndex])
+	}
+
+	if index > remaining {
+		return 0, fmt.Errorf("JPEG-LS run extends past the end of the line")
+	}
+
+	return index, nil
+}
+
+// decodeLine decodes one line of one or more components. prev and cur hold one
+// line buffer per component, each with one extra sample on either side. For
+// interleave mode 2 the components are decoded pixel by pixel; otherwise each
+// component's line is decoded in turn, each with its own run index.
+func (s *jpeglsScan) decodeLine(prev, cur [][]int, runIndex []int, sampleInterleaved bool) error {
+	cols := len(cur[0]) - 2
+
+	for c := range cur {
+		prev[c][cols+1] = prev[c][cols]
+		cur[c][0] = prev[c][1]
+	}
+
+	if !sampleInterleaved {
+		for c := range cur {
+			if err := s.decodeComponentLine(prev[c], cur[c], &runIndex[c]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return s.decodeInterleavedLine(prev, cur, &runIndex[0])
+}
+
+func (s *jpeglsScan) decodeComponentLine(prev, cur []int, runIndex *int) error {
+	cols := len(cur) - 2
+
+	for x := 0; x < cols; {
+		ra, rb, rc, rd := cur[x], prev[x+1], prev[x], prev[x+2]
+		q1, q2, q3 := s.quantize(rd-rb), s.quantize(rb-rc), s.quantize(rc-ra)
+
+		if q1 != 0 || q2 != 0 || q3 != 0 {
+			v, err := s.decodeRegular(q1, q2, q3, ra, rb, rc)
+			if err != nil {
+				return err
+			}
+			cur[x+1] = v
+			x++
+			continue
+		}
+
+		// Run mode
+		n, err := s.decodeRunLength(runIndex, cols-x)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			cur[x+1+i] = ra
+		}
+		x += n
+		if x == cols {
+			break
+		}
+
+		// Run interruption sample
+		rb = prev[x+1]
+		var v int
+		if absInt(ra-rb) <= s.near {
+			e, err := s.decodeRunInterruptionError(&s.runContexts[1], *runIndex)
+			if err != nil {
+				return err
+			}
+			v = s.reconstruct(ra, e)
+		} else {
+			e, err := s.decodeRunInterruptionError(&s.runContexts[0], *runIndex)
+			if err != nil {
+				return err
+			}
+			v = s.reconstruct(rb, e*signInt(rb-ra))
+		}
+		cur[x+1] = v
+		x++
+		if *runIndex > 0 {
+			*runIndex--
+		}
+	}
+
+	return nil
+}
+
+func (s *jpeglsScan) decodeInterleavedLine(prev, cur [][]int, runIndex *int) error {
+	cols := len(cur[0]) - 2
+	nc := len(cur)
+	q := make([][3]int, nc)
+
+	for x := 0; x < cols; {
+		allZero := true
+		for c := 0; c < nc; c++ {
+			ra, rb, rc, rd := cur[c][x], prev[c][x+1], prev[c][x], prev[c][x+2]
+			q[c] = [3]int{s.quantize(rd - rb), s.quantize(rb - rc), s.quantize(rc - ra)}
+			if q[c] != [3]int{} {
+				allZero = false
+			}
+		}
+
+		if !allZero {
+			for c := 0; c < nc; c++ {
+				v, err := s.decodeRegular(q[c][0], q[c][1], q[c][2], cur[c][x], prev[c][x+1], prev[c][x])
+				if err != nil {
+					return err
+				}
+				cur[c][x+1] = v
+			}
+			x++
+			continue
+		}
+
+		// Run mode, which applies to all components at once
+		n, err := s.decodeRunLength(runIndex, cols-x)
+		if err != nil {
+			return err
+		}
+		for c := 0; c < nc; c++ {
+			ra := cur[c][x]
+			for i := 0; i < n; i++ {
+				cur[c][x+1+i] = ra
+			}
+		}
+		x += n
+		if x == cols {
+			break
+		}
+
+		// Run interruption pixel
+		for c := 0; c < nc; c++ {
+			ra, rb := cur[c][x], prev[c][x+1]
+			e, err := s.decodeRunInterruptionError(&s.runContexts[0], *runIndex)
+			if err != nil {
+				return err
+			}
+			cur[c][x+1] = s.reconstruct(rb, e*signInt(rb-ra))
+		}
+		x++
+		if *runIndex > 0 {
+			*runIndex--
+		}
+	}
+
+	return nil
+}
+
+// defaultJPEGLSPresets computes the default coding parameters (T.87 C.2.4.1.1)
+// and overrides them with any non-zero values from an LSE segment.
+func defaultJPEGLSPresets(precision, near int, custom jpeglsPresets) jpeglsPresets {
+	p := jpeglsPresets{MaxVal: (1 << uint(precision)) - 1, Reset: 64}
+	if custom.MaxVal > 0 {
+		p.MaxVal = custom.MaxVal
+	}
+	if custom.Reset > 0 {
+		p.Reset = custom.Reset
+	}
+
+	clampThreshold := func(i, j, maxVal int) int {
+		if i > maxVal || i < j {
+			return j
+		}
+		return i
+	}
+
+	const basicT1, basicT2, basicT3 = 3, 7, 21
+	if p.MaxVal >= 128 {
+		factor := (minInt(p.MaxVal, 4095) + 128) / 256
+		p.T1 = clampThreshold(factor*(basicT1-2)+2+3*near, near+1, p.MaxVal)
+		p.T2 = clampThreshold(factor*(basicT2-3)+3+5*near, p.T1, p.MaxVal)
+		p.T3 = clampThreshold(factor*(basicT3-4)+4+7*near, p.T2, p.MaxVal)
+	} else {
+		factor := 256 / (p.MaxVal + 1)
+		p.T1 = clampThreshold(maxInt(2, basicT1/factor+3*near), near+1, p.MaxVal)
+		p.T2 = clampThreshold(maxInt(3, basicT2/factor+5*near), p.T1, p.MaxVal)
+		p.T3 = clampThreshold(maxInt(4, basicT3/factor+7*near), p.T2, p.MaxVal)
+	}
+
+	if custom.T1 > 0 {
+		p.T1 = custom.T1
+	}
+	if custom.T2 > 0 {
+		p.T2 = custom.T2
+	}
+	if custom.T3 > 0 {
+		p.T3 = custom.T3
+	}
+
+	return p
+}
+
+// decodeJPEGLS decodes a JPEG-LS stream into interleaved samples.
+func decodeJPEGLS(data []byte) (*decodedFrame, error) {
+	segments, err := splitJPEGSegments(data, func(next byte) bool { return next < 0x80 })
+	if err != nil {
+		return nil, err
+	}
+
+	var precision, rows, cols int
+	var componentIDs []byte
+	var restartInterval int
+	var custom jpeglsPresets
+	var planes [][]int
+
+	for _, seg := range segments {
+		p := seg.Payload
+
+		switch seg.Marker {
+		case jpegMarkerSOF55:
+			if len(p) < 6 {
+				return nil, fmt.Errorf("Truncated JPEG-LS SOF segment")
+			}
+			precision = int(p[0])
+			rows = int(binary.BigEndian.Uint16(p[1:]))
+			cols = int(binary.BigEndian.Uint16(p[3:]))
+			nComponents := int(p[5])
+			if len(p) < 6+3*nComponents {
+				return nil, fmt.Errorf("Truncated JPEG-LS SOF segment")
+			}
+			if precision < 2 || precision > 16 {
+				return nil, fmt.Errorf("Unsupported JPEG-LS precision %d", precision)
+			}
+			for i := 0; i < nComponents; i++ {
+				if p[6+3*i+1] != 0x11 {
+					return nil, fmt.Errorf("Subsampled JPEG-LS components are not supported")
+				}
+				componentIDs = append(componentIDs, p[6+3*i])
+			}
+			planes = make([][]int, nComponents)
+			for i := range planes {
+				planes[i] = make([]int, rows*cols)
+			}
+
+		case jpegMarkerLSE:
+			if len(p) < 1 {
+				return nil, fmt.Errorf("Truncated JPEG-LS LSE segment")
+			}
+			if p[0] != 1 {
+				return nil, fmt.Errorf("JPEG-LS mapping tables (LSE type %d) are not supported", p[0])
+			}
+			if len(p) < 11 {
+				return nil, fmt.Errorf("Truncated JPEG-LS LSE segment")
+			}
+			custom = jpeglsPresets{
+				MaxVal: int(binary.BigEndian.Uint16(p[1:])),
+				T1:     int(binary.BigEndian.Uint16(p[3:])),
+				T2:     int(binary.BigEndian.Uint16(p[5:])),
+				T3:     int(binary.BigEndian.Uint16(p[7:])),
+				Reset:  int(binary.BigEndian.Uint16(p[9:])),
+			}
+
+		case jpegMarkerDRI:
+			if len(p) < 2 {
+				return nil, fmt.Errorf("Truncated DRI segment")
+			}
+			restartInterval = int(binary.BigEndian.Uint16(p))
+
+		case jpegMarkerSOS:
+			if planes == nil {
+				return nil, fmt.Errorf("SOS found before the JPEG-LS SOF segment")
+			}
+			if err := decodeJPEGLSScan(seg, precision, rows, cols, componentIDs, custom, restartInterval, planes); err != nil {
+				return nil, err
+			}
+
+		default:
+			if seg.Marker >= 0xC0 && seg.Marker <= 0xCF && seg.Marker != jpegMarkerDHT && seg.Marker != 0xC8 && seg.Marker != 0xCC {
+				return nil, fmt.Errorf("JPEG frame type 0x%X is not JPEG-LS", seg.Marker)
+			}
+		}
+	}
+
+	if planes == nil {
+		return nil, fmt.Errorf("No JPEG-LS frame header found")
+	}
+
+	return &decodedFrame{
+		Rows:            rows,
+		Cols:            cols,
+		SamplesPerPixel: len(planes),
+		Samples:         interleavePlanes(planes),
+	}, nil
+}
+
+func decodeJPEGLSScan(seg jpegSegment, precision, rows, cols int, componentIDs []byte, custom jpeglsPresets, restartInterval int, planes [][]int) error {
+	p := seg.Payload
+	if len(p) < 1 || len(p) < 1+2*int(p[0])+3 {
+		return fmt.Errorf("Truncated JPEG-LS SOS segment")
+	}
+	nScan := int(p[0])
+	scanPlanes := make([][]int, nScan)
+	for i := 0; i < nScan; i++ {
+		id := p[1+2*i]
+		for j, cid := range componentIDs {
+			if cid == id {
+				scanPlanes[i] = planes[j]
+			}
+		}
+		if scanPlanes[i] == nil {
+			return fmt.Errorf("JPEG-LS SOS references unknown component %d", id)
+		}
+		if p[2+2*i] != 0 {
+			return fmt.Errorf("JPEG-LS mapping tables are not supported")
+		}
+	}
+	near := int(p[1+2*nScan])
+	interleave := int(p[2+2*nScan])
+	pointTransform := uint(p[3+2*nScan] & 0x0F)
+
+	if interleave > 2 || (interleave == 0 && nScan != 1) {
+		return fmt.Errorf("Invalid JPEG-LS interleave mode %d for %d components", interleave, nScan)
+	}
+
+	s := newJPEGLSScan(seg.Entropy, defaultJPEGLSPresets(precision, near, custom), near)
+
+	prev := make([][]int, nScan)
+	cur := make([][]int, nScan)
+	for c := range prev {
+		prev[c] = make([]int, cols+2)
+		cur[c] = make([]int, cols+2)
+	}
+	runIndex := make([]int, nScan)
+
+	for y := 0; y < rows; y++ {
+		if restartInterval > 0 && y > 0 && y%restartInterval == 0 {
+			if err := s.r.restart(); err != nil {
+				return err
+			}
+			s.resetContexts()
+			for c := range prev {
+				runIndex[c] = 0
+				for i := range prev[c] {
+					prev[c][i] = 0
+				}
+			}
+		}
+
+		if err := s.decodeLine(prev, cur, runIndex, interleave == 2); err != nil {
+			return fmt.Errorf("JPEG-LS line %d: %v", y, err)
+		}
+
+		for c, plane := range scanPlanes {
+			for x := 0; x < cols; x++ {
+				plane[y*cols+x] = cur[c][x+1] << pointTransform
+			}
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return nil
+}
+
+func ceilLog2(n int) int {
+	k := 0
+	for (1 << uint(k)) < n {
+		k++
+	}
+	return k
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func signInt(a int) int {
+	if a < 0 {
+		return -1
+	}
+	return 1
+}
@@ -3,7 +3,6 @@ package bulkprocess
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"strconv"
 
@@ -44,7 +43,7 @@ type DicomMeta struct {
 
 // Takes in a dicom file (in bytes), emit meta-information
 func DicomToMetadata(dicomReader io.Reader) (*DicomMeta, error) {
-	dcm, err := ioutil.ReadAll(dicomReader)
+	dcm, err := readAllDicom(dicomReader)
 	if err != nil {
 		return nil, err
 	}
@@ -5,7 +5,6 @@ import (
 	"image"
 	"image/color"
 	"io"
-	"io/ioutil"
 	"log"
 
 	"github.com/suyashkumar/dicom"
@@ -36,7 +35,7 @@ func (d DicomOverlayOpts) Subset() bool {
 // DicomToOverlayImage takes in a dicom file (as a reader), a blank image, and
 // options, and updates the image according to those options.
 func DicomToOverlayImage(dicomReader io.Reader, opts DicomOverlayOpts) ([]image.Image, error) {
-	dcm, err := ioutil.ReadAll(dicomReader)
+	dcm, err := readAllDicom(dicomReader)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,101 @@
+package bulkprocess
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// decodeRLE decompresses one frame of RLE Lossless pixel data. See DICOM PS3.5
+// Annex G. Each frame begins with a 64-byte header listing up to 15 segments.
+// Each segment is PackBits-encoded and holds one byte of one sample for every
+// pixel: for each sample, the most significant byte comes first.
+func decodeRLE(data []byte, rows, cols, samplesPerPixel, bitsAllocated int) (*decodedFrame, error) {
+	if len(data) < 64 {
+		return nil, fmt.Errorf("RLE frame is %d bytes, which is too short to contain its header", len(data))
+	}
+
+	if bitsAllocated%8 != 0 || bitsAllocated == 0 {
+		return nil, fmt.Errorf("RLE decoding of %d-bit samples is not supported", bitsAllocated)
+	}
+
+	bytesPerSample := bitsAllocated / 8
+	nSegments := int(binary.LittleEndian.Uint32(data))
+	if nSegments != bytesPerSample*samplesPerPixel {
+		return nil, fmt.Errorf("RLE frame has %d segments, expected %d", nSegments, bytesPerSample*samplesPerPixel)
+	}
+	if nSegments > 15 {
+		return nil, fmt.Errorf("RLE frame has %d segments, but at most 15 are permitted", nSegments)
+	}
+
+	offsets := make([]int, nSegments+1)
+	for i := 0; i < nSegments; i++ {
+		offsets[i] = int(binary.LittleEndian.Uint32(data[4+4*i:]))
+	}
+	offsets[nSegments] = len(data)
+
+	nPixels := rows * cols
+	out := &decodedFrame{
+		Rows:            rows,
+		Cols:            cols,
+		SamplesPerPixel: samplesPerPixel,
+		Samples:         make([]int, nPixels*samplesPerPixel),
+	}
+
+	for seg := 0; seg < nSegments; seg++ {
+		start, end := offsets[seg], offsets[seg+1]
+		if start < 64 || start > end || end > len(data) {
+			return nil, fmt.Errorf("RLE segment %d has invalid bounds [%d, %d)", seg, start, end)
+		}
+
+		decoded, err := unpackBits(data[start:end], nPixels)
+		if err != nil {
+			return nil, fmt.Errorf("RLE segment %d: %v", seg, err)
+		}
+
+		sample := seg / bytesPerSample
+		shift := uint(8 * (bytesPerSample - 1 - seg%bytesPerSample))
+		for px, b := range decoded {
+			out.Samples[px*samplesPerPixel+sample] |= int(b) << shift
+		}
+	}
+
+	return out, nil
+}
+
+// unpackBits decodes one PackBits segment, which should yield n bytes. Segments
+// are padded to an even length, so trailing input is ignored once we have
+// produced enough output.
+func unpackBits(segment []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+
+	for i := 0; i < len(segment) && len(out) < n; {
+		header := int8(segment[i])
+		i++
+
+		switch {
+		case header >= 0:
+			// Copy the next header+1 bytes literally
+			count := int(header) + 1
+			if i+count > len(segment) {
+				return nil, fmt.Errorf("literal run overflows the segment")
+			}
+			out = append(out, segment[i:i+count]...)
+			i += count
+		case header != -128:
+			// Replicate the next byte -header+1 times
+			if i >= len(segment) {
+				return nil, fmt.Errorf("replicate run overflows the segment")
+			}
+			for j := 0; j < int(-header)+1; j++ {
+				out = append(out, segment[i])
+			}
+			i++
+		}
+	}
+
+	if len(out) < n {
+		return nil, fmt.Errorf("decoded %d bytes, expected %d", len(out), n)
+	}
+
+	return out[:n], nil
+}
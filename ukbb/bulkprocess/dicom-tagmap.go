@@ -3,33 +3,21 @@ package bulkprocess
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 
 	"github.com/suyashkumar/dicom"
 	"github.com/suyashkumar/dicom/dicomtag"
+	"github.com/suyashkumar/dicom/element"
 )
 
 // DicomToTagMap takes in a dicom file (in bytes), emits a map of tags
 func DicomToTagMap(dicomReader io.Reader) (map[dicomtag.Tag][]interface{}, error) {
 	out := make(map[dicomtag.Tag][]interface{})
 
-	dcm, err := ioutil.ReadAll(dicomReader)
+	parsedData, err := DicomToDataSet(dicomReader)
 	if err != nil {
 		return nil, err
 	}
 
-	p, err := dicom.NewParserFromBytes(dcm, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	parsedData, err := SafelyDicomParse(p, dicom.ParseOptions{
-		DropPixelData: false,
-	})
-	if parsedData == nil || err != nil {
-		return nil, fmt.Errorf("Error reading dicom: %v", err)
-	}
-
 	for _, elem := range parsedData.Elements {
 		if elem == nil {
 			continue
@@ -43,3 +31,27 @@ func DicomToTagMap(dicomReader io.Reader) (map[dicomtag.Tag][]interface{}, error
 
 	return out, nil
 }
+
+// DicomToDataSet takes in a dicom file (in bytes) and emits the fully parsed
+// data set, including pixel data. Deflated dicoms are inflated first, but their
+// TransferSyntaxUID is left as-is.
+func DicomToDataSet(dicomReader io.Reader) (*element.DataSet, error) {
+	dcm, err := readAllDicom(dicomReader)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := dicom.NewParserFromBytes(dcm, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedData, err := SafelyDicomParse(p, dicom.ParseOptions{
+		DropPixelData: false,
+	})
+	if parsedData == nil || err != nil {
+		return nil, fmt.Errorf("Error reading dicom: %v", err)
+	}
+
+	return parsedData, nil
+}
@@ -0,0 +1,470 @@
+package bulkprocess
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/dicomtag"
+	"github.com/suyashkumar/dicom/element"
+)
+
+// DicomColumnType is the typed, columnar representation chosen for a selected
+// DICOM tag. The names match BigQuery's legacy type names.
+type DicomColumnType string
+
+const (
+	DicomColumnString  DicomColumnType = "STRING"
+	DicomColumnInteger DicomColumnType = "INTEGER"
+	DicomColumnFloat   DicomColumnType = "FLOAT"
+	DicomColumnDate    DicomColumnType = "DATE"
+)
+
+// Siemens stores its proprietary CSA headers in these private elements.
+var (
+	SiemensCSAImageHeaderTag  = dicomtag.Tag{Group: 0x0029, Element: 0x1010}
+	SiemensCSASeriesHeaderTag = dicomtag.Tag{Group: 0x0029, Element: 0x1020}
+)
+
+// DicomTagSelector describes one column to be extracted from each DICOM. It is
+// created from a text specification by ParseDicomTagSelector.
+type DicomTagSelector struct {
+	// Spec is the original text specification
+	Spec string
+
+	// Name is the output column name
+	Name string
+
+	// Path is the chain of tags to follow. All but the last must be sequences
+	// (SQ); the last is the tag whose values are extracted.
+	Path []dicomtag.Tag
+
+	// CSAElement, if set, means that the last tag in Path is a Siemens CSA
+	// header and that the values of the named CSA element are extracted.
+	CSAElement string
+
+	Type DicomColumnType
+
+	// Repeated is true when more than one value may be present, either
+	// because the tag's value multiplicity permits it or because the path
+	// traverses a sequence.
+	Repeated bool
+}
+
+// ParseDicomTagSelectors parses each specification with ParseDicomTagSelector
+// and ensures that the resulting column names are unique.
+func ParseDicomTagSelectors(specs []string) ([]DicomTagSelector, error) {
+	out := make([]DicomTagSelector, 0, len(specs))
+	seen := make(map[string]string)
+
+	for _, spec := range specs {
+		sel, err := ParseDicomTagSelector(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		if prior, exists := seen[strings.ToLower(sel.Name)]; exists {
+			return nil, fmt.Errorf("Tag selections %q and %q both produce the column name %q", prior, spec, sel.Name)
+		}
+		seen[strings.ToLower(sel.Name)] = spec
+
+		out = append(out, sel)
+	}
+
+	return out, nil
+}
+
+// ParseDicomTagSelector parses a tag specification of the form
+//
+//   [column=]path[:TYPE]
+//
+// The path is one or more tags separated by '>', each given either as a DICOM
+// keyword (e.g., SeriesDescription) or as a (gggg,eeee) pair. Every tag but the
+// last must be a sequence, e.g.,
+// SharedFunctionalGroupsSequence>MRTimingAndRelatedParametersSequence>RepetitionTime.
+// The last tag may instead name an element within a Siemens CSA header, as
+// CSAImage.ElementName, CSASeries.ElementName, or (gggg,eeee).ElementName.
+//
+// The column type is inferred from the tag's VR; CSA elements and tags that
+// are absent from the DICOM dictionary default to STRING. TYPE (one of STRING,
+// INTEGER, FLOAT, or DATE) overrides the inferred type.
+func ParseDicomTagSelector(spec string) (DicomTagSelector, error) {
+	out := DicomTagSelector{Spec: spec}
+
+	rest := strings.TrimSpace(spec)
+	if idx := strings.Index(rest, "="); idx >= 0 {
+		out.Name = strings.TrimSpace(rest[:idx])
+		rest = strings.TrimSpace(rest[idx+1:])
+	}
+
+	var typeOverride DicomColumnType
+	if idx := strings.LastIndex(rest, ":"); idx >= 0 {
+		typeOverride = DicomColumnType(strings.ToUpper(strings.TrimSpace(rest[idx+1:])))
+		rest = strings.TrimSpace(rest[:idx])
+
+		switch typeOverride {
+		case DicomColumnString, DicomColumnInteger, DicomColumnFloat, DicomColumnDate:
+		default:
+			return out, fmt.Errorf("Tag selection %q: unrecognized type %q", spec, typeOverride)
+		}
+	}
+
+	if rest == "" {
+		return out, fmt.Errorf("Tag selection %q: no tag given", spec)
+	}
+
+	parts := strings.Split(rest, ">")
+	nameParts := make([]string, 0, len(parts)+1)
+	var leafInfo dicomtag.TagInfo
+	leafKnown := false
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		isLeaf := i == len(parts)-1
+
+		if isLeaf {
+			if idx := strings.LastIndex(part, "."); idx >= 0 {
+				out.CSAElement = strings.TrimSpace(part[idx+1:])
+				part = strings.TrimSpace(part[:idx])
+				if out.CSAElement == "" {
+					return out, fmt.Errorf("Tag selection %q: no CSA element name given", spec)
+				}
+			}
+		}
+
+		var tag dicomtag.Tag
+		var info dicomtag.TagInfo
+		known := false
+		label := part
+
+		switch {
+		case out.CSAElement != "" && isLeaf && strings.EqualFold(part, "CSAImage"):
+			tag, label = SiemensCSAImageHeaderTag, "CSAImage"
+		case out.CSAElement != "" && isLeaf && strings.EqualFold(part, "CSASeries"):
+			tag, label = SiemensCSASeriesHeaderTag, "CSASeries"
+		case strings.Contains(part, ","):
+			parsed, err := parseDicomTagPair(part)
+			if err != nil {
+				return out, fmt.Errorf("Tag selection %q: %v", spec, err)
+			}
+			tag = parsed
+			label = fmt.Sprintf("x%04x_%04x", tag.Group, tag.Element)
+			if found, err := dicomtag.Find(tag); err == nil {
+				info, known = found, true
+				label = found.Name
+			}
+		default:
+			found, err := dicomtag.FindByName(part)
+			if err != nil {
+				return out, fmt.Errorf("Tag selection %q: %v", spec, err)
+			}
+			tag, info, known = found.Tag, found, true
+		}
+
+		if !isLeaf {
+			if !known || info.VR != "SQ" {
+				return out, fmt.Errorf("Tag selection %q: %s is not a sequence, so it cannot contain %s", spec, part, parts[i+1])
+			}
+			out.Repeated = true
+		} else {
+			leafInfo, leafKnown = info, known
+		}
+
+		out.Path = append(out.Path, tag)
+		nameParts = append(nameParts, label)
+	}
+
+	if out.CSAElement != "" {
+		nameParts = append(nameParts, out.CSAElement)
+	}
+
+	if out.Name == "" {
+		out.Name = strings.Join(nameParts, "_")
+	}
+	out.Name = sanitizeColumnName(out.Name)
+
+	switch {
+	case out.CSAElement != "":
+		// CSA elements carry their own VR and VM, which can only be known once
+		// a file has been read.
+		out.Type = DicomColumnString
+		out.Repeated = true
+	case leafKnown:
+		if leafInfo.VR == "SQ" {
+			return out, fmt.Errorf("Tag selection %q: %s is a sequence; select a tag within it", spec, leafInfo.Name)
+		}
+		out.Type = dicomColumnTypeForVR(leafInfo.VR)
+		if leafInfo.VM != "1" {
+			out.Repeated = true
+		}
+	default:
+		out.Type = DicomColumnString
+		out.Repeated = true
+	}
+
+	if typeOverride != "" {
+		out.Type = typeOverride
+	}
+
+	return out, nil
+}
+
+// ExtractDicomTags reads a DICOM and returns the values found for each
+// selector, in the same order as the selectors. Values are int64 for INTEGER
+// columns, float64 for FLOAT, time.Time for DATE, and string otherwise. Missing
+// tags yield an empty slice. So do tags with a value that cannot be converted
+// to the column's type, which is logged, so that the column is NULL rather
+// than the whole row being lost. For selectors that are not Repeated, only the
+// first value (if any) should be used.
+func ExtractDicomTags(dicomReader io.Reader, selectors []DicomTagSelector) ([][]interface{}, error) {
+	dcm, err := readAllDicom(dicomReader)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := dicom.NewParserFromBytes(dcm, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedData, err := SafelyDicomParse(p, dicom.ParseOptions{
+		DropPixelData: true,
+	})
+	if parsedData == nil || err != nil {
+		return nil, fmt.Errorf("Error reading dicom: %v", err)
+	}
+
+	out := make([][]interface{}, len(selectors))
+	for i, sel := range selectors {
+		elems := findDicomElements(parsedData.Elements, sel.Path)
+
+		var raw []interface{}
+		if sel.CSAElement != "" {
+			raw, err = siemensCSAValues(elems, sel.CSAElement)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", sel.Spec, err)
+			}
+		} else {
+			for _, elem := range elems {
+				raw = append(raw, elem.Value...)
+			}
+		}
+
+		vals := make([]interface{}, 0, len(raw))
+		for _, v := range raw {
+			converted, ok, err := convertDicomValue(v, sel.Type)
+			if err != nil {
+				log.Printf("%s: %v; leaving the column NULL\n", sel.Spec, err)
+				vals = nil
+				break
+			}
+			if !ok {
+				continue
+			}
+			vals = append(vals, converted)
+		}
+
+		out[i] = vals
+	}
+
+	return out, nil
+}
+
+// findDicomElements follows path through the (possibly nested) elements,
+// descending into every item of each intermediate sequence.
+func findDicomElements(elems []*element.Element, path []dicomtag.Tag) []*element.Element {
+	if len(path) == 0 {
+		return nil
+	}
+
+	var out []*element.Element
+	for _, elem := range elems {
+		if elem == nil || elem.Tag != path[0] {
+			continue
+		}
+
+		if len(path) == 1 {
+			out = append(out, elem)
+			continue
+		}
+
+		// Each value of a sequence is an item, whose values are its elements.
+		for _, v := range elem.Value {
+			item, ok := v.(*element.Element)
+			if !ok {
+				continue
+			}
+
+			children := make([]*element.Element, 0, len(item.Value))
+			for _, child := range item.Value {
+				if c, ok := child.(*element.Element); ok {
+					children = append(children, c)
+				}
+			}
+
+			out = append(out, findDicomElements(children, path[1:])...)
+		}
+	}
+
+	return out
+}
+
+func siemensCSAValues(elems []*element.Element, name string) ([]interface{}, error) {
+	var out []interface{}
+	for _, elem := range elems {
+		for _, v := range elem.Value {
+			if _, ok := v.([]uint8); !ok {
+				continue
+			}
+
+			sh, err := ParseSiemensHeader(v)
+			if err != nil {
+				return nil, err
+			}
+
+			chunk, exists := sh.Elements[name]
+			if !exists {
+				continue
+			}
+
+			for _, datum := range chunk.SubElementData {
+				out = append(out, datum)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// convertDicomValue converts one raw value, as produced by the dicom parser, to
+// the Go type used for the column. The boolean is false if the value is blank
+// and should be omitted.
+func convertDicomValue(v interface{}, colType DicomColumnType) (interface{}, bool, error) {
+	var str string
+
+	switch x := v.(type) {
+	case uint16:
+		return numericDicomValue(int64(x), float64(x), colType)
+	case uint32:
+		return numericDicomValue(int64(x), float64(x), colType)
+	case int16:
+		return numericDicomValue(int64(x), float64(x), colType)
+	case int32:
+		return numericDicomValue(int64(x), float64(x), colType)
+	case float32:
+		return numericDicomValue(int64(x), float64(x), colType)
+	case float64:
+		return numericDicomValue(int64(x), x, colType)
+	case dicomtag.Tag:
+		str = x.String()
+	case []byte:
+		str = string(x)
+	case string:
+		str = x
+	default:
+		str = fmt.Sprint(x)
+	}
+
+	// DICOM pads values with spaces or NULs to an even length
+	str = strings.TrimRight(strings.TrimSpace(str), "\x00")
+	if str == "" {
+		return nil, false, nil
+	}
+
+	switch colType {
+	case DicomColumnInteger:
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			// IS values are occasionally written with a decimal point
+			f, ferr := strconv.ParseFloat(str, 64)
+			if ferr != nil {
+				return nil, false, fmt.Errorf("Could not parse %q as an integer", str)
+			}
+			n = int64(f)
+		}
+		return n, true, nil
+	case DicomColumnFloat:
+		f, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("Could not parse %q as a float", str)
+		}
+		return f, true, nil
+	case DicomColumnDate:
+		// DA is YYYYMMDD; ACR-NEMA files sometimes used YYYY.MM.DD
+		for _, layout := range []string{"20060102", "2006.01.02", "2006-01-02"} {
+			if d, err := time.Parse(layout, str); err == nil {
+				return d, true, nil
+			}
+		}
+		return nil, false, fmt.Errorf("Could not parse %q as a date", str)
+	}
+
+	return str, true, nil
+}
+
+func numericDicomValue(n int64, f float64, colType DicomColumnType) (interface{}, bool, error) {
+	switch colType {
+	case DicomColumnInteger:
+		return n, true, nil
+	case DicomColumnFloat:
+		return f, true, nil
+	case DicomColumnDate:
+		return nil, false, fmt.Errorf("Cannot interpret numeric value %v as a date", f)
+	}
+
+	return strconv.FormatFloat(f, 'f', -1, 64), true, nil
+}
+
+func dicomColumnTypeForVR(vr string) DicomColumnType {
+	switch vr {
+	case "US", "UL", "SS", "SL", "IS", "SV", "UV":
+		return DicomColumnInteger
+	case "DS", "FL", "FD":
+		return DicomColumnFloat
+	case "DA":
+		return DicomColumnDate
+	}
+
+	return DicomColumnString
+}
+
+// parseDicomTagPair parses a tag written as (gggg,eeee) or gggg,eeee.
+func parseDicomTagPair(s string) (dicomtag.Tag, error) {
+	parts := strings.Split(strings.Trim(strings.TrimSpace(s), "()"), ",")
+	if len(parts) != 2 {
+		return dicomtag.Tag{}, fmt.Errorf("Could not parse %q as a (gggg,eeee) tag", s)
+	}
+
+	group, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 16, 16)
+	if err != nil {
+		return dicomtag.Tag{}, fmt.Errorf("Could not parse %q as a (gggg,eeee) tag", s)
+	}
+	elem, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 16, 16)
+	if err != nil {
+		return dicomtag.Tag{}, fmt.Errorf("Could not parse %q as a (gggg,eeee) tag", s)
+	}
+
+	return dicomtag.Tag{Group: uint16(group), Element: uint16(elem)}, nil
+}
+
+// sanitizeColumnName produces a name that is legal in both BigQuery and
+// Parquet: letters, digits, and underscores, not starting with a digit.
+func sanitizeColumnName(name string) string {
+	out := []rune(name)
+	for i, r := range out {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			out[i] = '_'
+		}
+	}
+
+	if len(out) == 0 || unicode.IsDigit(out[0]) {
+		out = append([]rune{'_'}, out...)
+	}
+
+	return string(out)
+}
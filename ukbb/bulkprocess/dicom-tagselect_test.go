@@ -0,0 +1,361 @@
+package bulkprocess
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suyashkumar/dicom/dicomtag"
+)
+
+func TestParseDicomTagSelector(t *testing.T) {
+	cases := []struct {
+		Spec     string
+		Name     string
+		Path     []dicomtag.Tag
+		CSA      string
+		Type     DicomColumnType
+		Repeated bool
+	}{
+		// Keywords
+		{"SeriesDescription", "SeriesDescription", []dicomtag.Tag{{Group: 0x0008, Element: 0x103e}}, "", DicomColumnString, false},
+		{"  Rows  ", "Rows", []dicomtag.Tag{{Group: 0x0028, Element: 0x0010}}, "", DicomColumnInteger, false},
+		{"PixelSpacing", "PixelSpacing", []dicomtag.Tag{{Group: 0x0028, Element: 0x0030}}, "", DicomColumnFloat, true},
+		{"StudyDate", "StudyDate", []dicomtag.Tag{{Group: 0x0008, Element: 0x0020}}, "", DicomColumnDate, false},
+
+		// (gggg,eeee) pairs are named after their keyword when known
+		{"(0028,0010)", "Rows", []dicomtag.Tag{{Group: 0x0028, Element: 0x0010}}, "", DicomColumnInteger, false},
+		{"0028,0011", "Columns", []dicomtag.Tag{{Group: 0x0028, Element: 0x0011}}, "", DicomColumnInteger, false},
+		{"(0008,103E)", "SeriesDescription", []dicomtag.Tag{{Group: 0x0008, Element: 0x103e}}, "", DicomColumnString, false},
+		{"( 0020 , 0011 )", "SeriesNumber", []dicomtag.Tag{{Group: 0x0020, Element: 0x0011}}, "", DicomColumnInteger, false},
+		{"(0019,100c)", "x0019_100c", []dicomtag.Tag{{Group: 0x0019, Element: 0x100c}}, "", DicomColumnString, true},
+
+		// Column names and type suffixes
+		{"series=SeriesNumber:STRING", "series", []dicomtag.Tag{{Group: 0x0020, Element: 0x0011}}, "", DicomColumnString, false},
+		{"Rows : float", "Rows", []dicomtag.Tag{{Group: 0x0028, Element: 0x0010}}, "", DicomColumnFloat, false},
+		{"StudyDate:STRING", "StudyDate", []dicomtag.Tag{{Group: 0x0008, Element: 0x0020}}, "", DicomColumnString, false},
+		{"(0019,100c):FLOAT", "x0019_100c", []dicomtag.Tag{{Group: 0x0019, Element: 0x100c}}, "", DicomColumnFloat, true},
+		{"lv-mass = Rows", "lv_mass", []dicomtag.Tag{{Group: 0x0028, Element: 0x0010}}, "", DicomColumnInteger, false},
+		{"2ch=SeriesDescription", "_2ch", []dicomtag.Tag{{Group: 0x0008, Element: 0x103e}}, "", DicomColumnString, false},
+
+		// Nested sequences
+		{"ReferencedStudySequence>(0008,1155)", "ReferencedStudySequence_ReferencedSOPInstanceUID", []dicomtag.Tag{{Group: 0x0008, Element: 0x1110}, {Group: 0x0008, Element: 0x1155}}, "", DicomColumnString, true},
+		{"SharedFunctionalGroupsSequence > MRTimingAndRelatedParametersSequence > RepetitionTime", "SharedFunctionalGroupsSequence_MRTimingAndRelatedParametersSequence_RepetitionTime", []dicomtag.Tag{{Group: 0x5200, Element: 0x9229}, {Group: 0x0018, Element: 0x9112}, {Group: 0x0018, Element: 0x0080}}, "", DicomColumnFloat, true},
+		{"tr=(5200,9229)>MRTimingAndRelatedParametersSequence>(0018,0080):STRING", "tr", []dicomtag.Tag{{Group: 0x5200, Element: 0x9229}, {Group: 0x0018, Element: 0x9112}, {Group: 0x0018, Element: 0x0080}}, "", DicomColumnString, true},
+
+		// CSA selectors
+		{"CSAImage.FlowVenc:FLOAT", "CSAImage_FlowVenc", []dicomtag.Tag{SiemensCSAImageHeaderTag}, "FlowVenc", DicomColumnFloat, true},
+		{"csaimage.B_value:integer", "CSAImage_B_value", []dicomtag.Tag{SiemensCSAImageHeaderTag}, "B_value", DicomColumnInteger, true},
+		{"CSASeries.MrPhoenixProtocol", "CSASeries_MrPhoenixProtocol", []dicomtag.Tag{SiemensCSASeriesHeaderTag}, "MrPhoenixProtocol", DicomColumnString, true},
+		{"(0029,1020).MrPhoenixProtocol", "x0029_1020_MrPhoenixProtocol", []dicomtag.Tag{SiemensCSASeriesHeaderTag}, "MrPhoenixProtocol", DicomColumnString, true},
+		{"venc=CSAImage.FlowVenc", "venc", []dicomtag.Tag{SiemensCSAImageHeaderTag}, "FlowVenc", DicomColumnString, true},
+	}
+
+	for _, c := range cases {
+		sel, err := ParseDicomTagSelector(c.Spec)
+		if err != nil {
+			t.Errorf("%s: %v", c.Spec, err)
+			continue
+		}
+
+		if sel.Spec != c.Spec || sel.Name != c.Name || sel.CSAElement != c.CSA || sel.Type != c.Type || sel.Repeated != c.Repeated || len(sel.Path) != len(c.Path) {
+			t.Errorf("%s: got %+v", c.Spec, sel)
+			continue
+		}
+
+		for i := range c.Path {
+			if sel.Path[i] != c.Path[i] {
+				t.Errorf("%s: path element %d was %v, expected %v", c.Spec, i, sel.Path[i], c.Path[i])
+			}
+		}
+	}
+}
+
+func TestParseDicomTagSelectorErrors(t *testing.T) {
+	cases := []struct {
+		Spec  string
+		Error string
+	}{
+		{"", "no tag given"},
+		{"name=", "no tag given"},
+		{":FLOAT", "no tag given"},
+		{"NotARealKeyword", "NotARealKeyword"},
+		{"Rows:BOOLEAN", "unrecognized type"},
+		{"Rows:", "unrecognized type"},
+		{"(zz,0010)", "(gggg,eeee)"},
+		{"(0028)", "(0028)"},
+		{"(0028,0010,0001)", "(gggg,eeee)"},
+		{"(10028,0010)", "(gggg,eeee)"},
+		{"SeriesDescription>Rows", "not a sequence"},
+		{"(0019,100c)>Rows", "not a sequence"},
+		{"NotARealKeyword>Rows", "NotARealKeyword"},
+		{"ReferencedStudySequence", "is a sequence"},
+		{"SharedFunctionalGroupsSequence>MRTimingAndRelatedParametersSequence", "is a sequence"},
+		{"CSAImage.", "no CSA element name"},
+		{"CSAImage", "CSAImage"},
+	}
+
+	for _, c := range cases {
+		_, err := ParseDicomTagSelector(c.Spec)
+		if err == nil {
+			t.Errorf("%q: expected an error", c.Spec)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.Error) {
+			t.Errorf("%q: error %q does not mention %q", c.Spec, err, c.Error)
+		}
+	}
+}
+
+func TestParseDicomTagSelectorsUniqueNames(t *testing.T) {
+	if _, err := ParseDicomTagSelectors([]string{"Rows", "Columns", "(0028,0030)"}); err != nil {
+		t.Errorf("Distinct columns: %v", err)
+	}
+
+	for _, specs := range [][]string{
+		{"Rows", "(0028,0010)"},
+		{"Rows", "rows=Columns"},
+		{"lv-mass=Rows", "lv_mass=Columns"},
+	} {
+		if _, err := ParseDicomTagSelectors(specs); err == nil {
+			t.Errorf("%q: expected an error for a repeated column name", specs)
+		}
+	}
+}
+
+func TestConvertDicomValue(t *testing.T) {
+	cases := []struct {
+		Value    interface{}
+		Type     DicomColumnType
+		Expected interface{}
+		OK       bool
+	}{
+		{"  CINE_segmented ", DicomColumnString, "CINE_segmented", true},
+		{"LAX\x00", DicomColumnString, "LAX", true},
+		{" ", DicomColumnString, nil, false},
+		{uint16(256), DicomColumnInteger, int64(256), true},
+		{uint16(256), DicomColumnString, "256", true},
+		{int32(-5), DicomColumnFloat, float64(-5), true},
+		{float32(1.5), DicomColumnString, "1.5", true},
+		{"12 ", DicomColumnInteger, int64(12), true},
+		{"12.0", DicomColumnInteger, int64(12), true},
+		{"1.875", DicomColumnFloat, 1.875, true},
+		{"20150601", DicomColumnDate, time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2015.06.01", DicomColumnDate, time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC), true},
+		{[]byte("abc"), DicomColumnString, "abc", true},
+		{dicomtag.Tag{Group: 0x0028, Element: 0x0010}, DicomColumnString, "(0028,0010)", true},
+	}
+
+	for _, c := range cases {
+		got, ok, err := convertDicomValue(c.Value, c.Type)
+		if err != nil {
+			t.Errorf("%#v as %s: %v", c.Value, c.Type, err)
+			continue
+		}
+		if ok != c.OK {
+			t.Errorf("%#v as %s: ok was %v, expected %v", c.Value, c.Type, ok, c.OK)
+			continue
+		}
+		if d, isDate := c.Expected.(time.Time); isDate {
+			if got, isDate := got.(time.Time); !isDate || !got.Equal(d) {
+				t.Errorf("%#v as %s: got %#v, expected %v", c.Value, c.Type, got, d)
+			}
+			continue
+		}
+		if got != c.Expected {
+			t.Errorf("%#v as %s: got %#v, expected %#v", c.Value, c.Type, got, c.Expected)
+		}
+	}
+
+	for _, bad := range []struct {
+		Value interface{}
+		Type  DicomColumnType
+	}{
+		{"abc", DicomColumnInteger},
+		{"abc", DicomColumnFloat},
+		{"2015-13-45", DicomColumnDate},
+		{uint16(5), DicomColumnDate},
+	} {
+		if _, _, err := convertDicomValue(bad.Value, bad.Type); err == nil {
+			t.Errorf("%#v as %s: expected an error", bad.Value, bad.Type)
+		}
+	}
+}
+
+// testSequence writes an undefined-length sequence whose items each hold the
+// explicit VR little endian elements written by the corresponding function.
+func testSequence(buf *bytes.Buffer, group, elem uint16, items ...func(*bytes.Buffer)) {
+	binary.Write(buf, binary.LittleEndian, []uint16{group, elem})
+	buf.WriteString("SQ")
+	buf.Write([]byte{0, 0})
+	binary.Write(buf, binary.LittleEndian, uint32(0xFFFFFFFF))
+
+	for _, item := range items {
+		binary.Write(buf, binary.LittleEndian, []uint16{0xFFFE, 0xE000})
+		binary.Write(buf, binary.LittleEndian, uint32(0xFFFFFFFF))
+		item(buf)
+		binary.Write(buf, binary.LittleEndian, []uint16{0xFFFE, 0xE00D})
+		binary.Write(buf, binary.LittleEndian, uint32(0))
+	}
+
+	binary.Write(buf, binary.LittleEndian, []uint16{0xFFFE, 0xE0DD})
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+}
+
+// testSiemensCSA builds a CSA header holding one element per name, each with
+// the given values as its subelements.
+func testSiemensCSA(elements map[string][]string, order []string) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("SV10")
+	buf.Write([]byte{4, 3, 2, 1})
+	binary.Write(buf, binary.LittleEndian, uint32(len(order)))
+	buf.Write(SiemensDelimiter)
+
+	for _, name := range order {
+		values := elements[name]
+
+		nameField := make([]byte, 64)
+		copy(nameField, name)
+		buf.Write(nameField)
+		binary.Write(buf, binary.LittleEndian, uint32(len(values))) // VM
+		buf.Write([]byte{'D', 'S', 0, 0})                           // VR
+		binary.Write(buf, binary.LittleEndian, uint32(3))           // syngo DT
+		binary.Write(buf, binary.LittleEndian, uint32(len(values))) // subelements
+		buf.Write(SiemensDelimiter2)
+
+		for _, v := range values {
+			data := append([]byte(v), 0)
+			n := uint32(len(data))
+			binary.Write(buf, binary.LittleEndian, []uint32{n, n, 0x4d, n})
+			buf.Write(data)
+			for pad := len(data) % 4; pad != 0 && pad < 4; pad++ {
+				buf.WriteByte(0)
+			}
+		}
+	}
+
+	// The parser stops once fewer than 64 bytes remain
+	buf.Write(make([]byte, 64))
+
+	return buf.Bytes()
+}
+
+// makeTestTagDicom builds an uncompressed DICOM with plain, multi-valued,
+// private, nested-sequence, and Siemens CSA tags.
+func makeTestTagDicom() []byte {
+	dataset := &bytes.Buffer{}
+	testDicomElement(dataset, 0x0008, 0x0020, "DA", testPadded("20150601", ' '))
+	testDicomElement(dataset, 0x0008, 0x103E, "LO", testPadded("CINE_segmented_LAX_4Ch", ' '))
+	testDicomElement(dataset, 0x0019, 0x100C, "DS", testPadded("12.5", ' '))
+	testDicomElement(dataset, 0x0020, 0x0011, "IS", testPadded("7", ' '))
+	testDicomElement(dataset, 0x0028, 0x0010, "US", testUS(208))
+	testDicomElement(dataset, 0x0028, 0x0030, "DS", testPadded(`1.5\1.875`, ' '))
+	testDicomElement(dataset, 0x0029, 0x0010, "LO", testPadded("SIEMENS CSA HEADER", ' '))
+	testDicomElement(dataset, 0x0029, 0x1010, "OB", testSiemensCSA(map[string][]string{
+		"FlowVenc": {"150"},
+		"B_value":  {},
+	}, []string{"FlowVenc", "B_value"}))
+
+	// Two frames, each with its own timing
+	timing := func(tr string) func(*bytes.Buffer) {
+		return func(item *bytes.Buffer) {
+			testSequence(item, 0x0018, 0x9112, func(inner *bytes.Buffer) {
+				testDicomElement(inner, 0x0018, 0x0080, "DS", testPadded(tr, ' '))
+			})
+		}
+	}
+	testSequence(dataset, 0x5200, 0x9229, timing("30.5"), timing("31"))
+
+	meta := &bytes.Buffer{}
+	testDicomElement(meta, 0x0002, 0x0001, "OB", []byte{0, 1})
+	testDicomElement(meta, 0x0002, 0x0010, "UI", testPadded(TransferSyntaxExplicitVRLittleEndian, 0))
+
+	out := &bytes.Buffer{}
+	out.Write(make([]byte, 128))
+	out.WriteString("DICM")
+	testDicomElement(out, 0x0002, 0x0000, "UL", []byte{byte(meta.Len()), byte(meta.Len() >> 8), 0, 0})
+	out.Write(meta.Bytes())
+	out.Write(dataset.Bytes())
+
+	return out.Bytes()
+}
+
+func TestExtractDicomTags(t *testing.T) {
+	cases := []struct {
+		Spec     string
+		Expected []interface{}
+	}{
+		{"SeriesDescription", []interface{}{"CINE_segmented_LAX_4Ch"}},
+		{"Rows", []interface{}{int64(208)}},
+		{"SeriesNumber", []interface{}{int64(7)}},
+		{"PixelSpacing", []interface{}{1.5, 1.875}},
+		{"StudyDate", []interface{}{time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)}},
+		{"study_date=(0008,0020):STRING", []interface{}{"20150601"}},
+		{"(0019,100c)", []interface{}{"12.5"}},
+		{"private=(0019,100c):FLOAT", []interface{}{12.5}},
+		{"SharedFunctionalGroupsSequence>MRTimingAndRelatedParametersSequence>RepetitionTime", []interface{}{30.5, 31.0}},
+		{"CSAImage.FlowVenc:FLOAT", []interface{}{150.0}},
+		{"CSAImage.B_value", []interface{}{}},
+		{"CSAImage.NotPresent", []interface{}{}},
+		{"CSASeries.MrPhoenixProtocol", []interface{}{}},
+		{"ReferencedStudySequence>ReferencedSOPInstanceUID", []interface{}{}},
+		{"Columns", []interface{}{}},
+	}
+
+	specs := make([]string, 0, len(cases))
+	for _, c := range cases {
+		specs = append(specs, c.Spec)
+	}
+
+	selectors, err := ParseDicomTagSelectors(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := ExtractDicomTags(bytes.NewReader(makeTestTagDicom()), selectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != len(cases) {
+		t.Fatalf("Got %d columns, expected %d", len(values), len(cases))
+	}
+
+	for i, c := range cases {
+		got := values[i]
+		if len(got) != len(c.Expected) {
+			t.Errorf("%s: got %v, expected %v", c.Spec, got, c.Expected)
+			continue
+		}
+
+		for j := range c.Expected {
+			if d, isDate := c.Expected[j].(time.Time); isDate {
+				if g, isDate := got[j].(time.Time); !isDate || !g.Equal(d) {
+					t.Errorf("%s: value %d was %#v, expected %v", c.Spec, j, got[j], d)
+				}
+				continue
+			}
+			if got[j] != c.Expected[j] {
+				t.Errorf("%s: value %d was %#v, expected %#v", c.Spec, j, got[j], c.Expected[j])
+			}
+		}
+	}
+
+	// A value that cannot be converted to the requested type leaves only its
+	// column NULL
+	bad, err := ParseDicomTagSelectors([]string{"SeriesDescription:INTEGER", "Rows"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err = ExtractDicomTags(bytes.NewReader(makeTestTagDicom()), bad)
+	if err != nil {
+		t.Fatalf("Reading SeriesDescription as an integer: %v", err)
+	}
+	if len(values[0]) != 0 {
+		t.Errorf("SeriesDescription as an integer was %v, expected no values", values[0])
+	}
+	if len(values[1]) != 1 {
+		t.Errorf("Rows was %v, expected one value alongside the unconvertible column", values[1])
+	}
+}
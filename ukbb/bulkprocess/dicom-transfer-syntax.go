@@ -0,0 +1,329 @@
+package bulkprocess
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/suyashkumar/dicom/element"
+)
+
+// Transfer syntaxes whose pixel data we know how to decode. The UK Biobank only
+// ships native (uncompressed) little endian data, but other imaging archives
+// routinely use the compressed syntaxes.
+const (
+	TransferSyntaxImplicitVRLittleEndian         = "1.2.840.10008.1.2"
+	TransferSyntaxExplicitVRLittleEndian         = "1.2.840.10008.1.2.1"
+	TransferSyntaxDeflatedExplicitVRLittleEndian = "1.2.840.10008.1.2.1.99"
+	TransferSyntaxExplicitVRBigEndian            = "1.2.840.10008.1.2.2"
+	TransferSyntaxJPEGBaseline                   = "1.2.840.10008.1.2.4.50"
+	TransferSyntaxJPEGExtended                   = "1.2.840.10008.1.2.4.51"
+	TransferSyntaxJPEGLossless                   = "1.2.840.10008.1.2.4.57"
+	TransferSyntaxJPEGLosslessSV1                = "1.2.840.10008.1.2.4.70"
+	TransferSyntaxJPEGLSLossless                 = "1.2.840.10008.1.2.4.80"
+	TransferSyntaxJPEGLSNearLossless             = "1.2.840.10008.1.2.4.81"
+	TransferSyntaxRLELossless                    = "1.2.840.10008.1.2.5"
+)
+
+// dicomPreambleLength is the length of the preamble plus the "DICM" magic word
+// that precede the file meta information group.
+const dicomPreambleLength = 128 + 4
+
+// maybeInflateDicom checks the file meta information of the DICOM in r. If the
+// transfer syntax is Deflated Explicit VR Little Endian, the data set following
+// the meta information is inflated, and a reader over the uncompressed DICOM is
+// returned along with its new length. Otherwise, a reader equivalent to the
+// original is returned. The dicom library treats the deflated syntax as
+// explicit little endian, so once inflated it can be parsed as usual.
+func maybeInflateDicom(r io.Reader, nReaderBytes int64) (io.Reader, int64, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	// Peek at the preamble and the (0002,0000) group length element, which the
+	// dicom library requires to be present.
+	header, err := br.Peek(dicomPreambleLength + 12)
+	if err != nil {
+		// Too short to be a deflated DICOM; let the parser produce the error.
+		return br, nReaderBytes, nil
+	}
+	if string(header[128:132]) != "DICM" {
+		return br, nReaderBytes, nil
+	}
+	metaLength := int(binary.LittleEndian.Uint32(header[dicomPreambleLength+8:]))
+	metaEnd := dicomPreambleLength + 12 + metaLength
+
+	meta, err := br.Peek(metaEnd)
+	if err != nil {
+		return br, nReaderBytes, nil
+	}
+
+	transferSyntax, err := fileMetaTransferSyntax(meta[dicomPreambleLength:])
+	if err != nil || transferSyntax != TransferSyntaxDeflatedExplicitVRLittleEndian {
+		return br, nReaderBytes, nil
+	}
+
+	// Copy the meta information before consuming the rest of the reader, since
+	// the peeked slice is only valid until the next read.
+	out := &bytes.Buffer{}
+	out.Write(meta)
+	if _, err := br.Discard(metaEnd); err != nil {
+		return nil, 0, err
+	}
+
+	// The data set is a raw deflate stream (RFC 1951) without zlib framing.
+	inflater := flate.NewReader(br)
+	defer inflater.Close()
+	if _, err := io.Copy(out, inflater); err != nil {
+		return nil, 0, fmt.Errorf("Error inflating deflated DICOM: %v", err)
+	}
+
+	return out, int64(out.Len()), nil
+}
+
+// fileMetaTransferSyntax walks the explicit VR little endian elements of the
+// file meta information group and returns the TransferSyntaxUID (0002,0010).
+func fileMetaTransferSyntax(meta []byte) (string, error) {
+	for pos := 0; pos+8 <= len(meta); {
+		group := binary.LittleEndian.Uint16(meta[pos:])
+		elem := binary.LittleEndian.Uint16(meta[pos+2:])
+		vr := string(meta[pos+4 : pos+6])
+
+		if group != 0x0002 {
+			break
+		}
+
+		var length, headerLength int
+		switch vr {
+		case "OB", "OW", "OF", "SQ", "UT", "UN":
+			if pos+12 > len(meta) {
+				return "", fmt.Errorf("Truncated file meta information")
+			}
+			length = int(binary.LittleEndian.Uint32(meta[pos+8:]))
+			headerLength = 12
+		default:
+			length = int(binary.LittleEndian.Uint16(meta[pos+6:]))
+			headerLength = 8
+		}
+
+		start := pos + headerLength
+		if start+length > len(meta) {
+			return "", fmt.Errorf("Truncated file meta information")
+		}
+
+		if elem == 0x0010 {
+			return strings.TrimRight(string(meta[start:start+length]), "\x00 "), nil
+		}
+
+		pos = start + length
+	}
+
+	return "", fmt.Errorf("TransferSyntaxUID not found in file meta information")
+}
+
+// decodedFrame holds the samples of one decompressed frame, interleaved by
+// pixel (e.g., RGBRGB...), in row-major order.
+type decodedFrame struct {
+	Rows            int
+	Cols            int
+	SamplesPerPixel int
+	Samples         []int
+
+	// ColorConverted is set when the codec has already converted the samples
+	// to RGB (as image/jpeg does for YCbCr data), in which case the
+	// PhotometricInterpretation in the DICOM header no longer applies.
+	ColorConverted bool
+}
+
+// pixelDataToFrames converts the PixelData element into one decodedFrame per
+// frame, decompressing encapsulated data as needed.
+func pixelDataToFrames(data element.PixelDataInfo, transferSyntax string, rows, cols, samplesPerPixel, planarConfiguration, bitsAllocated, nFrames int) ([]*decodedFrame, error) {
+	if !data.IsEncapsulated {
+		out := make([]*decodedFrame, 0, len(data.Frames))
+		for _, frame := range data.Frames {
+			decoded := &decodedFrame{
+				Rows:            frame.NativeData.Rows,
+				Cols:            frame.NativeData.Cols,
+				SamplesPerPixel: samplesPerPixel,
+				Samples:         make([]int, 0, len(frame.NativeData.Data)*samplesPerPixel),
+			}
+			for _, pixel := range frame.NativeData.Data {
+				decoded.Samples = append(decoded.Samples, pixel...)
+			}
+
+			// The dicom library assumes that samples are interleaved by pixel.
+			// With a planar configuration of 1, the samples were actually
+			// stored one color plane at a time.
+			if samplesPerPixel > 1 && planarConfiguration == 1 {
+				nPixels := len(decoded.Samples) / samplesPerPixel
+				planes := make([][]int, samplesPerPixel)
+				for c := range planes {
+					planes[c] = decoded.Samples[c*nPixels : (c+1)*nPixels]
+				}
+				decoded.Samples = interleavePlanes(planes)
+			}
+
+			out = append(out, decoded)
+		}
+
+		if len(out) == 0 {
+			return nil, fmt.Errorf("Pixel data contains no frames")
+		}
+
+		return out, nil
+	}
+
+	frameData, err := encapsulatedFrameData(data, nFrames)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*decodedFrame, 0, len(frameData))
+	for i, fd := range frameData {
+		decoded, err := decodeEncapsulatedFrame(transferSyntax, fd, rows, cols, samplesPerPixel, bitsAllocated)
+		if err != nil {
+			return nil, fmt.Errorf("Frame %d: %v", i, err)
+		}
+		if decoded.Rows != rows || decoded.Cols != cols {
+			return nil, fmt.Errorf("Frame %d decoded to %dx%d but the header specifies %dx%d", i, decoded.Cols, decoded.Rows, cols, rows)
+		}
+		out = append(out, decoded)
+	}
+
+	return out, nil
+}
+
+// decodeEncapsulatedFrame decompresses one frame of encapsulated pixel data
+// according to its transfer syntax.
+func decodeEncapsulatedFrame(transferSyntax string, data []byte, rows, cols, samplesPerPixel, bitsAllocated int) (*decodedFrame, error) {
+	switch transferSyntax {
+	case TransferSyntaxJPEGBaseline, TransferSyntaxJPEGExtended:
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding JPEG frame (only 8-bit JPEG is supported): %v", err)
+		}
+		return decodedFrameFromImage(img), nil
+	case TransferSyntaxJPEGLossless, TransferSyntaxJPEGLosslessSV1:
+		return decodeJPEGLossless(data)
+	case TransferSyntaxJPEGLSLossless, TransferSyntaxJPEGLSNearLossless:
+		return decodeJPEGLS(data)
+	case TransferSyntaxRLELossless:
+		return decodeRLE(data, rows, cols, samplesPerPixel, bitsAllocated)
+	}
+
+	return nil, fmt.Errorf("Unsupported transfer syntax %s for encapsulated pixel data", transferSyntax)
+}
+
+// decodedFrameFromImage converts the output of a Go image decoder into
+// interleaved samples. Grayscale images yield one sample per pixel and all
+// others yield RGB.
+func decodedFrameFromImage(img image.Image) *decodedFrame {
+	bounds := img.Bounds()
+	out := &decodedFrame{
+		Rows: bounds.Dy(),
+		Cols: bounds.Dx(),
+	}
+
+	switch v := img.(type) {
+	case *image.Gray:
+		out.SamplesPerPixel = 1
+		out.Samples = make([]int, 0, out.Rows*out.Cols)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				out.Samples = append(out.Samples, int(v.GrayAt(x, y).Y))
+			}
+		}
+	default:
+		out.SamplesPerPixel = 3
+		out.ColorConverted = true
+		out.Samples = make([]int, 0, 3*out.Rows*out.Cols)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, _ := img.At(x, y).RGBA()
+				out.Samples = append(out.Samples, int(r>>8), int(g>>8), int(b>>8))
+			}
+		}
+	}
+
+	return out
+}
+
+// encapsulatedFrameData reassembles the fragments of encapsulated pixel data
+// into one byte slice per frame. The dicom library emits one frame per
+// fragment, but a frame may span several fragments.
+func encapsulatedFrameData(data element.PixelDataInfo, nFrames int) ([][]byte, error) {
+	fragments := make([][]byte, 0, len(data.Frames))
+	for _, frag := range data.Frames {
+		fragments = append(fragments, frag.EncapsulatedData.Data)
+	}
+
+	if len(fragments) == 0 {
+		return nil, fmt.Errorf("Encapsulated pixel data contains no fragments")
+	}
+
+	// The simple cases: one fragment per frame, or one frame made from all
+	// fragments.
+	if len(fragments) == nFrames {
+		return fragments, nil
+	}
+	if nFrames <= 1 {
+		return [][]byte{bytes.Join(fragments, nil)}, nil
+	}
+
+	// If we have a basic offset table, it tells us the byte offset (relative to
+	// the first fragment, and counting the 8-byte item headers) at which each
+	// frame starts.
+	if len(data.Offsets) == nFrames {
+		out := make([][]byte, 0, nFrames)
+		position := uint32(0)
+		next := 1
+		var current []byte
+		for _, frag := range fragments {
+			if next < len(data.Offsets) && position == data.Offsets[next] {
+				out = append(out, current)
+				current = nil
+				next++
+			}
+			current = append(current, frag...)
+			position += 8 + uint32(len(frag))
+		}
+		out = append(out, current)
+
+		if len(out) == nFrames {
+			return out, nil
+		}
+	}
+
+	// Otherwise, all of the JPEG family codecs begin each frame with a start of
+	// image marker, so we can split on that.
+	out := make([][]byte, 0, nFrames)
+	for _, frag := range fragments {
+		if len(out) == 0 || (len(frag) >= 2 && frag[0] == 0xFF && frag[1] == 0xD8) {
+			out = append(out, append([]byte(nil), frag...))
+			continue
+		}
+		out[len(out)-1] = append(out[len(out)-1], frag...)
+	}
+
+	if len(out) != nFrames {
+		return nil, fmt.Errorf("Expected %d frames but found %d in the encapsulated pixel data", nFrames, len(out))
+	}
+
+	return out, nil
+}
+
+// readAllDicom reads the DICOM in r into memory, inflating it first if it uses
+// the deflated transfer syntax.
+func readAllDicom(r io.Reader) ([]byte, error) {
+	inflated, _, err := maybeInflateDicom(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(inflated)
+}
@@ -0,0 +1,443 @@
+package bulkprocess
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// testDicomElement writes one explicit VR little endian element.
+func testDicomElement(buf *bytes.Buffer, group, elem uint16, vr string, value []byte) {
+	binary.Write(buf, binary.LittleEndian, group)
+	binary.Write(buf, binary.LittleEndian, elem)
+	buf.WriteString(vr)
+	switch vr {
+	case "OB", "OW", "SQ", "UN", "UT":
+		buf.Write([]byte{0, 0})
+		binary.Write(buf, binary.LittleEndian, uint32(len(value)))
+	default:
+		binary.Write(buf, binary.LittleEndian, uint16(len(value)))
+	}
+	buf.Write(value)
+}
+
+func testUS(v uint16) []byte {
+	out := make([]byte, 2)
+	binary.LittleEndian.PutUint16(out, v)
+	return out
+}
+
+// testPadded pads strings to an even length, as DICOM requires.
+func testPadded(s string, pad byte) []byte {
+	if len(s)%2 == 1 {
+		return append([]byte(s), pad)
+	}
+	return []byte(s)
+}
+
+// makeTestDicom builds a minimal DICOM file. If fragments is non-nil, the pixel
+// data is encapsulated, otherwise native is used.
+func makeTestDicom(transferSyntax, photometric string, rows, cols, samplesPerPixel, bitsAllocated, nFrames int, native []byte, fragments [][]byte) []byte {
+	dataset := &bytes.Buffer{}
+	testDicomElement(dataset, 0x0028, 0x0002, "US", testUS(uint16(samplesPerPixel)))
+	testDicomElement(dataset, 0x0028, 0x0004, "CS", testPadded(photometric, ' '))
+	if samplesPerPixel > 1 {
+		testDicomElement(dataset, 0x0028, 0x0006, "US", testUS(0))
+	}
+	testDicomElement(dataset, 0x0028, 0x0008, "IS", testPadded(string(rune('0'+nFrames)), ' '))
+	testDicomElement(dataset, 0x0028, 0x0010, "US", testUS(uint16(rows)))
+	testDicomElement(dataset, 0x0028, 0x0011, "US", testUS(uint16(cols)))
+	testDicomElement(dataset, 0x0028, 0x0100, "US", testUS(uint16(bitsAllocated)))
+	testDicomElement(dataset, 0x0028, 0x0101, "US", testUS(uint16(bitsAllocated)))
+	testDicomElement(dataset, 0x0028, 0x0102, "US", testUS(uint16(bitsAllocated-1)))
+	testDicomElement(dataset, 0x0028, 0x0103, "US", testUS(0))
+
+	if fragments == nil {
+		vr := "OW"
+		if bitsAllocated == 8 {
+			vr = "OB"
+		}
+		testDicomElement(dataset, 0x7FE0, 0x0010, vr, native)
+	} else {
+		binary.Write(dataset, binary.LittleEndian, []uint16{0x7FE0, 0x0010})
+		dataset.WriteString("OB")
+		dataset.Write([]byte{0, 0})
+		binary.Write(dataset, binary.LittleEndian, uint32(0xFFFFFFFF))
+
+		// Empty basic offset table, then the fragments
+		binary.Write(dataset, binary.LittleEndian, []uint16{0xFFFE, 0xE000})
+		binary.Write(dataset, binary.LittleEndian, uint32(0))
+		for _, frag := range fragments {
+			if len(frag)%2 == 1 {
+				frag = append(frag, 0)
+			}
+			binary.Write(dataset, binary.LittleEndian, []uint16{0xFFFE, 0xE000})
+			binary.Write(dataset, binary.LittleEndian, uint32(len(frag)))
+			dataset.Write(frag)
+		}
+		binary.Write(dataset, binary.LittleEndian, []uint16{0xFFFE, 0xE0DD})
+		binary.Write(dataset, binary.LittleEndian, uint32(0))
+	}
+
+	body := dataset.Bytes()
+	if transferSyntax == TransferSyntaxDeflatedExplicitVRLittleEndian {
+		deflated := &bytes.Buffer{}
+		w, _ := flate.NewWriter(deflated, flate.DefaultCompression)
+		w.Write(body)
+		w.Close()
+		body = deflated.Bytes()
+	}
+
+	meta := &bytes.Buffer{}
+	testDicomElement(meta, 0x0002, 0x0001, "OB", []byte{0, 1})
+	testDicomElement(meta, 0x0002, 0x0010, "UI", testPadded(transferSyntax, 0))
+
+	out := &bytes.Buffer{}
+	out.Write(make([]byte, 128))
+	out.WriteString("DICM")
+	testDicomElement(out, 0x0002, 0x0000, "UL", []byte{byte(meta.Len()), byte(meta.Len() >> 8), 0, 0})
+	out.Write(meta.Bytes())
+	out.Write(body)
+
+	return out.Bytes()
+}
+
+// testRLEEncode produces an RLE Lossless frame. It emits replicate runs for
+// repeated bytes and short literal runs otherwise, which is inefficient but
+// exercises both paths of the decoder.
+func testRLEEncode(samples []int, samplesPerPixel, bytesPerSample int) []byte {
+	nPixels := len(samples) / samplesPerPixel
+	var segments [][]byte
+	for s := 0; s < samplesPerPixel; s++ {
+		for b := bytesPerSample - 1; b >= 0; b-- {
+			raw := make([]byte, nPixels)
+			for px := 0; px < nPixels; px++ {
+				raw[px] = byte(samples[px*samplesPerPixel+s] >> uint(8*b))
+			}
+
+			seg := []byte{}
+			for i := 0; i < len(raw); {
+				// Replicate runs of at least 3 identical bytes
+				j := i
+				for j < len(raw) && j-i < 128 && raw[j] == raw[i] {
+					j++
+				}
+				if j-i >= 3 {
+					seg = append(seg, byte(int8(-(j-i-1))), raw[i])
+					i = j
+					continue
+				}
+				n := len(raw) - i
+				if n > 2 {
+					n = 2
+				}
+				seg = append(seg, byte(n-1))
+				seg = append(seg, raw[i:i+n]...)
+				i += n
+			}
+			if len(seg)%2 == 1 {
+				seg = append(seg, 0x80)
+			}
+			segments = append(segments, seg)
+		}
+	}
+
+	header := make([]byte, 64)
+	binary.LittleEndian.PutUint32(header, uint32(len(segments)))
+	offset := 64
+	out := []byte{}
+	for i, seg := range segments {
+		binary.LittleEndian.PutUint32(header[4+4*i:], uint32(offset))
+		offset += len(seg)
+		out = append(out, seg...)
+	}
+
+	return append(header, out...)
+}
+
+// testJPEGLosslessEncode produces a single-component lossless JPEG using the
+// given predictor and a simple Huffman table in which every category has a
+// 5-bit code.
+func testJPEGLosslessEncode(samples []int, rows, cols, precision, predictor int) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0xFF, 0xD8})
+
+	buf.Write([]byte{0xFF, 0xC3, 0, 11, byte(precision), byte(rows >> 8), byte(rows), byte(cols >> 8), byte(cols), 1, 1, 0x11, 0})
+
+	counts := make([]byte, 16)
+	counts[4] = 17
+	dht := []byte{0xFF, 0xC4, 0, byte(2 + 1 + 16 + 17), 0x00}
+	dht = append(dht, counts...)
+	for i := 0; i <= 16; i++ {
+		dht = append(dht, byte(i))
+	}
+	buf.Write(dht)
+
+	buf.Write([]byte{0xFF, 0xDA, 0, 8, 1, 1, 0x00, byte(predictor), 0, 0})
+
+	var acc uint64
+	var nAcc uint
+	writeBits := func(v int, n uint) {
+		acc = acc<<n | uint64(v)&((1<<n)-1)
+		nAcc += n
+		for nAcc >= 8 {
+			b := byte(acc >> (nAcc - 8))
+			buf.WriteByte(b)
+			if b == 0xFF {
+				buf.WriteByte(0)
+			}
+			nAcc -= 8
+		}
+	}
+
+	mask := (1 << 16) - 1
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			idx := y*cols + x
+			var pred int
+			switch {
+			case y == 0 && x == 0:
+				pred = 1 << uint(precision-1)
+			case y == 0:
+				pred = samples[idx-1]
+			case x == 0:
+				pred = samples[idx-cols]
+			default:
+				ra, rb, rc := samples[idx-1], samples[idx-cols], samples[idx-cols-1]
+				switch predictor {
+				case 1:
+					pred = ra
+				case 4:
+					pred = ra + rb - rc
+				case 7:
+					pred = (ra + rb) >> 1
+				}
+			}
+
+			diff := (samples[idx] - pred) & mask
+			if diff >= 32768 {
+				diff -= 65536
+			}
+
+			// The category is the bit length of the magnitude of the difference
+			ssss := uint(0)
+			for magnitude := absInt(diff); magnitude != 0; magnitude >>= 1 {
+				ssss++
+			}
+
+			writeBits(int(ssss), 5)
+			if ssss > 0 && ssss < 16 {
+				v := diff
+				if v < 0 {
+					v += (1 << ssss) - 1
+				}
+				writeBits(v, ssss)
+			}
+		}
+	}
+	if nAcc > 0 {
+		writeBits(0x7F, 8-nAcc)
+	}
+
+	buf.Write([]byte{0xFF, 0xD9})
+	return buf.Bytes()
+}
+
+func testGradient(rows, cols, maxVal int) []int {
+	out := make([]int, rows*cols)
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			out[y*cols+x] = ((x*37 + y*101 + x*y) % (maxVal + 1))
+		}
+	}
+	return out
+}
+
+func TestDecodeRLE(t *testing.T) {
+	rows, cols := 7, 9
+
+	gray := testGradient(rows, cols, 4095)
+	for i := 10; i < 20; i++ {
+		gray[i] = 1000
+	}
+	frame, err := decodeRLE(testRLEEncode(gray, 1, 2), rows, cols, 1, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range gray {
+		if frame.Samples[i] != gray[i] {
+			t.Fatalf("Sample %d: got %d, expected %d", i, frame.Samples[i], gray[i])
+		}
+	}
+
+	rgb := testGradient(rows, cols*3, 255)
+	frame, err = decodeRLE(testRLEEncode(rgb, 3, 1), rows, cols, 3, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range rgb {
+		if frame.Samples[i] != rgb[i] {
+			t.Fatalf("Sample %d: got %d, expected %d", i, frame.Samples[i], rgb[i])
+		}
+	}
+}
+
+func TestDecodeJPEGLossless(t *testing.T) {
+	rows, cols := 11, 13
+	for _, precision := range []int{8, 12, 16} {
+		samples := testGradient(rows, cols, (1<<uint(precision))-1)
+		for _, predictor := range []int{1, 4, 7} {
+			frame, err := decodeJPEGLossless(testJPEGLosslessEncode(samples, rows, cols, precision, predictor))
+			if err != nil {
+				t.Fatalf("Precision %d, predictor %d: %v", precision, predictor, err)
+			}
+			for i := range samples {
+				if frame.Samples[i] != samples[i] {
+					t.Fatalf("Precision %d, predictor %d, sample %d: got %d, expected %d", precision, predictor, i, frame.Samples[i], samples[i])
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeJPEGLS(t *testing.T) {
+	// A 4x4 8-bit JPEG-LS image (lossless, default parameters) with a flat
+	// region, to exercise both the regular and the run modes.
+	expected := []int{
+		10, 10, 10, 10,
+		10, 10, 200, 10,
+		10, 50, 10, 10,
+		10, 10, 10, 255,
+	}
+
+	frame, err := decodeJPEGLS(testJPEGLSFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Rows != 4 || frame.Cols != 4 || frame.SamplesPerPixel != 1 {
+		t.Fatalf("Unexpected geometry %dx%dx%d", frame.Cols, frame.Rows, frame.SamplesPerPixel)
+	}
+	for i := range expected {
+		if frame.Samples[i] != expected[i] {
+			t.Fatalf("Sample %d: got %d, expected %d", i, frame.Samples[i], expected[i])
+		}
+	}
+}
+
+func TestExtractDicomTransferSyntaxes(t *testing.T) {
+	rows, cols := 6, 5
+	gray := testGradient(rows, cols, 255)
+
+	native := make([]byte, len(gray))
+	for i, v := range gray {
+		native[i] = byte(v)
+	}
+
+	jpegGray := image.NewGray(image.Rect(0, 0, cols, rows))
+	for i, v := range gray {
+		jpegGray.SetGray(i%cols, i/cols, color.Gray{Y: uint8(v)})
+	}
+	jpegBuf := &bytes.Buffer{}
+	if err := jpeg.Encode(jpegBuf, jpegGray, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name      string
+		Dicom     []byte
+		Tolerance int
+	}{
+		{"Native", makeTestDicom(TransferSyntaxExplicitVRLittleEndian, "MONOCHROME2", rows, cols, 1, 8, 1, native, nil), 0},
+		{"Deflated", makeTestDicom(TransferSyntaxDeflatedExplicitVRLittleEndian, "MONOCHROME2", rows, cols, 1, 8, 1, native, nil), 0},
+		{"RLE", makeTestDicom(TransferSyntaxRLELossless, "MONOCHROME2", rows, cols, 1, 8, 1, nil, [][]byte{testRLEEncode(gray, 1, 1)}), 0},
+		{"JPEGLossless", makeTestDicom(TransferSyntaxJPEGLosslessSV1, "MONOCHROME2", rows, cols, 1, 8, 1, nil, [][]byte{testJPEGLosslessEncode(gray, rows, cols, 8, 1)}), 0},
+		{"JPEGBaseline", makeTestDicom(TransferSyntaxJPEGBaseline, "MONOCHROME2", rows, cols, 1, 8, 1, nil, [][]byte{jpegBuf.Bytes()}), 8},
+	}
+
+	for _, c := range cases {
+		img, err := ExtractDicomFromReaderFuncOp(bytes.NewReader(c.Dicom), int64(len(c.Dicom)), OptWindowScalingRaw())
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name, err)
+		}
+
+		g, ok := img.(*image.Gray16)
+		if !ok {
+			t.Fatalf("%s: expected *image.Gray16, got %T", c.Name, img)
+		}
+		for i, v := range gray {
+			got := int(g.Gray16At(i%cols, i/cols).Y)
+			if got-v > c.Tolerance || v-got > c.Tolerance {
+				t.Fatalf("%s: pixel %d: got %d, expected %d", c.Name, i, got, v)
+			}
+		}
+	}
+}
+
+func TestExtractDicomPhotometric(t *testing.T) {
+	rows, cols := 2, 3
+
+	// MONOCHROME1 is inverted
+	native := []byte{0, 255, 0, 255, 0, 255}
+	dcm := makeTestDicom(TransferSyntaxExplicitVRLittleEndian, "MONOCHROME1", rows, cols, 1, 8, 1, native, nil)
+	img, err := ExtractDicomFromReaderFuncOp(bytes.NewReader(dcm), int64(len(dcm)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if y := img.(*image.Gray16).Gray16At(0, 0).Y; y != 0xFFFF {
+		t.Errorf("MONOCHROME1: expected a zero sample to be white, got %d", y)
+	}
+
+	// YBR_FULL from RLE is converted to RGB
+	ybr := []int{}
+	for i := 0; i < rows*cols; i++ {
+		y, cb, cr := color.RGBToYCbCr(200, 100, 50)
+		ybr = append(ybr, int(y), int(cb), int(cr))
+	}
+	dcm = makeTestDicom(TransferSyntaxRLELossless, "YBR_FULL", rows, cols, 3, 8, 1, nil, [][]byte{testRLEEncode(ybr, 3, 1)})
+	img, err = ExtractDicomFromReaderFuncOp(bytes.NewReader(dcm), int64(len(dcm)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, _ := img.At(1, 1).RGBA()
+	if d := int(r>>8) - 200; d > 2 || d < -2 || int(g>>8) < 98 || int(g>>8) > 102 || int(b>>8) < 48 || int(b>>8) > 52 {
+		t.Errorf("YBR_FULL: got RGB (%d, %d, %d), expected about (200, 100, 50)", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestExtractDicomMultiFrame(t *testing.T) {
+	rows, cols := 4, 4
+	frames := [][]byte{}
+	for f := 0; f < 3; f++ {
+		samples := make([]int, rows*cols)
+		for i := range samples {
+			samples[i] = 50 * f
+		}
+		frames = append(frames, testRLEEncode(samples, 1, 1))
+	}
+
+	dcm := makeTestDicom(TransferSyntaxRLELossless, "MONOCHROME2", rows, cols, 1, 8, 3, nil, frames)
+	imgs, err := ExtractDicomFramesFromReaderFuncOp(bytes.NewReader(dcm), int64(len(dcm)), OptWindowScalingRaw())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 3 {
+		t.Fatalf("Expected 3 frames, got %d", len(imgs))
+	}
+	for f, img := range imgs {
+		if y := img.(*image.Gray16).Gray16At(2, 2).Y; int(y) != 50*f {
+			t.Errorf("Frame %d: got %d, expected %d", f, y, 50*f)
+		}
+	}
+}
+
+// testJPEGLSFixture is the image in TestDecodeJPEGLS, encoded as JPEG-LS.
+var testJPEGLSFixture = []byte{
+	0xFF, 0xD8, 0xFF, 0xF7, 0x00, 0x0B, 0x08, 0x00, 0x04, 0x00, 0x04, 0x01,
+	0x01, 0x11, 0x00, 0xFF, 0xDA, 0x00, 0x08, 0x01, 0x01, 0x00, 0x00, 0x00,
+	0x00, 0x07, 0x95, 0x20, 0x00, 0x0A, 0x00, 0x00, 0x01, 0x82, 0x8B, 0xC0,
+	0x00, 0x00, 0x60, 0xA4, 0x00, 0x00, 0x09, 0x34, 0xFF, 0xD9,
+}
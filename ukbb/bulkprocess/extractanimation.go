@@ -0,0 +1,480 @@
+package bulkprocess
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"io"
+)
+
+// Animation formats. GIF is limited to a 256-color palette that is shared by
+// all frames; APNG is lossless and keeps 16-bit grayscale; AVI holds
+// motion-JPEG frames that most video players can open.
+const (
+	AnimationGIF  = "gif"
+	AnimationAPNG = "apng"
+	AnimationAVI  = "avi"
+)
+
+// AnimationExtension returns the file extension, including the leading dot,
+// for an animation format.
+func AnimationExtension(format string) (string, error) {
+	switch format {
+	case AnimationGIF:
+		return ".gif", nil
+	case AnimationAPNG:
+		return ".apng", nil
+	case AnimationAVI:
+		return ".avi", nil
+	}
+
+	return "", fmt.Errorf("Unrecognized animation format %q. Options include %s, %s, and %s", format, AnimationGIF, AnimationAPNG, AnimationAVI)
+}
+
+// EncodeAnimation writes an animation of the ordered images in the requested
+// format. As with MakeOneGIF, the delay between frames is in hundredths of a
+// second. withTransparency only applies to GIFs.
+func EncodeAnimation(w io.Writer, format string, sortedImages []image.Image, delay int, withTransparency bool) error {
+	switch format {
+	case AnimationGIF:
+		outGIF, err := MakeOneGIF(sortedImages, delay, withTransparency)
+		if err != nil {
+			return err
+		}
+		return gif.EncodeAll(w, outGIF)
+	case AnimationAPNG:
+		return EncodeAPNG(w, sortedImages, delay)
+	case AnimationAVI:
+		return EncodeMJPEGAVI(w, sortedImages, delay, 95)
+	}
+
+	_, err := AnimationExtension(format)
+	return err
+}
+
+// animationCanvas is the size of the largest image; smaller frames are drawn
+// at its top left.
+func animationCanvas(sortedImages []image.Image) (int, int) {
+	greatestX, greatestY := 0, 0
+	for _, img := range sortedImages {
+		if x := img.Bounds().Dx(); x > greatestX {
+			greatestX = x
+		}
+		if y := img.Bounds().Dy(); y > greatestY {
+			greatestY = y
+		}
+	}
+
+	return greatestX, greatestY
+}
+
+// EncodeAPNG writes an animated PNG. All frames share one color type, chosen
+// so that no information is lost: 8- or 16-bit grayscale if every frame is
+// grayscale, and otherwise 8- or 16-bit RGB, with an alpha channel only if
+// some frame is not opaque. The delay between frames is in hundredths of a
+// second, and the animation loops forever. Viewers that do not understand
+// APNG show the first frame.
+func EncodeAPNG(w io.Writer, sortedImages []image.Image, delay int) error {
+	if len(sortedImages) < 1 {
+		return fmt.Errorf("EncodeAPNG expects 1 or more image to convert into an animation; received 0")
+	}
+
+	width, height := animationCanvas(sortedImages)
+	enc := newAPNGEncoder(sortedImages)
+
+	if _, err := w.Write([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+		return err
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8] = enc.bitDepth
+	ihdr[9] = enc.colorType
+	if err := writePNGChunk(w, "IHDR", ihdr); err != nil {
+		return err
+	}
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(len(sortedImages)))
+	binary.BigEndian.PutUint32(actl[4:], 0)
+	if err := writePNGChunk(w, "acTL", actl); err != nil {
+		return err
+	}
+
+	sequence := uint32(0)
+	for k, img := range sortedImages {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], sequence)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(width))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(height))
+		binary.BigEndian.PutUint16(fctl[20:], uint16(delay))
+		binary.BigEndian.PutUint16(fctl[22:], 100)
+		// Offsets, dispose_op, and blend_op (APNG_BLEND_OP_SOURCE) are 0.
+		if err := writePNGChunk(w, "fcTL", fctl); err != nil {
+			return err
+		}
+		sequence++
+
+		data, err := enc.frameData(img, width, height)
+		if err != nil {
+			return err
+		}
+
+		// The first frame is the default image, stored as ordinary IDAT so
+		// that it displays everywhere; the rest are stored as fdAT chunks,
+		// which carry a sequence number.
+		if k == 0 {
+			err = writePNGChunk(w, "IDAT", data)
+		} else {
+			fdat := make([]byte, 4+len(data))
+			binary.BigEndian.PutUint32(fdat, sequence)
+			copy(fdat[4:], data)
+			err = writePNGChunk(w, "fdAT", fdat)
+			sequence++
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return writePNGChunk(w, "IEND", nil)
+}
+
+func writePNGChunk(w io.Writer, chunkType string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], chunkType)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+
+	footer := make([]byte, 4)
+	binary.BigEndian.PutUint32(footer, crc.Sum32())
+
+	for _, v := range [][]byte{header, data, footer} {
+		if _, err := w.Write(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PNG color types.
+const (
+	pngGray = 0
+	pngRGB  = 2
+	pngRGBA = 6
+)
+
+type apngEncoder struct {
+	colorType uint8
+	bitDepth  uint8
+}
+
+func newAPNGEncoder(sortedImages []image.Image) apngEncoder {
+	gray, deep, opaque := true, false, true
+	for _, img := range sortedImages {
+		switch img.ColorModel() {
+		case color.GrayModel:
+		case color.Gray16Model:
+			deep = true
+		case color.RGBA64Model, color.NRGBA64Model:
+			gray, deep = false, true
+		default:
+			gray = false
+		}
+
+		if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+			opaque = false
+		}
+	}
+
+	enc := apngEncoder{colorType: pngRGB, bitDepth: 8}
+	if gray && opaque {
+		enc.colorType = pngGray
+	} else if !opaque {
+		enc.colorType = pngRGBA
+	}
+	if deep {
+		enc.bitDepth = 16
+	}
+
+	return enc
+}
+
+func (e apngEncoder) bytesPerPixel() int {
+	channels := 1
+	switch e.colorType {
+	case pngRGB:
+		channels = 3
+	case pngRGBA:
+		channels = 4
+	}
+
+	return channels * int(e.bitDepth) / 8
+}
+
+// frameData returns the compressed, filtered scanlines of one frame, drawn at
+// the top left of a width x height canvas.
+func (e apngEncoder) frameData(img image.Image, width, height int) ([]byte, error) {
+	bpp := e.bytesPerPixel()
+	rowLen := width * bpp
+
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	prior := make([]byte, rowLen)
+	current := make([]byte, rowLen)
+	filtered := make([][]byte, 5)
+	for i := range filtered {
+		filtered[i] = make([]byte, 1+rowLen)
+		filtered[i][0] = byte(i)
+	}
+
+	for y := 0; y < height; y++ {
+		for i := range current {
+			current[i] = 0
+		}
+
+		for x := 0; x < width && y < b.Dy() && x < b.Dx(); x++ {
+			e.putPixel(current[x*bpp:], img, b.Min.X+x, b.Min.Y+y)
+		}
+
+		if _, err := zw.Write(filterRow(filtered, current, prior, bpp)); err != nil {
+			return nil, err
+		}
+
+		prior, current = current, prior
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (e apngEncoder) putPixel(dst []byte, img image.Image, x, y int) {
+	if e.colorType == pngGray {
+		var v uint16
+		switch g := img.(type) {
+		case *image.Gray16:
+			v = g.Gray16At(x, y).Y
+		case *image.Gray:
+			v = uint16(g.GrayAt(x, y).Y) * 0x101
+		default:
+			v = color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y
+		}
+
+		if e.bitDepth == 16 {
+			binary.BigEndian.PutUint16(dst, v)
+		} else {
+			dst[0] = uint8(v >> 8)
+		}
+		return
+	}
+
+	// PNG stores non-premultiplied color.
+	c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+	channels := []uint16{c.R, c.G, c.B, c.A}
+	if e.colorType == pngRGB {
+		channels = channels[:3]
+	}
+
+	for i, v := range channels {
+		if e.bitDepth == 16 {
+			binary.BigEndian.PutUint16(dst[2*i:], v)
+		} else {
+			dst[i] = uint8(v >> 8)
+		}
+	}
+}
+
+// filterRow applies each of the five PNG filters to the row and returns the
+// one with the smallest sum of absolute values, which is the heuristic
+// suggested by the PNG specification.
+func filterRow(filtered [][]byte, current, prior []byte, bpp int) []byte {
+	for i, v := range current {
+		var left, upperLeft byte
+		if i >= bpp {
+			left, upperLeft = current[i-bpp], prior[i-bpp]
+		}
+		up := prior[i]
+
+		filtered[0][1+i] = v
+		filtered[1][1+i] = v - left
+		filtered[2][1+i] = v - up
+		filtered[3][1+i] = v - byte((int(left)+int(up))/2)
+		filtered[4][1+i] = v - paeth(left, up, upperLeft)
+	}
+
+	best, bestSum := 0, -1
+	for f, row := range filtered {
+		sum := 0
+		for _, v := range row[1:] {
+			if v < 128 {
+				sum += int(v)
+			} else {
+				sum += 256 - int(v)
+			}
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = f, sum
+		}
+	}
+
+	return filtered[best]
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	} else if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// EncodeMJPEGAVI writes an AVI file whose video stream is a series of JPEG
+// images (motion JPEG), at the given JPEG quality (1-100). The delay between
+// frames is in hundredths of a second. Frames are drawn at the top left of a
+// canvas the size of the largest image.
+func EncodeMJPEGAVI(w io.Writer, sortedImages []image.Image, delay, quality int) error {
+	if len(sortedImages) < 1 {
+		return fmt.Errorf("EncodeMJPEGAVI expects 1 or more image to convert into an animation; received 0")
+	}
+	if delay < 1 {
+		delay = 1
+	}
+
+	width, height := animationCanvas(sortedImages)
+
+	// Encode every frame first, since the headers need to know their sizes.
+	frames := make([][]byte, 0, len(sortedImages))
+	maxFrame := 0
+	for _, img := range sortedImages {
+		canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+		draw.Draw(canvas, img.Bounds().Sub(img.Bounds().Min), img, img.Bounds().Min, draw.Over)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, canvas, &jpeg.Options{Quality: quality}); err != nil {
+			return err
+		}
+
+		// RIFF chunks are padded to an even length.
+		if buf.Len()%2 == 1 {
+			buf.WriteByte(0)
+		}
+		if buf.Len() > maxFrame {
+			maxFrame = buf.Len()
+		}
+		frames = append(frames, buf.Bytes())
+	}
+
+	var movi bytes.Buffer
+	movi.WriteString("movi")
+	var idx1 bytes.Buffer
+	for _, frame := range frames {
+		// Index offsets are relative to the "movi" fourcc.
+		idx1.WriteString("00dc")
+		binary.Write(&idx1, binary.LittleEndian, []uint32{0x10, uint32(movi.Len()), uint32(len(frame))})
+
+		movi.WriteString("00dc")
+		binary.Write(&movi, binary.LittleEndian, uint32(len(frame)))
+		movi.Write(frame)
+	}
+
+	microSecPerFrame := uint32(delay * 10000)
+
+	var avih bytes.Buffer
+	binary.Write(&avih, binary.LittleEndian, []uint32{
+		microSecPerFrame,
+		uint32(maxFrame) * 100 / uint32(delay), // dwMaxBytesPerSec
+		0,                                      // dwPaddingGranularity
+		0x10,                                   // dwFlags: AVIF_HASINDEX
+		uint32(len(frames)),                    // dwTotalFrames
+		0,                                      // dwInitialFrames
+		1,                                      // dwStreams
+		uint32(maxFrame),                       // dwSuggestedBufferSize
+		uint32(width),
+		uint32(height),
+		0, 0, 0, 0, // dwReserved
+	})
+
+	var strh bytes.Buffer
+	strh.WriteString("vidsMJPG")
+	binary.Write(&strh, binary.LittleEndian, []uint32{0, 0, 0}) // dwFlags, wPriority+wLanguage, dwInitialFrames
+	binary.Write(&strh, binary.LittleEndian, []uint32{
+		uint32(delay),       // dwScale
+		100,                 // dwRate: frames per second is dwRate/dwScale
+		0,                   // dwStart
+		uint32(len(frames)), // dwLength
+		uint32(maxFrame),    // dwSuggestedBufferSize
+		0xFFFFFFFF,          // dwQuality: default
+		0,                   // dwSampleSize
+	})
+	binary.Write(&strh, binary.LittleEndian, []uint16{0, 0, uint16(width), uint16(height)})
+
+	var strf bytes.Buffer
+	binary.Write(&strf, binary.LittleEndian, []uint32{40, uint32(width), uint32(height)})
+	binary.Write(&strf, binary.LittleEndian, []uint16{1, 24})
+	strf.WriteString("MJPG")
+	binary.Write(&strf, binary.LittleEndian, []uint32{uint32(width * height * 3), 0, 0, 0, 0})
+
+	strl := riffList("strl", riffChunk("strh", strh.Bytes()), riffChunk("strf", strf.Bytes()))
+	hdrl := riffList("hdrl", riffChunk("avih", avih.Bytes()), strl)
+
+	var body bytes.Buffer
+	body.WriteString("AVI ")
+	body.Write(hdrl)
+	body.Write(riffChunk("LIST", movi.Bytes()))
+	body.Write(riffChunk("idx1", idx1.Bytes()))
+
+	_, err := w.Write(riffChunk("RIFF", body.Bytes()))
+	return err
+}
+
+func riffChunk(fourcc string, data []byte) []byte {
+	out := make([]byte, 8, 8+len(data)+1)
+	copy(out, fourcc)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+
+	return out
+}
+
+func riffList(listType string, chunks ...[]byte) []byte {
+	data := []byte(listType)
+	for _, v := range chunks {
+		data = append(data, v...)
+	}
+
+	return riffChunk("LIST", data)
+}
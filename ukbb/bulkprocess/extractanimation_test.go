@@ -0,0 +1,156 @@
+package bulkprocess
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testAnimationFrames() []image.Image {
+	var frames []image.Image
+	for k := 0; k < 3; k++ {
+		img := image.NewGray16(image.Rect(0, 0, 7, 5))
+		for p := range img.Pix {
+			img.Pix[p] = uint8(p*31 + k*97)
+		}
+		frames = append(frames, img)
+	}
+
+	return frames
+}
+
+// testPNGChunks splits a PNG file into its chunks.
+func testPNGChunks(t *testing.T, b []byte) (types []string, data [][]byte) {
+	t.Helper()
+
+	if !bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")) {
+		t.Fatal("Missing PNG signature")
+	}
+	b = b[8:]
+	for len(b) > 0 {
+		n := binary.BigEndian.Uint32(b)
+		types = append(types, string(b[4:8]))
+		data = append(data, b[8:8+n])
+		b = b[12+n:]
+	}
+
+	return types, data
+}
+
+func TestEncodeAPNG(t *testing.T) {
+	frames := testAnimationFrames()
+
+	var buf bytes.Buffer
+	if err := EncodeAPNG(&buf, frames, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	types, data := testPNGChunks(t, buf.Bytes())
+	expected := []string{"IHDR", "acTL", "fcTL", "IDAT", "fcTL", "fdAT", "fcTL", "fdAT", "IEND"}
+	if len(types) != len(expected) {
+		t.Fatalf("Expected chunks %v, got %v", expected, types)
+	}
+	for i := range types {
+		if types[i] != expected[i] {
+			t.Fatalf("Expected chunks %v, got %v", expected, types)
+		}
+	}
+
+	if n := binary.BigEndian.Uint32(data[1]); n != 3 {
+		t.Errorf("Expected 3 frames in acTL, got %d", n)
+	}
+
+	// Rebuild each frame as a standalone PNG and confirm that it decodes to
+	// the original 16-bit pixels.
+	frame := 0
+	for i, chunkType := range types {
+		var pixels []byte
+		switch chunkType {
+		case "IDAT":
+			pixels = data[i]
+		case "fdAT":
+			pixels = data[i][4:]
+		default:
+			continue
+		}
+
+		var single bytes.Buffer
+		single.WriteString("\x89PNG\r\n\x1a\n")
+		writePNGChunk(&single, "IHDR", data[0])
+		writePNGChunk(&single, "IDAT", pixels)
+		writePNGChunk(&single, "IEND", nil)
+
+		img, err := png.Decode(&single)
+		if err != nil {
+			t.Fatalf("Frame %d: %v", frame, err)
+		}
+		got, ok := img.(*image.Gray16)
+		if !ok {
+			t.Fatalf("Frame %d: expected a 16-bit grayscale image, got %T", frame, img)
+		}
+		if !bytes.Equal(got.Pix, frames[frame].(*image.Gray16).Pix) {
+			t.Errorf("Frame %d: pixels differ", frame)
+		}
+		frame++
+	}
+}
+
+func TestEncodeMJPEGAVI(t *testing.T) {
+	frames := testAnimationFrames()
+
+	// A smaller color frame is placed at the top left of the canvas.
+	small := image.NewRGBA(image.Rect(2, 2, 5, 4))
+	for p := 0; p < len(small.Pix); p += 4 {
+		small.Pix[p], small.Pix[p+3] = 255, 255
+	}
+	frames = append(frames, small)
+
+	var buf bytes.Buffer
+	if err := EncodeMJPEGAVI(&buf, frames, 5, 90); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	if string(b[0:4]) != "RIFF" || string(b[8:12]) != "AVI " || int(binary.LittleEndian.Uint32(b[4:])) != len(b)-8 {
+		t.Fatalf("Malformed RIFF header %q", b[:12])
+	}
+
+	movi := bytes.Index(b, []byte("movi"))
+	idx1 := bytes.Index(b, []byte("idx1"))
+	if movi < 0 || idx1 < 0 {
+		t.Fatal("Missing movi list or idx1 index")
+	}
+
+	n := int(binary.LittleEndian.Uint32(b[idx1+4:])) / 16
+	if n != len(frames) {
+		t.Fatalf("Expected %d index entries, got %d", len(frames), n)
+	}
+
+	for i := 0; i < n; i++ {
+		entry := b[idx1+8+16*i:]
+		offset := movi + int(binary.LittleEndian.Uint32(entry[8:]))
+		size := int(binary.LittleEndian.Uint32(entry[12:]))
+		if string(b[offset:offset+4]) != "00dc" {
+			t.Fatalf("Index entry %d does not point to a frame", i)
+		}
+
+		img, err := jpeg.Decode(bytes.NewReader(b[offset+8 : offset+8+size]))
+		if err != nil {
+			t.Fatalf("Frame %d: %v", i, err)
+		}
+		if img.Bounds().Dx() != 7 || img.Bounds().Dy() != 5 {
+			t.Errorf("Frame %d: expected 7x5, got %v", i, img.Bounds())
+		}
+
+		if i == len(frames)-1 {
+			r, g, _, _ := img.At(1, 1).RGBA()
+			if r < 0xc000 || g > 0x4000 {
+				t.Errorf("Expected the small frame to be drawn in red at the top left, got %v", color.RGBA64Model.Convert(img.At(1, 1)))
+			}
+		}
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"io"
 	"log"
 	"math"
 	"strconv"
+	"strings"
 
 	"cloud.google.com/go/storage"
 	"github.com/suyashkumar/dicom"
@@ -85,12 +87,37 @@ func ExtractDicomFromReader(dicomReader io.Reader, nReaderBytes int64, includeOv
 	return ExtractDicomFromReaderFuncOp(dicomReader, nReaderBytes, opts...)
 }
 
+// ExtractDicomFromReaderFuncOp operates on a reader that contains one DICOM
+// and returns its image. For multi-frame DICOMs, this is the first frame; use
+// ExtractDicomFramesFromReaderFuncOp to obtain all of them.
 func ExtractDicomFromReaderFuncOp(dicomReader io.Reader, nReaderBytes int64, options ...func(*ExtractDicomOptions)) (image.Image, error) {
+	frames, err := ExtractDicomFramesFromReaderFuncOp(dicomReader, nReaderBytes, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return frames[0], nil
+}
+
+// ExtractDicomFramesFromReaderFuncOp operates on a reader that contains one
+// DICOM and returns one image per frame. Native pixel data as well as the
+// deflated, JPEG Baseline, JPEG Lossless, JPEG-LS and RLE Lossless transfer
+// syntaxes are supported. Grayscale images are returned as *image.Gray16 (with
+// MONOCHROME1 inverted so that higher values are brighter, unless raw window
+// scaling is requested), and color images as *image.RGBA64.
+func ExtractDicomFramesFromReaderFuncOp(dicomReader io.Reader, nReaderBytes int64, options ...func(*ExtractDicomOptions)) ([]image.Image, error) {
 	opts := &ExtractDicomOptions{}
 	for _, opt := range options {
 		opt(opts)
 	}
 
+	// Deflated DICOMs must be inflated before the parser can make sense of
+	// them.
+	dicomReader, nReaderBytes, err := maybeInflateDicom(dicomReader, nReaderBytes)
+	if err != nil {
+		return nil, err
+	}
+
 	p, err := dicom.NewParser(dicomReader, nReaderBytes, nil)
 	if err != nil {
 		return nil, err
@@ -112,12 +139,13 @@ func ExtractDicomFromReaderFuncOp(dicomReader io.Reader, nReaderBytes int64, opt
 
 	var nOverlayRows, nOverlayCols int
 
-	var img *image.Gray16
-
 	var imgRows, imgCols int
-	var imgPixels []int
 	var overlayPixels []int
 
+	var transferSyntax, photometricInterpretation string
+	samplesPerPixel, planarConfiguration, nFrames := 1, 0, 1
+	var pixelData *element.PixelDataInfo
+
 	for _, elem := range parsedData.Elements {
 
 		// The typical approach is to extract bitsAllocated, bitsStored, and the highBit
@@ -140,13 +168,20 @@ func ExtractDicomFromReaderFuncOp(dicomReader io.Reader, nReaderBytes int64, opt
 			imgRows = int(elem.Value[0].(uint16))
 		} else if elem.Tag == dicomtag.Columns {
 			imgCols = int(elem.Value[0].(uint16))
-		}
-
-		// If imgPixels is still uninitialized and we're on a rows or columns
-		// tag, and both rows and columns are populated, initialize imgPixels'
-		// backing array's capacity to the number of pixels in the image.
-		if elem.Tag == dicomtag.Rows || elem.Tag == dicomtag.Columns && imgRows*imgCols > 0 && len(imgPixels) == 0 {
-			imgPixels = make([]int, 0, imgRows*imgCols)
+		} else if elem.Tag == dicomtag.SamplesPerPixel {
+			samplesPerPixel = int(elem.Value[0].(uint16))
+		} else if elem.Tag == dicomtag.PlanarConfiguration {
+			planarConfiguration = int(elem.Value[0].(uint16))
+		} else if elem.Tag == dicomtag.TransferSyntaxUID {
+			transferSyntax = strings.TrimRight(elem.Value[0].(string), "\x00 ")
+		} else if elem.Tag == dicomtag.PhotometricInterpretation {
+			photometricInterpretation = strings.TrimSpace(elem.Value[0].(string))
+		} else if elem.Tag == dicomtag.NumberOfFrames {
+			nFrames, err = strconv.Atoi(strings.TrimSpace(elem.Value[0].(string)))
+			if err != nil || nFrames < 1 {
+				log.Println("Could not parse NumberOfFrames, assuming 1:", err)
+				nFrames = 1
+			}
 		}
 
 		if elem.Tag == dicomtag.RescaleSlope {
@@ -186,12 +221,6 @@ func ExtractDicomFromReaderFuncOp(dicomReader io.Reader, nReaderBytes int64, opt
 				log.Printf("RescaleType: %+v %T\n", elem.Value, elem.Value[0])
 			} else if elem.Tag == dicomtag.PixelIntensityRelationship {
 				log.Printf("PixelIntensityRelationship: %+v %T\n", elem.Value, elem.Value[0])
-			} else if elem.Tag == dicomtag.PhotometricInterpretation {
-				log.Printf("PhotometricInterpretation: %+v %T\n", elem.Value, elem.Value[0])
-			} else if elem.Tag == dicomtag.SamplesPerPixel {
-				log.Printf("SamplesPerPixel: %+v %T\n", elem.Value, elem.Value[0])
-			} else if elem.Tag == dicomtag.TransferSyntaxUID {
-				log.Printf("TransferSyntaxUID: %+v %T\n", elem.Value, elem.Value[0])
 			} else if elem.Tag == dicomtag.SmallestImagePixelValue {
 				log.Printf("SmallestImagePixelValue: %+v %T\n", elem.Value, elem.Value[0])
 			} else if elem.Tag == dicomtag.LargestImagePixelValue {
@@ -201,28 +230,11 @@ func ExtractDicomFromReaderFuncOp(dicomReader io.Reader, nReaderBytes int64, opt
 			}
 		}
 
-		// Main image
+		// Main image. We defer decoding until all of the elements have been
+		// seen, since decoding depends on several of them.
 		if elem.Tag == dicomtag.PixelData {
-
 			data := elem.Value[0].(element.PixelDataInfo)
-
-			for _, frame := range data.Frames {
-				if frame.IsEncapsulated() {
-					encImg, err := frame.GetImage()
-					if err != nil {
-						return nil, fmt.Errorf("Frame is encapsulated, which we did not expect. Additionally, %s", err.Error())
-					}
-
-					// We're done, since it's not clear how to add an overlay
-					return encImg, nil
-
-				}
-
-				for j := 0; j < len(frame.NativeData.Data); j++ {
-					imgPixels = append(imgPixels, frame.NativeData.Data[j][0])
-				}
-			}
-
+			pixelData = &data
 		}
 
 		// Extract the overlay, if it exists and we want it
@@ -261,46 +273,136 @@ func ExtractDicomFromReaderFuncOp(dicomReader io.Reader, nReaderBytes int64, opt
 		}
 	}
 
-	// Identify the brightest pixel
+	if pixelData == nil {
+		return nil, fmt.Errorf("No pixel data found")
+	}
+
+	frames, err := pixelDataToFrames(*pixelData, transferSyntax, imgRows, imgCols, samplesPerPixel, planarConfiguration, int(bitsAllocated), nFrames)
+	if err != nil {
+		return nil, err
+	}
+
+	// Identify the brightest pixel across all frames
 	maxIntensity := 0
-	for _, v := range imgPixels {
-		if v > maxIntensity {
-			maxIntensity = v
+	for _, frame := range frames {
+		for _, v := range frame.Samples {
+			if v > maxIntensity {
+				maxIntensity = v
+			}
 		}
 	}
 
-	// Draw the image
-	img = image.NewGray16(image.Rect(0, 0, imgCols, imgRows))
-	for j := 0; j < len(imgPixels); j++ {
-		leVal := imgPixels[j]
+	out := make([]image.Image, 0, len(frames))
+	for frameIdx, frame := range frames {
+		var img draw.Image
+		if frame.SamplesPerPixel == 1 {
+			img = renderGrayFrame(frame, photometricInterpretation, opts.WindowScaling, maxIntensity, rescaleSlope, rescaleIntercept, windowWidth, windowCenter, bitsAllocated)
+		} else {
+			img, err = renderColorFrame(frame, photometricInterpretation, bitsAllocated)
+			if err != nil {
+				return nil, err
+			}
+		}
 
-		// Should be %cols and /cols -- row count is not necessary here
-		switch opts.WindowScaling {
+		// Draw the overlay
+		if opts.IncludeOverlay && overlayPixels != nil && nOverlayCols > 0 {
+			// Multi-frame overlays are stored contiguously. If there is only
+			// one overlay frame, it applies to every image frame.
+			overlayFrame := overlayPixels
+			if perFrame := nOverlayRows * nOverlayCols; perFrame > 0 && len(overlayPixels) >= (frameIdx+1)*perFrame {
+				overlayFrame = overlayPixels[frameIdx*perFrame : (frameIdx+1)*perFrame]
+			}
+
+			// Iterate over the bytes. There will be 1 value for each cell.
+			// So in a 1024x1024 overlay, you will expect 1,048,576 cells.
+			for i, overlayValue := range overlayFrame {
+				row := i / nOverlayCols
+				col := i % nOverlayCols
+
+				if overlayValue != 0 {
+					img.Set(col, row, color.White)
+				}
+			}
+		}
+
+		out = append(out, img)
+	}
+
+	return out, nil
+}
+
+// renderGrayFrame draws a single-sample frame into a 16-bit grayscale image,
+// applying the requested window scaling.
+func renderGrayFrame(frame *decodedFrame, photometricInterpretation, windowScaling string, maxIntensity int, rescaleSlope, rescaleIntercept, windowWidth, windowCenter float64, bitsAllocated uint16) *image.Gray16 {
+	imgCols := frame.Cols
+
+	// In MONOCHROME1, the minimum sample value is displayed as white. We
+	// invert after windowing so that the output is always MONOCHROME2-like.
+	// Raw output is left as the stored values.
+	invert := photometricInterpretation == "MONOCHROME1" && windowScaling != "raw"
+
+	img := image.NewGray16(image.Rect(0, 0, frame.Cols, frame.Rows))
+	for j := 0; j < len(frame.Samples); j++ {
+		leVal := frame.Samples[j]
+
+		var y uint16
+		switch windowScaling {
 		case "pythonic":
-			img.SetGray16(j%imgCols, j/imgCols, color.Gray16{Y: ApplyPythonicWindowScaling(leVal, maxIntensity)})
+			y = ApplyPythonicWindowScaling(leVal, maxIntensity)
 		case "raw":
-			img.SetGray16(j%imgCols, j/imgCols, color.Gray16{Y: ApplyNoWindowScaling(leVal)})
+			y = ApplyNoWindowScaling(leVal)
 		default:
 			// "official" window scaling
-			img.SetGray16(j%imgCols, j/imgCols, color.Gray16{Y: ApplyOfficialWindowScaling(leVal, rescaleSlope, rescaleIntercept, windowWidth, windowCenter, bitsAllocated)})
+			y = ApplyOfficialWindowScaling(leVal, rescaleSlope, rescaleIntercept, windowWidth, windowCenter, bitsAllocated)
+		}
+
+		if invert {
+			y = math.MaxUint16 - y
 		}
+
+		// Should be %cols and /cols -- row count is not necessary here
+		img.SetGray16(j%imgCols, j/imgCols, color.Gray16{Y: y})
 	}
 
-	// Draw the overlay
-	if opts.IncludeOverlay && img != nil && overlayPixels != nil {
-		// Iterate over the bytes. There will be 1 value for each cell.
-		// So in a 1024x1024 overlay, you will expect 1,048,576 cells.
-		for i, overlayValue := range overlayPixels {
-			row := i / nOverlayCols
-			col := i % nOverlayCols
+	return img
+}
 
-			if overlayValue != 0 {
-				img.SetGray16(col, row, color.White)
-			}
+// renderColorFrame draws a three-sample frame into a 16-bit RGB image,
+// converting from YCbCr where needed.
+func renderColorFrame(frame *decodedFrame, photometricInterpretation string, bitsAllocated uint16) (*image.RGBA64, error) {
+	if frame.SamplesPerPixel != 3 {
+		return nil, fmt.Errorf("Frames with %d samples per pixel are not supported", frame.SamplesPerPixel)
+	}
+
+	isYBR := !frame.ColorConverted && strings.HasPrefix(photometricInterpretation, "YBR_FULL")
+	if isYBR && bitsAllocated != 8 {
+		return nil, fmt.Errorf("%s is only supported for 8-bit samples", photometricInterpretation)
+	}
+
+	// Scale the samples to 16 bits
+	scale := uint32(1)
+	if bitsAllocated == 8 || frame.ColorConverted {
+		scale = 0x101
+	}
+
+	img := image.NewRGBA64(image.Rect(0, 0, frame.Cols, frame.Rows))
+	for j := 0; j*3+2 < len(frame.Samples); j++ {
+		r, g, b := uint32(frame.Samples[j*3]), uint32(frame.Samples[j*3+1]), uint32(frame.Samples[j*3+2])
+
+		if isYBR {
+			r8, g8, b8 := color.YCbCrToRGB(uint8(r), uint8(g), uint8(b))
+			r, g, b = uint32(r8), uint32(g8), uint32(b8)
 		}
+
+		img.SetRGBA64(j%frame.Cols, j/frame.Cols, color.RGBA64{
+			R: uint16(r * scale),
+			G: uint16(g * scale),
+			B: uint16(b * scale),
+			A: math.MaxUint16,
+		})
 	}
 
-	return img, err
+	return img, nil
 }
 
 // See 'Grayscale Image Display' under
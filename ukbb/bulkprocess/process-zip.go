@@ -80,3 +80,70 @@ func CardiacMRIZipIterator(zipPath string, processOne func(DicomOutput) error) (
 
 	return nil
 }
+
+// DicomTagOutput holds the values extracted by DicomTagZipIterator for one
+// DICOM. Values is ordered like the selectors that were requested.
+type DicomTagOutput struct {
+	SampleID string
+	ZipFile  string
+	FieldID  string
+	Instance string
+	Index    string
+	Filename string
+	Values   [][]interface{}
+}
+
+// DicomTagZipIterator is like CardiacMRIZipIterator, but extracts the tags
+// described by selectors instead of the fixed DicomMeta fields.
+func DicomTagZipIterator(zipPath string, selectors []DicomTagSelector, processOne func(DicomTagOutput) error) (err error) {
+	metadata, err := zipPathToMetadata(zipPath)
+	if err != nil {
+		return err
+	}
+
+	if metadata.SampleID == "" {
+		return nil
+	}
+
+	zipName := path.Base(zipPath)
+
+	rc, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	for _, v := range rc.File {
+		// Looking only at the dicoms
+		if strings.HasPrefix(v.Name, "manifest") {
+			continue
+		}
+
+		dcm := DicomTagOutput{}
+		dcm.SampleID = metadata.SampleID
+		dcm.ZipFile = zipName
+		dcm.FieldID = metadata.FieldID
+		dcm.Instance = metadata.Instance
+		dcm.Index = metadata.Index
+		dcm.Filename = v.Name
+
+		unzippedFile, err := v.Open()
+		if err != nil {
+			return err
+		}
+		values, err := ExtractDicomTags(unzippedFile, selectors)
+		unzippedFile.Close()
+		if err != nil {
+			log.Println("Ignoring error in", zipName, v.Name, "and continuing:", err.Error())
+			continue
+		}
+
+		dcm.Values = values
+
+		if err := processOne(dcm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,3 +1,75 @@
 package ukbb
 
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
 const CodingFileRootURL = `https://biobank.ctsu.ox.ac.uk/crystal/codown.cgi`
+
+// ReadCodings reads every coding from a local path or URL to UK Biobank's
+// Codings.csv, as a map from coding to value to meaning.
+func ReadCodings(path string) (map[string]map[string]string, error) {
+	r, err := openPathOrURL(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	reader := csv.NewReader(NewCSVQuoteFixReadCloser(r))
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Reading the header of %s: %v", path, err)
+	}
+	cols := make(map[string]int)
+	for i, v := range header {
+		cols[v] = i
+	}
+	for _, required := range []string{"Coding", "Value", "Meaning"} {
+		if _, exists := cols[required]; !exists {
+			return nil, fmt.Errorf("%s has no %s column", path, required)
+		}
+	}
+
+	out := make(map[string]map[string]string)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Reading %s: %v", path, err)
+		}
+
+		coding := row[cols["Coding"]]
+		if out[coding] == nil {
+			out[coding] = make(map[string]string)
+		}
+		out[coding][row[cols["Value"]]] = row[cols["Meaning"]]
+	}
+
+	return out, nil
+}
+
+// openPathOrURL opens a local file, or fetches a URL.
+func openPathOrURL(path string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(path, "http") {
+		return os.Open(path)
+	}
+
+	resp, err := http.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Fetching %s: %s", path, resp.Status)
+	}
+
+	return resp.Body, nil
+}
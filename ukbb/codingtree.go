@@ -0,0 +1,205 @@
+package ukbb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Codings of the hierarchical UK Biobank code trees
+const (
+	CodingICD10 = "19"
+	CodingICD9  = "87"
+	CodingOPCS4 = "240"
+)
+
+// HierarchicalCodingByFieldID maps the FieldIDs whose values are ICD-10, ICD-9,
+// or OPCS-4 codes to the coding that holds their tree.
+var HierarchicalCodingByFieldID = map[int]string{
+	40001: CodingICD10, // Underlying cause of death
+	40002: CodingICD10, // Contributory causes of death
+	40006: CodingICD10, // Cancer registry
+	41202: CodingICD10, // HESIN primary
+	41204: CodingICD10, // HESIN secondary
+	41270: CodingICD10, // HESIN any
+	40013: CodingICD9,  // Cancer registry
+	41203: CodingICD9,  // HESIN primary
+	41205: CodingICD9,  // HESIN secondary
+	41271: CodingICD9,  // HESIN any
+	41200: CodingOPCS4, // HESIN primary
+	41210: CodingOPCS4, // HESIN secondary
+	41272: CodingOPCS4, // HESIN any
+}
+
+// CodingTree holds the codes of one hierarchical coding (e.g., ICD-10). In UK
+// Biobank's Codings.csv, codes are stored without their dot (I21.0 is I210),
+// so a code's descendants are exactly the codes that it prefixes. Grouping
+// nodes have values like "Block I20-I25" or "Chapter IX".
+type CodingTree struct {
+	Coding string
+
+	// Sorted codes, excluding grouping nodes
+	codes []string
+
+	// Grouping node values
+	groups map[string]struct{}
+}
+
+// NewCodingTree builds a tree from the values (and their meanings) of one
+// coding.
+func NewCodingTree(coding string, values map[string]string) *CodingTree {
+	t := &CodingTree{Coding: coding, groups: make(map[string]struct{})}
+	for value := range values {
+		if strings.Contains(value, " ") {
+			t.groups[value] = struct{}{}
+			continue
+		}
+		t.codes = append(t.codes, value)
+	}
+	sort.Strings(t.codes)
+
+	return t
+}
+
+// IsCodePattern reports whether a tabfile value must be expanded against a
+// coding tree: a prefix wildcard (I21*), a range (I20-I25), or a grouping node
+// (Block I20-I25).
+func IsCodePattern(value string) bool {
+	value = strings.TrimSpace(value)
+
+	return strings.HasSuffix(value, "*") ||
+		strings.HasPrefix(value, "Block ") ||
+		strings.HasPrefix(value, "Chapter ") ||
+		(strings.Index(value, "-") > 0 && !strings.HasSuffix(value, "-"))
+}
+
+// Expand returns every code in the tree matched by pattern, in sorted order.
+// Dots in the pattern are ignored. "I21*" matches I21 and all of its
+// descendants. "I20-I25" matches every code from I20 through I25, including
+// descendants of I25. "Block I20-I25" is treated like "I20-I25".
+func (t *CodingTree) Expand(pattern string) ([]string, error) {
+	pattern = strings.TrimSpace(pattern)
+	stripped := strings.ReplaceAll(pattern, ".", "")
+
+	var match func(code string) bool
+	switch {
+	case strings.HasPrefix(pattern, "Chapter "):
+		return nil, fmt.Errorf("Chapter %q cannot be expanded because Codings.csv does not record which blocks belong to a chapter; use the chapter's range of codes instead", pattern)
+	case strings.HasSuffix(stripped, "*"):
+		prefix := strings.TrimSuffix(stripped, "*")
+		if prefix == "" || strings.Contains(prefix, "*") {
+			return nil, fmt.Errorf("Wildcard %q must be a code followed by a single *", pattern)
+		}
+		match = func(code string) bool { return strings.HasPrefix(code, prefix) }
+	default:
+		if strings.HasPrefix(pattern, "Block ") {
+			if _, exists := t.groups[pattern]; !exists {
+				return nil, fmt.Errorf("%q is not a block in coding %s", pattern, t.Coding)
+			}
+			stripped = strings.TrimPrefix(stripped, "Block ")
+		}
+
+		bounds := strings.Split(stripped, "-")
+		if len(bounds) != 2 || bounds[0] == "" || bounds[1] == "" {
+			return nil, fmt.Errorf("Range %q must have the form FIRST-LAST", pattern)
+		}
+		lo, hi := bounds[0], bounds[1]
+		if lo > hi {
+			return nil, fmt.Errorf("Range %q ends before it begins", pattern)
+		}
+		match = func(code string) bool {
+			return truncate(code, len(lo)) >= lo && truncate(code, len(hi)) <= hi
+		}
+	}
+
+	var out []string
+	for _, code := range t.codes {
+		if match(code) {
+			out = append(out, code)
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%q matched no codes in coding %s", pattern, t.Coding)
+	}
+
+	return out, nil
+}
+
+// ExpandCodes replaces the wildcards (I21*), ranges (I20-I25), and blocks
+// (Block I20-I25) among the values of a FieldID with every code that they match
+// in the coding trees, returning the deduplicated codes in sorted order. If no
+// value is such a pattern, values is returned as-is, in its original order, and
+// the trees are not needed.
+func ExpandCodes(fieldID int, values []string, trees map[string]*CodingTree) ([]string, error) {
+	hasPattern := false
+	for _, value := range values {
+		if IsCodePattern(value) {
+			hasPattern = true
+			break
+		}
+	}
+	if !hasPattern {
+		return values, nil
+	}
+
+	coding, hierarchical := HierarchicalCodingByFieldID[fieldID]
+
+	seen := make(map[string]struct{})
+	out := make([]string, 0, len(values))
+	for _, value := range values {
+		codes := []string{value}
+
+		if IsCodePattern(value) {
+			if !hierarchical && strings.HasSuffix(strings.TrimSpace(value), "*") {
+				return nil, fmt.Errorf("FieldID %d: wildcard %q is only supported for ICD-10, ICD-9, and OPCS-4 fields", fieldID, value)
+			} else if hierarchical {
+				tree, exists := trees[coding]
+				if !exists {
+					return nil, fmt.Errorf("FieldID %d: expanding %q requires the UK Biobank coding trees; please provide --coding", fieldID, value)
+				}
+
+				var err error
+				codes, err = tree.Expand(value)
+				if err != nil {
+					return nil, fmt.Errorf("FieldID %d: %v", fieldID, err)
+				}
+			}
+		}
+
+		for _, code := range codes {
+			if _, exists := seen[code]; exists {
+				continue
+			}
+			seen[code] = struct{}{}
+			out = append(out, code)
+		}
+	}
+
+	sort.Strings(out)
+
+	return out, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// ReadCodingTrees reads the hierarchical codings (ICD-10, ICD-9, and OPCS-4)
+// from a local path or URL to UK Biobank's Codings.csv.
+func ReadCodingTrees(path string) (map[string]*CodingTree, error) {
+	codings, err := ReadCodings(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]*CodingTree)
+	for _, coding := range []string{CodingICD10, CodingICD9, CodingOPCS4} {
+		out[coding] = NewCodingTree(coding, codings[coding])
+	}
+
+	return out, nil
+}
@@ -0,0 +1,128 @@
+package ukbb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testCodings = `Coding,Value,Meaning
+19,Chapter IX,Chapter IX Diseases of the circulatory system
+19,Block I20-I25,I20-I25 Ischaemic heart diseases
+19,I20,I20 Angina pectoris
+19,I200,I20.0 Unstable angina
+19,I21,I21 Acute myocardial infarction
+19,I210,I21.0 Acute transmural myocardial infarction of anterior wall
+19,I214,I21.4 Acute subendocardial myocardial infarction
+19,I219,"I21.9 Acute myocardial infarction, unspecified"
+19,I25,I25 Chronic ischaemic heart disease
+19,I251,I25.1 Atherosclerotic heart disease
+19,I26,I26 Pulmonary embolism
+19,I260,I26.0 Pulmonary embolism with mention of acute cor pulmonale
+240,K40,K40 Saphenous vein graft replacement of coronary artery
+240,K401,K40.1 Saphenous vein graft replacement of one coronary artery
+6,1065,hypertension
+`
+
+func TestCodingTreeExpand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Codings.csv")
+	if err := os.WriteFile(path, []byte(testCodings), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	trees, err := ReadCodingTrees(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, exists := trees["6"]; exists {
+		t.Errorf("Expected only hierarchical codings to be loaded")
+	}
+
+	cases := map[string]string{
+		"I21*":          "I21 I210 I214 I219",
+		"I21.*":         "I21 I210 I214 I219",
+		"I20-I21":       "I20 I200 I21 I210 I214 I219",
+		"I20-I25":       "I20 I200 I21 I210 I214 I219 I25 I251",
+		"I21.0-I21.4":   "I210 I214",
+		"Block I20-I25": "I20 I200 I21 I210 I214 I219 I25 I251",
+	}
+	for pattern, expected := range cases {
+		if !IsCodePattern(pattern) {
+			t.Errorf("Expected %q to be recognized as a pattern", pattern)
+		}
+
+		got, err := trees[CodingICD10].Expand(pattern)
+		if err != nil {
+			t.Errorf("%s: %v", pattern, err)
+			continue
+		}
+		if strings.Join(got, " ") != expected {
+			t.Errorf("%s: expected %s, got %s", pattern, expected, strings.Join(got, " "))
+		}
+	}
+
+	if got, err := trees[CodingOPCS4].Expand("K40*"); err != nil || strings.Join(got, " ") != "K40 K401" {
+		t.Errorf("Expected K40 K401, got %v (%v)", got, err)
+	}
+
+	for _, value := range []string{"I21", "I21.0", "-1", "1065"} {
+		if IsCodePattern(value) {
+			t.Errorf("Expected %q not to be recognized as a pattern", value)
+		}
+	}
+
+	for _, pattern := range []string{"I25-I20", "Block I30-I52", "Chapter IX", "Q99*"} {
+		if _, err := trees[CodingICD10].Expand(pattern); err == nil {
+			t.Errorf("Expected an error expanding %q", pattern)
+		}
+	}
+}
+
+func TestExpandCodes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Codings.csv")
+	if err := os.WriteFile(path, []byte(testCodings), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	trees, err := ReadCodingTrees(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		FieldID  int
+		Values   []string
+		Trees    map[string]*CodingTree
+		Expected string
+	}{
+		// Without patterns, values keep their order and no trees are needed
+		{41202, []string{"I26", "I21", "I21"}, nil, "I26 I21 I21"},
+		{20002, []string{"1065", "1074"}, nil, "1065 1074"},
+
+		// With a pattern, codes are expanded, deduplicated, and sorted
+		{41202, []string{"I26", "I21*", "I210"}, trees, "I21 I210 I214 I219 I26"},
+		{41200, []string{"K40*"}, trees, "K40 K401"},
+
+		// Ranges of non-hierarchical fields are left as-is
+		{20002, []string{"1074", "1065-1070"}, trees, "1065-1070 1074"},
+	}
+	for _, c := range cases {
+		got, err := ExpandCodes(c.FieldID, c.Values, c.Trees)
+		if err != nil {
+			t.Errorf("%d %v: %v", c.FieldID, c.Values, err)
+			continue
+		}
+		if strings.Join(got, " ") != c.Expected {
+			t.Errorf("%d %v: expected %s, got %s", c.FieldID, c.Values, c.Expected, strings.Join(got, " "))
+		}
+	}
+
+	if _, err := ExpandCodes(41202, []string{"I21*"}, nil); err == nil {
+		t.Errorf("Expected an error expanding a pattern without the coding trees")
+	}
+	if _, err := ExpandCodes(20002, []string{"10*"}, trees); err == nil {
+		t.Errorf("Expected an error for a wildcard in a non-hierarchical field")
+	}
+}
@@ -2,6 +2,11 @@ package ukbb
 
 import (
 	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
 )
 
 type DictionaryField struct {
@@ -23,3 +28,97 @@ type DictionaryField struct {
 	Notes        sql.NullString `db:"Notes"`
 	Link         string         `db:"Link"`
 }
+
+// ReadDictionary reads the UK Biobank's Data_Dictionary_Showcase from a local
+// path or URL, keyed by FieldID. Files ending in .tsv or .txt are
+// tab-delimited (like the output of ukbb2csv/convertdict); others are
+// comma-delimited. The coding may be in a column named Coding or
+// coding_file_id.
+func ReadDictionary(path string) (map[int]DictionaryField, error) {
+	r, err := openPathOrURL(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	reader := csv.NewReader(NewCSVQuoteFixReadCloser(r))
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	if strings.HasSuffix(path, ".tsv") || strings.HasSuffix(path, ".txt") {
+		reader.Comma = '\t'
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Reading the header of %s: %v", path, err)
+	}
+	cols := make(map[string]int)
+	for i, v := range header {
+		cols[v] = i
+	}
+	if _, exists := cols["coding_file_id"]; !exists {
+		if i, exists := cols["Coding"]; exists {
+			cols["coding_file_id"] = i
+		}
+	}
+	for _, required := range []string{"FieldID", "Field", "coding_file_id"} {
+		if _, exists := cols[required]; !exists {
+			return nil, fmt.Errorf("%s has no %s column", path, required)
+		}
+	}
+
+	out := make(map[int]DictionaryField)
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Reading %s: %v", path, err)
+		}
+		if len(row) != len(header) {
+			return nil, fmt.Errorf("%s line %d had %d columns, expected %d", path, line, len(row), len(header))
+		}
+
+		get := func(name string) string {
+			if i, exists := cols[name]; exists {
+				return row[i]
+			}
+			return ""
+		}
+		// Counts are informational, so malformed ones are left as zero
+		getInt := func(name string) int {
+			v, _ := strconv.Atoi(get(name))
+			return v
+		}
+		getNull := func(name string) sql.NullString {
+			v := get(name)
+			return sql.NullString{String: v, Valid: v != ""}
+		}
+
+		field := DictionaryField{
+			Path:         get("Path"),
+			Category:     get("Category"),
+			Field:        get("Field"),
+			Participants: getInt("Participants"),
+			Items:        getInt("Items"),
+			Stability:    get("Stability"),
+			ValueType:    get("ValueType"),
+			Units:        getNull("Units"),
+			ItemType:     get("ItemType"),
+			Strata:       get("Strata"),
+			Sexed:        get("Sexed"),
+			Instances:    getInt("Instances"),
+			Array:        getInt("Array"),
+			Coding:       getNull("coding_file_id"),
+			Notes:        getNull("Notes"),
+			Link:         get("Link"),
+		}
+		if field.FieldID, err = strconv.Atoi(get("FieldID")); err != nil {
+			return nil, fmt.Errorf("%s line %d: FieldID: %v", path, line, err)
+		}
+
+		out[field.FieldID] = field
+	}
+
+	return out, nil
+}
@@ -0,0 +1,112 @@
+package localtable
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+func TestReadDatedFieldsParquet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "materialized_hesin_dates.parquet")
+
+	fw, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := `{"Tag": "name=parquet_go_root, repetitiontype=REQUIRED", "Fields": [
+		{"Tag": "name=sample_id, type=INT64, repetitiontype=REQUIRED"},
+		{"Tag": "name=FieldID, type=INT64, repetitiontype=REQUIRED"},
+		{"Tag": "name=value, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"},
+		{"Tag": "name=first_date, type=INT32, convertedtype=DATE, repetitiontype=OPTIONAL"}
+	]}`
+	pw, err := writer.NewJSONWriter(schema, fw, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Span more than one batch, and leave every 7th date NULL.
+	n := parquetBatchSize + 5
+	for i := 0; i < n; i++ {
+		date := "null"
+		if i%7 != 0 {
+			date = fmt.Sprint(14000 + i)
+		}
+		if err := pw.Write(fmt.Sprintf(`{"sample_id": %d, "FieldID": %d, "value": "I%d", "first_date": %s}`, i, 41202+i%2, i, date)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	fw.Close()
+
+	found, err := Find(filepath.Dir(path), "materialized_hesin_dates")
+	if err != nil || found != path {
+		t.Fatalf("Expected to find %s, got %s (%v)", path, found, err)
+	}
+
+	var rows []DatedField
+	err = ReadDatedFields(path, func(fieldID int64, value string) bool {
+		return fieldID == 41202
+	}, func(d DatedField) error {
+		rows = append(rows, d)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != (n+1)/2 {
+		t.Fatalf("Expected %d rows, got %d", (n+1)/2, len(rows))
+	}
+
+	for _, d := range rows {
+		i := int(d.SampleID)
+		if d.Value != fmt.Sprintf("I%d", i) {
+			t.Fatalf("Row %d: expected value I%d, got %s", i, i, d.Value)
+		}
+		if d.FirstDate.Valid != (i%7 != 0) {
+			t.Fatalf("Row %d: unexpected NULL state of first_date %v", i, d.FirstDate)
+		}
+	}
+
+	// Sample 2 was first seen 14002 days after 1970-01-01
+	if got := rows[1].FirstDate.String(); got != "2008-05-03" {
+		t.Errorf("Expected 2008-05-03, got %s", got)
+	}
+}
+
+func TestReadCensorDelimited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "censor.tsv")
+	contents := "sample_id\tbirthdate\tenroll_date\tenroll_age\tdeath_date\tmissing_fields\n" +
+		"1\t1950-07-02\t2008-01-17\t57.541\t\t\n" +
+		"2\t1945-03-15\t2009-06-01 00:00:00\t64.213\t2015-02-02\tdeath_date\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := ReadCensor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+
+	if rows[0].DeathDate.Valid || rows[0].MissingFields.Valid || rows[0].PhenotypeCensorDate.Valid {
+		t.Errorf("Expected NULL values, got %+v", rows[0])
+	}
+
+	if rows[1].EnrollDate.String() != "2009-06-01" || rows[1].EnrollAge.Float64 != 64.213 || rows[1].DeathDate.String() != "2015-02-02" || rows[1].MissingFields.StringVal != "death_date" {
+		t.Errorf("Unexpected values %+v", rows[1])
+	}
+
+	if got := SafeParseDate("2008-1-17"); got.Valid {
+		t.Errorf("Expected a NULL date, got %v", got)
+	}
+}
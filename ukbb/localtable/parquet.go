@@ -0,0 +1,160 @@
+package localtable
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/carbocation/pfx"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// parquetBatchSize is the number of rows read from each column at a time.
+const parquetBatchSize = 10000
+
+type parquetColumn struct {
+	path   string
+	isDate bool
+
+	// Values of the current batch
+	values []interface{}
+}
+
+// parquetTable reads a flat Parquet file (such as one exported from BigQuery)
+// column by column, in batches, and serves it back row by row.
+type parquetTable struct {
+	pf      source.ParquetFile
+	pr      *reader.ParquetReader
+	header  []string
+	columns []parquetColumn
+
+	remaining int64
+	position  int
+	row       []string
+}
+
+func openParquet(path string) (*parquetTable, error) {
+	pf, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, pfx.Err(err)
+	}
+
+	pr, err := reader.NewParquetColumnReader(pf, 1)
+	if err != nil {
+		pf.Close()
+		return nil, fmt.Errorf("Reading %s: %v", path, err)
+	}
+
+	t := &parquetTable{
+		pf:        pf,
+		pr:        pr,
+		remaining: pr.GetNumRows(),
+	}
+
+	sh := pr.SchemaHandler
+	for _, inPath := range sh.ValueColumns {
+		idx := sh.MapIndex[inPath]
+		element := sh.SchemaElements[idx]
+
+		maxRL, err := sh.MaxRepetitionLevel(common.StrToPath(inPath))
+		if err != nil {
+			pf.Close()
+			return nil, pfx.Err(err)
+		}
+		if maxRL > 0 {
+			pf.Close()
+			return nil, fmt.Errorf("%s: column %s is repeated; only flat tables are supported", path, sh.Infos[idx].ExName)
+		}
+
+		isDate := element.IsSetConvertedType() && element.GetConvertedType() == parquet.ConvertedType_DATE
+		if element.IsSetLogicalType() && element.GetLogicalType().IsSetDATE() {
+			isDate = true
+		}
+
+		t.header = append(t.header, sh.Infos[idx].ExName)
+		t.columns = append(t.columns, parquetColumn{path: inPath, isDate: isDate})
+	}
+	t.row = make([]string, len(t.columns))
+
+	return t, nil
+}
+
+func (t *parquetTable) Header() []string {
+	return t.header
+}
+
+func (t *parquetTable) Read() ([]string, error) {
+	if len(t.columns) == 0 {
+		return nil, io.EOF
+	}
+
+	if t.position >= len(t.columns[0].values) {
+		if t.remaining <= 0 {
+			return nil, io.EOF
+		}
+
+		n := int64(parquetBatchSize)
+		if n > t.remaining {
+			n = t.remaining
+		}
+
+		for i := range t.columns {
+			values, _, _, err := t.pr.ReadColumnByPath(t.columns[i].path, n)
+			if err != nil {
+				return nil, pfx.Err(err)
+			}
+			if int64(len(values)) != n {
+				return nil, fmt.Errorf("Column %s: expected %d values, got %d", t.header[i], n, len(values))
+			}
+			t.columns[i].values = values
+		}
+		t.remaining -= n
+		t.position = 0
+	}
+
+	for i, col := range t.columns {
+		t.row[i] = formatParquetValue(col.values[t.position], col.isDate)
+	}
+	t.position++
+
+	return t.row, nil
+}
+
+func (t *parquetTable) Close() error {
+	t.pr.ReadStop()
+	return t.pf.Close()
+}
+
+// formatParquetValue renders a value the way BigQuery renders it in a CSV
+// export.
+func formatParquetValue(v interface{}, isDate bool) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case bool:
+		if x {
+			return "true"
+		}
+		return "false"
+	case int32:
+		if isDate {
+			return time.Unix(int64(x)*86400, 0).UTC().Format("2006-01-02")
+		}
+		return strconv.FormatInt(int64(x), 10)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	}
+
+	return fmt.Sprint(v)
+}
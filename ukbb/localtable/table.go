@@ -0,0 +1,144 @@
+// Package localtable reads local copies of the UK Biobank BigQuery tables
+// (e.g., censor, phenotype, and the materialized_* tables) that were exported
+// as delimited text or Parquet files, so that tools which normally query
+// BigQuery can run where BigQuery is not available.
+package localtable
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carbocation/genomisc"
+	"github.com/carbocation/pfx"
+)
+
+// Extensions are checked in this order by Find.
+var Extensions = []string{".parquet", ".tsv", ".tsv.gz", ".csv", ".csv.gz", ".txt", ".txt.gz"}
+
+const bufferSize = 4096 * 8
+
+// Table iterates over the rows of a local table. Every value is returned as a
+// string; NULL values are empty strings.
+type Table interface {
+	// Header lists the column names.
+	Header() []string
+
+	// Read returns the next row, or io.EOF when the table is exhausted. The
+	// returned slice may be reused by the next call.
+	Read() ([]string, error)
+
+	Close() error
+}
+
+// Find returns the path of the table called name within dir, trying each of
+// Extensions in turn.
+func Find(dir, name string) (string, error) {
+	dir = genomisc.ExpandHome(dir)
+	for _, ext := range Extensions {
+		candidate := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("No table named %s (with any of the extensions %s) was found in %s", name, strings.Join(Extensions, ", "), dir)
+}
+
+// Open opens a Parquet file (by its .parquet extension) or a delimited text
+// file. Text files ending in .csv (optionally compressed) are comma-delimited;
+// all others are tab-delimited. Compression is detected automatically.
+func Open(path string) (Table, error) {
+	path = genomisc.ExpandHome(path)
+
+	if strings.HasSuffix(path, ".parquet") {
+		return openParquet(path)
+	}
+
+	return openDelimited(path)
+}
+
+// Column returns the index of the named column in t, or -1.
+func Column(t Table, name string) int {
+	for i, v := range t.Header() {
+		if v == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Columns returns the index of each named column in t. Missing columns are an
+// error.
+func Columns(t Table, names ...string) ([]int, error) {
+	out := make([]int, 0, len(names))
+	for _, name := range names {
+		idx := Column(t, name)
+		if idx < 0 {
+			return nil, fmt.Errorf("Column %s not found; columns are %s", name, strings.Join(t.Header(), ", "))
+		}
+		out = append(out, idx)
+	}
+
+	return out, nil
+}
+
+type delimitedTable struct {
+	f      *os.File
+	rc     io.ReadCloser
+	r      *csv.Reader
+	header []string
+}
+
+func openDelimited(path string) (*delimitedTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, pfx.Err(err)
+	}
+
+	rc, err := genomisc.MaybeDecompressReadCloserFromFile(f)
+	if err != nil {
+		f.Close()
+		return nil, pfx.Err(err)
+	}
+
+	r := csv.NewReader(bufio.NewReaderSize(rc, bufferSize))
+	r.Comma = '\t'
+	trimmed := strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ".bz2")
+	if strings.HasSuffix(trimmed, ".csv") {
+		r.Comma = ','
+	}
+	r.LazyQuotes = true
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Reading the header of %s: %v", path, err)
+	}
+
+	return &delimitedTable{
+		f:      f,
+		rc:     rc,
+		r:      r,
+		header: append([]string(nil), header...),
+	}, nil
+}
+
+func (t *delimitedTable) Header() []string {
+	return t.header
+}
+
+func (t *delimitedTable) Read() ([]string, error) {
+	return t.r.Read()
+}
+
+func (t *delimitedTable) Close() error {
+	t.rc.Close()
+	return t.f.Close()
+}
@@ -0,0 +1,292 @@
+package localtable
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+)
+
+// Censor is one row of the censor table produced by ukbb2csv/censor.
+type Censor struct {
+	SampleID               int64
+	BirthDate              bigquery.NullDate
+	EnrollDate             bigquery.NullDate
+	EnrollAge              bigquery.NullFloat64
+	EnrollAgeDays          bigquery.NullFloat64
+	DeathDate              bigquery.NullDate
+	DeathAge               bigquery.NullFloat64
+	DeathAgeDays           bigquery.NullFloat64
+	DeathCensorDate        bigquery.NullDate
+	DeathCensorAge         bigquery.NullFloat64
+	DeathCensorAgeDays     bigquery.NullFloat64
+	PhenotypeCensorDate    bigquery.NullDate
+	PhenotypeCensorAge     bigquery.NullFloat64
+	PhenotypeCensorAgeDays bigquery.NullFloat64
+	LostToFollowupDate     bigquery.NullDate
+	ComputedDate           bigquery.NullDate
+	MissingFields          bigquery.NullString
+}
+
+// DatedField is one row of a materialized_*_dates table: the first date on
+// which a participant had a given value for a FieldID.
+type DatedField struct {
+	SampleID  int64
+	FieldID   int64
+	Value     string
+	FirstDate bigquery.NullDate
+
+	// Only populated if the table has a dsource column
+	DSource string
+}
+
+// Phenotype is one row of the phenotype table produced by
+// ukbb2csv/convertpheno.
+type Phenotype struct {
+	SampleID int64
+	FieldID  int64
+	Instance int64
+	ArrayIdx int64
+	Value    string
+}
+
+// ReadCensor reads every row of a censor table. Only sample_id is required;
+// other missing columns are treated as NULL.
+func ReadCensor(path string) ([]Censor, error) {
+	t, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+
+	sampleCol, err := Columns(t, "sample_id")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// Each destination is a field of c, which is reset for every row
+	var c Censor
+	type dateColumn struct {
+		name string
+		col  int
+		dst  *bigquery.NullDate
+	}
+	type floatColumn struct {
+		name string
+		col  int
+		dst  *bigquery.NullFloat64
+	}
+	dates := []dateColumn{
+		{"birthdate", -1, &c.BirthDate},
+		{"enroll_date", -1, &c.EnrollDate},
+		{"death_date", -1, &c.DeathDate},
+		{"death_censor_date", -1, &c.DeathCensorDate},
+		{"phenotype_censor_date", -1, &c.PhenotypeCensorDate},
+		{"lost_to_followup_date", -1, &c.LostToFollowupDate},
+		{"computed_date", -1, &c.ComputedDate},
+	}
+	floats := []floatColumn{
+		{"enroll_age", -1, &c.EnrollAge},
+		{"enroll_age_days", -1, &c.EnrollAgeDays},
+		{"death_age", -1, &c.DeathAge},
+		{"death_age_days", -1, &c.DeathAgeDays},
+		{"death_censor_age", -1, &c.DeathCensorAge},
+		{"death_censor_age_days", -1, &c.DeathCensorAgeDays},
+		{"phenotype_censor_age", -1, &c.PhenotypeCensorAge},
+		{"phenotype_censor_age_days", -1, &c.PhenotypeCensorAgeDays},
+	}
+	for i := range dates {
+		dates[i].col = Column(t, dates[i].name)
+	}
+	for i := range floats {
+		floats[i].col = Column(t, floats[i].name)
+	}
+	missingCol := Column(t, "missing_fields")
+
+	var out []Censor
+	for line := 2; ; line++ {
+		row, err := t.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		c = Censor{}
+		if c.SampleID, err = strconv.ParseInt(row[sampleCol[0]], 10, 64); err != nil {
+			return nil, fmt.Errorf("%s line %d: sample_id: %v", path, line, err)
+		}
+
+		for _, v := range dates {
+			if v.col < 0 {
+				continue
+			}
+			if *v.dst, err = parseNullDate(row[v.col]); err != nil {
+				return nil, fmt.Errorf("%s line %d: %s: %v", path, line, v.name, err)
+			}
+		}
+
+		for _, v := range floats {
+			if v.col < 0 {
+				continue
+			}
+			if *v.dst, err = parseNullFloat64(row[v.col]); err != nil {
+				return nil, fmt.Errorf("%s line %d: %s: %v", path, line, v.name, err)
+			}
+		}
+
+		if missingCol >= 0 && row[missingCol] != "" {
+			c.MissingFields = bigquery.NullString{StringVal: row[missingCol], Valid: true}
+		}
+
+		out = append(out, c)
+	}
+
+	return out, nil
+}
+
+// ReadDatedFields calls fn for each row of a materialized dates table for
+// which keep returns true.
+func ReadDatedFields(path string, keep func(fieldID int64, value string) bool, fn func(DatedField) error) error {
+	t, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	cols, err := Columns(t, "sample_id", "FieldID", "value", "first_date")
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	dsourceCol := Column(t, "dsource")
+
+	for line := 2; ; line++ {
+		row, err := t.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		fieldID, err := strconv.ParseInt(row[cols[1]], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s line %d: FieldID: %v", path, line, err)
+		}
+
+		if !keep(fieldID, row[cols[2]]) {
+			continue
+		}
+
+		d := DatedField{FieldID: fieldID, Value: row[cols[2]]}
+		if d.SampleID, err = strconv.ParseInt(row[cols[0]], 10, 64); err != nil {
+			return fmt.Errorf("%s line %d: sample_id: %v", path, line, err)
+		}
+		if d.FirstDate, err = parseNullDate(row[cols[3]]); err != nil {
+			return fmt.Errorf("%s line %d: first_date: %v", path, line, err)
+		}
+		if dsourceCol >= 0 {
+			d.DSource = row[dsourceCol]
+		}
+
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadPhenotype calls fn for each row of a phenotype table whose FieldID is
+// accepted by keep.
+func ReadPhenotype(path string, keep func(fieldID int64) bool, fn func(Phenotype) error) error {
+	t, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	cols, err := Columns(t, "sample_id", "FieldID", "instance", "array_idx", "value")
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	for line := 2; ; line++ {
+		row, err := t.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		fieldID, err := strconv.ParseInt(row[cols[1]], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s line %d: FieldID: %v", path, line, err)
+		}
+
+		if !keep(fieldID) {
+			continue
+		}
+
+		p := Phenotype{FieldID: fieldID, Value: row[cols[4]]}
+		if p.SampleID, err = strconv.ParseInt(row[cols[0]], 10, 64); err != nil {
+			return fmt.Errorf("%s line %d: sample_id: %v", path, line, err)
+		}
+		if p.Instance, err = strconv.ParseInt(row[cols[2]], 10, 64); err != nil {
+			return fmt.Errorf("%s line %d: instance: %v", path, line, err)
+		}
+		if p.ArrayIdx, err = strconv.ParseInt(row[cols[3]], 10, 64); err != nil {
+			return fmt.Errorf("%s line %d: array_idx: %v", path, line, err)
+		}
+
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SafeParseDate mimics BigQuery's SAFE.PARSE_DATE("%E4Y-%m-%d", value): values
+// that are not a valid date yield NULL rather than an error.
+func SafeParseDate(value string) bigquery.NullDate {
+	d, err := civil.ParseDate(value)
+	if err != nil || len(value) != 10 {
+		return bigquery.NullDate{}
+	}
+
+	return bigquery.NullDate{Date: d, Valid: true}
+}
+
+func parseNullDate(value string) (bigquery.NullDate, error) {
+	if value == "" {
+		return bigquery.NullDate{}, nil
+	}
+
+	// Tolerate DATETIME or TIMESTAMP renderings of a date
+	if len(value) > 10 && strings.ContainsAny(value[10:11], " T") {
+		value = value[:10]
+	}
+
+	d, err := civil.ParseDate(value)
+	if err != nil {
+		return bigquery.NullDate{}, err
+	}
+
+	return bigquery.NullDate{Date: d, Valid: true}, nil
+}
+
+func parseNullFloat64(value string) (bigquery.NullFloat64, error) {
+	if value == "" {
+		return bigquery.NullFloat64{}, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return bigquery.NullFloat64{}, err
+	}
+
+	return bigquery.NullFloat64{Float64: f, Valid: true}, nil
+}
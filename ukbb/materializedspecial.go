@@ -1,6 +1,4 @@
-package main
-
-import "log"
+package ukbb
 
 // SQL to generate this:
 //
@@ -2289,12 +2287,7 @@ func init() {
 	}
 
 	// Add all of these as known FieldIDs
-	l0 := len(MaterializedSpecial)
 	for _, v := range knownSpecialFields {
 		MaterializedSpecial[v] = struct{}{}
 	}
-	l1 := len(MaterializedSpecial)
-
-	log.Printf("Observed %d special fields with known dates (%d total, including the %d statically coded fields)", l1-l0, l1, l0)
-
 }
@@ -1,4 +1,4 @@
-package main
+package ukbb
 
 import (
 	"fmt"
@@ -92,19 +92,25 @@ var (
 	}
 )
 
+// IsHesin reports whether the FieldID holds ICD or OPCS codes that are
+// materialized with their dates from the HESIN, death, or cancer tables.
 func IsHesin(fieldID int) bool {
 	_, exists := MaterializedHesin[fieldID]
 
 	return exists
 }
 
+// IsSpecial reports whether the FieldID is materialized with a specially-known
+// date.
 func IsSpecial(fieldID int) bool {
 	_, exists := MaterializedSpecial[fieldID]
 
 	return exists
 }
 
-func describeDateFields(verbose bool) {
+// DescribeDateFields prints to stderr the FieldIDs for which accurate dates
+// are set.
+func DescribeDateFields(verbose bool) {
 	fmt.Fprintf(os.Stderr, "\nNote that the tool tries to set accurate dates only for the following FieldIDs:\n")
 	fmt.Fprintf(os.Stderr, "\tICD-like FieldIDs:\n")
 
@@ -0,0 +1,203 @@
+package convertpheno
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/carbocation/genomisc"
+	"github.com/carbocation/genomisc/ukbb2csv/sqlstore"
+	"github.com/carbocation/pfx"
+)
+
+// RunAllBackendSQL loads the phenotype files directly into the phenotype table
+// of a SQLite or Postgres database, rather than printing them. As with the
+// BigQuery method, FieldIDs that are already in the table are skipped, and
+// coding_file_ids come from the dictionary table, which should be loaded
+// first. Each file is loaded in its own transaction.
+func RunAllBackendSQL(phenoPaths []string, db *sqlstore.DB) error {
+	knownFieldIDs, err := db.KnownFieldIDs()
+	if err != nil {
+		return err
+	}
+	log.Println("Found", len(knownFieldIDs), "FieldIDs already in the database, will ignore those FieldIDs in the new data, if present.")
+
+	codingFileIDs, err := db.CodingFileIDs()
+	if err != nil {
+		return err
+	}
+	if len(codingFileIDs) == 0 {
+		log.Println("Warning: no FieldIDs in the dictionary table have a coding_file_id. Did you load the dictionary table first?")
+	}
+
+	// Process each file. KnownFieldIDs is modified within.
+	for _, phenoPath := range phenoPaths {
+		if err := processOnePathSQL(db, codingFileIDs, knownFieldIDs, phenoPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func processOnePathSQL(db *sqlstore.DB, codingFileIDs map[int64]int64, knownFieldIDs map[string]struct{}, phenoPath string) error {
+	headers := make(map[int]Column)
+
+	phenoPath = genomisc.ExpandHome(phenoPath)
+	log.Printf("Loading %s\n", phenoPath)
+
+	f, err := os.Open(phenoPath)
+	if err != nil {
+		return pfx.Err(err)
+	}
+	defer f.Close()
+
+	delim := genomisc.DetermineDelimiter(f)
+
+	f.Seek(0, 0)
+
+	fileCSV := csv.NewReader(bufio.NewReaderSize(f, BufferSize))
+	fileCSV.Comma = delim
+
+	log.Printf("Determined phenotype delimiter to be \"%s\"\n", string(delim))
+
+	headRow, err := fileCSV.Read()
+	if err != nil {
+		return fmt.Errorf("Header parsing error: %v", err)
+	}
+	if err := parseHeadersSQL(codingFileIDs, headRow, headers); err != nil {
+		return err
+	}
+
+	// Track which fields are new
+	newFieldIDs := make(map[string]struct{})
+	for col := range headRow {
+		if col == 0 {
+			continue
+		}
+
+		if _, exists := knownFieldIDs[headers[col].FieldID]; !exists {
+			newFieldIDs[headers[col].FieldID] = struct{}{}
+		}
+	}
+
+	summarizeImport(knownFieldIDs, newFieldIDs)
+
+	if len(newFieldIDs) < 1 {
+		log.Println("No new fields were found in file", phenoPath, " -- skipping")
+		return nil
+	}
+
+	loader, err := db.NewLoader(sqlstore.Phenotype)
+	if err != nil {
+		return err
+	}
+
+	var addedPhenoCount int
+	for sample := 1; ; sample++ {
+		if sample%10000 == 0 {
+			log.Println("Saw sample", sample)
+		}
+
+		row, err := fileCSV.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			loader.Rollback()
+			return pfx.Err(err)
+		}
+
+		sampleID := row[0]
+		if IsWithdrawnSample(sampleID) {
+			log.Println("Skipping withdrawn sample", sampleID)
+			continue
+		}
+
+		for col := range row {
+			if col == 0 {
+				// Skip the sample ID
+				continue
+			}
+
+			column := headers[col]
+			if _, exists := knownFieldIDs[column.FieldID]; exists {
+				// Skip any FieldIDs that were inserted on previous runs, as
+				// the BigQuery method does.
+				continue
+			}
+
+			if strings.TrimSpace(row[col]) == "" {
+				// Skip blanks
+				continue
+			}
+
+			codingFileID := ""
+			if column.CodingFileID.Valid {
+				codingFileID = strconv.FormatInt(column.CodingFileID.Int64, 10)
+			}
+
+			if err := loader.Insert(sampleID, column.FieldID, column.Instance, column.ArrayIDX, row[col], codingFileID); err != nil {
+				loader.Rollback()
+				return fmt.Errorf("%s sample %s: %v", phenoPath, sampleID, err)
+			}
+
+			addedPhenoCount++
+		}
+	}
+
+	if err := loader.Commit(); err != nil {
+		return err
+	}
+
+	log.Println("Populated the table with", addedPhenoCount, "new records from", len(newFieldIDs), "previously unseen FieldIDs the phenotype file")
+
+	// Make the tool aware of new fields so it won't import them from the next
+	// file.
+	for key := range newFieldIDs {
+		knownFieldIDs[key] = struct{}{}
+	}
+
+	return nil
+}
+
+func parseHeadersSQL(codingFileIDs map[int64]int64, row []string, headers map[int]Column) error {
+	for col, header := range row {
+		dash := strings.Index(header, "-")
+		dot := strings.Index(header, ".")
+
+		// The very first field is just "eid"
+		if dash == -1 || dot == -1 {
+			if col > 0 {
+				return fmt.Errorf("All columns after the 0th are expected to be of format XX-XX.XX, but column %d is %s", col, header)
+			}
+			headers[col] = Column{
+				FieldID: header,
+			}
+			continue
+		}
+
+		column := Column{
+			FieldID:  header[:dash],
+			Instance: header[dash+1 : dot],
+			ArrayIDX: header[dot+1:],
+		}
+
+		// Annotate the column with the CodingFileID that applies to its
+		// FieldID.
+		if fieldID, err := strconv.ParseInt(column.FieldID, 10, 64); err == nil {
+			if codingFileID, exists := codingFileIDs[fieldID]; exists {
+				column.CodingFileID = bigquery.NullInt64{Int64: codingFileID, Valid: true}
+			}
+		}
+
+		headers[col] = column
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package convertpheno
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carbocation/genomisc/ukbb2csv/sqlstore"
+)
+
+func TestRunAllBackendSQL(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := sqlstore.Open(sqlstore.DriverSQLite, filepath.Join(dir, "ukbb.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dictionary := "FieldID\tField\tcoding_file_id\n" +
+		"20002\tNon-cancer illness code\t6\n" +
+		"30780\tLDL direct\t\n"
+	if _, err := db.LoadTSV(sqlstore.Dictionary, strings.NewReader(dictionary), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"new.csv": "eid,20002-0.0,20002-0.1,30780-0.0\n" +
+			"1,1065,1066,3.1\n" +
+			"2,,,2.5\n" +
+			"3,1065,,4.0\n",
+		// 20002 was seen in the newer file, so only 50 is loaded from here
+		"old.csv": "eid,20002-0.0,50-0.0\n" +
+			"1,1074,170\n" +
+			"2,1065,180\n",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	withdrawnSampleIDs = map[string]struct{}{}
+	SetWithdrawnSamples([]string{"3"})
+	defer func() { withdrawnSampleIDs = map[string]struct{}{} }()
+
+	paths := []string{filepath.Join(dir, "new.csv"), filepath.Join(dir, "old.csv")}
+	if err := RunAllBackendSQL(paths, db); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second run finds every FieldID already loaded
+	if err := RunAllBackendSQL(paths, db); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	if err := db.Select(&got, `SELECT "sample_id" || ' ' || "FieldID" || ' ' || "array_idx" || ' ' || "value" || ' ' || COALESCE("coding_file_id", 'NULL')
+FROM "phenotype"
+ORDER BY "sample_id", "FieldID", "array_idx"`); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"1 50 0 170 NULL",
+		"1 20002 0 1065 6",
+		"1 20002 1 1066 6",
+		"1 30780 0 3.1 NULL",
+		"2 50 0 180 NULL",
+		"2 30780 0 2.5 NULL",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected phenotype rows:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
@@ -54,3 +54,13 @@ func SetWithdrawnSamples(withdrawnSampleIDList []string) {
 		withdrawnSampleIDs[withdrawnSampleID] = struct{}{}
 	}
 }
+
+// WithdrawnSamples lists the sample IDs in the withdrawn sample lookup table.
+func WithdrawnSamples() []string {
+	out := make([]string, 0, len(withdrawnSampleIDs))
+	for sampleID := range withdrawnSampleIDs {
+		out = append(out, sampleID)
+	}
+
+	return out
+}
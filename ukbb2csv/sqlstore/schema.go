@@ -0,0 +1,208 @@
+// Package sqlstore keeps the tables produced by the ukbb2csv tools (phenotype,
+// dictionary, coding, sample, and censor) in a local SQLite or Postgres
+// database, as an alternative to loading them into BigQuery.
+package sqlstore
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Column types are chosen to be valid in both SQLite and Postgres.
+const (
+	TypeInt   = "BIGINT"
+	TypeFloat = "DOUBLE PRECISION"
+	TypeText  = "TEXT"
+	TypeDate  = "DATE"
+)
+
+type Column struct {
+	Name string
+	Type string
+}
+
+// Table describes one table and how it is loaded. Identifiers are quoted, so
+// that mixed-case names like FieldID keep their case in Postgres.
+type Table struct {
+	Name    string
+	Columns []Column
+	Indexes [][]string
+
+	// If set, rows whose Key was already present in the table before a load
+	// began are skipped, so that loads are incremental. If empty, each load
+	// replaces the table's contents.
+	Key string
+
+	// If set, rows of withdrawn samples are skipped
+	SampleColumn string
+
+	// Values that are treated as NULL in addition to the empty string
+	NullValues []string
+}
+
+var (
+	Phenotype = Table{
+		Name: "phenotype",
+		Columns: []Column{
+			{"sample_id", TypeInt},
+			{"FieldID", TypeInt},
+			{"instance", TypeInt},
+			{"array_idx", TypeInt},
+			{"value", TypeText},
+			{"coding_file_id", TypeInt},
+		},
+		Indexes: [][]string{
+			{"FieldID", "value"},
+			{"sample_id", "FieldID"},
+		},
+		Key:          "FieldID",
+		SampleColumn: "sample_id",
+		// convertpheno prints NULL coding_file_ids as NULL
+		NullValues: []string{"NULL"},
+	}
+
+	Dictionary = Table{
+		Name: "dictionary",
+		Columns: []Column{
+			{"Path", TypeText},
+			{"Category", TypeText},
+			{"FieldID", TypeInt},
+			{"Field", TypeText},
+			{"Participants", TypeInt},
+			{"Items", TypeInt},
+			{"Stability", TypeText},
+			{"ValueType", TypeText},
+			{"Units", TypeText},
+			{"ItemType", TypeText},
+			{"Strata", TypeText},
+			{"Sexed", TypeText},
+			{"Instances", TypeInt},
+			{"Array", TypeInt},
+			{"coding_file_id", TypeInt},
+			{"Notes", TypeText},
+			{"Link", TypeText},
+		},
+		Indexes: [][]string{
+			{"FieldID"},
+			{"coding_file_id"},
+		},
+		Key: "FieldID",
+	}
+
+	Coding = Table{
+		Name: "coding",
+		Columns: []Column{
+			{"coding_file_id", TypeInt},
+			{"coding", TypeText},
+			{"meaning", TypeText},
+		},
+		Indexes: [][]string{
+			{"coding_file_id", "coding"},
+		},
+		Key: "coding_file_id",
+	}
+
+	Sample = Table{
+		Name: "sample",
+		Columns: []Column{
+			{"sample_id", TypeInt},
+			{"file_row", TypeInt},
+		},
+		Indexes: [][]string{
+			{"sample_id"},
+		},
+		Key:          "sample_id",
+		SampleColumn: "sample_id",
+	}
+
+	// Censor is recomputed with new censoring dates, so each load replaces it.
+	Censor = Table{
+		Name: "censor",
+		Columns: []Column{
+			{"sample_id", TypeInt},
+			{"sex", TypeText},
+			{"ethnicity", TypeText},
+			{"birthdate", TypeDate},
+			{"enroll_date", TypeDate},
+			{"enroll_age", TypeFloat},
+			{"enroll_age_days", TypeFloat},
+			{"death_date", TypeDate},
+			{"death_age", TypeFloat},
+			{"death_age_days", TypeFloat},
+			{"death_censor_date", TypeDate},
+			{"death_censor_age", TypeFloat},
+			{"death_censor_age_days", TypeFloat},
+			{"phenotype_censor_date", TypeDate},
+			{"phenotype_censor_age", TypeFloat},
+			{"phenotype_censor_age_days", TypeFloat},
+			{"lost_to_followup_date", TypeDate},
+			{"lost_to_followup_age", TypeFloat},
+			{"lost_to_followup_age_days", TypeFloat},
+			{"computed_date", TypeDate},
+			{"missing_fields", TypeText},
+			{"has_gp_data", TypeInt},
+		},
+		Indexes: [][]string{
+			{"sample_id"},
+		},
+		SampleColumn: "sample_id",
+		// The censor tool prints NULL values as NA
+		NullValues: []string{"NA"},
+	}
+
+	Tables = []Table{Phenotype, Dictionary, Coding, Sample, Censor}
+)
+
+// LookupTable returns the table with the given name.
+func LookupTable(name string) (Table, error) {
+	names := make([]string, 0, len(Tables))
+	for _, t := range Tables {
+		if t.Name == name {
+			return t, nil
+		}
+		names = append(names, t.Name)
+	}
+
+	return Table{}, fmt.Errorf("Unknown table %q. Options include: %s", name, strings.Join(names, ", "))
+}
+
+// Column returns the named column, if it exists.
+func (t Table) Column(name string) (Column, bool) {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+
+	return Column{}, false
+}
+
+// CreateStatements returns the statements that create the table and its
+// indexes if they do not exist.
+func (t Table) CreateStatements() []string {
+	cols := make([]string, 0, len(t.Columns))
+	for _, c := range t.Columns {
+		cols = append(cols, fmt.Sprintf("%s %s", quote(c.Name), c.Type))
+	}
+
+	out := []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)", quote(t.Name), strings.Join(cols, ",\n\t"))}
+	for _, index := range t.Indexes {
+		name := strings.ToLower(t.Name + "_" + strings.Join(index, "_"))
+		out = append(out, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", quote(name), quote(t.Name), quoteAll(index)))
+	}
+
+	return out
+}
+
+func quote(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func quoteAll(identifiers []string) string {
+	out := make([]string, 0, len(identifiers))
+	for _, v := range identifiers {
+		out = append(out, quote(v))
+	}
+
+	return strings.Join(out, ", ")
+}
@@ -0,0 +1,88 @@
+package sqlstore
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTSV(t *testing.T) {
+	db, err := Open(DriverSQLite, filepath.Join(t.TempDir(), "ukbb.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	withdrawn := func(sampleID string) bool { return sampleID == "3" }
+
+	coding := "coding_file_id\tcoding\tmeaning\n" +
+		"6\t1065\thypertension\n" +
+		"6\t1066\theart/cardiac problem\n"
+	if n, err := db.LoadTSV(Coding, strings.NewReader(coding), withdrawn); err != nil || n != 2 {
+		t.Fatalf("Expected 2 coding rows, got %d (%v)", n, err)
+	}
+
+	// Codings that are already known are skipped, new ones are added
+	coding = "coding_file_id\tcoding\tmeaning\n" +
+		"6\t1065\thypertension\n" +
+		"7\t1\tYes\n"
+	if n, err := db.LoadTSV(Coding, strings.NewReader(coding), withdrawn); err != nil || n != 1 {
+		t.Fatalf("Expected 1 new coding row, got %d (%v)", n, err)
+	}
+
+	censor := "sample_id\tsex\tbirthdate\tenroll_age\tdeath_date\thas_gp_data\n" +
+		"1\tFemale\t1950-01-01\t58.5\tNA\t1\n" +
+		"2\tMale\t1945-06-01\t62.1\t2015-03-01\t0\n" +
+		"3\tMale\t1960-01-01\t49.0\tNA\t0\n"
+	if n, err := db.LoadTSV(Censor, strings.NewReader(censor), withdrawn); err != nil || n != 2 {
+		t.Fatalf("Expected 2 censor rows, got %d (%v)", n, err)
+	}
+
+	// Censor has no key, so reloading it replaces it
+	censor = "sample_id\tsex\tbirthdate\tenroll_age\tdeath_date\thas_gp_data\n" +
+		"1\tFemale\t1950-01-01\t58.5\t2020-01-01\t1\n"
+	if n, err := db.LoadTSV(Censor, strings.NewReader(censor), withdrawn); err != nil || n != 1 {
+		t.Fatalf("Expected 1 censor row, got %d (%v)", n, err)
+	}
+
+	var deaths []string
+	if err := db.Select(&deaths, `SELECT "death_date" FROM "censor" WHERE "death_date" IS NOT NULL`); err != nil {
+		t.Fatal(err)
+	}
+	if len(deaths) != 1 || !strings.HasPrefix(deaths[0], "2020-01-01") {
+		t.Errorf("Expected the reloaded death date, got %v", deaths)
+	}
+
+	// A failed load leaves the table as it was
+	bad := "sample_id\tenroll_age\n" +
+		"4\tx\n"
+	if _, err := db.LoadTSV(Censor, strings.NewReader(bad), nil); err == nil {
+		t.Error("Expected an error for a non-numeric enroll_age")
+	}
+	if _, err := db.LoadTSV(Censor, strings.NewReader("sample_id\tunknown\n"), nil); err == nil {
+		t.Error("Expected an error for an unknown column")
+	}
+
+	sample := "sample_id\tfile_row\n" +
+		"1\t0\n" +
+		"2\t1\n"
+	if _, err := db.LoadTSV(Sample, strings.NewReader(sample), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := db.DeleteSamples([]string{"1", "-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected to delete 2 rows of sample 1, deleted %d", deleted)
+	}
+
+	var count int
+	if err := db.Get(&count, `SELECT COUNT(*) FROM "censor"`); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("Expected an empty censor table, found %d rows", count)
+	}
+}